@@ -8,19 +8,41 @@ import (
 	"net"
 	"os"
 	"os/signal"
+	"path/filepath"
+	"slices"
+	"strings"
+	"sync/atomic"
 	"syscall"
 	"time"
+	_ "time/tzdata"
 
 	"github.com/peterbourgon/ff/v3"
 	"github.com/peterbourgon/ff/v3/ffcli"
 	nfs "github.com/willscott/go-nfs"
 	nfshelper "github.com/willscott/go-nfs/helpers"
+	"golang.org/x/sync/errgroup"
+	"golang.org/x/text/language"
 
+	"github.com/axiomhq/axiom-fs/internal/atrest"
+	"github.com/axiomhq/axiom-fs/internal/automount"
 	"github.com/axiomhq/axiom-fs/internal/axiomclient"
 	"github.com/axiomhq/axiom-fs/internal/cache"
+	"github.com/axiomhq/axiom-fs/internal/compiler"
 	"github.com/axiomhq/axiom-fs/internal/config"
+	"github.com/axiomhq/axiom-fs/internal/events"
+	"github.com/axiomhq/axiom-fs/internal/export"
+	"github.com/axiomhq/axiom-fs/internal/finder"
+	"github.com/axiomhq/axiom-fs/internal/latency"
+	"github.com/axiomhq/axiom-fs/internal/listen"
 	"github.com/axiomhq/axiom-fs/internal/nfsfs"
+	"github.com/axiomhq/axiom-fs/internal/policy"
+	"github.com/axiomhq/axiom-fs/internal/postprocess"
 	"github.com/axiomhq/axiom-fs/internal/query"
+	"github.com/axiomhq/axiom-fs/internal/quota"
+	"github.com/axiomhq/axiom-fs/internal/redact"
+	"github.com/axiomhq/axiom-fs/internal/selfcheck"
+	"github.com/axiomhq/axiom-fs/internal/systemd"
+	"github.com/axiomhq/axiom-fs/internal/urlbuilder"
 	"github.com/axiomhq/axiom-fs/internal/vfs"
 )
 
@@ -28,31 +50,128 @@ func main() {
 	cfg := config.Default()
 	fsFlagSet := flag.NewFlagSet("axiom-fs", flag.ExitOnError)
 
-	fsFlagSet.StringVar(&cfg.ListenAddr, "listen", cfg.ListenAddr, "NFS server listen address")
+	fsFlagSet.StringVar(&cfg.ListenAddr, "listen", cfg.ListenAddr, "NFS listen addresses, comma-separated host:port or unix:///path/to/sock")
 	fsFlagSet.StringVar(&cfg.DefaultRange, "default-range", cfg.DefaultRange, "default range for queries (ago duration)")
+	fsFlagSet.StringVar(&cfg.Timezone, "timezone", cfg.Timezone, "IANA time zone range/today, range/yesterday and range/last count days in, e.g. Europe/Berlin (default: UTC)")
 	fsFlagSet.IntVar(&cfg.DefaultLimit, "default-limit", cfg.DefaultLimit, "default row limit when not specified")
+	fsFlagSet.StringVar(&cfg.DefaultFormat, "default-format", cfg.DefaultFormat, "format of bare result files, without an extension (ndjson, csv, json, tsv, xlsx, vl.json, svg or md)")
 	fsFlagSet.IntVar(&cfg.MaxLimit, "max-limit", cfg.MaxLimit, "maximum row limit allowed")
 	fsFlagSet.DurationVar(&cfg.MaxRange, "max-range", cfg.MaxRange, "maximum allowed range duration")
+	fsFlagSet.BoolVar(&cfg.AllowForce, "allow-force", cfg.AllowForce, "allow q/force/ paths to exceed -max-range and -max-limit up to -force-max-range and -force-max-limit")
+	fsFlagSet.DurationVar(&cfg.ForceMaxRange, "force-max-range", cfg.ForceMaxRange, "hard cap on the range of q/force/ paths (0 = none)")
+	fsFlagSet.IntVar(&cfg.ForceMaxLimit, "force-max-limit", cfg.ForceMaxLimit, "hard cap on the row limit of q/force/ paths (0 = none)")
 	fsFlagSet.DurationVar(&cfg.CacheTTL, "cache-ttl", cfg.CacheTTL, "query cache TTL")
-	fsFlagSet.IntVar(&cfg.MaxCacheEntries, "cache-max-entries", cfg.MaxCacheEntries, "max cache entries")
-	fsFlagSet.IntVar(&cfg.MaxCacheBytes, "cache-max-bytes", cfg.MaxCacheBytes, "max cache size in bytes")
+	fsFlagSet.IntVar(&cfg.MaxCacheEntries, "cache-max-entries", cfg.MaxCacheEntries, "max cached results below the large threshold")
+	fsFlagSet.IntVar(&cfg.MaxCacheBytes, "cache-max-bytes", cfg.MaxCacheBytes, "max size in bytes of cached results below the large threshold")
 	fsFlagSet.IntVar(&cfg.MaxInMemoryBytes, "max-in-memory-bytes", cfg.MaxInMemoryBytes, "max in-memory result size before spilling to disk")
+	fsFlagSet.IntVar(&cfg.MaxResultBytes, "max-result-bytes", cfg.MaxResultBytes, "stop encoding results past this size and mark them truncated (0 = no cap)")
 	fsFlagSet.StringVar(&cfg.CacheDir, "cache-dir", cfg.CacheDir, "directory for persistent query cache")
 	fsFlagSet.StringVar(&cfg.QueryDir, "query-dir", cfg.QueryDir, "directory for persisted raw queries")
+	fsFlagSet.StringVar(&cfg.SnippetDir, "snippet-dir", cfg.SnippetDir, "directory for APL snippets used by #include")
 	fsFlagSet.StringVar(&cfg.TempDir, "temp-dir", cfg.TempDir, "temporary directory for large result files")
 	fsFlagSet.IntVar(&cfg.SampleLimit, "sample-limit", cfg.SampleLimit, "sample size for sample.ndjson")
+	fsFlagSet.BoolVar(&cfg.SampleAutoRange, "sample-auto-range", cfg.SampleAutoRange, "widen sample.ndjson range up to max-range when the default range is empty")
+	fsFlagSet.Float64Var(&cfg.ExploreSample, "explore-sample", cfg.ExploreSample, "percent of rows sample.ndjson draws from at random (0 = first rows)")
 	fsFlagSet.DurationVar(&cfg.MetadataTTL, "metadata-ttl", cfg.MetadataTTL, "dataset and field cache TTL")
+	fsFlagSet.DurationVar(&cfg.MetadataMaxStale, "metadata-max-stale", cfg.MetadataMaxStale, "serve the last dataset listing this long past -metadata-ttl while refreshing it in the background (0 = always wait for Axiom)")
+	fsFlagSet.BoolVar(&cfg.MetadataWarmStart, "metadata-warm-start", cfg.MetadataWarmStart, "on startup, serve the dataset and field lists saved in the cache dir however old and revalidate them in the background")
+	fsFlagSet.DurationVar(&cfg.DeletedDatasetGrace, "deleted-dataset-grace", cfg.DeletedDatasetGrace, "keep serving cached results of a deleted dataset this long before dropping its caches (0 = drop at once)")
+	fsFlagSet.DurationVar(&cfg.MetadataPollInterval, "metadata-poll-interval", cfg.MetadataPollInterval, "poll the dataset list this often and invalidate caches when datasets are created or deleted (0 = off)")
+	fsFlagSet.IntVar(&cfg.FieldShardThreshold, "field-shard-threshold", cfg.FieldShardThreshold, "shard fields/ into one directory per first character above this many fields (0 = never)")
+	fsFlagSet.BoolVar(&cfg.IncludeHiddenFields, "include-hidden-fields", cfg.IncludeHiddenFields, "list hidden fields in fields/, schema.csv, schema.jsonschema, _meta fields.json and field search")
+	fsFlagSet.BoolVar(&cfg.Revalidate, "revalidate", cfg.Revalidate, "probe cached results with a count and latest _sysTime query before serving them")
+	fsFlagSet.IntVar(&cfg.FollowCursorPages, "follow-cursor-pages", cfg.FollowCursorPages, "continue partial results for up to this many more requests (0 = off)")
+	fsFlagSet.IntVar(&cfg.ReadProbeRows, "read-probe-rows", cfg.ReadProbeRows, "serve the first reads of q/ results from a query taking this many rows (0 = off)")
+	fsFlagSet.IntVar(&cfg.FlattenMaxDepth, "flatten-max-depth", cfg.FlattenMaxDepth, "levels of nested objects flatten/dot spreads over columns (0 = all)")
+	fsFlagSet.DurationVar(&cfg.CacheWarmInterval, "cache-warm-interval", cfg.CacheWarmInterval, "refresh results read repeatedly before they expire, checking this often (0 = off)")
+	fsFlagSet.DurationVar(&cfg.CacheMaxStale, "cache-max-stale", cfg.CacheMaxStale, "serve expired results of -cache-stale-paths up to this long while refreshing them in the background (0 = off)")
+	fsFlagSet.StringVar(&cfg.CacheStalePaths, "cache-stale-paths", cfg.CacheStalePaths, "comma-separated path classes -cache-max-stale applies to: q, presets, fields, sample, queries, dashboards")
+	fsFlagSet.DurationVar(&cfg.PresetSizeInterval, "preset-size-interval", cfg.PresetSizeInterval, "after mount, run one aggregating preset per interval to give preset files a size (0 = off, presets show 0 bytes until read)")
+	fsFlagSet.IntVar(&cfg.QueryConcurrency, "query-concurrency", cfg.QueryConcurrency, "max queries run against Axiom at once, queued fairly per NFS client beyond that (0 = no cap)")
+	fsFlagSet.StringVar(&cfg.ClientWeightsFile, "client-weights-file", cfg.ClientWeightsFile, "JSON object of NFS client address to its share of -query-concurrency (default weight 1)")
+	fsFlagSet.IntVar(&cfg.BatchConcurrency, "batch-concurrency", cfg.BatchConcurrency, "max queries of one /_batch run executed at once")
+	fsFlagSet.IntVar(&cfg.CacheWarmConcurrency, "cache-warm-concurrency", cfg.CacheWarmConcurrency, "max concurrent cache-warming queries")
+	fsFlagSet.IntVar(&cfg.CacheMetaEntries, "cache-meta-entries", cfg.CacheMetaEntries, "max cached metadata entries (result meta, counts, estimates)")
+	fsFlagSet.IntVar(&cfg.CacheMetaBytes, "cache-meta-bytes", cfg.CacheMetaBytes, "max cached metadata size in bytes")
+	fsFlagSet.IntVar(&cfg.CacheLargeThreshold, "cache-large-threshold", cfg.CacheLargeThreshold, "results of at least this many bytes are cached in the large segment (0 = off)")
+	fsFlagSet.IntVar(&cfg.CacheLargeEntries, "cache-large-entries", cfg.CacheLargeEntries, "max cached large results")
+	fsFlagSet.IntVar(&cfg.CacheLargeBytes, "cache-large-bytes", cfg.CacheLargeBytes, "max cached large results size in bytes")
+	fsFlagSet.StringVar(&cfg.SortLocale, "sort-locale", cfg.SortLocale, "BCP 47 locale for natural sorts, e.g. de or sv (default: root collation)")
+	fsFlagSet.DurationVar(&cfg.CacheSweepInterval, "cache-sweep-interval", cfg.CacheSweepInterval, "how often to trim the disk cache to its limits (0 = startup only)")
+	fsFlagSet.StringVar(&cfg.StatMode, "stat-mode", cfg.StatMode, "how Stat sizes unread result files: exact (run the query) or estimate (count probe and sample)")
+	fsFlagSet.Int64Var(&cfg.DynamicFileSize, "dynamic-file-size", cfg.DynamicFileSize, "size Stat reports for generated files not read yet, for clients that need non-zero sizes (0 = generate them on Stat for their exact size)")
+	fsFlagSet.DurationVar(&cfg.DrainTimeout, "drain-timeout", cfg.DrainTimeout, "on shutdown, how long to wait for in-flight queries and open files")
+	fsFlagSet.DurationVar(&cfg.TailInterval, "tail-interval", cfg.TailInterval, "how often tail.ndjson polls Axiom for new events")
+	fsFlagSet.DurationVar(&cfg.TailHeartbeat, "tail-heartbeat", cfg.TailHeartbeat, "append a heartbeat line to a quiet tail.ndjson this often (0 = off)")
+	fsFlagSet.BoolVar(&cfg.InlineMarkers, "inline-markers", cfg.InlineMarkers, "write truncation markers and tail heartbeats into data files; false leaves them to stats.json, manifest.json and tail.status.json")
+	fsFlagSet.IntVar(&cfg.TailMaxBytes, "tail-max-bytes", cfg.TailMaxBytes, "bytes of each tail.ndjson stream kept for readers")
+	fsFlagSet.Int64Var(&cfg.QuotaRowsPerHour, "quota-rows-per-hour", cfg.QuotaRowsPerHour, "max rows fetched from Axiom per principal per hour (0 = unlimited)")
+	fsFlagSet.Int64Var(&cfg.QuotaBytesPerHour, "quota-bytes-per-hour", cfg.QuotaBytesPerHour, "max result bytes fetched from Axiom per principal per hour (0 = unlimited)")
+	fsFlagSet.StringVar(&cfg.AliasesFile, "aliases-file", cfg.AliasesFile, "JSON file mapping alias names to lists of datasets")
+	fsFlagSet.StringVar(&cfg.DatasetDefaultsFile, "dataset-defaults-file", cfg.DatasetDefaultsFile, "JSON file of per-dataset default_range, default_limit, sample_limit and default_format overrides")
+	fsFlagSet.StringVar(&cfg.PolicyFile, "policy-file", cfg.PolicyFile, "JSON policy declaring writable subtrees and visible datasets")
+	fsFlagSet.StringVar(&cfg.PostProcessFile, "postprocess-file", cfg.PostProcessFile, "JSON list of rules piping results read under a path through a command or registered processor")
+	fsFlagSet.StringVar(&cfg.TenantFile, "tenant-config", cfg.TenantFile, "JSON list of tenant views, each with a path, token and policy; only they are served")
+	fsFlagSet.Int64Var(&cfg.MaxReadThroughput, "max-read-throughput", cfg.MaxReadThroughput, "max bytes per second read from each file handle (0 = unlimited)")
+	fsFlagSet.DurationVar(&cfg.SlowOpThreshold, "slow-op-threshold", cfg.SlowOpThreshold, "log file operations and queries slower than this to /_status/slow.ndjson (0 = off)")
+	fsFlagSet.BoolVar(&cfg.InflightJournal, "inflight-journal", cfg.InflightJournal, "journal running result queries in the cache dir so ones cut off by a restart are reported")
+	fsFlagSet.BoolVar(&cfg.SuppressFinderJunk, "suppress-finder-junk", cfg.SuppressFinderJunk, "answer lookups of macOS Finder and Spotlight metadata (.DS_Store, ._*, .Spotlight-V100, ...) with ENOENT without walking the tree")
+	fsFlagSet.BoolVar(&cfg.FakeDSStore, "fake-ds-store", cfg.FakeDSStore, "let Finder write .DS_Store files, held in memory until exit (needs -suppress-finder-junk)")
+	fsFlagSet.BoolVar(&cfg.SystemdNotify, "systemd-notify", cfg.SystemdNotify, "tell systemd the service is ready once datasets are listed (Type=notify units)")
+	fsFlagSet.StringVar(&cfg.MountPoint, "mount-point", cfg.MountPoint, "where clients mount the export, for the absolute paths in duckdb.sql")
+	fsFlagSet.IntVar(&cfg.UID, "uid", cfg.UID, "user ID owning the mount's files (-1 = the user axiom-fs runs as)")
+	fsFlagSet.IntVar(&cfg.GID, "gid", cfg.GID, "group ID owning the mount's files (-1 = the group axiom-fs runs as)")
+	fsFlagSet.UintVar(&cfg.FileMode, "file-mode", cfg.FileMode, "permission bits of files, e.g. 0640; write bits only apply to writable files (0 = 0444, or 0644 if writable)")
+	fsFlagSet.UintVar(&cfg.DirMode, "dir-mode", cfg.DirMode, "permission bits of directories, e.g. 0550 (0 = 0555)")
+	fsFlagSet.BoolVar(&cfg.EnableAdminFiles, "enable-admin-files", cfg.EnableAdminFiles, "expose destructive control files under /_admin, each confirmed with a token from its .confirm file")
+	fsFlagSet.TextVar(&cfg.SnapshotFrom, "snapshot-from", cfg.SnapshotFrom, "pin the mount to events from this RFC 3339 time (with -snapshot-to): read-only, cached forever")
+	fsFlagSet.TextVar(&cfg.SnapshotTo, "snapshot-to", cfg.SnapshotTo, "end of the pinned snapshot range (RFC 3339)")
 	fsFlagSet.StringVar(&cfg.AxiomURL, "axiom-url", "", "Axiom API base URL (overrides env)")
 	fsFlagSet.StringVar(&cfg.AxiomToken, "axiom-token", "", "Axiom token (overrides env)")
 	fsFlagSet.StringVar(&cfg.AxiomOrgID, "axiom-org", "", "Axiom org ID (overrides env)")
+	fsFlagSet.StringVar(&cfg.TokenSource, "token-source", cfg.TokenSource, "read the token from file:<path>, exec:<command>, ssm:<parameter> or secretsmanager:<id> instead of flag/env")
+	fsFlagSet.StringVar(&cfg.AppURL, "app-url", cfg.AppURL, "Axiom web UI base URL for open.url/link.txt (default: derived from the API URL)")
+	fsFlagSet.IntVar(&cfg.MaxIdleConnsPerHost, "max-idle-conns-per-host", cfg.MaxIdleConnsPerHost, "keep-alive connections kept open to the Axiom API")
+	fsFlagSet.BoolVar(&cfg.DisableCompression, "disable-compression", cfg.DisableCompression, "do not request zstd/gzip compressed query responses from Axiom")
+	fsFlagSet.StringVar(&cfg.EncryptionKey, "encryption-key", cfg.EncryptionKey, "32-byte key, hex or base64, encrypting the disk cache and spill files with AES-GCM (prefer AXIOM_FS_ENCRYPTION_KEY)")
+	fsFlagSet.StringVar(&cfg.EncryptionKeyFile, "encryption-key-file", cfg.EncryptionKeyFile, "file holding the -encryption-key")
+	fsFlagSet.BoolVar(&cfg.DisableEncryption, "disable-encryption", cfg.DisableEncryption, "write the disk cache and spill files in plaintext even with a key")
+	fsFlagSet.StringVar(&cfg.ReplayDir, "replay-dir", cfg.ReplayDir, "record Axiom API responses to, or replay them from, this directory")
+	fsFlagSet.StringVar(&cfg.ReplayMode, "replay-mode", cfg.ReplayMode, "with -replay-dir: record (call Axiom and save responses) or replay (offline)")
+	fsFlagSet.StringVar(&cfg.CAFile, "ca-file", cfg.CAFile, "PEM CA bundle to trust in addition to the system roots (self-hosted Axiom, TLS-intercepting proxies)")
+
+	checkCmd := &ffcli.Command{
+		Name:       "check",
+		ShortUsage: "axiom-fs [flags] check",
+		ShortHelp:  "verify token, datasets, a test query and the cache dir, then exit",
+		Exec: func(ctx context.Context, args []string) error {
+			return check(ctx, cfg)
+		},
+	}
+
+	serveFlagSet := flag.NewFlagSet("axiom-fs serve", flag.ExitOnError)
+	serveFlagSet.StringVar(&cfg.AutoMount, "auto-mount", cfg.AutoMount, "mount the export at this directory once listening, and unmount it at shutdown")
+	serveCmd := &ffcli.Command{
+		Name:       "serve",
+		ShortUsage: "axiom-fs [flags] serve [-auto-mount <dir>]",
+		ShortHelp:  "serve the NFS export, the default; -auto-mount also mounts it",
+		FlagSet:    serveFlagSet,
+		Options: []ff.Option{
+			ff.WithEnvVarPrefix("AXIOM_FS"),
+		},
+		Exec: func(ctx context.Context, args []string) error {
+			return run(ctx, cfg)
+		},
+	}
 
 	rootCmd := &ffcli.Command{
 		Name:       "axiom-fs",
-		ShortUsage: "axiom-fs [flags]",
+		ShortUsage: "axiom-fs [flags] [check | serve]",
 		FlagSet:    fsFlagSet,
 		Options: []ff.Option{
 			ff.WithEnvVarPrefix("AXIOM_FS"),
 		},
+		Subcommands: []*ffcli.Command{checkCmd, serveCmd},
 		Exec: func(ctx context.Context, args []string) error {
 			return run(ctx, cfg)
 		},
@@ -67,67 +186,510 @@ func main() {
 	}
 }
 
+// newClient creates the client of cfg. sealer, if enabled, encrypts the
+// rows it spools to --temp-dir.
+func newClient(cfg config.Config, sealer *atrest.Sealer) (*axiomclient.Client, error) {
+	opts := []axiomclient.Option{
+		axiomclient.WithMaxIdleConnsPerHost(cfg.MaxIdleConnsPerHost),
+		axiomclient.WithCAFile(cfg.CAFile),
+		axiomclient.WithCompression(!cfg.DisableCompression),
+		axiomclient.WithSpoolDir(cfg.TempDir),
+		axiomclient.WithSpoolEncryption(sealer),
+	}
+	if cfg.ReplayDir != "" {
+		opts = append(opts, axiomclient.WithReplay(cfg.ReplayDir, cfg.ReplayMode))
+		fmt.Printf("Replay dir %s: %s mode\n", cfg.ReplayDir, cfg.ReplayMode)
+	}
+	tokens, err := axiomclient.ParseTokenSource(cfg.TokenSource, 0)
+	if err != nil {
+		return nil, err
+	}
+	if tokens != nil {
+		opts = append(opts, axiomclient.WithTokenSource(tokens))
+	}
+	return axiomclient.NewWithEnvOverrides(cfg.AxiomURL, cfg.AxiomToken, cfg.AxiomOrgID, opts...)
+}
+
+// check prints a readiness report and fails when any step failed.
+func check(ctx context.Context, cfg config.Config) error {
+	pol, err := policy.Load(cfg.PolicyFile)
+	if err != nil {
+		return err
+	}
+	client, err := newClient(cfg, nil)
+	if err != nil {
+		return fmt.Errorf("%w\n\nSet AXIOM_TOKEN, pass --axiom-token or --token-source, or add a token to ~/.axiom.toml", err)
+	}
+	ctx, cancel := context.WithTimeout(ctx, time.Minute)
+	defer cancel()
+	report := selfcheck.Run(ctx, client, cfg, pol)
+	report.Print(os.Stdout)
+	if !report.OK() {
+		return errors.New("check failed")
+	}
+	return nil
+}
+
 func run(ctx context.Context, cfg config.Config) error {
-	client, err := axiomclient.NewWithEnvOverrides(cfg.AxiomURL, cfg.AxiomToken, cfg.AxiomOrgID)
+	if cfg.StatMode != config.StatModeExact && cfg.StatMode != config.StatModeEstimate {
+		return fmt.Errorf("invalid -stat-mode %q (want exact or estimate)", cfg.StatMode)
+	}
+	if cfg.DynamicFileSize < 0 {
+		return fmt.Errorf("invalid -dynamic-file-size %d (want 0 or more)", cfg.DynamicFileSize)
+	}
+	for _, class := range strings.Split(cfg.CacheStalePaths, ",") {
+		if class = strings.TrimSpace(class); class != "" && !slices.Contains(config.StalePaths, class) {
+			return fmt.Errorf("invalid -cache-stale-paths class %q (want %s)", class, strings.Join(config.StalePaths, ", "))
+		}
+	}
+	if !compiler.IsFormat(cfg.DefaultFormat) {
+		return fmt.Errorf("invalid -default-format %q", cfg.DefaultFormat)
+	}
+	if cfg.ExploreSample < 0 || cfg.ExploreSample > 100 {
+		return fmt.Errorf("invalid -explore-sample %v (want a percent up to 100)", cfg.ExploreSample)
+	}
+	if cfg.AutoMount != "" {
+		dir, err := filepath.Abs(cfg.AutoMount)
+		if err != nil {
+			return fmt.Errorf("invalid -auto-mount %q: %w", cfg.AutoMount, err)
+		}
+		cfg.AutoMount, cfg.MountPoint = dir, dir
+	}
+	if cfg.Timezone != "" {
+		loc, err := time.LoadLocation(cfg.Timezone)
+		if err != nil {
+			return fmt.Errorf("invalid -timezone %q: %w", cfg.Timezone, err)
+		}
+		cfg.Location = loc
+	}
+	if cfg.BatchConcurrency < 1 {
+		return fmt.Errorf("invalid -batch-concurrency %d (want at least 1)", cfg.BatchConcurrency)
+	}
+	sortLocale := language.Und
+	if cfg.SortLocale != "" {
+		tag, err := language.Parse(cfg.SortLocale)
+		if err != nil {
+			return fmt.Errorf("invalid -sort-locale %q: %w", cfg.SortLocale, err)
+		}
+		sortLocale = tag
+	}
+	aliases, err := config.LoadAliases(cfg.AliasesFile)
+	if err != nil {
+		return err
+	}
+	cfg.Aliases = aliases
+	weights, err := config.LoadClientWeights(cfg.ClientWeightsFile)
 	if err != nil {
 		return err
 	}
+	cfg.ClientWeights = weights
+	datasetDefaults, err := config.LoadDatasetDefaults(cfg.DatasetDefaultsFile)
+	if err != nil {
+		return err
+	}
+	cfg.DatasetDefaults = datasetDefaults
+	if err := cfg.PinSnapshot(); err != nil {
+		return err
+	}
+	pol, err := policy.Load(cfg.PolicyFile)
+	if err != nil {
+		return err
+	}
+	if cfg.Snapshot() {
+		pol.WritablePaths = nil
+	} else if cfg.EnableAdminFiles {
+		pol.WritablePaths = append(pol.WritablePaths, vfs.AdminDir)
+	}
 
-	// Preflight check: verify token is valid
-	fmt.Println("Verifying Axiom credentials...")
-	user, err := client.CurrentUser(ctx)
+	tenants, err := policy.LoadTenants(cfg.TenantFile)
 	if err != nil {
-		return fmt.Errorf("credential check failed: %w\n\nEnsure AXIOM_TOKEN is valid, or check ~/.axiom.toml", err)
+		return err
+	}
+	post, err := postprocess.Load(cfg.PostProcessFile)
+	if err != nil {
+		return err
 	}
-	fmt.Printf("Connected as %s (%s)\n", user.Name, user.Email)
 
-	c := cache.New(cfg.CacheTTL, cfg.MaxCacheEntries, cfg.MaxCacheBytes, cfg.CacheDir)
-	exec := query.NewExecutor(client, c, cfg.DefaultRange, cfg.DefaultLimit, cfg.MaxCacheBytes, cfg.MaxInMemoryBytes, cfg.TempDir)
+	var sealer *atrest.Sealer
+	if !cfg.DisableEncryption {
+		if sealer, err = atrest.Load(cfg.EncryptionKey, cfg.EncryptionKeyFile); err != nil {
+			return err
+		}
+		if sealer.Enabled() {
+			fmt.Println("Encrypting the disk cache and spill files")
+		}
+	}
 
-	root := vfs.NewRoot(cfg, client, exec)
-	billyFS := nfsfs.New(root)
+	junk := finder.New(cfg.SuppressFinderJunk, cfg.FakeDSStore)
+	shared := mountOptions{sealer: sealer, sortLocale: sortLocale, post: post, finder: junk}
 
-	// Prefetch datasets in background to warm cache before Finder opens
-	go func() {
-		ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
-		defer cancel()
-		if _, err := root.ReadDir(ctx); err != nil {
-			fmt.Fprintf(os.Stderr, "prefetch warning: %v\n", err)
+	var mounts []*mount
+	var root *vfs.Root
+	if len(tenants) == 0 {
+		client, err := connect(ctx, cfg, "", sealer)
+		if err != nil {
+			return err
 		}
-	}()
+		m, err := newMount(cfg, client, pol, shared)
+		if err != nil {
+			return err
+		}
+		mounts, root = append(mounts, m), m.root
+	} else {
+		views := make([]vfs.Tenant, 0, len(tenants))
+		for _, t := range tenants {
+			tcfg := cfg.Tenant(t.Name())
+			tcfg.AxiomToken = t.AxiomToken()
+			if t.DefaultRange != "" {
+				tcfg.DefaultRange = t.DefaultRange
+			}
+			tpol := &t.Policy
+			if cfg.Snapshot() {
+				tpol.WritablePaths = nil
+			} else if cfg.EnableAdminFiles {
+				tpol.WritablePaths = append(tpol.WritablePaths, vfs.AdminDir)
+			}
+			client, err := connect(ctx, tcfg, t.Path, sealer)
+			if err != nil {
+				return err
+			}
+			m, err := newMount(tcfg, client, tpol, shared)
+			if err != nil {
+				return err
+			}
+			mounts = append(mounts, m)
+			views = append(views, vfs.Tenant{Path: t.Path, Root: m.root})
+		}
+		root = vfs.NewRoot(cfg, nil, nil,
+			vfs.WithLatency(latency.New(cfg.SlowOpThreshold)),
+			vfs.WithFinder(junk),
+			vfs.WithEvents(events.New(0)),
+			vfs.WithTenants(views),
+		)
+	}
+	billyFS := nfsfs.New(root)
 
-	handler := nfshelper.NewNullAuthHandler(billyFS)
+	watchCtx, stopWatch := context.WithCancel(ctx)
+	defer stopWatch()
+	for _, m := range mounts {
+		go m.root.WatchMetadata(watchCtx, m.cfg.MetadataPollInterval)
+		go m.cache.Janitor(watchCtx, cfg.CacheSweepInterval)
+		go m.exec.WarmCache(watchCtx, query.WarmOptions{Interval: cfg.CacheWarmInterval, Concurrency: cfg.CacheWarmConcurrency})
+		go m.root.SizePresets(watchCtx, cfg.PresetSizeInterval)
+	}
+
+	handler := nfsfs.NewHandler(billyFS)
 	cacheHandler := nfshelper.NewCachingHandler(handler, 1024)
 
-	listener, err := net.Listen("tcp", cfg.ListenAddr)
+	addrs, listeners, err := openListeners(cfg.ListenAddr)
 	if err != nil {
 		return err
 	}
-	defer listener.Close()
 
-	host, port, _ := net.SplitHostPort(cfg.ListenAddr)
-	if host == "" {
-		host = "127.0.0.1"
+	for _, addr := range addrs {
+		fmt.Printf("Axiom NFS server listening on %s\n", addr)
 	}
-	fmt.Printf("Axiom NFS server listening on %s\n", cfg.ListenAddr)
-	fmt.Println()
-	fmt.Println("Mount on macOS (userspace):")
-	fmt.Printf("  mkdir -p ~/Axiom && mount_nfs -o vers=3,tcp,port=%s,mountport=%s,noresvport,nolocks,locallocks %s:/ ~/Axiom\n", port, port, host)
-	fmt.Println()
-	fmt.Println("Mount on Linux:")
-	fmt.Printf("  sudo mount -t nfs -o vers=3,tcp,port=%s,mountport=%s %s:/ /mnt/axiom\n", port, port, host)
 	fmt.Println()
+	tcp := firstTCP(addrs)
+	if cfg.AutoMount != "" && tcp == "" {
+		return errors.New("-auto-mount needs a TCP -listen address")
+	}
+	if tcp != "" && cfg.AutoMount == "" {
+		printMountHints(tcp)
+	}
+
+	// Prefetch datasets in background to warm cache before Finder opens.
+	// With -systemd-notify, the service is ready once its listeners are
+	// open and every view has listed its datasets from Axiom, so the
+	// listing is retried until it succeeds.
+	go func() {
+		for {
+			err := prefetch(mounts, cfg.SystemdNotify)
+			if err == nil {
+				break
+			}
+			fmt.Fprintf(os.Stderr, "prefetch warning: %v\n", err)
+			if !cfg.SystemdNotify {
+				return
+			}
+			select {
+			case <-watchCtx.Done():
+				return
+			case <-time.After(prefetchRetry):
+			}
+		}
+		if cfg.SystemdNotify {
+			notify("READY=1")
+		}
+	}()
 
 	sigs := make(chan os.Signal, 1)
 	signal.Notify(sigs, syscall.SIGINT, syscall.SIGTERM)
-	go func() {
+	defer signal.Stop(sigs)
+
+	// Each listener is served on its own goroutine. The first signal, context
+	// cancellation or serve failure closes every listener; errors caused by
+	// that shutdown are not reported. Connections already accepted keep
+	// being served while in-flight work drains.
+	var shuttingDown atomic.Bool
+	g, gctx := errgroup.WithContext(ctx)
+	for _, l := range listeners {
+		g.Go(func() error {
+			err := nfs.Serve(l, cacheHandler)
+			if shuttingDown.Load() {
+				return nil
+			}
+			return fmt.Errorf("serve %s: %w", l.Addr(), err)
+		})
+	}
+	g.Go(func() error {
+		var mounted *automount.Mounted
+		if cfg.AutoMount != "" {
+			m, err := automount.Mount(gctx, tcp, cfg.AutoMount)
+			if err != nil {
+				shuttingDown.Store(true)
+				for _, l := range listeners {
+					_ = l.Close()
+				}
+				return err
+			}
+			mounted = m
+			fmt.Printf("Mounted at %s\n", mounted.Dir)
+		}
 		select {
-		case <-ctx.Done():
+		case <-gctx.Done():
 		case <-sigs:
+			fmt.Println("\nShutting down...")
 		}
-		fmt.Println("\nShutting down...")
-		_ = listener.Close()
-	}()
+		if cfg.SystemdNotify {
+			notify("STOPPING=1")
+		}
+		// Unmount while the server still answers, so the client does not
+		// hang on a dead mount.
+		if mounted != nil {
+			if err := mounted.Unmount(context.Background()); err != nil {
+				fmt.Fprintf(os.Stderr, "%v\n", err)
+			}
+		}
+		shuttingDown.Store(true)
+		for _, l := range listeners {
+			_ = l.Close()
+		}
+		return nil
+	})
+	err = g.Wait()
+	// Wake readers blocked at the end of tail.ndjson and stream.ndjson so
+	// they can drain.
+	for _, m := range mounts {
+		m.root.Tails().Close()
+		m.changes.Close()
+	}
+	drainAndPersist(cfg.DrainTimeout, billyFS, mounts)
+	return err
+}
+
+// connect creates the client of cfg and verifies its token. tenant names
+// the tenant view it is for, if any.
+func connect(ctx context.Context, cfg config.Config, tenant string, sealer *atrest.Sealer) (*axiomclient.Client, error) {
+	client, err := newClient(cfg, sealer)
+	if err != nil {
+		return nil, err
+	}
+
+	// Preflight check: verify token is valid
+	if tenant == "" {
+		fmt.Println("Verifying Axiom credentials...")
+	} else {
+		fmt.Printf("Verifying Axiom credentials of tenant %s...\n", tenant)
+	}
+	user, err := client.CurrentUser(ctx)
+	if err != nil {
+		if tenant != "" {
+			return nil, fmt.Errorf("tenant %s: credential check failed: %w", tenant, err)
+		}
+		return nil, fmt.Errorf("credential check failed: %w\n\nEnsure AXIOM_TOKEN is valid, or check ~/.axiom.toml", err)
+	}
+	fmt.Printf("Connected as %s (%s)\n", user.Name, user.Email)
+	return client, nil
+}
+
+// mountOptions are shared by every view a server serves.
+type mountOptions struct {
+	sealer     *atrest.Sealer
+	sortLocale language.Tag
+	finder     *finder.Filter
+	post       *postprocess.Pipeline
+}
+
+// mount is one view of Axiom: the whole mount, or a tenant's. Each has its
+// own event log and latency recorder, so one tenant's _status files never
+// show another's queries.
+type mount struct {
+	cfg     config.Config
+	cache   *cache.Cache
+	exec    *query.Executor
+	root    *vfs.Root
+	changes *events.Log
+}
+
+func newMount(cfg config.Config, client *axiomclient.Client, pol *policy.Policy, shared mountOptions) (*mount, error) {
+	redactor, err := redact.New(pol.Redact, pol.Columns)
+	if err != nil {
+		return nil, err
+	}
+
+	c := cache.New(cfg.CacheTTL, cfg.MaxCacheEntries, cfg.MaxCacheBytes, cfg.CacheDir,
+		cache.WithSegmentLimits(cache.SegmentMeta, cache.Limits{MaxEntries: cfg.CacheMetaEntries, MaxBytes: cfg.CacheMetaBytes}),
+		cache.WithSegmentLimits(cache.SegmentLarge, cache.Limits{MaxEntries: cfg.CacheLargeEntries, MaxBytes: cfg.CacheLargeBytes}),
+		cache.WithLargeThreshold(cfg.CacheLargeThreshold),
+		cache.WithMaxStale(cfg.CacheMaxStale),
+		cache.WithEncryption(shared.sealer),
+		cache.WithNamespace(redactor.Fingerprint()),
+	)
+	quotas := quota.New(quota.Limits{RowsPerHour: cfg.QuotaRowsPerHour, BytesPerHour: cfg.QuotaBytesPerHour})
+	timings := latency.New(cfg.SlowOpThreshold)
+	changes := events.New(0)
+	execOpts := []query.Option{
+		query.WithQuota(quotas),
+		query.WithLatency(timings),
+		query.WithEvents(changes),
+		query.WithEncryption(shared.sealer),
+		query.WithRedaction(redactor),
+		query.WithMaxRange(cfg.MaxRange),
+		query.WithRevalidate(cfg.Revalidate),
+		query.WithCursorFollow(cfg.FollowCursorPages),
+		query.WithMaxResultBytes(cfg.MaxResultBytes),
+		query.WithInlineMarkers(cfg.InlineMarkers),
+		query.WithFairQueue(cfg.QueryConcurrency, cfg.ClientWeights),
+		query.WithFlattenDepth(cfg.FlattenMaxDepth),
+		query.WithCollation(shared.sortLocale),
+		query.WithPostProcessors(shared.post),
+	}
+	if journal := cfg.InflightJournalPath(); journal != "" {
+		execOpts = append(execOpts, query.WithJournal(journal))
+	}
+	if cfg.Snapshot() {
+		execOpts = append(execOpts, query.WithPinnedRange(cfg.SnapshotFrom, cfg.SnapshotTo))
+		fmt.Printf("Snapshot mount: every query is pinned to %s .. %s\n", cfg.SnapshotFrom.Format(time.RFC3339), cfg.SnapshotTo.Format(time.RFC3339))
+	}
+	exec := query.NewExecutor(client, c, cfg.DefaultRange, cfg.DefaultLimit, cfg.MaxCachedResultBytes(), cfg.MaxInMemoryBytes, cfg.TempDir, execOpts...)
+
+	root := vfs.NewRoot(cfg, client, exec,
+		vfs.WithQuota(quotas),
+		vfs.WithLatency(timings),
+		vfs.WithFinder(shared.finder),
+		vfs.WithEvents(changes),
+		vfs.WithPolicy(pol),
+		vfs.WithRedaction(redactor),
+		vfs.WithTransferStats(client.TransferStats),
+		vfs.WithLinks(urlbuilder.New(client.BaseURL(), cfg.AppURL, client.OrgID())),
+		vfs.WithExportSinks(export.FromEnv()),
+	)
+	return &mount{cfg: cfg, cache: c, exec: exec, root: root, changes: changes}, nil
+}
+
+// drainAndPersist refuses new file handles and Axiom queries, waits up to
+// timeout for those in flight, then writes the caches to disk.
+func drainAndPersist(timeout time.Duration, fsys *nfsfs.FS, mounts []*mount) {
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+	if err := fsys.Drain(ctx); err != nil {
+		fmt.Fprintf(os.Stderr, "drain: open files did not close: %v\n", err)
+	}
+	for _, m := range mounts {
+		if err := m.exec.Drain(ctx); err != nil {
+			fmt.Fprintf(os.Stderr, "drain: in-flight queries did not finish: %v\n", err)
+		}
+		if err := m.cache.Flush(); err != nil {
+			fmt.Fprintf(os.Stderr, "cache flush: %v\n", err)
+		}
+	}
+}
+
+// prefetchRetry is how long a failed dataset prefetch waits before it is
+// retried for -systemd-notify.
+const prefetchRetry = 10 * time.Second
+
+// prefetch lists the datasets of every view. With live set, each list is
+// fetched from Axiom first rather than served from the disk cache.
+func prefetch(mounts []*mount, live bool) error {
+	for _, m := range mounts {
+		ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+		var err error
+		if live {
+			_, err = m.root.RefreshMetadata(ctx)
+		}
+		if err == nil {
+			_, err = m.root.ReadDir(ctx)
+		}
+		cancel()
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// notify sends state to systemd, warning when it cannot.
+func notify(state string) {
+	sent, err := systemd.Notify(state)
+	switch {
+	case err != nil:
+		fmt.Fprintf(os.Stderr, "systemd notify: %v\n", err)
+	case !sent:
+		fmt.Fprintln(os.Stderr, "systemd notify: NOTIFY_SOCKET is not set")
+	}
+}
 
-	return nfs.Serve(listener, cacheHandler)
+// openListeners opens the listeners of spec, the -listen flag, unless
+// systemd passed sockets to the process, which are used instead.
+func openListeners(spec string) ([]listen.Address, []net.Listener, error) {
+	listeners, err := systemd.Listeners()
+	if err != nil {
+		return nil, nil, err
+	}
+	if len(listeners) > 0 {
+		fmt.Println("Serving the sockets passed by systemd; -listen is ignored")
+		addrs := make([]listen.Address, len(listeners))
+		for i, l := range listeners {
+			addrs[i] = listen.Address{Network: l.Addr().Network(), Addr: l.Addr().String()}
+		}
+		return addrs, listeners, nil
+	}
+	addrs, err := listen.Parse(spec)
+	if err != nil {
+		return nil, nil, err
+	}
+	listeners, err = listen.Open(addrs)
+	if err != nil {
+		return nil, nil, err
+	}
+	return addrs, listeners, nil
+}
+
+// printMountHints prints the commands mounting the export served on tcp.
+func printMountHints(tcp string) {
+	if argv, err := automount.Command("darwin", tcp, "~/Axiom"); err == nil {
+		fmt.Println("Mount on macOS (userspace):")
+		fmt.Printf("  mkdir -p ~/Axiom && %s\n", strings.Join(argv, " "))
+		fmt.Println()
+	}
+	if argv, err := automount.Command("linux", tcp, "/mnt/axiom"); err == nil {
+		fmt.Println("Mount on Linux:")
+		fmt.Printf("  sudo %s\n", strings.Join(argv, " "))
+		fmt.Println()
+	}
+	fmt.Println("Or let the server mount it: axiom-fs [flags] serve -auto-mount <dir>")
+	fmt.Println()
+}
+
+// firstTCP returns the first TCP address, used for the mount hints and
+// -auto-mount.
+func firstTCP(addrs []listen.Address) string {
+	for _, addr := range addrs {
+		if addr.Network == "tcp" {
+			return addr.Addr
+		}
+	}
+	return ""
 }
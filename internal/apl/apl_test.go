@@ -0,0 +1,331 @@
+package apl
+
+import (
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestTokenize(t *testing.T) {
+	tokens := Tokenize(`['my-logs'] | where msg == "a | b" and x>=5 // trailing`)
+	want := []Token{
+		{Ident, "['my-logs']"},
+		{Pipe, "|"},
+		{Ident, "where"},
+		{Ident, "msg"},
+		{Punct, "=="},
+		{String, `"a | b"`},
+		{Ident, "and"},
+		{Ident, "x"},
+		{Punct, ">="},
+		{Number, "5"},
+		{Comment, "// trailing"},
+	}
+	if len(tokens) != len(want) {
+		t.Fatalf("got %d tokens %v, want %d", len(tokens), tokens, len(want))
+	}
+	for i := range want {
+		if tokens[i] != want[i] {
+			t.Errorf("token[%d] = %+v, want %+v", i, tokens[i], want[i])
+		}
+	}
+}
+
+func TestTokenizeHyphenatedOperators(t *testing.T) {
+	tokens := Tokenize("project-away a | extend d = a-b")
+	if tokens[0].Text != "project-away" {
+		t.Errorf("got %q, want project-away", tokens[0].Text)
+	}
+	last := tokens[len(tokens)-3:]
+	if last[0].Text != "a" || last[1].Text != "-" || last[2].Text != "b" {
+		t.Errorf("subtraction tokenized as %v", last)
+	}
+}
+
+func TestFormat(t *testing.T) {
+	cases := []struct {
+		name string
+		in   string
+		want string
+	}{
+		{
+			name: "one line",
+			in:   "['logs'] | where status>=500   | summarize count() by service",
+			want: "['logs']\n| where status >= 500\n| summarize count() by service\n",
+		},
+		{
+			name: "pipes in strings and parens",
+			in:   "logs|where msg==\"a|b\"|extend x=iff(a,1,2)",
+			want: "logs\n| where msg == \"a|b\"\n| extend x = iff(a, 1, 2)\n",
+		},
+		{
+			name: "range",
+			in:   "['logs']\n|where _time between (ago(1h)..now())",
+			want: "['logs']\n| where _time between (ago(1h) .. now())\n",
+		},
+		{
+			name: "comments kept",
+			in:   "// errors\n['logs'] | take 5",
+			want: "// errors\n['logs']\n| take 5\n",
+		},
+		{
+			name: "empty",
+			in:   "   ",
+			want: "",
+		},
+	}
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			if got := Format(tc.in); got != tc.want {
+				t.Errorf("Format(%q) = %q, want %q", tc.in, got, tc.want)
+			}
+		})
+	}
+}
+
+func TestFormatIdempotent(t *testing.T) {
+	in := "['logs'] | where a == 'x' | project a, b=c | take 10"
+	once := Format(in)
+	if twice := Format(once); twice != once {
+		t.Errorf("Format not idempotent:\n%q\n%q", once, twice)
+	}
+}
+
+func TestCanonical(t *testing.T) {
+	same := [][]string{
+		{
+			"['logs'] | where status >= 500 and service == 'api' | take 10",
+			"['logs']\n| where service=='api' and status>=500 // errors\n| take 10",
+			"['logs'] | where status >= 500 | where service == 'api' | take 10",
+		},
+		{
+			"['logs'] | where a == 1 or b == 2 | where c == 3",
+			"['logs'] | where c == 3 | where (a == 1 or b == 2)",
+		},
+		{
+			"['logs'] | project-away b, a",
+			"['logs'] | project-away a,b",
+		},
+	}
+	for _, spellings := range same {
+		want := Canonical(spellings[0])
+		for _, src := range spellings[1:] {
+			if got := Canonical(src); got != want {
+				t.Errorf("Canonical(%q) = %q, want %q", src, got, want)
+			}
+		}
+	}
+	different := [][2]string{
+		{"['logs'] | where a == 1 or b == 2 and c == 3", "['logs'] | where a == 1 or c == 3 and b == 2"},
+		{"['logs'] | summarize count() by a, b", "['logs'] | summarize count() by b, a"},
+		{"['logs'] | where a == 1 | take 5 | where b == 2", "['logs'] | where b == 2 | take 5 | where a == 1"},
+		{"['logs'] | where msg == 'a  b'", "['logs'] | where msg == 'a b'"},
+	}
+	for _, pair := range different {
+		if Canonical(pair[0]) == Canonical(pair[1]) {
+			t.Errorf("Canonical(%q) == Canonical(%q)", pair[0], pair[1])
+		}
+	}
+}
+
+func TestDataset(t *testing.T) {
+	cases := map[string]string{
+		"['logs'] | take 1":   "logs",
+		"logs | take 1":       "logs",
+		`["my logs"]`:         "my logs",
+		"union a, b | take 1": "",
+	}
+	for in, want := range cases {
+		if got := Dataset(in); got != want {
+			t.Errorf("Dataset(%q) = %q, want %q", in, got, want)
+		}
+	}
+}
+
+func TestDatasets(t *testing.T) {
+	cases := map[string]string{
+		"['logs'] | take 1":                             "logs",
+		"['a'] | union ['b'] | where ['user id'] != ''": "a,b,user id",
+		"['a'] | union b":                               "",
+		"['a'] | join kind=inner (b) on id":             "",
+		"print 1":                                       "",
+	}
+	for in, want := range cases {
+		if got := strings.Join(Datasets(in), ","); got != want {
+			t.Errorf("Datasets(%q) = %q, want %q", in, got, want)
+		}
+	}
+}
+
+func TestProjected(t *testing.T) {
+	got := Projected("['logs'] | project _time, who = ['user'].email, n = strlen(msg) | project-away _time | project-reorder who")
+	if want := "_time,user.email,who"; strings.Join(got, ",") != want {
+		t.Errorf("Projected = %v, want %s", got, want)
+	}
+}
+
+func TestLint(t *testing.T) {
+	rules := func(issues []Issue) map[string]bool {
+		out := map[string]bool{}
+		for _, is := range issues {
+			out[is.Rule] = true
+		}
+		return out
+	}
+
+	t.Run("clean query", func(t *testing.T) {
+		issues := Lint("['logs'] | where _time > ago(1h) | take 10", LintOptions{})
+		if len(issues) != 0 {
+			t.Errorf("expected no issues, got %+v", issues)
+		}
+	})
+
+	t.Run("missing time and bound", func(t *testing.T) {
+		got := rules(Lint("['logs'] | where status == 500", LintOptions{}))
+		if !got["missing-time-filter"] || !got["unbounded-take"] {
+			t.Errorf("got %v", got)
+		}
+	})
+
+	t.Run("unknown fields", func(t *testing.T) {
+		issues := Lint("['logs'] | where _time > ago(1h) | project a, b = c, d = strlen(e) | take 1", LintOptions{Fields: []string{"a", "b"}})
+		if len(issues) != 1 || issues[0].Rule != "unknown-field" || issues[0].Stage != 2 {
+			t.Fatalf("got %+v", issues)
+		}
+	})
+
+	t.Run("computed columns", func(t *testing.T) {
+		opts := LintOptions{Fields: []string{"_time", "service", "d"}}
+		for _, src := range []string{
+			"['logs'] | where _time > ago(1h) | summarize count() by service | project count_, service",
+			"['logs'] | where _time > ago(1h) | extend ms = d * 1000, ['bytes.in'] = 1 | project ms, ['bytes.in'] | take 1",
+			"['logs'] | where _time > ago(1h) | project s = service | project s | take 1",
+		} {
+			if got := rules(Lint(src, opts)); got["unknown-field"] {
+				t.Errorf("%s: got %v", src, got)
+			}
+		}
+		if got := rules(Lint("['logs'] | where _time > ago(1h) | extend ms = d | project nope | take 1", opts)); !got["unknown-field"] {
+			t.Errorf("unknown field after extend not reported: %v", got)
+		}
+	})
+
+	t.Run("fields unknown skips check", func(t *testing.T) {
+		got := rules(Lint("['logs'] | where _time > ago(1h) | project nope | take 1", LintOptions{}))
+		if got["unknown-field"] {
+			t.Error("field check should be skipped without fields")
+		}
+	})
+
+	t.Run("empty", func(t *testing.T) {
+		got := rules(Lint("", LintOptions{}))
+		if !got["empty"] {
+			t.Errorf("got %v", got)
+		}
+	})
+}
+
+func TestExpandIncludes(t *testing.T) {
+	sources := map[string]string{
+		"prod":   "| where env == \"prod\"",
+		"errors": "#include <prod>\n| where status >= 500\n",
+		"a":      "#include b",
+		"b":      "#include \"a\"",
+	}
+	resolve := func(name string) (string, bool) {
+		src, ok := sources[name]
+		return src, ok
+	}
+
+	got, err := ExpandIncludes("", "['logs']\n#include <errors>\n| take 10", resolve)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := "['logs']\n| where env == \"prod\"\n| where status >= 500\n| take 10"
+	if got != want {
+		t.Errorf("got:\n%s\nwant:\n%s", got, want)
+	}
+
+	if _, err := ExpandIncludes("", "#include a", resolve); err == nil || !strings.Contains(err.Error(), "cycle") {
+		t.Errorf("expected cycle error, got %v", err)
+	}
+	if _, err := ExpandIncludes("", "#include missing", resolve); err == nil {
+		t.Error("expected error for unknown include")
+	}
+	if _, err := ExpandIncludes("self", "#include self", resolve); err == nil {
+		t.Error("expected error for self include")
+	}
+	if got, _ := ExpandIncludes("", "['logs'] // #include prod", resolve); got != "['logs'] // #include prod" {
+		t.Errorf("inline directive should be left alone: %q", got)
+	}
+}
+
+func TestBundledCatalog(t *testing.T) {
+	catalog := BundledCatalog()
+	if len(catalog.Operators) == 0 || len(catalog.Functions) == 0 {
+		t.Fatalf("catalog = %d operators, %d functions", len(catalog.Operators), len(catalog.Functions))
+	}
+	for i, op := range catalog.Operators {
+		if op.Name == "" || op.Signature == "" || op.Description == "" {
+			t.Errorf("operator %d is incomplete: %+v", i, op)
+		}
+		if i > 0 && catalog.Operators[i-1].Name >= op.Name {
+			t.Errorf("operators not sorted at %q", op.Name)
+		}
+	}
+	for i, fn := range catalog.Functions {
+		if fn.Name == "" || fn.Category == "" || fn.Signature == "" || fn.Description == "" {
+			t.Errorf("function %d is incomplete: %+v", i, fn)
+		}
+		if !strings.HasPrefix(fn.Signature, fn.Name+"(") {
+			t.Errorf("%s signature %q does not call it", fn.Name, fn.Signature)
+		}
+		if fn.Aggregation != (fn.Category == "aggregation") {
+			t.Errorf("%s aggregation = %v in category %s", fn.Name, fn.Aggregation, fn.Category)
+		}
+		if i > 0 {
+			prev := catalog.Functions[i-1]
+			if prev.Category > fn.Category || prev.Category == fn.Category && prev.Name >= fn.Name {
+				t.Errorf("functions not sorted at %q", fn.Name)
+			}
+		}
+	}
+}
+
+func TestParseTimespan(t *testing.T) {
+	valid := map[string]time.Duration{
+		"90s":   90 * time.Second,
+		"1h30m": 90 * time.Minute,
+		"7d":    7 * 24 * time.Hour,
+		"1.5d":  36 * time.Hour,
+		"2w":    14 * 24 * time.Hour,
+	}
+	for in, want := range valid {
+		got, err := ParseTimespan(in)
+		if err != nil || got != want {
+			t.Errorf("ParseTimespan(%q) = %v, %v; want %v", in, got, err, want)
+		}
+	}
+	for _, in := range []string{"", "d", "7", "7x", "1e3d", "NaNd"} {
+		if _, err := ParseTimespan(in); err == nil {
+			t.Errorf("ParseTimespan(%q) succeeded", in)
+		}
+	}
+}
+
+func TestReferences(t *testing.T) {
+	cases := map[string]string{
+		"['logs'] | extend x = ssn | project x":                                          "ssn",
+		"['logs'] | summarize make_set(ssn) by service":                                  "ssn,service",
+		"['logs'] | where user.email contains \"@\" and status >= 500":                   "user.email,status",
+		"['logs'] | project who = ['user'].email, n = strlen(msg) | where n > 1":         "user.email,msg",
+		"['logs'] | project-away ssn | sort by _time desc":                               "_time",
+		"['logs'] | join kind=inner (['users'] | where active) on $left.id == $right.id": "active",
+		"['logs'] | where _time > ago(1h) | summarize count() by bin(_time, 1m)":         "_time",
+	}
+	for src, want := range cases {
+		if got := strings.Join(References(src), ","); got != want {
+			t.Errorf("References(%q) = %s, want %s", src, got, want)
+		}
+	}
+}
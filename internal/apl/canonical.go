@@ -0,0 +1,79 @@
+package apl
+
+import (
+	"slices"
+	"strings"
+)
+
+// Canonical returns src in a form shared by trivially different spellings
+// of the same query, for use as a cache key: comments are dropped,
+// whitespace is normalized, stages are joined on one line, consecutive
+// where stages are merged into one whose top-level and-ed terms are sorted,
+// and project-away lists are sorted. The rewrite is purely textual: queries
+// equal in meaning but written differently otherwise still differ.
+func Canonical(src string) string {
+	var (
+		out   []string
+		terms []string
+	)
+	flush := func() {
+		if len(terms) == 0 {
+			return
+		}
+		slices.Sort(terms)
+		out = append(out, "where "+strings.Join(terms, " and "))
+		terms = nil
+	}
+	for i, stage := range Stages(Tokenize(src)) {
+		code := Code(stage)
+		if i > 0 && len(code) > 1 && code[0].Kind == Ident && code[0].Text == "where" {
+			terms = append(terms, whereTerms(code[1:])...)
+			continue
+		}
+		flush()
+		if i > 0 && len(code) > 1 && code[0].Kind == Ident && code[0].Text == "project-away" {
+			list := splitTopLevel(code[1:], func(tok Token) bool { return tok.Kind == Punct && tok.Text == "," })
+			slices.Sort(list)
+			out = append(out, "project-away "+strings.Join(list, ", "))
+			continue
+		}
+		out = append(out, Join(code))
+	}
+	flush()
+	return strings.Join(out, " | ")
+}
+
+// whereTerms splits a where predicate at its top-level ands. A predicate
+// with a top-level or is one term, parenthesized so it keeps its meaning
+// when joined to others.
+func whereTerms(predicate []Token) []string {
+	isOr := func(tok Token) bool { return tok.Kind == Ident && tok.Text == "or" }
+	if len(splitTopLevel(predicate, isOr)) > 1 {
+		return []string{"(" + Join(predicate) + ")"}
+	}
+	return splitTopLevel(predicate, func(tok Token) bool { return tok.Kind == Ident && tok.Text == "and" })
+}
+
+// splitTopLevel splits tokens at the separators outside brackets and
+// renders each part.
+func splitTopLevel(tokens []Token, sep func(Token) bool) []string {
+	var (
+		parts []string
+		start int
+		depth int
+	)
+	for i, tok := range tokens {
+		switch {
+		case tok.Kind == Punct && (tok.Text == "(" || tok.Text == "["):
+			depth++
+		case tok.Kind == Punct && (tok.Text == ")" || tok.Text == "]"):
+			if depth > 0 {
+				depth--
+			}
+		case depth == 0 && sep(tok):
+			parts = append(parts, Join(tokens[start:i]))
+			start = i + 1
+		}
+	}
+	return append(parts, Join(tokens[start:]))
+}
@@ -0,0 +1,49 @@
+package apl
+
+import (
+	_ "embed"
+	"encoding/json"
+	"sync"
+)
+
+// catalogJSON is the bundled reference of APL operators and functions.
+//
+//go:embed catalog.json
+var catalogJSON []byte
+
+// CatalogEntry documents one tabular operator or scalar or aggregation
+// function.
+type CatalogEntry struct {
+	Name     string `json:"name"`
+	Category string `json:"category,omitempty"`
+	// Signature shows the call with its arguments; optional ones are in
+	// brackets.
+	Signature   string `json:"signature"`
+	Returns     string `json:"returns,omitempty"`
+	Description string `json:"description"`
+	// Aggregation marks functions only valid inside summarize.
+	Aggregation bool `json:"aggregation,omitempty"`
+}
+
+// Catalog is the bundled APL reference. Operators are sorted by name,
+// functions by category and then name.
+type Catalog struct {
+	Operators []CatalogEntry `json:"operators"`
+	Functions []CatalogEntry `json:"functions"`
+}
+
+var (
+	catalogOnce sync.Once
+	catalog     Catalog
+)
+
+// BundledCatalog returns the operators and functions bundled with the
+// binary. Callers must not modify it.
+func BundledCatalog() Catalog {
+	catalogOnce.Do(func() {
+		if err := json.Unmarshal(catalogJSON, &catalog); err != nil {
+			panic("apl: bundled catalog: " + err.Error())
+		}
+	})
+	return catalog
+}
@@ -0,0 +1,2 @@
+// Package apl provides a lightweight APL tokenizer used to format and lint saved queries.
+package apl
@@ -0,0 +1,29 @@
+package apl
+
+import "strings"
+
+// Format returns a canonical rendering of src: one pipeline stage per line,
+// each after the first prefixed with "| ", with normalized whitespace.
+// Comments are kept on their own line above the stage they belong to.
+func Format(src string) string {
+	stages := Stages(Tokenize(src))
+	var b strings.Builder
+	for i, stage := range stages {
+		for _, tok := range stage {
+			if tok.Kind == Comment {
+				b.WriteString(tok.Text)
+				b.WriteByte('\n')
+			}
+		}
+		code := Join(stage)
+		if code == "" && i == 0 {
+			continue
+		}
+		if i > 0 {
+			b.WriteString("| ")
+		}
+		b.WriteString(code)
+		b.WriteByte('\n')
+	}
+	return b.String()
+}
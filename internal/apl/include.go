@@ -0,0 +1,83 @@
+package apl
+
+import (
+	"bufio"
+	"fmt"
+	"strings"
+)
+
+// maxIncludeDepth bounds nested includes independently of cycle detection.
+const maxIncludeDepth = 16
+
+// Resolver returns the source of an include target.
+type Resolver func(name string) (string, bool)
+
+// ExpandIncludes replaces every `#include <name>` line in src with the
+// source resolve returns for name, recursively. The name may be bare, in
+// angle brackets or in double quotes. Cycles and unknown names are errors.
+// self names src for cycle detection and may be empty.
+func ExpandIncludes(self, src string, resolve Resolver) (string, error) {
+	var stack []string
+	if self != "" {
+		stack = []string{self}
+	}
+	return expand(src, resolve, stack)
+}
+
+func expand(src string, resolve Resolver, stack []string) (string, error) {
+	if len(stack) > maxIncludeDepth {
+		return "", fmt.Errorf("include depth exceeds %d: %s", maxIncludeDepth, strings.Join(stack, " -> "))
+	}
+	var out strings.Builder
+	scanner := bufio.NewScanner(strings.NewReader(src))
+	scanner.Buffer(make([]byte, 0, 64*1024), 1<<20)
+	first := true
+	for scanner.Scan() {
+		line := scanner.Text()
+		if !first {
+			out.WriteByte('\n')
+		}
+		first = false
+
+		name, ok := includeTarget(line)
+		if !ok {
+			out.WriteString(line)
+			continue
+		}
+		for _, seen := range stack {
+			if seen == name {
+				return "", fmt.Errorf("include cycle: %s -> %s", strings.Join(stack, " -> "), name)
+			}
+		}
+		body, found := resolve(name)
+		if !found {
+			return "", fmt.Errorf("include %q not found", name)
+		}
+		expanded, err := expand(body, resolve, append(stack, name))
+		if err != nil {
+			return "", err
+		}
+		out.WriteString(strings.TrimRight(expanded, "\n"))
+	}
+	if err := scanner.Err(); err != nil {
+		return "", err
+	}
+	return out.String(), nil
+}
+
+// includeTarget parses an `#include` directive line.
+func includeTarget(line string) (string, bool) {
+	trimmed := strings.TrimSpace(line)
+	rest, ok := strings.CutPrefix(trimmed, "#include")
+	if !ok || (rest != "" && rest[0] != ' ' && rest[0] != '\t') {
+		return "", false
+	}
+	name := strings.TrimSpace(rest)
+	if len(name) >= 2 && ((name[0] == '<' && name[len(name)-1] == '>') || (name[0] == '"' && name[len(name)-1] == '"')) {
+		name = name[1 : len(name)-1]
+	}
+	if name == "" {
+		return "", false
+	}
+	return name, true
+}
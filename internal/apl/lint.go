@@ -0,0 +1,387 @@
+package apl
+
+import (
+	"fmt"
+	"strings"
+)
+
+// Severity levels for lint issues.
+const (
+	SeverityWarning = "warning"
+	SeverityError   = "error"
+)
+
+// Issue is a single lint finding.
+type Issue struct {
+	Rule     string `json:"rule"`
+	Severity string `json:"severity"`
+	Stage    int    `json:"stage"`
+	Message  string `json:"message"`
+}
+
+// LintOptions configures Lint.
+type LintOptions struct {
+	// Fields lists the known fields of the source dataset. When nil, field
+	// checks are skipped.
+	Fields []string
+}
+
+// Dataset returns the dataset referenced by the first stage of src, or ""
+// when the source is not a plain dataset reference.
+func Dataset(src string) string {
+	stages := Stages(Tokenize(src))
+	if len(stages) == 0 {
+		return ""
+	}
+	code := Code(stages[0])
+	if len(code) != 1 || code[0].Kind != Ident {
+		return ""
+	}
+	return unquoteIdent(code[0].Text)
+}
+
+// Datasets returns the datasets src may read: its plain source and every
+// bracket-quoted name, which also catches field names quoted the same way.
+// It returns nil when it cannot tell, e.g. for a bare union operand or a
+// join.
+func Datasets(src string) []string {
+	tokens := Tokenize(src)
+	var names []string
+	seen := map[string]bool{}
+	add := func(name string) {
+		if !seen[name] {
+			seen[name] = true
+			names = append(names, name)
+		}
+	}
+	if name := Dataset(src); name != "" {
+		add(name)
+	}
+	for _, stage := range Stages(tokens) {
+		code := Code(stage)
+		if len(code) == 0 {
+			continue
+		}
+		switch strings.ToLower(code[0].Text) {
+		case "union":
+			for _, tok := range code[1:] {
+				if tok.Kind == Ident && !strings.HasPrefix(tok.Text, "[") {
+					return nil
+				}
+			}
+		case "join", "lookup":
+			return nil
+		}
+	}
+	for _, tok := range tokens {
+		if tok.Kind == Ident && strings.HasPrefix(tok.Text, "[") {
+			add(unquoteIdent(tok.Text))
+		}
+	}
+	return names
+}
+
+// Projected returns the source fields src's project stages reference, in
+// order, dotted paths included; computed columns count by their right-hand
+// side when it is a single field.
+func Projected(src string) []string {
+	var fields []string
+	for _, stage := range Stages(Tokenize(src)) {
+		code := Code(stage)
+		if len(code) == 0 {
+			continue
+		}
+		switch strings.ToLower(code[0].Text) {
+		case "project", "project-keep", "project-reorder":
+			for _, expr := range projectedExprs(code[1:]) {
+				if name, ok := fieldPath(expr); ok {
+					fields = append(fields, name)
+				}
+			}
+		}
+	}
+	return fields
+}
+
+// References returns the fields src reads anywhere, in order of first
+// use, dotted paths included: in filters, expressions, aggregations and
+// group keys as well as projections. Operators, keywords, function names,
+// dataset sources and the columns src computes itself are left out, as are
+// fields it only removes with project-away.
+func References(src string) []string {
+	var fields []string
+	seen := map[string]bool{}
+	defined := map[string]bool{}
+	for s, stage := range Stages(Tokenize(src)) {
+		code := Code(stage)
+		if len(code) == 0 {
+			continue
+		}
+		op := strings.ToLower(code[0].Text)
+		if op == "union" || op == "project-away" {
+			continue
+		}
+		start := 1
+		if s == 0 {
+			start = 0
+		}
+		var computed []string
+		for i := start; i < len(code); {
+			tok := code[i]
+			if tok.Kind != Ident {
+				i++
+				continue
+			}
+			// Join a chain such as ['user'].email into one dotted path.
+			parts := []string{unquoteIdent(tok.Text)}
+			j := i + 1
+			for j+1 < len(code) && code[j].Kind == Punct && code[j].Text == "." && code[j+1].Kind == Ident {
+				parts = append(parts, unquoteIdent(code[j+1].Text))
+				j += 2
+			}
+			name := strings.Join(parts, ".")
+			prev, next := Token{}, Token{}
+			if i > 0 {
+				prev = code[i-1]
+			}
+			if j < len(code) {
+				next = code[j]
+			}
+			i = j
+			switch {
+			case next.Kind == Punct && next.Text == "(":
+				// A function call.
+			case next.Kind == Punct && (next.Text == "=" || next.Text == ":"):
+				computed = append(computed, name)
+			case s == 0 && j == len(code), next.Kind == Pipe,
+				prev.Kind == Punct && prev.Text == "(" && (op == "join" || op == "lookup"):
+				// A dataset source, of the query or a nested pipeline.
+			case prev.Kind == Pipe:
+				// The operator of a nested pipeline.
+			case strings.HasPrefix(name, "$") || isReferenceKeyword(name) || defined[name]:
+			default:
+				if !seen[name] {
+					seen[name] = true
+					fields = append(fields, name)
+				}
+			}
+		}
+		for _, name := range computed {
+			defined[name] = true
+		}
+	}
+	return fields
+}
+
+// isReferenceKeyword reports whether word is APL syntax rather than a field
+// name where it appears inside an expression.
+func isReferenceKeyword(word string) bool {
+	switch strings.ToLower(word) {
+	case "and", "or", "not", "by", "on", "in", "in~", "between", "asc", "desc", "nulls", "first", "last",
+		"with", "kind", "withsource", "true", "false", "null", "let", "from", "to", "step", "default",
+		"has", "has_cs", "has_any", "has_all", "hasprefix", "hasprefix_cs", "hassuffix", "hassuffix_cs",
+		"contains", "contains_cs", "startswith", "startswith_cs", "endswith", "endswith_cs",
+		"matches", "regex", "like", "notlike",
+		"inner", "innerunique", "leftouter", "rightouter", "fullouter", "leftanti", "rightanti", "leftsemi", "rightsemi",
+		"bool", "boolean", "int", "long", "real", "double", "decimal", "string", "datetime", "timespan", "dynamic", "guid":
+		return true
+	default:
+		return false
+	}
+}
+
+// fieldPath joins a field reference such as user.email or
+// ['user'].email; ok is false for anything else.
+func fieldPath(expr []Token) (string, bool) {
+	var parts []string
+	for i, tok := range expr {
+		if i%2 == 1 {
+			if tok.Kind != Punct || tok.Text != "." {
+				return "", false
+			}
+			continue
+		}
+		if tok.Kind != Ident {
+			return "", false
+		}
+		parts = append(parts, unquoteIdent(tok.Text))
+	}
+	if len(parts) == 0 || len(expr)%2 == 0 {
+		return "", false
+	}
+	return strings.Join(parts, "."), true
+}
+
+// Lint reports common problems in src. Issues are ordered by stage. Field
+// checks know the columns extend and project compute, and stop after a
+// stage such as summarize that reshapes the result.
+func Lint(src string, opts LintOptions) []Issue {
+	stages := Stages(Tokenize(src))
+	issues := []Issue{}
+	if len(stages) == 0 {
+		return append(issues, Issue{Rule: "empty", Severity: SeverityError, Message: "query is empty"})
+	}
+
+	known := map[string]bool{}
+	for _, f := range opts.Fields {
+		known[f] = true
+	}
+
+	// checkFields turns off after a stage whose output columns the lint
+	// cannot follow.
+	checkFields := opts.Fields != nil
+	hasTime, hasBound := false, false
+	for i, stage := range stages {
+		code := Code(stage)
+		if i == 0 || len(code) == 0 {
+			if i > 0 {
+				issues = append(issues, Issue{Rule: "empty-stage", Severity: SeverityError, Stage: i, Message: "empty pipeline stage"})
+			}
+			continue
+		}
+		op := strings.ToLower(code[0].Text)
+		switch op {
+		case "where":
+			if referencesTime(code[1:]) {
+				hasTime = true
+			}
+		case "take", "limit", "top", "count", "summarize", "distinct":
+			hasBound = true
+		case "project", "project-keep", "project-away", "project-reorder":
+			if !checkFields {
+				break
+			}
+			for _, name := range projectedFields(code[1:]) {
+				if !known[name] {
+					issues = append(issues, Issue{
+						Rule:     "unknown-field",
+						Severity: SeverityError,
+						Stage:    i,
+						Message:  fmt.Sprintf("%s references unknown field %q", op, name),
+					})
+				}
+			}
+		}
+		switch op {
+		case "extend", "project", "project-keep", "project-reorder", "project-rename":
+			for _, name := range computedColumns(code[1:]) {
+				known[name] = true
+			}
+		case "where", "filter", "take", "limit", "sort", "order", "top", "sample", "project-away":
+		default:
+			// summarize, join, parse and the like replace or add columns
+			// by rules of their own.
+			checkFields = false
+		}
+	}
+	if !hasTime {
+		issues = append(issues, Issue{
+			Rule:     "missing-time-filter",
+			Severity: SeverityWarning,
+			Message:  "no _time filter; the query scans the dataset's full retention",
+		})
+	}
+	if !hasBound {
+		issues = append(issues, Issue{
+			Rule:     "unbounded-take",
+			Severity: SeverityWarning,
+			Stage:    len(stages) - 1,
+			Message:  "no take/limit/top/summarize; the result size is unbounded",
+		})
+	}
+	return issues
+}
+
+func referencesTime(tokens []Token) bool {
+	for _, tok := range tokens {
+		if tok.Kind == Ident && unquoteIdent(tok.Text) == "_time" {
+			return true
+		}
+	}
+	return false
+}
+
+// projectedFields returns the source fields referenced by a project list.
+// Only bare identifiers are reported; computed columns (a = expr) are skipped
+// except for their right-hand side when it is a single identifier.
+func projectedFields(tokens []Token) []string {
+	var fields []string
+	for _, expr := range projectedExprs(tokens) {
+		if len(expr) == 1 && expr[0].Kind == Ident {
+			fields = append(fields, unquoteIdent(expr[0].Text))
+		}
+	}
+	return fields
+}
+
+// computedColumns returns the columns an extend or project list assigns,
+// such as b in `b = a * 2`.
+func computedColumns(tokens []Token) []string {
+	var names []string
+	depth := 0
+	for i, tok := range tokens {
+		if tok.Kind == Punct {
+			switch tok.Text {
+			case "(", "[":
+				depth++
+			case ")", "]":
+				depth--
+			}
+			continue
+		}
+		first := i == 0 || tokens[i-1].Kind == Punct && tokens[i-1].Text == ","
+		if depth == 0 && first && tok.Kind == Ident && i+1 < len(tokens) && tokens[i+1].Kind == Punct && tokens[i+1].Text == "=" {
+			names = append(names, unquoteIdent(tok.Text))
+		}
+	}
+	return names
+}
+
+// projectedExprs splits a project list into its items, each the right-hand
+// side of a computed column or the item itself.
+func projectedExprs(tokens []Token) [][]Token {
+	var (
+		exprs [][]Token
+		item  []Token
+		depth int
+	)
+	flush := func() {
+		expr := item
+		for j, tok := range item {
+			if tok.Kind == Punct && tok.Text == "=" {
+				expr = item[j+1:]
+				break
+			}
+		}
+		exprs = append(exprs, expr)
+		item = nil
+	}
+	for _, tok := range tokens {
+		if tok.Kind == Punct {
+			switch tok.Text {
+			case "(", "[":
+				depth++
+			case ")", "]":
+				depth--
+			case ",":
+				if depth == 0 {
+					flush()
+					continue
+				}
+			}
+		}
+		item = append(item, tok)
+	}
+	flush()
+	return exprs
+}
+
+func unquoteIdent(text string) string {
+	if strings.HasPrefix(text, "[") && strings.HasSuffix(text, "]") {
+		text = text[1 : len(text)-1]
+	}
+	if len(text) >= 2 && (text[0] == '\'' || text[0] == '"') && text[len(text)-1] == text[0] {
+		text = text[1 : len(text)-1]
+	}
+	return text
+}
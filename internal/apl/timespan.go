@@ -0,0 +1,30 @@
+package apl
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// ParseTimespan parses an APL timespan literal such as "90s", "1h30m", "7d"
+// or "2w". Besides the units time.ParseDuration accepts, it takes days (d)
+// and weeks (w) as a single number and unit.
+func ParseTimespan(s string) (time.Duration, error) {
+	for suffix, unit := range map[string]time.Duration{"d": 24 * time.Hour, "w": 7 * 24 * time.Hour} {
+		n, ok := strings.CutSuffix(s, suffix)
+		if !ok {
+			continue
+		}
+		v, err := strconv.ParseFloat(n, 64)
+		if err != nil || n == "" || strings.ContainsAny(n, "eEnNiIxX") {
+			return 0, fmt.Errorf("invalid timespan %q", s)
+		}
+		return time.Duration(v * float64(unit)), nil
+	}
+	d, err := time.ParseDuration(s)
+	if err != nil {
+		return 0, fmt.Errorf("invalid timespan %q", s)
+	}
+	return d, nil
+}
@@ -0,0 +1,219 @@
+package apl
+
+import (
+	"strings"
+	"unicode"
+)
+
+// Kind classifies a token.
+type Kind int
+
+const (
+	Ident Kind = iota
+	Number
+	String
+	Pipe
+	Punct
+	Comment
+)
+
+// Token is a single lexical element of an APL query.
+type Token struct {
+	Kind Kind
+	Text string
+}
+
+// Tokenize splits APL into tokens. Whitespace is dropped; string literals,
+// bracketed dataset references and comments are kept intact. Tokenize never
+// fails: unterminated literals run to the end of the input.
+func Tokenize(src string) []Token {
+	var tokens []Token
+	i := 0
+	for i < len(src) {
+		c := src[i]
+		switch {
+		case c == ' ' || c == '\t' || c == '\n' || c == '\r':
+			i++
+		case c == '/' && i+1 < len(src) && src[i+1] == '/':
+			end := strings.IndexByte(src[i:], '\n')
+			if end == -1 {
+				end = len(src) - i
+			}
+			tokens = append(tokens, Token{Kind: Comment, Text: strings.TrimRight(src[i:i+end], " \t\r")})
+			i += end
+		case c == '"' || c == '\'':
+			end := scanString(src, i)
+			tokens = append(tokens, Token{Kind: String, Text: src[i:end]})
+			i = end
+		case c == '[' && i+1 < len(src) && (src[i+1] == '\'' || src[i+1] == '"'):
+			end := scanString(src, i+1)
+			if end < len(src) && src[end] == ']' {
+				end++
+			}
+			tokens = append(tokens, Token{Kind: Ident, Text: src[i:end]})
+			i = end
+		case c == '|':
+			tokens = append(tokens, Token{Kind: Pipe, Text: "|"})
+			i++
+		case isDigit(c):
+			j := i
+			for j < len(src) && (isIdentByte(src[j]) || src[j] == '.') {
+				j++
+			}
+			tokens = append(tokens, Token{Kind: Number, Text: src[i:j]})
+			i = j
+		case isIdentStart(c):
+			j := i
+			for j < len(src) && (isIdentByte(src[j]) || src[j] == '-' && j+1 < len(src) && isIdentStart(src[j+1]) && isOperatorWord(src[i:j])) {
+				j++
+			}
+			tokens = append(tokens, Token{Kind: Ident, Text: src[i:j]})
+			i = j
+		default:
+			j := i + 1
+			if j < len(src) && isCompound(src[i:j+1]) {
+				j++
+			}
+			tokens = append(tokens, Token{Kind: Punct, Text: src[i:j]})
+			i = j
+		}
+	}
+	return tokens
+}
+
+// Stages splits tokens into pipeline stages at top-level pipes. Comments stay
+// attached to the stage they appear in. The first stage is the tabular source
+// (usually the dataset).
+func Stages(tokens []Token) [][]Token {
+	var (
+		stages  [][]Token
+		current []Token
+		depth   int
+	)
+	for _, tok := range tokens {
+		switch {
+		case tok.Kind == Punct && (tok.Text == "(" || tok.Text == "["):
+			depth++
+		case tok.Kind == Punct && (tok.Text == ")" || tok.Text == "]"):
+			if depth > 0 {
+				depth--
+			}
+		case tok.Kind == Pipe && depth == 0:
+			stages = append(stages, current)
+			current = nil
+			continue
+		}
+		current = append(current, tok)
+	}
+	if len(current) > 0 || len(stages) > 0 {
+		stages = append(stages, current)
+	}
+	return stages
+}
+
+// Join renders tokens with canonical spacing: a single space between words,
+// none inside brackets or before commas. Comments are skipped.
+func Join(tokens []Token) string {
+	var (
+		b    strings.Builder
+		prev *Token
+	)
+	for i := range tokens {
+		tok := tokens[i]
+		if tok.Kind == Comment {
+			continue
+		}
+		if prev != nil && needsSpace(*prev, tok) {
+			b.WriteByte(' ')
+		}
+		b.WriteString(tok.Text)
+		prev = &tokens[i]
+	}
+	return b.String()
+}
+
+// Code returns the stage tokens without comments.
+func Code(stage []Token) []Token {
+	out := make([]Token, 0, len(stage))
+	for _, tok := range stage {
+		if tok.Kind != Comment {
+			out = append(out, tok)
+		}
+	}
+	return out
+}
+
+func needsSpace(prev, next Token) bool {
+	if next.Kind == Punct {
+		switch next.Text {
+		case ",", ")", "]", ".", ":":
+			return false
+		case "(":
+			return prev.Kind != Ident || isKeyword(prev.Text)
+		case "[":
+			return prev.Kind != Ident && prev.Text != ")"
+		}
+	}
+	if prev.Kind == Punct {
+		switch prev.Text {
+		case "(", "[", ".", "!":
+			return false
+		}
+	}
+	return true
+}
+
+// isKeyword reports whether word is an infix keyword that is followed by a
+// parenthesized operand rather than being a function call.
+func isKeyword(word string) bool {
+	switch strings.ToLower(word) {
+	case "between", "in", "and", "or", "by", "on", "has_any", "has_all":
+		return true
+	default:
+		return false
+	}
+}
+
+func scanString(src string, start int) int {
+	quote := src[start]
+	i := start + 1
+	for i < len(src) {
+		switch src[i] {
+		case '\\':
+			i += 2
+			continue
+		case quote:
+			return i + 1
+		}
+		i++
+	}
+	return len(src)
+}
+
+func isDigit(c byte) bool { return c >= '0' && c <= '9' }
+
+func isIdentStart(c byte) bool {
+	return c == '_' || c == '$' || c >= 0x80 || unicode.IsLetter(rune(c))
+}
+
+func isIdentByte(c byte) bool { return isIdentStart(c) || isDigit(c) }
+
+// isOperatorWord reports whether a hyphenated operator (project-away,
+// mv-expand, ...) may continue after word.
+func isOperatorWord(word string) bool {
+	switch word {
+	case "project", "mv", "parse", "make", "extend":
+		return true
+	default:
+		return false
+	}
+}
+
+func isCompound(op string) bool {
+	switch op {
+	case "==", "!=", ">=", "<=", "=~", "!~", "..":
+		return true
+	default:
+		return false
+	}
+}
@@ -0,0 +1,332 @@
+// Package atrest encrypts what axiom-fs writes to disk, the disk cache and
+// spilled results, with AES-256-GCM. Whole blobs are sealed in one piece;
+// spill files are sealed in chunks so they can be read at any offset
+// without decrypting what comes before.
+//
+// A nil *Sealer leaves data in plaintext.
+package atrest
+
+import (
+	"bytes"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/binary"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"sync"
+)
+
+// KeySize is the size of an AES-256 key.
+const KeySize = 32
+
+// chunkSize is the plaintext size of each sealed chunk of a spill file.
+const chunkSize = 64 << 10
+
+// magic starts every sealed blob and file, so data written with
+// encryption off, or on, is never mistaken for the other.
+var magic = []byte("AXFSENC1")
+
+// ErrSealed is returned when sealed data is read without a key.
+var ErrSealed = errors.New("data is encrypted but no encryption key is configured")
+
+// ErrCorrupt is returned when sealed data fails authentication: it was
+// written with another key, truncated or modified.
+var ErrCorrupt = errors.New("encrypted data is corrupt or was sealed with another key")
+
+// Sealer encrypts and decrypts with one key.
+type Sealer struct {
+	aead cipher.AEAD
+}
+
+// New returns a Sealer for a KeySize-byte key.
+func New(key []byte) (*Sealer, error) {
+	if len(key) != KeySize {
+		return nil, fmt.Errorf("encryption key must be %d bytes, got %d", KeySize, len(key))
+	}
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	aead, err := cipher.NewGCM(block)
+	if err != nil {
+		return nil, err
+	}
+	return &Sealer{aead: aead}, nil
+}
+
+// ParseKey decodes a key written as hex or standard base64.
+func ParseKey(s string) ([]byte, error) {
+	s = strings.TrimSpace(s)
+	if key, err := hex.DecodeString(s); err == nil && len(key) == KeySize {
+		return key, nil
+	}
+	if key, err := base64.StdEncoding.DecodeString(s); err == nil && len(key) == KeySize {
+		return key, nil
+	}
+	return nil, fmt.Errorf("encryption key must be %d bytes in hex or base64", KeySize)
+}
+
+// Load returns the Sealer for a key given inline or in keyFile, inline
+// winning. With neither it returns nil: no encryption.
+func Load(key, keyFile string) (*Sealer, error) {
+	if key == "" && keyFile != "" {
+		data, err := os.ReadFile(keyFile)
+		if err != nil {
+			return nil, fmt.Errorf("read encryption key: %w", err)
+		}
+		key = string(data)
+	}
+	if key == "" {
+		return nil, nil
+	}
+	raw, err := ParseKey(key)
+	if err != nil {
+		return nil, err
+	}
+	return New(raw)
+}
+
+// Enabled reports whether s encrypts.
+func (s *Sealer) Enabled() bool { return s != nil }
+
+// IsSealed reports whether data was written by Seal.
+func IsSealed(data []byte) bool { return bytes.HasPrefix(data, magic) }
+
+// Seal encrypts plain as one blob. Without a key it returns plain.
+func (s *Sealer) Seal(plain []byte) ([]byte, error) {
+	if s == nil {
+		return plain, nil
+	}
+	nonce := make([]byte, s.aead.NonceSize())
+	if _, err := rand.Read(nonce); err != nil {
+		return nil, err
+	}
+	out := make([]byte, 0, len(magic)+len(nonce)+len(plain)+s.aead.Overhead())
+	out = append(append(out, magic...), nonce...)
+	return s.aead.Seal(out, nonce, plain, magic), nil
+}
+
+// Open decrypts a blob written by Seal. Without a key it returns data as
+// is, unless data is sealed; with one, plaintext data fails.
+func (s *Sealer) Open(data []byte) ([]byte, error) {
+	if s == nil {
+		if IsSealed(data) {
+			return nil, ErrSealed
+		}
+		return data, nil
+	}
+	if !IsSealed(data) || len(data) < len(magic)+s.aead.NonceSize() {
+		return nil, ErrCorrupt
+	}
+	rest := data[len(magic):]
+	nonce, sealed := rest[:s.aead.NonceSize()], rest[s.aead.NonceSize():]
+	plain, err := s.aead.Open(nil, nonce, sealed, magic)
+	if err != nil {
+		return nil, ErrCorrupt
+	}
+	return plain, nil
+}
+
+// chunkNonce derives chunk i's nonce from the file's base nonce.
+func chunkNonce(base []byte, i int64) []byte {
+	nonce := bytes.Clone(base)
+	tail := nonce[len(nonce)-8:]
+	binary.BigEndian.PutUint64(tail, binary.BigEndian.Uint64(tail)^uint64(i))
+	return nonce
+}
+
+// chunkAD binds a chunk to its position and marks the last one, so chunks
+// cannot be reordered and the file cannot be cut at a chunk boundary.
+func chunkAD(i int64, last bool) []byte {
+	ad := make([]byte, 9)
+	binary.BigEndian.PutUint64(ad, uint64(i))
+	if last {
+		ad[8] = 1
+	}
+	return ad
+}
+
+// Writer seals what is written to a spill file in chunks. Close flushes
+// the last chunk; it does not close the file.
+type Writer struct {
+	s     *Sealer
+	w     io.Writer
+	nonce []byte
+	buf   []byte
+	index int64
+	err   error
+}
+
+// NewWriter starts a sealed file on w.
+func (s *Sealer) NewWriter(w io.Writer) (*Writer, error) {
+	nonce := make([]byte, s.aead.NonceSize())
+	if _, err := rand.Read(nonce); err != nil {
+		return nil, err
+	}
+	if _, err := w.Write(append(bytes.Clone(magic), nonce...)); err != nil {
+		return nil, err
+	}
+	return &Writer{s: s, w: w, nonce: nonce, buf: make([]byte, 0, chunkSize)}, nil
+}
+
+func (w *Writer) Write(p []byte) (int, error) {
+	if w.err != nil {
+		return 0, w.err
+	}
+	n := 0
+	for len(p) > 0 {
+		// A full chunk is held back until more data arrives, since only
+		// Close knows which chunk is the last.
+		if len(w.buf) == chunkSize {
+			if w.err = w.flush(false); w.err != nil {
+				return n, w.err
+			}
+		}
+		k := min(chunkSize-len(w.buf), len(p))
+		w.buf = append(w.buf, p[:k]...)
+		p = p[k:]
+		n += k
+	}
+	return n, nil
+}
+
+func (w *Writer) flush(last bool) error {
+	sealed := w.s.aead.Seal(nil, chunkNonce(w.nonce, w.index), w.buf, chunkAD(w.index, last))
+	if _, err := w.w.Write(sealed); err != nil {
+		return err
+	}
+	w.index++
+	w.buf = w.buf[:0]
+	return nil
+}
+
+// Close seals the last chunk.
+func (w *Writer) Close() error {
+	if w.err != nil {
+		return w.err
+	}
+	if err := w.flush(true); err != nil {
+		w.err = err
+		return err
+	}
+	w.err = os.ErrClosed
+	return nil
+}
+
+// File reads a spill file written by a Writer, decrypting the chunks it
+// touches.
+type File struct {
+	s     *Sealer
+	file  *os.File
+	nonce []byte
+	size  int64
+
+	mu     sync.Mutex
+	offset int64
+	// chunk caches the last decrypted chunk for sequential reads.
+	chunk      []byte
+	chunkIndex int64
+}
+
+// OpenFile reads file, holding size bytes of plaintext, as written by a
+// Writer.
+func (s *Sealer) OpenFile(file *os.File, size int64) (*File, error) {
+	header := make([]byte, len(magic)+s.aead.NonceSize())
+	if _, err := file.ReadAt(header, 0); err != nil || !IsSealed(header) {
+		return nil, ErrCorrupt
+	}
+	return &File{s: s, file: file, nonce: header[len(magic):], size: size, chunkIndex: -1}, nil
+}
+
+func (f *File) Name() string { return f.file.Name() }
+
+// load returns chunk i in plaintext. f.mu is held.
+func (f *File) load(i int64) ([]byte, error) {
+	if i == f.chunkIndex {
+		return f.chunk, nil
+	}
+	chunks := (f.size + chunkSize - 1) / chunkSize
+	plain := min(chunkSize, f.size-i*chunkSize)
+	overhead := int64(f.s.aead.Overhead())
+	sealed := make([]byte, plain+overhead)
+	off := int64(len(magic)+len(f.nonce)) + i*(chunkSize+overhead)
+	if _, err := f.file.ReadAt(sealed, off); err != nil {
+		return nil, ErrCorrupt
+	}
+	chunk, err := f.s.aead.Open(sealed[:0], chunkNonce(f.nonce, i), sealed, chunkAD(i, i == chunks-1))
+	if err != nil {
+		return nil, ErrCorrupt
+	}
+	f.chunk, f.chunkIndex = chunk, i
+	return chunk, nil
+}
+
+func (f *File) ReadAt(p []byte, off int64) (int, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return f.readAt(p, off)
+}
+
+func (f *File) readAt(p []byte, off int64) (int, error) {
+	if off < 0 {
+		return 0, errors.New("atrest: negative offset")
+	}
+	n := 0
+	for n < len(p) && off < f.size {
+		chunk, err := f.load(off / chunkSize)
+		if err != nil {
+			return n, err
+		}
+		k := copy(p[n:], chunk[off%chunkSize:])
+		n += k
+		off += int64(k)
+	}
+	if n < len(p) {
+		return n, io.EOF
+	}
+	return n, nil
+}
+
+func (f *File) Read(p []byte) (int, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if f.offset >= f.size {
+		return 0, io.EOF
+	}
+	n, err := f.readAt(p, f.offset)
+	f.offset += int64(n)
+	if err == io.EOF && n > 0 {
+		err = nil
+	}
+	return n, err
+}
+
+func (f *File) Seek(offset int64, whence int) (int64, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	switch whence {
+	case io.SeekStart:
+	case io.SeekCurrent:
+		offset += f.offset
+	case io.SeekEnd:
+		offset += f.size
+	default:
+		return 0, errors.New("atrest: invalid whence")
+	}
+	if offset < 0 {
+		return 0, errors.New("atrest: negative offset")
+	}
+	f.offset = offset
+	return offset, nil
+}
+
+// Close closes the file underneath; the caller removes it.
+func (f *File) Close() error {
+	return f.file.Close()
+}
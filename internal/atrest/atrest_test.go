@@ -0,0 +1,142 @@
+package atrest
+
+import (
+	"bytes"
+	"crypto/rand"
+	"errors"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func testSealer(t *testing.T) *Sealer {
+	t.Helper()
+	key := make([]byte, KeySize)
+	if _, err := rand.Read(key); err != nil {
+		t.Fatal(err)
+	}
+	s, err := New(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return s
+}
+
+func TestSealOpen(t *testing.T) {
+	s := testSealer(t)
+	plain := []byte(`{"message":"secret"}`)
+	sealed, err := s.Seal(plain)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if bytes.Contains(sealed, []byte("secret")) || !IsSealed(sealed) {
+		t.Fatalf("sealed blob = %q", sealed)
+	}
+	got, err := s.Open(sealed)
+	if err != nil || !bytes.Equal(got, plain) {
+		t.Fatalf("Open = %q, %v", got, err)
+	}
+
+	if _, err := testSealer(t).Open(sealed); !errors.Is(err, ErrCorrupt) {
+		t.Errorf("Open with another key: %v", err)
+	}
+	if _, err := s.Open(plain); !errors.Is(err, ErrCorrupt) {
+		t.Errorf("Open of plaintext: %v", err)
+	}
+	var none *Sealer
+	if _, err := none.Open(sealed); !errors.Is(err, ErrSealed) {
+		t.Errorf("Open without a key: %v", err)
+	}
+	if got, err := none.Seal(plain); err != nil || !bytes.Equal(got, plain) {
+		t.Errorf("Seal without a key = %q, %v", got, err)
+	}
+}
+
+func TestLoad(t *testing.T) {
+	if s, err := Load("", ""); err != nil || s.Enabled() {
+		t.Errorf("Load without a key = %v, %v", s, err)
+	}
+	file := filepath.Join(t.TempDir(), "key")
+	if err := os.WriteFile(file, []byte(strings.Repeat("ab", KeySize)+"\n"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	if s, err := Load("", file); err != nil || !s.Enabled() {
+		t.Errorf("Load hex key file = %v, %v", s, err)
+	}
+	if _, err := Load("c2hvcnQ=", ""); err == nil {
+		t.Error("Load accepted a short key")
+	}
+}
+
+func TestFile(t *testing.T) {
+	s := testSealer(t)
+	for _, size := range []int{1, chunkSize - 1, chunkSize, 3*chunkSize + 17} {
+		plain := make([]byte, size)
+		_, _ = rand.Read(plain)
+
+		f, err := os.CreateTemp(t.TempDir(), "spill-*")
+		if err != nil {
+			t.Fatal(err)
+		}
+		w, err := s.NewWriter(f)
+		if err != nil {
+			t.Fatal(err)
+		}
+		// Uneven writes cross chunk boundaries.
+		for rest := plain; len(rest) > 0; {
+			n := min(len(rest), 1000)
+			if _, err := w.Write(rest[:n]); err != nil {
+				t.Fatal(err)
+			}
+			rest = rest[n:]
+		}
+		if err := w.Close(); err != nil {
+			t.Fatal(err)
+		}
+
+		r, err := s.OpenFile(f, int64(size))
+		if err != nil {
+			t.Fatal(err)
+		}
+		got, err := io.ReadAll(r)
+		if err != nil || !bytes.Equal(got, plain) {
+			t.Fatalf("size %d: ReadAll = %d bytes, %v", size, len(got), err)
+		}
+		off := int64(size / 2)
+		buf := make([]byte, size-int(off))
+		if n, err := r.ReadAt(buf, off); n != len(buf) || (err != nil && err != io.EOF) || !bytes.Equal(buf, plain[off:]) {
+			t.Errorf("size %d: ReadAt(%d) = %d, %v", size, off, n, err)
+		}
+		if _, err := r.Seek(0, io.SeekStart); err != nil {
+			t.Fatal(err)
+		}
+		if got, _ := io.ReadAll(r); !bytes.Equal(got, plain) {
+			t.Errorf("size %d: reread after Seek differs", size)
+		}
+		r.Close()
+	}
+}
+
+func TestFileTruncated(t *testing.T) {
+	s := testSealer(t)
+	f, err := os.CreateTemp(t.TempDir(), "spill-*")
+	if err != nil {
+		t.Fatal(err)
+	}
+	w, _ := s.NewWriter(f)
+	plain := make([]byte, 2*chunkSize+1)
+	_, _ = w.Write(plain)
+	_ = w.Close()
+
+	// Claiming the file ends after its second chunk must not authenticate.
+	r, err := s.OpenFile(f, 2*chunkSize)
+	if err != nil {
+		t.Fatal(err)
+	}
+	buf := make([]byte, 10)
+	if _, err := r.ReadAt(buf, chunkSize+5); !errors.Is(err, ErrCorrupt) {
+		t.Errorf("ReadAt in a chunk read as the last: %v", err)
+	}
+}
@@ -0,0 +1,165 @@
+// Package automount mounts the server's own NFS export on the local
+// machine, for `axiom-fs serve -auto-mount <dir>`, with the mount options
+// each OS needs, and unmounts it again at shutdown.
+package automount
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"os"
+	"os/exec"
+	"runtime"
+	"strings"
+	"time"
+)
+
+// Overridden in tests.
+var (
+	goos       = runtime.GOOS
+	geteuid    = os.Geteuid
+	run        = runCommand
+	retryDelay = time.Second
+)
+
+const (
+	// attempts is how often the mount command is tried before giving up.
+	attempts = 5
+	// dialTimeout bounds the wait for the server to accept connections.
+	dialTimeout = 10 * time.Second
+)
+
+// Command returns the command mounting the export served on addr, a TCP
+// host:port, at dir on goos. It needs root on Linux; see Mount.
+func Command(goos, addr, dir string) ([]string, error) {
+	host, port, err := target(addr)
+	if err != nil {
+		return nil, err
+	}
+	switch goos {
+	case "darwin":
+		// mount_nfs runs as the user: noresvport lets it connect from an
+		// unprivileged port, and locks stay local since the server has
+		// no lock manager.
+		opts := fmt.Sprintf("vers=3,tcp,port=%s,mountport=%s,noresvport,nolocks,locallocks", port, port)
+		return []string{"mount_nfs", "-o", opts, host + ":/", dir}, nil
+	case "linux":
+		// nolock keeps mount.nfs from requiring rpc.statd.
+		opts := fmt.Sprintf("vers=3,tcp,port=%s,mountport=%s,nolock", port, port)
+		return []string{"mount", "-t", "nfs", "-o", opts, host + ":/", dir}, nil
+	default:
+		return nil, fmt.Errorf("automount: mounting is not supported on %s", goos)
+	}
+}
+
+// target returns the host and port to mount addr from. An unspecified host
+// is reached over loopback, and IPv6 hosts are bracketed.
+func target(addr string) (host, port string, err error) {
+	host, port, err = net.SplitHostPort(addr)
+	if err != nil {
+		return "", "", fmt.Errorf("automount: %w", err)
+	}
+	if ip := net.ParseIP(host); host == "" || ip != nil && ip.IsUnspecified() {
+		host = "127.0.0.1"
+	}
+	if strings.Contains(host, ":") {
+		host = "[" + host + "]"
+	}
+	return host, port, nil
+}
+
+// Mounted is the export mounted at Dir.
+type Mounted struct {
+	Dir string
+}
+
+// Mount mounts the export served on addr at dir, creating dir if needed.
+// It waits for addr to accept connections, then tries the mount command a
+// few times, since a server that just started may not answer at once. On
+// Linux, commands are run with sudo unless axiom-fs runs as root.
+func Mount(ctx context.Context, addr, dir string) (*Mounted, error) {
+	argv, err := Command(goos, addr, dir)
+	if err != nil {
+		return nil, err
+	}
+	host, port, _ := target(addr)
+	if err := waitListening(ctx, net.JoinHostPort(strings.Trim(host, "[]"), port)); err != nil {
+		return nil, err
+	}
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		if err := run(ctx, privileged([]string{"mkdir", "-p", dir})); err != nil {
+			return nil, fmt.Errorf("automount: create %s: %w", dir, err)
+		}
+	}
+	for attempt := 1; ; attempt++ {
+		err = run(ctx, privileged(argv))
+		if err == nil {
+			return &Mounted{Dir: dir}, nil
+		}
+		if attempt == attempts {
+			return nil, fmt.Errorf("automount: mount %s: %w", dir, err)
+		}
+		select {
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		case <-time.After(retryDelay):
+		}
+	}
+}
+
+// Unmount unmounts the export. A busy mount is detached instead (lazily on
+// Linux, forcibly on macOS) so shutdown is not held up by open files.
+func (m *Mounted) Unmount(ctx context.Context) error {
+	err := run(ctx, privileged([]string{"umount", m.Dir}))
+	if err == nil {
+		return nil
+	}
+	force := "-f"
+	if goos == "linux" {
+		force = "-l"
+	}
+	if ferr := run(ctx, privileged([]string{"umount", force, m.Dir})); ferr != nil {
+		return fmt.Errorf("automount: unmount %s: %w", m.Dir, errors.Join(err, ferr))
+	}
+	return nil
+}
+
+// privileged prefixes argv with sudo where mounting needs root.
+func privileged(argv []string) []string {
+	if goos != "linux" || geteuid() == 0 {
+		return argv
+	}
+	return append([]string{"sudo"}, argv...)
+}
+
+// waitListening waits for addr to accept TCP connections.
+func waitListening(ctx context.Context, addr string) error {
+	ctx, cancel := context.WithTimeout(ctx, dialTimeout)
+	defer cancel()
+	var dialer net.Dialer
+	for {
+		conn, err := dialer.DialContext(ctx, "tcp", addr)
+		if err == nil {
+			return conn.Close()
+		}
+		select {
+		case <-ctx.Done():
+			return fmt.Errorf("automount: %s is not accepting connections: %w", addr, err)
+		case <-time.After(100 * time.Millisecond):
+		}
+	}
+}
+
+// runCommand runs argv, returning its output in the error if it fails.
+func runCommand(ctx context.Context, argv []string) error {
+	out, err := exec.CommandContext(ctx, argv[0], argv[1:]...).CombinedOutput()
+	if err != nil {
+		if out = bytes.TrimSpace(out); len(out) > 0 {
+			return fmt.Errorf("%s: %w: %s", strings.Join(argv, " "), err, out)
+		}
+		return fmt.Errorf("%s: %w", strings.Join(argv, " "), err)
+	}
+	return nil
+}
@@ -0,0 +1,106 @@
+package automount
+
+import (
+	"context"
+	"errors"
+	"net"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestCommand(t *testing.T) {
+	cases := []struct {
+		goos, addr string
+		want       []string
+	}{
+		{"darwin", "127.0.0.1:2049", []string{"mount_nfs", "-o", "vers=3,tcp,port=2049,mountport=2049,noresvport,nolocks,locallocks", "127.0.0.1:/", "/mnt/axiom"}},
+		{"linux", ":2049", []string{"mount", "-t", "nfs", "-o", "vers=3,tcp,port=2049,mountport=2049,nolock", "127.0.0.1:/", "/mnt/axiom"}},
+		{"linux", "0.0.0.0:2050", []string{"mount", "-t", "nfs", "-o", "vers=3,tcp,port=2050,mountport=2050,nolock", "127.0.0.1:/", "/mnt/axiom"}},
+		{"linux", "[::1]:2049", []string{"mount", "-t", "nfs", "-o", "vers=3,tcp,port=2049,mountport=2049,nolock", "[::1]:/", "/mnt/axiom"}},
+	}
+	for _, tc := range cases {
+		got, err := Command(tc.goos, tc.addr, "/mnt/axiom")
+		if err != nil || !reflect.DeepEqual(got, tc.want) {
+			t.Errorf("Command(%s, %s) = %q, %v; want %q", tc.goos, tc.addr, got, err, tc.want)
+		}
+	}
+	if _, err := Command("windows", "127.0.0.1:2049", `C:\axiom`); err == nil {
+		t.Error("expected error for an unsupported OS")
+	}
+	if _, err := Command("linux", "/run/axiom-fs.sock", "/mnt/axiom"); err == nil {
+		t.Error("expected error for a non-TCP address")
+	}
+}
+
+// fake replaces the OS and the commands run for a test, recording them and
+// failing those fail returns an error for.
+func fake(t *testing.T, os string, euid int, fail func(argv []string) error) *[]string {
+	t.Helper()
+	var ran []string
+	prevGOOS, prevEUID, prevRun, prevDelay := goos, geteuid, run, retryDelay
+	t.Cleanup(func() { goos, geteuid, run, retryDelay = prevGOOS, prevEUID, prevRun, prevDelay })
+	goos, geteuid, retryDelay = os, func() int { return euid }, 0
+	run = func(ctx context.Context, argv []string) error {
+		ran = append(ran, strings.Join(argv, " "))
+		return fail(argv)
+	}
+	return &ran
+}
+
+func TestMount(t *testing.T) {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer l.Close()
+	go func() {
+		for {
+			conn, err := l.Accept()
+			if err != nil {
+				return
+			}
+			conn.Close()
+		}
+	}()
+	_, port, _ := net.SplitHostPort(l.Addr().String())
+	dir := filepath.Join(t.TempDir(), "axiom")
+
+	failures := 2
+	ran := fake(t, "linux", 1000, func(argv []string) error {
+		if argv[1] == "mount" && failures > 0 {
+			failures--
+			return errors.New("connection refused")
+		}
+		return nil
+	})
+	m, err := Mount(context.Background(), ":"+port, dir)
+	if err != nil {
+		t.Fatalf("Mount: %v", err)
+	}
+	want := "sudo mount -t nfs -o vers=3,tcp,port=" + port + ",mountport=" + port + ",nolock 127.0.0.1:/ " + dir
+	if len(*ran) != 3 || (*ran)[2] != want {
+		t.Errorf("ran %q, want three tries of %q", *ran, want)
+	}
+
+	*ran = nil
+	if err := m.Unmount(context.Background()); err != nil || !reflect.DeepEqual(*ran, []string{"sudo umount " + dir}) {
+		t.Errorf("Unmount ran %q: %v", *ran, err)
+	}
+
+	ran = fake(t, "darwin", 1000, func(argv []string) error {
+		if reflect.DeepEqual(argv, []string{"umount", dir}) {
+			return errors.New("resource busy")
+		}
+		return nil
+	})
+	if err := m.Unmount(context.Background()); err != nil || !reflect.DeepEqual(*ran, []string{"umount " + dir, "umount -f " + dir}) {
+		t.Errorf("busy Unmount ran %q: %v", *ran, err)
+	}
+
+	fake(t, "linux", 0, func(argv []string) error { return errors.New("permission denied") })
+	if _, err := Mount(context.Background(), ":"+port, dir); err == nil || !strings.Contains(err.Error(), "permission denied") {
+		t.Errorf("failing Mount = %v", err)
+	}
+}
@@ -12,6 +12,8 @@ import (
 	"time"
 
 	"github.com/BurntSushi/toml"
+
+	"github.com/axiomhq/axiom-fs/internal/atrest"
 )
 
 // Field represents a field in a dataset.
@@ -31,19 +33,50 @@ type Dataset struct {
 	Description string `json:"description,omitempty"`
 }
 
+// DatasetStats represents storage and ingest activity for a dataset.
+type DatasetStats struct {
+	Name            string    `json:"name"`
+	NumEvents       int64     `json:"numEvents"`
+	InputBytes      int64     `json:"inputBytes"`
+	CompressedBytes int64     `json:"compressedBytes"`
+	MinTime         time.Time `json:"minTime"`
+	MaxTime         time.Time `json:"maxTime"`
+}
+
 // QueryResult represents the result of an APL query.
 type QueryResult struct {
 	Tables []QueryTable `json:"tables"`
 	Status QueryStatus  `json:"status"`
+	// QueryID is the query history ID reported by Axiom, if any.
+	QueryID string `json:"-"`
 }
 
-// QueryTable represents a table in query results.
+// QueryTable represents a table in query results. Numbers in Columns are
+// json.Number, so int64 IDs and nanosecond timestamps keep every digit.
 type QueryTable struct {
 	Name    string       `json:"name"`
 	Fields  []QueryField `json:"fields"`
 	Columns [][]any      `json:"columns"`
 }
 
+// Float64 returns a numeric result value as a float64: a json.Number as
+// decoded from Axiom, or a float64 or int built by hand.
+func Float64(v any) (float64, bool) {
+	switch n := v.(type) {
+	case json.Number:
+		f, err := n.Float64()
+		return f, err == nil
+	case float64:
+		return n, true
+	case int64:
+		return float64(n), true
+	case int:
+		return float64(n), true
+	default:
+		return 0, false
+	}
+}
+
 // QueryField represents a field in query results.
 type QueryField struct {
 	Name        string       `json:"name"`
@@ -64,6 +97,56 @@ type QueryStatus struct {
 	BlocksExamined int64 `json:"blocksExamined"`
 	RowsExamined   int64 `json:"rowsExamined"`
 	RowsMatched    int64 `json:"rowsMatched"`
+	// IsPartial is set when Axiom stopped before returning every matching
+	// row; MinCursor and MaxCursor bound the rows it did return, when the
+	// query asked for cursors.
+	IsPartial         bool           `json:"isPartial,omitempty"`
+	ContinuationToken string         `json:"continuationToken,omitempty"`
+	MinCursor         string         `json:"minCursor,omitempty"`
+	MaxCursor         string         `json:"maxCursor,omitempty"`
+	Messages          []QueryMessage `json:"messages,omitempty"`
+}
+
+// QueryMessage is a warning or error Axiom attached to a result.
+type QueryMessage struct {
+	Priority string `json:"priority"`
+	Count    int64  `json:"count"`
+	Code     string `json:"code"`
+	Msg      string `json:"msg"`
+}
+
+// limitCodes are the message codes Axiom sends when a limit cut a result
+// short.
+var limitCodes = map[string]bool{
+	"default_limit_warning":           true,
+	"license_limit_for_query_warning": true,
+}
+
+// Truncated reports whether Axiom said the result lacks matching rows:
+// it is partial, or a default or license limit applied.
+func (s QueryStatus) Truncated() bool {
+	if s.IsPartial {
+		return true
+	}
+	for _, m := range s.Messages {
+		if limitCodes[m.Code] {
+			return true
+		}
+	}
+	return false
+}
+
+// Warnings returns the text of the result's messages.
+func (s QueryStatus) Warnings() []string {
+	var out []string
+	for _, m := range s.Messages {
+		text := m.Msg
+		if text == "" {
+			text = m.Code
+		}
+		out = append(out, text)
+	}
+	return out
 }
 
 // User represents the current authenticated user.
@@ -73,11 +156,15 @@ type User struct {
 	Email string `json:"email"`
 }
 
+// queryIDHeader carries the query history ID on APL responses.
+const queryIDHeader = "X-Axiom-History-Query-Id"
+
 // API defines the interface for Axiom API operations.
 type API interface {
 	CurrentUser(ctx context.Context) (*User, error)
 	ListDatasets(ctx context.Context) ([]Dataset, error)
 	ListFields(ctx context.Context, datasetID string) ([]Field, error)
+	DatasetStats(ctx context.Context) ([]DatasetStats, error)
 	QueryAPL(ctx context.Context, apl string) (*QueryResult, error)
 }
 
@@ -85,8 +172,13 @@ type API interface {
 type Client struct {
 	httpClient *http.Client
 	baseURL    string
-	token      string
+	tokens     TokenSource
 	orgID      string
+
+	compression bool
+	transfer    transferCounters
+	spoolDir    string
+	spoolSealer *atrest.Sealer
 }
 
 type axiomConfig struct {
@@ -125,23 +217,45 @@ func loadAxiomTOML() (url, token, orgID string) {
 }
 
 // New creates a new Axiom API client.
-func New(baseURL, token, orgID string) (*Client, error) {
+func New(baseURL, token, orgID string, opts ...Option) (*Client, error) {
 	if baseURL == "" {
 		baseURL = "https://api.axiom.co"
 	}
-	if token == "" {
-		return nil, fmt.Errorf("axiom token is required")
+	o := options{maxIdleConnsPerHost: DefaultMaxIdleConnsPerHost}
+	for _, opt := range opts {
+		opt(&o)
+	}
+	tokens := o.tokenSource
+	if tokens == nil {
+		if token == "" && o.replayMode != ReplayOnly {
+			return nil, fmt.Errorf("axiom token is required")
+		}
+		tokens = StaticToken(token)
+	}
+	base, err := newTransport(o)
+	if err != nil {
+		return nil, err
+	}
+	var transport http.RoundTripper = base
+	if o.replayDir != "" {
+		if transport, err = newReplayTransport(o.replayDir, o.replayMode, transport); err != nil {
+			return nil, err
+		}
 	}
 	return &Client{
-		httpClient: &http.Client{Timeout: 60 * time.Second},
+		httpClient: &http.Client{Timeout: 60 * time.Second, Transport: transport},
 		baseURL:    baseURL,
-		token:      token,
+		tokens:     tokens,
 		orgID:      orgID,
+
+		compression: !o.disableCompression,
+		spoolDir:    o.spoolDir,
+		spoolSealer: o.spoolSealer,
 	}, nil
 }
 
 // NewWithEnvOverrides creates a client with configuration from flags, env, and ~/.axiom.toml.
-func NewWithEnvOverrides(url, token, orgID string) (*Client, error) {
+func NewWithEnvOverrides(url, token, orgID string, opts ...Option) (*Client, error) {
 	var (
 		envURL   = os.Getenv("AXIOM_URL")
 		envToken = os.Getenv("AXIOM_TOKEN")
@@ -171,23 +285,42 @@ func NewWithEnvOverrides(url, token, orgID string) (*Client, error) {
 		orgID = tomlOrg
 	}
 
-	return New(url, token, orgID)
+	return New(url, token, orgID, opts...)
 }
 
-func (c *Client) doRequest(ctx context.Context, method, path string, body io.Reader) (*http.Response, error) {
+// BaseURL is the API base URL the client sends requests to.
+func (c *Client) BaseURL() string { return c.baseURL }
+
+// OrgID is the org the client acts for, if any.
+func (c *Client) OrgID() string { return c.orgID }
+
+func (c *Client) newRequest(ctx context.Context, method, path string, body io.Reader) (*http.Request, error) {
 	req, err := http.NewRequestWithContext(ctx, method, c.baseURL+path, body)
 	if err != nil {
 		return nil, err
 	}
-	req.Header.Set("Authorization", "Bearer "+c.token)
+	token, err := c.tokens.Token(ctx)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Authorization", "Bearer "+token)
 	req.Header.Set("Content-Type", "application/json")
 	if c.orgID != "" {
 		req.Header.Set("X-Axiom-Org-ID", c.orgID)
 	}
+	return req, nil
+}
+
+func (c *Client) doRequest(ctx context.Context, method, path string, body io.Reader) (*http.Response, error) {
+	req, err := c.newRequest(ctx, method, path, body)
+	if err != nil {
+		return nil, err
+	}
 	return c.httpClient.Do(req)
 }
 
-type apiError struct {
+// errorBody is the JSON body of an API error response.
+type errorBody struct {
 	Code    int    `json:"code"`
 	Message string `json:"message"`
 }
@@ -198,11 +331,13 @@ func (c *Client) checkResponse(resp *http.Response) error {
 	}
 	defer resp.Body.Close()
 	body, _ := io.ReadAll(resp.Body)
-	var apiErr apiError
-	if json.Unmarshal(body, &apiErr) == nil && apiErr.Message != "" {
-		return fmt.Errorf("axiom API error %d: %s", apiErr.Code, apiErr.Message)
+	apiErr := &APIError{StatusCode: resp.StatusCode}
+	var parsed errorBody
+	if json.Unmarshal(body, &parsed) == nil && parsed.Message != "" {
+		apiErr.Code = parsed.Code
+		apiErr.Message = parsed.Message
 	}
-	return fmt.Errorf("axiom API error: status %d", resp.StatusCode)
+	return apiErr
 }
 
 // CurrentUser returns the authenticated user.
@@ -256,27 +391,104 @@ func (c *Client) ListFields(ctx context.Context, datasetID string) ([]Field, err
 	return fields, nil
 }
 
+type statsResponse struct {
+	Datasets []DatasetStats `json:"datasets"`
+}
+
+// DatasetStats returns ingest activity for all datasets in a single call.
+func (c *Client) DatasetStats(ctx context.Context) ([]DatasetStats, error) {
+	resp, err := c.doRequest(ctx, http.MethodGet, "/v1/datasets/_stats", nil)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if err := c.checkResponse(resp); err != nil {
+		return nil, err
+	}
+	var stats statsResponse
+	if err := json.NewDecoder(resp.Body).Decode(&stats); err != nil {
+		return nil, err
+	}
+	return stats.Datasets, nil
+}
+
 type queryRequest struct {
 	APL string `json:"apl"`
+	// Cursor continues a partial result; IncludeCursor asks for the
+	// cursors to continue from.
+	Cursor        string `json:"cursor,omitempty"`
+	IncludeCursor bool   `json:"includeCursor,omitempty"`
 }
 
 // QueryAPL executes an APL query and returns the result.
 func (c *Client) QueryAPL(ctx context.Context, apl string) (*QueryResult, error) {
-	reqBody, err := json.Marshal(queryRequest{APL: apl})
+	return c.QueryAPLWithHeaders(ctx, apl, nil)
+}
+
+// QueryAPLWithHeaders is QueryAPL sending extra request headers, such as
+// traceparent or X-Request-ID, so Axiom's query logs can attribute the
+// query. Headers the client sets itself cannot be overridden.
+func (c *Client) QueryAPLWithHeaders(ctx context.Context, apl string, header http.Header) (*QueryResult, error) {
+	return c.query(ctx, queryRequest{APL: apl}, header)
+}
+
+// QueryAPLFrom is QueryAPLWithHeaders asking for cursors, and continuing
+// after cursor unless it is empty: the rows a partial result reported
+// through its Status.MinCursor lie beyond it.
+func (c *Client) QueryAPLFrom(ctx context.Context, apl, cursor string, header http.Header) (*QueryResult, error) {
+	return c.query(ctx, queryRequest{APL: apl, Cursor: cursor, IncludeCursor: true}, header)
+}
+
+func (c *Client) query(ctx context.Context, q queryRequest, header http.Header) (*QueryResult, error) {
+	body, queryID, err := c.postAPL(ctx, q, header)
 	if err != nil {
 		return nil, err
 	}
-	resp, err := c.doRequest(ctx, http.MethodPost, "/v1/datasets/_apl?format=tabular", bytes.NewReader(reqBody))
-	if err != nil {
+	defer body.Close()
+	var result QueryResult
+	dec := json.NewDecoder(body)
+	dec.UseNumber()
+	if err := dec.Decode(&result); err != nil {
 		return nil, err
 	}
-	defer resp.Body.Close()
+	result.QueryID = queryID
+	return &result, nil
+}
+
+// postAPL sends q for a tabular result and returns the decoded response
+// body and the query history ID.
+func (c *Client) postAPL(ctx context.Context, q queryRequest, header http.Header) (io.ReadCloser, string, error) {
+	reqBody, err := json.Marshal(q)
+	if err != nil {
+		return nil, "", err
+	}
+	req, err := c.newRequest(ctx, http.MethodPost, "/v1/datasets/_apl?format=tabular", bytes.NewReader(reqBody))
+	if err != nil {
+		return nil, "", err
+	}
+	if c.compression {
+		// Setting the header ourselves turns off net/http's transparent
+		// gzip, so decodeBody handles both encodings and counts the bytes.
+		req.Header.Set("Accept-Encoding", acceptEncoding)
+	}
+	for key, values := range header {
+		key = http.CanonicalHeaderKey(key)
+		if _, ok := req.Header[key]; ok || key == "Accept-Encoding" {
+			continue
+		}
+		req.Header[key] = values
+	}
+	resp, err := c.httpClient.Do(req)
+	if err != nil {
+		return nil, "", err
+	}
 	if err := c.checkResponse(resp); err != nil {
-		return nil, err
+		return nil, "", err
 	}
-	var result QueryResult
-	if err := json.NewDecoder(resp.Body).Decode(&result); err != nil {
-		return nil, err
+	body, err := c.decodeBody(resp)
+	if err != nil {
+		resp.Body.Close()
+		return nil, "", err
 	}
-	return &result, nil
+	return body, resp.Header.Get(queryIDHeader), nil
 }
@@ -1,14 +1,25 @@
 package axiomclient_test
 
 import (
+	"bytes"
+	"compress/gzip"
 	"context"
 	"encoding/json"
+	"encoding/pem"
+	"errors"
 	"net/http"
 	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"slices"
 	"strings"
+	"syscall"
 	"testing"
 	"time"
 
+	"github.com/klauspost/compress/zstd"
+
+	"github.com/axiomhq/axiom-fs/internal/atrest"
 	"github.com/axiomhq/axiom-fs/internal/axiomclient"
 )
 
@@ -106,6 +117,41 @@ func TestListFields(t *testing.T) {
 	}
 }
 
+func TestDatasetStats(t *testing.T) {
+	maxTime := time.Date(2024, 1, 15, 10, 30, 0, 0, time.UTC)
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/v1/datasets/_stats" {
+			t.Errorf("expected /v1/datasets/_stats, got %s", r.URL.Path)
+		}
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(map[string]any{
+			"datasets": []axiomclient.DatasetStats{
+				{Name: "logs", NumEvents: 42, InputBytes: 1024, MaxTime: maxTime},
+			},
+		})
+	}))
+	defer srv.Close()
+
+	client, err := axiomclient.New(srv.URL, "test-token", "test-org")
+	if err != nil {
+		t.Fatalf("New: %v", err)
+	}
+
+	got, err := client.DatasetStats(context.Background())
+	if err != nil {
+		t.Fatalf("DatasetStats: %v", err)
+	}
+	if len(got) != 1 {
+		t.Fatalf("expected 1 dataset, got %d", len(got))
+	}
+	if got[0].Name != "logs" || got[0].InputBytes != 1024 {
+		t.Errorf("unexpected stats: %+v", got[0])
+	}
+	if !got[0].MaxTime.Equal(maxTime) {
+		t.Errorf("MaxTime = %v, want %v", got[0].MaxTime, maxTime)
+	}
+}
+
 func TestQueryAPL(t *testing.T) {
 	result := axiomclient.QueryResult{
 		Tables: []axiomclient.QueryTable{
@@ -153,6 +199,7 @@ func TestQueryAPL(t *testing.T) {
 		capturedAPL = req.APL
 
 		w.Header().Set("Content-Type", "application/json")
+		w.Header().Set("X-Axiom-History-Query-Id", "q-123")
 		json.NewEncoder(w).Encode(result)
 	}))
 	defer srv.Close()
@@ -181,6 +228,483 @@ func TestQueryAPL(t *testing.T) {
 	if got.Status.RowsMatched != 2 {
 		t.Errorf("expected 2 rows matched, got %d", got.Status.RowsMatched)
 	}
+	if got.QueryID != "q-123" {
+		t.Errorf("expected query ID q-123, got %q", got.QueryID)
+	}
+}
+
+func TestQueryAPLWithHeaders(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if got := r.Header.Get("X-Axiom-Query-Label"); got != "/logs/q/result.csv" {
+			t.Errorf("label = %q", got)
+		}
+		if got := r.Header.Get("Traceparent"); got != "00-0af7651916cd43dd8448eb211c80319c-b7ad6b7169203331-01" {
+			t.Errorf("traceparent = %q", got)
+		}
+		if got := r.Header.Get("Authorization"); got != "Bearer test-token" {
+			t.Errorf("Authorization overridden: %q", got)
+		}
+		_ = json.NewEncoder(w).Encode(axiomclient.QueryResult{})
+	}))
+	defer srv.Close()
+
+	client, err := axiomclient.New(srv.URL, "test-token", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	header := http.Header{
+		"X-Axiom-Query-Label": {"/logs/q/result.csv"},
+		"traceparent":         {"00-0af7651916cd43dd8448eb211c80319c-b7ad6b7169203331-01"},
+		"Authorization":       {"Bearer other"},
+	}
+	if _, err := client.QueryAPLWithHeaders(context.Background(), "['logs']", header); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestQueryAPLNumbers(t *testing.T) {
+	body := `{"tables": [{"name": "r", "fields": [{"name": "id"}], "columns": [[1700000000123456789, 0.1, 9007199254740993]]}]}`
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_, _ = w.Write([]byte(body))
+	}))
+	defer srv.Close()
+	client, err := axiomclient.New(srv.URL, "test-token", "", axiomclient.WithSpoolDir(t.TempDir()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []any{json.Number("1700000000123456789"), json.Number("0.1"), json.Number("9007199254740993")}
+
+	result, err := client.QueryAPL(context.Background(), "['logs']")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := result.Tables[0].Columns[0]; !slices.Equal(got, want) {
+		t.Errorf("QueryAPL column = %#v, want %#v", got, want)
+	}
+
+	var got []any
+	_, err = client.QueryAPLRows(context.Background(), "['logs']", func(result *axiomclient.QueryResult, table int, row []any) error {
+		got = append(got, row[0])
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !slices.Equal(got, want) {
+		t.Errorf("QueryAPLRows column = %#v, want %#v", got, want)
+	}
+
+	if f, ok := axiomclient.Float64(want[1]); !ok || f != 0.1 {
+		t.Errorf("Float64(0.1) = %v, %v", f, ok)
+	}
+}
+
+func TestQueryAPLFrom(t *testing.T) {
+	var req struct {
+		APL           string `json:"apl"`
+		Cursor        string `json:"cursor"`
+		IncludeCursor bool   `json:"includeCursor"`
+	}
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			t.Errorf("failed to decode request: %v", err)
+		}
+		w.Header().Set("Content-Type", "application/json")
+		w.Write([]byte(`{"tables":[],"status":{"isPartial":true,"minCursor":"c-1","messages":[{"priority":"warn","code":"default_limit_warning","msg":"limit reached"}]}}`))
+	}))
+	defer srv.Close()
+
+	client, err := axiomclient.New(srv.URL, "test-token", "test-org")
+	if err != nil {
+		t.Fatalf("New: %v", err)
+	}
+	got, err := client.QueryAPLFrom(context.Background(), "['logs']", "c-0", nil)
+	if err != nil {
+		t.Fatalf("QueryAPLFrom: %v", err)
+	}
+	if req.Cursor != "c-0" || !req.IncludeCursor {
+		t.Errorf("request = %+v, want the cursor and includeCursor", req)
+	}
+	if !got.Status.IsPartial || got.Status.MinCursor != "c-1" || !got.Status.Truncated() {
+		t.Errorf("status = %+v", got.Status)
+	}
+	if w := got.Status.Warnings(); len(w) != 1 || w[0] != "limit reached" {
+		t.Errorf("Warnings() = %v", w)
+	}
+	if (axiomclient.QueryStatus{RowsMatched: 2}).Truncated() {
+		t.Error("complete status reported truncated")
+	}
+}
+
+func TestQueryAPLRows(t *testing.T) {
+	// Pretty-printed, with an unknown key, a nested value, a short column
+	// and the status after the tables.
+	body := `{
+  "format": "tabular",
+  "tables": [
+    {
+      "name": "result",
+      "fields": [{"name": "service", "type": "string"}, {"name": "attrs", "type": "object"}, {"name": "n", "type": "integer"}],
+      "columns": [
+        ["api", "web\nfront"],
+        [{"region": "eu",
+          "tags": ["a", "b"]}, null],
+        [3]
+      ]
+    },
+    {"name": "total", "fields": [{"name": "n"}], "columns": [[5]]}
+  ],
+  "status": {"rowsMatched": 2}
+}`
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("X-Axiom-History-Query-Id", "q-7")
+		_, _ = w.Write([]byte(body))
+	}))
+	defer srv.Close()
+
+	spool := t.TempDir()
+	client, err := axiomclient.New(srv.URL, "test-token", "", axiomclient.WithSpoolDir(spool))
+	if err != nil {
+		t.Fatal(err)
+	}
+	var rows []string
+	result, err := client.QueryAPLRows(context.Background(), "['logs']", func(result *axiomclient.QueryResult, table int, row []any) error {
+		data, _ := json.Marshal(row)
+		rows = append(rows, result.Tables[table].Name+" "+string(data))
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []string{
+		`result ["api",{"region":"eu","tags":["a","b"]},3]`,
+		`result ["web\nfront",null,null]`,
+		`total [5]`,
+	}
+	if strings.Join(rows, "\n") != strings.Join(want, "\n") {
+		t.Errorf("rows =\n%s\nwant\n%s", strings.Join(rows, "\n"), strings.Join(want, "\n"))
+	}
+	if result.QueryID != "q-7" || result.Status.RowsMatched != 2 || len(result.Tables) != 2 || len(result.Tables[0].Fields) != 3 || result.Tables[0].Columns != nil {
+		t.Errorf("result = %+v", result)
+	}
+	if entries, _ := os.ReadDir(spool); len(entries) != 0 {
+		t.Errorf("spool left behind: %v", entries)
+	}
+
+	stop := errors.New("stop")
+	_, err = client.QueryAPLRows(context.Background(), "['logs']", func(*axiomclient.QueryResult, int, []any) error { return stop })
+	if !errors.Is(err, stop) {
+		t.Errorf("RowFunc error = %v", err)
+	}
+}
+
+func TestQueryAPLRowsEncrypted(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_, _ = w.Write([]byte(`{"tables": [{"name": "result", "fields": [{"name": "email"}, {"name": "n"}], "columns": [["alice@example.com", "bob@example.com"], [1, 2]]}]}`))
+	}))
+	defer srv.Close()
+
+	sealer, err := atrest.New(bytes.Repeat([]byte{7}, atrest.KeySize))
+	if err != nil {
+		t.Fatal(err)
+	}
+	spool := t.TempDir()
+	client, err := axiomclient.New(srv.URL, "test-token", "", axiomclient.WithSpoolDir(spool), axiomclient.WithSpoolEncryption(sealer))
+	if err != nil {
+		t.Fatal(err)
+	}
+	var rows []string
+	_, err = client.QueryAPLRows(context.Background(), "['logs']", func(result *axiomclient.QueryResult, table int, row []any) error {
+		// The spool is complete while rows are replayed.
+		entries, _ := os.ReadDir(spool)
+		for _, entry := range entries {
+			data, _ := os.ReadFile(filepath.Join(spool, entry.Name()))
+			if !atrest.IsSealed(data) || bytes.Contains(data, []byte("example.com")) {
+				t.Errorf("spool %s is not sealed: %q", entry.Name(), data)
+			}
+		}
+		data, _ := json.Marshal(row)
+		rows = append(rows, string(data))
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := strings.Join(rows, " "); got != `["alice@example.com",1] ["bob@example.com",2]` {
+		t.Errorf("rows = %s", got)
+	}
+}
+
+func TestReplay(t *testing.T) {
+	var calls int
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		calls++
+		switch r.URL.Path {
+		case "/v2/datasets":
+			json.NewEncoder(w).Encode([]axiomclient.Dataset{{ID: "logs", Name: "logs"}})
+		case "/v1/datasets/_apl":
+			var req struct {
+				APL string `json:"apl"`
+			}
+			json.NewDecoder(r.Body).Decode(&req)
+			w.Header().Set("X-Axiom-History-Query-Id", "q-"+req.APL)
+			zw, _ := zstd.NewWriter(w)
+			w.Header().Set("Content-Encoding", "zstd")
+			json.NewEncoder(zw).Encode(axiomclient.QueryResult{Status: axiomclient.QueryStatus{RowsMatched: int64(len(req.APL))}})
+			zw.Close()
+		default:
+			w.WriteHeader(http.StatusNotFound)
+			w.Write([]byte(`{"code":404,"message":"not found"}`))
+		}
+	}))
+	dir := filepath.Join(t.TempDir(), "replay")
+	ctx := context.Background()
+
+	recorder, err := axiomclient.New(srv.URL, "xaat-secret", "org", axiomclient.WithReplay(dir, axiomclient.ReplayRecord))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := recorder.ListDatasets(ctx); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := recorder.QueryAPL(ctx, "['logs']"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := recorder.GetDashboard(ctx, "missing"); err == nil {
+		t.Fatal("expected 404")
+	}
+	srv.Close()
+	files, _ := os.ReadDir(dir)
+	if len(files) != 3 || calls != 3 {
+		t.Fatalf("recorded %d files from %d calls", len(files), calls)
+	}
+	for _, f := range files {
+		if data, _ := os.ReadFile(filepath.Join(dir, f.Name())); strings.Contains(string(data), "secret") {
+			t.Errorf("recording leaks credentials: %s", data)
+		}
+	}
+
+	// Replay needs neither a token nor the server.
+	replayer, err := axiomclient.New("https://unreachable.invalid", "", "", axiomclient.WithReplay(dir, axiomclient.ReplayOnly))
+	if err != nil {
+		t.Fatal(err)
+	}
+	datasets, err := replayer.ListDatasets(ctx)
+	if err != nil || len(datasets) != 1 || datasets[0].Name != "logs" {
+		t.Errorf("replayed datasets = %v, %v", datasets, err)
+	}
+	result, err := replayer.QueryAPL(ctx, "['logs']")
+	if err != nil || result.QueryID != "q-['logs']" || result.Status.RowsMatched != 8 {
+		t.Errorf("replayed query = %+v, %v", result, err)
+	}
+	var apiErr *axiomclient.APIError
+	if _, err := replayer.GetDashboard(ctx, "missing"); !errors.As(err, &apiErr) || apiErr.StatusCode != http.StatusNotFound {
+		t.Errorf("replayed 404: err = %v", err)
+	}
+	if _, err := replayer.QueryAPL(ctx, "['other']"); !errors.Is(err, axiomclient.ErrNotRecorded) {
+		t.Errorf("unrecorded query: err = %v", err)
+	}
+
+	if _, err := axiomclient.New(srv.URL, "token", "", axiomclient.WithReplay(dir, "rewind")); err == nil {
+		t.Error("expected invalid mode error")
+	}
+	if _, err := axiomclient.New(srv.URL, "", "", axiomclient.WithReplay(dir, axiomclient.ReplayRecord)); err == nil {
+		t.Error("recording without a token should fail")
+	}
+}
+
+func TestPoll(t *testing.T) {
+	var apl string
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var req struct {
+			APL string `json:"apl"`
+		}
+		json.NewDecoder(r.Body).Decode(&req)
+		apl = req.APL
+		json.NewEncoder(w).Encode(axiomclient.QueryResult{})
+	}))
+	defer srv.Close()
+
+	client, _ := axiomclient.New(srv.URL, "token", "org")
+	after := time.Date(2025, 1, 1, 12, 0, 0, 500, time.UTC)
+	if _, err := client.Poll(context.Background(), "['logs']", after, 50); err != nil {
+		t.Fatal(err)
+	}
+	for _, want := range []string{
+		"['logs'] | ",
+		`_sysTime > datetime("2025-01-01T12:00:00.0000005Z")`,
+		"sort by _sysTime asc",
+		"take 50",
+	} {
+		if !strings.Contains(apl, want) {
+			t.Errorf("poll APL %q missing %q", apl, want)
+		}
+	}
+}
+
+func TestDashboards(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/v1/dashboards":
+			w.Write([]byte(`[{"id":"d1","name":"API"}]`))
+		case "/v1/dashboards/d1":
+			w.Write([]byte(`{"id":"d1","name":"API","timeWindowStart":"qr-now-1h","timeWindowEnd":"qr-now",` +
+				`"charts":[{"id":"c1","name":"Errors","type":"TimeSeries","query":{"apl":"['logs'] | count"}}]}`))
+		default:
+			w.WriteHeader(http.StatusNotFound)
+		}
+	}))
+	defer srv.Close()
+
+	client, _ := axiomclient.New(srv.URL, "token", "org")
+	ctx := context.Background()
+	list, err := client.ListDashboards(ctx)
+	if err != nil || len(list) != 1 || list[0].ID != "d1" {
+		t.Fatalf("ListDashboards = %+v, %v", list, err)
+	}
+	dashboard, err := client.GetDashboard(ctx, "d1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if dashboard.TimeWindowStart != "qr-now-1h" || len(dashboard.Charts) != 1 || dashboard.Charts[0].Query.APL != "['logs'] | count" {
+		t.Errorf("GetDashboard = %+v", dashboard)
+	}
+	var apiErr *axiomclient.APIError
+	if _, err := client.GetDashboard(ctx, "missing"); !errors.As(err, &apiErr) || apiErr.StatusCode != http.StatusNotFound {
+		t.Errorf("missing dashboard: err = %v", err)
+	}
+}
+
+func TestOrg(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/v1/orgs/org-1":
+			w.Header().Set("X-QueryLimit-Limit", "1000")
+			w.Header().Set("X-QueryLimit-Remaining", "998")
+			w.Header().Set("X-QueryLimit-Reset", "1735689600")
+			w.Header().Set("X-QueryLimit-Scope", "organization")
+			w.Write([]byte(`{"id":"org-1","name":"Acme","plan":"personal","license":{"maxDatasets":2,"monthlyIngestGb":500}}`))
+		case "/v1/orgs":
+			w.Write([]byte(`[{"id":"org-2","name":"Solo"}]`))
+		case "/v2/tokens":
+			w.Write([]byte(`[{"id":"t1","name":"ci"}]`))
+		}
+	}))
+	defer srv.Close()
+	ctx := context.Background()
+
+	client, _ := axiomclient.New(srv.URL, "xaat-0123456789abcdef", "org-1")
+	org, err := client.Org(ctx)
+	if err != nil || org.Name != "Acme" || org.License.MaxDatasets != 2 {
+		t.Fatalf("Org = %+v, %v", org, err)
+	}
+	limits, err := client.Limits(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if limits.Query == nil || limits.Query.Limit != 1000 || limits.Query.Remaining != 998 || limits.Query.Scope != "organization" ||
+		!limits.Query.Reset.Equal(time.Date(2025, 1, 1, 0, 0, 0, 0, time.UTC)) {
+		t.Errorf("query limit = %+v", limits.Query)
+	}
+	if limits.Rate != nil || limits.License.MonthlyIngestGB != 500 {
+		t.Errorf("limits = %+v", limits)
+	}
+	if tokens, err := client.ListTokens(ctx); err != nil || len(tokens) != 1 || tokens[0].Name != "ci" {
+		t.Errorf("ListTokens = %+v, %v", tokens, err)
+	}
+	if info := client.TokenInfo(); info.Kind != "api" || info.Redacted != "xaat-…cdef" || info.OrgID != "org-1" {
+		t.Errorf("TokenInfo = %+v", info)
+	}
+
+	noOrg, _ := axiomclient.New(srv.URL, "short", "")
+	if org, err := noOrg.Org(ctx); err != nil || org.ID != "org-2" {
+		t.Errorf("Org without org ID = %+v, %v", org, err)
+	}
+	if info := noOrg.TokenInfo(); info.Kind != "unknown" || info.Redacted != "…" {
+		t.Errorf("short token must not be revealed: %+v", info)
+	}
+}
+
+func TestQueryAPLCompression(t *testing.T) {
+	result := axiomclient.QueryResult{
+		Tables: []axiomclient.QueryTable{{
+			Name:    "result",
+			Fields:  []axiomclient.QueryField{{Name: "msg", Type: "string"}},
+			Columns: [][]any{{strings.Repeat("repetitive log line ", 200)}},
+		}},
+	}
+	plain, _ := json.Marshal(result)
+
+	encode := map[string]func([]byte) []byte{
+		"gzip": func(b []byte) []byte {
+			var buf bytes.Buffer
+			zw := gzip.NewWriter(&buf)
+			zw.Write(b)
+			zw.Close()
+			return buf.Bytes()
+		},
+		"zstd": func(b []byte) []byte {
+			enc, _ := zstd.NewWriter(nil)
+			defer enc.Close()
+			return enc.EncodeAll(b, nil)
+		},
+	}
+
+	for _, tc := range []struct {
+		name     string
+		disable  bool
+		encoding string
+	}{
+		{name: "zstd", encoding: "zstd"},
+		{name: "gzip", encoding: "gzip"},
+		{name: "disabled", disable: true},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			var accept string
+			srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				accept = r.Header.Get("Accept-Encoding")
+				body := plain
+				if tc.encoding != "" {
+					w.Header().Set("Content-Encoding", tc.encoding)
+					body = encode[tc.encoding](plain)
+				}
+				w.Write(body)
+			}))
+			defer srv.Close()
+
+			client, err := axiomclient.New(srv.URL, "token", "org", axiomclient.WithCompression(!tc.disable))
+			if err != nil {
+				t.Fatal(err)
+			}
+			got, err := client.QueryAPL(context.Background(), "['logs']")
+			if err != nil {
+				t.Fatalf("QueryAPL: %v", err)
+			}
+			if len(got.Tables) != 1 || got.Tables[0].Columns[0][0] != result.Tables[0].Columns[0][0] {
+				t.Fatalf("decoded result mismatch: %+v", got.Tables)
+			}
+
+			stats := client.TransferStats()
+			if stats.Responses != 1 || stats.DecodedBytes < int64(len(plain)) {
+				t.Errorf("stats = %+v, want decoded >= %d", stats, len(plain))
+			}
+			if tc.disable {
+				if accept != "" {
+					t.Errorf("Accept-Encoding = %q with compression disabled", accept)
+				}
+				if stats.Compression || stats.Compressed != 0 {
+					t.Errorf("stats = %+v", stats)
+				}
+				return
+			}
+			if accept != "zstd, gzip" {
+				t.Errorf("Accept-Encoding = %q", accept)
+			}
+			if stats.Compressed != 1 || stats.WireBytes >= stats.DecodedBytes {
+				t.Errorf("compressed response should be smaller on the wire: %+v", stats)
+			}
+		})
+	}
 }
 
 func TestAPIErrorHandling(t *testing.T) {
@@ -189,24 +713,49 @@ func TestAPIErrorHandling(t *testing.T) {
 		statusCode int
 		body       string
 		wantErr    string
+		wantErrno  syscall.Errno
 	}{
 		{
 			name:       "structured error",
 			statusCode: 403,
 			body:       `{"code":403,"message":"forbidden"}`,
 			wantErr:    "axiom API error 403: forbidden",
+			wantErrno:  syscall.EACCES,
 		},
 		{
 			name:       "unstructured error",
 			statusCode: 500,
 			body:       "internal server error",
 			wantErr:    "axiom API error: status 500",
+			wantErrno:  syscall.EIO,
 		},
 		{
 			name:       "bad request",
 			statusCode: 400,
 			body:       `{"code":400,"message":"invalid APL query"}`,
 			wantErr:    "axiom API error 400: invalid APL query",
+			wantErrno:  syscall.EINVAL,
+		},
+		{
+			name:       "unauthorized",
+			statusCode: 401,
+			body:       `{"code":401,"message":"invalid token"}`,
+			wantErr:    "axiom API error 401: invalid token",
+			wantErrno:  syscall.EACCES,
+		},
+		{
+			name:       "not found",
+			statusCode: 404,
+			body:       `{"code":404,"message":"dataset not found"}`,
+			wantErr:    "axiom API error 404: dataset not found",
+			wantErrno:  syscall.ENOENT,
+		},
+		{
+			name:       "rate limited",
+			statusCode: 429,
+			body:       "",
+			wantErr:    "axiom API error: status 429",
+			wantErrno:  syscall.EAGAIN,
 		},
 	}
 
@@ -231,6 +780,13 @@ func TestAPIErrorHandling(t *testing.T) {
 			if !strings.Contains(err.Error(), tt.wantErr) {
 				t.Errorf("expected error containing %q, got %q", tt.wantErr, err.Error())
 			}
+			var apiErr *axiomclient.APIError
+			if !errors.As(err, &apiErr) || apiErr.StatusCode != tt.statusCode {
+				t.Errorf("expected *APIError with status %d, got %#v", tt.statusCode, err)
+			}
+			if !errors.Is(err, tt.wantErrno) {
+				t.Errorf("expected errors.Is(err, %v)", tt.wantErrno)
+			}
 		})
 	}
 }
@@ -242,6 +798,87 @@ func TestNewClientValidation(t *testing.T) {
 	}
 }
 
+func TestTokenSource(t *testing.T) {
+	var auth string
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		auth = r.Header.Get("Authorization")
+		_ = json.NewEncoder(w).Encode([]axiomclient.Dataset{})
+	}))
+	defer srv.Close()
+	ctx := context.Background()
+
+	path := filepath.Join(t.TempDir(), "token")
+	if err := os.WriteFile(path, []byte("xaat-first-token-1111\n"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	tokens, err := axiomclient.ParseTokenSource("file:"+path, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	client, err := axiomclient.New(srv.URL, "", "", axiomclient.WithTokenSource(tokens))
+	if err != nil {
+		t.Fatalf("New: %v", err)
+	}
+	if _, err := client.ListDatasets(ctx); err != nil {
+		t.Fatal(err)
+	}
+	if auth != "Bearer xaat-first-token-1111" {
+		t.Errorf("auth = %q", auth)
+	}
+
+	// Rotating the file takes effect on the next request.
+	if err := os.WriteFile(path, []byte("xaat-second-token-2222\n"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	later := time.Now().Add(time.Minute)
+	if err := os.Chtimes(path, later, later); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := client.ListDatasets(ctx); err != nil {
+		t.Fatal(err)
+	}
+	if auth != "Bearer xaat-second-token-2222" {
+		t.Errorf("after rotation auth = %q", auth)
+	}
+	if info := client.TokenInfo(); info.Redacted != "xaat-…2222" {
+		t.Errorf("TokenInfo = %+v", info)
+	}
+
+	// A failing source fails the request without sending it.
+	_ = os.Remove(path)
+	auth = ""
+	if _, err := client.ListDatasets(ctx); err == nil || auth != "" {
+		t.Errorf("missing token file: err = %v, auth = %q", err, auth)
+	}
+
+	tokens, err = axiomclient.ParseTokenSource("exec:echo cmd-token", 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	client, err = axiomclient.New(srv.URL, "", "", axiomclient.WithTokenSource(tokens))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := client.ListDatasets(ctx); err != nil {
+		t.Fatal(err)
+	}
+	if auth != "Bearer cmd-token" {
+		t.Errorf("exec auth = %q", auth)
+	}
+
+	if _, err := axiomclient.CommandToken(0, "sh", "-c", "echo denied >&2; exit 1").Token(ctx); err == nil || !strings.Contains(err.Error(), "denied") {
+		t.Errorf("failing command: %v", err)
+	}
+	for _, spec := range []string{"vault:x", "file:", "nocolon"} {
+		if _, err := axiomclient.ParseTokenSource(spec, 0); err == nil {
+			t.Errorf("ParseTokenSource(%q): expected error", spec)
+		}
+	}
+	if src, err := axiomclient.ParseTokenSource("env", 0); src != nil || err != nil {
+		t.Errorf("env: %v, %v", src, err)
+	}
+}
+
 func TestNewClientDefaults(t *testing.T) {
 	client, err := axiomclient.New("", "token", "org")
 	if err != nil {
@@ -252,6 +889,50 @@ func TestNewClientDefaults(t *testing.T) {
 	}
 }
 
+func TestCAFile(t *testing.T) {
+	srv := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_ = json.NewEncoder(w).Encode([]axiomclient.Dataset{{Name: "logs"}})
+	}))
+	defer srv.Close()
+
+	caFile := filepath.Join(t.TempDir(), "ca.pem")
+	certPEM := pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: srv.Certificate().Raw})
+	if err := os.WriteFile(caFile, certPEM, 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	untrusted, err := axiomclient.New(srv.URL, "token", "org")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := untrusted.ListDatasets(context.Background()); err == nil {
+		t.Error("expected certificate error without the CA file")
+	}
+
+	client, err := axiomclient.New(srv.URL, "token", "org", axiomclient.WithCAFile(caFile), axiomclient.WithMaxIdleConnsPerHost(4))
+	if err != nil {
+		t.Fatal(err)
+	}
+	datasets, err := client.ListDatasets(context.Background())
+	if err != nil {
+		t.Fatalf("ListDatasets with CA file: %v", err)
+	}
+	if len(datasets) != 1 || datasets[0].Name != "logs" {
+		t.Errorf("datasets = %+v", datasets)
+	}
+
+	bad := filepath.Join(t.TempDir(), "bad.pem")
+	if err := os.WriteFile(bad, []byte("not a certificate"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := axiomclient.New(srv.URL, "token", "org", axiomclient.WithCAFile(bad)); err == nil {
+		t.Error("expected error for CA file without certificates")
+	}
+	if _, err := axiomclient.New(srv.URL, "token", "org", axiomclient.WithCAFile(filepath.Join(t.TempDir(), "missing.pem"))); err == nil {
+		t.Error("expected error for missing CA file")
+	}
+}
+
 func TestContextCancellation(t *testing.T) {
 	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 		time.Sleep(100 * time.Millisecond)
@@ -0,0 +1,112 @@
+package axiomclient
+
+import (
+	"compress/gzip"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+	"sync/atomic"
+
+	"github.com/klauspost/compress/zstd"
+)
+
+// acceptEncoding is what QueryAPL asks for. zstd decodes faster and
+// compresses tabular results better; gzip is the fallback.
+const acceptEncoding = "zstd, gzip"
+
+// WithCompression toggles compressed query responses. It is on by default.
+func WithCompression(enabled bool) Option {
+	return func(o *options) {
+		o.disableCompression = !enabled
+	}
+}
+
+// TransferStats counts query response bytes as received on the wire and
+// after decoding. Uncompressed responses count the same in both.
+type TransferStats struct {
+	Compression  bool  `json:"compression"`
+	Responses    int64 `json:"responses"`
+	Compressed   int64 `json:"compressed_responses"`
+	WireBytes    int64 `json:"wire_bytes"`
+	DecodedBytes int64 `json:"decoded_bytes"`
+}
+
+type transferCounters struct {
+	responses  atomic.Int64
+	compressed atomic.Int64
+	wire       atomic.Int64
+	decoded    atomic.Int64
+}
+
+// TransferStats reports query response bytes transferred so far.
+func (c *Client) TransferStats() TransferStats {
+	return TransferStats{
+		Compression:  c.compression,
+		Responses:    c.transfer.responses.Load(),
+		Compressed:   c.transfer.compressed.Load(),
+		WireBytes:    c.transfer.wire.Load(),
+		DecodedBytes: c.transfer.decoded.Load(),
+	}
+}
+
+// decodeBody wraps resp.Body so reads return the decoded body while the
+// transfer counters track both sides. Close closes the decoder and the
+// underlying body.
+func (c *Client) decodeBody(resp *http.Response) (io.ReadCloser, error) {
+	c.transfer.responses.Add(1)
+	wire := &countingReader{r: resp.Body, n: &c.transfer.wire}
+	var (
+		decoded io.Reader
+		closeFn func()
+	)
+	switch encoding := strings.ToLower(strings.TrimSpace(resp.Header.Get("Content-Encoding"))); encoding {
+	case "", "identity":
+		decoded = wire
+	case "gzip":
+		zr, err := gzip.NewReader(wire)
+		if err != nil {
+			return nil, fmt.Errorf("decode gzip response: %w", err)
+		}
+		decoded, closeFn = zr, func() { _ = zr.Close() }
+		c.transfer.compressed.Add(1)
+	case "zstd":
+		zr, err := zstd.NewReader(wire, zstd.WithDecoderConcurrency(1))
+		if err != nil {
+			return nil, fmt.Errorf("decode zstd response: %w", err)
+		}
+		decoded, closeFn = zr, zr.Close
+		c.transfer.compressed.Add(1)
+	default:
+		return nil, fmt.Errorf("unsupported response encoding %q", encoding)
+	}
+	return &decodedBody{
+		Reader: &countingReader{r: decoded, n: &c.transfer.decoded},
+		body:   resp.Body,
+		close:  closeFn,
+	}, nil
+}
+
+type countingReader struct {
+	r io.Reader
+	n *atomic.Int64
+}
+
+func (c *countingReader) Read(p []byte) (int, error) {
+	n, err := c.r.Read(p)
+	c.n.Add(int64(n))
+	return n, err
+}
+
+type decodedBody struct {
+	io.Reader
+	body  io.Closer
+	close func()
+}
+
+func (d *decodedBody) Close() error {
+	if d.close != nil {
+		d.close()
+	}
+	return d.body.Close()
+}
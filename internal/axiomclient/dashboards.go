@@ -0,0 +1,68 @@
+package axiomclient
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/url"
+)
+
+// Dashboard is a saved Axiom dashboard.
+type Dashboard struct {
+	ID          string `json:"id"`
+	Name        string `json:"name"`
+	Description string `json:"description,omitempty"`
+	// TimeWindowStart and TimeWindowEnd are the dashboard's time range,
+	// either relative ("qr-now-1h", "qr-now") or RFC 3339 timestamps.
+	TimeWindowStart string           `json:"timeWindowStart,omitempty"`
+	TimeWindowEnd   string           `json:"timeWindowEnd,omitempty"`
+	Charts          []DashboardChart `json:"charts,omitempty"`
+}
+
+// DashboardChart is one chart of a dashboard and the APL behind it.
+type DashboardChart struct {
+	ID    string         `json:"id"`
+	Name  string         `json:"name"`
+	Type  string         `json:"type,omitempty"`
+	Query DashboardQuery `json:"query"`
+}
+
+// DashboardQuery is the stored query of a chart.
+type DashboardQuery struct {
+	APL string `json:"apl"`
+}
+
+// ListDashboards returns all dashboards. Charts may be omitted from the
+// listing; GetDashboard returns them.
+func (c *Client) ListDashboards(ctx context.Context) ([]Dashboard, error) {
+	resp, err := c.doRequest(ctx, http.MethodGet, "/v1/dashboards", nil)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if err := c.checkResponse(resp); err != nil {
+		return nil, err
+	}
+	var dashboards []Dashboard
+	if err := json.NewDecoder(resp.Body).Decode(&dashboards); err != nil {
+		return nil, err
+	}
+	return dashboards, nil
+}
+
+// GetDashboard returns a dashboard with its charts.
+func (c *Client) GetDashboard(ctx context.Context, id string) (*Dashboard, error) {
+	resp, err := c.doRequest(ctx, http.MethodGet, "/v1/dashboards/"+url.PathEscape(id), nil)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if err := c.checkResponse(resp); err != nil {
+		return nil, err
+	}
+	var dashboard Dashboard
+	if err := json.NewDecoder(resp.Body).Decode(&dashboard); err != nil {
+		return nil, err
+	}
+	return &dashboard, nil
+}
@@ -0,0 +1,45 @@
+package axiomclient
+
+import (
+	"fmt"
+	"net/http"
+	"syscall"
+)
+
+// APIError is a non-2xx response from the Axiom API. It unwraps to the
+// syscall.Errno that best describes it, so callers can use errors.Is with
+// syscall.EACCES, syscall.ENOENT, syscall.EAGAIN or syscall.EINVAL.
+type APIError struct {
+	// StatusCode is the HTTP status of the response.
+	StatusCode int
+	// Code and Message come from the JSON error body, when there is one.
+	Code    int
+	Message string
+}
+
+func (e *APIError) Error() string {
+	if e.Message != "" {
+		return fmt.Sprintf("axiom API error %d: %s", e.Code, e.Message)
+	}
+	return fmt.Sprintf("axiom API error: status %d", e.StatusCode)
+}
+
+// Errno maps the HTTP status to an errno: authentication and authorization
+// failures are EACCES, missing resources ENOENT, rate limiting EAGAIN and
+// rejected requests (such as APL syntax errors) EINVAL. Anything else is EIO.
+func (e *APIError) Errno() syscall.Errno {
+	switch e.StatusCode {
+	case http.StatusUnauthorized, http.StatusForbidden:
+		return syscall.EACCES
+	case http.StatusNotFound:
+		return syscall.ENOENT
+	case http.StatusTooManyRequests:
+		return syscall.EAGAIN
+	case http.StatusBadRequest, http.StatusUnprocessableEntity:
+		return syscall.EINVAL
+	default:
+		return syscall.EIO
+	}
+}
+
+func (e *APIError) Unwrap() error { return e.Errno() }
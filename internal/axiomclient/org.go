@@ -0,0 +1,175 @@
+package axiomclient
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Org is the Axiom organization the client acts for.
+type Org struct {
+	ID          string   `json:"id"`
+	Name        string   `json:"name"`
+	Slug        string   `json:"slug,omitempty"`
+	Plan        string   `json:"plan,omitempty"`
+	PlanCreated string   `json:"planCreated,omitempty"`
+	Trial       bool     `json:"trial,omitempty"`
+	License     *License `json:"license,omitempty"`
+}
+
+// License holds the plan limits of an org. Zero means unlimited or not
+// reported.
+type License struct {
+	Tier                  string   `json:"tier,omitempty"`
+	MonthlyIngestGB       int64    `json:"monthlyIngestGb,omitempty"`
+	MaxDatasets           int64    `json:"maxDatasets,omitempty"`
+	MaxFieldsPerDataset   int64    `json:"maxFieldsPerDataset,omitempty"`
+	MaxQueryWindowSeconds int64    `json:"maxQueryWindowSeconds,omitempty"`
+	MaxAuditWindowSeconds int64    `json:"maxAuditWindowSeconds,omitempty"`
+	MaxMonitors           int64    `json:"maxMonitors,omitempty"`
+	WithRBAC              bool     `json:"withRBAC,omitempty"`
+	WithAuths             []string `json:"withAuths,omitempty"`
+}
+
+// RateLimit is one of the limits Axiom reports in X-<Kind>Limit-* response
+// headers.
+type RateLimit struct {
+	Scope     string    `json:"scope,omitempty"`
+	Limit     int64     `json:"limit"`
+	Remaining int64     `json:"remaining"`
+	Reset     time.Time `json:"reset,omitzero"`
+}
+
+// Limits are the org's plan limits and the current API, query and ingest
+// rate limits.
+type Limits struct {
+	License *License   `json:"license,omitempty"`
+	Rate    *RateLimit `json:"rate,omitempty"`
+	Query   *RateLimit `json:"query,omitempty"`
+	Ingest  *RateLimit `json:"ingest,omitempty"`
+}
+
+// Token describes an API token without its secret.
+type Token struct {
+	ID          string     `json:"id"`
+	Name        string     `json:"name"`
+	Description string     `json:"description,omitempty"`
+	ExpiresAt   *time.Time `json:"expiresAt,omitempty"`
+}
+
+// TokenInfo describes the token the client authenticates with.
+type TokenInfo struct {
+	// Kind is "api" for xaat- tokens, "personal" for xapt- tokens.
+	Kind     string `json:"kind"`
+	Redacted string `json:"redacted"`
+	OrgID    string `json:"org_id,omitempty"`
+}
+
+// Org returns the client's org: the configured org ID, or the only org an
+// API token belongs to.
+func (c *Client) Org(ctx context.Context) (*Org, error) {
+	org, _, err := c.getOrg(ctx)
+	return org, err
+}
+
+// Limits returns the org's plan limits and the rate limits reported with
+// the org lookup.
+func (c *Client) Limits(ctx context.Context) (*Limits, error) {
+	org, header, err := c.getOrg(ctx)
+	if err != nil {
+		return nil, err
+	}
+	return &Limits{
+		License: org.License,
+		Rate:    rateLimit(header, "X-RateLimit-"),
+		Query:   rateLimit(header, "X-QueryLimit-"),
+		Ingest:  rateLimit(header, "X-IngestLimit-"),
+	}, nil
+}
+
+func (c *Client) getOrg(ctx context.Context) (*Org, http.Header, error) {
+	path := "/v1/orgs"
+	if c.orgID != "" {
+		path += "/" + url.PathEscape(c.orgID)
+	}
+	resp, err := c.doRequest(ctx, http.MethodGet, path, nil)
+	if err != nil {
+		return nil, nil, err
+	}
+	defer resp.Body.Close()
+	if err := c.checkResponse(resp); err != nil {
+		return nil, nil, err
+	}
+	if c.orgID != "" {
+		var org Org
+		if err := json.NewDecoder(resp.Body).Decode(&org); err != nil {
+			return nil, nil, err
+		}
+		return &org, resp.Header, nil
+	}
+	var orgs []Org
+	if err := json.NewDecoder(resp.Body).Decode(&orgs); err != nil {
+		return nil, nil, err
+	}
+	if len(orgs) != 1 {
+		return nil, nil, errors.New("token has access to several orgs; set an org ID")
+	}
+	return &orgs[0], resp.Header, nil
+}
+
+// rateLimit parses the <prefix>Limit, Remaining, Reset and Scope headers.
+// It returns nil when the limit header is absent.
+func rateLimit(header http.Header, prefix string) *RateLimit {
+	limit, err := strconv.ParseInt(header.Get(prefix+"Limit"), 10, 64)
+	if err != nil {
+		return nil
+	}
+	rl := &RateLimit{Scope: header.Get(prefix + "Scope"), Limit: limit}
+	rl.Remaining, _ = strconv.ParseInt(header.Get(prefix+"Remaining"), 10, 64)
+	if reset, err := strconv.ParseInt(header.Get(prefix+"Reset"), 10, 64); err == nil {
+		rl.Reset = time.Unix(reset, 0).UTC()
+	}
+	return rl
+}
+
+// ListTokens returns the org's API tokens. Listing needs a token allowed to
+// read API tokens.
+func (c *Client) ListTokens(ctx context.Context) ([]Token, error) {
+	resp, err := c.doRequest(ctx, http.MethodGet, "/v2/tokens", nil)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if err := c.checkResponse(resp); err != nil {
+		return nil, err
+	}
+	var tokens []Token
+	if err := json.NewDecoder(resp.Body).Decode(&tokens); err != nil {
+		return nil, err
+	}
+	return tokens, nil
+}
+
+// TokenInfo describes the client's own token, keeping only its last four
+// characters. A token source that fails is reported as kind "unknown".
+func (c *Client) TokenInfo() TokenInfo {
+	info := TokenInfo{Kind: "unknown", OrgID: c.orgID, Redacted: "…"}
+	token, _ := c.tokens.Token(context.Background())
+	switch {
+	case strings.HasPrefix(token, "xaat-"):
+		info.Kind = "api"
+		info.Redacted = "xaat-…"
+	case strings.HasPrefix(token, "xapt-"):
+		info.Kind = "personal"
+		info.Redacted = "xapt-…"
+	}
+	if len(token) > 12 {
+		info.Redacted += token[len(token)-4:]
+	}
+	return info
+}
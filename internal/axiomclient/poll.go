@@ -0,0 +1,25 @@
+package axiomclient
+
+import (
+	"context"
+	"fmt"
+	"time"
+)
+
+// pollLookback bounds the _time scan of a poll. Events ingested with a
+// timestamp older than this are not tailed.
+const pollLookback = "1h"
+
+// Poll returns up to limit events ingested into source after the ingest
+// time after, oldest first. source is an APL tabular expression such as
+// ['logs'] or a union. Live tails call it repeatedly, passing the newest
+// _sysTime they have seen.
+func (c *Client) Poll(ctx context.Context, source string, after time.Time, limit int) (*QueryResult, error) {
+	return c.QueryAPL(ctx, PollAPL(source, after, limit))
+}
+
+// PollAPL is the APL of a Poll, for callers that run it themselves.
+func PollAPL(source string, after time.Time, limit int) string {
+	return fmt.Sprintf("%s | where _time > ago(%s) and _sysTime > datetime(%q) | sort by _sysTime asc | take %d",
+		source, pollLookback, after.UTC().Format(time.RFC3339Nano), limit)
+}
@@ -0,0 +1,160 @@
+package axiomclient
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"net/http"
+	"os"
+	"path/filepath"
+)
+
+// Replay modes for WithReplay.
+const (
+	// ReplayRecord sends requests to Axiom and saves every response.
+	ReplayRecord = "record"
+	// ReplayOnly answers requests from saved responses without network.
+	ReplayOnly = "replay"
+)
+
+// ErrNotRecorded is returned in replay mode for a request that was never
+// recorded.
+var ErrNotRecorded = errors.New("no recorded response")
+
+// WithReplay records API responses to dir, or replays them from it, as mode
+// is ReplayRecord or ReplayOnly. Replaying needs no token or network.
+func WithReplay(dir, mode string) Option {
+	return func(o *options) {
+		o.replayDir = dir
+		o.replayMode = mode
+	}
+}
+
+// recording is one saved response. The request is kept for people reading
+// the files; only its key is used to match.
+type recording struct {
+	Method  string      `json:"method"`
+	Path    string      `json:"path"`
+	Request string      `json:"request,omitempty"`
+	Status  int         `json:"status"`
+	Header  http.Header `json:"header"`
+	Body    []byte      `json:"body"`
+}
+
+// replayTransport records or replays responses keyed by method, path with
+// query and request body. Credentials, org and host are not part of the
+// key, so a recording replays against any API URL.
+type replayTransport struct {
+	dir    string
+	record bool
+	next   http.RoundTripper
+}
+
+func newReplayTransport(dir, mode string, next http.RoundTripper) (*replayTransport, error) {
+	switch mode {
+	case ReplayRecord:
+		if err := os.MkdirAll(dir, 0o755); err != nil {
+			return nil, fmt.Errorf("replay dir: %w", err)
+		}
+	case ReplayOnly:
+		if _, err := os.Stat(dir); err != nil {
+			return nil, fmt.Errorf("replay dir: %w", err)
+		}
+	default:
+		return nil, fmt.Errorf("invalid replay mode %q (want %s or %s)", mode, ReplayRecord, ReplayOnly)
+	}
+	return &replayTransport{dir: dir, record: mode == ReplayRecord, next: next}, nil
+}
+
+func (t *replayTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	var body []byte
+	if req.Body != nil {
+		var err error
+		if body, err = io.ReadAll(req.Body); err != nil {
+			return nil, err
+		}
+		_ = req.Body.Close()
+		req.Body = io.NopCloser(bytes.NewReader(body))
+	}
+	path := req.URL.RequestURI()
+	file := filepath.Join(t.dir, replayKey(req.Method, path, body)+".json")
+
+	if !t.record {
+		data, err := os.ReadFile(file)
+		if errors.Is(err, fs.ErrNotExist) {
+			return nil, fmt.Errorf("%w for %s %s", ErrNotRecorded, req.Method, path)
+		}
+		if err != nil {
+			return nil, err
+		}
+		var rec recording
+		if err := json.Unmarshal(data, &rec); err != nil {
+			return nil, fmt.Errorf("replay %s: %w", file, err)
+		}
+		return rec.response(req), nil
+	}
+
+	resp, err := t.next.RoundTrip(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	data, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return nil, err
+	}
+	header := resp.Header.Clone()
+	header.Del("Set-Cookie")
+	rec := recording{Method: req.Method, Path: path, Request: string(body), Status: resp.StatusCode, Header: header, Body: data}
+	if err := writeRecording(file, rec); err != nil {
+		return nil, fmt.Errorf("record %s %s: %w", req.Method, path, err)
+	}
+	return rec.response(req), nil
+}
+
+func (r recording) response(req *http.Request) *http.Response {
+	return &http.Response{
+		Status:        fmt.Sprintf("%d %s", r.Status, http.StatusText(r.Status)),
+		StatusCode:    r.Status,
+		Proto:         "HTTP/1.1",
+		ProtoMajor:    1,
+		ProtoMinor:    1,
+		Header:        r.Header,
+		Body:          io.NopCloser(bytes.NewReader(r.Body)),
+		ContentLength: int64(len(r.Body)),
+		Request:       req,
+	}
+}
+
+func writeRecording(path string, rec recording) error {
+	data, err := json.MarshalIndent(rec, "", "  ")
+	if err != nil {
+		return err
+	}
+	tmp, err := os.CreateTemp(filepath.Dir(path), "record-*")
+	if err != nil {
+		return err
+	}
+	if _, err := tmp.Write(append(data, '\n')); err != nil {
+		_ = tmp.Close()
+		_ = os.Remove(tmp.Name())
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		_ = os.Remove(tmp.Name())
+		return err
+	}
+	return os.Rename(tmp.Name(), path)
+}
+
+func replayKey(method, path string, body []byte) string {
+	h := sha256.New()
+	_, _ = io.WriteString(h, method+" "+path+"\n")
+	_, _ = h.Write(body)
+	return hex.EncodeToString(h.Sum(nil))
+}
@@ -0,0 +1,312 @@
+package axiomclient
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+
+	"github.com/axiomhq/axiom-fs/internal/atrest"
+)
+
+// WithSpoolDir sets where QueryAPLRows spools results; empty uses the
+// system temporary directory.
+func WithSpoolDir(dir string) Option {
+	return func(o *options) {
+		o.spoolDir = dir
+	}
+}
+
+// WithSpoolEncryption seals what QueryAPLRows spools with s, so result
+// values never reach the spool directory in plaintext. A nil s spools
+// plaintext.
+func WithSpoolEncryption(s *atrest.Sealer) Option {
+	return func(o *options) {
+		o.spoolSealer = s
+	}
+}
+
+// RowFunc receives a query result one row at a time. result describes every
+// table and the query status, with Columns left empty; table indexes
+// result.Tables. row is reused and only valid during the call.
+type RowFunc func(result *QueryResult, table int, row []any) error
+
+// QueryAPLRows runs apl like QueryAPL but hands the result to fn row by row
+// instead of holding it in memory. It returns what fn was given as result.
+//
+// The tabular format is column-major, so no row is complete before the last
+// column arrives: values are spooled to a temporary file as the response is
+// decoded and rows are replayed from it, keeping memory bounded by the
+// number of columns rather than rows.
+func (c *Client) QueryAPLRows(ctx context.Context, apl string, fn RowFunc) (*QueryResult, error) {
+	return c.QueryAPLRowsWithHeaders(ctx, apl, nil, fn)
+}
+
+// QueryAPLRowsWithHeaders is QueryAPLRows sending extra request headers, as
+// QueryAPLWithHeaders does.
+func (c *Client) QueryAPLRowsWithHeaders(ctx context.Context, apl string, header http.Header, fn RowFunc) (*QueryResult, error) {
+	body, queryID, err := c.postAPL(ctx, queryRequest{APL: apl}, header)
+	if err != nil {
+		return nil, err
+	}
+	defer body.Close()
+	spool, err := os.CreateTemp(c.spoolDir, "axiom-fs-rows-*")
+	if err != nil {
+		return nil, err
+	}
+	defer func() {
+		_ = spool.Close()
+		_ = os.Remove(spool.Name())
+	}()
+	var (
+		out    io.Writer = spool
+		sealed *atrest.Writer
+	)
+	if c.spoolSealer.Enabled() {
+		if sealed, err = c.spoolSealer.NewWriter(spool); err != nil {
+			return nil, err
+		}
+		out = sealed
+	}
+	d := &rowDecoder{dec: json.NewDecoder(body), spool: spool, w: bufio.NewWriter(out)}
+	result, err := d.decode()
+	if err != nil {
+		return nil, err
+	}
+	result.QueryID = queryID
+	if err := d.w.Flush(); err != nil {
+		return nil, err
+	}
+	if sealed != nil {
+		if err := sealed.Close(); err != nil {
+			return nil, err
+		}
+		// The spool is closed and removed above, not through the
+		// decrypting file.
+		if d.spool, err = c.spoolSealer.OpenFile(spool, d.written); err != nil {
+			return nil, err
+		}
+	}
+	if err := d.replay(ctx, result, fn); err != nil {
+		return nil, err
+	}
+	return result, nil
+}
+
+// spooledColumn is where a column's values, one compact JSON value per
+// line, sit in the spool file.
+type spooledColumn struct {
+	offset int64
+	size   int64
+}
+
+// rowDecoder reads a tabular response token by token, writing column values
+// to spool as it goes.
+type rowDecoder struct {
+	dec *json.Decoder
+	// spool reads back what w wrote, decrypting it if it is sealed.
+	spool   io.ReaderAt
+	w       *bufio.Writer
+	written int64
+	// columns holds the spooled columns of each table.
+	columns [][]spooledColumn
+	// rows is the row count of each table, the length of its first column.
+	rows []int64
+}
+
+func (d *rowDecoder) decode() (*QueryResult, error) {
+	result := &QueryResult{}
+	err := d.object(func(key string) error {
+		switch key {
+		case "tables":
+			return d.array(func() error {
+				table, err := d.table()
+				if err != nil {
+					return err
+				}
+				result.Tables = append(result.Tables, table)
+				return nil
+			})
+		case "status":
+			return d.dec.Decode(&result.Status)
+		default:
+			return d.skip()
+		}
+	})
+	if err != nil {
+		return nil, fmt.Errorf("decode query result: %w", err)
+	}
+	return result, nil
+}
+
+func (d *rowDecoder) table() (QueryTable, error) {
+	var (
+		table   QueryTable
+		columns []spooledColumn
+		rows    int64
+	)
+	err := d.object(func(key string) error {
+		switch key {
+		case "name":
+			return d.dec.Decode(&table.Name)
+		case "fields":
+			return d.dec.Decode(&table.Fields)
+		case "columns":
+			return d.array(func() error {
+				column := spooledColumn{offset: d.written}
+				var values int64
+				err := d.array(func() error {
+					values++
+					return d.spoolValue()
+				})
+				column.size = d.written - column.offset
+				if len(columns) == 0 {
+					rows = values
+				}
+				columns = append(columns, column)
+				return err
+			})
+		default:
+			return d.skip()
+		}
+	})
+	d.columns = append(d.columns, columns)
+	d.rows = append(d.rows, rows)
+	return table, err
+}
+
+// spoolValue copies the next value to the spool, compacted onto one line.
+func (d *rowDecoder) spoolValue() error {
+	var raw json.RawMessage
+	if err := d.dec.Decode(&raw); err != nil {
+		return err
+	}
+	// Scalars come back as they were sent, which is already one line.
+	line := []byte(raw)
+	if len(raw) > 0 && (raw[0] == '{' || raw[0] == '[') {
+		var buf bytes.Buffer
+		if err := json.Compact(&buf, raw); err != nil {
+			return err
+		}
+		line = buf.Bytes()
+	}
+	n, err := d.w.Write(line)
+	d.written += int64(n)
+	if err != nil {
+		return err
+	}
+	if err := d.w.WriteByte('\n'); err != nil {
+		return err
+	}
+	d.written++
+	return nil
+}
+
+// object reads a JSON object, calling fn with each key positioned at its
+// value.
+func (d *rowDecoder) object(fn func(key string) error) error {
+	if err := d.delim('{'); err != nil {
+		return err
+	}
+	for d.dec.More() {
+		tok, err := d.dec.Token()
+		if err != nil {
+			return err
+		}
+		key, ok := tok.(string)
+		if !ok {
+			return fmt.Errorf("unexpected %v, want an object key", tok)
+		}
+		if err := fn(key); err != nil {
+			return err
+		}
+	}
+	return d.delim('}')
+}
+
+// array reads a JSON array, calling fn positioned at each element. A null
+// counts as an empty array.
+func (d *rowDecoder) array(fn func() error) error {
+	tok, err := d.dec.Token()
+	if err != nil {
+		return err
+	}
+	if tok == nil {
+		return nil
+	}
+	if tok != json.Delim('[') {
+		return fmt.Errorf("unexpected %v, want [", tok)
+	}
+	for d.dec.More() {
+		if err := fn(); err != nil {
+			return err
+		}
+	}
+	return d.delim(']')
+}
+
+func (d *rowDecoder) delim(want json.Delim) error {
+	tok, err := d.dec.Token()
+	if err != nil {
+		return err
+	}
+	if tok != want {
+		return fmt.Errorf("unexpected %v, want %v", tok, want)
+	}
+	return nil
+}
+
+func (d *rowDecoder) skip() error {
+	var raw json.RawMessage
+	return d.dec.Decode(&raw)
+}
+
+// replay reads the spooled columns of each table side by side, calling fn
+// for every row.
+func (d *rowDecoder) replay(ctx context.Context, result *QueryResult, fn RowFunc) error {
+	for t, columns := range d.columns {
+		readers := make([]*bufio.Reader, len(columns))
+		for i, column := range columns {
+			readers[i] = bufio.NewReader(io.NewSectionReader(d.spool, column.offset, column.size))
+		}
+		row := make([]any, len(columns))
+		for r := int64(0); r < d.rows[t]; r++ {
+			if r%1024 == 0 {
+				if err := ctx.Err(); err != nil {
+					return err
+				}
+			}
+			for i, reader := range readers {
+				row[i] = nil
+				line, err := reader.ReadSlice('\n')
+				if err == io.EOF && len(line) == 0 {
+					// A column shorter than the first reads as nulls.
+					continue
+				}
+				if err != nil && err != bufio.ErrBufferFull {
+					return err
+				}
+				if err == bufio.ErrBufferFull {
+					rest, err := reader.ReadBytes('\n')
+					if err != nil {
+						return err
+					}
+					line = append(append([]byte{}, line...), rest...)
+				}
+				dec := json.NewDecoder(bytes.NewReader(line))
+				dec.UseNumber()
+				if err := dec.Decode(&row[i]); err != nil {
+					return err
+				}
+			}
+			if err := fn(result, t, row); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
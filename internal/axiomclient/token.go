@@ -0,0 +1,153 @@
+package axiomclient
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"os"
+	"os/exec"
+	"strings"
+	"sync"
+	"time"
+)
+
+// DefaultTokenRefresh is how long tokens from commands and AWS are used
+// before the source is asked again.
+const DefaultTokenRefresh = 15 * time.Minute
+
+// TokenSource supplies the token the client authenticates with. It is asked
+// before every request, so a source may rotate the token while the mount
+// runs; sources that are slow to ask cache the token themselves.
+type TokenSource interface {
+	Token(ctx context.Context) (string, error)
+}
+
+// StaticToken is a token that never changes, as given by flag, env or
+// ~/.axiom.toml.
+type StaticToken string
+
+func (t StaticToken) Token(context.Context) (string, error) { return string(t), nil }
+
+// WithTokenSource authenticates with tokens from src instead of the token
+// passed to New.
+func WithTokenSource(src TokenSource) Option {
+	return func(o *options) {
+		o.tokenSource = src
+	}
+}
+
+// ParseTokenSource returns the source a -token-source value names:
+//
+//	file:<path>            the file's trimmed contents, reread when it changes
+//	exec:<command>         the trimmed output of a shell command, e.g. exec:op read op://vault/axiom/token
+//	ssm:<parameter>        an AWS SSM parameter, decrypted
+//	secretsmanager:<id>    an AWS Secrets Manager secret string
+//
+// The AWS sources run the aws CLI with its usual credentials chain. "" and
+// "env" return nil: the token comes from flag, env or ~/.axiom.toml.
+func ParseTokenSource(spec string, refresh time.Duration) (TokenSource, error) {
+	if spec == "" || spec == "env" {
+		return nil, nil
+	}
+	kind, arg, ok := strings.Cut(spec, ":")
+	if !ok || arg == "" {
+		return nil, fmt.Errorf("invalid token source %q (want file:, exec:, ssm: or secretsmanager:)", spec)
+	}
+	switch kind {
+	case "file":
+		return FileToken(arg), nil
+	case "exec":
+		return CommandToken(refresh, "sh", "-c", arg), nil
+	case "ssm":
+		return CommandToken(refresh, "aws", "ssm", "get-parameter", "--with-decryption",
+			"--name", arg, "--query", "Parameter.Value", "--output", "text"), nil
+	case "secretsmanager":
+		return CommandToken(refresh, "aws", "secretsmanager", "get-secret-value",
+			"--secret-id", arg, "--query", "SecretString", "--output", "text"), nil
+	default:
+		return nil, fmt.Errorf("unknown token source %q (want file, exec, ssm or secretsmanager)", kind)
+	}
+}
+
+// fileToken rereads its file whenever the file's modification time or size
+// changes, so rotating the token on disk needs no remount.
+type fileToken struct {
+	path string
+
+	mu      sync.Mutex
+	modTime time.Time
+	size    int64
+	token   string
+}
+
+// FileToken reads the token from path, reloading it when the file changes.
+func FileToken(path string) TokenSource {
+	return &fileToken{path: path}
+}
+
+func (f *fileToken) Token(context.Context) (string, error) {
+	info, err := os.Stat(f.path)
+	if err != nil {
+		return "", fmt.Errorf("token file: %w", err)
+	}
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if f.token != "" && info.ModTime().Equal(f.modTime) && info.Size() == f.size {
+		return f.token, nil
+	}
+	data, err := os.ReadFile(f.path)
+	if err != nil {
+		return "", fmt.Errorf("token file: %w", err)
+	}
+	token := strings.TrimSpace(string(data))
+	if token == "" {
+		return "", fmt.Errorf("token file %s is empty", f.path)
+	}
+	f.token, f.modTime, f.size = token, info.ModTime(), info.Size()
+	return token, nil
+}
+
+// commandToken runs a command for the token and reuses its output until
+// refresh has passed.
+type commandToken struct {
+	name    string
+	args    []string
+	refresh time.Duration
+
+	mu      sync.Mutex
+	token   string
+	fetched time.Time
+}
+
+// CommandToken runs name with args and uses its trimmed standard output as
+// the token for refresh (DefaultTokenRefresh when zero).
+func CommandToken(refresh time.Duration, name string, args ...string) TokenSource {
+	if refresh <= 0 {
+		refresh = DefaultTokenRefresh
+	}
+	return &commandToken{name: name, args: args, refresh: refresh}
+}
+
+func (c *commandToken) Token(ctx context.Context) (string, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.token != "" && time.Since(c.fetched) < c.refresh {
+		return c.token, nil
+	}
+	var stderr bytes.Buffer
+	cmd := exec.CommandContext(ctx, c.name, c.args...)
+	cmd.Stderr = &stderr
+	out, err := cmd.Output()
+	if err != nil {
+		if msg := strings.TrimSpace(stderr.String()); msg != "" {
+			err = fmt.Errorf("%w: %s", err, msg)
+		}
+		return "", fmt.Errorf("token command %s: %w", c.name, err)
+	}
+	token := strings.TrimSpace(string(out))
+	if token == "" {
+		return "", fmt.Errorf("token command %s printed no token", c.name)
+	}
+	c.token, c.fetched = token, time.Now()
+	return token, nil
+}
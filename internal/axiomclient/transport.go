@@ -0,0 +1,92 @@
+package axiomclient
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"net"
+	"net/http"
+	"os"
+	"time"
+
+	"github.com/axiomhq/axiom-fs/internal/atrest"
+)
+
+// DefaultMaxIdleConnsPerHost is the idle connection pool size kept for the
+// Axiom API host. Reads fan out into many concurrent queries, so the
+// net/http default of 2 would churn TLS handshakes.
+const DefaultMaxIdleConnsPerHost = 16
+
+type options struct {
+	maxIdleConnsPerHost int
+	caFile              string
+	disableCompression  bool
+	replayDir           string
+	replayMode          string
+	tokenSource         TokenSource
+	spoolDir            string
+	spoolSealer         *atrest.Sealer
+}
+
+// Option configures how the client talks to the Axiom API.
+type Option func(*options)
+
+// WithMaxIdleConnsPerHost sets how many idle keep-alive connections are
+// kept to the Axiom API. Zero keeps the default.
+func WithMaxIdleConnsPerHost(n int) Option {
+	return func(o *options) {
+		if n > 0 {
+			o.maxIdleConnsPerHost = n
+		}
+	}
+}
+
+// WithCAFile trusts the PEM certificates in path in addition to the system
+// roots, for self-hosted Axiom or TLS-intercepting proxies.
+func WithCAFile(path string) Option {
+	return func(o *options) {
+		o.caFile = path
+	}
+}
+
+// newTransport returns a keep-alive, HTTP/2-capable transport that honours
+// HTTPS_PROXY, HTTP_PROXY and NO_PROXY.
+func newTransport(o options) (*http.Transport, error) {
+	t := &http.Transport{
+		Proxy: http.ProxyFromEnvironment,
+		DialContext: (&net.Dialer{
+			Timeout:   30 * time.Second,
+			KeepAlive: 30 * time.Second,
+		}).DialContext,
+		ForceAttemptHTTP2:     true,
+		MaxIdleConns:          100,
+		MaxIdleConnsPerHost:   o.maxIdleConnsPerHost,
+		IdleConnTimeout:       90 * time.Second,
+		TLSHandshakeTimeout:   10 * time.Second,
+		ExpectContinueTimeout: time.Second,
+		DisableCompression:    o.disableCompression,
+	}
+	if o.caFile != "" {
+		pool, err := loadCAFile(o.caFile)
+		if err != nil {
+			return nil, err
+		}
+		t.TLSClientConfig = &tls.Config{RootCAs: pool, MinVersion: tls.VersionTLS12}
+	}
+	return t, nil
+}
+
+func loadCAFile(path string) (*x509.CertPool, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("read CA file: %w", err)
+	}
+	pool, err := x509.SystemCertPool()
+	if err != nil || pool == nil {
+		pool = x509.NewCertPool()
+	}
+	if !pool.AppendCertsFromPEM(data) {
+		return nil, fmt.Errorf("CA file %s contains no PEM certificates", path)
+	}
+	return pool, nil
+}
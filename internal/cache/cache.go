@@ -1,161 +1,421 @@
 package cache
 
 import (
+	"container/list"
 	"crypto/sha256"
 	"encoding/hex"
 	"os"
 	"path/filepath"
 	"sort"
+	"strings"
 	"sync"
 	"time"
+
+	"github.com/axiomhq/axiom-fs/internal/atrest"
 )
 
 type Entry struct {
 	Bytes     []byte
+	StoredAt  time.Time
 	ExpiresAt time.Time
 }
 
+// Segment is a partition of the cache with its own limits, so large
+// results cannot evict small ones or the metadata describing them.
+type Segment int
+
+const (
+	// SegmentMeta holds entries stored with SetMeta: result metadata,
+	// counts and estimates.
+	SegmentMeta Segment = iota
+	// SegmentSmall holds results below the large threshold.
+	SegmentSmall
+	// SegmentLarge holds results of at least the large threshold.
+	SegmentLarge
+	numSegments
+)
+
+var segmentNames = [numSegments]string{"meta", "small", "large"}
+
+func (s Segment) String() string {
+	return segmentNames[s]
+}
+
+// Limits bound a segment. Zero means unlimited.
+type Limits struct {
+	MaxEntries int
+	MaxBytes   int
+}
+
+// Stats are the cache's counters since it was created. A hit is a lookup
+// served from memory or disk, a miss one that found nothing and an eviction
+// an entry dropped from memory to make room.
+type Stats struct {
+	Hits      int64          `json:"hits"`
+	Misses    int64          `json:"misses"`
+	Evictions int64          `json:"evictions"`
+	Segments  []SegmentStats `json:"segments"`
+}
+
+// SegmentStats describes the live state of a segment.
+type SegmentStats struct {
+	Segment    string `json:"segment"`
+	Entries    int    `json:"entries"`
+	Bytes      int    `json:"bytes"`
+	MaxEntries int    `json:"max_entries,omitempty"`
+	MaxBytes   int    `json:"max_bytes,omitempty"`
+	Hits       int64  `json:"hits"`
+	Evictions  int64  `json:"evictions"`
+}
+
+// Option configures a Cache.
+type Option func(*Cache)
+
+// WithSegmentLimits overrides the limits of one segment.
+func WithSegmentLimits(s Segment, limits Limits) Option {
+	return func(c *Cache) {
+		c.segments[s].limits = limits
+	}
+}
+
+// WithLargeThreshold stores results of at least n bytes in SegmentLarge.
+// Zero keeps every result in SegmentSmall.
+func WithLargeThreshold(n int) Option {
+	return func(c *Cache) {
+		c.largeThreshold = n
+	}
+}
+
+// WithEncryption seals disk entries with s. Entries that do not open with
+// it, such as plaintext ones from before it was set, are dropped as misses.
+func WithEncryption(s *atrest.Sealer) Option {
+	return func(c *Cache) {
+		c.sealer = s
+	}
+}
+
+// WithNamespace keeps disk entries apart from those written under another
+// namespace in the same directory, such as results redacted by other
+// rules.
+func WithNamespace(ns string) Option {
+	return func(c *Cache) {
+		c.namespace = ns
+	}
+}
+
+// WithMaxStale keeps entries d past their TTL, in memory and on disk, for
+// LookupStale. Lookup misses them as before.
+func WithMaxStale(d time.Duration) Option {
+	return func(c *Cache) {
+		c.maxStale = d
+	}
+}
+
+// segment is an LRU: recent holds keys most recently used first.
+type segment struct {
+	// prefix is prepended to the file names of the segment's disk entries.
+	prefix    string
+	items     map[string]*list.Element
+	recent    *list.List
+	size      int
+	limits    Limits
+	hits      int64
+	evictions int64
+}
+
+type lruEntry struct {
+	key   string
+	entry Entry
+}
+
 type Cache struct {
-	mu         sync.Mutex
-	items      map[string]Entry
-	ttl        time.Duration
-	order      []string
-	size       int
-	maxEntries int
-	maxBytes   int
-	dir        string
+	mu             sync.Mutex
+	segments       [numSegments]*segment
+	largeThreshold int
+	misses         int64
+	ttl            time.Duration
+	// maxStale is how long entries are kept past ttl; see WithMaxStale.
+	maxStale time.Duration
+	dir      string
+	// sealer encrypts disk entries; nil writes them in plaintext.
+	sealer *atrest.Sealer
+	// namespace is mixed into disk entry names; see WithNamespace.
+	namespace string
+
+	// diskRemoved counts disk entries removed for expiry or limits;
+	// lastSweep is when Sweep last ran.
+	diskRemoved int64
+	lastSweep   time.Time
 }
 
-func New(ttl time.Duration, maxEntries, maxBytes int, dir string) *Cache {
+// New returns a cache whose segments are each bounded by maxEntries and
+// maxBytes unless overridden with WithSegmentLimits.
+func New(ttl time.Duration, maxEntries, maxBytes int, dir string, opts ...Option) *Cache {
 	if dir != "" {
 		_ = os.MkdirAll(dir, 0o755)
 	}
-	return &Cache{
-		items:      make(map[string]Entry),
-		ttl:        ttl,
-		maxEntries: maxEntries,
-		maxBytes:   maxBytes,
-		dir:        dir,
+	c := &Cache{
+		ttl: ttl,
+		dir: dir,
+	}
+	for s := range c.segments {
+		c.segments[s] = &segment{
+			items:  make(map[string]*list.Element),
+			recent: list.New(),
+			limits: Limits{MaxEntries: maxEntries, MaxBytes: maxBytes},
+		}
+	}
+	// Small results keep the unprefixed disk layout of earlier versions.
+	c.segments[SegmentMeta].prefix = "meta-"
+	c.segments[SegmentLarge].prefix = "large-"
+	for _, opt := range opts {
+		opt(c)
+	}
+	return c
+}
+
+// TTL is how long entries are kept; zero means forever.
+func (c *Cache) TTL() time.Duration {
+	return c.ttl
+}
+
+// Stats reports the cache counters and each segment, in Segment order.
+func (c *Cache) Stats() Stats {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	stats := Stats{Misses: c.misses, Segments: make([]SegmentStats, 0, numSegments)}
+	for s, seg := range c.segments {
+		stats.Hits += seg.hits
+		stats.Evictions += seg.evictions
+		stats.Segments = append(stats.Segments, SegmentStats{
+			Segment:    Segment(s).String(),
+			Entries:    len(seg.items),
+			Bytes:      seg.size,
+			MaxEntries: seg.limits.MaxEntries,
+			MaxBytes:   seg.limits.MaxBytes,
+			Hits:       seg.hits,
+			Evictions:  seg.evictions,
+		})
 	}
+	return stats
 }
 
 func (c *Cache) Get(key string) ([]byte, bool) {
+	entry, ok := c.Lookup(key)
+	return entry.Bytes, ok
+}
+
+// Lookup is like Get but returns the full entry, including when it was stored.
+func (c *Cache) Lookup(key string) (Entry, bool) {
+	return c.lookup(key, 0)
+}
+
+// LookupStale is like Lookup but also returns an entry that expired up to
+// maxStale ago, bounded by WithMaxStale; its ExpiresAt is then in the past.
+func (c *Cache) LookupStale(key string, maxStale time.Duration) (Entry, bool) {
+	return c.lookup(key, min(maxStale, c.maxStale))
+}
+
+func (c *Cache) lookup(key string, stale time.Duration) (Entry, bool) {
 	c.mu.Lock()
 	defer c.mu.Unlock()
 
-	entry, ok := c.items[key]
-	if !ok {
-		if c.dir != "" {
-			return c.getDiskLocked(key)
+	for _, seg := range c.segments {
+		elem, ok := seg.items[key]
+		if !ok {
+			continue
+		}
+		entry := elem.Value.(*lruEntry).entry
+		if c.ttl > 0 {
+			expired := time.Since(entry.ExpiresAt)
+			if expired > c.maxStale {
+				seg.remove(key)
+				break
+			}
+			if expired > stale {
+				break
+			}
 		}
-		return nil, false
+		seg.recent.MoveToFront(elem)
+		seg.hits++
+		return entry, true
 	}
-	if c.ttl > 0 && time.Now().After(entry.ExpiresAt) {
-		c.removeLocked(key)
-		if c.dir != "" {
-			return c.getDiskLocked(key)
+	if c.dir != "" {
+		for s := range c.segments {
+			if entry, ok := c.getDiskLocked(Segment(s), key, stale); ok {
+				return entry, true
+			}
 		}
-		return nil, false
 	}
-	return entry.Bytes, true
+	c.misses++
+	return Entry{}, false
 }
 
+// retention is how long after its mtime a disk entry is kept.
+func (c *Cache) retention() time.Duration {
+	return c.ttl + c.maxStale
+}
+
+// Set stores a result in SegmentSmall or SegmentLarge depending on its size.
 func (c *Cache) Set(key string, value []byte) {
+	s := SegmentSmall
+	if c.largeThreshold > 0 && len(value) >= c.largeThreshold {
+		s = SegmentLarge
+	}
+	c.set(s, key, value)
+}
+
+// SetMeta stores a small entry describing results in SegmentMeta, where
+// result churn cannot evict it.
+func (c *Cache) SetMeta(key string, value []byte) {
+	c.set(SegmentMeta, key, value)
+}
+
+func (c *Cache) set(s Segment, key string, value []byte) {
 	c.mu.Lock()
 	defer c.mu.Unlock()
 
-	if entry, ok := c.items[key]; ok {
-		c.size -= len(entry.Bytes)
-		c.removeKeyLocked(key)
+	for other, seg := range c.segments {
+		if _, ok := seg.items[key]; ok {
+			seg.remove(key)
+		}
+		if other != int(s) && c.dir != "" {
+			_ = os.Remove(c.diskPath(Segment(other), key))
+		}
 	}
 
-	entry := Entry{
+	seg := c.segments[s]
+	now := time.Now()
+	seg.add(key, Entry{
 		Bytes:     value,
-		ExpiresAt: time.Now().Add(c.ttl),
-	}
-	c.items[key] = entry
-	c.order = append(c.order, key)
-	c.size += len(value)
-	c.evictLocked()
+		StoredAt:  now,
+		ExpiresAt: now.Add(c.ttl),
+	})
 
-	if c.dir != "" && c.shouldPersist(len(value)) {
-		_ = c.writeDiskLocked(key, value)
-		c.evictDiskLocked()
+	if c.dir != "" && seg.shouldPersist(len(value)) {
+		_ = c.writeDiskLocked(s, key, value)
+		c.evictDiskLocked(s)
 	}
 }
 
-func (c *Cache) removeLocked(key string) {
-	if entry, ok := c.items[key]; ok {
-		c.size -= len(entry.Bytes)
-		delete(c.items, key)
-		c.removeKeyLocked(key)
+// Flush writes live in-memory entries missing from the disk cache, e.g.
+// after disk eviction, so they survive a restart. Entries keep their
+// original store time. It is a no-op without a cache directory.
+func (c *Cache) Flush() error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if c.dir == "" {
+		return nil
+	}
+	now := time.Now()
+	for s, seg := range c.segments {
+		for elem := seg.recent.Back(); elem != nil; elem = elem.Prev() {
+			lru := elem.Value.(*lruEntry)
+			if (c.ttl > 0 && now.After(lru.entry.ExpiresAt)) || !seg.shouldPersist(len(lru.entry.Bytes)) {
+				continue
+			}
+			path := c.diskPath(Segment(s), lru.key)
+			if _, err := os.Stat(path); err == nil {
+				continue
+			}
+			if err := c.writeDiskLocked(Segment(s), lru.key, lru.entry.Bytes); err != nil {
+				return err
+			}
+			_ = os.Chtimes(path, lru.entry.StoredAt, lru.entry.StoredAt)
+		}
 	}
+	return nil
 }
 
-func (c *Cache) removeKeyLocked(key string) {
-	for i, existing := range c.order {
-		if existing == key {
-			c.order = append(c.order[:i], c.order[i+1:]...)
-			return
-		}
+func (s *segment) add(key string, entry Entry) {
+	s.items[key] = s.recent.PushFront(&lruEntry{key: key, entry: entry})
+	s.size += len(entry.Bytes)
+	s.evict()
+}
+
+func (s *segment) remove(key string) {
+	if elem, ok := s.items[key]; ok {
+		s.removeElement(elem)
 	}
 }
 
-func (c *Cache) evictLocked() {
-	for c.shouldEvictLocked() {
-		if len(c.order) == 0 {
+func (s *segment) removeElement(elem *list.Element) {
+	lru := s.recent.Remove(elem).(*lruEntry)
+	delete(s.items, lru.key)
+	s.size -= len(lru.entry.Bytes)
+}
+
+// evict drops least recently used entries until the segment fits its limits.
+func (s *segment) evict() {
+	for s.shouldEvict(s.size, len(s.items)) {
+		elem := s.recent.Back()
+		if elem == nil {
 			return
 		}
-		key := c.order[0]
-		c.order = c.order[1:]
-		if entry, ok := c.items[key]; ok {
-			c.size -= len(entry.Bytes)
-			delete(c.items, key)
-		}
+		s.removeElement(elem)
+		s.evictions++
 	}
 }
 
-func (c *Cache) shouldEvictLocked() bool {
-	if c.maxEntries > 0 && len(c.items) > c.maxEntries {
+func (s *segment) shouldEvict(total int, count int) bool {
+	if s.limits.MaxEntries > 0 && count > s.limits.MaxEntries {
 		return true
 	}
-	if c.maxBytes > 0 && c.size > c.maxBytes {
+	if s.limits.MaxBytes > 0 && total > s.limits.MaxBytes {
 		return true
 	}
 	return false
 }
 
-func (c *Cache) shouldPersist(size int) bool {
-	if c.maxBytes > 0 && size > c.maxBytes {
+func (s *segment) shouldPersist(size int) bool {
+	if s.limits.MaxBytes > 0 && size > s.limits.MaxBytes {
 		return false
 	}
 	return true
 }
 
-func (c *Cache) getDiskLocked(key string) ([]byte, bool) {
-	path := c.diskPath(key)
+func (c *Cache) getDiskLocked(s Segment, key string, stale time.Duration) (Entry, bool) {
+	path := c.diskPath(s, key)
 	info, err := os.Stat(path)
 	if err != nil {
-		return nil, false
+		return Entry{}, false
 	}
-	if c.ttl > 0 && time.Since(info.ModTime()) > c.ttl {
+	age := time.Since(info.ModTime())
+	if c.ttl > 0 && age > c.retention() {
 		_ = os.Remove(path)
-		return nil, false
+		return Entry{}, false
+	}
+	if c.ttl > 0 && age > c.ttl+stale {
+		return Entry{}, false
 	}
 	data, err := os.ReadFile(path)
 	if err != nil {
-		return nil, false
+		return Entry{}, false
 	}
-	_ = os.Chtimes(path, time.Now(), time.Now())
-	c.items[key] = Entry{Bytes: data, ExpiresAt: time.Now().Add(c.ttl)}
-	c.order = append(c.order, key)
-	c.size += len(data)
-	c.evictLocked()
-	return data, true
+	if data, err = c.sealer.Open(data); err != nil {
+		_ = os.Remove(path)
+		return Entry{}, false
+	}
+	entry := Entry{Bytes: data, StoredAt: info.ModTime(), ExpiresAt: info.ModTime().Add(c.ttl)}
+	if c.ttl <= 0 || age <= c.ttl {
+		// A stale entry keeps its mtime, so it still expires for good.
+		_ = os.Chtimes(path, time.Now(), time.Now())
+		entry.ExpiresAt = time.Now().Add(c.ttl)
+	}
+	seg := c.segments[s]
+	seg.hits++
+	seg.add(key, entry)
+	return entry, true
 }
 
-func (c *Cache) writeDiskLocked(key string, data []byte) error {
-	path := c.diskPath(key)
+func (c *Cache) writeDiskLocked(s Segment, key string, data []byte) error {
+	path := c.diskPath(s, key)
+	data, err := c.sealer.Seal(data)
+	if err != nil {
+		return err
+	}
 	tmp, err := os.CreateTemp(c.dir, "cache-*")
 	if err != nil {
 		return err
@@ -172,41 +432,57 @@ func (c *Cache) writeDiskLocked(key string, data []byte) error {
 	return os.Rename(tmp.Name(), path)
 }
 
-func (c *Cache) diskPath(key string) string {
+func (c *Cache) diskPath(s Segment, key string) string {
+	if c.namespace != "" {
+		key = c.namespace + "\x00" + key
+	}
 	sum := sha256.Sum256([]byte(key))
-	return filepath.Join(c.dir, hex.EncodeToString(sum[:]))
+	return filepath.Join(c.dir, c.segments[s].prefix+hex.EncodeToString(sum[:]))
 }
 
-func (c *Cache) evictDiskLocked() {
+// evictDiskLocked removes the oldest disk entries of a segment until it
+// fits its limits.
+func (c *Cache) evictDiskLocked(s Segment) {
 	if c.dir == "" {
 		return
 	}
-	entries, total := c.listDiskLocked()
-	for c.shouldEvictDisk(total, len(entries)) {
+	seg := c.segments[s]
+	entries, total := c.listDiskLocked(s)
+	for seg.shouldEvict(total, len(entries)) {
 		if len(entries) == 0 {
 			return
 		}
 		entry := entries[0]
-		_ = os.Remove(entry.path)
+		if os.Remove(entry.path) == nil {
+			c.diskRemoved++
+		}
 		total -= entry.size
 		entries = entries[1:]
 	}
 }
 
-func (c *Cache) listDiskLocked() ([]diskEntry, int) {
+// listDiskLocked returns the disk entries of a segment, oldest first.
+func (c *Cache) listDiskLocked(s Segment) ([]diskEntry, int) {
 	entries := []diskEntry{}
 	total := 0
 	items, err := os.ReadDir(c.dir)
 	if err != nil {
 		return entries, total
 	}
+	prefix := c.segments[s].prefix
 	for _, item := range items {
+		name, ok := strings.CutPrefix(item.Name(), prefix)
+		if !ok || len(name) != sha256.Size*2 || item.IsDir() {
+			continue
+		}
 		info, err := item.Info()
 		if err != nil {
 			continue
 		}
-		if c.ttl > 0 && time.Since(info.ModTime()) > c.ttl {
-			_ = os.Remove(filepath.Join(c.dir, item.Name()))
+		if c.ttl > 0 && time.Since(info.ModTime()) > c.retention() {
+			if os.Remove(filepath.Join(c.dir, item.Name())) == nil {
+				c.diskRemoved++
+			}
 			continue
 		}
 		entries = append(entries, diskEntry{
@@ -220,16 +496,6 @@ func (c *Cache) listDiskLocked() ([]diskEntry, int) {
 	return entries, total
 }
 
-func (c *Cache) shouldEvictDisk(total int, count int) bool {
-	if c.maxEntries > 0 && count > c.maxEntries {
-		return true
-	}
-	if c.maxBytes > 0 && total > c.maxBytes {
-		return true
-	}
-	return false
-}
-
 type diskEntry struct {
 	path string
 	mod  time.Time
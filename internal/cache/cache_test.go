@@ -1,10 +1,14 @@
 package cache
 
 import (
+	"bytes"
 	"os"
 	"path/filepath"
+	"strings"
 	"testing"
 	"time"
+
+	"github.com/axiomhq/axiom-fs/internal/atrest"
 )
 
 func TestCacheBasicGetSet(t *testing.T) {
@@ -40,6 +44,23 @@ func TestCacheBasicGetSet(t *testing.T) {
 	})
 }
 
+func TestCacheLookupStoredAt(t *testing.T) {
+	c := New(time.Hour, 100, 0, "")
+	before := time.Now()
+	c.Set("key", []byte("value"))
+
+	entry, ok := c.Lookup("key")
+	if !ok {
+		t.Fatal("expected key to exist")
+	}
+	if entry.StoredAt.Before(before) || entry.StoredAt.After(time.Now()) {
+		t.Errorf("StoredAt = %v, want between %v and now", entry.StoredAt, before)
+	}
+	if string(entry.Bytes) != "value" {
+		t.Errorf("got %q, want %q", entry.Bytes, "value")
+	}
+}
+
 func TestCacheTTLExpiration(t *testing.T) {
 	c := New(50*time.Millisecond, 100, 0, "")
 
@@ -101,14 +122,38 @@ func TestCacheMaxBytesEviction(t *testing.T) {
 
 	c.Set("d", []byte("0000"))
 
-	if _, ok := c.Get("a"); ok {
-		t.Error("key a should have been evicted (exceeds max bytes)")
+	if _, ok := c.Get("b"); ok {
+		t.Error("key b should have been evicted (least recently used)")
+	}
+	if _, ok := c.Get("a"); !ok {
+		t.Error("key a should survive, it was read after b")
 	}
 	if _, ok := c.Get("d"); !ok {
 		t.Error("key d should exist")
 	}
 }
 
+func TestCacheLRU(t *testing.T) {
+	c := New(time.Hour, 2, 0, "")
+
+	c.Set("hot", []byte("1"))
+	c.Set("cold", []byte("2"))
+	c.Get("hot")
+	c.Set("new", []byte("3"))
+
+	if _, ok := c.Get("cold"); ok {
+		t.Error("cold should be evicted before the recently read hot")
+	}
+	if _, ok := c.Get("hot"); !ok {
+		t.Error("hot should survive")
+	}
+
+	stats := c.Stats()
+	if stats.Hits != 2 || stats.Misses != 1 || stats.Evictions != 1 {
+		t.Errorf("stats = %+v", stats)
+	}
+}
+
 func TestCacheDiskPersistence(t *testing.T) {
 	dir := t.TempDir()
 	c := New(time.Hour, 100, 0, dir)
@@ -134,6 +179,130 @@ func TestCacheDiskPersistence(t *testing.T) {
 	}
 }
 
+func TestCacheFlush(t *testing.T) {
+	dir := t.TempDir()
+	c := New(time.Hour, 100, 0, dir)
+	c.Set("flushed", []byte("value"))
+	// Simulate the disk copy being evicted while the entry stays in memory.
+	if err := os.Remove(c.diskPath(SegmentSmall, "flushed")); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := c.Flush(); err != nil {
+		t.Fatal(err)
+	}
+	got, ok := New(time.Hour, 100, 0, dir).Get("flushed")
+	if !ok || string(got) != "value" {
+		t.Errorf("after Flush got %q, %v", got, ok)
+	}
+
+	if err := New(time.Hour, 100, 0, "").Flush(); err != nil {
+		t.Errorf("Flush without dir: %v", err)
+	}
+}
+
+func TestCachePurge(t *testing.T) {
+	dir := t.TempDir()
+	c := New(time.Hour, 100, 0, dir)
+	c.Set("a", []byte("1"))
+	c.SetMeta("b", []byte("2"))
+	// An entry only on disk, as after a restart.
+	New(time.Hour, 100, 0, dir).Set("c", []byte("3"))
+
+	if got := c.Purge(); got != 3 {
+		t.Errorf("Purge = %d, want 3", got)
+	}
+	for _, key := range []string{"a", "c"} {
+		if _, ok := c.Get(key); ok {
+			t.Errorf("Get(%q) after Purge still hit", key)
+		}
+	}
+	if _, ok := New(time.Hour, 100, 0, dir).Lookup("c"); ok {
+		t.Error("disk entry survived Purge")
+	}
+}
+
+func TestCacheRemove(t *testing.T) {
+	dir := t.TempDir()
+	c := New(time.Hour, 100, 0, dir)
+	c.Set("q|csv", []byte("1"))
+	c.SetMeta("q|csv|meta", []byte("2"))
+	c.Set("other|csv", []byte("3"))
+	// An entry only on disk, as after a restart.
+	New(time.Hour, 100, 0, dir).Set("q|json", []byte("4"))
+
+	match := func(key string) bool { return strings.HasPrefix(key, "q|") }
+	if got := c.Remove(match, "q|json", "q|tsv"); got != 3 {
+		t.Errorf("Remove = %d, want 3", got)
+	}
+	fresh := New(time.Hour, 100, 0, dir)
+	for _, key := range []string{"q|csv", "q|csv|meta", "q|json"} {
+		if _, ok := fresh.Get(key); ok {
+			t.Errorf("Get(%q) after Remove still hit", key)
+		}
+	}
+	if _, ok := c.Get("other|csv"); !ok {
+		t.Error("unmatched entry removed")
+	}
+}
+
+func TestCacheRetain(t *testing.T) {
+	dir := t.TempDir()
+	c := New(20*time.Millisecond, 100, 0, dir)
+	c.Set("['gone'] | count|csv", []byte("1"))
+	c.Set("['logs'] | count|csv", []byte("2"))
+
+	match := func(key string) bool { return strings.HasPrefix(key, "['gone']") }
+	if got := c.Retain(match, time.Now().Add(time.Hour)); got != 1 {
+		t.Errorf("Retain = %d, want 1", got)
+	}
+	time.Sleep(40 * time.Millisecond)
+	if _, ok := c.Get("['gone'] | count|csv"); !ok {
+		t.Error("retained entry expired")
+	}
+	if _, ok := c.Get("['logs'] | count|csv"); ok {
+		t.Error("other entry outlived its TTL")
+	}
+	if _, ok := New(20*time.Millisecond, 100, 0, dir).Get("['gone'] | count|csv"); !ok {
+		t.Error("retained entry expired on disk")
+	}
+}
+
+func TestCacheEncryption(t *testing.T) {
+	dir := t.TempDir()
+	sealer, err := atrest.New(bytes.Repeat([]byte{7}, atrest.KeySize))
+	if err != nil {
+		t.Fatal(err)
+	}
+	New(time.Hour, 100, 0, dir, WithEncryption(sealer)).Set("k", []byte("secret result"))
+
+	files, _ := os.ReadDir(dir)
+	for _, f := range files {
+		data, _ := os.ReadFile(filepath.Join(dir, f.Name()))
+		if bytes.Contains(data, []byte("secret")) {
+			t.Errorf("%s holds the plaintext", f.Name())
+		}
+	}
+	if got, ok := New(time.Hour, 100, 0, dir, WithEncryption(sealer)).Get("k"); !ok || string(got) != "secret result" {
+		t.Errorf("Get with the key = %q, %v", got, ok)
+	}
+	// Without the key the entry is a miss, not ciphertext.
+	if got, ok := New(time.Hour, 100, 0, dir).Get("k"); ok {
+		t.Errorf("Get without the key = %q", got)
+	}
+}
+
+func TestCacheNamespace(t *testing.T) {
+	dir := t.TempDir()
+	New(time.Hour, 100, 0, dir, WithNamespace("a")).Set("k", []byte("v"))
+	if got, ok := New(time.Hour, 100, 0, dir, WithNamespace("b")).Get("k"); ok {
+		t.Errorf("Get in another namespace = %q", got)
+	}
+	if got, ok := New(time.Hour, 100, 0, dir, WithNamespace("a")).Get("k"); !ok || string(got) != "v" {
+		t.Errorf("Get in the same namespace = %q, %v", got, ok)
+	}
+}
+
 func TestCacheDiskTTLExpiration(t *testing.T) {
 	dir := t.TempDir()
 	c := New(50*time.Millisecond, 100, 0, dir)
@@ -158,6 +327,35 @@ func TestCacheDiskTTLExpiration(t *testing.T) {
 	}
 }
 
+func TestCacheLookupStale(t *testing.T) {
+	dir := t.TempDir()
+	c := New(50*time.Millisecond, 100, 0, dir, WithMaxStale(time.Hour))
+	c.Set("k", []byte("v"))
+	time.Sleep(100 * time.Millisecond)
+
+	if _, ok := c.Get("k"); ok {
+		t.Error("Get served an expired entry")
+	}
+	if _, ok := c.LookupStale("k", 10*time.Millisecond); ok {
+		t.Error("LookupStale served an entry expired past its maxStale")
+	}
+	entry, ok := c.LookupStale("k", time.Hour)
+	if !ok || string(entry.Bytes) != "v" || !entry.ExpiresAt.Before(time.Now()) {
+		t.Fatalf("LookupStale = %q, expires %v, %v", entry.Bytes, entry.ExpiresAt, ok)
+	}
+
+	// The stale entry is kept on disk too, without its mtime refreshed.
+	entry, ok = New(50*time.Millisecond, 100, 0, dir, WithMaxStale(time.Hour)).LookupStale("k", time.Hour)
+	if !ok || !entry.ExpiresAt.Before(time.Now()) {
+		t.Fatalf("disk LookupStale expires %v, %v", entry.ExpiresAt, ok)
+	}
+
+	// Without WithMaxStale, expired entries are gone.
+	if _, ok := New(50*time.Millisecond, 100, 0, dir).LookupStale("k", time.Hour); ok {
+		t.Error("LookupStale past the cache's max-stale")
+	}
+}
+
 func TestCacheDiskEviction(t *testing.T) {
 	dir := t.TempDir()
 	c := New(time.Hour, 2, 0, dir)
@@ -191,10 +389,10 @@ func TestCacheNoDir(t *testing.T) {
 func TestCacheShouldPersist(t *testing.T) {
 	c := New(time.Hour, 0, 10, "")
 
-	if !c.shouldPersist(5) {
+	if !c.segments[SegmentSmall].shouldPersist(5) {
 		t.Error("should persist small values")
 	}
-	if c.shouldPersist(20) {
+	if c.segments[SegmentSmall].shouldPersist(20) {
 		t.Error("should not persist values larger than maxBytes")
 	}
 }
@@ -202,7 +400,7 @@ func TestCacheShouldPersist(t *testing.T) {
 func TestCacheDiskPath(t *testing.T) {
 	c := New(time.Hour, 0, 0, "/tmp/cache")
 
-	path := c.diskPath("testkey")
+	path := c.diskPath(SegmentSmall, "testkey")
 	if !filepath.IsAbs(path) {
 		t.Error("path should be absolute")
 	}
@@ -243,3 +441,84 @@ func TestCacheConcurrency(t *testing.T) {
 		<-done
 	}
 }
+
+func TestCacheSegments(t *testing.T) {
+	dir := t.TempDir()
+	opts := []Option{
+		WithSegmentLimits(SegmentMeta, Limits{MaxEntries: 2}),
+		WithSegmentLimits(SegmentLarge, Limits{MaxBytes: 100}),
+		WithLargeThreshold(8),
+	}
+	c := New(time.Hour, 0, 10, dir, opts...)
+
+	c.SetMeta("meta|a", []byte("1"))
+	c.Set("small", []byte("abc"))
+	c.Set("big", make([]byte, 60))
+	c.Set("bigger", make([]byte, 60))
+
+	if _, ok := c.Get("meta|a"); !ok {
+		t.Error("large results evicted metadata")
+	}
+	if _, ok := c.Get("small"); !ok {
+		t.Error("large results evicted a small result")
+	}
+	if _, ok := c.Get("big"); ok {
+		t.Error("big should be evicted by bigger")
+	}
+
+	// A key moving between segments leaves no stale copy behind.
+	c.Set("small", make([]byte, 20))
+	stats := c.Stats()
+	if len(stats.Segments) != 3 || stats.Segments[SegmentSmall].Entries != 0 || stats.Segments[SegmentLarge].Entries != 2 {
+		t.Errorf("stats = %+v", stats)
+	}
+	if stats.Segments[SegmentLarge].Evictions != 1 || stats.Segments[SegmentMeta].Hits != 1 || stats.Segments[SegmentMeta].Segment != "meta" {
+		t.Errorf("stats = %+v", stats)
+	}
+
+	// Segments persist under their own names and reload into the same segment.
+	c2 := New(time.Hour, 0, 10, dir, opts...)
+	if got, ok := c2.Get("small"); !ok || len(got) != 20 {
+		t.Errorf("reloaded small = %d bytes, %v", len(got), ok)
+	}
+	if _, err := os.Stat(c2.diskPath(SegmentMeta, "meta|a")); err != nil {
+		t.Errorf("meta entry not on disk: %v", err)
+	}
+	if c2.Stats().Segments[SegmentLarge].Entries != 1 {
+		t.Errorf("stats = %+v", c2.Stats())
+	}
+}
+
+func TestCacheSweep(t *testing.T) {
+	dir := t.TempDir()
+	c := New(time.Hour, 0, 0, dir)
+	c.Set("a", []byte("1"))
+	c.Set("b", []byte("22"))
+	c.Set("c", []byte("333"))
+	for i, key := range []string{"a", "b", "c"} {
+		mod := time.Now().Add(time.Duration(i-3) * time.Minute)
+		_ = os.Chtimes(c.diskPath(SegmentSmall, key), mod, mod)
+	}
+	stale := filepath.Join(dir, "cache-123")
+	if err := os.WriteFile(stale, []byte("partial"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	old := time.Now().Add(-2 * staleTempAge)
+	_ = os.Chtimes(stale, old, old)
+
+	// A restart with tighter limits shrinks the disk cache without a Set.
+	usage := New(time.Hour, 2, 0, dir).Sweep()
+	if got := usage.Segments[SegmentSmall]; got.Entries != 2 || got.Bytes != 5 {
+		t.Errorf("small usage = %+v", got)
+	}
+	if usage.Removed != 1 || usage.LastSweep == nil || usage.Bytes != 5 {
+		t.Errorf("usage = %+v", usage)
+	}
+	if _, err := os.Stat(stale); !os.IsNotExist(err) {
+		t.Errorf("stale temp file kept: %v", err)
+	}
+
+	if got := New(time.Hour, 0, 0, "").Sweep(); got.Dir != "" || len(got.Segments) != 0 {
+		t.Errorf("usage without dir = %+v", got)
+	}
+}
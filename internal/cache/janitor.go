@@ -0,0 +1,226 @@
+package cache
+
+import (
+	"container/list"
+	"context"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// staleTempAge is how old a leftover temp file must be before Sweep
+// removes it, so writes in progress are left alone.
+const staleTempAge = time.Hour
+
+// DiskUsage is the footprint of the cache directory.
+type DiskUsage struct {
+	Dir string `json:"dir"`
+	// Bytes counts every file under Dir, including field and dataset lists
+	// and snapshots kept there by other subsystems.
+	Bytes    int64          `json:"bytes"`
+	Segments []SegmentUsage `json:"segments"`
+	// Removed counts entries removed from disk for expiry or limits.
+	Removed   int64      `json:"removed"`
+	LastSweep *time.Time `json:"last_sweep,omitempty"`
+}
+
+// SegmentUsage is the disk footprint of one segment.
+type SegmentUsage struct {
+	Segment    string `json:"segment"`
+	Entries    int    `json:"entries"`
+	Bytes      int    `json:"bytes"`
+	MaxEntries int    `json:"max_entries,omitempty"`
+	MaxBytes   int    `json:"max_bytes,omitempty"`
+}
+
+// Janitor sweeps the disk cache now and then every interval until ctx is
+// done, so entries left by earlier runs are bounded even without new
+// writes. A zero interval sweeps only once. It is a no-op without a cache
+// directory.
+func (c *Cache) Janitor(ctx context.Context, interval time.Duration) {
+	if c.dir == "" {
+		return
+	}
+	c.Sweep()
+	if interval <= 0 {
+		return
+	}
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			c.Sweep()
+		}
+	}
+}
+
+// Sweep removes expired disk entries and stale temp files, evicts the
+// oldest entries of each segment over its limits and returns the resulting
+// usage.
+func (c *Cache) Sweep() DiskUsage {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if c.dir == "" {
+		return DiskUsage{Segments: []SegmentUsage{}}
+	}
+	if items, err := os.ReadDir(c.dir); err == nil {
+		for _, item := range items {
+			if !strings.HasPrefix(item.Name(), "cache-") || item.IsDir() {
+				continue
+			}
+			if info, err := item.Info(); err == nil && time.Since(info.ModTime()) > staleTempAge {
+				_ = os.Remove(filepath.Join(c.dir, item.Name()))
+			}
+		}
+	}
+	for s := range c.segments {
+		c.evictDiskLocked(Segment(s))
+	}
+	c.lastSweep = time.Now()
+	return c.diskUsageLocked()
+}
+
+// Purge removes every entry from memory and disk and returns how many
+// entries it removed. Counters are kept.
+func (c *Cache) Purge() int {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	// Entries both in memory and on disk are counted once.
+	inMemory := map[string]bool{}
+	for s, seg := range c.segments {
+		for key := range seg.items {
+			inMemory[c.diskPath(Segment(s), key)] = true
+		}
+		seg.items = make(map[string]*list.Element)
+		seg.recent.Init()
+		seg.size = 0
+	}
+	removed := len(inMemory)
+	if c.dir == "" {
+		return removed
+	}
+	for s := range c.segments {
+		entries, _ := c.listDiskLocked(Segment(s))
+		for _, entry := range entries {
+			if os.Remove(entry.path) == nil && !inMemory[entry.path] {
+				removed++
+			}
+		}
+	}
+	return removed
+}
+
+// Remove drops the entries whose key matches, plus the listed keys, from
+// memory and disk, and returns how many it removed. Disk files are named by
+// a hash of their key, so an entry only on disk, e.g. after a restart, is
+// dropped only when its key is listed.
+func (c *Cache) Remove(match func(key string) bool, keys ...string) int {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	drop := map[string]bool{}
+	for _, key := range keys {
+		drop[key] = true
+	}
+	for _, seg := range c.segments {
+		for key := range seg.items {
+			if match != nil && match(key) {
+				drop[key] = true
+			}
+		}
+	}
+	removed := 0
+	for key := range drop {
+		found := false
+		for s, seg := range c.segments {
+			if _, ok := seg.items[key]; ok {
+				seg.remove(key)
+				found = true
+			}
+			if c.dir != "" && os.Remove(c.diskPath(Segment(s), key)) == nil {
+				found = true
+			}
+		}
+		if found {
+			removed++
+		}
+	}
+	return removed
+}
+
+// Retain keeps the entries in memory whose key matches until at least
+// until, however old, on disk too, and returns how many it kept. Like
+// Remove, it cannot match entries only on disk.
+func (c *Cache) Retain(match func(key string) bool, until time.Time) int {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	kept := 0
+	for s, seg := range c.segments {
+		for key, elem := range seg.items {
+			if !match(key) {
+				continue
+			}
+			entry := &elem.Value.(*lruEntry).entry
+			if entry.ExpiresAt.Before(until) {
+				entry.ExpiresAt = until
+			}
+			if c.dir != "" && c.ttl > 0 {
+				// Disk entries expire ttl after their mtime.
+				stamp := until.Add(-c.ttl)
+				_ = os.Chtimes(c.diskPath(Segment(s), key), stamp, stamp)
+			}
+			kept++
+		}
+	}
+	return kept
+}
+
+// DiskUsage reports the current footprint without removing anything.
+func (c *Cache) DiskUsage() DiskUsage {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if c.dir == "" {
+		return DiskUsage{Segments: []SegmentUsage{}}
+	}
+	return c.diskUsageLocked()
+}
+
+func (c *Cache) diskUsageLocked() DiskUsage {
+	usage := DiskUsage{
+		Dir:      c.dir,
+		Segments: make([]SegmentUsage, 0, numSegments),
+		Removed:  c.diskRemoved,
+	}
+	if !c.lastSweep.IsZero() {
+		last := c.lastSweep
+		usage.LastSweep = &last
+	}
+	for s, seg := range c.segments {
+		entries, total := c.listDiskLocked(Segment(s))
+		usage.Segments = append(usage.Segments, SegmentUsage{
+			Segment:    Segment(s).String(),
+			Entries:    len(entries),
+			Bytes:      total,
+			MaxEntries: seg.limits.MaxEntries,
+			MaxBytes:   seg.limits.MaxBytes,
+		})
+	}
+	_ = filepath.WalkDir(c.dir, func(path string, d fs.DirEntry, err error) error {
+		if err != nil || d.IsDir() {
+			return nil
+		}
+		if info, err := d.Info(); err == nil {
+			usage.Bytes += info.Size()
+		}
+		return nil
+	})
+	return usage
+}
@@ -0,0 +1,163 @@
+// Package chart renders time-bucketed query results, such as
+// `summarize count() by bin(_time, 5m), service`, as a Vega-Lite spec with
+// the data embedded or as a standalone SVG line chart.
+//
+// The first column must be the time bucket. Every numeric column after it
+// becomes a series; string columns split those series by group, e.g. one
+// line per service.
+package chart
+
+import (
+	"fmt"
+	"io/fs"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/axiomhq/axiom-fs/internal/axiomclient"
+)
+
+// ErrNotTimeSeries is returned for results whose first column is not a time
+// bucket. It wraps fs.ErrInvalid so reads fail with EINVAL.
+var ErrNotTimeSeries = fmt.Errorf("chart needs a time bucket as the first column: %w", fs.ErrInvalid)
+
+// Point is one bucket of a series.
+type Point struct {
+	Time  time.Time
+	Value float64
+}
+
+// Series is one line of a chart.
+type Series struct {
+	Name   string
+	Points []Point
+}
+
+// FromTable splits table into series, in order of first appearance, with
+// points sorted by time. A table without fields yields no series.
+func FromTable(table axiomclient.QueryTable) (string, []Series, error) {
+	if len(table.Fields) == 0 || len(table.Columns) == 0 {
+		return "", nil, nil
+	}
+	timeField := table.Fields[0]
+	times := make([]time.Time, len(table.Columns[0]))
+	for i, v := range table.Columns[0] {
+		t, ok := parseTime(v)
+		if !ok {
+			return "", nil, ErrNotTimeSeries
+		}
+		times[i] = t
+	}
+	if len(times) == 0 && !strings.Contains(timeField.Type, "datetime") && timeField.Name != "_time" {
+		return "", nil, ErrNotTimeSeries
+	}
+
+	var values, groups []int
+	for i, field := range table.Fields[1:] {
+		col := i + 1
+		if col >= len(table.Columns) {
+			break
+		}
+		if isNumeric(field.Type, table.Columns[col]) {
+			values = append(values, col)
+		} else {
+			groups = append(groups, col)
+		}
+	}
+
+	index := map[string]int{}
+	var series []Series
+	for row, t := range times {
+		var group []string
+		for _, col := range groups {
+			group = append(group, label(cell(table.Columns[col], row)))
+		}
+		for _, col := range values {
+			v, ok := toFloat(cell(table.Columns[col], row))
+			if !ok {
+				continue
+			}
+			name := seriesName(table.Fields[col].Name, group, len(values) > 1)
+			i, ok := index[name]
+			if !ok {
+				i = len(series)
+				index[name] = i
+				series = append(series, Series{Name: name})
+			}
+			series[i].Points = append(series[i].Points, Point{Time: t, Value: v})
+		}
+	}
+	for _, s := range series {
+		sort.SliceStable(s.Points, func(i, j int) bool { return s.Points[i].Time.Before(s.Points[j].Time) })
+	}
+	return timeField.Name, series, nil
+}
+
+func seriesName(value string, group []string, multiValue bool) string {
+	if len(group) == 0 {
+		return value
+	}
+	name := strings.Join(group, " / ")
+	if multiValue {
+		name += " / " + value
+	}
+	return name
+}
+
+func cell(col []any, row int) any {
+	if row < len(col) {
+		return col[row]
+	}
+	return nil
+}
+
+func parseTime(v any) (time.Time, bool) {
+	s, ok := v.(string)
+	if !ok {
+		return time.Time{}, false
+	}
+	t, err := time.Parse(time.RFC3339Nano, s)
+	return t.UTC(), err == nil
+}
+
+func isNumeric(fieldType string, col []any) bool {
+	switch {
+	case strings.Contains(fieldType, "int"), strings.Contains(fieldType, "long"),
+		strings.Contains(fieldType, "float"), strings.Contains(fieldType, "real"),
+		strings.Contains(fieldType, "double"):
+		return true
+	case strings.Contains(fieldType, "string"):
+		return false
+	}
+	for _, v := range col {
+		if v == nil {
+			continue
+		}
+		_, ok := axiomclient.Float64(v)
+		return ok
+	}
+	return false
+}
+
+func toFloat(v any) (float64, bool) {
+	if s, ok := v.(string); ok {
+		f, err := strconv.ParseFloat(s, 64)
+		return f, err == nil
+	}
+	return axiomclient.Float64(v)
+}
+
+func label(v any) string {
+	switch s := v.(type) {
+	case nil:
+		return "(empty)"
+	case string:
+		if s == "" {
+			return "(empty)"
+		}
+		return s
+	default:
+		return fmt.Sprint(s)
+	}
+}
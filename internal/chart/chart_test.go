@@ -0,0 +1,90 @@
+package chart
+
+import (
+	"encoding/json"
+	"errors"
+	"io/fs"
+	"strings"
+	"testing"
+
+	"github.com/axiomhq/axiom-fs/internal/axiomclient"
+)
+
+func timeTable() axiomclient.QueryTable {
+	return axiomclient.QueryTable{
+		Fields: []axiomclient.QueryField{
+			{Name: "_time", Type: "datetime"},
+			{Name: "service", Type: "string"},
+			{Name: "count_", Type: "integer"},
+		},
+		Columns: [][]any{
+			{"2025-01-01T00:05:00Z", "2025-01-01T00:00:00Z", "2025-01-01T00:00:00Z", "2025-01-01T00:05:00Z"},
+			{"api", "api", "web", "web"},
+			{float64(7), float64(3), float64(1), float64(2)},
+		},
+	}
+}
+
+func TestFromTable(t *testing.T) {
+	timeField, series, err := FromTable(timeTable())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if timeField != "_time" || len(series) != 2 {
+		t.Fatalf("timeField=%q series=%+v", timeField, series)
+	}
+	api := series[0]
+	if api.Name != "api" || len(api.Points) != 2 || api.Points[0].Value != 3 || api.Points[1].Value != 7 {
+		t.Errorf("api series should be sorted by time: %+v", api)
+	}
+
+	_, _, err = FromTable(axiomclient.QueryTable{
+		Fields:  []axiomclient.QueryField{{Name: "service", Type: "string"}, {Name: "count_", Type: "integer"}},
+		Columns: [][]any{{"api"}, {float64(1)}},
+	})
+	if !errors.Is(err, ErrNotTimeSeries) || !errors.Is(err, fs.ErrInvalid) {
+		t.Errorf("non-time first column: err = %v", err)
+	}
+
+	if _, series, err := FromTable(axiomclient.QueryTable{}); err != nil || series != nil {
+		t.Errorf("empty table: series=%v err=%v", series, err)
+	}
+}
+
+func TestVegaLite(t *testing.T) {
+	data, err := VegaLite(timeTable())
+	if err != nil {
+		t.Fatal(err)
+	}
+	var spec vegaLiteSpec
+	if err := json.Unmarshal(data, &spec); err != nil {
+		t.Fatal(err)
+	}
+	if spec.Schema != vegaLiteSchema || spec.Mark.Type != "line" || spec.Encoding.X.Type != "temporal" {
+		t.Errorf("spec = %+v", spec)
+	}
+	if len(spec.Data.Values) != 4 || spec.Encoding.Color == nil {
+		t.Errorf("values=%d color=%v", len(spec.Data.Values), spec.Encoding.Color)
+	}
+}
+
+func TestSVG(t *testing.T) {
+	data, err := SVG(timeTable())
+	if err != nil {
+		t.Fatal(err)
+	}
+	svg := string(data)
+	if !strings.HasPrefix(svg, "<svg ") || strings.Count(svg, "<polyline") != 2 {
+		t.Errorf("svg:\n%s", svg)
+	}
+	for _, want := range []string{">api<", ">web<", ">7<", "2025-01-01 00:00"} {
+		if !strings.Contains(svg, want) {
+			t.Errorf("svg missing %q", want)
+		}
+	}
+
+	empty, err := SVG(axiomclient.QueryTable{})
+	if err != nil || !strings.Contains(string(empty), "no data") {
+		t.Errorf("empty svg = %s, %v", empty, err)
+	}
+}
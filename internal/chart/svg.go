@@ -0,0 +1,141 @@
+package chart
+
+import (
+	"bytes"
+	"fmt"
+	"html"
+	"math"
+	"strconv"
+	"time"
+
+	"github.com/axiomhq/axiom-fs/internal/axiomclient"
+)
+
+const (
+	width  = 640
+	height = 240
+
+	marginLeft   = 56
+	marginRight  = 16
+	marginTop    = 16
+	marginBottom = 28
+	legendLimit  = 8
+)
+
+// palette is Vega's category10, so the SVG and the Vega-Lite spec colour
+// series alike.
+var palette = []string{
+	"#1f77b4", "#ff7f0e", "#2ca02c", "#d62728", "#9467bd",
+	"#8c564b", "#e377c2", "#7f7f7f", "#bcbd22", "#17becf",
+}
+
+// SVG renders table as a standalone line chart with min/max labels on both
+// axes and, for several series, a legend.
+func SVG(table axiomclient.QueryTable) ([]byte, error) {
+	_, series, err := FromTable(table)
+	if err != nil {
+		return nil, err
+	}
+
+	var buf bytes.Buffer
+	fmt.Fprintf(&buf, `<svg xmlns="http://www.w3.org/2000/svg" width="%d" height="%d" viewBox="0 0 %d %d" font-family="sans-serif" font-size="11">`+"\n", width, height, width, height)
+	buf.WriteString(`<rect width="100%" height="100%" fill="white"/>` + "\n")
+
+	minT, maxT, minV, maxV, ok := bounds(series)
+	if !ok {
+		fmt.Fprintf(&buf, `<text x="%d" y="%d" text-anchor="middle" fill="#666">no data</text>`+"\n", width/2, height/2)
+		buf.WriteString("</svg>\n")
+		return buf.Bytes(), nil
+	}
+
+	plotW := float64(width - marginLeft - marginRight)
+	plotH := float64(height - marginTop - marginBottom)
+	x := func(t time.Time) float64 {
+		span := maxT.Sub(minT)
+		if span <= 0 {
+			return marginLeft + plotW/2
+		}
+		return marginLeft + plotW*float64(t.Sub(minT))/float64(span)
+	}
+	y := func(v float64) float64 {
+		if maxV == minV {
+			return marginTop + plotH/2
+		}
+		return marginTop + plotH*(1-(v-minV)/(maxV-minV))
+	}
+
+	// Axes and labels.
+	fmt.Fprintf(&buf, `<path d="M%d %d V%d H%d" fill="none" stroke="#999"/>`+"\n",
+		marginLeft, marginTop, height-marginBottom, width-marginRight)
+	fmt.Fprintf(&buf, `<text x="%d" y="%d" text-anchor="end" dominant-baseline="middle" fill="#444">%s</text>`+"\n",
+		marginLeft-6, marginTop, formatValue(maxV))
+	fmt.Fprintf(&buf, `<text x="%d" y="%d" text-anchor="end" dominant-baseline="middle" fill="#444">%s</text>`+"\n",
+		marginLeft-6, height-marginBottom, formatValue(minV))
+	fmt.Fprintf(&buf, `<text x="%d" y="%d" text-anchor="start" fill="#444">%s</text>`+"\n",
+		marginLeft, height-8, minT.Format(timeLayout(minT, maxT)))
+	fmt.Fprintf(&buf, `<text x="%d" y="%d" text-anchor="end" fill="#444">%s</text>`+"\n",
+		width-marginRight, height-8, maxT.Format(timeLayout(minT, maxT)))
+
+	for i, s := range series {
+		color := palette[i%len(palette)]
+		var pts bytes.Buffer
+		for j, p := range s.Points {
+			if j > 0 {
+				pts.WriteByte(' ')
+			}
+			fmt.Fprintf(&pts, "%.1f,%.1f", x(p.Time), y(p.Value))
+		}
+		fmt.Fprintf(&buf, `<polyline points="%s" fill="none" stroke="%s" stroke-width="1.5"><title>%s</title></polyline>`+"\n",
+			pts.String(), color, html.EscapeString(s.Name))
+	}
+
+	if len(series) > 1 {
+		for i, s := range series {
+			if i == legendLimit {
+				fmt.Fprintf(&buf, `<text x="%d" y="%d" text-anchor="end" fill="#666">+%d more</text>`+"\n",
+					width-marginRight, marginTop+10+i*14, len(series)-legendLimit)
+				break
+			}
+			fmt.Fprintf(&buf, `<text x="%d" y="%d" text-anchor="end" fill="%s">%s</text>`+"\n",
+				width-marginRight, marginTop+10+i*14, palette[i%len(palette)], html.EscapeString(s.Name))
+		}
+	}
+	buf.WriteString("</svg>\n")
+	return buf.Bytes(), nil
+}
+
+func bounds(series []Series) (minT, maxT time.Time, minV, maxV float64, ok bool) {
+	minV, maxV = math.Inf(1), math.Inf(-1)
+	for _, s := range series {
+		for _, p := range s.Points {
+			if !ok || p.Time.Before(minT) {
+				minT = p.Time
+			}
+			if !ok || p.Time.After(maxT) {
+				maxT = p.Time
+			}
+			minV = math.Min(minV, p.Value)
+			maxV = math.Max(maxV, p.Value)
+			ok = true
+		}
+	}
+	// Counts and rates read best against a zero baseline.
+	if ok && minV > 0 {
+		minV = 0
+	}
+	return minT, maxT, minV, maxV, ok
+}
+
+func timeLayout(minT, maxT time.Time) string {
+	if maxT.Sub(minT) >= 48*time.Hour {
+		return "2006-01-02"
+	}
+	return "2006-01-02 15:04"
+}
+
+func formatValue(v float64) string {
+	if v == math.Trunc(v) && math.Abs(v) < 1e15 {
+		return strconv.FormatInt(int64(v), 10)
+	}
+	return strconv.FormatFloat(v, 'g', 4, 64)
+}
@@ -0,0 +1,85 @@
+package chart
+
+import (
+	"encoding/json"
+	"time"
+
+	"github.com/axiomhq/axiom-fs/internal/axiomclient"
+)
+
+const vegaLiteSchema = "https://vega.github.io/schema/vega-lite/v5.json"
+
+type vegaLiteSpec struct {
+	Schema   string           `json:"$schema"`
+	Width    int              `json:"width"`
+	Height   int              `json:"height"`
+	Data     vegaLiteData     `json:"data"`
+	Mark     vegaLiteMark     `json:"mark"`
+	Encoding vegaLiteEncoding `json:"encoding"`
+}
+
+type vegaLiteData struct {
+	Values []vegaLiteValue `json:"values"`
+}
+
+type vegaLiteValue struct {
+	Time   string  `json:"time"`
+	Series string  `json:"series"`
+	Value  float64 `json:"value"`
+}
+
+type vegaLiteMark struct {
+	Type  string `json:"type"`
+	Point bool   `json:"point"`
+}
+
+type vegaLiteEncoding struct {
+	X     vegaLiteChannel  `json:"x"`
+	Y     vegaLiteChannel  `json:"y"`
+	Color *vegaLiteChannel `json:"color,omitempty"`
+}
+
+type vegaLiteChannel struct {
+	Field string `json:"field"`
+	Type  string `json:"type"`
+	Title string `json:"title,omitempty"`
+}
+
+// VegaLite returns a line chart spec for table with the data inlined, so
+// it renders in any Vega-Lite viewer without access to Axiom.
+func VegaLite(table axiomclient.QueryTable) ([]byte, error) {
+	timeField, series, err := FromTable(table)
+	if err != nil {
+		return nil, err
+	}
+	spec := vegaLiteSpec{
+		Schema: vegaLiteSchema,
+		Width:  width,
+		Height: height,
+		Data:   vegaLiteData{Values: []vegaLiteValue{}},
+		Mark:   vegaLiteMark{Type: "line", Point: true},
+		Encoding: vegaLiteEncoding{
+			X: vegaLiteChannel{Field: "time", Type: "temporal", Title: timeField},
+			Y: vegaLiteChannel{Field: "value", Type: "quantitative"},
+		},
+	}
+	if len(series) == 1 {
+		spec.Encoding.Y.Title = series[0].Name
+	} else if len(series) > 1 {
+		spec.Encoding.Color = &vegaLiteChannel{Field: "series", Type: "nominal"}
+	}
+	for _, s := range series {
+		for _, p := range s.Points {
+			spec.Data.Values = append(spec.Data.Values, vegaLiteValue{
+				Time:   p.Time.Format(time.RFC3339Nano),
+				Series: s.Name,
+				Value:  p.Value,
+			})
+		}
+	}
+	data, err := json.MarshalIndent(spec, "", "  ")
+	if err != nil {
+		return nil, err
+	}
+	return append(data, '\n'), nil
+}
@@ -1,14 +1,19 @@
 package compiler
 
 import (
+	"cmp"
 	"encoding/base64"
 	"errors"
 	"fmt"
+	"io/fs"
 	"net/url"
+	"path"
 	"path/filepath"
+	"slices"
 	"strconv"
 	"strings"
 	"time"
+	"unicode"
 	"unicode/utf8"
 )
 
@@ -21,16 +26,154 @@ type Options struct {
 	DefaultRange string
 	// DefaultLimit is the row limit appended when no limit is present.
 	DefaultLimit int
+	// DefaultFormat is the format of a bare result segment without a
+	// format/ segment; empty means ndjson.
+	DefaultFormat string
 	// MaxRange rejects range/ago or range/from/to longer than this duration.
 	MaxRange time.Duration
 	// MaxLimit rejects limit/top values larger than this.
 	MaxLimit int
+	// AllowForce permits a leading force/ segment, which raises MaxRange
+	// and MaxLimit to ForceMaxRange and ForceMaxLimit; zero leaves those
+	// uncapped.
+	AllowForce    bool
+	ForceMaxRange time.Duration
+	ForceMaxLimit int
+	// MountPoint prefixes the force/ path a LimitError suggests.
+	MountPoint string
+	// Aliases maps virtual dataset names to the real datasets they union.
+	Aliases map[string][]string
+	// Fields lists the dataset's known fields. When set, distinct/ and
+	// select/ reject fields not in it; when nil, fields are not checked.
+	Fields []string
+	// Location is the time zone range/today, range/yesterday and
+	// range/last count days in, unless a tz/ segment names another; nil
+	// means UTC. Now is the time they are relative to; zero means now.
+	Location *time.Location
+	Now      time.Time
 }
 
+// LimitError is returned when a q/ path exceeds MaxRange or MaxLimit.
+// ForcePath is the same path with force/ in front, which goes past them;
+// it is empty when AllowForce is off.
+type LimitError struct {
+	Reason    string
+	ForcePath string
+}
+
+func (e *LimitError) Error() string {
+	if e.ForcePath == "" {
+		return e.Reason + " (force/ paths need -allow-force)"
+	}
+	return e.Reason + "; to go past it, read " + e.ForcePath
+}
+
+// FlattenDot is the flatten/ mode naming nested values by their dotted
+// path, such as attributes.http.status.
+const FlattenDot = "dot"
+
+// ErrUnknownField is returned when a segment names a field the dataset does
+// not have.
+var ErrUnknownField = fmt.Errorf("unknown field: %w", fs.ErrInvalid)
+
 type Query struct {
 	Dataset string
 	APL     string
 	Format  string
+	// AutoRange asks the executor to widen the default range when the
+	// result is empty.
+	AutoRange bool
+	// Columns lists result columns to keep after execution (cols/ segment).
+	Columns []string
+	// NaturalSort is a sort/<field>:<dir>:natural segment, which the
+	// executor applies to the result rows after execution.
+	NaturalSort *Sort
+	// Flatten is a flatten/<mode> segment: how nested objects are spread
+	// over columns in csv and tsv results. The only mode is "dot".
+	Flatten string
+	// Label is a label/<name> segment, which attributes the query's cost
+	// to name.
+	Label string
+	// Stages explains the query step by step: the APL steps in order,
+	// then what is applied after execution.
+	Stages []Stage
+}
+
+// Stage is one step of a compiled query and why it is there.
+type Stage struct {
+	// APL is the pipeline step, empty for steps applied after execution.
+	APL string `json:"apl,omitempty"`
+	// Segment is the q/ path segment that added the step, empty for
+	// defaults.
+	Segment string `json:"segment,omitempty"`
+	// Note says where the step comes from and which limits it enforces.
+	Note string `json:"note,omitempty"`
+
+	// from is the index of the first segment of the step.
+	from int
+}
+
+// Sort is a parsed sort/ segment: <field>:<asc|desc> followed by optional
+// nulls-last and natural modifiers.
+type Sort struct {
+	Field     string
+	Desc      bool
+	NullsLast bool
+	// Natural orders strings by locale collation with digit runs compared
+	// as numbers, so "v2" sorts before "v10".
+	Natural bool
+}
+
+// ParseSort decodes a sort/ segment.
+func ParseSort(segment string) (Sort, error) {
+	parts := strings.Split(segment, ":")
+	if len(parts) < 2 || len(parts) > 4 {
+		return Sort{}, fmt.Errorf("expected field:dir[:nulls-last|natural]")
+	}
+	field, dir, err := splitFieldDir(parts[0] + ":" + parts[1])
+	if err != nil {
+		return Sort{}, err
+	}
+	sort := Sort{Field: field, Desc: dir == "desc"}
+	for _, modifier := range parts[2:] {
+		switch {
+		case modifier == "nulls-last" && !sort.NullsLast:
+			sort.NullsLast = true
+		case modifier == "natural" && !sort.Natural:
+			sort.Natural = true
+		default:
+			return Sort{}, fmt.Errorf("unknown sort modifier %q", modifier)
+		}
+	}
+	return sort, nil
+}
+
+// String returns s as a sort/ segment.
+func (s Sort) String() string {
+	segment := s.Field + ":asc"
+	if s.Desc {
+		segment = s.Field + ":desc"
+	}
+	if s.NullsLast {
+		segment += ":nulls-last"
+	}
+	if s.Natural {
+		segment += ":natural"
+	}
+	return segment
+}
+
+// APL returns the order by step for s. Natural ordering is not expressible
+// in APL, so the step only sorts by direction and nulls.
+func (s Sort) APL() string {
+	step := "order by " + s.Field + " asc"
+	if s.Desc {
+		step = "order by " + s.Field + " desc"
+	}
+	if s.NullsLast {
+		step += " nulls last"
+	}
+	return step
 }
 
 // CompileQueryPath compiles a full filesystem path to an APL query.
@@ -68,6 +211,12 @@ func CompileSegments(dataset string, segments []string, opts Options) (Query, er
 	state := compileState{
 		format: defaultFormat,
 	}
+	if opts.DefaultFormat != "" {
+		if !IsFormat(opts.DefaultFormat) {
+			return Query{}, fmt.Errorf("default format invalid: %q", opts.DefaultFormat)
+		}
+		state.format = opts.DefaultFormat
+	}
 	if opts.DefaultRange != "" {
 		state.defaultRange = opts.DefaultRange
 	} else {
@@ -80,21 +229,64 @@ func CompileSegments(dataset string, segments []string, opts Options) (Query, er
 	}
 	state.maxRange = opts.MaxRange
 	state.maxLimit = opts.MaxLimit
+	state.location, state.now = opts.Location, opts.Now
+	if state.location == nil {
+		state.location = time.UTC
+	}
+	if state.now.IsZero() {
+		state.now = time.Now()
+	}
+	state.rangeFlag, state.limitFlag = "--max-range", "--max-limit"
+	if opts.AllowForce {
+		state.forcePath = path.Join(append([]string{opts.MountPoint, dataset, "q", "force"}, segments...)...)
+	}
 
 	i := 0
 	for i < len(segments) {
 		seg := segments[i]
+		state.starts = append(state.starts, i)
 		switch seg {
+		case "force":
+			if i != 0 {
+				return Query{}, fmt.Errorf("force must be the first segment")
+			}
+			if !opts.AllowForce {
+				return Query{}, fmt.Errorf("force/ is disabled; start axiom-fs with -allow-force")
+			}
+			state.forced = true
+			state.maxRange, state.maxLimit = opts.ForceMaxRange, opts.ForceMaxLimit
+			state.rangeFlag, state.limitFlag = "--force-max-range", "--force-max-limit"
+			state.post = append(state.post, Stage{
+				Segment: "force",
+				Note:    "lifts --max-range and --max-limit to --force-max-range and --force-max-limit",
+			})
+			i++
+			continue
 		case "range":
+			if i+1 < len(segments) && isDayRange(segments[i+1]) {
+				start, end, used, err := dayRange(segments[i+1:], state.now.In(state.location))
+				if err != nil {
+					return Query{}, err
+				}
+				if state.maxRange > 0 && end.Sub(start) > state.maxRange {
+					return Query{}, state.limitError(&LimitError{Reason: fmt.Sprintf("range exceeds max: %s > %s", end.Sub(start), state.maxRange)})
+				}
+				state.addRange(rangeFromTo(start.UTC().Format(time.RFC3339), end.UTC().Format(time.RFC3339)))
+				state.note(dayRangeNote(state.location, maxRangeNote(state.maxRange, state.rangeFlag)))
+				state.dayRange = true
+				i += 1 + used
+				continue
+			}
 			if i+2 >= len(segments) {
 				return Query{}, fmt.Errorf("range missing arguments")
 			}
 			if segments[i+1] == "ago" {
 				dur := segments[i+2]
 				if err := checkRangeAgo(dur, state.maxRange); err != nil {
-					return Query{}, err
+					return Query{}, state.limitError(err)
 				}
 				state.addRange(rangeAgo(dur))
+				state.note(maxRangeNote(state.maxRange, state.rangeFlag))
 				i += 3
 				continue
 			}
@@ -105,9 +297,10 @@ func CompileSegments(dataset string, segments []string, opts Options) (Query, er
 				from := segments[i+2]
 				to := segments[i+4]
 				if err := checkRangeFromTo(from, to, state.maxRange); err != nil {
-					return Query{}, err
+					return Query{}, state.limitError(err)
 				}
 				state.addRange(rangeFromTo(from, to))
+				state.note(maxRangeNote(state.maxRange, state.rangeFlag))
 				i += 5
 				continue
 			}
@@ -134,6 +327,23 @@ func CompileSegments(dataset string, segments []string, opts Options) (Query, er
 			state.append(fmt.Sprintf("search %q", escapeAPLString(term)))
 			i += 2
 			continue
+		case "grep", "grepi":
+			if i+1 >= len(segments) {
+				return Query{}, fmt.Errorf("%s missing term", seg)
+			}
+			// grep terms are literal text: only percent-decoding applies,
+			// never the base64 heuristic used for expressions.
+			term, err := url.PathUnescape(segments[i+1])
+			if err != nil || term == "" {
+				return Query{}, fmt.Errorf("%s invalid term: %q", seg, segments[i+1])
+			}
+			kind := ""
+			if seg == "grepi" {
+				kind = "kind=case_insensitive "
+			}
+			state.append(fmt.Sprintf("search %s\"%s\"", kind, escapeAPLString(term)))
+			i += 2
+			continue
 		case "summarize":
 			if i+1 >= len(segments) {
 				return Query{}, fmt.Errorf("summarize missing agg")
@@ -151,10 +361,12 @@ func CompileSegments(dataset string, segments []string, opts Options) (Query, er
 					return Query{}, fmt.Errorf("summarize/by decode: %w", err)
 				}
 				state.append(fmt.Sprintf("summarize %s by %s", agg, fields))
+				state.reshaped = true
 				i += 4
 				continue
 			}
 			state.append(fmt.Sprintf("summarize %s", agg))
+			state.reshaped = true
 			i += 2
 			continue
 		case "project":
@@ -166,6 +378,7 @@ func CompileSegments(dataset string, segments []string, opts Options) (Query, er
 				return Query{}, fmt.Errorf("project decode: %w", err)
 			}
 			state.append(fmt.Sprintf("project %s", fields))
+			state.reshaped = true
 			i += 2
 			continue
 		case "project-away":
@@ -179,6 +392,65 @@ func CompileSegments(dataset string, segments []string, opts Options) (Query, er
 			state.append(fmt.Sprintf("project-away %s", fields))
 			i += 2
 			continue
+		case "select":
+			if i+1 >= len(segments) {
+				return Query{}, fmt.Errorf("select missing fields")
+			}
+			columns, err := ParseSelect(segments[i+1])
+			if err != nil {
+				return Query{}, err
+			}
+			if opts.Fields != nil && !state.reshaped {
+				for _, column := range columns {
+					if !slices.Contains(opts.Fields, column.Field) {
+						return Query{}, fmt.Errorf("select %q: %w", column.Field, ErrUnknownField)
+					}
+				}
+			}
+			exprs := make([]string, len(columns))
+			for j, column := range columns {
+				exprs[j] = column.APL()
+			}
+			state.append("project " + strings.Join(exprs, ", "))
+			state.reshaped = true
+			i += 2
+			continue
+		case "distinct":
+			if i+1 >= len(segments) {
+				return Query{}, fmt.Errorf("distinct missing fields")
+			}
+			fields, err := ParseColumns(segments[i+1])
+			if err != nil {
+				return Query{}, fmt.Errorf("distinct invalid: %q", segments[i+1])
+			}
+			// Fields are only known up to the first step that reshapes rows.
+			if opts.Fields != nil && !state.reshaped {
+				for _, field := range fields {
+					if !slices.Contains(opts.Fields, field) {
+						return Query{}, fmt.Errorf("distinct %q: %w", field, ErrUnknownField)
+					}
+				}
+			}
+			refs := make([]string, len(fields))
+			for j, field := range fields {
+				refs[j] = FieldRef(field)
+			}
+			state.append("distinct " + strings.Join(refs, ", "))
+			state.reshaped = true
+			i += 2
+			continue
+		case "sample":
+			if i+1 >= len(segments) {
+				return Query{}, fmt.Errorf("sample missing percent")
+			}
+			percent, err := ParseSamplePercent(segments[i+1])
+			if err != nil {
+				return Query{}, err
+			}
+			state.append(SampleAPL(percent))
+			state.note("keeps about " + strconv.FormatFloat(percent, 'g', -1, 64) + "% of rows, picked at random on every run")
+			i += 2
+			continue
 		case "order":
 			if i+1 >= len(segments) {
 				return Query{}, fmt.Errorf("order missing field:dir")
@@ -190,6 +462,21 @@ func CompileSegments(dataset string, segments []string, opts Options) (Query, er
 			state.append(fmt.Sprintf("order by %s %s", field, dir))
 			i += 2
 			continue
+		case "sort":
+			if i+1 >= len(segments) {
+				return Query{}, fmt.Errorf("sort missing field:dir")
+			}
+			sort, err := ParseSort(segments[i+1])
+			if err != nil {
+				return Query{}, fmt.Errorf("sort invalid: %w", err)
+			}
+			state.append(sort.APL())
+			if sort.Natural {
+				state.naturalSort = &sort
+				state.note("re-sorted in natural order after execution")
+			}
+			i += 2
+			continue
 		case "limit":
 			if i+1 >= len(segments) {
 				return Query{}, fmt.Errorf("limit missing value")
@@ -199,9 +486,10 @@ func CompileSegments(dataset string, segments []string, opts Options) (Query, er
 				return Query{}, fmt.Errorf("limit invalid: %q", segments[i+1])
 			}
 			if err := checkLimit(n, state.maxLimit); err != nil {
-				return Query{}, err
+				return Query{}, state.limitError(err)
 			}
 			state.append(fmt.Sprintf("take %d", n))
+			state.note(maxLimitNote(n, state.maxLimit, state.limitFlag))
 			state.hasLimit = true
 			i += 2
 			continue
@@ -214,34 +502,116 @@ func CompileSegments(dataset string, segments []string, opts Options) (Query, er
 				return Query{}, fmt.Errorf("top invalid: %q", segments[i+1])
 			}
 			if err := checkLimit(n, state.maxLimit); err != nil {
-				return Query{}, err
+				return Query{}, state.limitError(err)
 			}
 			field, dir, err := splitFieldDir(segments[i+3])
 			if err != nil {
 				return Query{}, fmt.Errorf("top invalid: %w", err)
 			}
 			state.append(fmt.Sprintf("top %d by %s %s", n, field, dir))
+			state.note(maxLimitNote(n, state.maxLimit, state.limitFlag))
 			state.hasLimit = true
 			i += 4
 			continue
+		case "cols":
+			if i+1 >= len(segments) {
+				return Query{}, fmt.Errorf("cols missing fields")
+			}
+			columns, err := ParseColumns(segments[i+1])
+			if err != nil {
+				return Query{}, err
+			}
+			state.columns = columns
+			state.post = append(state.post, Stage{
+				Segment: "cols/" + segments[i+1],
+				Note:    "after execution, keep only columns " + strings.Join(columns, ", "),
+			})
+			i += 2
+			continue
+		case "flatten":
+			if i+1 >= len(segments) {
+				return Query{}, fmt.Errorf("flatten missing mode")
+			}
+			if segments[i+1] != FlattenDot {
+				return Query{}, fmt.Errorf("flatten invalid: %q (want %s)", segments[i+1], FlattenDot)
+			}
+			state.flatten = segments[i+1]
+			state.post = append(state.post, Stage{
+				Segment: "flatten/" + state.flatten,
+				Note:    "after execution, spread nested objects over dotted columns in csv and tsv",
+			})
+			i += 2
+			continue
+		case "label":
+			if i+1 >= len(segments) {
+				return Query{}, fmt.Errorf("label missing name")
+			}
+			if !validLabel(segments[i+1]) {
+				return Query{}, fmt.Errorf("label invalid: %q (want letters, digits, '-', '_' or '.')", segments[i+1])
+			}
+			state.label = segments[i+1]
+			state.post = append(state.post, Stage{
+				Segment: "label/" + state.label,
+				Note:    "queries sent to Axiom are counted under " + state.label + " in /_status/costs.json",
+			})
+			i += 2
+			continue
+		case "tz":
+			if i+1 >= len(segments) {
+				return Query{}, fmt.Errorf("tz missing zone")
+			}
+			if state.dayRange {
+				return Query{}, fmt.Errorf("tz must come before the range/ it applies to")
+			}
+			loc, err := parseZone(segments[i+1])
+			if err != nil {
+				return Query{}, err
+			}
+			state.location = loc
+			state.post = append(state.post, Stage{
+				Segment: "tz/" + segments[i+1],
+				Note:    "range/today, range/yesterday and range/last count days in " + loc.String(),
+			})
+			i += 2
+			continue
+		case "auto-range":
+			state.autoRange = true
+			i++
+			continue
 		case "format":
 			if i+1 >= len(segments) {
 				return Query{}, fmt.Errorf("format missing value")
 			}
-			format := segments[i+1]
-			if !isFormat(format) {
-				return Query{}, fmt.Errorf("format invalid: %q", format)
+			format, ok := ResultFormat(segments[i+1])
+			if !ok {
+				return Query{}, fmt.Errorf("format invalid: %q", segments[i+1])
 			}
 			state.format = format
 			i += 2
 			continue
+		case "result":
+			// A bare result keeps the format/ segment's or the default.
+			i++
+			continue
+		case "_":
+			// _/<comment>/ only chunks long paths for readers.
+			if i+1 >= len(segments) {
+				return Query{}, fmt.Errorf("_ missing comment")
+			}
+			i += 2
+			continue
 		default:
+			if strings.HasPrefix(seg, "#") {
+				// A #<tag> segment is for scripts; it changes nothing.
+				i++
+				continue
+			}
 			if strings.HasPrefix(seg, "result.") {
-				ext := strings.TrimPrefix(seg, "result.")
-				if !isFormat(ext) {
+				format, ok := ResultFormat(strings.TrimPrefix(seg, "result."))
+				if !ok {
 					return Query{}, fmt.Errorf("result extension invalid: %q", seg)
 				}
-				state.format = ext
+				state.format = format
 				i++
 				continue
 			}
@@ -249,44 +619,266 @@ func CompileSegments(dataset string, segments []string, opts Options) (Query, er
 		}
 	}
 
-	steps := state.steps
+	if state.autoRange && state.hasRange {
+		return Query{}, fmt.Errorf("auto-range cannot be combined with range")
+	}
+
+	state.spanSegments(segments)
+	stages := state.stages
 	if !state.hasRange {
-		steps = append([]string{rangeAgo(state.defaultRange)}, steps...)
+		note := "default range: no range/ segment"
+		if state.autoRange {
+			note += "; auto-range/ widens it while the result is empty"
+		}
+		stages = append([]Stage{{APL: rangeAgo(state.defaultRange), Note: note}}, stages...)
 	}
 	if !state.hasLimit && state.defaultLimit > 0 {
-		steps = append(steps, fmt.Sprintf("take %d", state.defaultLimit))
+		stages = append(stages, Stage{
+			APL:  fmt.Sprintf("take %d", state.defaultLimit),
+			Note: fmt.Sprintf("default limit: no limit/ or top/ segment, so rows past %d are dropped", state.defaultLimit),
+		})
+	}
+	steps := make([]string, len(stages))
+	for i, stage := range stages {
+		steps[i] = stage.APL
 	}
 
-	apl := fmt.Sprintf("['%s']", dataset)
+	source := Source(dataset, opts.Aliases)
+	apl := source
 	if len(steps) > 0 {
 		apl += "\n| " + strings.Join(steps, "\n| ")
 	}
+	sourceNote := "dataset " + dataset
+	if members := opts.Aliases[dataset]; len(members) > 0 {
+		sourceNote = "alias " + dataset + " of " + strings.Join(members, ", ")
+	}
+	stages = append([]Stage{{APL: source, Note: sourceNote}}, stages...)
+	stages = append(stages, state.post...)
 
 	return Query{
-		Dataset: dataset,
-		APL:     apl,
-		Format:  state.format,
+		Dataset:     dataset,
+		APL:         apl,
+		Format:      state.format,
+		AutoRange:   state.autoRange,
+		Columns:     state.columns,
+		NaturalSort: state.naturalSort,
+		Flatten:     state.flatten,
+		Label:       state.label,
+		Stages:      stages,
 	}, nil
 }
 
+// Source returns the tabular source expression for dataset, expanding
+// aliases into a union of their member datasets.
+func Source(dataset string, aliases map[string][]string) string {
+	members, ok := aliases[dataset]
+	if !ok || len(members) == 0 {
+		return fmt.Sprintf("['%s']", dataset)
+	}
+	quoted := make([]string, len(members))
+	for i, m := range members {
+		quoted[i] = fmt.Sprintf("['%s']", m)
+	}
+	return "union " + strings.Join(quoted, ", ")
+}
+
+// FieldRef returns name as an APL field reference, quoting it as ['name']
+// unless it is a plain, possibly dotted, identifier.
+func FieldRef(name string) string {
+	plain := name != "" && !unicode.IsDigit(rune(name[0]))
+	for _, r := range name {
+		if r != '_' && r != '.' && !unicode.IsLetter(r) && !unicode.IsDigit(r) {
+			plain = false
+			break
+		}
+	}
+	if plain {
+		return name
+	}
+	return "['" + fieldRefEscaper.Replace(name) + "']"
+}
+
+var fieldRefEscaper = strings.NewReplacer(`\`, `\\`, `'`, `\'`, "\n", `\n`, "\r", `\r`, "\t", `\t`)
+
+// ParseColumns decodes a cols/ segment into a list of column names.
+func ParseColumns(segment string) ([]string, error) {
+	decoded, err := decodeExpr(segment)
+	if err != nil {
+		return nil, fmt.Errorf("cols decode: %w", err)
+	}
+	var columns []string
+	for _, c := range strings.Split(decoded, ",") {
+		if c = strings.TrimSpace(c); c != "" {
+			columns = append(columns, c)
+		}
+	}
+	if len(columns) == 0 {
+		return nil, fmt.Errorf("cols invalid: %q", segment)
+	}
+	return columns, nil
+}
+
+// SelectColumn is one entry of a select/ segment: a field, optionally
+// renamed to Alias.
+type SelectColumn struct {
+	Field string
+	Alias string
+}
+
+// APL returns the column as a project expression.
+func (c SelectColumn) APL() string {
+	if c.Alias == "" || c.Alias == c.Field {
+		return FieldRef(c.Field)
+	}
+	return FieldRef(c.Alias) + "=" + FieldRef(c.Field)
+}
+
+// ParseSelect decodes a select/ segment, field[:alias] entries separated by
+// commas, such as "service:svc,status".
+func ParseSelect(segment string) ([]SelectColumn, error) {
+	decoded, err := decodeExpr(segment)
+	if err != nil {
+		return nil, fmt.Errorf("select decode: %w", err)
+	}
+	var columns []SelectColumn
+	aliases := map[string]bool{}
+	for _, entry := range strings.Split(decoded, ",") {
+		if entry = strings.TrimSpace(entry); entry == "" {
+			continue
+		}
+		field, alias, renamed := strings.Cut(entry, ":")
+		field, alias = strings.TrimSpace(field), strings.TrimSpace(alias)
+		if field == "" || renamed && alias == "" {
+			return nil, fmt.Errorf("select invalid: %q (want field[:alias],...)", entry)
+		}
+		column := SelectColumn{Field: field, Alias: alias}
+		name := cmp.Or(alias, field)
+		if aliases[name] {
+			return nil, fmt.Errorf("select invalid: column %q appears twice", name)
+		}
+		aliases[name] = true
+		columns = append(columns, column)
+	}
+	if len(columns) == 0 {
+		return nil, fmt.Errorf("select invalid: %q", segment)
+	}
+	return columns, nil
+}
+
+// ParseSamplePercent decodes the percent of a sample/ segment, above 0 and
+// at most 100.
+func ParseSamplePercent(segment string) (float64, error) {
+	percent, err := strconv.ParseFloat(segment, 64)
+	if err != nil || !(percent > 0 && percent <= 100) {
+		return 0, fmt.Errorf("sample invalid: %q (want a percent above 0, up to 100)", segment)
+	}
+	return percent, nil
+}
+
+// SampleAPL returns the step keeping about percent of rows at random, such
+// as where rand() < 0.01 for 1%.
+func SampleAPL(percent float64) string {
+	return "where rand() < " + strconv.FormatFloat(percent/100, 'g', -1, 64)
+}
+
+// validLabel reports whether name can be used as a cost label.
+func validLabel(name string) bool {
+	return name != "" && !strings.ContainsFunc(name, func(r rune) bool {
+		return !(r >= 'a' && r <= 'z' || r >= 'A' && r <= 'Z' || r >= '0' && r <= '9' || r == '-' || r == '_' || r == '.')
+	})
+}
+
 type compileState struct {
-	steps        []string
-	hasRange     bool
-	hasLimit     bool
+	stages []Stage
+	// post are stages applied after execution.
+	post []Stage
+	// starts holds the index of every segment that began a step.
+	starts   []int
+	hasRange bool
+	hasLimit bool
+	// reshaped is set once a step replaces the dataset's columns.
+	reshaped     bool
+	autoRange    bool
+	columns      []string
+	naturalSort  *Sort
+	flatten      string
+	label        string
 	format       string
 	defaultRange string
 	defaultLimit int
 	maxRange     time.Duration
 	maxLimit     int
+	// rangeFlag and limitFlag name the flags maxRange and maxLimit come
+	// from in notes; forced is set by a force/ segment.
+	rangeFlag string
+	limitFlag string
+	forced    bool
+	// forcePath is the path forced, when force/ is allowed.
+	forcePath string
+	// location and now resolve day ranges; dayRange is set once one is
+	// compiled, after which tz/ comes too late.
+	location *time.Location
+	now      time.Time
+	dayRange bool
+}
+
+// limitError completes a LimitError from a range or limit check. Past the
+// force/ caps there is nothing left to suggest.
+func (s *compileState) limitError(err error) error {
+	var limit *LimitError
+	if !errors.As(err, &limit) {
+		return err
+	}
+	if s.forced {
+		return errors.New(strings.Replace(limit.Reason, "exceeds max", "exceeds force max", 1))
+	}
+	limit.ForcePath = s.forcePath
+	return limit
 }
 
 func (s *compileState) append(step string) {
-	s.steps = append(s.steps, step)
+	s.stages = append(s.stages, Stage{APL: step, from: s.starts[len(s.starts)-1]})
 }
 
 func (s *compileState) addRange(step string) {
 	s.hasRange = true
-	s.steps = append(s.steps, step)
+	s.append(step)
+}
+
+// note annotates the last stage appended.
+func (s *compileState) note(note string) {
+	if note != "" {
+		s.stages[len(s.stages)-1].Note = note
+	}
+}
+
+// spanSegments sets each stage's Segment to the path segments that
+// produced it: from its first segment up to the next step's.
+func (s *compileState) spanSegments(segments []string) {
+	for i := range s.stages {
+		from, to := s.stages[i].from, len(segments)
+		for _, start := range s.starts {
+			if start > from {
+				to = start
+				break
+			}
+		}
+		s.stages[i].Segment = strings.Join(segments[from:to], "/")
+	}
+}
+
+func maxRangeNote(maxRange time.Duration, flag string) string {
+	if maxRange == 0 {
+		return ""
+	}
+	return "within " + flag + " " + maxRange.String()
+}
+
+func maxLimitNote(n, maxLimit int, flag string) string {
+	if maxLimit == 0 {
+		return ""
+	}
+	return fmt.Sprintf("%d within %s %d", n, flag, maxLimit)
 }
 
 func rangeAgo(dur string) string {
@@ -297,6 +889,68 @@ func rangeFromTo(from, to string) string {
 	return fmt.Sprintf("where _time between (%s .. %s)", datetimeArg(from), datetimeArg(to))
 }
 
+func isDayRange(mode string) bool {
+	return mode == "today" || mode == "yesterday" || mode == "last"
+}
+
+// dayRange resolves range/today, range/yesterday and range/last/<n>d (or
+// <n>w) to whole days in now's location: today until midnight, and the
+// days before today. It also returns how many segments after range/ it
+// used.
+func dayRange(args []string, now time.Time) (time.Time, time.Time, int, error) {
+	today := time.Date(now.Year(), now.Month(), now.Day(), 0, 0, 0, 0, now.Location())
+	switch args[0] {
+	case "today":
+		return today, today.AddDate(0, 0, 1), 1, nil
+	case "yesterday":
+		return today.AddDate(0, 0, -1), today, 1, nil
+	}
+	if len(args) < 2 {
+		return time.Time{}, time.Time{}, 0, fmt.Errorf("range/last missing days")
+	}
+	days, err := parseDays(args[1])
+	if err != nil {
+		return time.Time{}, time.Time{}, 0, err
+	}
+	return today.AddDate(0, 0, -days), today, 2, nil
+}
+
+// parseDays reads range/last's <n>d or <n>w as a number of days.
+func parseDays(value string) (int, error) {
+	per := 0
+	switch {
+	case strings.HasSuffix(value, "d"):
+		per = 1
+	case strings.HasSuffix(value, "w"):
+		per = 7
+	}
+	n, err := strconv.Atoi(value[:max(len(value)-1, 0)])
+	if per == 0 || err != nil || n < 1 {
+		return 0, fmt.Errorf("range/last invalid days: %q (want e.g. 7d or 2w)", value)
+	}
+	return n * per, nil
+}
+
+// parseZone reads tz/'s IANA zone, such as UTC or Europe%2FBerlin.
+func parseZone(value string) (*time.Location, error) {
+	name, err := url.PathUnescape(value)
+	if err != nil || name == "" || name == "Local" {
+		return nil, fmt.Errorf("tz invalid zone: %q", value)
+	}
+	loc, err := time.LoadLocation(name)
+	if err != nil {
+		return nil, fmt.Errorf("tz invalid zone: %q", value)
+	}
+	return loc, nil
+}
+
+func dayRangeNote(loc *time.Location, note string) string {
+	if note == "" {
+		return "days in " + loc.String()
+	}
+	return "days in " + loc.String() + ", " + note
+}
+
 func datetimeArg(value string) string {
 	if strings.HasPrefix(value, "\"") && strings.HasSuffix(value, "\"") {
 		return fmt.Sprintf("datetime(%s)", value)
@@ -320,13 +974,21 @@ func splitFieldDir(input string) (string, string, error) {
 	return field, dir, nil
 }
 
-func isFormat(format string) bool {
-	switch format {
-	case "ndjson", "csv", "json":
-		return true
-	default:
-		return false
+// IsFormat reports whether format is a result format, as in result.<format>.
+func IsFormat(format string) bool {
+	return slices.Contains(formats, format)
+}
+
+// formatAliases are result extensions read as another format.
+var formatAliases = map[string]string{"jsonl": "ndjson"}
+
+// ResultFormat returns the format of result.<ext>, resolving aliases such
+// as jsonl for ndjson, and whether ext is one.
+func ResultFormat(ext string) (string, bool) {
+	if format, ok := formatAliases[ext]; ok {
+		return format, true
 	}
+	return ext, IsFormat(ext)
 }
 
 func decodeExpr(input string) (string, error) {
@@ -366,7 +1028,7 @@ func checkRangeAgo(dur string, maxRange time.Duration) error {
 		return fmt.Errorf("range/ago invalid duration: %q", dur)
 	}
 	if parsed > maxRange {
-		return fmt.Errorf("range exceeds max: %s > %s", parsed, maxRange)
+		return &LimitError{Reason: fmt.Sprintf("range exceeds max: %s > %s", parsed, maxRange)}
 	}
 	return nil
 }
@@ -387,14 +1049,14 @@ func checkRangeFromTo(from, to string, maxRange time.Duration) error {
 		return fmt.Errorf("range invalid: end before start")
 	}
 	if end.Sub(start) > maxRange {
-		return fmt.Errorf("range exceeds max: %s > %s", end.Sub(start), maxRange)
+		return &LimitError{Reason: fmt.Sprintf("range exceeds max: %s > %s", end.Sub(start), maxRange)}
 	}
 	return nil
 }
 
 func checkLimit(n int, maxLimit int) error {
 	if maxLimit > 0 && n > maxLimit {
-		return fmt.Errorf("limit exceeds max: %d > %d", n, maxLimit)
+		return &LimitError{Reason: fmt.Sprintf("limit exceeds max: %d > %d", n, maxLimit)}
 	}
 	return nil
 }
@@ -2,6 +2,9 @@ package compiler
 
 import (
 	"encoding/base64"
+	"errors"
+	"io/fs"
+	"regexp"
 	"strings"
 	"testing"
 	"time"
@@ -210,6 +213,12 @@ func TestCompileSegments_ErrorCases(t *testing.T) {
 			segments: []string{"project-away"},
 			wantErr:  "project-away missing fields",
 		},
+		{
+			name:     "distinct without fields",
+			dataset:  "logs",
+			segments: []string{"distinct"},
+			wantErr:  "distinct missing fields",
+		},
 		{
 			name:     "order without field:dir",
 			dataset:  "logs",
@@ -359,6 +368,72 @@ func TestCompileSegments_LimitConstraints(t *testing.T) {
 	})
 }
 
+func TestCompileSegments_BareResult(t *testing.T) {
+	for _, tc := range []struct {
+		segments []string
+		opts     Options
+		want     string
+	}{
+		{[]string{"result"}, Options{}, "ndjson"},
+		{[]string{"result"}, Options{DefaultFormat: "csv"}, "csv"},
+		{[]string{"format", "json", "result"}, Options{DefaultFormat: "csv"}, "json"},
+		{[]string{"result.tsv"}, Options{DefaultFormat: "csv"}, "tsv"},
+	} {
+		q, err := CompileSegments("logs", tc.segments, tc.opts)
+		if err != nil {
+			t.Fatalf("%v: %v", tc.segments, err)
+		}
+		if q.Format != tc.want {
+			t.Errorf("%v with default %q: format = %q, want %q", tc.segments, tc.opts.DefaultFormat, q.Format, tc.want)
+		}
+	}
+	if _, err := CompileSegments("logs", []string{"result"}, Options{DefaultFormat: "parquet"}); err == nil {
+		t.Error("expected error for unknown default format")
+	}
+}
+
+func TestCompileSegments_Force(t *testing.T) {
+	opts := Options{MaxRange: 24 * time.Hour, MaxLimit: 100, MountPoint: "/mnt/axiom"}
+	_, err := CompileSegments("logs", []string{"range", "ago", "72h", "result.csv"}, opts)
+	var limit *LimitError
+	if !errors.As(err, &limit) || limit.ForcePath != "" || !strings.Contains(err.Error(), "-allow-force") {
+		t.Fatalf("error without -allow-force = %v", err)
+	}
+	if _, err := CompileSegments("logs", []string{"force", "limit", "500", "result.csv"}, opts); err == nil || !strings.Contains(err.Error(), "disabled") {
+		t.Errorf("force/ without -allow-force = %v", err)
+	}
+
+	opts.AllowForce, opts.ForceMaxRange, opts.ForceMaxLimit = true, 7*24*time.Hour, 1000
+	_, err = CompileSegments("logs", []string{"range", "ago", "72h", "result.csv"}, opts)
+	if !errors.As(err, &limit) || limit.ForcePath != "/mnt/axiom/logs/q/force/range/ago/72h/result.csv" {
+		t.Fatalf("error = %v, want the force path", err)
+	}
+	if !strings.Contains(err.Error(), "read /mnt/axiom/logs/q/force/range/ago/72h/result.csv") {
+		t.Errorf("error text = %q", err)
+	}
+
+	q, err := CompileSegments("logs", []string{"force", "range", "ago", "72h", "limit", "500", "result.csv"}, opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(q.APL, "ago(72h)") || !strings.Contains(q.APL, "take 500") {
+		t.Errorf("forced APL = %s", q.APL)
+	}
+
+	for _, segments := range [][]string{
+		{"force", "range", "ago", "720h", "result.csv"},
+		{"force", "limit", "5000", "result.csv"},
+	} {
+		_, err := CompileSegments("logs", segments, opts)
+		if err == nil || !strings.Contains(err.Error(), "exceeds force max") || errors.As(err, &limit) {
+			t.Errorf("%v past the hard cap = %v", segments, err)
+		}
+	}
+	if _, err := CompileSegments("logs", []string{"limit", "5", "force", "result.csv"}, opts); err == nil {
+		t.Error("force/ accepted after the first segment")
+	}
+}
+
 func TestCompileSegments_RangeConstraints(t *testing.T) {
 	t.Run("MaxRange enforcement for ago", func(t *testing.T) {
 		_, err := CompileSegments("logs", []string{"range", "ago", "48h", "result.ndjson"}, Options{MaxRange: 24 * time.Hour})
@@ -443,6 +518,198 @@ func TestCompileSegments_RangeConstraints(t *testing.T) {
 	})
 }
 
+func TestCompileSegments_Sort(t *testing.T) {
+	query, err := CompileSegments("logs", []string{"sort", "host:desc:nulls-last", "result.csv"}, Options{})
+	if err != nil {
+		t.Fatalf("compile failed: %v", err)
+	}
+	if !strings.Contains(query.APL, "\n| order by host desc nulls last\n") || query.NaturalSort != nil {
+		t.Errorf("APL = %s, natural = %v", query.APL, query.NaturalSort)
+	}
+
+	query, err = CompileSegments("logs", []string{"sort", "host:asc:natural", "result.csv"}, Options{})
+	if err != nil {
+		t.Fatalf("compile failed: %v", err)
+	}
+	want := Sort{Field: "host", Natural: true}
+	if !strings.Contains(query.APL, "\n| order by host asc\n") || query.NaturalSort == nil || *query.NaturalSort != want {
+		t.Errorf("APL = %s, natural = %+v", query.APL, query.NaturalSort)
+	}
+	if got := want.String(); got != "host:asc:natural" {
+		t.Errorf("String() = %q", got)
+	}
+
+	for _, bad := range []string{"host", "host:up", "host:asc:natural:natural", "host:asc:locale", "host:asc:natural:nulls-last:x"} {
+		if _, err := CompileSegments("logs", []string{"sort", bad}, Options{}); err == nil {
+			t.Errorf("sort/%s: expected error", bad)
+		}
+	}
+}
+
+func TestCompileSegments_Stages(t *testing.T) {
+	opts := Options{DefaultRange: "1h", DefaultLimit: 100, MaxLimit: 500}
+	query, err := CompileSegments("logs", []string{"where", "status>=500", "cols", "a,b", "limit", "50", "result.csv"}, opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []Stage{
+		{APL: "['logs']", Note: "dataset logs"},
+		{APL: "where _time between (ago(1h) .. now())", Note: "default range: no range/ segment"},
+		{APL: "where status>=500", Segment: "where/status>=500"},
+		{APL: "take 50", Segment: "limit/50", Note: "50 within --max-limit 500"},
+		{Segment: "cols/a,b", Note: "after execution, keep only columns a, b"},
+	}
+	if len(query.Stages) != len(want) {
+		t.Fatalf("stages = %+v", query.Stages)
+	}
+	for i := range want {
+		if got := query.Stages[i]; got.APL != want[i].APL || got.Segment != want[i].Segment || got.Note != want[i].Note {
+			t.Errorf("stage %d = %+v, want %+v", i, got, want[i])
+		}
+	}
+
+	// The APL is the stages' steps, with defaults where segments are missing.
+	query, err = CompileSegments("logs", []string{"auto-range"}, opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	last := query.Stages[len(query.Stages)-1]
+	if last.APL != "take 100" || !strings.Contains(last.Note, "rows past 100 are dropped") {
+		t.Errorf("default limit stage = %+v", last)
+	}
+	if !strings.Contains(query.Stages[1].Note, "auto-range/ widens it") {
+		t.Errorf("default range stage = %+v", query.Stages[1])
+	}
+	if query.APL != "['logs']\n| "+query.Stages[1].APL+"\n| take 100" {
+		t.Errorf("APL = %q", query.APL)
+	}
+}
+
+func TestFieldRef(t *testing.T) {
+	for name, want := range map[string]string{
+		"status":           "status",
+		"attributes.http2": "attributes.http2",
+		"http/status":      `['http/status']`,
+		"2xx":              `['2xx']`,
+		"it's\na\\b":       `['it\'s\na\\b']`,
+	} {
+		if got := FieldRef(name); got != want {
+			t.Errorf("FieldRef(%q) = %s, want %s", name, got, want)
+		}
+	}
+}
+
+func TestCompileSegments_Select(t *testing.T) {
+	fields := []string{"_time", "service", "status", "http.method"}
+	query, err := CompileSegments("logs", []string{"select", "service:svc,status,http.method:method%20name", "result.csv"}, Options{Fields: fields})
+	if err != nil {
+		t.Fatalf("compile failed: %v", err)
+	}
+	if !strings.Contains(query.APL, "\n| project svc=service, status, ['method name']=http.method\n") {
+		t.Errorf("APL missing project:\n%s", query.APL)
+	}
+
+	_, err = CompileSegments("logs", []string{"select", "service:svc,region", "result.csv"}, Options{Fields: fields})
+	if !errors.Is(err, ErrUnknownField) || !strings.Contains(err.Error(), `"region"`) {
+		t.Errorf("unknown field: err = %v", err)
+	}
+
+	for _, segment := range []string{",", "service:", ":svc", "service:x,status:x"} {
+		if _, err := CompileSegments("logs", []string{"select", segment, "result.csv"}, Options{}); err == nil {
+			t.Errorf("select/%s: expected error", segment)
+		}
+	}
+}
+
+func TestCompileSegments_Distinct(t *testing.T) {
+	fields := []string{"_time", "service", "status"}
+	query, err := CompileSegments("logs", []string{"distinct", "service,%20status", "result.csv"}, Options{Fields: fields})
+	if err != nil {
+		t.Fatalf("compile failed: %v", err)
+	}
+	if !strings.Contains(query.APL, "\n| distinct service, status\n") {
+		t.Errorf("APL missing distinct:\n%s", query.APL)
+	}
+
+	query, err = CompileSegments("logs", []string{"distinct", "service,http-status", "result.csv"}, Options{})
+	if err != nil {
+		t.Fatalf("compile failed: %v", err)
+	}
+	if !strings.Contains(query.APL, "\n| distinct service, ['http-status']\n") {
+		t.Errorf("APL should quote field names:\n%s", query.APL)
+	}
+
+	_, err = CompileSegments("logs", []string{"distinct", "service,region", "result.csv"}, Options{Fields: fields})
+	if !errors.Is(err, ErrUnknownField) || !errors.Is(err, fs.ErrInvalid) || !strings.Contains(err.Error(), `"region"`) {
+		t.Errorf("unknown field: err = %v", err)
+	}
+
+	// Without a field list, or after the columns were replaced, fields are
+	// not checked.
+	for _, segs := range [][]string{
+		{"distinct", "region", "result.csv"},
+		{"summarize", "count()", "by", "service", "distinct", "count_", "result.csv"},
+	} {
+		opts := Options{Fields: fields}
+		if segs[0] == "distinct" {
+			opts.Fields = nil
+		}
+		if _, err := CompileSegments("logs", segs, opts); err != nil {
+			t.Errorf("%v: %v", segs, err)
+		}
+	}
+}
+
+func TestCompileSegments_Grep(t *testing.T) {
+	cases := []struct {
+		name     string
+		segments []string
+		want     string
+	}{
+		{"plain", []string{"grep", "timeout", "result.ndjson"}, `search "timeout"`},
+		{"case insensitive", []string{"grepi", "Timeout", "result.ndjson"}, `search kind=case_insensitive "Timeout"`},
+		{"percent encoded", []string{"grep", "connection%20reset", "result.ndjson"}, `search "connection reset"`},
+		{"quotes escaped", []string{"grep", `say%20%22hi%22`, "result.ndjson"}, `search "say \"hi\""`},
+		{"no base64 heuristic", []string{"grep", "dGVzdA", "result.ndjson"}, `search "dGVzdA"`},
+	}
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			query, err := CompileSegments("logs", tc.segments, Options{})
+			if err != nil {
+				t.Fatalf("compile failed: %v", err)
+			}
+			if !strings.Contains(query.APL, "\n| "+tc.want+"\n") {
+				t.Errorf("APL missing %q:\n%s", tc.want, query.APL)
+			}
+		})
+	}
+
+	for _, segs := range [][]string{{"grep"}, {"grepi"}, {"grep", "%zz"}} {
+		if _, err := CompileSegments("logs", segs, Options{}); err == nil {
+			t.Errorf("expected error for %v", segs)
+		}
+	}
+}
+
+func TestCompileSegments_Alias(t *testing.T) {
+	opts := Options{Aliases: map[string][]string{"all-logs": {"logs-a", "logs-b"}}}
+	query, err := CompileSegments("all-logs", []string{"where", "status>=500", "result.csv"}, opts)
+	if err != nil {
+		t.Fatalf("compile failed: %v", err)
+	}
+	if !strings.HasPrefix(query.APL, "union ['logs-a'], ['logs-b']\n| ") {
+		t.Errorf("expected union source, got:\n%s", query.APL)
+	}
+	if query.Dataset != "all-logs" {
+		t.Errorf("Dataset = %q, want all-logs", query.Dataset)
+	}
+
+	plain, _ := CompileSegments("logs-a", []string{"result.csv"}, opts)
+	if !strings.HasPrefix(plain.APL, "['logs-a']\n") {
+		t.Errorf("non-alias source changed:\n%s", plain.APL)
+	}
+}
+
 func TestCompileSegments_EdgeCases(t *testing.T) {
 	t.Run("multiple where clauses in sequence", func(t *testing.T) {
 		query, err := CompileSegments("logs", []string{
@@ -638,3 +905,232 @@ func TestCompileQueryPath_Variations(t *testing.T) {
 		})
 	}
 }
+
+func TestCompileSegments_AutoRange(t *testing.T) {
+	query, err := CompileSegments("logs", []string{"auto-range", "result.ndjson"}, Options{})
+	if err != nil {
+		t.Fatalf("compile failed: %v", err)
+	}
+	if !query.AutoRange {
+		t.Error("expected AutoRange to be set")
+	}
+	if !strings.Contains(query.APL, "ago(1h)") {
+		t.Errorf("auto-range should keep the default range: %s", query.APL)
+	}
+
+	if _, err := CompileSegments("logs", []string{"auto-range", "range", "ago", "2h", "result.ndjson"}, Options{}); err == nil {
+		t.Error("expected error combining auto-range with range")
+	}
+}
+
+func TestCompileSegments_Cols(t *testing.T) {
+	query, err := CompileSegments("logs", []string{"cols", "service, status", "result.csv"}, Options{})
+	if err != nil {
+		t.Fatalf("compile failed: %v", err)
+	}
+	if got := strings.Join(query.Columns, "|"); got != "service|status" {
+		t.Errorf("Columns = %q, want service|status", got)
+	}
+	if strings.Contains(query.APL, "project") {
+		t.Errorf("cols should not change the APL: %s", query.APL)
+	}
+
+	for _, segments := range [][]string{{"cols"}, {"cols", ",", "result.csv"}} {
+		if _, err := CompileSegments("logs", segments, Options{}); err == nil {
+			t.Errorf("expected error for %v", segments)
+		}
+	}
+}
+
+func TestCompileSegments_Label(t *testing.T) {
+	query, err := CompileSegments("logs", []string{"label", "team-a", "where", "status==500", "result.csv"}, Options{})
+	if err != nil {
+		t.Fatalf("compile failed: %v", err)
+	}
+	if query.Label != "team-a" {
+		t.Errorf("Label = %q, want team-a", query.Label)
+	}
+	if strings.Contains(query.APL, "team-a") {
+		t.Errorf("label should not change the APL: %s", query.APL)
+	}
+
+	for _, segments := range [][]string{{"label"}, {"label", "team a", "result.csv"}} {
+		if _, err := CompileSegments("logs", segments, Options{}); err == nil {
+			t.Errorf("expected error for %v", segments)
+		}
+	}
+}
+
+func TestCompileSegments_Flatten(t *testing.T) {
+	query, err := CompileSegments("logs", []string{"flatten", "dot", "where", "status==500", "result.csv"}, Options{})
+	if err != nil {
+		t.Fatalf("compile failed: %v", err)
+	}
+	if query.Flatten != FlattenDot {
+		t.Errorf("Flatten = %q, want dot", query.Flatten)
+	}
+	if strings.Contains(query.APL, "flatten") {
+		t.Errorf("flatten should not change the APL: %s", query.APL)
+	}
+
+	for _, segments := range [][]string{{"flatten"}, {"flatten", "underscore", "result.csv"}} {
+		if _, err := CompileSegments("logs", segments, Options{}); err == nil {
+			t.Errorf("expected error for %v", segments)
+		}
+	}
+}
+
+func TestCompileSegments_ChartFormats(t *testing.T) {
+	for _, ext := range []string{"vl.json", "svg"} {
+		query, err := CompileSegments("logs", []string{"summarize", "count()", "by", "bin(_time, 5m)", "result." + ext}, Options{})
+		if err != nil || query.Format != ext {
+			t.Errorf("result.%s: format=%q err=%v", ext, query.Format, err)
+		}
+	}
+}
+
+func TestCompileSegments_Sample(t *testing.T) {
+	query, err := CompileSegments("logs", []string{"sample", "1", "where", "status==500", "result.csv"}, Options{})
+	if err != nil {
+		t.Fatalf("compile failed: %v", err)
+	}
+	if !strings.Contains(query.APL, "| where rand() < 0.01\n| where status==500") {
+		t.Errorf("APL = %s", query.APL)
+	}
+	if got := SampleAPL(0.5); got != "where rand() < 0.005" {
+		t.Errorf("SampleAPL(0.5) = %q", got)
+	}
+
+	for _, segments := range [][]string{{"sample"}, {"sample", "0", "result.csv"}, {"sample", "150", "result.csv"}, {"sample", "1%", "result.csv"}} {
+		if _, err := CompileSegments("logs", segments, Options{}); err == nil {
+			t.Errorf("expected error for %v", segments)
+		}
+	}
+}
+
+func TestCompileSegments_DayRanges(t *testing.T) {
+	berlin, err := time.LoadLocation("Europe/Berlin")
+	if err != nil {
+		t.Skip(err)
+	}
+	// 01:30 on 2025-03-31 in Berlin, the day after the switch to summer time.
+	now := time.Date(2025, 3, 30, 23, 30, 0, 0, time.UTC)
+	cases := []struct {
+		segments []string
+		loc      *time.Location
+		want     string
+	}{
+		{[]string{"range", "today", "result.csv"}, nil, `between (datetime("2025-03-30T00:00:00Z") .. datetime("2025-03-31T00:00:00Z"))`},
+		{[]string{"range", "today", "result.csv"}, berlin, `between (datetime("2025-03-30T22:00:00Z") .. datetime("2025-03-31T22:00:00Z"))`},
+		{[]string{"range", "yesterday", "result.csv"}, berlin, `between (datetime("2025-03-29T23:00:00Z") .. datetime("2025-03-30T22:00:00Z"))`},
+		{[]string{"range", "last", "2d", "result.csv"}, berlin, `between (datetime("2025-03-28T23:00:00Z") .. datetime("2025-03-30T22:00:00Z"))`},
+		{[]string{"range", "last", "1w", "result.csv"}, nil, `between (datetime("2025-03-23T00:00:00Z") .. datetime("2025-03-30T00:00:00Z"))`},
+		{[]string{"tz", "Europe%2FBerlin", "range", "today", "result.csv"}, nil, `between (datetime("2025-03-30T22:00:00Z") .. datetime("2025-03-31T22:00:00Z"))`},
+		{[]string{"tz", "UTC", "range", "today", "result.csv"}, berlin, `between (datetime("2025-03-30T00:00:00Z") .. datetime("2025-03-31T00:00:00Z"))`},
+	}
+	for _, tc := range cases {
+		query, err := CompileSegments("logs", tc.segments, Options{Location: tc.loc, Now: now})
+		if err != nil {
+			t.Errorf("%v: %v", tc.segments, err)
+			continue
+		}
+		if !strings.Contains(query.APL, tc.want) {
+			t.Errorf("%v: APL = %s, want %s", tc.segments, query.APL, tc.want)
+		}
+		if strings.Contains(query.APL, "ago(") {
+			t.Errorf("%v: default range added: %s", tc.segments, query.APL)
+		}
+	}
+
+	for _, segments := range [][]string{
+		{"range", "last", "result.csv"},
+		{"range", "last", "0d", "result.csv"},
+		{"range", "last", "7h", "result.csv"},
+		{"tz", "Mars%2FOlympus", "range", "today", "result.csv"},
+		{"tz", "Local", "range", "today", "result.csv"},
+		{"range", "today", "tz", "UTC", "result.csv"},
+		{"tz"},
+	} {
+		if _, err := CompileSegments("logs", segments, Options{Now: now}); err == nil {
+			t.Errorf("expected error for %v", segments)
+		}
+	}
+
+	_, err = CompileSegments("logs", []string{"range", "last", "7d", "result.csv"}, Options{Now: now, MaxRange: 24 * time.Hour})
+	var limit *LimitError
+	if !errors.As(err, &limit) {
+		t.Errorf("range/last/7d past MaxRange: %v", err)
+	}
+}
+
+func TestCompileSegments_JSONL(t *testing.T) {
+	for _, segments := range [][]string{{"result.jsonl"}, {"format", "jsonl", "result"}} {
+		query, err := CompileSegments("logs", segments, Options{})
+		if err != nil || query.Format != "ndjson" {
+			t.Errorf("%v: format=%q err=%v", segments, query.Format, err)
+		}
+	}
+}
+
+var placeholder = regexp.MustCompile(`<[^>]+>(,\.\.\.)?`)
+
+func TestSegments(t *testing.T) {
+	opts := Options{AllowForce: true, MaxRange: 90 * 24 * time.Hour, MaxLimit: 1000}
+	names := map[string]bool{}
+	for _, rule := range Segments() {
+		names[rule.Name] = true
+		query, err := CompileSegments("logs", strings.Split(rule.Example, "/"), opts)
+		if err != nil {
+			t.Errorf("%s example %q: %v", rule.Name, rule.Example, err)
+			continue
+		}
+		if rule.APL != "" && !strings.Contains(query.APL, strings.Fields(rule.APL)[0]) {
+			t.Errorf("%s example compiled to %s, want %s", rule.Name, query.APL, rule.APL)
+		}
+		for _, arg := range rule.Args {
+			for _, p := range placeholder.FindAllString(arg, -1) {
+				if _, ok := Placeholders()[p]; !ok {
+					t.Errorf("%s: placeholder %s not explained", rule.Name, p)
+				}
+			}
+		}
+	}
+
+	// Every segment the compiler knows is described: a name absent from
+	// the rules is an unknown segment.
+	for _, name := range []string{"force", "range", "tz", "where", "search", "grep", "grepi", "summarize", "project", "project-away", "select", "distinct", "sample", "order", "sort", "limit", "top", "cols", "flatten", "label", "auto-range", "format", "_", "#<tag>"} {
+		if !names[name] {
+			t.Errorf("segment %s missing from Segments()", name)
+		}
+	}
+	if _, err := CompileSegments("logs", []string{"bogus", "result.csv"}, opts); err == nil || names["bogus"] {
+		t.Error("unknown segments should fail")
+	}
+	for _, format := range Formats() {
+		if !IsFormat(format) {
+			t.Errorf("IsFormat(%q) = false", format)
+		}
+	}
+}
+
+func TestCompileSegments_Comments(t *testing.T) {
+	plain, err := CompileSegments("logs", []string{"range", "ago", "1h", "where", "status>=500", "result.csv"}, Options{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	commented, err := CompileSegments("logs", []string{"#nightly", "range", "ago", "1h", "_", "errors-only", "where", "status>=500", "#v2", "result.csv"}, Options{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if commented.APL != plain.APL || commented.Format != plain.Format {
+		t.Errorf("comments changed the query: %s, want %s", commented.APL, plain.APL)
+	}
+	for _, stage := range commented.Stages {
+		if strings.Contains(stage.Segment, "errors-only") || strings.Contains(stage.Segment, "#") {
+			t.Errorf("stage %+v spans a comment", stage)
+		}
+	}
+	if _, err := CompileSegments("logs", []string{"where", "x==1", "_"}, Options{}); err == nil {
+		t.Error("expected error for _ without a comment")
+	}
+}
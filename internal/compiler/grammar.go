@@ -0,0 +1,100 @@
+package compiler
+
+import (
+	"maps"
+	"slices"
+)
+
+// SegmentRule describes one form of a q/ path segment, for tools that build
+// paths rather than read about them.
+type SegmentRule struct {
+	Name string `json:"name"`
+	// Args are the path elements after Name: literal words as they are,
+	// placeholders in angle brackets, such as <n>, by, <field>:<dir>.
+	Args []string `json:"args,omitempty"`
+	// APL is the step the segment adds, empty for segments applied after
+	// execution or that only set options.
+	APL         string `json:"apl,omitempty"`
+	Description string `json:"description"`
+	// First is set for segments allowed only as the first one.
+	First bool `json:"first,omitempty"`
+	// Example is a path after q/ using the segment, ending in a result.
+	Example string `json:"example"`
+}
+
+// segmentRules lists every form CompileSegments accepts, in the order of
+// its switch.
+var segmentRules = []SegmentRule{
+	{Name: "force", First: true, Description: "lift -max-range and -max-limit to -force-max-range and -force-max-limit; needs -allow-force", Example: "force/range/ago/720h/result.ndjson"},
+	{Name: "range", Args: []string{"ago", "<duration>"}, APL: "where _time between (ago(<duration>) .. now())", Description: "events from <duration> ago until now", Example: "range/ago/1h/result.ndjson"},
+	{Name: "range", Args: []string{"from", "<time>", "to", "<time>"}, APL: "where _time between (datetime(<time>) .. datetime(<time>))", Description: "events between two times", Example: "range/from/2025-01-01T00:00:00Z/to/2025-01-02T00:00:00Z/result.ndjson"},
+	{Name: "range", Args: []string{"today"}, APL: "where _time between (datetime(<midnight>) .. datetime(<midnight>))", Description: "today, midnight to midnight in -timezone or tz/", Example: "range/today/result.ndjson"},
+	{Name: "range", Args: []string{"yesterday"}, APL: "where _time between (datetime(<midnight>) .. datetime(<midnight>))", Description: "yesterday, midnight to midnight in -timezone or tz/", Example: "range/yesterday/result.ndjson"},
+	{Name: "range", Args: []string{"last", "<days>"}, APL: "where _time between (datetime(<midnight>) .. datetime(<midnight>))", Description: "the whole days before today in -timezone or tz/", Example: "range/last/1d/result.csv"},
+	{Name: "tz", Args: []string{"<zone>"}, Description: "time zone of the range/today, range/yesterday and range/last after it", Example: "tz/Asia%2FTokyo/range/today/result.ndjson"},
+	{Name: "where", Args: []string{"<expr>"}, APL: "where <expr>", Description: "keep rows matching an expression", Example: "where/status>=500/result.ndjson"},
+	{Name: "search", Args: []string{"<term>"}, APL: `search "<term>"`, Description: "full-text search", Example: "search/timeout/result.ndjson"},
+	{Name: "grep", Args: []string{"<text>"}, APL: `search "<text>"`, Description: "full-text search for literal text, only percent-decoded", Example: "grep/connection%20reset/result.ndjson"},
+	{Name: "grepi", Args: []string{"<text>"}, APL: `search kind=case_insensitive "<text>"`, Description: "case-insensitive grep", Example: "grepi/Timeout/result.ndjson"},
+	{Name: "summarize", Args: []string{"<agg>"}, APL: "summarize <agg>", Description: "aggregate all rows", Example: "summarize/count()/result.csv"},
+	{Name: "summarize", Args: []string{"<agg>", "by", "<fields>"}, APL: "summarize <agg> by <fields>", Description: "aggregate per group", Example: "summarize/count()/by/service/result.csv"},
+	{Name: "project", Args: []string{"<fields>"}, APL: "project <fields>", Description: "keep and compute columns", Example: "project/_time,message/result.ndjson"},
+	{Name: "project-away", Args: []string{"<fields>"}, APL: "project-away <fields>", Description: "drop columns", Example: "project-away/trace_id/result.ndjson"},
+	{Name: "select", Args: []string{"<field[:alias]>,..."}, APL: "project <alias>=<field>, ...", Description: "keep, order and rename columns; fields are checked against the dataset", Example: "select/_time:time,message/result.csv"},
+	{Name: "distinct", Args: []string{"<fields>"}, APL: "distinct <fields>", Description: "unique combinations of fields; fields are checked against the dataset", Example: "distinct/message/result.csv"},
+	{Name: "sample", Args: []string{"<percent>"}, APL: "where rand() < <percent>/100", Description: "keep about <percent> of rows, at random", Example: "sample/1/result.ndjson"},
+	{Name: "order", Args: []string{"<field>:<dir>"}, APL: "order by <field> <dir>", Description: "sort rows", Example: "order/_time:desc/result.ndjson"},
+	{Name: "sort", Args: []string{"<field>:<dir>[:nulls-last][:natural]"}, APL: "order by <field> <dir> [nulls last]", Description: "sort rows, optionally nulls last or in natural order", Example: "sort/host:asc:natural/result.csv"},
+	{Name: "limit", Args: []string{"<n>"}, APL: "take <n>", Description: "at most <n> rows, within -max-limit", Example: "limit/100/result.ndjson"},
+	{Name: "top", Args: []string{"<n>", "by", "<field>:<dir>"}, APL: "top <n> by <field> <dir>", Description: "the first <n> rows by a field, within -max-limit", Example: "top/10/by/duration:desc/result.csv"},
+	{Name: "cols", Args: []string{"<fields>"}, Description: "after execution, keep only these columns", Example: "cols/_time,message/result.csv"},
+	{Name: "flatten", Args: []string{FlattenDot}, Description: "after execution, spread nested objects over dotted columns in csv and tsv", Example: "flatten/dot/result.csv"},
+	{Name: "label", Args: []string{"<name>"}, Description: "count the query's cost under <name> in /_status/costs.json", Example: "label/team-a/result.ndjson"},
+	{Name: "auto-range", Description: "widen the default range until rows appear; not with range/", Example: "auto-range/result.ndjson"},
+	{Name: "format", Args: []string{"<format>"}, Description: "format of a bare result file", Example: "format/csv/result"},
+	{Name: "_", Args: []string{"<comment>"}, Description: "ignored; chunks long paths for readers", Example: "range/ago/1h/_/errors-only/where/status>=500/result.ndjson"},
+	{Name: "#<tag>", Description: "ignored; any segment starting with # tags a path for scripts", Example: "#nightly/result.ndjson"},
+}
+
+// placeholders explains the placeholders in segmentRules' Args.
+var placeholders = map[string]string{
+	"<duration>":          "duration such as 30m or 72h, within -max-range",
+	"<time>":              "RFC 3339 time, such as 2025-01-01T00:00:00Z",
+	"<days>":              "days such as 7d, or weeks such as 2w",
+	"<zone>":              "IANA time zone, URL-encoded, such as UTC or Europe%2FBerlin",
+	"<expr>":              "APL expression, URL-encoded or base64url-encoded",
+	"<term>":              "search term, URL-encoded or base64url-encoded",
+	"<text>":              "literal text, URL-encoded",
+	"<agg>":               "APL aggregation, such as count() or avg(duration), encoded as <expr>",
+	"<fields>":            "comma-separated field names or expressions, encoded as <expr>",
+	"<field[:alias]>,...": "comma-separated fields, each optionally renamed after a colon",
+	"<field>":             "field name",
+	"<dir>":               "asc or desc",
+	"<percent>":           "number above 0, up to 100",
+	"<n>":                 "non-negative integer",
+	"<name>":              "letters, digits, '-', '_' or '.'",
+	"<format>":            "a result format or alias",
+	"<comment>":           "any single segment",
+}
+
+// formats lists the result formats IsFormat accepts.
+var formats = []string{"ndjson", "csv", "json", "tsv", "xlsx", "vl.json", "svg", "md"}
+
+// Segments returns the forms of every q/ path segment.
+func Segments() []SegmentRule {
+	rules := make([]SegmentRule, len(segmentRules))
+	for i, rule := range segmentRules {
+		rule.Args = slices.Clone(rule.Args)
+		rules[i] = rule
+	}
+	return rules
+}
+
+// Placeholders explains the placeholders in Segments' Args.
+func Placeholders() map[string]string { return maps.Clone(placeholders) }
+
+// Formats returns the result formats, as in result.<format>.
+func Formats() []string { return slices.Clone(formats) }
+
+// FormatAliases returns the extensions read as another format.
+func FormatAliases() map[string]string { return maps.Clone(formatAliases) }
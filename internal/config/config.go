@@ -1,30 +1,310 @@
 package config
 
 import (
+	"encoding/json"
+	"errors"
+	"fmt"
 	"os"
+	"path"
 	"path/filepath"
+	"strings"
 	"time"
+
+	"github.com/axiomhq/axiom-fs/internal/apl"
+	"github.com/axiomhq/axiom-fs/internal/compiler"
+)
+
+const (
+	// StatModeExact executes the query to size a result file.
+	StatModeExact = "exact"
+	// StatModeEstimate sizes result files from a count probe and a small
+	// sample; the exact size replaces it once the file is read.
+	StatModeEstimate = "estimate"
 )
 
+// Path classes CacheStalePaths may list: q/ paths, dataset presets,
+// fields/ files, sample.ndjson, saved queries and dashboard charts.
+const (
+	StalePathQuery      = "q"
+	StalePathPresets    = "presets"
+	StalePathFields     = "fields"
+	StalePathSample     = "sample"
+	StalePathQueries    = "queries"
+	StalePathDashboards = "dashboards"
+)
+
+// StalePaths lists every path class, for validating CacheStalePaths.
+var StalePaths = []string{StalePathQuery, StalePathPresets, StalePathFields, StalePathSample, StalePathQueries, StalePathDashboards}
+
+// DatasetDefaults overrides the query defaults for one dataset or alias.
+// Zero fields keep the global value.
+type DatasetDefaults struct {
+	DefaultRange string `json:"default_range,omitempty"`
+	DefaultLimit int    `json:"default_limit,omitempty"`
+	SampleLimit  int    `json:"sample_limit,omitempty"`
+	// DefaultFormat is the format of bare result files.
+	DefaultFormat string `json:"default_format,omitempty"`
+}
+
 type Config struct {
-	ListenAddr       string
-	DefaultRange     string
-	DefaultLimit     int
-	MaxLimit         int
-	MaxRange         time.Duration
-	CacheTTL         time.Duration
-	MetadataTTL      time.Duration
-	MaxCacheEntries  int
-	MaxCacheBytes    int
-	MaxInMemoryBytes int
-	CacheDir         string
-	QueryDir         string
-	TempDir          string
-	SampleLimit      int
+	ListenAddr   string
+	DefaultRange string
+	DefaultLimit int
+	// DefaultFormat is the format bare result files, without an
+	// extension, are encoded in when no format/ segment says otherwise.
+	DefaultFormat string
+	MaxLimit      int
+	MaxRange      time.Duration
+	// AllowForce permits q/force/ paths, which may exceed MaxRange and
+	// MaxLimit up to ForceMaxRange and ForceMaxLimit; zero is uncapped.
+	AllowForce    bool
+	ForceMaxRange time.Duration
+	ForceMaxLimit int
+	CacheTTL      time.Duration
+	MetadataTTL   time.Duration
+	// MetadataMaxStale is how long past MetadataTTL the last dataset
+	// listing is still served, while it is refreshed in the background,
+	// before listings wait for Axiom again; zero always waits.
+	MetadataMaxStale time.Duration
+	// MetadataWarmStart serves the dataset and field lists saved in CacheDir
+	// at startup however old they are, revalidating them in the background
+	// instead of blocking the first listings on Axiom.
+	MetadataWarmStart bool
+	// MetadataPollInterval is how often the dataset list is polled so that
+	// created and deleted datasets show up before MetadataTTL expires; zero
+	// disables polling.
+	MetadataPollInterval time.Duration
+	// DeletedDatasetGrace is how long a dataset that disappeared from Axiom
+	// stays listed, with its cached results served read-only, before its
+	// caches are dropped; zero drops them at once.
+	DeletedDatasetGrace time.Duration
+	MaxCacheEntries     int
+	MaxCacheBytes       int
+	MaxInMemoryBytes    int
+	// MaxResultBytes cuts encoded results past this size, marking them
+	// truncated; zero is no cap.
+	MaxResultBytes int
+	CacheDir       string
+	QueryDir       string
+	SnippetDir     string
+	TempDir        string
+	SampleLimit    int
+	// SampleAutoRange widens sample.ndjson's range when the default is empty.
+	SampleAutoRange bool
+	// ExploreSample is the percent of rows a dataset's sample.ndjson is
+	// drawn from at random, so reads of huge datasets stay cheap; zero
+	// takes the first rows.
+	ExploreSample float64
+
+	// FieldShardThreshold is the field count above which <dataset>/fields/
+	// lists one subdirectory per first character instead of every field;
+	// zero never shards.
+	FieldShardThreshold int
+
+	// IncludeHiddenFields lists hidden fields in fields/, schema.csv,
+	// schema.jsonschema, _meta fields.json and field search. They are
+	// always under fields/.hidden/.
+	IncludeHiddenFields bool
+
+	// Revalidate probes cached results with a cheap count query before
+	// serving them, re-executing when the data changed.
+	Revalidate bool
+
+	// FollowCursorPages continues results Axiom returns partial for up to
+	// this many more requests; zero serves the first page, marked
+	// truncated.
+	FollowCursorPages int
+
+	// ReadProbeRows, when positive, serves reads of the first bytes of
+	// ndjson, csv and tsv q/ results from a query taking only this many
+	// rows; the full query runs once reads go past them.
+	ReadProbeRows int
+
+	// FlattenMaxDepth caps how many levels of nested objects flatten/dot
+	// spreads over columns; deeper ones stay JSON. Zero means no cap.
+	FlattenMaxDepth int
+
+	// CacheWarmInterval is how often results that are read repeatedly are
+	// checked and refreshed shortly before they expire; zero disables
+	// warming. CacheWarmConcurrency bounds concurrent refreshes.
+	CacheWarmInterval    time.Duration
+	CacheWarmConcurrency int
+
+	// CacheMaxStale is how long past CacheTTL an expired result of a path
+	// class listed in CacheStalePaths, comma-separated, is served while it
+	// is re-executed in the background; zero always waits for a fresh one.
+	CacheMaxStale   time.Duration
+	CacheStalePaths string
+
+	// PresetSizeInterval paces the queries run after mount to size the
+	// aggregating presets of every dataset, so they do not show as empty
+	// files; zero disables sizing, leaving them 0 bytes until read.
+	PresetSizeInterval time.Duration
+
+	// QueryConcurrency caps the queries run against Axiom at once; queries
+	// past it wait in one queue per NFS client, served in turn. Zero is no
+	// cap. ClientWeightsFile is a JSON object of client address to weight,
+	// the queries a client may start per turn (default 1).
+	QueryConcurrency  int
+	ClientWeightsFile string
+	ClientWeights     map[string]int
+
+	// BatchConcurrency bounds the queries of one /_batch/<name>/ run that
+	// execute at once.
+	BatchConcurrency int
+
+	// Cache segments. Metadata (result meta, counts and estimates) and
+	// results of at least CacheLargeThreshold bytes are cached apart from
+	// other results, with their own limits, so large results cannot evict
+	// them. MaxCacheEntries and MaxCacheBytes bound the remaining results;
+	// a zero CacheLargeThreshold keeps all results together.
+	CacheMetaEntries    int
+	CacheMetaBytes      int
+	CacheLargeThreshold int
+	CacheLargeEntries   int
+	CacheLargeBytes     int
+
+	// CacheSweepInterval is how often the disk cache is swept of expired
+	// entries and trimmed to its limits, in addition to once at startup;
+	// zero sweeps only at startup.
+	CacheSweepInterval time.Duration
+
+	// SortLocale is the BCP 47 locale that sort/<field>:<dir>:natural
+	// compares strings in; empty uses the root collation.
+	SortLocale string
+
+	// Timezone is the IANA zone range/today, range/yesterday and
+	// range/last count days in; empty is UTC. Location is it resolved at
+	// startup.
+	Timezone string
+	Location *time.Location
+
+	// StatMode is StatModeExact or StatModeEstimate and controls how Stat
+	// sizes q/ result files that have not been read yet.
+	StatMode string
+
+	// DynamicFileSize is the size Stat reports for generated files, such as
+	// README.md or schema.csv, that have not been read yet, for clients that
+	// skip reading empty files. Zero generates them on Stat to report their
+	// exact size.
+	DynamicFileSize int64
+
+	// DrainTimeout bounds how long shutdown waits for in-flight queries and
+	// open file handles before persisting the cache and exiting.
+	DrainTimeout time.Duration
+
+	// TailInterval is how often <dataset>/tail.ndjson polls for new events.
+	TailInterval time.Duration
+	// TailHeartbeat appends a heartbeat line to a quiet tail.ndjson this
+	// often; zero disables heartbeats.
+	TailHeartbeat time.Duration
+	// TailMaxBytes is how much of each tail.ndjson stream is retained.
+	TailMaxBytes int
+	// InlineMarkers writes comment lines into data files: the # TRUNCATED
+	// markers ending incomplete results and tail.ndjson heartbeats. Off,
+	// they are left to the sidecars, stats.json and manifest.json for
+	// results and tail.status.json for tails.
+	InlineMarkers bool
+
+	QuotaRowsPerHour  int64
+	QuotaBytesPerHour int64
+
+	// AliasesFile is a JSON file mapping virtual dataset names to the real
+	// datasets they union, e.g. {"all-logs": ["logs-api", "logs-web"]}.
+	// Members may be globs, e.g. {"logs-*": ["logs-*"]}, matched against
+	// the dataset list whenever it is refreshed.
+	AliasesFile string
+	Aliases     map[string][]string
+
+	// DatasetDefaultsFile is a JSON file of per-dataset overrides, e.g.
+	// {"logs-high": {"default_range": "5m"}, "audit": {"default_range": "7d"}}.
+	DatasetDefaultsFile string
+	DatasetDefaults     map[string]DatasetDefaults
+
+	// PolicyFile is a JSON mount policy; see package policy.
+	PolicyFile string
+	// PostProcessFile is a JSON list of rules running commands or
+	// registered processors over encoded results; see package postprocess.
+	PostProcessFile string
+	// TenantFile is a JSON list of restricted views, each with its own
+	// token and policy; when set, only those views are served. See
+	// policy.Tenant.
+	TenantFile string
+	// MaxReadThroughput caps how many bytes per second each file handle is
+	// read at; zero is unlimited.
+	MaxReadThroughput int64
+	// SlowOpThreshold is how long a file operation or query may take before
+	// it is logged to /_status/slow.ndjson; zero disables the log.
+	SlowOpThreshold time.Duration
+	// EnableAdminFiles exposes the destructive control files under /_admin,
+	// each run only by writing the token from its paired .confirm file.
+	EnableAdminFiles bool
+	// InflightJournal journals running result queries to inflight.json in
+	// CacheDir, so queries a restart cuts off are reported after it.
+	InflightJournal bool
+	// MountPoint is where clients mount the export. duckdb.sql reads the
+	// mount's files by absolute path under it.
+	MountPoint string
+	// SuppressFinderJunk answers lookups of macOS Finder and Spotlight
+	// metadata names (.DS_Store, ._* AppleDouble files, .Spotlight-V100,
+	// ...) with ENOENT without walking the tree. FakeDSStore instead lets
+	// Finder write .DS_Store files, held in memory until exit.
+	SuppressFinderJunk bool
+	FakeDSStore        bool
+	// SystemdNotify sends READY=1 to systemd's NOTIFY_SOCKET once every
+	// view has listed its datasets, and STOPPING=1 at shutdown, for
+	// Type=notify units.
+	SystemdNotify bool
+	// AutoMount, set by `serve -auto-mount`, is where the server mounts its
+	// own export once it listens, unmounting it at shutdown; it also sets
+	// MountPoint. Empty leaves mounting to the user.
+	AutoMount string
+	// UID and GID own every file on the mount; negative means the user and
+	// group axiom-fs runs as. FileMode and DirMode, when non-zero, replace
+	// the permission bits of files and directories; writable files keep
+	// their owner write bit and read-only ones get none. The policy's
+	// owners rules override all four per subtree.
+	UID      int
+	GID      int
+	FileMode uint
+	DirMode  uint
 
 	AxiomURL   string
 	AxiomToken string
 	AxiomOrgID string
+	// TokenSource, when set, reads the token from a file, a command or AWS
+	// instead; see axiomclient.ParseTokenSource.
+	TokenSource string
+
+	// MaxIdleConnsPerHost sizes the keep-alive pool to the Axiom API.
+	MaxIdleConnsPerHost int
+	// CAFile is a PEM bundle trusted in addition to the system roots, for
+	// self-hosted Axiom or TLS-intercepting proxies.
+	CAFile string
+	// AppURL is the Axiom web UI base for open.url and link.txt. Empty
+	// derives it from AxiomURL.
+	AppURL string
+
+	// DisableCompression stops asking Axiom for zstd/gzip query responses.
+	DisableCompression bool
+
+	// EncryptionKey, or the contents of EncryptionKeyFile, is a 32-byte
+	// AES-256 key in hex or base64. With one, disk cache entries and spilled
+	// results are encrypted with AES-GCM unless DisableEncryption is set.
+	EncryptionKey     string
+	EncryptionKeyFile string
+	DisableEncryption bool
+
+	// ReplayDir, when set, records every Axiom API response there
+	// (ReplayMode "record") or serves the mount from those recordings
+	// without network or token (ReplayMode "replay").
+	ReplayDir  string
+	ReplayMode string
+
+	// SnapshotFrom and SnapshotTo, when set, pin the mount to that time
+	// range; see PinSnapshot.
+	SnapshotFrom time.Time
+	SnapshotTo   time.Time
 }
 
 func Default() Config {
@@ -36,6 +316,7 @@ func Default() Config {
 	} else {
 		queryDir = "axiom-fs-queries"
 	}
+	snippetDir := filepath.Join(filepath.Dir(queryDir), "snippets")
 	cacheDir := ""
 	if dir, err := os.UserConfigDir(); err == nil {
 		cacheDir = filepath.Join(dir, "axiom-fs", "cache")
@@ -45,19 +326,247 @@ func Default() Config {
 		cacheDir = "axiom-fs-cache"
 	}
 	return Config{
-		ListenAddr:       "127.0.0.1:2049",
-		DefaultRange:     "1h",
-		DefaultLimit:     10000,
-		MaxLimit:         100000,
-		MaxRange:         24 * time.Hour,
-		CacheTTL:         10 * time.Minute,
-		MetadataTTL:      10 * time.Minute,
-		MaxCacheEntries:  256,
-		MaxCacheBytes:    50 << 20,
-		MaxInMemoryBytes: 8 << 20,
-		CacheDir:         cacheDir,
-		QueryDir:         queryDir,
-		TempDir:          "",
-		SampleLimit:      100,
+		ListenAddr:           "127.0.0.1:2049",
+		DefaultRange:         "1h",
+		DefaultLimit:         10000,
+		DefaultFormat:        "ndjson",
+		MaxLimit:             100000,
+		MaxRange:             24 * time.Hour,
+		ForceMaxRange:        30 * 24 * time.Hour,
+		ForceMaxLimit:        1000000,
+		CacheTTL:             10 * time.Minute,
+		MetadataTTL:          10 * time.Minute,
+		MetadataPollInterval: time.Minute,
+		MetadataMaxStale:     24 * time.Hour,
+		DeletedDatasetGrace:  time.Hour,
+		FieldShardThreshold:  1000,
+		MaxCacheEntries:      256,
+		MaxCacheBytes:        50 << 20,
+		MaxInMemoryBytes:     8 << 20,
+		CacheDir:             cacheDir,
+		QueryDir:             queryDir,
+		SnippetDir:           snippetDir,
+		TempDir:              "",
+		SampleLimit:          100,
+		StatMode:             StatModeExact,
+		CacheWarmConcurrency: 4,
+		PresetSizeInterval:   5 * time.Second,
+		CacheStalePaths:      "presets,fields,dashboards",
+		BatchConcurrency:     4,
+		CacheMetaEntries:     4096,
+		CacheMetaBytes:       4 << 20,
+		CacheLargeThreshold:  4 << 20,
+		CacheLargeEntries:    16,
+		CacheLargeBytes:      100 << 20,
+		CacheSweepInterval:   10 * time.Minute,
+		DrainTimeout:         10 * time.Second,
+		TailInterval:         2 * time.Second,
+		TailHeartbeat:        15 * time.Second,
+		TailMaxBytes:         8 << 20,
+		InlineMarkers:        true,
+
+		MaxIdleConnsPerHost: 16,
+		ReplayMode:          "replay",
+		InflightJournal:     true,
+		SlowOpThreshold:     time.Second,
+		MountPoint:          "/mnt/axiom",
+		SuppressFinderJunk:  true,
+		UID:                 -1,
+		GID:                 -1,
+	}
+}
+
+// LoadAliases reads a dataset alias file. An empty path yields no aliases.
+func LoadAliases(file string) (map[string][]string, error) {
+	if file == "" {
+		return nil, nil
+	}
+	data, err := os.ReadFile(file)
+	if err != nil {
+		return nil, err
+	}
+	var aliases map[string][]string
+	if err := json.Unmarshal(data, &aliases); err != nil {
+		return nil, fmt.Errorf("parse aliases %s: %w", file, err)
+	}
+	for name, members := range aliases {
+		if len(members) == 0 {
+			return nil, fmt.Errorf("alias %q has no datasets", name)
+		}
+		if name == "" || strings.Contains(name, "/") {
+			return nil, fmt.Errorf("invalid alias name %q", name)
+		}
+		for _, member := range members {
+			if _, err := path.Match(member, ""); err != nil {
+				return nil, fmt.Errorf("alias %q: invalid dataset glob %q", name, member)
+			}
+			// Aliases expand once, into datasets; an alias of an alias,
+			// or of itself, would not compile. Globs match datasets only.
+			if _, ok := aliases[member]; ok && !IsDatasetGlob(member) {
+				return nil, fmt.Errorf("alias %q: member %q is an alias, not a dataset", name, member)
+			}
+		}
+	}
+	return aliases, nil
+}
+
+// IsDatasetGlob reports whether an alias member is a glob pattern rather
+// than a dataset name.
+func IsDatasetGlob(member string) bool {
+	return strings.ContainsAny(member, "*?[")
+}
+
+// LoadDatasetDefaults reads a per-dataset defaults file. An empty path
+// yields no overrides.
+func LoadDatasetDefaults(path string) (map[string]DatasetDefaults, error) {
+	if path == "" {
+		return nil, nil
+	}
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var defaults map[string]DatasetDefaults
+	if err := json.Unmarshal(data, &defaults); err != nil {
+		return nil, fmt.Errorf("parse dataset defaults %s: %w", path, err)
+	}
+	for name, d := range defaults {
+		if d.DefaultRange != "" {
+			if _, err := apl.ParseTimespan(d.DefaultRange); err != nil {
+				return nil, fmt.Errorf("dataset %q: invalid default_range %q", name, d.DefaultRange)
+			}
+		}
+		if d.DefaultFormat != "" && !compiler.IsFormat(d.DefaultFormat) {
+			return nil, fmt.Errorf("dataset %q: invalid default_format %q", name, d.DefaultFormat)
+		}
+		if d.DefaultLimit < 0 || d.SampleLimit < 0 {
+			return nil, fmt.Errorf("dataset %q: limits must not be negative", name)
+		}
+	}
+	return defaults, nil
+}
+
+// LoadClientWeights reads a client weights file. An empty path yields no
+// weights.
+func LoadClientWeights(path string) (map[string]int, error) {
+	if path == "" {
+		return nil, nil
+	}
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var weights map[string]int
+	if err := json.Unmarshal(data, &weights); err != nil {
+		return nil, fmt.Errorf("parse client weights %s: %w", path, err)
+	}
+	for client, w := range weights {
+		if w < 1 {
+			return nil, fmt.Errorf("client %q: weight must be at least 1", client)
+		}
+	}
+	return weights, nil
+}
+
+// MaxCachedResultBytes is the largest result worth caching: the larger of
+// the small and large segment limits, or zero for unlimited.
+func (c Config) MaxCachedResultBytes() int {
+	if c.CacheLargeThreshold <= 0 {
+		return c.MaxCacheBytes
+	}
+	if c.MaxCacheBytes <= 0 || c.CacheLargeBytes <= 0 {
+		return 0
+	}
+	return max(c.MaxCacheBytes, c.CacheLargeBytes)
+}
+
+// InflightJournalPath is where the in-flight query journal is kept, or
+// empty when there is none.
+func (c Config) InflightJournalPath() string {
+	if !c.InflightJournal || c.CacheDir == "" {
+		return ""
+	}
+	return filepath.Join(c.CacheDir, "inflight.json")
+}
+
+// Tenant returns the configuration of the tenant view name: the mount's,
+// with cache, query and snippet directories of its own beneath the mount's
+// so tenants never share results or saved queries.
+func (c Config) Tenant(name string) Config {
+	if c.CacheDir != "" {
+		c.CacheDir = filepath.Join(c.CacheDir, "tenants", name)
+	}
+	c.QueryDir = filepath.Join(c.QueryDir, "tenants", name)
+	c.SnippetDir = filepath.Join(c.SnippetDir, "tenants", name)
+	c.TokenSource = ""
+	return c
+}
+
+// MaxStale is how long past CacheTTL results of the path class may be
+// served stale: CacheMaxStale when CacheStalePaths lists the class.
+func (c Config) MaxStale(class string) time.Duration {
+	if c.CacheMaxStale <= 0 {
+		return 0
+	}
+	for _, listed := range strings.Split(c.CacheStalePaths, ",") {
+		if strings.TrimSpace(listed) == class {
+			return c.CacheMaxStale
+		}
+	}
+	return 0
+}
+
+// Snapshot reports whether the mount is pinned to a time range.
+func (c Config) Snapshot() bool {
+	return !c.SnapshotFrom.IsZero() || !c.SnapshotTo.IsZero()
+}
+
+// PinSnapshot validates the snapshot range and makes the query defaults
+// cover it: the default range is the whole window, cached results never
+// expire and are not revalidated, and ranges are not widened. It does
+// nothing unless Snapshot is true.
+func (c *Config) PinSnapshot() error {
+	if !c.Snapshot() {
+		return nil
+	}
+	if c.SnapshotFrom.IsZero() || c.SnapshotTo.IsZero() {
+		return errors.New("-snapshot-from and -snapshot-to must be set together")
+	}
+	if !c.SnapshotFrom.Before(c.SnapshotTo) {
+		return fmt.Errorf("-snapshot-from %s is not before -snapshot-to %s", c.SnapshotFrom.Format(time.RFC3339), c.SnapshotTo.Format(time.RFC3339))
+	}
+	window := c.SnapshotTo.Sub(c.SnapshotFrom)
+	c.DefaultRange = fmt.Sprintf("%ds", int64((window+time.Second-1)/time.Second))
+	if c.MaxRange > 0 && c.MaxRange < window {
+		c.MaxRange = window
+	}
+	for name, d := range c.DatasetDefaults {
+		d.DefaultRange = ""
+		c.DatasetDefaults[name] = d
+	}
+	c.CacheTTL = 0
+	c.Revalidate = false
+	c.SampleAutoRange = false
+	return nil
+}
+
+// ForDataset returns c with dataset's overrides applied.
+func (c Config) ForDataset(dataset string) Config {
+	d, ok := c.DatasetDefaults[dataset]
+	if !ok {
+		return c
+	}
+	if d.DefaultRange != "" {
+		c.DefaultRange = d.DefaultRange
+	}
+	if d.DefaultLimit > 0 {
+		c.DefaultLimit = d.DefaultLimit
+	}
+	if d.SampleLimit > 0 {
+		c.SampleLimit = d.SampleLimit
+	}
+	if d.DefaultFormat != "" {
+		c.DefaultFormat = d.DefaultFormat
 	}
+	return c
 }
@@ -0,0 +1,81 @@
+// Package drain tracks in-flight work so shutdown can wait for it.
+package drain
+
+import (
+	"context"
+	"errors"
+	"sync"
+)
+
+// ErrDraining is returned for work started after Close.
+var ErrDraining = errors.New("server is shutting down")
+
+// Group counts in-flight work. Once closed it refuses new work, and Wait
+// blocks until the work already started finishes. The zero value is ready
+// to use.
+type Group struct {
+	mu     sync.Mutex
+	active int
+	closed bool
+	idle   chan struct{}
+}
+
+// Acquire registers one unit of work. It reports false once the group is
+// closed; callers must not start the work then. Every successful Acquire
+// must be paired with Release.
+func (g *Group) Acquire() bool {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	if g.closed {
+		return false
+	}
+	g.active++
+	return true
+}
+
+// Release marks one unit of work as finished.
+func (g *Group) Release() {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	g.active--
+	if g.active == 0 && g.idle != nil {
+		close(g.idle)
+		g.idle = nil
+	}
+}
+
+// Active returns the amount of in-flight work.
+func (g *Group) Active() int {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	return g.active
+}
+
+// Close stops the group from accepting new work.
+func (g *Group) Close() {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	g.closed = true
+}
+
+// Wait blocks until no work is in flight or ctx is done, returning ctx's
+// error in the latter case.
+func (g *Group) Wait(ctx context.Context) error {
+	g.mu.Lock()
+	if g.active == 0 {
+		g.mu.Unlock()
+		return nil
+	}
+	if g.idle == nil {
+		g.idle = make(chan struct{})
+	}
+	idle := g.idle
+	g.mu.Unlock()
+
+	select {
+	case <-idle:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
@@ -0,0 +1,54 @@
+package drain
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+)
+
+func TestGroupWait(t *testing.T) {
+	var g Group
+	if err := g.Wait(context.Background()); err != nil {
+		t.Fatalf("Wait on idle group: %v", err)
+	}
+
+	if !g.Acquire() || !g.Acquire() {
+		t.Fatal("Acquire should succeed before Close")
+	}
+	g.Close()
+	if g.Acquire() {
+		t.Fatal("Acquire should fail after Close")
+	}
+	if g.Active() != 2 {
+		t.Fatalf("Active = %d, want 2", g.Active())
+	}
+
+	done := make(chan error, 1)
+	go func() { done <- g.Wait(context.Background()) }()
+	g.Release()
+	select {
+	case <-done:
+		t.Fatal("Wait returned with work in flight")
+	case <-time.After(10 * time.Millisecond):
+	}
+	g.Release()
+	select {
+	case err := <-done:
+		if err != nil {
+			t.Fatalf("Wait: %v", err)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("Wait did not return after the last Release")
+	}
+}
+
+func TestGroupWaitTimeout(t *testing.T) {
+	var g Group
+	g.Acquire()
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	if err := g.Wait(ctx); !errors.Is(err, context.DeadlineExceeded) {
+		t.Fatalf("Wait = %v, want deadline exceeded", err)
+	}
+}
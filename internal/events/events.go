@@ -0,0 +1,168 @@
+// Package events keeps the change events served at
+// /_events/stream.ndjson, so tools can react to saved queries, cache
+// refreshes and datasets coming and going without polling the tree.
+//
+// The log is an append-only ndjson stream like a tail stream: readers
+// follow it by its size, reads at its end block until the next event, and
+// only the newest events are kept, older offsets reading back as newlines.
+package events
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"io"
+	"sync"
+	"time"
+)
+
+// DefaultMaxBytes is how much of the log is kept when New is given zero.
+const DefaultMaxBytes = 1 << 20
+
+// Event types.
+const (
+	// QueryChanged is a saved query written or truncated.
+	QueryChanged = "query.changed"
+	// CacheRefreshed is a cached result re-executed by cache warming.
+	CacheRefreshed = "cache.refreshed"
+	// DatasetCreated and DatasetDeleted are found by the metadata poller.
+	DatasetCreated = "dataset.created"
+	DatasetDeleted = "dataset.deleted"
+)
+
+// Event is one line of the log.
+type Event struct {
+	Time time.Time `json:"time"`
+	Type string    `json:"type"`
+	// Seq numbers events from 1 since the mount started.
+	Seq      int64  `json:"seq"`
+	Dataset  string `json:"dataset,omitempty"`
+	Query    string `json:"query,omitempty"`
+	Revision uint64 `json:"revision,omitempty"`
+	APL      string `json:"apl,omitempty"`
+	Format   string `json:"format,omitempty"`
+}
+
+// Log is the event stream. A nil Log drops what is published.
+type Log struct {
+	maxBytes int
+
+	mu      sync.Mutex
+	buf     []byte
+	base    int64 // stream offset of buf[0]
+	seq     int64
+	modTime time.Time
+	closed  bool
+	// changed is closed and replaced whenever the log grows.
+	changed chan struct{}
+}
+
+// New returns a log keeping the newest maxBytes of events.
+func New(maxBytes int) *Log {
+	if maxBytes <= 0 {
+		maxBytes = DefaultMaxBytes
+	}
+	return &Log{maxBytes: maxBytes, modTime: time.Now(), changed: make(chan struct{})}
+}
+
+// Publish appends e, setting its Seq and, when zero, its Time.
+func (l *Log) Publish(e Event) {
+	if l == nil {
+		return
+	}
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if l.closed {
+		return
+	}
+	l.seq++
+	e.Seq = l.seq
+	if e.Time.IsZero() {
+		e.Time = time.Now().UTC()
+	}
+	line, err := json.Marshal(e)
+	if err != nil {
+		return
+	}
+	l.buf = append(l.buf, line...)
+	l.buf = append(l.buf, '\n')
+	if over := len(l.buf) - l.maxBytes; over > 0 {
+		// Drop whole lines, so no event is read back cut.
+		if i := bytes.IndexByte(l.buf[over-1:], '\n'); i >= 0 {
+			over += i
+		}
+		l.buf = append([]byte(nil), l.buf[over:]...)
+		l.base += int64(over)
+	}
+	l.modTime = e.Time
+	close(l.changed)
+	l.changed = make(chan struct{})
+}
+
+// Size is the stream length so far.
+func (l *Log) Size() int64 {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return l.base + int64(len(l.buf))
+}
+
+// ModTime is when the last event was published.
+func (l *Log) ModTime() time.Time {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return l.modTime
+}
+
+// Close wakes blocked readers; later events are dropped.
+func (l *Log) Close() {
+	if l == nil {
+		return
+	}
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if !l.closed {
+		l.closed = true
+		close(l.changed)
+	}
+}
+
+// ReadAt reads the stream at off. At the end of the stream it blocks until
+// the next event, ctx ends or the log is closed; reads into an empty p
+// never block.
+func (l *Log) ReadAt(ctx context.Context, p []byte, off int64) (int, error) {
+	for {
+		l.mu.Lock()
+		size := l.base + int64(len(l.buf))
+		if len(p) == 0 || off < size || l.closed {
+			n := l.copyLocked(p, off)
+			l.mu.Unlock()
+			if off+int64(n) >= size {
+				return n, io.EOF
+			}
+			return n, nil
+		}
+		changed := l.changed
+		l.mu.Unlock()
+
+		select {
+		case <-changed:
+		case <-ctx.Done():
+			return 0, io.EOF
+		}
+	}
+}
+
+// copyLocked copies the stream from off into p. Offsets that fell out of
+// the retained window read as newlines.
+func (l *Log) copyLocked(p []byte, off int64) int {
+	n := 0
+	for off < l.base && n < len(p) {
+		p[n] = '\n'
+		n++
+		off++
+	}
+	if n < len(p) && off >= l.base && off < l.base+int64(len(l.buf)) {
+		n += copy(p[n:], l.buf[off-l.base:])
+	}
+	return n
+}
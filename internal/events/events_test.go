@@ -0,0 +1,82 @@
+package events
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestLogPublish(t *testing.T) {
+	l := New(0)
+	l.Publish(Event{Type: DatasetCreated, Dataset: "logs"})
+	l.Publish(Event{Type: QueryChanged, Query: "errors", Revision: 2})
+
+	buf := make([]byte, 4096)
+	n, err := l.ReadAt(context.Background(), buf, 0)
+	if err != io.EOF || int64(n) != l.Size() {
+		t.Fatalf("ReadAt = %d, %v; size %d", n, err, l.Size())
+	}
+	lines := strings.Split(strings.TrimSuffix(string(buf[:n]), "\n"), "\n")
+	if len(lines) != 2 {
+		t.Fatalf("lines = %q", lines)
+	}
+	var second Event
+	if err := json.Unmarshal([]byte(lines[1]), &second); err != nil {
+		t.Fatal(err)
+	}
+	if second.Seq != 2 || second.Type != QueryChanged || second.Query != "errors" || second.Time.IsZero() {
+		t.Errorf("second event = %+v", second)
+	}
+}
+
+func TestLogReadBlocks(t *testing.T) {
+	l := New(0)
+	done := make(chan string)
+	go func() {
+		buf := make([]byte, 512)
+		n, _ := l.ReadAt(context.Background(), buf, 0)
+		done <- string(buf[:n])
+	}()
+	select {
+	case got := <-done:
+		t.Fatalf("read returned before an event: %q", got)
+	case <-time.After(20 * time.Millisecond):
+	}
+	l.Publish(Event{Type: CacheRefreshed, APL: "['logs']"})
+	if got := <-done; !strings.Contains(got, `"type":"cache.refreshed"`) {
+		t.Errorf("read = %q", got)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	if n, err := l.ReadAt(ctx, make([]byte, 8), l.Size()); n != 0 || err != io.EOF {
+		t.Errorf("read at end = %d, %v", n, err)
+	}
+	l.Close()
+	if n, err := l.ReadAt(context.Background(), make([]byte, 8), l.Size()); n != 0 || err != io.EOF {
+		t.Errorf("read after close = %d, %v", n, err)
+	}
+}
+
+func TestLogRetention(t *testing.T) {
+	l := New(100)
+	for range 5 {
+		l.Publish(Event{Type: DatasetDeleted, Dataset: "logs"})
+	}
+	buf := make([]byte, l.Size())
+	n, _ := l.ReadAt(context.Background(), buf, 0)
+	data := strings.TrimLeft(string(buf[:n]), "\n")
+	if n == len(data) || !strings.HasSuffix(data, `"seq":5,"dataset":"logs"}`+"\n") {
+		t.Fatalf("retained stream = %q", data)
+	}
+	// Only whole events are kept.
+	for _, line := range strings.Split(strings.TrimSuffix(data, "\n"), "\n") {
+		var e Event
+		if err := json.Unmarshal([]byte(line), &e); err != nil {
+			t.Errorf("retained line %q: %v", line, err)
+		}
+	}
+}
@@ -0,0 +1,92 @@
+// Package export uploads query results to object storage, so large exports
+// go from the server to a bucket without passing through the NFS client.
+package export
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net/url"
+	"os"
+	"sort"
+	"strings"
+)
+
+// Sink uploads objects to one kind of storage.
+type Sink interface {
+	// Upload writes size bytes from r to dest, a URI of a scheme the sink
+	// is registered for.
+	Upload(ctx context.Context, dest *url.URL, r io.Reader, size int64) error
+}
+
+// Sinks maps URI schemes, such as s3 and gs, to the sink serving them.
+type Sinks map[string]Sink
+
+// Parse validates dest as an upload destination: a URI with a registered
+// scheme, a bucket and an object key.
+func (s Sinks) Parse(dest string) (*url.URL, error) {
+	u, err := url.Parse(strings.TrimSpace(dest))
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", os.ErrInvalid, err)
+	}
+	if u.Host == "" || strings.Trim(u.Path, "/") == "" {
+		return nil, fmt.Errorf("%w: %q is not <scheme>://<bucket>/<key>", os.ErrInvalid, dest)
+	}
+	if _, ok := s[u.Scheme]; !ok {
+		return nil, fmt.Errorf("%w: no export sink for %q (configured: %s)", os.ErrInvalid, u.Scheme+"://", s.schemes())
+	}
+	return u, nil
+}
+
+// Upload sends r to dest through the sink registered for its scheme.
+func (s Sinks) Upload(ctx context.Context, dest string, r io.Reader, size int64) error {
+	u, err := s.Parse(dest)
+	if err != nil {
+		return err
+	}
+	return s[u.Scheme].Upload(ctx, u, r, size)
+}
+
+func (s Sinks) schemes() string {
+	if len(s) == 0 {
+		return "none"
+	}
+	schemes := make([]string, 0, len(s))
+	for scheme := range s {
+		schemes = append(schemes, scheme+"://")
+	}
+	sort.Strings(schemes)
+	return strings.Join(schemes, ", ")
+}
+
+// FromEnv returns the sinks whose credentials are in the environment:
+//   - s3:// with AWS_ACCESS_KEY_ID and AWS_SECRET_ACCESS_KEY, plus the
+//     optional AWS_SESSION_TOKEN, AWS_REGION and, for S3-compatible stores,
+//     AWS_ENDPOINT_URL_S3
+//   - gs:// with GCS_HMAC_ACCESS_KEY_ID and GCS_HMAC_SECRET, through
+//     Cloud Storage's S3-compatible XML API
+func FromEnv() Sinks {
+	sinks := Sinks{}
+	if key, secret := os.Getenv("AWS_ACCESS_KEY_ID"), os.Getenv("AWS_SECRET_ACCESS_KEY"); key != "" && secret != "" {
+		region := os.Getenv("AWS_REGION")
+		if region == "" {
+			region = os.Getenv("AWS_DEFAULT_REGION")
+		}
+		sinks["s3"] = NewS3(S3Config{
+			Endpoint:     os.Getenv("AWS_ENDPOINT_URL_S3"),
+			Region:       region,
+			AccessKey:    key,
+			SecretKey:    secret,
+			SessionToken: os.Getenv("AWS_SESSION_TOKEN"),
+		})
+	}
+	if key, secret := os.Getenv("GCS_HMAC_ACCESS_KEY_ID"), os.Getenv("GCS_HMAC_SECRET"); key != "" && secret != "" {
+		sinks["gs"] = NewS3(S3Config{
+			Endpoint:  "https://storage.googleapis.com",
+			Region:    "auto",
+			AccessKey: key,
+			SecretKey: secret,
+		})
+	}
+	return sinks
+}
@@ -0,0 +1,184 @@
+package export
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"sort"
+	"strings"
+	"time"
+)
+
+// maxPutSize is the largest object a single PUT may create.
+const maxPutSize = 5 << 30
+
+// unsignedPayload skips hashing the body, so it can be streamed once.
+const unsignedPayload = "UNSIGNED-PAYLOAD"
+
+// S3Config configures an S3Sink.
+type S3Config struct {
+	// Endpoint is the base URL of an S3-compatible store, addressed
+	// path-style. Empty uses AWS with virtual-hosted buckets.
+	Endpoint string
+	// Region signs requests; empty is us-east-1.
+	Region       string
+	AccessKey    string
+	SecretKey    string
+	SessionToken string
+	// HTTPClient defaults to http.DefaultClient.
+	HTTPClient *http.Client
+}
+
+// S3Sink uploads with single SigV4-signed PUTs, which works for AWS S3 and
+// S3-compatible stores including Cloud Storage.
+type S3Sink struct {
+	cfg S3Config
+}
+
+// NewS3 returns a sink for cfg.
+func NewS3(cfg S3Config) *S3Sink {
+	if cfg.Region == "" {
+		cfg.Region = "us-east-1"
+	}
+	if cfg.HTTPClient == nil {
+		cfg.HTTPClient = http.DefaultClient
+	}
+	return &S3Sink{cfg: cfg}
+}
+
+// Upload PUTs r to the bucket and key of dest.
+func (s *S3Sink) Upload(ctx context.Context, dest *url.URL, r io.Reader, size int64) error {
+	if size > maxPutSize {
+		return fmt.Errorf("export of %d bytes exceeds the %d byte single upload limit", size, int64(maxPutSize))
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPut, s.objectURL(dest.Host, strings.TrimPrefix(dest.Path, "/")), io.NopCloser(r))
+	if err != nil {
+		return err
+	}
+	req.ContentLength = size
+	if s.cfg.SessionToken != "" {
+		req.Header.Set("X-Amz-Security-Token", s.cfg.SessionToken)
+	}
+	signV4(req, s.cfg.AccessKey, s.cfg.SecretKey, s.cfg.Region, "s3", unsignedPayload, time.Now())
+	resp, err := s.cfg.HTTPClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode/100 != 2 {
+		body, _ := io.ReadAll(io.LimitReader(resp.Body, 1024))
+		return fmt.Errorf("upload to %s: %s: %s", dest, resp.Status, strings.TrimSpace(string(body)))
+	}
+	return nil
+}
+
+func (s *S3Sink) objectURL(bucket, key string) string {
+	if s.cfg.Endpoint == "" {
+		return fmt.Sprintf("https://%s.s3.%s.amazonaws.com/%s", bucket, s.cfg.Region, escapePath(key))
+	}
+	return strings.TrimSuffix(s.cfg.Endpoint, "/") + "/" + bucket + "/" + escapePath(key)
+}
+
+// escapePath escapes each segment of an object key as SigV4 expects.
+func escapePath(key string) string {
+	segments := strings.Split(key, "/")
+	for i, segment := range segments {
+		segments[i] = uriEncode(segment)
+	}
+	return strings.Join(segments, "/")
+}
+
+// uriEncode percent-encodes every byte but the unreserved characters, the
+// encoding SigV4 canonicalizes with.
+func uriEncode(s string) string {
+	var b strings.Builder
+	for i := 0; i < len(s); i++ {
+		c := s[i]
+		if 'A' <= c && c <= 'Z' || 'a' <= c && c <= 'z' || '0' <= c && c <= '9' || c == '-' || c == '_' || c == '.' || c == '~' {
+			b.WriteByte(c)
+		} else {
+			fmt.Fprintf(&b, "%%%02X", c)
+		}
+	}
+	return b.String()
+}
+
+// signV4 signs req with AWS Signature Version 4, covering the host and
+// every header already set.
+func signV4(req *http.Request, accessKey, secretKey, region, service, payloadHash string, now time.Time) {
+	now = now.UTC()
+	amzDate := now.Format("20060102T150405Z")
+	day := now.Format("20060102")
+	req.Header.Set("X-Amz-Date", amzDate)
+	req.Header.Set("X-Amz-Content-Sha256", payloadHash)
+
+	headers := map[string]string{"host": req.URL.Host}
+	for name, values := range req.Header {
+		headers[strings.ToLower(name)] = strings.TrimSpace(strings.Join(values, ","))
+	}
+	names := make([]string, 0, len(headers))
+	for name := range headers {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	var canonicalHeaders strings.Builder
+	for _, name := range names {
+		canonicalHeaders.WriteString(name + ":" + headers[name] + "\n")
+	}
+	signedHeaders := strings.Join(names, ";")
+
+	path := req.URL.EscapedPath()
+	if path == "" {
+		path = "/"
+	}
+	canonicalRequest := strings.Join([]string{
+		req.Method,
+		path,
+		canonicalQuery(req.URL.Query()),
+		canonicalHeaders.String(),
+		signedHeaders,
+		payloadHash,
+	}, "\n")
+	scope := day + "/" + region + "/" + service + "/aws4_request"
+	stringToSign := "AWS4-HMAC-SHA256\n" + amzDate + "\n" + scope + "\n" + hexSHA256(canonicalRequest)
+
+	key := hmacSHA256([]byte("AWS4"+secretKey), day)
+	for _, part := range []string{region, service, "aws4_request"} {
+		key = hmacSHA256(key, part)
+	}
+	signature := hex.EncodeToString(hmacSHA256(key, stringToSign))
+	req.Header.Set("Authorization", fmt.Sprintf("AWS4-HMAC-SHA256 Credential=%s/%s, SignedHeaders=%s, Signature=%s", accessKey, scope, signedHeaders, signature))
+}
+
+func canonicalQuery(values url.Values) string {
+	keys := make([]string, 0, len(values))
+	for key := range values {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	var parts []string
+	for _, key := range keys {
+		vals := append([]string(nil), values[key]...)
+		sort.Strings(vals)
+		for _, v := range vals {
+			parts = append(parts, uriEncode(key)+"="+uriEncode(v))
+		}
+	}
+	return strings.Join(parts, "&")
+}
+
+func hexSHA256(s string) string {
+	sum := sha256.Sum256([]byte(s))
+	return hex.EncodeToString(sum[:])
+}
+
+func hmacSHA256(key []byte, data string) []byte {
+	mac := hmac.New(sha256.New, key)
+	mac.Write([]byte(data))
+	return mac.Sum(nil)
+}
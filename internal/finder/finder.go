@@ -0,0 +1,203 @@
+// Package finder recognizes the metadata files macOS Finder and Spotlight
+// look up in every directory they visit (.DS_Store, ._* AppleDouble files,
+// .Spotlight-V100 and the like), so the NFS layer can answer them without
+// walking the tree, and counts what it suppressed for
+// /_status/finder.json. It can also hold the .DS_Store files Finder writes
+// in memory, so window settings stick for the life of the server.
+package finder
+
+import (
+	"errors"
+	"slices"
+	"strings"
+	"sync"
+	"sync/atomic"
+)
+
+// Kinds of suppressed names.
+const (
+	KindDSStore     = "ds_store"
+	KindAppleDouble = "appledouble"
+	KindSpotlight   = "spotlight"
+	KindOther       = "other"
+)
+
+// DSStore is the name of Finder's per-directory view settings file.
+const DSStore = ".DS_Store"
+
+const (
+	// maxDSStores and maxDSStoreBytes bound the .DS_Store files held in
+	// memory: how many, and the size of each.
+	maxDSStores     = 10000
+	maxDSStoreBytes = 1 << 20
+)
+
+// ErrFull is returned by writes past the limits of the .DS_Store files
+// held in memory.
+var ErrFull = errors.New("finder: in-memory .DS_Store limit reached")
+
+// names are the macOS metadata names suppressed by their full name.
+var names = map[string]string{
+	DSStore:                               KindDSStore,
+	".Spotlight-V100":                     KindSpotlight,
+	".metadata_never_index":               KindSpotlight,
+	".metadata_never_index_unless_rootfs": KindSpotlight,
+	".metadata_direct_scope_only":         KindSpotlight,
+	".Trashes":                            KindOther,
+	".fseventsd":                          KindOther,
+	".TemporaryItems":                     KindOther,
+	".DocumentRevisions-V100":             KindOther,
+	".VolumeIcon.icns":                    KindOther,
+	".com.apple.timemachine.donotpresent": KindOther,
+	".com.apple.timemachine.supported":    KindOther,
+	".apdisk":                             KindOther,
+	".localized":                          KindOther,
+	".ql_disablethumbnails":               KindOther,
+	".ql_disablecache":                    KindOther,
+	"Icon\r":                              KindOther,
+	"Backups.backupdb":                    KindOther,
+}
+
+// Filter recognizes Finder metadata names. A nil or disabled Filter
+// recognizes none.
+type Filter struct {
+	enabled     bool
+	fakeDSStore bool
+
+	suppressed sync.Map // map[string]*atomic.Int64, by kind
+	writes     atomic.Int64
+
+	mu       sync.Mutex
+	dsStores map[string][]byte
+	bytes    int64
+}
+
+// New returns a Filter suppressing Finder metadata names when enabled, and
+// holding written .DS_Store files in memory when fakeDSStore is set too.
+func New(enabled, fakeDSStore bool) *Filter {
+	return &Filter{
+		enabled:     enabled,
+		fakeDSStore: enabled && fakeDSStore,
+		dsStores:    make(map[string][]byte),
+	}
+}
+
+// Junk returns the kind of name if it is Finder metadata.
+func (f *Filter) Junk(name string) (string, bool) {
+	if f == nil || !f.enabled {
+		return "", false
+	}
+	if kind, ok := names[name]; ok {
+		return kind, true
+	}
+	if strings.HasPrefix(name, "._") {
+		return KindAppleDouble, true
+	}
+	return "", false
+}
+
+// Suppress counts an operation on a name of kind answered without the tree.
+func (f *Filter) Suppress(kind string) {
+	v, _ := f.suppressed.LoadOrStore(kind, new(atomic.Int64))
+	v.(*atomic.Int64).Add(1)
+}
+
+// FakesDSStore reports whether .DS_Store files are held in memory.
+func (f *Filter) FakesDSStore() bool {
+	return f != nil && f.fakeDSStore
+}
+
+// ReadDSStore returns the .DS_Store held at p, an absolute path on the
+// mount.
+func (f *Filter) ReadDSStore(p string) ([]byte, bool) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	data, ok := f.dsStores[p]
+	return slices.Clone(data), ok
+}
+
+// WriteDSStore writes data at off in the .DS_Store held at p, creating it.
+func (f *Filter) WriteDSStore(p string, off int64, data []byte) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	old, ok := f.dsStores[p]
+	end := off + int64(len(data))
+	if !ok && len(f.dsStores) >= maxDSStores || end > maxDSStoreBytes {
+		return ErrFull
+	}
+	buf := old
+	if end > int64(len(buf)) {
+		buf = make([]byte, end)
+		copy(buf, old)
+	}
+	copy(buf[off:], data)
+	f.dsStores[p] = buf
+	f.bytes += int64(len(buf) - len(old))
+	f.writes.Add(1)
+	return nil
+}
+
+// TruncateDSStore sets the size of the .DS_Store held at p, creating it.
+func (f *Filter) TruncateDSStore(p string, size int64) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	old, ok := f.dsStores[p]
+	if !ok && len(f.dsStores) >= maxDSStores || size > maxDSStoreBytes {
+		return ErrFull
+	}
+	buf := make([]byte, size)
+	copy(buf, old)
+	f.dsStores[p] = buf
+	f.bytes += int64(len(buf) - len(old))
+	return nil
+}
+
+// RemoveDSStore drops the .DS_Store held at p, reporting whether there was
+// one.
+func (f *Filter) RemoveDSStore(p string) bool {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	old, ok := f.dsStores[p]
+	delete(f.dsStores, p)
+	f.bytes -= int64(len(old))
+	return ok
+}
+
+// Stats is the state /_status/finder.json reports.
+type Stats struct {
+	Enabled     bool `json:"enabled"`
+	FakeDSStore bool `json:"fake_ds_store"`
+	// Suppressed counts the operations answered without the tree, in
+	// total and by kind of name.
+	Suppressed       int64            `json:"suppressed"`
+	SuppressedByKind map[string]int64 `json:"suppressed_by_kind"`
+	// DSStores and DSStoreBytes are the .DS_Store files held in memory;
+	// DSStoreWrites counts the writes to them.
+	DSStores      int   `json:"ds_stores"`
+	DSStoreBytes  int64 `json:"ds_store_bytes"`
+	DSStoreWrites int64 `json:"ds_store_writes"`
+}
+
+// Stats returns the Filter's counters.
+func (f *Filter) Stats() Stats {
+	if f == nil {
+		return Stats{SuppressedByKind: map[string]int64{}}
+	}
+	stats := Stats{
+		Enabled:          f.enabled,
+		FakeDSStore:      f.fakeDSStore,
+		SuppressedByKind: make(map[string]int64),
+		DSStoreWrites:    f.writes.Load(),
+	}
+	f.suppressed.Range(func(k, v any) bool {
+		n := v.(*atomic.Int64).Load()
+		stats.SuppressedByKind[k.(string)] = n
+		stats.Suppressed += n
+		return true
+	})
+	f.mu.Lock()
+	stats.DSStores = len(f.dsStores)
+	stats.DSStoreBytes = f.bytes
+	f.mu.Unlock()
+	return stats
+}
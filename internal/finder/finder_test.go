@@ -0,0 +1,70 @@
+package finder
+
+import (
+	"errors"
+	"testing"
+)
+
+func TestJunk(t *testing.T) {
+	f := New(true, false)
+	for name, want := range map[string]string{
+		".DS_Store":             KindDSStore,
+		"._result.csv":          KindAppleDouble,
+		".Spotlight-V100":       KindSpotlight,
+		".metadata_never_index": KindSpotlight,
+		".Trashes":              KindOther,
+		"result.csv":            "",
+		".hidden":               "",
+		".stale":                "",
+	} {
+		if kind, ok := f.Junk(name); kind != want || ok != (want != "") {
+			t.Errorf("Junk(%q) = %q, %v; want %q", name, kind, ok, want)
+		}
+	}
+	if _, ok := New(false, true).Junk(".DS_Store"); ok {
+		t.Error("disabled Filter recognizes .DS_Store")
+	}
+	if New(false, true).FakesDSStore() {
+		t.Error("disabled Filter fakes .DS_Store")
+	}
+	var none *Filter
+	if _, ok := none.Junk(".DS_Store"); ok || none.FakesDSStore() {
+		t.Error("nil Filter recognizes names")
+	}
+}
+
+func TestDSStore(t *testing.T) {
+	f := New(true, true)
+	if _, ok := f.ReadDSStore("/logs/.DS_Store"); ok {
+		t.Fatal("unwritten .DS_Store exists")
+	}
+	if err := f.WriteDSStore("/logs/.DS_Store", 2, []byte("cd")); err != nil {
+		t.Fatal(err)
+	}
+	if err := f.WriteDSStore("/logs/.DS_Store", 0, []byte("ab")); err != nil {
+		t.Fatal(err)
+	}
+	if data, ok := f.ReadDSStore("/logs/.DS_Store"); !ok || string(data) != "abcd" {
+		t.Errorf("ReadDSStore = %q, %v; want abcd", data, ok)
+	}
+	if err := f.TruncateDSStore("/logs/.DS_Store", 1); err != nil {
+		t.Fatal(err)
+	}
+	if err := f.WriteDSStore("/logs/.DS_Store", 0, make([]byte, maxDSStoreBytes+1)); !errors.Is(err, ErrFull) {
+		t.Errorf("oversized write = %v, want ErrFull", err)
+	}
+
+	f.Suppress(KindDSStore)
+	f.Suppress(KindDSStore)
+	f.Suppress(KindAppleDouble)
+	stats := f.Stats()
+	if stats.Suppressed != 3 || stats.SuppressedByKind[KindDSStore] != 2 || stats.DSStores != 1 || stats.DSStoreBytes != 1 || stats.DSStoreWrites != 2 {
+		t.Errorf("Stats = %+v", stats)
+	}
+	if !f.RemoveDSStore("/logs/.DS_Store") || f.RemoveDSStore("/logs/.DS_Store") {
+		t.Error("RemoveDSStore does not report what it removed")
+	}
+	if stats := f.Stats(); stats.DSStores != 0 || stats.DSStoreBytes != 0 {
+		t.Errorf("Stats after remove = %+v", stats)
+	}
+}
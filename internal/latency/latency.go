@@ -0,0 +1,191 @@
+// Package latency times file system operations and queries: a histogram
+// per operation for /_status/latency.json, and the recent ones slower than
+// a threshold for /_status/slow.ndjson.
+package latency
+
+import (
+	"sync"
+	"time"
+)
+
+// maxSlow is how many slow entries are kept; older ones are dropped.
+const maxSlow = 1000
+
+// Operations timed by the NFS layer and the executor.
+const (
+	OpResolve = "resolve"
+	OpStat    = "stat"
+	OpOpen    = "open"
+	OpRead    = "read"
+	OpReadDir = "readdir"
+	OpQuery   = "query"
+)
+
+// bounds are the histogram buckets' upper bounds; a last bucket counts
+// the rest.
+var bounds = []time.Duration{
+	time.Millisecond,
+	10 * time.Millisecond,
+	100 * time.Millisecond,
+	time.Second,
+	10 * time.Second,
+	time.Minute,
+}
+
+// Entry is one timed operation.
+type Entry struct {
+	Time time.Time `json:"time"`
+	Op   string    `json:"op"`
+	// Path is the file operated on, or the file a query was run for.
+	Path    string  `json:"path,omitempty"`
+	APL     string  `json:"apl,omitempty"`
+	Seconds float64 `json:"seconds"`
+	// AxiomSeconds and EncodeSeconds split a query's time between waiting
+	// on Axiom and encoding the result.
+	AxiomSeconds  float64 `json:"axiom_seconds,omitempty"`
+	EncodeSeconds float64 `json:"encode_seconds,omitempty"`
+	// Bytes is what a read returned.
+	Bytes int64  `json:"bytes,omitempty"`
+	Error string `json:"error,omitempty"`
+}
+
+// Bucket counts the operations that took at most LESeconds; the last
+// bucket of a histogram has no bound.
+type Bucket struct {
+	LESeconds float64 `json:"le_seconds,omitempty"`
+	Count     int64   `json:"count"`
+}
+
+// Histogram is the latency distribution of one operation.
+type Histogram struct {
+	Op           string   `json:"op"`
+	Count        int64    `json:"count"`
+	TotalSeconds float64  `json:"total_seconds"`
+	MaxSeconds   float64  `json:"max_seconds"`
+	Buckets      []Bucket `json:"buckets"`
+}
+
+// Stats is /_status/latency.json.
+type Stats struct {
+	// SlowThresholdSeconds is the time above which operations are logged
+	// to slow.ndjson; zero disables the log.
+	SlowThresholdSeconds float64     `json:"slow_threshold_seconds"`
+	Ops                  []Histogram `json:"ops"`
+}
+
+type histogram struct {
+	count   int64
+	total   time.Duration
+	max     time.Duration
+	buckets []int64
+}
+
+// Recorder keeps the histograms and the slow log. A nil Recorder records
+// nothing.
+type Recorder struct {
+	threshold time.Duration
+
+	mu    sync.Mutex
+	ops   map[string]*histogram
+	slow  []Entry
+	first int
+}
+
+// New returns a recorder logging operations slower than threshold; zero
+// only keeps the histograms.
+func New(threshold time.Duration) *Recorder {
+	return &Recorder{threshold: threshold, ops: map[string]*histogram{}}
+}
+
+// Observe records e, which took elapsed, setting its Seconds and, when it
+// is slow, its Time.
+func (r *Recorder) Observe(e Entry, elapsed time.Duration) {
+	if r == nil {
+		return
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	h, ok := r.ops[e.Op]
+	if !ok {
+		h = &histogram{buckets: make([]int64, len(bounds)+1)}
+		r.ops[e.Op] = h
+	}
+	h.count++
+	h.total += elapsed
+	h.max = max(h.max, elapsed)
+	i := 0
+	for i < len(bounds) && elapsed > bounds[i] {
+		i++
+	}
+	h.buckets[i]++
+
+	if r.threshold <= 0 || elapsed < r.threshold {
+		return
+	}
+	e.Seconds = elapsed.Seconds()
+	if e.Time.IsZero() {
+		e.Time = time.Now().UTC()
+	}
+	if len(r.slow) < maxSlow {
+		r.slow = append(r.slow, e)
+		return
+	}
+	r.slow[r.first] = e
+	r.first = (r.first + 1) % maxSlow
+}
+
+// Since is Observe for an operation that started at start.
+func (r *Recorder) Since(e Entry, start time.Time, err error) {
+	if r == nil {
+		return
+	}
+	if err != nil {
+		e.Error = err.Error()
+	}
+	e.Time = start.UTC()
+	r.Observe(e, time.Since(start))
+}
+
+// Slow returns the logged slow operations, oldest first.
+func (r *Recorder) Slow() []Entry {
+	if r == nil {
+		return nil
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	out := make([]Entry, 0, len(r.slow))
+	out = append(out, r.slow[r.first:]...)
+	return append(out, r.slow[:r.first]...)
+}
+
+// Stats returns the histogram of every operation seen, in the order of
+// the Op constants.
+func (r *Recorder) Stats() Stats {
+	if r == nil {
+		return Stats{Ops: []Histogram{}}
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	stats := Stats{SlowThresholdSeconds: r.threshold.Seconds(), Ops: []Histogram{}}
+	for _, op := range []string{OpResolve, OpStat, OpOpen, OpRead, OpReadDir, OpQuery} {
+		h, ok := r.ops[op]
+		if !ok {
+			continue
+		}
+		out := Histogram{
+			Op:           op,
+			Count:        h.count,
+			TotalSeconds: h.total.Seconds(),
+			MaxSeconds:   h.max.Seconds(),
+			Buckets:      make([]Bucket, len(h.buckets)),
+		}
+		for i, n := range h.buckets {
+			out.Buckets[i].Count = n
+			if i < len(bounds) {
+				out.Buckets[i].LESeconds = bounds[i].Seconds()
+			}
+		}
+		stats.Ops = append(stats.Ops, out)
+	}
+	return stats
+}
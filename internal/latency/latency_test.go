@@ -0,0 +1,68 @@
+package latency
+
+import (
+	"errors"
+	"testing"
+	"time"
+)
+
+func TestRecorderHistogram(t *testing.T) {
+	r := New(0)
+	r.Observe(Entry{Op: OpStat}, 500*time.Microsecond)
+	r.Observe(Entry{Op: OpStat}, 50*time.Millisecond)
+	r.Observe(Entry{Op: OpStat}, 2*time.Minute)
+	r.Observe(Entry{Op: OpOpen}, time.Second)
+
+	stats := r.Stats()
+	if len(stats.Ops) != 2 || stats.Ops[0].Op != OpStat || stats.Ops[1].Op != OpOpen {
+		t.Fatalf("ops = %+v", stats.Ops)
+	}
+	stat := stats.Ops[0]
+	if stat.Count != 3 || stat.MaxSeconds != 120 {
+		t.Errorf("stat = %+v", stat)
+	}
+	want := []int64{1, 0, 1, 0, 0, 0, 1}
+	for i, n := range want {
+		if stat.Buckets[i].Count != n {
+			t.Errorf("bucket %d = %+v, want count %d", i, stat.Buckets[i], n)
+		}
+	}
+	if last := stat.Buckets[len(stat.Buckets)-1]; last.LESeconds != 0 {
+		t.Errorf("last bucket is bounded: %+v", last)
+	}
+	// A duration on a bound falls in that bound's bucket.
+	if open := stats.Ops[1]; open.Buckets[3].Count != 1 {
+		t.Errorf("open = %+v", open)
+	}
+	if slow := r.Slow(); len(slow) != 0 {
+		t.Errorf("zero threshold logged %v", slow)
+	}
+}
+
+func TestRecorderSlowLog(t *testing.T) {
+	r := New(time.Second)
+	r.Observe(Entry{Op: OpRead, Path: "/fast"}, time.Millisecond)
+	r.Since(Entry{Op: OpQuery, APL: "logs"}, time.Now().Add(-2*time.Second), errors.New("boom"))
+
+	slow := r.Slow()
+	if len(slow) != 1 {
+		t.Fatalf("slow = %+v", slow)
+	}
+	if e := slow[0]; e.Op != OpQuery || e.Seconds < 2 || e.Error != "boom" || e.Time.IsZero() {
+		t.Errorf("entry = %+v", e)
+	}
+
+	for i := range maxSlow + 5 {
+		r.Observe(Entry{Op: OpOpen, Bytes: int64(i)}, time.Second)
+	}
+	slow = r.Slow()
+	if len(slow) != maxSlow || slow[0].Bytes != 5 || slow[len(slow)-1].Bytes != maxSlow+4 {
+		t.Errorf("ring kept %d entries from %d to %d", len(slow), slow[0].Bytes, slow[len(slow)-1].Bytes)
+	}
+
+	var nilRecorder *Recorder
+	nilRecorder.Observe(Entry{Op: OpStat}, time.Hour)
+	if len(nilRecorder.Slow()) != 0 || len(nilRecorder.Stats().Ops) != 0 {
+		t.Error("nil recorder recorded")
+	}
+}
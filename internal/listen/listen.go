@@ -0,0 +1,109 @@
+// Package listen parses the -listen flag and opens the listeners it names.
+//
+// A spec is a comma-separated list of addresses. Each address is either a
+// TCP host:port (IPv4 or bracketed IPv6) or a unix:// URL naming a socket
+// path, e.g. "127.0.0.1:2049,[::1]:2049,unix:///run/axiom-fs.sock".
+package listen
+
+import (
+	"errors"
+	"fmt"
+	"io/fs"
+	"net"
+	"os"
+	"strings"
+)
+
+const unixScheme = "unix://"
+
+// Address is one listen address.
+type Address struct {
+	// Network is "tcp" or "unix".
+	Network string
+	// Addr is host:port for tcp and the socket path for unix.
+	Addr string
+}
+
+func (a Address) String() string {
+	if a.Network == "unix" {
+		return unixScheme + a.Addr
+	}
+	return a.Addr
+}
+
+// Parse splits spec into addresses. Duplicates and empty entries are errors.
+func Parse(spec string) ([]Address, error) {
+	var addrs []Address
+	seen := make(map[Address]bool)
+	for _, part := range strings.Split(spec, ",") {
+		part = strings.TrimSpace(part)
+		if part == "" {
+			return nil, fmt.Errorf("listen: empty address in %q", spec)
+		}
+		addr, err := parseAddress(part)
+		if err != nil {
+			return nil, err
+		}
+		if seen[addr] {
+			return nil, fmt.Errorf("listen: duplicate address %s", addr)
+		}
+		seen[addr] = true
+		addrs = append(addrs, addr)
+	}
+	return addrs, nil
+}
+
+func parseAddress(s string) (Address, error) {
+	if path, ok := strings.CutPrefix(s, unixScheme); ok {
+		if path == "" {
+			return Address{}, fmt.Errorf("listen: unix address %q has no path", s)
+		}
+		return Address{Network: "unix", Addr: path}, nil
+	}
+	if _, _, err := net.SplitHostPort(s); err != nil {
+		return Address{}, fmt.Errorf("listen: %w", err)
+	}
+	return Address{Network: "tcp", Addr: s}, nil
+}
+
+// Open listens on every address. A stale unix socket left by an earlier run
+// is removed first; other files at the socket path are left alone. If any
+// address fails, the listeners already opened are closed.
+func Open(addrs []Address) ([]net.Listener, error) {
+	listeners := make([]net.Listener, 0, len(addrs))
+	for _, addr := range addrs {
+		if addr.Network == "unix" {
+			if err := removeStaleSocket(addr.Addr); err != nil {
+				closeAll(listeners)
+				return nil, err
+			}
+		}
+		l, err := net.Listen(addr.Network, addr.Addr)
+		if err != nil {
+			closeAll(listeners)
+			return nil, err
+		}
+		listeners = append(listeners, l)
+	}
+	return listeners, nil
+}
+
+func removeStaleSocket(path string) error {
+	info, err := os.Lstat(path)
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	if info.Mode()&fs.ModeSocket == 0 {
+		return fmt.Errorf("listen: %s exists and is not a socket", path)
+	}
+	return os.Remove(path)
+}
+
+func closeAll(listeners []net.Listener) {
+	for _, l := range listeners {
+		_ = l.Close()
+	}
+}
@@ -0,0 +1,65 @@
+package listen
+
+import (
+	"net"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestParse(t *testing.T) {
+	addrs, err := Parse("127.0.0.1:2049, [::1]:2049,unix:///run/axiom-fs.sock")
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []Address{
+		{Network: "tcp", Addr: "127.0.0.1:2049"},
+		{Network: "tcp", Addr: "[::1]:2049"},
+		{Network: "unix", Addr: "/run/axiom-fs.sock"},
+	}
+	if len(addrs) != len(want) {
+		t.Fatalf("addrs = %v, want %v", addrs, want)
+	}
+	for i := range want {
+		if addrs[i] != want[i] {
+			t.Errorf("addrs[%d] = %v, want %v", i, addrs[i], want[i])
+		}
+	}
+	if got := addrs[2].String(); got != "unix:///run/axiom-fs.sock" {
+		t.Errorf("String() = %q", got)
+	}
+
+	for _, spec := range []string{"", "127.0.0.1", "a:1,,b:2", "unix://", "a:1,a:1"} {
+		if _, err := Parse(spec); err == nil {
+			t.Errorf("Parse(%q) should fail", spec)
+		}
+	}
+}
+
+func TestOpen(t *testing.T) {
+	sock := filepath.Join(t.TempDir(), "fs.sock")
+	// A socket left behind by a previous run is replaced.
+	stale, err := net.Listen("unix", sock)
+	if err != nil {
+		t.Skipf("unix sockets unavailable: %v", err)
+	}
+	stale.(*net.UnixListener).SetUnlinkOnClose(false)
+	_ = stale.Close()
+
+	listeners, err := Open([]Address{{Network: "tcp", Addr: "127.0.0.1:0"}, {Network: "unix", Addr: sock}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer closeAll(listeners)
+	if len(listeners) != 2 || listeners[1].Addr().Network() != "unix" {
+		t.Fatalf("listeners = %v", listeners)
+	}
+
+	plain := filepath.Join(t.TempDir(), "file")
+	if err := os.WriteFile(plain, nil, 0o644); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := Open([]Address{{Network: "unix", Addr: plain}}); err == nil {
+		t.Error("Open should refuse to replace a regular file")
+	}
+}
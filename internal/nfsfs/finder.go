@@ -0,0 +1,150 @@
+package nfsfs
+
+import (
+	"context"
+	"errors"
+	"io"
+	"os"
+	"path"
+	"strings"
+	"syscall"
+
+	"github.com/go-git/go-billy/v5"
+
+	"github.com/axiomhq/axiom-fs/internal/finder"
+	"github.com/axiomhq/axiom-fs/internal/vfs"
+)
+
+// finderJunk answers full, an absolute path on the mount, without walking
+// the tree when one of its names is macOS Finder metadata: ENOENT, or the
+// in-memory .DS_Store with -fake-ds-store. ok is false for other paths.
+func (f *FS) finderJunk(full string) (node vfs.Node, ok bool, err error) {
+	filter := f.root.Finder()
+	segments := strings.Split(strings.TrimPrefix(full, "/"), "/")
+	for i, seg := range segments {
+		kind, junk := filter.Junk(seg)
+		if !junk {
+			continue
+		}
+		filter.Suppress(kind)
+		if i == len(segments)-1 && seg == finder.DSStore && filter.FakesDSStore() {
+			return &dsStoreNode{fs: f, path: full}, true, nil
+		}
+		return nil, true, syscall.ENOENT
+	}
+	return nil, false, nil
+}
+
+// isDSStore reports whether filename is a .DS_Store held in memory.
+func (f *FS) isDSStore(filename string) bool {
+	return f.root.Finder().FakesDSStore() && path.Base(filename) == finder.DSStore
+}
+
+// dsStoreNode is a .DS_Store held in memory; it exists once written.
+type dsStoreNode struct {
+	fs   *FS
+	path string
+}
+
+func (d *dsStoreNode) Stat(ctx context.Context) (os.FileInfo, error) {
+	data, ok := d.fs.root.Finder().ReadDSStore(d.path)
+	if !ok {
+		return nil, os.ErrNotExist
+	}
+	return vfs.WritableFileInfo(finder.DSStore, int64(len(data))), nil
+}
+
+func (d *dsStoreNode) Open(ctx context.Context, flags int) (billy.File, error) {
+	return d.open(flags)
+}
+
+// open opens the file, creating it with O_CREATE in an existing directory.
+// Writes go to it in place, since each NFS write opens the file again.
+func (d *dsStoreNode) open(flags int) (billy.File, error) {
+	filter := d.fs.root.Finder()
+	_, exists := filter.ReadDSStore(d.path)
+	if !exists && flags&os.O_CREATE == 0 {
+		return nil, syscall.ENOENT
+	}
+	if !exists {
+		parent, err := d.fs.resolve(path.Dir(d.path))
+		if err != nil {
+			return nil, err
+		}
+		if _, ok := parent.(vfs.Dir); !ok {
+			return nil, syscall.ENOTDIR
+		}
+	}
+	if !exists || flags&os.O_TRUNC != 0 {
+		if err := filter.TruncateDSStore(d.path, 0); err != nil {
+			return nil, dsStoreErrno(err)
+		}
+	}
+	return &dsStoreFile{filter: filter, path: d.path}, nil
+}
+
+// dsStoreFile reads and writes a .DS_Store held in memory.
+type dsStoreFile struct {
+	filter *finder.Filter
+	path   string
+	offset int64
+}
+
+func (d *dsStoreFile) Name() string { return finder.DSStore }
+
+func (d *dsStoreFile) Read(p []byte) (int, error) {
+	n, err := d.ReadAt(p, d.offset)
+	d.offset += int64(n)
+	return n, err
+}
+
+func (d *dsStoreFile) ReadAt(p []byte, off int64) (int, error) {
+	data, _ := d.filter.ReadDSStore(d.path)
+	if off >= int64(len(data)) {
+		return 0, io.EOF
+	}
+	n := copy(p, data[off:])
+	if n < len(p) {
+		return n, io.EOF
+	}
+	return n, nil
+}
+
+func (d *dsStoreFile) Seek(offset int64, whence int) (int64, error) {
+	switch whence {
+	case io.SeekCurrent:
+		offset += d.offset
+	case io.SeekEnd:
+		data, _ := d.filter.ReadDSStore(d.path)
+		offset += int64(len(data))
+	}
+	if offset < 0 {
+		return 0, syscall.EINVAL
+	}
+	d.offset = offset
+	return offset, nil
+}
+
+func (d *dsStoreFile) Write(p []byte) (int, error) {
+	if err := d.filter.WriteDSStore(d.path, d.offset, p); err != nil {
+		return 0, dsStoreErrno(err)
+	}
+	d.offset += int64(len(p))
+	return len(p), nil
+}
+
+func (d *dsStoreFile) Truncate(size int64) error {
+	return dsStoreErrno(d.filter.TruncateDSStore(d.path, size))
+}
+
+func (d *dsStoreFile) Close() error  { return nil }
+func (d *dsStoreFile) Lock() error   { return nil }
+func (d *dsStoreFile) Unlock() error { return nil }
+
+// dsStoreErrno reports a full in-memory .DS_Store store as ENOSPC.
+func dsStoreErrno(err error) error {
+	if errors.Is(err, finder.ErrFull) {
+		return syscall.ENOSPC
+	}
+	return err
+}
@@ -3,8 +3,11 @@ package nfsfs
 import (
 	"context"
 	"io/fs"
+	"log/slog"
 	"os"
 	"path"
+	"slices"
+	"sort"
 	"strings"
 	"sync"
 	"syscall"
@@ -12,41 +15,104 @@ import (
 
 	"github.com/go-git/go-billy/v5"
 
+	"github.com/axiomhq/axiom-fs/internal/drain"
+	"github.com/axiomhq/axiom-fs/internal/latency"
+	"github.com/axiomhq/axiom-fs/internal/query"
 	"github.com/axiomhq/axiom-fs/internal/vfs"
 )
 
 type FS struct {
-	root      *vfs.Root
-	rootPath  string
-	sizeCache sync.Map // map[string]int64 - caches actual file sizes after Open
+	root     *vfs.Root
+	rootPath string
+	// client is the NFS client the file system serves; see ForClient.
+	client string
+	*state
+}
+
+// state is shared by the views ForClient returns.
+type state struct {
+	sizeCache sync.Map // map[string]openedAttrs - caches actual file attrs after Open
+	handles   drain.Group
+	listings  listingCache
+}
+
+// openedAttrs are the attributes observed on the last Open of a file.
+// A zero modTime means the node did not report one.
+type openedAttrs struct {
+	size    int64
+	modTime time.Time
 }
 
 type sizedFileInfo struct {
 	os.FileInfo
-	size int64
+	size    int64
+	modTime time.Time
 }
 
 func (s *sizedFileInfo) Size() int64 { return s.size }
 
-func (f *FS) cacheFileSize(filename string, size int64) {
-	f.sizeCache.Store(path.Clean(filename), size)
+func (s *sizedFileInfo) ModTime() time.Time {
+	if s.modTime.IsZero() {
+		return s.FileInfo.ModTime()
+	}
+	return s.modTime
 }
 
-func (f *FS) getCachedSize(filename string) (int64, bool) {
+// errno translates errors from the virtual tree into the errno the NFS
+// layer reports. The full message is logged since the errno drops it; it is
+// also readable from the failing file's `.error` sibling.
+func errno(err error) error {
+	e := vfs.Errno(err)
+	if e != syscall.ENOENT {
+		slog.Debug("nfs operation failed", "errno", e, "error", err)
+	}
+	return e
+}
+
+func (f *FS) cacheFileAttrs(filename string, attrs openedAttrs) {
+	f.sizeCache.Store(path.Clean(filename), attrs)
+}
+
+func (f *FS) getCachedAttrs(filename string) (openedAttrs, bool) {
 	if v, ok := f.sizeCache.Load(path.Clean(filename)); ok {
-		return v.(int64), true
+		return v.(openedAttrs), true
 	}
-	return 0, false
+	return openedAttrs{}, false
 }
 
 func New(root *vfs.Root) *FS {
 	return &FS{
 		root:     root,
 		rootPath: "/",
+		state:    &state{listings: listingCache{ttl: listingTTL}},
 	}
 }
 
-func (f *FS) resolve(filename string) (vfs.Node, error) {
+// ForClient returns the file system as served to client, an NFS client's
+// address: the queries its operations run are queued as that client's.
+func (f *FS) ForClient(client string) *FS {
+	view := *f
+	view.client = client
+	return &view
+}
+
+// context is the context of an operation by the file system's client.
+func (f *FS) context() context.Context {
+	ctx := context.Background()
+	if f.client != "" {
+		ctx = query.WithClient(ctx, f.client)
+	}
+	return ctx
+}
+
+// observe times op on filename, which started at start, for
+// /_status/latency.json and /_status/slow.ndjson.
+func (f *FS) observe(op, filename string, start time.Time, err error) {
+	f.root.Latency().Since(latency.Entry{Op: op, Path: path.Join(f.rootPath, filename)}, start, err)
+}
+
+func (f *FS) resolve(filename string) (node vfs.Node, err error) {
+	defer func(start time.Time) { f.observe(latency.OpResolve, filename, start, err) }(time.Now())
 	filename = path.Clean(filename)
 	if !path.IsAbs(filename) {
 		filename = path.Join(f.rootPath, filename)
@@ -56,11 +122,14 @@ func (f *FS) resolve(filename string) (vfs.Node, error) {
 	if filename == "/" || filename == "." {
 		return f.root, nil
 	}
+	if node, ok, err := f.finderJunk(filename); ok {
+		return node, err
+	}
 
 	filename = strings.TrimPrefix(filename, "/")
 	segments := strings.Split(filename, "/")
 
-	ctx := context.Background()
+	ctx := f.context()
 	var current vfs.Node = f.root
 	for _, seg := range segments {
 		if seg == "" || seg == "." {
@@ -72,21 +141,28 @@ func (f *FS) resolve(filename string) (vfs.Node, error) {
 		}
 		next, err := dir.Lookup(ctx, seg)
 		if err != nil {
-			return nil, err
+			return nil, errno(err)
 		}
 		current = next
 	}
 	return current, nil
 }
 
-func (f *FS) isQueriesPath(filename string) bool {
-	filename = path.Clean(filename)
-	filename = strings.TrimPrefix(filename, "/")
-	return strings.HasPrefix(filename, "_queries/")
+// isWritablePath reports whether the mount policy, or that of the tenant
+// view holding filename, allows writes at filename.
+func (f *FS) isWritablePath(filename string) bool {
+	if f.isDSStore(filename) {
+		return true
+	}
+	if len(f.root.Tenants()) > 0 {
+		root, rel := f.root.Tenant(path.Join(f.rootPath, filename))
+		return root != f.root && root.Policy().Writable(rel)
+	}
+	return f.root.Policy().Writable(filename)
 }
 
 func (f *FS) Create(filename string) (billy.File, error) {
-	if !f.isQueriesPath(filename) {
+	if !f.isWritablePath(filename) {
 		return nil, syscall.EROFS
 	}
 	return f.OpenFile(filename, os.O_RDWR|os.O_CREATE|os.O_TRUNC, 0o644)
@@ -96,23 +172,53 @@ func (f *FS) Open(filename string) (billy.File, error) {
 	return f.OpenFile(filename, os.O_RDONLY, 0)
 }
 
-func (f *FS) OpenFile(filename string, flag int, perm fs.FileMode) (billy.File, error) {
+// Drain refuses new file handles and waits, up to ctx's deadline, for open
+// ones to be closed.
+func (f *FS) Drain(ctx context.Context) error {
+	f.handles.Close()
+	return f.handles.Wait(ctx)
+}
+
+// track counts a handle opened by open until it is closed.
+func (f *FS) track(open func() (billy.File, error)) (billy.File, error) {
+	if !f.handles.Acquire() {
+		return nil, errno(drain.ErrDraining)
+	}
+	file, err := open()
+	if err != nil {
+		f.handles.Release()
+		return nil, errno(err)
+	}
+	return &trackedFile{File: file, release: f.handles.Release}, nil
+}
+
+func (f *FS) OpenFile(filename string, flag int, perm fs.FileMode) (file billy.File, err error) {
+	defer func(start time.Time) { f.observe(latency.OpOpen, filename, start, err) }(time.Now())
+	return f.track(func() (billy.File, error) { return f.openFile(filename, flag) })
+}
+
+func (f *FS) openFile(filename string, flag int) (billy.File, error) {
 	node, err := f.resolve(filename)
 	if err != nil {
 		return nil, err
 	}
 
-	ctx := context.Background()
+	ctx := f.context()
 
 	isWrite := flag&(os.O_WRONLY|os.O_RDWR|os.O_APPEND|os.O_CREATE|os.O_TRUNC) != 0
 	if isWrite {
-		if !f.isQueriesPath(filename) {
+		if !f.isWritablePath(filename) {
 			return nil, syscall.EROFS
 		}
+		if ds, ok := node.(*dsStoreNode); ok {
+			return ds.open(flag)
+		}
 		wf, ok := node.(vfs.Writable)
 		if !ok {
 			return nil, syscall.EROFS
 		}
+		// Writes can add entries; don't page listings taken before them.
+		f.listings.reset()
 		return wf.Create(ctx)
 	}
 
@@ -125,41 +231,149 @@ func (f *FS) OpenFile(filename string, flag int, perm fs.FileMode) (billy.File,
 		return nil, err
 	}
 	// Cache the opened file with its path so Stat can return accurate size
+	// and the time the result was produced.
 	if sizer, ok := opened.(interface{ Size() int64 }); ok {
-		f.cacheFileSize(filename, sizer.Size())
+		attrs := openedAttrs{size: sizer.Size()}
+		if timer, ok := opened.(interface{ ModTime() time.Time }); ok {
+			attrs.modTime = timer.ModTime()
+		}
+		f.cacheFileAttrs(filename, attrs)
 	}
-	return opened, nil
+	return f.reader(path.Join(f.rootPath, filename), opened), nil
 }
 
-func (f *FS) Stat(filename string) (os.FileInfo, error) {
+// reader wraps a file opened for reading to time its reads, and to pace
+// them when -max-read-throughput is set.
+func (f *FS) reader(handle string, file billy.File) billy.File {
+	handle = path.Clean(handle)
+	if reads := f.root.Reads(); reads.Enabled() {
+		file = &throttledFile{File: file, handle: handle, reads: reads}
+	}
+	return &timedFile{File: file, path: handle, latency: f.root.Latency()}
+}
+
+func (f *FS) Stat(filename string) (info os.FileInfo, err error) {
+	defer func(start time.Time) { f.observe(latency.OpStat, filename, start, err) }(time.Now())
 	node, err := f.resolve(filename)
 	if err != nil {
 		return nil, err
 	}
-	ctx := context.Background()
-	info, err := node.Stat(ctx)
+	ctx := f.context()
+	info, err = node.Stat(ctx)
 	if err != nil {
-		return nil, err
+		return nil, errno(err)
 	}
 	// Check if we have a cached actual size from a previous Open
-	if cachedSize, ok := f.getCachedSize(filename); ok {
-		return &sizedFileInfo{FileInfo: info, size: cachedSize}, nil
+	if cached, ok := f.getCachedAttrs(filename); ok && !info.IsDir() {
+		info = &sizedFileInfo{FileInfo: info, size: cached.size, modTime: cached.modTime}
+	} else if vfs.IsDynamic(info) {
+		info = f.sizeDynamic(ctx, filename, node, info)
+	}
+	// The forked go-nfs also uses the file's Size() method after Open to
+	// include the real size in post-op attrs, updating the client's cache.
+	return f.owned(path.Join(f.rootPath, filename), info), nil
+}
+
+// unsizedQueryFileSize is reported for a query-backed file that cannot be
+// estimated. NFS clients never READ a 0-byte file, so it must be non-zero;
+// reads stop at the real end of the file, and the size seen on Open
+// replaces it.
+const unsizedQueryFileSize = 64 << 20
+
+// sizeDynamic sizes a generated file that has not been read yet. NFS
+// clients read no further than the size they were given, so a file built
+// locally is generated to report its exact size, which is cached like one
+// seen on Open. Generating a query-backed file would run its query on
+// every GETATTR, so results are estimated instead, and other query-backed
+// files, or ones whose estimate fails, report unsizedQueryFileSize. A
+// local file that fails to size keeps its info; reading it reports the
+// error. With -dynamic-file-size, that size is reported instead.
+func (f *FS) sizeDynamic(ctx context.Context, filename string, node vfs.Node, info os.FileInfo) os.FileInfo {
+	if size := f.root.Config().DynamicFileSize; size > 0 {
+		return &sizedFileInfo{FileInfo: info, size: size}
 	}
-	// Dynamic files return a placeholder size here. The forked go-nfs
-	// will use the file's Size() method after Open to get the real size
-	// and include it in post-op attrs, updating the client's cache.
-	return info, nil
+	if vfs.IsQueryBacked(info) {
+		unsized := &sizedFileInfo{FileInfo: info, size: unsizedQueryFileSize}
+		estimator, ok := node.(vfs.Estimator)
+		if !ok {
+			return unsized
+		}
+		estimate, err := estimator.Estimate(ctx)
+		if err != nil {
+			slog.Debug("estimating generated file failed", "path", filename, "error", err)
+			return unsized
+		}
+		return &sizedFileInfo{FileInfo: info, size: estimate.Size, modTime: estimate.ModTime}
+	}
+	file, ok := node.(vfs.File)
+	if !ok {
+		return info
+	}
+	opened, err := file.Open(ctx, os.O_RDONLY)
+	if err != nil {
+		slog.Debug("sizing generated file failed", "path", filename, "error", err)
+		return info
+	}
+	defer opened.Close()
+	sizer, ok := opened.(interface{ Size() int64 })
+	if !ok {
+		return info
+	}
+	attrs := openedAttrs{size: sizer.Size()}
+	if timer, ok := opened.(interface{ ModTime() time.Time }); ok {
+		attrs.modTime = timer.ModTime()
+	}
+	f.cacheFileAttrs(filename, attrs)
+	return &sizedFileInfo{FileInfo: info, size: attrs.size, modTime: attrs.modTime}
 }
 
+// Rename onto /_queries/<name> or into /_queries/<name>/ saves the APL of
+// a q/ path as that query. Other renames are refused.
 func (f *FS) Rename(oldpath, newpath string) error {
-	if !f.isQueriesPath(oldpath) || !f.isQueriesPath(newpath) {
+	root, target := f.root.Tenant(path.Join(f.rootPath, newpath))
+	if name, ok := savedQueryTarget("/" + target); ok && f.isWritablePath(newpath) {
+		// Queries are only saved within the tenant view they come from.
+		if from, _ := f.root.Tenant(path.Join(f.rootPath, oldpath)); from != root {
+			return syscall.EXDEV
+		}
+		node, err := f.resolve(oldpath)
+		if err != nil {
+			return err
+		}
+		if err := root.SaveQuery(f.context(), name, node); err != nil {
+			return errno(err)
+		}
+		f.listings.reset()
+		return nil
+	}
+	if !f.isWritablePath(oldpath) || !f.isWritablePath(newpath) {
 		return syscall.EROFS
 	}
 	return syscall.EROFS
 }
 
+// savedQueryTarget returns the query a rename to p saves: p is
+// /_queries/<name> or a file directly inside it.
+func savedQueryTarget(p string) (string, bool) {
+	rest, ok := strings.CutPrefix(path.Clean(p), "/_queries/")
+	if !ok {
+		return "", false
+	}
+	name, file, _ := strings.Cut(rest, "/")
+	if name == "" || strings.Contains(file, "/") {
+		return "", false
+	}
+	return name, true
+}
+
 func (f *FS) Remove(filename string) error {
-	if !f.isQueriesPath(filename) {
+	if f.isDSStore(filename) {
+		if !f.root.Finder().RemoveDSStore(path.Join(f.rootPath, filename)) {
+			return syscall.ENOENT
+		}
+		return nil
+	}
+	if !f.isWritablePath(filename) {
 		return syscall.EROFS
 	}
 	return syscall.EROFS
@@ -173,7 +387,12 @@ func (f *FS) TempFile(dir, prefix string) (billy.File, error) {
 	return nil, billy.ErrNotSupported
 }
 
-func (f *FS) ReadDir(dirname string) ([]os.FileInfo, error) {
+func (f *FS) ReadDir(dirname string) (entries []os.FileInfo, err error) {
+	defer func(start time.Time) { f.observe(latency.OpReadDir, dirname, start, err) }(time.Now())
+	key := path.Clean(path.Join(f.rootPath, dirname))
+	if entries, ok := f.listings.get(key); ok {
+		return entries, nil
+	}
 	node, err := f.resolve(dirname)
 	if err != nil {
 		return nil, err
@@ -182,12 +401,23 @@ func (f *FS) ReadDir(dirname string) ([]os.FileInfo, error) {
 	if !ok {
 		return nil, syscall.ENOTDIR
 	}
-	ctx := context.Background()
-	return dir.ReadDir(ctx)
+	ctx := f.context()
+	entries, err = dir.ReadDir(ctx)
+	if err != nil {
+		return nil, errno(err)
+	}
+	owned := make([]os.FileInfo, len(entries))
+	for i, entry := range entries {
+		owned[i] = f.owned(path.Join(key, entry.Name()), entry)
+	}
+	entries = owned
+	sort.Slice(entries, func(i, j int) bool { return entries[i].Name() < entries[j].Name() })
+	f.listings.put(key, entries)
+	return slices.Clone(entries), nil
 }
 
 func (f *FS) MkdirAll(filename string, perm os.FileMode) error {
-	if !f.isQueriesPath(filename) {
+	if !f.isWritablePath(filename) {
 		return syscall.EROFS
 	}
 	return nil
@@ -237,7 +467,22 @@ func (f *FS) Chown(name string, uid, gid int) error {
 	return nil
 }
 
+// Chtimes on a touchable file, such as `touch /_queries/<name>/result.csv`,
+// drops its cached result so the next read re-runs the query. Elsewhere it
+// is a no-op.
 func (f *FS) Chtimes(name string, atime time.Time, mtime time.Time) error {
+	node, err := f.resolve(name)
+	if err != nil {
+		return err
+	}
+	touchable, ok := node.(vfs.Touchable)
+	if !ok || !f.isWritablePath(name) {
+		return nil
+	}
+	if err := touchable.Touch(f.context()); err != nil {
+		return errno(err)
+	}
+	f.sizeCache.Delete(path.Clean(name))
 	return nil
 }
 
@@ -250,6 +495,11 @@ type chrootFS struct {
 	rootPath string
 }
 
+// full returns filename's path in the parent file system.
+func (c *chrootFS) full(filename string) string {
+	return path.Join(c.rootPath, filename)
+}
+
 func (c *chrootFS) resolve(filename string) (vfs.Node, error) {
 	filename = path.Clean(filename)
 	if !path.IsAbs(filename) {
@@ -259,17 +509,17 @@ func (c *chrootFS) resolve(filename string) (vfs.Node, error) {
 	return c.parent.resolve(fullPath)
 }
 
-func (c *chrootFS) isQueriesPath(filename string) bool {
+func (c *chrootFS) isWritablePath(filename string) bool {
 	filename = path.Clean(filename)
 	if !path.IsAbs(filename) {
 		filename = "/" + filename
 	}
 	fullPath := path.Join(c.rootPath, filename)
-	return c.parent.isQueriesPath(fullPath)
+	return c.parent.isWritablePath(fullPath)
 }
 
 func (c *chrootFS) Create(filename string) (billy.File, error) {
-	if !c.isQueriesPath(filename) {
+	if !c.isWritablePath(filename) {
 		return nil, syscall.EROFS
 	}
 	return c.OpenFile(filename, os.O_RDWR|os.O_CREATE|os.O_TRUNC, 0o644)
@@ -279,23 +529,33 @@ func (c *chrootFS) Open(filename string) (billy.File, error) {
 	return c.OpenFile(filename, os.O_RDONLY, 0)
 }
 
-func (c *chrootFS) OpenFile(filename string, flag int, perm fs.FileMode) (billy.File, error) {
+func (c *chrootFS) OpenFile(filename string, flag int, perm fs.FileMode) (file billy.File, err error) {
+	defer func(start time.Time) { c.parent.observe(latency.OpOpen, c.full(filename), start, err) }(time.Now())
+	return c.parent.track(func() (billy.File, error) { return c.openFile(filename, flag) })
+}
+
+func (c *chrootFS) openFile(filename string, flag int) (billy.File, error) {
 	node, err := c.resolve(filename)
 	if err != nil {
 		return nil, err
 	}
 
-	ctx := context.Background()
+	ctx := c.parent.context()
 
 	isWrite := flag&(os.O_WRONLY|os.O_RDWR|os.O_APPEND|os.O_CREATE|os.O_TRUNC) != 0
 	if isWrite {
-		if !c.isQueriesPath(filename) {
+		if !c.isWritablePath(filename) {
 			return nil, syscall.EROFS
 		}
+		if ds, ok := node.(*dsStoreNode); ok {
+			return ds.open(flag)
+		}
 		wf, ok := node.(vfs.Writable)
 		if !ok {
 			return nil, syscall.EROFS
 		}
+		// Writes can add entries; don't page listings taken before them.
+		c.parent.listings.reset()
 		return wf.Create(ctx)
 	}
 
@@ -303,24 +563,33 @@ func (c *chrootFS) OpenFile(filename string, flag int, perm fs.FileMode) (billy.
 	if !ok {
 		return nil, syscall.EISDIR
 	}
-	return file.Open(ctx, flag)
+	opened, err := file.Open(ctx, flag)
+	if err != nil {
+		return nil, err
+	}
+	return c.parent.reader(c.full(filename), opened), nil
 }
 
-func (c *chrootFS) Stat(filename string) (os.FileInfo, error) {
+func (c *chrootFS) Stat(filename string) (info os.FileInfo, err error) {
+	defer func(start time.Time) { c.parent.observe(latency.OpStat, c.full(filename), start, err) }(time.Now())
 	node, err := c.resolve(filename)
 	if err != nil {
 		return nil, err
 	}
-	ctx := context.Background()
-	return node.Stat(ctx)
+	ctx := c.parent.context()
+	info, err = node.Stat(ctx)
+	if err != nil {
+		return nil, errno(err)
+	}
+	return c.parent.owned(c.full(filename), info), nil
 }
 
 func (c *chrootFS) Rename(oldpath, newpath string) error {
-	return syscall.EROFS
+	return c.parent.Rename(path.Join(c.rootPath, oldpath), path.Join(c.rootPath, newpath))
 }
 
 func (c *chrootFS) Remove(filename string) error {
-	return syscall.EROFS
+	return c.parent.Remove(c.full(filename))
 }
 
 func (c *chrootFS) Join(elem ...string) string {
@@ -331,7 +600,8 @@ func (c *chrootFS) TempFile(dir, prefix string) (billy.File, error) {
 	return nil, billy.ErrNotSupported
 }
 
-func (c *chrootFS) ReadDir(dirname string) ([]os.FileInfo, error) {
+func (c *chrootFS) ReadDir(dirname string) (entries []os.FileInfo, err error) {
+	defer func(start time.Time) { c.parent.observe(latency.OpReadDir, c.full(dirname), start, err) }(time.Now())
 	node, err := c.resolve(dirname)
 	if err != nil {
 		return nil, err
@@ -340,12 +610,20 @@ func (c *chrootFS) ReadDir(dirname string) ([]os.FileInfo, error) {
 	if !ok {
 		return nil, syscall.ENOTDIR
 	}
-	ctx := context.Background()
-	return dir.ReadDir(ctx)
+	ctx := c.parent.context()
+	entries, err = dir.ReadDir(ctx)
+	if err != nil {
+		return nil, errno(err)
+	}
+	owned := make([]os.FileInfo, len(entries))
+	for i, entry := range entries {
+		owned[i] = c.parent.owned(path.Join(c.full(dirname), entry.Name()), entry)
+	}
+	return owned, nil
 }
 
 func (c *chrootFS) MkdirAll(filename string, perm os.FileMode) error {
-	if !c.isQueriesPath(filename) {
+	if !c.isWritablePath(filename) {
 		return syscall.EROFS
 	}
 	return nil
@@ -388,7 +666,7 @@ func (c *chrootFS) Chown(name string, uid, gid int) error {
 }
 
 func (c *chrootFS) Chtimes(name string, atime time.Time, mtime time.Time) error {
-	return nil
+	return c.parent.Chtimes(c.full(name), atime, mtime)
 }
 
 func (c *chrootFS) Capabilities() billy.Capability {
@@ -2,15 +2,25 @@ package nfsfs
 
 import (
 	"context"
+	"errors"
 	"io"
+	"net"
 	"os"
+	"reflect"
+	"slices"
+	"strings"
+	"sync"
+	"sync/atomic"
 	"syscall"
 	"testing"
+	"time"
 
 	"github.com/go-git/go-billy/v5"
+	nfsfile "github.com/willscott/go-nfs/file"
 
 	"github.com/axiomhq/axiom-fs/internal/axiomclient"
 	"github.com/axiomhq/axiom-fs/internal/config"
+	"github.com/axiomhq/axiom-fs/internal/policy"
 	"github.com/axiomhq/axiom-fs/internal/query"
 	"github.com/axiomhq/axiom-fs/internal/vfs"
 )
@@ -34,6 +44,10 @@ func (m *mockClient) ListFields(ctx context.Context, datasetID string) ([]axiomc
 	}, nil
 }
 
+func (m *mockClient) DatasetStats(ctx context.Context) ([]axiomclient.DatasetStats, error) {
+	return nil, nil
+}
+
 func (m *mockClient) QueryAPL(ctx context.Context, apl string) (*axiomclient.QueryResult, error) {
 	return &axiomclient.QueryResult{}, nil
 }
@@ -54,6 +68,22 @@ func (m *mockExecutor) QueryAPL(ctx context.Context, apl string, opts query.Exec
 	return &axiomclient.QueryResult{}, nil
 }
 
+func (m *mockExecutor) ResultMeta(ctx context.Context, apl, format string, opts query.ExecOptions) (query.ResultMeta, error) {
+	return query.ResultMeta{APL: apl, Format: format, Bytes: int64(len(m.data))}, nil
+}
+
+func (m *mockExecutor) ResultCount(ctx context.Context, apl string, opts query.ExecOptions) (int64, error) {
+	return 0, nil
+}
+
+func (m *mockExecutor) ResultStats(ctx context.Context, apl, format string, opts query.ExecOptions) ([]byte, error) {
+	return nil, nil
+}
+
+func (m *mockExecutor) EstimateResult(ctx context.Context, apl, format string, opts query.ExecOptions) (query.ResultEstimate, error) {
+	return query.ResultEstimate{Size: int64(len(m.data))}, nil
+}
+
 func newTestFS(t *testing.T) billy.Filesystem {
 	t.Helper()
 	cfg := config.Default()
@@ -225,6 +255,132 @@ func TestCreate(t *testing.T) {
 	})
 }
 
+func TestDrain(t *testing.T) {
+	fsys := newTestFS(t).(*FS)
+	f, err := fsys.Open("/README.txt")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if sizer, ok := f.(interface{ Size() int64 }); !ok || sizer.Size() <= 0 {
+		t.Error("tracked file should expose the underlying size")
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	if err := fsys.Drain(ctx); !errors.Is(err, context.DeadlineExceeded) {
+		t.Fatalf("Drain with open file = %v, want deadline exceeded", err)
+	}
+	if _, err := fsys.Open("/README.txt"); !errors.Is(err, syscall.EAGAIN) {
+		t.Errorf("Open while draining = %v, want EAGAIN", err)
+	}
+
+	_ = f.Close()
+	_ = f.Close()
+	if err := fsys.Drain(context.Background()); err != nil {
+		t.Errorf("Drain after close: %v", err)
+	}
+}
+
+func TestErrno(t *testing.T) {
+	fsys := newTestFS(t)
+	if _, err := fsys.Stat("/datasets/missing"); !os.IsNotExist(err) {
+		t.Errorf("missing dataset: %v, want ENOENT", err)
+	}
+	cases := map[error]syscall.Errno{
+		&axiomclient.APIError{StatusCode: 401}: syscall.EACCES,
+		&axiomclient.APIError{StatusCode: 429}: syscall.EAGAIN,
+		errors.New("boom"):                     syscall.EIO,
+	}
+	for err, want := range cases {
+		if got := errno(err); got != want {
+			t.Errorf("errno(%v) = %v, want %v", err, got, want)
+		}
+	}
+}
+
+func TestPolicy(t *testing.T) {
+	cfg := config.Default()
+	cfg.CacheDir = t.TempDir()
+	cfg.QueryDir = t.TempDir()
+	client := &mockClient{datasets: []axiomclient.Dataset{{Name: "logs"}, {Name: "secret"}}}
+	pol := &policy.Policy{Datasets: policy.Datasets{Deny: []string{"secret"}}}
+	fs := New(vfs.NewRoot(cfg, client, &mockExecutor{}, vfs.WithPolicy(pol)))
+
+	if _, err := fs.Stat("/secret"); !os.IsNotExist(err) {
+		t.Errorf("hidden dataset Stat: expected not exist, got %v", err)
+	}
+	entries, err := fs.ReadDir("/datasets")
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, e := range entries {
+		if e.Name() == "secret" {
+			t.Error("hidden dataset listed in /datasets")
+		}
+	}
+	if _, err := fs.OpenFile("/_queries/test/apl", os.O_RDWR|os.O_CREATE, 0o644); err != syscall.EROFS {
+		t.Errorf("read-only policy: expected EROFS, got %v", err)
+	}
+}
+
+func TestOwnership(t *testing.T) {
+	cfg := config.Default()
+	cfg.CacheDir = t.TempDir()
+	cfg.QueryDir = t.TempDir()
+	cfg.UID, cfg.GID = 1000, 1001
+	cfg.FileMode, cfg.DirMode = 0o440, 0o550
+	uid := uint32(2000)
+	pol := policy.Default()
+	pol.Owners = []policy.Owner{{Path: "_queries", UID: &uid, FileMode: 0o600}}
+	client := &mockClient{datasets: []axiomclient.Dataset{{Name: "logs"}}}
+	fs := New(vfs.NewRoot(cfg, client, &mockExecutor{}, vfs.WithPolicy(pol)))
+	if _, err := fs.Create("/_queries/errors/apl"); err != nil {
+		t.Fatal(err)
+	}
+
+	cases := []struct {
+		path string
+		uid  uint32
+		perm os.FileMode
+	}{
+		{"/README.txt", 1000, 0o440},
+		{"/logs", 1000, 0o550},
+		{"/_queries/errors", 2000, 0o550},
+		// Only writable files get write bits.
+		{"/_queries/errors/apl", 2000, 0o600},
+		{"/_queries/errors/result.csv", 2000, 0o400},
+	}
+	for _, tc := range cases {
+		info, err := fs.Stat(tc.path)
+		if err != nil {
+			t.Fatalf("Stat(%s): %v", tc.path, err)
+		}
+		sys, ok := info.Sys().(*nfsfile.FileInfo)
+		if !ok {
+			t.Fatalf("Stat(%s).Sys() = %T", tc.path, info.Sys())
+		}
+		if sys.UID != tc.uid || sys.GID != 1001 || info.Mode().Perm() != tc.perm {
+			t.Errorf("Stat(%s): uid %d gid %d mode %v, want uid %d gid 1001 mode %v", tc.path, sys.UID, sys.GID, info.Mode().Perm(), tc.uid, tc.perm)
+		}
+		if info.IsDir() != (info.Mode()&os.ModeDir != 0) {
+			t.Errorf("Stat(%s): mode %v lost its type", tc.path, info.Mode())
+		}
+	}
+
+	entries, err := fs.ReadDir("/_queries/errors")
+	if err != nil {
+		t.Fatal(err)
+	}
+	ids := map[uint64]bool{}
+	for _, entry := range entries {
+		sys := entry.Sys().(*nfsfile.FileInfo)
+		if sys.UID != 2000 || ids[sys.Fileid] {
+			t.Errorf("entry %s: uid %d, file ID %x", entry.Name(), sys.UID, sys.Fileid)
+		}
+		ids[sys.Fileid] = true
+	}
+}
+
 func TestReadDir(t *testing.T) {
 	fs := newTestFS(t)
 
@@ -337,6 +493,181 @@ func TestLstat(t *testing.T) {
 	}
 }
 
+func TestStatDynamic(t *testing.T) {
+	fs := newTestFS(t)
+
+	info, err := fs.Stat("/logs/schema.csv")
+	if err != nil {
+		t.Fatalf("Stat: %v", err)
+	}
+	f, err := fs.Open("/logs/schema.csv")
+	if err != nil {
+		t.Fatalf("Open: %v", err)
+	}
+	data, _ := io.ReadAll(f)
+	f.Close()
+	if len(data) == 0 || info.Size() != int64(len(data)) {
+		t.Errorf("unread Size = %d, want %d", info.Size(), len(data))
+	}
+
+	cfg := config.Default()
+	cfg.CacheDir = t.TempDir()
+	cfg.DynamicFileSize = 4096
+	client := &mockClient{datasets: []axiomclient.Dataset{{Name: "logs"}}}
+	compat := New(vfs.NewRoot(cfg, client, &mockExecutor{}))
+	if info, err := compat.Stat("/logs/schema.csv"); err != nil || info.Size() != 4096 {
+		t.Errorf("Stat with -dynamic-file-size = %v, %v; want 4096 bytes", info, err)
+	}
+	if info, err := compat.Stat("/logs/q"); err != nil || info.Size() == 4096 {
+		t.Errorf("-dynamic-file-size applied to a directory: %v, %v", info, err)
+	}
+}
+
+// runCountingExecutor counts the queries it runs, as opposed to sizes it
+// estimates.
+type runCountingExecutor struct {
+	mockExecutor
+	runs atomic.Int64
+}
+
+func (r *runCountingExecutor) ExecuteAPL(ctx context.Context, apl, format string, opts query.ExecOptions) ([]byte, error) {
+	r.runs.Add(1)
+	return r.mockExecutor.ExecuteAPL(ctx, apl, format, opts)
+}
+
+func (r *runCountingExecutor) ExecuteAPLResult(ctx context.Context, apl, format string, opts query.ExecOptions) (query.ResultData, error) {
+	r.runs.Add(1)
+	return r.mockExecutor.ExecuteAPLResult(ctx, apl, format, opts)
+}
+
+func (r *runCountingExecutor) QueryAPL(ctx context.Context, apl string, opts query.ExecOptions) (*axiomclient.QueryResult, error) {
+	r.runs.Add(1)
+	return r.mockExecutor.QueryAPL(ctx, apl, opts)
+}
+
+func TestStatQueryBacked(t *testing.T) {
+	cfg := config.Default()
+	cfg.CacheDir = t.TempDir()
+	exec := &runCountingExecutor{mockExecutor: mockExecutor{data: []byte("{\"a\":1}\n")}}
+	fs := New(vfs.NewRoot(cfg, &mockClient{datasets: []axiomclient.Dataset{{Name: "logs"}}}, exec))
+
+	info, err := fs.Stat("/logs/sample.ndjson")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if info.Size() != int64(len(exec.data)) {
+		t.Errorf("sample.ndjson Size = %d, want the estimate %d", info.Size(), len(exec.data))
+	}
+	// A query-backed file without an estimate reports a placeholder size.
+	readme, err := fs.Stat("/logs/README.md")
+	if err != nil || readme.Size() == 0 {
+		t.Fatalf("README.md = %v, %v; want a non-zero size", readme, err)
+	}
+	if n := exec.runs.Load(); n != 0 {
+		t.Errorf("Stat ran %d queries", n)
+	}
+
+	// Reads, each opening the file as go-nfs does, stop at its real end.
+	var data []byte
+	for offset := int64(0); offset < readme.Size(); {
+		f, err := fs.Open("/logs/README.md")
+		if err != nil {
+			t.Fatal(err)
+		}
+		buf := make([]byte, 512)
+		n, err := f.ReadAt(buf, offset)
+		_ = f.Close()
+		data = append(data, buf[:n]...)
+		offset += int64(n)
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	if !strings.HasPrefix(string(data), "# logs") {
+		t.Errorf("README.md read back %q", data)
+	}
+	if info, err := fs.Stat("/logs/README.md"); err != nil || info.Size() != int64(len(data)) {
+		t.Errorf("README.md after reading = %v, %v; want %d bytes", info, err, len(data))
+	}
+}
+
+func TestFinderJunk(t *testing.T) {
+	fs := newTestFS(t)
+	for _, p := range []string{"/logs/.DS_Store", "/logs/q/._result.csv", "/.Spotlight-V100/Store-V2", "/datasets/.localized"} {
+		if _, err := fs.Stat(p); !errors.Is(err, syscall.ENOENT) {
+			t.Errorf("Stat(%s) = %v, want ENOENT", p, err)
+		}
+	}
+	if _, err := fs.Create("/logs/.DS_Store"); !errors.Is(err, syscall.EROFS) {
+		t.Errorf("Create(.DS_Store) = %v, want EROFS", err)
+	}
+	f, err := fs.Open("/_status/finder.json")
+	if err != nil {
+		t.Fatal(err)
+	}
+	data, _ := io.ReadAll(f)
+	f.Close()
+	if !strings.Contains(string(data), `"suppressed": 4`) || !strings.Contains(string(data), `"appledouble": 1`) {
+		t.Errorf("finder.json = %s", data)
+	}
+}
+
+func TestFakeDSStore(t *testing.T) {
+	cfg := config.Default()
+	cfg.CacheDir = t.TempDir()
+	cfg.FakeDSStore = true
+	client := &mockClient{datasets: []axiomclient.Dataset{{Name: "logs"}}}
+	fs := New(vfs.NewRoot(cfg, client, &mockExecutor{}))
+
+	if _, err := fs.Stat("/logs/.DS_Store"); !errors.Is(err, syscall.ENOENT) {
+		t.Fatalf("Stat of unwritten .DS_Store = %v, want ENOENT", err)
+	}
+	if _, err := fs.Create("/nonexistent/.DS_Store"); err == nil {
+		t.Error("created .DS_Store in a missing directory")
+	}
+	f, err := fs.Create("/logs/.DS_Store")
+	if err != nil {
+		t.Fatal(err)
+	}
+	f.Close()
+	// Each NFS write opens the file again at an offset.
+	for i, chunk := range []string{"Bud1", "view"} {
+		f, err := fs.OpenFile("/logs/.DS_Store", os.O_RDWR, 0)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, err := f.Seek(int64(4*i), io.SeekStart); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := f.Write([]byte(chunk)); err != nil {
+			t.Fatal(err)
+		}
+		f.Close()
+	}
+	info, err := fs.Stat("/logs/.DS_Store")
+	if err != nil || info.Size() != 8 {
+		t.Fatalf("Stat = %v, %v; want 8 bytes", info, err)
+	}
+	f, err = fs.Open("/logs/.DS_Store")
+	if err != nil {
+		t.Fatal(err)
+	}
+	data, _ := io.ReadAll(f)
+	f.Close()
+	if string(data) != "Bud1view" {
+		t.Errorf("read %q, want Bud1view", data)
+	}
+	if err := fs.Remove("/logs/.DS_Store"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := fs.Stat("/logs/.DS_Store"); !errors.Is(err, syscall.ENOENT) {
+		t.Errorf("Stat after Remove = %v, want ENOENT", err)
+	}
+}
+
 func TestReadlink(t *testing.T) {
 	fs := newTestFS(t)
 	_, err := fs.Readlink("/anything")
@@ -559,6 +890,124 @@ func TestRenameInQueries(t *testing.T) {
 	}
 }
 
+func TestTenantViews(t *testing.T) {
+	cfg := config.Default()
+	cfg.CacheDir = t.TempDir()
+	cfg.QueryDir = t.TempDir()
+	view := func(name string, pol *policy.Policy, datasets ...string) vfs.Tenant {
+		tcfg := cfg.Tenant(name)
+		client := &mockClient{}
+		for _, d := range datasets {
+			client.datasets = append(client.datasets, axiomclient.Dataset{Name: d})
+		}
+		return vfs.Tenant{Path: "teams/" + name, Root: vfs.NewRoot(tcfg, client, &mockExecutor{}, vfs.WithPolicy(pol))}
+	}
+	payments := view("payments", &policy.Policy{
+		WritablePaths: []string{"_queries"},
+		Datasets:      policy.Datasets{Allow: []string{"payments-*"}},
+	}, "payments-api", "search-api")
+	search := view("search", &policy.Policy{WritablePaths: []string{}}, "search-api")
+	fs := New(vfs.NewRoot(cfg, nil, nil, vfs.WithTenants([]vfs.Tenant{payments, search})))
+
+	names := func(dir string) []string {
+		t.Helper()
+		entries, err := fs.ReadDir(dir)
+		if err != nil {
+			t.Fatalf("ReadDir(%s): %v", dir, err)
+		}
+		var names []string
+		for _, e := range entries {
+			names = append(names, e.Name())
+		}
+		return names
+	}
+	if got := names("/"); !slices.Equal(got, []string{"teams"}) {
+		t.Errorf("root = %v, want only the tenants", got)
+	}
+	if got := names("/teams"); !slices.Equal(got, []string{"payments", "search"}) {
+		t.Errorf("/teams = %v", got)
+	}
+	if got := names("/teams/payments"); !slices.Contains(got, "payments-api") || slices.Contains(got, "search-api") {
+		t.Errorf("payments view = %v, want its policy applied", got)
+	}
+	if _, err := fs.Stat("/_queries"); err == nil {
+		t.Error("the mount's own tree should not be served")
+	}
+
+	f, err := fs.OpenFile("/teams/payments/_queries/errors/apl", os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0o644)
+	if err != nil {
+		t.Fatalf("write in writable tenant: %v", err)
+	}
+	_, _ = f.Write([]byte("['payments-api']\n"))
+	_ = f.Close()
+	if _, err := fs.OpenFile("/teams/search/_queries/errors/apl", os.O_WRONLY|os.O_CREATE, 0o644); err != syscall.EROFS {
+		t.Errorf("write in read-only tenant: err = %v, want EROFS", err)
+	}
+	if got := names("/teams/search/_queries"); slices.Contains(got, "errors") {
+		t.Errorf("tenants share saved queries: %v", got)
+	}
+
+	if err := fs.Rename("/teams/payments/payments-api/q/where/status>=500/result.csv", "/teams/payments/_queries/fails"); err != nil {
+		t.Errorf("mv within a tenant: %v", err)
+	}
+	if err := fs.Rename("/teams/search/search-api/q/where/status>=500/result.csv", "/teams/payments/_queries/leak"); err != syscall.EXDEV {
+		t.Errorf("mv across tenants: err = %v, want EXDEV", err)
+	}
+}
+
+func TestRenamePromotesQueryPath(t *testing.T) {
+	cfg := config.Default()
+	cfg.CacheDir = t.TempDir()
+	cfg.QueryDir = t.TempDir()
+	client := &mockClient{datasets: []axiomclient.Dataset{{Name: "logs"}}}
+	fs := New(vfs.NewRoot(cfg, client, &mockExecutor{}))
+
+	readAPL := func(name string) string {
+		t.Helper()
+		f, err := fs.Open("/_queries/" + name + "/apl")
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer f.Close()
+		data, _ := io.ReadAll(f)
+		return string(data)
+	}
+
+	if err := fs.Rename("/logs/q/where/status>=500/result.csv", "/_queries/errors/result.csv"); err != nil {
+		t.Fatalf("mv into query dir: %v", err)
+	}
+	if got := readAPL("errors"); !strings.HasPrefix(got, "['logs']") || !strings.Contains(got, "| where status>=500") {
+		t.Errorf("saved APL = %q", got)
+	}
+
+	if err := fs.Rename("/logs/q/summarize/count()", "/_queries/counts"); err != nil {
+		t.Fatalf("mv directory: %v", err)
+	}
+	if got := readAPL("counts"); !strings.Contains(got, "| summarize count()") {
+		t.Errorf("saved APL = %q", got)
+	}
+	chrooted, _ := fs.Chroot("/logs")
+	if err := chrooted.Rename("/q/limit/5/result.ndjson", "/../_queries/five"); err != nil {
+		t.Fatalf("chrooted mv: %v", err)
+	}
+	if got := readAPL("five"); !strings.Contains(got, "take 5") {
+		t.Errorf("saved APL = %q", got)
+	}
+
+	for _, tc := range []struct{ from, to string }{
+		{"/logs/schema.json", "/_queries/schema"},
+		{"/logs/q/where/status>=500/result.csv", "/_queries/errors/nested/apl"},
+		{"/logs/q/where/status>=500/result.csv", "/logs/errors"},
+	} {
+		if err := fs.Rename(tc.from, tc.to); err != syscall.EROFS {
+			t.Errorf("Rename(%q, %q) = %v, want EROFS", tc.from, tc.to, err)
+		}
+	}
+	if err := fs.Rename("/logs/q/limit/x/result.csv", "/_queries/bad"); err != syscall.EINVAL {
+		t.Errorf("uncompilable path: err = %v, want EINVAL", err)
+	}
+}
+
 func TestChrootOpenFile(t *testing.T) {
 	fs := newTestFS(t)
 	chrooted, _ := fs.Chroot("/logs")
@@ -649,6 +1098,58 @@ func TestQueriesWriteFlow(t *testing.T) {
 	}
 }
 
+// invalidatingExecutor records the queries whose cache was dropped.
+type invalidatingExecutor struct {
+	mockExecutor
+	invalidated []string
+}
+
+func (e *invalidatingExecutor) InvalidateAPL(apl string) int {
+	e.invalidated = append(e.invalidated, apl)
+	return 1
+}
+
+func TestChtimesInvalidatesResult(t *testing.T) {
+	cfg := config.Default()
+	cfg.CacheDir = t.TempDir()
+	cfg.QueryDir = t.TempDir()
+	exec := &invalidatingExecutor{mockExecutor: mockExecutor{data: []byte("a\n1\n")}}
+	fs := New(vfs.NewRoot(cfg, &mockClient{datasets: []axiomclient.Dataset{{Name: "logs"}}}, exec))
+
+	f, err := fs.Create("/_queries/errors/apl")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := f.Write([]byte("['logs'] | count")); err != nil {
+		t.Fatal(err)
+	}
+	f.Close()
+
+	now := time.Now()
+	if err := fs.Chtimes("/README.txt", now, now); err != nil {
+		t.Errorf("Chtimes README.txt: %v", err)
+	}
+	if len(exec.invalidated) != 0 {
+		t.Errorf("touching README.txt invalidated %v", exec.invalidated)
+	}
+	if err := fs.Chtimes("/_queries/errors/result.csv", now, now); err != nil {
+		t.Fatalf("Chtimes result.csv: %v", err)
+	}
+	if len(exec.invalidated) != 1 || !strings.Contains(exec.invalidated[0], "['logs'] | count") {
+		t.Errorf("invalidated = %v", exec.invalidated)
+	}
+	chroot, err := fs.Chroot("/_queries")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := chroot.(billy.Change).Chtimes("errors/result.json", now, now); err != nil || len(exec.invalidated) != 2 {
+		t.Errorf("chroot Chtimes: err = %v, invalidated = %v", err, exec.invalidated)
+	}
+	if err := fs.Chtimes("/nonexistent", now, now); err == nil {
+		t.Error("Chtimes on a missing file should fail")
+	}
+}
+
 func TestFileSeekAndReadAt(t *testing.T) {
 	fs := newTestFS(t)
 	f, err := fs.Open("/README.txt")
@@ -680,3 +1181,187 @@ func TestFileSeekAndReadAt(t *testing.T) {
 		t.Error("no data from ReadAt")
 	}
 }
+
+func TestReadThroughput(t *testing.T) {
+	cfg := config.Default()
+	cfg.CacheDir = t.TempDir()
+	root := vfs.NewRoot(cfg, &mockClient{}, &mockExecutor{})
+	readme, _ := root.Lookup(context.Background(), "README.txt")
+	info, _ := readme.Stat(context.Background())
+	// Ten reads fit in the first second; the eleventh waits 100ms.
+	cfg.MaxReadThroughput = 10 * info.Size()
+	root = vfs.NewRoot(cfg, &mockClient{}, &mockExecutor{})
+	fs := New(root)
+
+	start := time.Now()
+	for i := 0; i < 11; i++ {
+		// go-nfs opens the file for every READ call.
+		f, err := fs.Open("/README.txt")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, err := io.ReadAll(io.NewSectionReader(f, 0, info.Size())); err != nil {
+			t.Fatal(err)
+		}
+		_ = f.Close()
+	}
+	if elapsed := time.Since(start); elapsed < 90*time.Millisecond {
+		t.Errorf("11 reads took %v, want them throttled", elapsed)
+	}
+	if stats := root.Reads().Stats(); stats.ThrottledReads != 1 || stats.Handles != 1 {
+		t.Errorf("stats = %+v", stats)
+	}
+}
+
+func TestLatency(t *testing.T) {
+	cfg := config.Default()
+	cfg.CacheDir = t.TempDir()
+	// Every operation is slow enough to be logged.
+	cfg.SlowOpThreshold = time.Nanosecond
+	root := vfs.NewRoot(cfg, &mockClient{datasets: []axiomclient.Dataset{{Name: "logs"}}}, &mockExecutor{})
+	fs := New(root)
+
+	if _, err := fs.ReadDir("/"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := fs.Stat("/missing"); err == nil {
+		t.Fatal("Stat of a missing file succeeded")
+	}
+	f, err := fs.Open("/README.txt")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := io.ReadAll(f); err != nil {
+		t.Fatal(err)
+	}
+	_ = f.Close()
+
+	var ops []string
+	for _, h := range root.Latency().Stats().Ops {
+		ops = append(ops, h.Op)
+	}
+	if want := []string{"resolve", "stat", "open", "read", "readdir"}; !slices.Equal(ops, want) {
+		t.Errorf("ops = %v, want %v", ops, want)
+	}
+
+	var statErr, read bool
+	for _, e := range root.Latency().Slow() {
+		switch {
+		case e.Op == "stat" && e.Path == "/missing":
+			statErr = e.Error != ""
+		case e.Op == "read" && e.Path == "/README.txt" && e.Bytes > 0:
+			read = e.Error == ""
+		}
+	}
+	if !statErr || !read {
+		t.Errorf("slow log = %+v", root.Latency().Slow())
+	}
+}
+
+func TestReadDirSnapshot(t *testing.T) {
+	cfg := config.Default()
+	cfg.CacheDir = t.TempDir()
+	cfg.QueryDir = t.TempDir()
+	// Deleted datasets leave the listing at once.
+	cfg.DeletedDatasetGrace = 0
+	client := &mockClient{datasets: []axiomclient.Dataset{{Name: "logs"}, {Name: "metrics"}}}
+	root := vfs.NewRoot(cfg, client, &mockExecutor{})
+	fs := New(root)
+
+	has := func(name string) bool {
+		t.Helper()
+		entries, err := fs.ReadDir("/")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !slices.IsSortedFunc(entries, func(a, b os.FileInfo) int { return strings.Compare(a.Name(), b.Name()) }) {
+			t.Error("listing not sorted")
+		}
+		found := false
+		for _, e := range entries {
+			found = found || e.Name() == name
+		}
+		// Callers may reorder the slice; the snapshot must not change.
+		slices.Reverse(entries)
+		return found
+	}
+
+	if has("traces") {
+		t.Fatal("traces listed before it exists")
+	}
+	client.datasets = append(client.datasets, axiomclient.Dataset{Name: "traces"})
+	if _, err := root.RefreshMetadata(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	if has("traces") {
+		t.Error("listing changed while paging from a snapshot")
+	}
+
+	f, err := fs.Create("/_queries/snapshot/apl")
+	if err != nil {
+		t.Fatal(err)
+	}
+	f.Close()
+	if !has("traces") {
+		t.Error("write did not drop the listing snapshot")
+	}
+
+	fs.listings.reset()
+	fs.listings.ttl = 0
+	client.datasets = client.datasets[:2]
+	if _, err := root.RefreshMetadata(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	if has("traces") {
+		t.Error("listing cached with a zero TTL")
+	}
+}
+
+// clientExecutor records the client each query runs for.
+type clientExecutor struct {
+	mockExecutor
+	mu      sync.Mutex
+	clients []string
+}
+
+func (e *clientExecutor) record(ctx context.Context) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	e.clients = append(e.clients, query.ClientFrom(ctx))
+}
+
+func (e *clientExecutor) ExecuteAPL(ctx context.Context, apl, format string, opts query.ExecOptions) ([]byte, error) {
+	e.record(ctx)
+	return e.mockExecutor.ExecuteAPL(ctx, apl, format, opts)
+}
+
+func (e *clientExecutor) ExecuteAPLResult(ctx context.Context, apl, format string, opts query.ExecOptions) (query.ResultData, error) {
+	e.record(ctx)
+	return e.mockExecutor.ExecuteAPLResult(ctx, apl, format, opts)
+}
+
+func TestForClient(t *testing.T) {
+	cfg := config.Default()
+	cfg.CacheDir = t.TempDir()
+	exec := &clientExecutor{mockExecutor: mockExecutor{data: []byte("test_data")}}
+	fsys := New(vfs.NewRoot(cfg, &mockClient{datasets: []axiomclient.Dataset{{Name: "logs"}}}, exec))
+
+	for _, fs := range []billy.Filesystem{fsys, fsys.ForClient("10.0.0.5")} {
+		f, err := fs.Open("/logs/sample.ndjson")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, err := io.ReadAll(f); err != nil {
+			t.Fatal(err)
+		}
+		f.Close()
+	}
+	if want := []string{query.LocalClient, "10.0.0.5"}; !reflect.DeepEqual(exec.clients, want) {
+		t.Errorf("queries ran for %v, want %v", exec.clients, want)
+	}
+
+	addr := &net.TCPAddr{IP: net.ParseIP("10.0.0.5"), Port: 871}
+	if got := clientAddr(addr); got != "10.0.0.5" {
+		t.Errorf("clientAddr(%v) = %q", addr, got)
+	}
+}
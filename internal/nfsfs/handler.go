@@ -0,0 +1,40 @@
+package nfsfs
+
+import (
+	"context"
+	"net"
+
+	"github.com/go-git/go-billy/v5"
+	nfs "github.com/willscott/go-nfs"
+	nfshelper "github.com/willscott/go-nfs/helpers"
+)
+
+// clientHandler serves fs to every mount request, as seen by the client
+// that sent it, so the queries each client's reads run queue separately.
+type clientHandler struct {
+	nfs.Handler
+	fs *FS
+}
+
+// NewHandler returns an AUTH_NULL handler serving fs to each client by its
+// address; see ForClient.
+func NewHandler(fs *FS) nfs.Handler {
+	return &clientHandler{Handler: nfshelper.NewNullAuthHandler(fs), fs: fs}
+}
+
+func (h *clientHandler) Mount(ctx context.Context, conn net.Conn, req nfs.MountRequest) (nfs.MountStatus, billy.Filesystem, []nfs.AuthFlavor) {
+	status, _, auths := h.Handler.Mount(ctx, conn, req)
+	return status, h.fs.ForClient(clientAddr(conn.RemoteAddr())), auths
+}
+
+// clientAddr is a client's host, without the port, which changes with
+// every connection.
+func clientAddr(addr net.Addr) string {
+	if addr == nil {
+		return ""
+	}
+	if host, _, err := net.SplitHostPort(addr.String()); err == nil {
+		return host
+	}
+	return addr.String()
+}
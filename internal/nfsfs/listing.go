@@ -0,0 +1,65 @@
+package nfsfs
+
+import (
+	"os"
+	"slices"
+	"sync"
+	"time"
+)
+
+// listingTTL is how long a directory listing is reused. READDIR pages
+// through a directory by position in its sorted listing (cookie = index+2)
+// and go-nfs re-lists the directory for a page whenever its verifier has
+// left the handler's cache; a listing that changed meanwhile then skips or
+// repeats entries, or fails the page with BADCOOKIE. Paging from one
+// snapshot keeps the cookies of a large directory stable.
+const listingTTL = 5 * time.Second
+
+// listingCache holds recent sorted directory listings by absolute path.
+type listingCache struct {
+	mu       sync.Mutex
+	ttl      time.Duration
+	listings map[string]listing
+}
+
+type listing struct {
+	entries []os.FileInfo
+	taken   time.Time
+}
+
+// get returns a copy of dir's listing if it was taken within the TTL; go-nfs
+// sorts the slice it is given in place.
+func (c *listingCache) get(dir string) ([]os.FileInfo, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	l, ok := c.listings[dir]
+	if !ok || time.Since(l.taken) >= c.ttl {
+		return nil, false
+	}
+	return slices.Clone(l.entries), true
+}
+
+func (c *listingCache) put(dir string, entries []os.FileInfo) {
+	if c.ttl <= 0 {
+		return
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	now := time.Now()
+	for key, l := range c.listings {
+		if now.Sub(l.taken) >= c.ttl {
+			delete(c.listings, key)
+		}
+	}
+	if c.listings == nil {
+		c.listings = map[string]listing{}
+	}
+	c.listings[dir] = listing{entries: entries, taken: now}
+}
+
+// reset drops all listings.
+func (c *listingCache) reset() {
+	c.mu.Lock()
+	c.listings = nil
+	c.mu.Unlock()
+}
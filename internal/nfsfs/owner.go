@@ -0,0 +1,46 @@
+package nfsfs
+
+import (
+	"hash/fnv"
+	"os"
+	"path"
+
+	nfsfile "github.com/willscott/go-nfs/file"
+)
+
+// ownedFileInfo reports a file's owner and permission bits under the
+// mount's ownership settings. go-nfs reads the owner from Sys.
+type ownedFileInfo struct {
+	os.FileInfo
+	mode os.FileMode
+	sys  *nfsfile.FileInfo
+}
+
+func (o *ownedFileInfo) Mode() os.FileMode { return o.mode }
+func (o *ownedFileInfo) Sys() any          { return o.sys }
+
+// owned applies the ownership of name, an absolute path on the mount, to
+// info. The file ID go-nfs otherwise derives from the path is kept, since
+// setting Sys replaces it.
+func (f *FS) owned(name string, info os.FileInfo) os.FileInfo {
+	name = path.Clean("/" + name)
+	root, rel := f.root.Tenant(name)
+	own := root.Ownership(rel)
+	mode := info.Mode()
+	switch {
+	case info.IsDir() && own.DirMode != 0:
+		mode = mode&^os.ModePerm | own.DirMode
+	case !info.IsDir() && own.FileMode != 0 && mode&0o200 != 0:
+		mode = mode&^os.ModePerm | own.FileMode | 0o200
+	case !info.IsDir() && own.FileMode != 0:
+		// Writes to read-only files fail whatever the mode says.
+		mode = mode&^os.ModePerm | own.FileMode&^0o222
+	}
+	id := fnv.New64()
+	_, _ = id.Write([]byte(name))
+	return &ownedFileInfo{
+		FileInfo: info,
+		mode:     mode,
+		sys:      &nfsfile.FileInfo{Nlink: 1, UID: own.UID, GID: own.GID, Fileid: id.Sum64()},
+	}
+}
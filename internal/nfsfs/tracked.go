@@ -0,0 +1,125 @@
+package nfsfs
+
+import (
+	"context"
+	"errors"
+	"io"
+	"sync"
+	"time"
+
+	"github.com/go-git/go-billy/v5"
+
+	"github.com/axiomhq/axiom-fs/internal/latency"
+	"github.com/axiomhq/axiom-fs/internal/throttle"
+)
+
+// throttledFile paces reads to its handle's share of -max-read-throughput.
+// go-nfs opens a file for every READ call, so the handle is the file's path,
+// not the billy.File.
+type throttledFile struct {
+	billy.File
+	handle string
+	reads  *throttle.Limiter
+}
+
+func (t *throttledFile) Read(p []byte) (int, error) {
+	n, err := t.File.Read(p)
+	if werr := t.reads.Wait(context.Background(), t.handle, n); werr != nil && err == nil {
+		err = werr
+	}
+	return n, err
+}
+
+func (t *throttledFile) ReadAt(p []byte, off int64) (int, error) {
+	n, err := t.File.ReadAt(p, off)
+	if werr := t.reads.Wait(context.Background(), t.handle, n); werr != nil && err == nil {
+		err = werr
+	}
+	return n, err
+}
+
+func (t *throttledFile) Size() int64 {
+	if sizer, ok := t.File.(interface{ Size() int64 }); ok {
+		return sizer.Size()
+	}
+	return -1
+}
+
+func (t *throttledFile) ModTime() time.Time {
+	if timer, ok := t.File.(interface{ ModTime() time.Time }); ok {
+		return timer.ModTime()
+	}
+	return time.Time{}
+}
+
+// trackedFile releases its drain slot on the first Close. Size and ModTime
+// are forwarded because go-nfs and Stat look for them on opened files.
+type trackedFile struct {
+	billy.File
+	release func()
+	once    sync.Once
+}
+
+func (t *trackedFile) Close() error {
+	err := t.File.Close()
+	t.once.Do(t.release)
+	return err
+}
+
+// Size returns the underlying file's size, or -1 when it does not know it.
+func (t *trackedFile) Size() int64 {
+	if sizer, ok := t.File.(interface{ Size() int64 }); ok {
+		return sizer.Size()
+	}
+	return -1
+}
+
+func (t *trackedFile) ModTime() time.Time {
+	if timer, ok := t.File.(interface{ ModTime() time.Time }); ok {
+		return timer.ModTime()
+	}
+	return time.Time{}
+}
+
+// timedFile times each read for /_status/latency.json and slow.ndjson.
+// Reads are the NFS transfer: what a query already produced, sent on.
+type timedFile struct {
+	billy.File
+	path    string
+	latency *latency.Recorder
+}
+
+func (t *timedFile) Read(p []byte) (int, error) {
+	start := time.Now()
+	n, err := t.File.Read(p)
+	t.observe(start, n, err)
+	return n, err
+}
+
+func (t *timedFile) ReadAt(p []byte, off int64) (int, error) {
+	start := time.Now()
+	n, err := t.File.ReadAt(p, off)
+	t.observe(start, n, err)
+	return n, err
+}
+
+func (t *timedFile) observe(start time.Time, n int, err error) {
+	if errors.Is(err, io.EOF) {
+		err = nil
+	}
+	t.latency.Since(latency.Entry{Op: latency.OpRead, Path: t.path, Bytes: int64(n)}, start, err)
+}
+
+func (t *timedFile) Size() int64 {
+	if sizer, ok := t.File.(interface{ Size() int64 }); ok {
+		return sizer.Size()
+	}
+	return -1
+}
+
+func (t *timedFile) ModTime() time.Time {
+	if timer, ok := t.File.(interface{ ModTime() time.Time }); ok {
+		return timer.ModTime()
+	}
+	return time.Time{}
+}
@@ -0,0 +1,306 @@
+// Package policy decides which parts of the mount are visible and which
+// accept writes. Policies are loaded from a JSON file:
+//
+//	{
+//	  "writable": ["_queries", "_snippets", "_batch"],
+//	  "datasets": {"allow": ["logs-*"], "deny": ["*-pii"]},
+//	  "owners": [{"path": "_queries", "uid": 1000, "file_mode": "0640"}],
+//	  "redact": [{"field": "user.email", "action": "hash"}],
+//	  "columns": [{"dataset": "billing-*", "allow": ["_time", "plan", "amount"]}]
+//	}
+//
+// Patterns use path.Match syntax. A nil *Policy behaves like Default.
+package policy
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path"
+	"strconv"
+	"strings"
+
+	"github.com/axiomhq/axiom-fs/internal/apl"
+	"github.com/axiomhq/axiom-fs/internal/redact"
+)
+
+// Policy is a mount access policy.
+type Policy struct {
+	// WritablePaths lists subtrees, relative to the mount root, whose contents
+	// accept writes. Each segment may be a glob.
+	WritablePaths []string `json:"writable"`
+	Datasets      Datasets `json:"datasets"`
+	// Owners override the mount-wide ownership in subtrees; later rules
+	// win over earlier ones.
+	Owners []Owner `json:"owners,omitempty"`
+	// Redact hashes, masks or drops result columns by name; the first
+	// matching rule wins.
+	Redact []redact.Rule `json:"redact,omitempty"`
+	// Columns limits which columns of a dataset are served at all, in
+	// results, schemas and field listings.
+	Columns []redact.ColumnRule `json:"columns,omitempty"`
+}
+
+// Datasets filters which datasets are visible. An empty Allow list allows
+// every dataset; Deny always wins over Allow.
+type Datasets struct {
+	Allow []string `json:"allow,omitempty"`
+	Deny  []string `json:"deny,omitempty"`
+}
+
+// Owner sets the owner or permission bits of a subtree, relative to the
+// mount root; each segment may be a glob. Unset fields keep what applies
+// outside it.
+type Owner struct {
+	Path     string  `json:"path"`
+	UID      *uint32 `json:"uid,omitempty"`
+	GID      *uint32 `json:"gid,omitempty"`
+	FileMode Mode    `json:"file_mode,omitempty"`
+	DirMode  Mode    `json:"dir_mode,omitempty"`
+}
+
+// Mode is a permission mode written as an octal string, e.g. "0640".
+type Mode os.FileMode
+
+func (m *Mode) UnmarshalJSON(data []byte) error {
+	var s string
+	if err := json.Unmarshal(data, &s); err != nil {
+		return err
+	}
+	v, err := strconv.ParseUint(s, 8, 32)
+	if err != nil || v > 0o777 {
+		return fmt.Errorf("invalid mode %q: want octal permission bits such as 0640", s)
+	}
+	*m = Mode(v)
+	return nil
+}
+
+func (m Mode) MarshalJSON() ([]byte, error) {
+	return json.Marshal(fmt.Sprintf("%04o", uint32(m)))
+}
+
+// Ownership is who owns files and their permission bits. Zero modes keep
+// each file's own bits.
+type Ownership struct {
+	UID      uint32
+	GID      uint32
+	FileMode os.FileMode
+	DirMode  os.FileMode
+}
+
+// Default keeps only saved queries, snippets and batches writable and shows every
+// dataset.
+func Default() *Policy {
+	return &Policy{WritablePaths: []string{"_queries", "_snippets", "_batch"}}
+}
+
+// Load reads a policy file. An empty path yields Default.
+func Load(file string) (*Policy, error) {
+	if file == "" {
+		return Default(), nil
+	}
+	data, err := os.ReadFile(file)
+	if err != nil {
+		return nil, err
+	}
+	var p Policy
+	if err := json.Unmarshal(data, &p); err != nil {
+		return nil, fmt.Errorf("parse policy %s: %w", file, err)
+	}
+	if err := p.validate(); err != nil {
+		return nil, fmt.Errorf("policy %s: %w", file, err)
+	}
+	return &p, nil
+}
+
+func (p *Policy) validate() error {
+	patterns := append(append(append([]string{}, p.WritablePaths...), p.Datasets.Allow...), p.Datasets.Deny...)
+	for _, owner := range p.Owners {
+		patterns = append(patterns, owner.Path)
+	}
+	for _, pattern := range patterns {
+		if _, err := path.Match(pattern, ""); err != nil {
+			return fmt.Errorf("invalid pattern %q: %w", pattern, err)
+		}
+	}
+	_, err := redact.New(p.Redact, p.Columns)
+	return err
+}
+
+// DatasetVisible reports whether a dataset may appear in the mount.
+func (p *Policy) DatasetVisible(name string) bool {
+	if p == nil {
+		return true
+	}
+	for _, pattern := range p.Datasets.Deny {
+		if ok, _ := path.Match(pattern, name); ok {
+			return false
+		}
+	}
+	if len(p.Datasets.Allow) == 0 {
+		return true
+	}
+	for _, pattern := range p.Datasets.Allow {
+		if ok, _ := path.Match(pattern, name); ok {
+			return true
+		}
+	}
+	return false
+}
+
+// RestrictsDatasets reports whether the policy hides any dataset.
+func (p *Policy) RestrictsDatasets() bool {
+	return p != nil && len(p.Datasets.Allow)+len(p.Datasets.Deny) > 0
+}
+
+// Writable reports whether name, a slash path relative to the mount root,
+// lies strictly inside a writable subtree.
+func (p *Policy) Writable(name string) bool {
+	if p == nil {
+		p = Default()
+	}
+	segments := splitPath(name)
+	for _, pattern := range p.WritablePaths {
+		prefix := splitPath(pattern)
+		if len(prefix) == 0 || len(segments) <= len(prefix) {
+			continue
+		}
+		if matchSegments(prefix, segments[:len(prefix)]) {
+			return true
+		}
+	}
+	return false
+}
+
+// Ownership returns the ownership of name, a slash path relative to the
+// mount root: base, overridden by every owners rule whose subtree holds
+// name.
+func (p *Policy) Ownership(name string, base Ownership) Ownership {
+	if p == nil {
+		return base
+	}
+	segments := splitPath(name)
+	for _, owner := range p.Owners {
+		prefix := splitPath(owner.Path)
+		if len(segments) < len(prefix) || !matchSegments(prefix, segments[:len(prefix)]) {
+			continue
+		}
+		if owner.UID != nil {
+			base.UID = *owner.UID
+		}
+		if owner.GID != nil {
+			base.GID = *owner.GID
+		}
+		if owner.FileMode != 0 {
+			base.FileMode = os.FileMode(owner.FileMode)
+		}
+		if owner.DirMode != 0 {
+			base.DirMode = os.FileMode(owner.DirMode)
+		}
+	}
+	return base
+}
+
+func splitPath(name string) []string {
+	name = strings.Trim(path.Clean("/"+name), "/")
+	if name == "" {
+		return nil
+	}
+	return strings.Split(name, "/")
+}
+
+func matchSegments(patterns, segments []string) bool {
+	for i, pattern := range patterns {
+		if ok, _ := path.Match(pattern, segments[i]); !ok {
+			return false
+		}
+	}
+	return true
+}
+
+// Tenant is a restricted view of Axiom served under Path, a slash path
+// relative to the mount root such as teams/payments. Its policy fields
+// apply inside the view; with no writable list, its saved queries and
+// snippets are writable.
+type Tenant struct {
+	Path string `json:"path"`
+	Policy
+	// DefaultRange replaces -default-range for the tenant's queries.
+	DefaultRange string `json:"default_range,omitempty"`
+	// TokenEnv names the environment variable holding the tenant's Axiom
+	// token; Token is the token itself.
+	TokenEnv string `json:"token_env,omitempty"`
+	Token    string `json:"token,omitempty"`
+}
+
+// Name is the last segment of the tenant's path, which names its cache and
+// query directories.
+func (t Tenant) Name() string {
+	return path.Base("/" + t.Path)
+}
+
+// AxiomToken resolves the tenant's token.
+func (t Tenant) AxiomToken() string {
+	if t.TokenEnv != "" {
+		return os.Getenv(t.TokenEnv)
+	}
+	return t.Token
+}
+
+// LoadTenants reads a tenant file, a JSON list of tenants. An empty path
+// yields none.
+func LoadTenants(file string) ([]Tenant, error) {
+	if file == "" {
+		return nil, nil
+	}
+	data, err := os.ReadFile(file)
+	if err != nil {
+		return nil, err
+	}
+	var tenants []Tenant
+	if err := json.Unmarshal(data, &tenants); err != nil {
+		return nil, fmt.Errorf("parse tenants %s: %w", file, err)
+	}
+	if err := validateTenants(tenants); err != nil {
+		return nil, fmt.Errorf("tenants %s: %w", file, err)
+	}
+	for i := range tenants {
+		if tenants[i].WritablePaths == nil {
+			tenants[i].WritablePaths = Default().WritablePaths
+		}
+	}
+	return tenants, nil
+}
+
+func validateTenants(tenants []Tenant) error {
+	paths := map[string]bool{}
+	names := map[string]bool{}
+	for _, t := range tenants {
+		p := strings.Trim(path.Clean("/"+t.Path), "/")
+		if p == "" || strings.ContainsAny(p, "*?[\\") {
+			return fmt.Errorf("invalid tenant path %q", t.Path)
+		}
+		for other := range paths {
+			if p == other || strings.HasPrefix(p, other+"/") || strings.HasPrefix(other, p+"/") {
+				return fmt.Errorf("tenant paths %q and %q overlap", other, p)
+			}
+		}
+		paths[p] = true
+		if names[t.Name()] {
+			return fmt.Errorf("two tenants are named %q", t.Name())
+		}
+		names[t.Name()] = true
+		if t.AxiomToken() == "" {
+			return fmt.Errorf("tenant %q has no token; set token or token_env", p)
+		}
+		if t.DefaultRange != "" {
+			if _, err := apl.ParseTimespan(t.DefaultRange); err != nil {
+				return fmt.Errorf("tenant %q: invalid default_range %q", p, t.DefaultRange)
+			}
+		}
+		if err := t.Policy.validate(); err != nil {
+			return fmt.Errorf("tenant %q: %w", p, err)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,178 @@
+package policy
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestDefaultPolicy(t *testing.T) {
+	for _, p := range []*Policy{nil, Default()} {
+		if !p.Writable("_queries/foo/apl") {
+			t.Error("_queries contents should be writable by default")
+		}
+		for _, name := range []string{"_queries", "logs/q/result.csv", "/", ""} {
+			if p.Writable(name) {
+				t.Errorf("%q should not be writable", name)
+			}
+		}
+		if !p.DatasetVisible("anything") {
+			t.Error("all datasets should be visible by default")
+		}
+	}
+}
+
+func TestDatasetVisible(t *testing.T) {
+	p := &Policy{Datasets: Datasets{Allow: []string{"logs-*", "metrics"}, Deny: []string{"*-pii"}}}
+	cases := map[string]bool{
+		"logs-api":  true,
+		"metrics":   true,
+		"logs-pii":  false,
+		"billing":   false,
+		"metrics-2": false,
+	}
+	for name, want := range cases {
+		if got := p.DatasetVisible(name); got != want {
+			t.Errorf("DatasetVisible(%q) = %v, want %v", name, got, want)
+		}
+	}
+}
+
+func TestWritableGlobs(t *testing.T) {
+	p := &Policy{WritablePaths: []string{"ingest/*", "_queries"}}
+	cases := map[string]bool{
+		"/ingest/logs/data.ndjson": true,
+		"ingest/logs":              false,
+		"_queries/x/apl":           true,
+		"/logs/q":                  false,
+	}
+	for name, want := range cases {
+		if got := p.Writable(name); got != want {
+			t.Errorf("Writable(%q) = %v, want %v", name, got, want)
+		}
+	}
+}
+
+func TestLoad(t *testing.T) {
+	dir := t.TempDir()
+	file := filepath.Join(dir, "policy.json")
+	if err := os.WriteFile(file, []byte(`{"writable":[],"datasets":{"deny":["secret"]}}`), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	p, err := Load(file)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if p.Writable("_queries/x/apl") {
+		t.Error("empty writable list should make the mount read-only")
+	}
+	if p.DatasetVisible("secret") {
+		t.Error("secret should be hidden")
+	}
+
+	if err := os.WriteFile(file, []byte(`{"owners":[{"path":"_queries","gid":7,"file_mode":"0640"}]}`), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	p, err = Load(file)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := p.Ownership("_queries/x/apl", Ownership{}); got != (Ownership{GID: 7, FileMode: 0o640}) {
+		t.Errorf("loaded owners rule: Ownership = %+v", got)
+	}
+	if err := os.WriteFile(file, []byte(`{"owners":[{"path":"_queries","file_mode":"rw"}]}`), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := Load(file); err == nil {
+		t.Error("expected error for malformed mode")
+	}
+	if err := os.WriteFile(file, []byte(`{"redact":[{"field":"email","action":"encrypt"}]}`), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := Load(file); err == nil {
+		t.Error("expected error for unknown redaction action")
+	}
+	if err := os.WriteFile(file, []byte(`{"columns":[{"dataset":"billing","deny":["card["]}]}`), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := Load(file); err == nil {
+		t.Error("expected error for malformed column pattern")
+	}
+
+	if err := os.WriteFile(file, []byte(`{"datasets":{"allow":["["]}}`), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := Load(file); err == nil {
+		t.Error("expected error for malformed glob")
+	}
+}
+
+func TestOwnership(t *testing.T) {
+	uid := uint32(2000)
+	p := &Policy{Owners: []Owner{
+		{Path: "_queries", UID: &uid, FileMode: 0o600},
+		{Path: "_queries/shared", DirMode: 0o777},
+	}}
+	base := Ownership{UID: 1000, GID: 1000, FileMode: 0o440}
+	cases := map[string]Ownership{
+		"logs/q/result.csv":     base,
+		"_queries":              {UID: 2000, GID: 1000, FileMode: 0o600},
+		"/_queries/x/apl":       {UID: 2000, GID: 1000, FileMode: 0o600},
+		"_queries/shared/apl":   {UID: 2000, GID: 1000, FileMode: 0o600, DirMode: 0o777},
+		"_queriesx/unmatched":   base,
+		"_queries/sharedx/file": {UID: 2000, GID: 1000, FileMode: 0o600},
+	}
+	for name, want := range cases {
+		if got := p.Ownership(name, base); got != want {
+			t.Errorf("Ownership(%q) = %+v, want %+v", name, got, want)
+		}
+	}
+	if got := (*Policy)(nil).Ownership("x", base); got != base {
+		t.Errorf("nil policy Ownership = %+v", got)
+	}
+}
+
+func TestLoadTenants(t *testing.T) {
+	file := filepath.Join(t.TempDir(), "tenants.json")
+	t.Setenv("PAYMENTS_TOKEN", "xapt-payments")
+	write := func(data string) {
+		t.Helper()
+		if err := os.WriteFile(file, []byte(data), 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	write(`[
+		{"path": "/teams/payments/", "token_env": "PAYMENTS_TOKEN", "default_range": "7d",
+		 "datasets": {"allow": ["payments-*"]}, "redact": [{"field": "card", "action": "mask"}]},
+		{"path": "teams/search", "token": "xapt-search", "writable": []}
+	]`)
+	tenants, err := LoadTenants(file)
+	if err != nil {
+		t.Fatal(err)
+	}
+	payments, search := tenants[0], tenants[1]
+	if payments.Name() != "payments" || payments.AxiomToken() != "xapt-payments" || payments.DefaultRange != "7d" {
+		t.Errorf("payments = %+v", payments)
+	}
+	if !payments.DatasetVisible("payments-api") || payments.DatasetVisible("search-api") || len(payments.Redact) != 1 {
+		t.Errorf("payments policy = %+v", payments.Policy)
+	}
+	if !payments.Writable("_queries/x/apl") || search.Writable("_queries/x/apl") {
+		t.Error("tenants without a writable list should get the default, [] none")
+	}
+
+	for _, bad := range []string{
+		`[{"path": "teams/a", "token": "t"}, {"path": "teams/a/b", "token": "t"}]`,
+		`[{"path": "teams/a", "token": "t"}, {"path": "other/a", "token": "t"}]`,
+		`[{"path": "teams/a"}]`,
+		`[{"path": "/", "token": "t"}]`,
+		`[{"path": "teams/*", "token": "t"}]`,
+		`[{"path": "teams/a", "token": "t", "default_range": "soon"}]`,
+	} {
+		write(bad)
+		if _, err := LoadTenants(file); err == nil {
+			t.Errorf("LoadTenants(%s) should fail", bad)
+		}
+	}
+}
@@ -0,0 +1,304 @@
+// Package postprocess transforms encoded results before they are served
+// and cached: a jq filter over ndjson, an anonymizer over csv. Rules pick
+// the results they apply to by path and format, and run either an external
+// command, fed the result on stdin, or a Processor compiled in with
+// Register.
+//
+// A nil *Pipeline processes nothing.
+package postprocess
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"path"
+	"slices"
+	"strings"
+	"sync"
+)
+
+// Processor transforms one encoded result, read from in, into out.
+type Processor interface {
+	Process(ctx context.Context, format string, in io.Reader, out io.Writer) error
+}
+
+// ProcessorFunc adapts a function to Processor.
+type ProcessorFunc func(ctx context.Context, format string, in io.Reader, out io.Writer) error
+
+func (f ProcessorFunc) Process(ctx context.Context, format string, in io.Reader, out io.Writer) error {
+	return f(ctx, format, in, out)
+}
+
+var (
+	registryMu sync.Mutex
+	registry   = map[string]Processor{}
+)
+
+// Register makes p available to rules as "plugin": name. Builds embedding
+// axiom-fs call it from an init function.
+func Register(name string, p Processor) {
+	registryMu.Lock()
+	defer registryMu.Unlock()
+	registry[name] = p
+}
+
+func registered(name string) (Processor, bool) {
+	registryMu.Lock()
+	defer registryMu.Unlock()
+	p, ok := registry[name]
+	return p, ok
+}
+
+// Rule runs Command, or the registered Plugin, over the results read under
+// Path in one of Formats.
+type Rule struct {
+	// Name identifies the rule in errors and cache keys.
+	Name string `json:"name"`
+	// Path is a slash path relative to the mount root; the rule applies to
+	// results read at or below it. Segments may be globs, e.g. */q.
+	Path string `json:"path"`
+	// Formats limits the rule to these result formats; empty is all.
+	Formats []string `json:"formats,omitempty"`
+	// Command is the program and arguments run per result, with the
+	// result on stdin and AXIOM_FS_FORMAT and AXIOM_FS_PATH set.
+	Command []string `json:"command,omitempty"`
+	// Plugin names a Processor registered with Register.
+	Plugin string `json:"plugin,omitempty"`
+
+	processor Processor
+	key       string
+}
+
+// Pipeline is the configured rules, applied in order.
+type Pipeline struct {
+	rules []Rule
+}
+
+// Load reads a JSON list of rules. An empty path yields nil.
+func Load(file string) (*Pipeline, error) {
+	if file == "" {
+		return nil, nil
+	}
+	data, err := os.ReadFile(file)
+	if err != nil {
+		return nil, err
+	}
+	var rules []Rule
+	if err := json.Unmarshal(data, &rules); err != nil {
+		return nil, fmt.Errorf("parse post-processors %s: %w", file, err)
+	}
+	p, err := New(rules)
+	if err != nil {
+		return nil, fmt.Errorf("post-processors %s: %w", file, err)
+	}
+	return p, nil
+}
+
+// New validates rules. It returns nil when there are none.
+func New(rules []Rule) (*Pipeline, error) {
+	if len(rules) == 0 {
+		return nil, nil
+	}
+	names := map[string]bool{}
+	for i := range rules {
+		rule := &rules[i]
+		if rule.Name == "" || names[rule.Name] {
+			return nil, fmt.Errorf("rule %d: name %q is empty or repeated", i, rule.Name)
+		}
+		names[rule.Name] = true
+		if _, err := path.Match(rule.Path, ""); err != nil {
+			return nil, fmt.Errorf("rule %s: invalid path %q: %w", rule.Name, rule.Path, err)
+		}
+		switch {
+		case len(rule.Command) > 0 && rule.Plugin == "":
+			rule.processor = command(rule.Command)
+		case len(rule.Command) == 0 && rule.Plugin != "":
+			p, ok := registered(rule.Plugin)
+			if !ok {
+				return nil, fmt.Errorf("rule %s: no plugin registered as %q", rule.Name, rule.Plugin)
+			}
+			rule.processor = p
+		default:
+			return nil, fmt.Errorf("rule %s: want one of command or plugin", rule.Name)
+		}
+		// The key changes with the rule, so results processed by an older
+		// version of it are not served from the cache.
+		def, _ := json.Marshal(rule)
+		sum := sha256.Sum256(def)
+		rule.key = rule.Name + "@" + hex.EncodeToString(sum[:4])
+	}
+	return &Pipeline{rules: rules}, nil
+}
+
+// Chain is the rules that apply to one result, in order.
+type Chain []Rule
+
+// Match returns the rules applying to the result read at name, a slash
+// path relative to the mount root, in format.
+func (p *Pipeline) Match(name, format string) Chain {
+	if p == nil {
+		return nil
+	}
+	segments := splitPath(name)
+	var chain Chain
+	for _, rule := range p.rules {
+		if len(rule.Formats) > 0 && !slices.Contains(rule.Formats, format) {
+			continue
+		}
+		prefix := splitPath(rule.Path)
+		if len(segments) < len(prefix) || !matchSegments(prefix, segments[:len(prefix)]) {
+			continue
+		}
+		chain = append(chain, rule)
+	}
+	return chain
+}
+
+// Key identifies the chain's rules for cache keys; empty for no rules.
+func (c Chain) Key() string {
+	keys := make([]string, len(c))
+	for i, rule := range c {
+		keys[i] = rule.key
+	}
+	return strings.Join(keys, ",")
+}
+
+// Bytes runs the chain over data.
+func (c Chain) Bytes(ctx context.Context, name, format string, data []byte) ([]byte, error) {
+	var out bytes.Buffer
+	s := c.Start(ctx, name, format, &out)
+	_, err := s.Write(data)
+	if err := s.Close(err); err != nil {
+		return nil, err
+	}
+	return out.Bytes(), nil
+}
+
+// Stream feeds what is written to it through a chain.
+type Stream struct {
+	in   *io.PipeWriter
+	errs []error
+	wg   sync.WaitGroup
+}
+
+// Start runs the chain over what is written to the returned Stream, the
+// last rule writing to out, until the Stream is closed.
+func (c Chain) Start(ctx context.Context, name, format string, out io.Writer) *Stream {
+	s := &Stream{errs: make([]error, len(c))}
+	ctx = withPath(ctx, name)
+	r, w := io.Pipe()
+	s.in = w
+	for i, rule := range c {
+		in := r
+		var next *io.PipeWriter
+		var dst io.Writer = out
+		if i < len(c)-1 {
+			r, next = io.Pipe()
+			dst = next
+		}
+		s.wg.Add(1)
+		go func() {
+			defer s.wg.Done()
+			err := rule.processor.Process(ctx, format, in, dst)
+			if err == nil {
+				// Let the stage before finish even if the processor
+				// stopped reading early, as head does.
+				_, err = io.Copy(io.Discard, in)
+			}
+			if err != nil {
+				err = fmt.Errorf("post-processor %s: %w", rule.Name, err)
+				s.errs[i] = err
+			}
+			in.CloseWithError(err)
+			if next != nil {
+				next.CloseWithError(err)
+			}
+		}()
+	}
+	return s
+}
+
+func (s *Stream) Write(p []byte) (int, error) {
+	return s.in.Write(p)
+}
+
+// Close ends the input, failed with err when it is not nil, and waits for
+// every rule. A rule's error comes before err, which it may have caused.
+func (s *Stream) Close(err error) error {
+	_ = s.in.CloseWithError(err)
+	s.wg.Wait()
+	for _, e := range s.errs {
+		if e != nil && !errors.Is(e, err) {
+			return e
+		}
+	}
+	return err
+}
+
+type pathKey struct{}
+
+func withPath(ctx context.Context, name string) context.Context {
+	return context.WithValue(ctx, pathKey{}, name)
+}
+
+// PathFrom returns the path of the result being processed, for Processors
+// that treat paths differently.
+func PathFrom(ctx context.Context) string {
+	name, _ := ctx.Value(pathKey{}).(string)
+	return name
+}
+
+// command runs an external program per result.
+type command []string
+
+func (c command) Process(ctx context.Context, format string, in io.Reader, out io.Writer) error {
+	cmd := exec.CommandContext(ctx, c[0], c[1:]...)
+	cmd.Env = append(os.Environ(), "AXIOM_FS_FORMAT="+format, "AXIOM_FS_PATH="+PathFrom(ctx))
+	cmd.Stdin, cmd.Stdout = in, out
+	var stderr bytes.Buffer
+	cmd.Stderr = &limitedBuffer{buf: &stderr, max: 4096}
+	if err := cmd.Run(); err != nil {
+		if msg := strings.TrimSpace(stderr.String()); msg != "" {
+			return fmt.Errorf("%w: %s", err, msg)
+		}
+		return err
+	}
+	return nil
+}
+
+// limitedBuffer keeps the first max bytes written, for error messages.
+type limitedBuffer struct {
+	buf *bytes.Buffer
+	max int
+}
+
+func (l *limitedBuffer) Write(p []byte) (int, error) {
+	if room := l.max - l.buf.Len(); room > 0 {
+		l.buf.Write(p[:min(len(p), room)])
+	}
+	return len(p), nil
+}
+
+func splitPath(name string) []string {
+	name = strings.Trim(path.Clean("/"+name), "/")
+	if name == "" {
+		return nil
+	}
+	return strings.Split(name, "/")
+}
+
+func matchSegments(patterns, segments []string) bool {
+	for i, pattern := range patterns {
+		if ok, _ := path.Match(pattern, segments[i]); !ok {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,122 @@
+package postprocess
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"io"
+	"os/exec"
+	"strings"
+	"testing"
+)
+
+func TestMatch(t *testing.T) {
+	p, err := New([]Rule{
+		{Name: "jq", Path: "logs/q", Formats: []string{"ndjson"}, Command: []string{"cat"}},
+		{Name: "anon", Path: "*/q", Command: []string{"cat"}},
+		{Name: "saved", Path: "_queries/pii-*", Command: []string{"cat"}},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	cases := []struct {
+		name, format, want string
+	}{
+		{"/logs/q/where/a/result.ndjson", "ndjson", "jq,anon"},
+		{"/logs/q/result.csv", "csv", "anon"},
+		{"/metrics/q/result.ndjson", "ndjson", "anon"},
+		{"/logs/sample.ndjson", "ndjson", ""},
+		{"/_queries/pii-users/result.csv", "csv", "saved"},
+		{"/_queries/errors/result.csv", "csv", ""},
+		{"", "csv", ""},
+	}
+	for _, tc := range cases {
+		var names []string
+		for _, rule := range p.Match(tc.name, tc.format) {
+			names = append(names, rule.Name)
+		}
+		if got := strings.Join(names, ","); got != tc.want {
+			t.Errorf("Match(%q, %s) = %s, want %s", tc.name, tc.format, got, tc.want)
+		}
+	}
+	if chain := (*Pipeline)(nil).Match("/logs/q/result.csv", "csv"); chain != nil || chain.Key() != "" {
+		t.Errorf("nil pipeline matched %v", chain)
+	}
+
+	// The key follows the rule's definition, not just its name.
+	other, _ := New([]Rule{{Name: "anon", Path: "*/q", Command: []string{"cat", "-u"}}})
+	if a, b := p.Match("/x/q/result.csv", "csv").Key(), other.Match("/x/q/result.csv", "csv").Key(); a == b || !strings.HasPrefix(a, "anon@") {
+		t.Errorf("keys %q and %q", a, b)
+	}
+}
+
+func TestNew(t *testing.T) {
+	Register("test-noop", ProcessorFunc(func(ctx context.Context, format string, in io.Reader, out io.Writer) error {
+		_, err := io.Copy(out, in)
+		return err
+	}))
+	if p, err := New(nil); p != nil || err != nil {
+		t.Errorf("New(nil) = %v, %v", p, err)
+	}
+	if _, err := New([]Rule{{Name: "a", Path: "logs", Plugin: "test-noop"}}); err != nil {
+		t.Error(err)
+	}
+	for _, rules := range [][]Rule{
+		{{Path: "logs", Command: []string{"cat"}}},
+		{{Name: "a", Path: "logs", Command: []string{"cat"}}, {Name: "a", Path: "x", Command: []string{"cat"}}},
+		{{Name: "a", Path: "[", Command: []string{"cat"}}},
+		{{Name: "a", Path: "logs"}},
+		{{Name: "a", Path: "logs", Command: []string{"cat"}, Plugin: "test-noop"}},
+		{{Name: "a", Path: "logs", Plugin: "missing"}},
+	} {
+		if _, err := New(rules); err == nil {
+			t.Errorf("New(%+v) should fail", rules)
+		}
+	}
+}
+
+func TestChain(t *testing.T) {
+	for _, name := range []string{"tr", "head", "sh"} {
+		if _, err := exec.LookPath(name); err != nil {
+			t.Skip(err)
+		}
+	}
+	ctx := context.Background()
+	p, err := New([]Rule{
+		{Name: "upper", Path: "logs", Command: []string{"tr", "a-z", "A-Z"}},
+		{Name: "env", Path: "logs", Command: []string{"sh", "-c", `cat; echo "$AXIOM_FS_FORMAT $AXIOM_FS_PATH"`}},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	got, err := p.Match("/logs/q/result.csv", "csv").Bytes(ctx, "/logs/q/result.csv", "csv", []byte("a,b\n"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(got) != "A,B\ncsv /logs/q/result.csv\n" {
+		t.Errorf("chain output = %q", got)
+	}
+
+	// A processor that stops reading early does not fail the writer.
+	head, _ := New([]Rule{{Name: "head", Path: "logs", Command: []string{"head", "-c", "3"}}})
+	got, err = head.Match("/logs", "csv").Bytes(ctx, "/logs", "csv", bytes.Repeat([]byte("x"), 1<<20))
+	if err != nil || string(got) != "xxx" {
+		t.Errorf("head = %q, %v", got, err)
+	}
+
+	fail, _ := New([]Rule{{Name: "fail", Path: "logs", Command: []string{"sh", "-c", "echo bad input >&2; exit 3"}}})
+	_, err = fail.Match("/logs", "csv").Bytes(ctx, "/logs", "csv", []byte("a\n"))
+	if err == nil || !strings.Contains(err.Error(), "post-processor fail") || !strings.Contains(err.Error(), "bad input") {
+		t.Errorf("failing command: %v", err)
+	}
+
+	// An input error is returned as is.
+	upper, _ := New([]Rule{{Name: "upper", Path: "logs", Command: []string{"tr", "a-z", "A-Z"}}})
+	var out bytes.Buffer
+	s := upper.Match("/logs", "csv").Start(ctx, "/logs", "csv", &out)
+	_, _ = s.Write([]byte("partial"))
+	encodeErr := errors.New("encode failed")
+	if err := s.Close(encodeErr); !errors.Is(err, encodeErr) {
+		t.Errorf("Close(encodeErr) = %v", err)
+	}
+}
@@ -175,6 +175,13 @@ func Render(preset Preset, dataset string, defaultRange string) string {
 	return replacer.Replace(preset.Template)
 }
 
+// RenderSource renders preset with its ['${DATASET}'] source replaced by an
+// arbitrary tabular expression, such as a union for dataset aliases.
+func RenderSource(preset Preset, source string, defaultRange string) string {
+	preset.Template = strings.Replace(preset.Template, "['${DATASET}']", source, 1)
+	return Render(preset, "", defaultRange)
+}
+
 func fmtRange(defaultRange string) string {
 	return "ago(" + defaultRange + ") .. now()"
 }
@@ -0,0 +1,106 @@
+package query
+
+import (
+	"bytes"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/axiomhq/axiom-fs/internal/apl"
+)
+
+// autoRangeSteps are the windows tried, in order, after the default range
+// comes back empty. Steps beyond the executor's max range are skipped.
+var autoRangeSteps = []time.Duration{
+	6 * time.Hour,
+	24 * time.Hour,
+	3 * 24 * time.Hour,
+	7 * 24 * time.Hour,
+	30 * 24 * time.Hour,
+}
+
+// withAutoRange runs attempt with apl and then with progressively wider
+// ago() windows while attempt reports an empty result. Only queries using
+// the default window defaultRange are widened; anything else runs exactly
+// once.
+func (e *Executor) withAutoRange(apl, defaultRange string, attempt func(apl, rng string) (empty bool, err error)) error {
+	current := rangeClause(defaultRange)
+	if !strings.Contains(apl, current) {
+		_, err := attempt(apl, "")
+		return err
+	}
+	empty, err := attempt(apl, defaultRange)
+	if err != nil || !empty {
+		return err
+	}
+	for _, rng := range e.widerRanges(defaultRange) {
+		empty, err = attempt(strings.Replace(apl, current, rangeClause(rng), 1), rng)
+		if err != nil || !empty {
+			return err
+		}
+	}
+	return nil
+}
+
+// widerRanges lists the APL timespans to retry with, ending at the max range.
+func (e *Executor) widerRanges(defaultRange string) []string {
+	start, err := apl.ParseTimespan(defaultRange)
+	if err != nil {
+		return nil
+	}
+	var ranges []string
+	last := start
+	for _, step := range autoRangeSteps {
+		if step <= last {
+			continue
+		}
+		if e.maxRange > 0 && step > e.maxRange {
+			break
+		}
+		ranges = append(ranges, formatTimespan(step))
+		last = step
+	}
+	if e.maxRange > last {
+		ranges = append(ranges, formatTimespan(e.maxRange))
+	}
+	return ranges
+}
+
+func rangeClause(rng string) string {
+	return "_time between (ago(" + rng + ") .. now())"
+}
+
+// formatTimespan renders d as an APL timespan literal using the largest
+// whole unit (days, hours or minutes).
+func formatTimespan(d time.Duration) string {
+	switch {
+	case d%(24*time.Hour) == 0:
+		return strconv.FormatInt(int64(d/(24*time.Hour)), 10) + "d"
+	case d%time.Hour == 0:
+		return strconv.FormatInt(int64(d/time.Hour), 10) + "h"
+	default:
+		return strconv.FormatInt(int64(d/time.Minute), 10) + "m"
+	}
+}
+
+// isEmptyResult reports whether encoded output holds no rows. Workbooks and
+// charts are never considered empty.
+func isEmptyResult(data []byte, format string) bool {
+	trimmed := bytes.TrimSpace(data)
+	switch format {
+	case "json":
+		return len(trimmed) == 0 || bytes.Equal(trimmed, []byte("[]"))
+	case "csv", "tsv":
+		// A header line alone means no rows.
+		return !bytes.Contains(trimmed, []byte("\n"))
+	case "md":
+		// The table ends at the first blank line; its header and separator
+		// lines alone mean no rows.
+		table, _, _ := bytes.Cut(trimmed, []byte("\n\n"))
+		return bytes.Count(table, []byte("\n|")) < 2
+	case "xlsx", "vl.json", "svg":
+		return false
+	default:
+		return len(trimmed) == 0
+	}
+}
@@ -0,0 +1,136 @@
+package query
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"syscall"
+)
+
+// ErrResultTooLarge is returned for results over the WithMaxResultBytes
+// cap in formats that cannot be cut short, such as json and xlsx.
+var ErrResultTooLarge = fmt.Errorf("result larger than -max-result-bytes: %w", syscall.EFBIG)
+
+// errResultCapped stops encoding once a line format reached the cap.
+var errResultCapped = errors.New("result capped")
+
+// WithMaxResultBytes stops encoding results past n bytes, so a runaway
+// query neither fills the temp dir nor floods a reader. ndjson, csv, tsv
+// and md results are cut at the last whole line and end with a marker;
+// other formats fail with ErrResultTooLarge. Zero, the default, is no cap.
+func WithMaxResultBytes(n int) Option {
+	return func(e *Executor) { e.maxResultBytes = n }
+}
+
+// cappedMarker ends a result cut at max bytes.
+func cappedMarker(format string, max int) string {
+	line := fmt.Sprintf("TRUNCATED: result cut at %d bytes by -max-result-bytes", max)
+	if format == "md" {
+		return "\n<!-- " + line + " -->\n"
+	}
+	return "# " + line + "\n"
+}
+
+// cutsLines reports whether format is line-based, so a capped result can
+// end at a whole line.
+func cutsLines(format string) bool {
+	switch format {
+	case "ndjson", "csv", "tsv", "md":
+		return true
+	default:
+		return false
+	}
+}
+
+// cappedWriter passes at most max bytes to w. Line formats are written a
+// whole line at a time, holding back a partial line until it ends, and
+// cut at the last line that fits.
+type cappedWriter struct {
+	w      io.Writer
+	format string
+	max    int
+	// written counts the bytes passed to w.
+	written int
+	tail    []byte
+	capped  bool
+	// quiet leaves the marker out; see WithInlineMarkers.
+	quiet bool
+}
+
+// newCappedWriter caps w at max bytes; zero max returns w's writes as is.
+func newCappedWriter(w io.Writer, format string, max int, quiet bool) *cappedWriter {
+	return &cappedWriter{w: w, format: format, max: max, quiet: quiet}
+}
+
+func (c *cappedWriter) Write(p []byte) (int, error) {
+	if c.capped {
+		return 0, errResultCapped
+	}
+	if c.max <= 0 {
+		return c.w.Write(p)
+	}
+	if !cutsLines(c.format) {
+		if c.written+len(p) > c.max {
+			c.capped = true
+			return 0, ErrResultTooLarge
+		}
+		n, err := c.w.Write(p)
+		c.written += n
+		return n, err
+	}
+	c.tail = append(c.tail, p...)
+	end := bytes.LastIndexByte(c.tail, '\n') + 1
+	room := c.max - c.written
+	if end > room {
+		end = bytes.LastIndexByte(c.tail[:room], '\n') + 1
+		c.capped = true
+	} else if len(c.tail) > room {
+		// The partial line can no longer fit.
+		c.capped = true
+	}
+	if end > 0 {
+		n, err := c.w.Write(c.tail[:end])
+		c.written += n
+		if err != nil {
+			return 0, err
+		}
+		c.tail = append(c.tail[:0], c.tail[end:]...)
+	}
+	if c.capped {
+		return 0, errResultCapped
+	}
+	return len(p), nil
+}
+
+// finish writes the held-back partial line, or the marker when the result
+// was cut and c is not quiet. It reports whether it was.
+func (c *cappedWriter) finish() (bool, error) {
+	if !c.capped && len(c.tail) > 0 {
+		n, err := c.w.Write(c.tail)
+		c.written += n
+		c.tail = nil
+		if err != nil {
+			return false, err
+		}
+	}
+	if !c.capped || c.quiet {
+		return c.capped, nil
+	}
+	_, err := io.WriteString(c.w, cappedMarker(c.format, c.max))
+	return true, err
+}
+
+// capBytes cuts an encoded result the way cappedWriter does.
+func capBytes(data []byte, format string, max int, quiet bool) ([]byte, bool, error) {
+	if max <= 0 || len(data) <= max {
+		return data, false, nil
+	}
+	var buf bytes.Buffer
+	c := newCappedWriter(&buf, format, max, quiet)
+	if _, err := c.Write(data); err != nil && !errors.Is(err, errResultCapped) {
+		return nil, false, err
+	}
+	capped, err := c.finish()
+	return buf.Bytes(), capped, err
+}
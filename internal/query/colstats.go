@@ -0,0 +1,220 @@
+package query
+
+import (
+	"bytes"
+	"container/heap"
+	"context"
+	"encoding/csv"
+	"hash/maphash"
+	"math"
+	"strconv"
+
+	"github.com/axiomhq/axiom-fs/internal/axiomclient"
+)
+
+// distinctSketchSize is how many value hashes a column keeps to estimate
+// its distinct count. Columns with fewer distinct values are counted
+// exactly.
+const distinctSketchSize = 1024
+
+// ColumnStats summarizes one result column.
+type ColumnStats struct {
+	Name string `json:"name"`
+	Type string `json:"type,omitempty"`
+	// Count is the number of non-null values, Nulls the rest.
+	Count int64 `json:"count"`
+	Nulls int64 `json:"nulls"`
+	// Distinct is exact below distinctSketchSize distinct values and an
+	// estimate above.
+	Distinct int64  `json:"distinct"`
+	Min      string `json:"min,omitempty"`
+	Max      string `json:"max,omitempty"`
+	// Avg is set when every non-null value is a number.
+	Avg *float64 `json:"avg,omitempty"`
+}
+
+// columnStats summarizes each column of the table the encoders write,
+// reading every value once.
+func columnStats(result *axiomclient.QueryResult) []ColumnStats {
+	if len(result.Tables) == 0 {
+		return []ColumnStats{}
+	}
+	table := result.Tables[0]
+	acc := newColumnStatsAcc(table.Fields)
+	for i := range table.Fields {
+		if i < len(table.Columns) {
+			for _, value := range table.Columns[i] {
+				acc.columns[i].add(value)
+			}
+		}
+	}
+	return acc.stats()
+}
+
+// columnStatsAcc accumulates ColumnStats a value at a time, so streamed
+// results get the same stats as those held in memory.
+type columnStatsAcc struct {
+	seed    maphash.Seed
+	columns []columnAcc
+}
+
+func newColumnStatsAcc(fields []axiomclient.QueryField) *columnStatsAcc {
+	acc := &columnStatsAcc{seed: maphash.MakeSeed(), columns: make([]columnAcc, len(fields))}
+	for i, field := range fields {
+		acc.columns[i] = columnAcc{seed: acc.seed, stats: ColumnStats{Name: field.Name, Type: field.Type}, numeric: true}
+	}
+	return acc
+}
+
+// addRow adds one value per field; missing values are skipped.
+func (a *columnStatsAcc) addRow(row []any) {
+	for i := range a.columns {
+		if i < len(row) {
+			a.columns[i].add(row[i])
+		}
+	}
+}
+
+func (a *columnStatsAcc) stats() []ColumnStats {
+	stats := make([]ColumnStats, len(a.columns))
+	for i := range a.columns {
+		stats[i] = a.columns[i].finish()
+	}
+	return stats
+}
+
+type columnAcc struct {
+	seed             maphash.Seed
+	stats            ColumnStats
+	sketch           kmvSketch
+	sum              float64
+	numeric          bool
+	minNum, maxNum   float64
+	minText, maxText string
+}
+
+func (c *columnAcc) add(value any) {
+	s := &c.stats
+	if value == nil {
+		s.Nulls++
+		return
+	}
+	text := stringify(value)
+	c.sketch.add(maphash.String(c.seed, text))
+	n, ok := axiomclient.Float64(value)
+	if s.Count == 0 {
+		c.minNum, c.maxNum, c.minText, c.maxText = n, n, text, text
+	}
+	s.Count++
+	c.numeric = c.numeric && ok
+	if c.numeric {
+		c.sum += n
+		c.minNum, c.maxNum = math.Min(c.minNum, n), math.Max(c.maxNum, n)
+	}
+	c.minText, c.maxText = min(c.minText, text), max(c.maxText, text)
+}
+
+func (c *columnAcc) finish() ColumnStats {
+	s := c.stats
+	s.Distinct = c.sketch.estimate()
+	switch {
+	case s.Count == 0:
+	case c.numeric:
+		avg := c.sum / float64(s.Count)
+		s.Min, s.Max, s.Avg = formatNumber(c.minNum), formatNumber(c.maxNum), &avg
+	default:
+		s.Min, s.Max = c.minText, c.maxText
+	}
+	return s
+}
+
+func formatNumber(n float64) string {
+	return strconv.FormatFloat(n, 'f', -1, 64)
+}
+
+// encodeColumnStats renders stats as result.stats.csv, one row per column.
+func encodeColumnStats(stats []ColumnStats) []byte {
+	var buf bytes.Buffer
+	w := csv.NewWriter(&buf)
+	_ = w.Write([]string{"column", "type", "count", "nulls", "distinct", "min", "max", "avg"})
+	for _, s := range stats {
+		avg := ""
+		if s.Avg != nil {
+			avg = formatNumber(*s.Avg)
+		}
+		_ = w.Write([]string{
+			s.Name, s.Type,
+			strconv.FormatInt(s.Count, 10), strconv.FormatInt(s.Nulls, 10), strconv.FormatInt(s.Distinct, 10),
+			s.Min, s.Max, avg,
+		})
+	}
+	w.Flush()
+	return buf.Bytes()
+}
+
+// ResultStats returns result.stats.csv for a result: per-column counts,
+// distinct estimates, min/max and averages, as computed when the result was
+// encoded. A cached result without stored stats is executed again.
+func (e *Executor) ResultStats(ctx context.Context, apl, format string, opts ExecOptions) ([]byte, error) {
+	meta, err := e.ResultMeta(ctx, apl, format, opts)
+	if err != nil {
+		return nil, err
+	}
+	if meta.Columns == nil {
+		opts.refresh = true
+		if meta, err = e.ResultMeta(ctx, apl, format, opts); err != nil {
+			return nil, err
+		}
+	}
+	return encodeColumnStats(meta.Columns), nil
+}
+
+// kmvSketch keeps the smallest distinctSketchSize distinct hashes seen
+// (k minimum values), from which the distinct count is estimated.
+type kmvSketch struct {
+	hashes maxHeap
+	seen   map[uint64]bool
+}
+
+func (k *kmvSketch) add(h uint64) {
+	if k.seen == nil {
+		k.seen = map[uint64]bool{}
+	}
+	if k.seen[h] {
+		return
+	}
+	if len(k.hashes) < distinctSketchSize {
+		k.seen[h] = true
+		heap.Push(&k.hashes, h)
+		return
+	}
+	if h >= k.hashes[0] {
+		return
+	}
+	delete(k.seen, k.hashes[0])
+	k.seen[h] = true
+	k.hashes[0] = h
+	heap.Fix(&k.hashes, 0)
+}
+
+func (k *kmvSketch) estimate() int64 {
+	if len(k.hashes) < distinctSketchSize {
+		return int64(len(k.hashes))
+	}
+	// The k-th smallest of n uniform hashes sits near k/n of the range.
+	fraction := float64(k.hashes[0]) / math.MaxUint64
+	return int64(math.Round(float64(distinctSketchSize-1) / fraction))
+}
+
+type maxHeap []uint64
+
+func (h maxHeap) Len() int           { return len(h) }
+func (h maxHeap) Less(i, j int) bool { return h[i] > h[j] }
+func (h maxHeap) Swap(i, j int)      { h[i], h[j] = h[j], h[i] }
+func (h *maxHeap) Push(x any)        { *h = append(*h, x.(uint64)) }
+func (h *maxHeap) Pop() any {
+	old := *h
+	x := old[len(old)-1]
+	*h = old[:len(old)-1]
+	return x
+}
@@ -0,0 +1,71 @@
+package query
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/axiomhq/axiom-fs/internal/axiomclient"
+)
+
+// projectColumns returns result with the first table narrowed to columns.
+// Unknown column names are an error listing the available ones.
+func projectColumns(result *axiomclient.QueryResult, columns []string) (*axiomclient.QueryResult, error) {
+	if len(columns) == 0 {
+		return result, nil
+	}
+	if len(result.Tables) == 0 {
+		// Without a table the columns asked for are the whole schema, so
+		// csv and tsv still get a header.
+		empty := axiomclient.QueryTable{Fields: make([]axiomclient.QueryField, len(columns))}
+		for i, column := range columns {
+			empty.Fields[i] = axiomclient.QueryField{Name: column}
+		}
+		out := *result
+		out.Tables = []axiomclient.QueryTable{empty}
+		return &out, nil
+	}
+	table := result.Tables[0]
+	indexes, err := columnIndexes(table, columns)
+	if err != nil {
+		return nil, err
+	}
+
+	projected := axiomclient.QueryTable{
+		Name:    table.Name,
+		Fields:  make([]axiomclient.QueryField, 0, len(columns)),
+		Columns: make([][]any, 0, len(columns)),
+	}
+	for _, i := range indexes {
+		projected.Fields = append(projected.Fields, table.Fields[i])
+		if i < len(table.Columns) {
+			projected.Columns = append(projected.Columns, table.Columns[i])
+		} else {
+			projected.Columns = append(projected.Columns, nil)
+		}
+	}
+
+	out := *result
+	out.Tables = append([]axiomclient.QueryTable{projected}, result.Tables[1:]...)
+	return &out, nil
+}
+
+// columnIndexes returns the field index of each of columns in table.
+func columnIndexes(table axiomclient.QueryTable, columns []string) ([]int, error) {
+	index := make(map[string]int, len(table.Fields))
+	for i, f := range table.Fields {
+		index[f.Name] = i
+	}
+	indexes := make([]int, len(columns))
+	for j, name := range columns {
+		i, ok := index[name]
+		if !ok {
+			available := make([]string, len(table.Fields))
+			for k, f := range table.Fields {
+				available[k] = f.Name
+			}
+			return nil, fmt.Errorf("unknown column %q (available: %s)", name, strings.Join(available, ", "))
+		}
+		indexes[j] = i
+	}
+	return indexes, nil
+}
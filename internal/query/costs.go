@@ -0,0 +1,121 @@
+package query
+
+import (
+	"regexp"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/axiomhq/axiom-fs/internal/axiomclient"
+)
+
+// Unlabeled is the cost label of queries that carry none.
+const Unlabeled = "unlabeled"
+
+// costRetention is how many days of costs are kept, counting today.
+const costRetention = 31
+
+// labelComment matches a `// label: <name>` line, which labels a saved or
+// raw query for cost attribution.
+var labelComment = regexp.MustCompile(`(?m)^\s*//\s*label:\s*(\S+)\s*$`)
+
+// CostUsage is what the queries of one label cost Axiom on one UTC day.
+type CostUsage struct {
+	Label   string `json:"label"`
+	Day     string `json:"day"`
+	Queries int64  `json:"queries"`
+	Errors  int64  `json:"errors"`
+	// RowsExamined, RowsMatched and BlocksExamined sum the query status
+	// Axiom reports.
+	RowsExamined   int64 `json:"rows_examined"`
+	RowsMatched    int64 `json:"rows_matched"`
+	BlocksExamined int64 `json:"blocks_examined"`
+	// AxiomElapsed sums the elapsedTime of the query status, in Axiom's
+	// unit; ElapsedSeconds is the wall time the mount waited.
+	AxiomElapsed   int64   `json:"axiom_elapsed"`
+	ElapsedSeconds float64 `json:"elapsed_seconds"`
+	RowsReturned   int64   `json:"rows_returned"`
+}
+
+type costKey struct {
+	label string
+	day   string
+}
+
+// costTracker aggregates every query sent to Axiom per label and day.
+type costTracker struct {
+	mu    sync.Mutex
+	usage map[costKey]*CostUsage
+}
+
+// costLabel is opts.Label, else the label comment in apl, else Unlabeled.
+func costLabel(apl string, opts ExecOptions) string {
+	if opts.Label != "" {
+		return opts.Label
+	}
+	if m := labelComment.FindStringSubmatch(apl); m != nil {
+		return m[1]
+	}
+	return Unlabeled
+}
+
+// record counts one query; rows is how many it returned.
+func (c *costTracker) record(label string, start time.Time, elapsed time.Duration, result *axiomclient.QueryResult, rows int64, err error) {
+	day := start.UTC().Format(time.DateOnly)
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.usage == nil {
+		c.usage = map[costKey]*CostUsage{}
+	}
+	key := costKey{label: label, day: day}
+	u, ok := c.usage[key]
+	if !ok {
+		c.prune(start)
+		u = &CostUsage{Label: label, Day: day}
+		c.usage[key] = u
+	}
+	u.Queries++
+	u.ElapsedSeconds += elapsed.Seconds()
+	if err != nil || result == nil {
+		u.Errors++
+		return
+	}
+	u.RowsExamined += result.Status.RowsExamined
+	u.RowsMatched += result.Status.RowsMatched
+	u.BlocksExamined += result.Status.BlocksExamined
+	u.AxiomElapsed += result.Status.ElapsedTime
+	u.RowsReturned += rows
+}
+
+// prune drops days older than costRetention.
+func (c *costTracker) prune(now time.Time) {
+	oldest := now.UTC().AddDate(0, 0, 1-costRetention).Format(time.DateOnly)
+	for key := range c.usage {
+		if key.day < oldest {
+			delete(c.usage, key)
+		}
+	}
+}
+
+func (c *costTracker) snapshot() []CostUsage {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	out := make([]CostUsage, 0, len(c.usage))
+	for _, u := range c.usage {
+		out = append(out, *u)
+	}
+	sort.Slice(out, func(i, j int) bool {
+		if out[i].Day != out[j].Day {
+			return out[i].Day > out[j].Day
+		}
+		return out[i].Label < out[j].Label
+	})
+	return out
+}
+
+// Costs reports the queries sent to Axiom per label and day, newest day
+// first, for /_status/costs.json. Cache hits cost nothing and are not
+// counted.
+func (e *Executor) Costs() []CostUsage {
+	return e.costs.snapshot()
+}
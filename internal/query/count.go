@@ -0,0 +1,67 @@
+package query
+
+import (
+	"context"
+	"strconv"
+
+	"github.com/axiomhq/axiom-fs/internal/apl"
+)
+
+// countKey is the cache key holding the row count of src.
+func countKey(src string) string {
+	return "count|" + apl.Canonical(src)
+}
+
+// ResultCount returns the number of rows apl produces without fetching or
+// encoding them. Counts recorded by an earlier execution are reused;
+// otherwise a `| count` probe runs and its answer is cached. With AutoRange
+// the query executes so the count matches the widened range.
+func (e *Executor) ResultCount(ctx context.Context, apl string, opts ExecOptions) (int64, error) {
+	if opts.EnsureTimeRange {
+		apl = ensureTimeRange(apl, e.rangeFor(opts))
+	}
+	if opts.EnsureLimit {
+		apl = ensureLimit(apl, e.limitFor(opts))
+	}
+	apl, opts = e.pin(apl, opts)
+	if opts.AutoRange {
+		opts.EnsureTimeRange = false
+		opts.EnsureLimit = false
+		meta, err := e.ResultMeta(ctx, apl, "ndjson", opts)
+		return meta.Rows, err
+	}
+	if opts.UseCache {
+		if rows, ok := e.knownRows(apl, opts); ok {
+			return rows, nil
+		}
+		if rows, ok := e.knownRows(apl, ExecOptions{}); ok {
+			return rows, nil
+		}
+		if e.cache != nil {
+			if data, ok := e.cache.Get(countKey(apl)); ok {
+				if rows, err := strconv.ParseInt(string(data), 10, 64); err == nil {
+					return rows, nil
+				}
+			}
+		}
+	}
+
+	if err := e.quota.Allow(principal(ctx, opts)); err != nil {
+		return 0, err
+	}
+	value, err, _ := e.sf.Do(countKey(apl), func() (any, error) {
+		rows, err := e.countRows(ctx, apl, opts)
+		if err != nil {
+			return nil, err
+		}
+		e.quota.Record(principal(ctx, opts), 1, 0)
+		if opts.UseCache && e.cache != nil {
+			e.cache.SetMeta(countKey(apl), []byte(strconv.FormatInt(rows, 10)))
+		}
+		return rows, nil
+	})
+	if err != nil {
+		return 0, err
+	}
+	return value.(int64), nil
+}
@@ -0,0 +1,101 @@
+package query
+
+import (
+	"context"
+	"net/http"
+	"slices"
+
+	"github.com/axiomhq/axiom-fs/internal/axiomclient"
+)
+
+// TruncatedMarker ends ndjson, csv and tsv results Axiom reported as
+// missing matching rows.
+const TruncatedMarker = "# TRUNCATED\n"
+
+// cursorQuerier is implemented by clients that can continue a partial
+// result from a cursor.
+type cursorQuerier interface {
+	QueryAPLFrom(ctx context.Context, apl, cursor string, header http.Header) (*axiomclient.QueryResult, error)
+}
+
+// WithCursorFollow continues partial results for up to pages more requests,
+// appending each page's rows. Zero, the default, returns the first page with
+// its truncation warning. Results are then no longer streamed, since every
+// page must arrive first.
+func WithCursorFollow(pages int) Option {
+	return func(e *Executor) { e.followPages = pages }
+}
+
+// follows reports whether runQuery continues partial results.
+func (e *Executor) follows() bool {
+	_, ok := e.client.(cursorQuerier)
+	return ok && e.followPages > 0
+}
+
+// followCursor runs apl and continues it while Axiom reports it partial,
+// up to e.followPages more pages. The status counters add up; the rest of
+// the status is the last page's, so a result still truncated says so.
+func (e *Executor) followCursor(ctx context.Context, client cursorQuerier, apl string, header http.Header) (*axiomclient.QueryResult, error) {
+	result, err := client.QueryAPLFrom(ctx, apl, "", header)
+	for pages := 0; err == nil && pages < e.followPages; pages++ {
+		if !result.Status.IsPartial || result.Status.MinCursor == "" {
+			break
+		}
+		var next *axiomclient.QueryResult
+		if next, err = client.QueryAPLFrom(ctx, apl, result.Status.MinCursor, header); err != nil {
+			break
+		}
+		if !appendPage(result, next) {
+			break
+		}
+	}
+	return result, err
+}
+
+// appendPage appends next's rows to result. It reports false, leaving
+// result as it is, when next's tables do not match result's.
+func appendPage(result, next *axiomclient.QueryResult) bool {
+	if len(next.Tables) != len(result.Tables) {
+		return false
+	}
+	for i, table := range next.Tables {
+		if !slices.EqualFunc(table.Fields, result.Tables[i].Fields, func(a, b axiomclient.QueryField) bool { return a.Name == b.Name }) ||
+			len(table.Columns) != len(result.Tables[i].Columns) {
+			return false
+		}
+	}
+	for i, table := range next.Tables {
+		for j, column := range table.Columns {
+			result.Tables[i].Columns[j] = append(result.Tables[i].Columns[j], column...)
+		}
+	}
+	status := next.Status
+	status.ElapsedTime += result.Status.ElapsedTime
+	status.BlocksExamined += result.Status.BlocksExamined
+	status.RowsExamined += result.Status.RowsExamined
+	status.RowsMatched = max(status.RowsMatched, result.Status.RowsMatched)
+	result.Status = status
+	return true
+}
+
+// WithInlineMarkers, on by default, ends results with comment lines saying
+// they are incomplete: TruncatedMarker and the -max-result-bytes marker.
+// Off, results hold only data, for tools that reject any other line, and
+// stats.json and manifest.json alone report truncation.
+func WithInlineMarkers(inline bool) Option {
+	return func(e *Executor) { e.noMarkers = !inline }
+}
+
+// truncatedFooter is TruncatedMarker for the formats that carry it, unless
+// markers are off.
+func (e *Executor) truncatedFooter(format string, truncated bool) string {
+	if !truncated || e.noMarkers {
+		return ""
+	}
+	switch format {
+	case "ndjson", "csv", "tsv":
+		return TruncatedMarker
+	default:
+		return ""
+	}
+}
@@ -0,0 +1,149 @@
+package query
+
+import (
+	"context"
+	"encoding/json"
+	"strconv"
+	"time"
+
+	"github.com/axiomhq/axiom-fs/internal/axiomclient"
+)
+
+// estimateSampleRows is how many rows are encoded to learn the average
+// encoded row size of a result.
+const estimateSampleRows = 20
+
+// estimateFormats are the formats whose stored metadata may supply a row
+// count for another format of the same query.
+var estimateFormats = []string{"ndjson", "csv", "json", "tsv", "xlsx", "vl.json", "svg", "md"}
+
+// ResultEstimate is the size of a result file, either exact (from a previous
+// execution) or approximated without running the full query.
+type ResultEstimate struct {
+	Size    int64
+	ModTime time.Time
+	Exact   bool
+}
+
+// EstimateResult sizes a result without fetching it. Results that were
+// already executed report their exact size; otherwise the row count comes
+// from stored metadata of another format or a count probe, and is scaled by
+// the encoded size of a small sample. Estimates are cached like results so
+// repeated Stat calls stay cheap until the real execution backfills them.
+func (e *Executor) EstimateResult(ctx context.Context, apl, format string, opts ExecOptions) (ResultEstimate, error) {
+	if opts.EnsureTimeRange {
+		apl = ensureTimeRange(apl, e.rangeFor(opts))
+	}
+	if opts.EnsureLimit {
+		apl = ensureLimit(apl, e.limitFor(opts))
+	}
+	apl, opts = e.pin(apl, opts)
+	key := e.resultKey(apl, format, opts)
+	if meta, ok := e.lookupMeta(key); ok {
+		return ResultEstimate{
+			Size:    meta.Bytes,
+			ModTime: e.versions.since(key, meta.Version, meta.ExecutedAt),
+			Exact:   true,
+		}, nil
+	}
+	if e.cache != nil {
+		if data, ok := e.cache.Get(estimateKey(key)); ok {
+			if size, err := strconv.ParseInt(string(data), 10, 64); err == nil {
+				return ResultEstimate{Size: size}, nil
+			}
+		}
+	}
+
+	if err := e.quota.Allow(principal(ctx, opts)); err != nil {
+		return ResultEstimate{}, err
+	}
+	value, err, _ := e.sf.Do(estimateKey(key), func() (any, error) {
+		rows, ok := e.knownRows(apl, opts)
+		if !ok {
+			count, err := e.countRows(ctx, apl, opts)
+			if err != nil {
+				return nil, err
+			}
+			rows = count
+		}
+		size, err := e.sampleSize(ctx, apl, format, opts, rows)
+		if err != nil {
+			return nil, err
+		}
+		if e.cache != nil {
+			e.cache.SetMeta(estimateKey(key), []byte(strconv.FormatInt(size, 10)))
+		}
+		return size, nil
+	})
+	if err != nil {
+		return ResultEstimate{}, err
+	}
+	return ResultEstimate{Size: value.(int64)}, nil
+}
+
+func estimateKey(key string) string {
+	return "estimate|" + key
+}
+
+// knownRows returns the row count recorded by an earlier execution of apl in
+// any format.
+func (e *Executor) knownRows(apl string, opts ExecOptions) (int64, bool) {
+	for _, format := range estimateFormats {
+		if meta, ok := e.lookupMeta(e.resultKey(apl, format, opts)); ok && meta.Rows >= 0 {
+			return meta.Rows, true
+		}
+	}
+	return 0, false
+}
+
+func (e *Executor) countRows(ctx context.Context, apl string, opts ExecOptions) (int64, error) {
+	result, err := e.runQuery(ctx, apl+"\n| count", opts)
+	if err != nil {
+		return 0, err
+	}
+	if len(result.Tables) == 0 || len(result.Tables[0].Columns) == 0 || len(result.Tables[0].Columns[0]) == 0 {
+		return 0, nil
+	}
+	switch v := result.Tables[0].Columns[0][0].(type) {
+	case json.Number:
+		return v.Int64()
+	case float64:
+		return int64(v), nil
+	case int64:
+		return v, nil
+	case int:
+		return int64(v), nil
+	default:
+		return strconv.ParseInt(stringify(v), 10, 64)
+	}
+}
+
+// sampleSize encodes a few rows of apl and extrapolates to rows.
+func (e *Executor) sampleSize(ctx context.Context, apl, format string, opts ExecOptions, rows int64) (int64, error) {
+	result, err := e.runQuery(ctx, apl+"\n| take "+itoa(estimateSampleRows), opts)
+	if err != nil {
+		return 0, err
+	}
+	e.quota.Record(principal(ctx, opts), resultRows(result), 0)
+	if result, err = e.shapeResult(result, apl, format, opts); err != nil {
+		return 0, err
+	}
+	data, err := encodeResult(result, format)
+	if err != nil {
+		return 0, err
+	}
+	if format == "md" {
+		rows = min(rows, markdownMaxRows)
+	}
+	sampled := resultRows(result)
+	if sampled == 0 || rows <= sampled {
+		return int64(len(data)), nil
+	}
+	empty := &axiomclient.QueryResult{Tables: []axiomclient.QueryTable{{Fields: result.Tables[0].Fields}}}
+	base, err := encodeResult(empty, format)
+	if err != nil {
+		return 0, err
+	}
+	perRow := float64(len(data)-len(base)) / float64(sampled)
+	return int64(len(base)) + int64(perRow*float64(rows)), nil
+}
@@ -3,21 +3,41 @@ package query
 import (
 	"bytes"
 	"context"
+	"crypto/sha256"
 	"encoding/csv"
+	"encoding/hex"
 	"encoding/json"
 	"errors"
 	"fmt"
 	"io"
+	"io/fs"
+	"log/slog"
+	"net/http"
 	"os"
 	"strings"
+	"sync"
 	"time"
 
 	"golang.org/x/sync/singleflight"
+	"golang.org/x/text/language"
 
+	"github.com/axiomhq/axiom-fs/internal/apl"
+	"github.com/axiomhq/axiom-fs/internal/atrest"
 	"github.com/axiomhq/axiom-fs/internal/axiomclient"
 	"github.com/axiomhq/axiom-fs/internal/cache"
+	"github.com/axiomhq/axiom-fs/internal/chart"
+	"github.com/axiomhq/axiom-fs/internal/compiler"
+	"github.com/axiomhq/axiom-fs/internal/drain"
+	"github.com/axiomhq/axiom-fs/internal/events"
+	"github.com/axiomhq/axiom-fs/internal/latency"
+	"github.com/axiomhq/axiom-fs/internal/postprocess"
+	"github.com/axiomhq/axiom-fs/internal/quota"
+	"github.com/axiomhq/axiom-fs/internal/redact"
 )
 
+// ErrNotCached is returned for CacheOnly calls whose result is not cached.
+var ErrNotCached = fmt.Errorf("result not cached: %w", fs.ErrNotExist)
+
 type Executor struct {
 	client           axiomclient.API
 	cache            *cache.Cache
@@ -27,28 +47,191 @@ type Executor struct {
 	maxInMemoryBytes int
 	tempDir          string
 	sf               singleflight.Group
+	results          resultFlight
+	quota            *quota.Tracker
+	maxRange         time.Duration
+	revalidate       bool
+	pinned           *pinnedRange
+	collation        language.Tag
+	versions         versionTable
+	accesses         accessLog
+	inflight         drain.Group
+	costs            costTracker
+	running          inflightTracker
+	latency          *latency.Recorder
+	events           *events.Log
+	sealer           *atrest.Sealer
+	redactor         *redact.Redactor
+	flattenDepth     int
+	// followPages is how many more pages partial results are continued
+	// for; see WithCursorFollow.
+	followPages int
+	// maxResultBytes caps encoded results; see WithMaxResultBytes.
+	maxResultBytes int
+	// noMarkers leaves incomplete results without comment lines; see
+	// WithInlineMarkers.
+	noMarkers bool
+	// fair, when set, queues queries per client; see WithFairQueue.
+	fair *fairQueue
+	// post transforms encoded results; see WithPostProcessors.
+	post *postprocess.Pipeline
+	// staleRefreshes holds the keys of results served stale whose refresh
+	// is running; see ExecOptions.MaxStale.
+	staleRefreshes sync.Map
+}
+
+// Option configures optional Executor behavior.
+type Option func(*Executor)
+
+// WithQuota enforces per-principal row/byte budgets on queries that reach Axiom.
+func WithQuota(t *quota.Tracker) Option {
+	return func(e *Executor) { e.quota = t }
+}
+
+// WithRevalidate makes cache hits run a cheap count probe before serving.
+// Entries whose probe no longer matches the one taken at execution time are
+// re-executed instead of served stale.
+func WithRevalidate(enabled bool) Option {
+	return func(e *Executor) { e.revalidate = enabled }
+}
+
+// WithCollation sets the locale natural sorts compare strings in; the
+// default is the root collation.
+func WithCollation(tag language.Tag) Option {
+	return func(e *Executor) { e.collation = tag }
+}
+
+// WithLatency times every query sent to Axiom into r, split between
+// Axiom and encoding.
+func WithLatency(r *latency.Recorder) Option {
+	return func(e *Executor) { e.latency = r }
+}
+
+// WithEvents publishes a cache.refreshed event to l for every result cache
+// warming re-executes.
+func WithEvents(l *events.Log) Option {
+	return func(e *Executor) { e.events = l }
+}
+
+// WithEncryption seals results spilled to the temp dir with s; nil keeps
+// them in plaintext.
+func WithEncryption(s *atrest.Sealer) Option {
+	return func(e *Executor) { e.sealer = s }
+}
+
+// WithRedaction applies r to every result as it comes back from Axiom,
+// before it is shaped, encoded or cached.
+func WithRedaction(r *redact.Redactor) Option {
+	return func(e *Executor) { e.redactor = r }
+}
+
+// WithPostProcessors runs p's rules over the encoded results of the paths
+// they match, by the path in the LabelHeader, before they are served and
+// cached.
+func WithPostProcessors(p *postprocess.Pipeline) Option {
+	return func(e *Executor) { e.post = p }
+}
+
+// WithMaxRange caps how far AutoRange may widen a query's time window.
+func WithMaxRange(d time.Duration) Option {
+	return func(e *Executor) { e.maxRange = d }
 }
 
 type ExecOptions struct {
 	UseCache        bool
 	EnsureTimeRange bool
 	EnsureLimit     bool
+	// AutoRange retries an empty result with progressively wider ranges when
+	// the query uses the default ago() window.
+	AutoRange bool
+	// Principal identifies who the query is charged to for quotas. Unset,
+	// it is the NFS client in the context; see ClientFrom.
+	Principal string
+	// Columns, when set, keeps only these result columns, in this order,
+	// before encoding. It applies to any APL, including raw queries.
+	Columns []string
+	// NaturalSort, when set, stably re-sorts the result rows in natural
+	// order before encoding.
+	NaturalSort *compiler.Sort
+	// Flatten, when set, spreads nested objects over dotted columns in csv
+	// and tsv results; see compiler.FlattenDot.
+	Flatten string
+	// DefaultRange and DefaultLimit override the executor's defaults for
+	// EnsureTimeRange, EnsureLimit and AutoRange, e.g. with per-dataset
+	// defaults. Empty and zero keep the executor's.
+	DefaultRange string
+	DefaultLimit int
+	// Headers are sent with the queries run for this call, e.g. an
+	// X-Axiom-Query-Label naming the file read. They are not part of the
+	// cache key.
+	Headers http.Header
+	// Label attributes the queries run for this call in Costs. Empty falls
+	// back to a `// label: <name>` comment in the APL. It is not part of the
+	// cache key.
+	Label string
+	// Table, when set, encodes only the result table of that name, as
+	// listed by TableNames, instead of the first.
+	Table string
+	// CacheOnly serves results only from the cache: whatever would query
+	// Axiom fails with ErrNotCached instead, e.g. for a dataset Axiom no
+	// longer has. It is not part of the cache key.
+	CacheOnly bool
+	// MaxStale, when positive, serves a result up to this long past its
+	// cache expiry, bounded by the cache's own max-stale, while it is
+	// re-executed in the background. It is not part of the cache key.
+	MaxStale time.Duration
+
+	// refresh skips the cache lookup, re-executing and replacing the entry.
+	refresh bool
 }
 
 type Runner interface {
 	ExecuteAPL(ctx context.Context, apl, format string, opts ExecOptions) ([]byte, error)
 	ExecuteAPLResult(ctx context.Context, apl, format string, opts ExecOptions) (ResultData, error)
 	QueryAPL(ctx context.Context, apl string, opts ExecOptions) (*axiomclient.QueryResult, error)
+	ResultMeta(ctx context.Context, apl, format string, opts ExecOptions) (ResultMeta, error)
+	EstimateResult(ctx context.Context, apl, format string, opts ExecOptions) (ResultEstimate, error)
+	ResultCount(ctx context.Context, apl string, opts ExecOptions) (int64, error)
+	ResultStats(ctx context.Context, apl, format string, opts ExecOptions) ([]byte, error)
+}
+
+// SpillFile is a handle on a result spilled to the temp dir, an *os.File
+// or an *atrest.File decrypting one. Callers served by the same execution
+// each get a handle of their own; the file is removed when the last is
+// closed.
+type SpillFile interface {
+	io.Reader
+	io.ReaderAt
+	io.Seeker
+	io.Closer
+	Name() string
 }
 
 type ResultData struct {
 	Bytes []byte
-	File  *os.File
+	File  SpillFile
 	Size  int64
+	// ModTime is when the current content version was first produced, used
+	// as the file mtime so unchanged results keep a stable mtime.
+	ModTime time.Time
+	// Range is the ago() window the result was produced with when AutoRange
+	// was requested; empty otherwise.
+	Range string
+	// Meta describes the execution that produced the result.
+	Meta ResultMeta
 }
 
-func NewExecutor(client axiomclient.API, c *cache.Cache, defaultRange string, defaultLimit int, maxCacheBytes int, maxInMemoryBytes int, tempDir string) *Executor {
-	return &Executor{
+// Release closes the result's spill file handle, if it has one. Holders
+// that do not hand File on, such as to a file opened for a reader, call it
+// once they are done with the result.
+func (r ResultData) Release() {
+	if r.File != nil {
+		_ = r.File.Close()
+	}
+}
+
+func NewExecutor(client axiomclient.API, c *cache.Cache, defaultRange string, defaultLimit int, maxCacheBytes int, maxInMemoryBytes int, tempDir string, options ...Option) *Executor {
+	e := &Executor{
 		client:           client,
 		cache:            c,
 		defaultRange:     defaultRange,
@@ -57,44 +240,151 @@ func NewExecutor(client axiomclient.API, c *cache.Cache, defaultRange string, de
 		maxInMemoryBytes: maxInMemoryBytes,
 		tempDir:          tempDir,
 	}
+	for _, opt := range options {
+		opt(e)
+	}
+	return e
+}
+
+// runQuery sends apl to Axiom, tracked so Drain can wait for it. Clients
+// that accept them get header plus a fresh request ID and trace context.
+func (e *Executor) runQuery(ctx context.Context, apl string, opts ExecOptions) (*axiomclient.QueryResult, error) {
+	if opts.CacheOnly {
+		return nil, ErrNotCached
+	}
+	if !e.inflight.Acquire() {
+		return nil, drain.ErrDraining
+	}
+	defer e.inflight.Release()
+	peer := ClientFrom(ctx)
+	if err := e.fair.acquire(ctx, peer); err != nil {
+		return nil, err
+	}
+	defer e.fair.release(peer)
+	start := time.Now()
+	var (
+		result *axiomclient.QueryResult
+		err    error
+	)
+	if client, ok := e.client.(cursorQuerier); ok && e.followPages > 0 {
+		result, err = e.followCursor(ctx, client, apl, queryHeaders(opts.Headers))
+	} else if client, ok := e.client.(headerQuerier); ok {
+		result, err = client.QueryAPLWithHeaders(ctx, apl, queryHeaders(opts.Headers))
+	} else {
+		result, err = e.client.QueryAPL(ctx, apl)
+	}
+	var rows int64
+	if result != nil {
+		rows = resultRows(result)
+	}
+	e.costs.record(costLabel(apl, opts), start, time.Since(start), result, rows, err)
+	return e.redactor.Scope(apl).Result(result), err
+}
+
+// Drain stops sending new queries to Axiom and waits, up to ctx's deadline,
+// for the queries already running. Cached results are still served.
+func (e *Executor) Drain(ctx context.Context) error {
+	e.inflight.Close()
+	return e.inflight.Wait(ctx)
 }
 
 func (e *Executor) QueryAPL(ctx context.Context, apl string, opts ExecOptions) (*axiomclient.QueryResult, error) {
 	if opts.EnsureTimeRange {
-		apl = ensureTimeRange(apl, e.defaultRange)
+		apl = ensureTimeRange(apl, e.rangeFor(opts))
 	}
 	if opts.EnsureLimit {
-		apl = ensureLimit(apl, e.defaultLimit)
+		apl = ensureLimit(apl, e.limitFor(opts))
+	}
+	apl, opts = e.pin(apl, opts)
+	if err := e.quota.Allow(principal(ctx, opts)); err != nil {
+		return nil, err
 	}
-	return e.client.QueryAPL(ctx, apl)
+	result, err := e.runQuery(ctx, apl, opts)
+	if err != nil {
+		return nil, err
+	}
+	e.quota.Record(principal(ctx, opts), resultRows(result), 0)
+	return result, nil
 }
 
 func (e *Executor) ExecuteAPL(ctx context.Context, apl, format string, opts ExecOptions) ([]byte, error) {
 	if opts.EnsureTimeRange {
-		apl = ensureTimeRange(apl, e.defaultRange)
+		apl = ensureTimeRange(apl, e.rangeFor(opts))
 	}
 	if opts.EnsureLimit {
-		apl = ensureLimit(apl, e.defaultLimit)
+		apl = ensureLimit(apl, e.limitFor(opts))
 	}
-	key := cacheKey(apl, format)
+	apl, opts = e.pin(apl, opts)
+	if !opts.AutoRange {
+		return e.executeBytes(ctx, apl, format, opts)
+	}
+	var data []byte
+	err := e.withAutoRange(apl, e.rangeFor(opts), func(apl, _ string) (bool, error) {
+		var err error
+		data, err = e.executeBytes(ctx, apl, format, opts)
+		return err == nil && isEmptyResult(data, format), err
+	})
+	return data, err
+}
+
+func (e *Executor) executeBytes(ctx context.Context, apl, format string, opts ExecOptions) ([]byte, error) {
+	key := e.resultKey(apl, format, opts)
 
 	if opts.UseCache && e.cache != nil {
-		if data, ok := e.cache.Get(key); ok {
+		e.accesses.record(key, apl, format, opts)
+		if data, ok := e.cache.Get(key); ok && e.fresh(ctx, key, apl, opts) {
 			return data, nil
 		}
+		if entry, ok := e.staleEntry(ctx, key, apl, format, opts); ok {
+			return entry.Bytes, nil
+		}
+	}
+
+	if err := e.quota.Allow(principal(ctx, opts)); err != nil {
+		return nil, err
 	}
 
 	value, err, _ := e.sf.Do(key, func() (any, error) {
-		result, err := e.client.QueryAPL(ctx, apl)
+		ctx, running := e.running.begin(ctx, key, apl, format, opts)
+		defer e.running.end(running)
+		start := time.Now()
+		result, err := e.runQuery(ctx, apl, opts)
+		axiom := time.Since(start)
+		var data []byte
+		if err == nil {
+			if result, err = e.shapeResult(result, apl, format, opts); err == nil {
+				data, err = encodeResult(result, format)
+			}
+		}
+		e.observeQuery(apl, opts, start, axiom, err)
 		if err != nil {
 			return nil, err
 		}
-		data, err := encodeResult(result, format)
+		if chain := e.postFor(format, opts); len(chain) > 0 {
+			if data, err = chain.Bytes(ctx, opts.Headers.Get(LabelHeader), format, data); err != nil {
+				return nil, err
+			}
+			if endsLines(format) {
+				data = endLine(data)
+			}
+		}
+		if format == "md" {
+			data = append(data, markdownFooter(apl)...)
+		}
+		data = append(data, e.truncatedFooter(format, result.Status.Truncated())...)
+		data, capped, err := capBytes(data, format, e.maxResultBytes, e.noMarkers)
 		if err != nil {
 			return nil, err
 		}
+		e.quota.Record(principal(ctx, opts), resultRows(result), int64(len(data)))
+		sum := sha256.Sum256(data)
+		meta := newResultMeta(apl, format, result, int64(len(data)), sum[:])
+		meta.Restarted = running.Restarted
+		meta.Capped = capped
+		e.trackVersion(ctx, key, apl, opts, &meta)
 		if opts.UseCache && e.cache != nil {
 			e.cache.Set(key, data)
+			e.storeMeta(key, meta)
 		}
 		return data, nil
 	})
@@ -106,47 +396,149 @@ func (e *Executor) ExecuteAPL(ctx context.Context, apl, format string, opts Exec
 
 func (e *Executor) ExecuteAPLResult(ctx context.Context, apl, format string, opts ExecOptions) (ResultData, error) {
 	if opts.EnsureTimeRange {
-		apl = ensureTimeRange(apl, e.defaultRange)
+		apl = ensureTimeRange(apl, e.rangeFor(opts))
 	}
 	if opts.EnsureLimit {
-		apl = ensureLimit(apl, e.defaultLimit)
+		apl = ensureLimit(apl, e.limitFor(opts))
 	}
-	key := cacheKey(apl, format)
+	apl, opts = e.pin(apl, opts)
+	if !opts.AutoRange {
+		return e.executeResult(ctx, apl, format, opts)
+	}
+	var result ResultData
+	err := e.withAutoRange(apl, e.rangeFor(opts), func(apl, rng string) (bool, error) {
+		result.Release()
+		var err error
+		result, err = e.executeResult(ctx, apl, format, opts)
+		result.Range = rng
+		return err == nil && result.File == nil && isEmptyResult(result.Bytes, format), err
+	})
+	return result, err
+}
 
-	if opts.UseCache && e.cache != nil {
-		if data, ok := e.cache.Get(key); ok {
-			return ResultData{Bytes: data, Size: int64(len(data))}, nil
+func (e *Executor) executeResult(ctx context.Context, apl, format string, opts ExecOptions) (ResultData, error) {
+	key := e.resultKey(apl, format, opts)
+
+	if opts.UseCache && e.cache != nil && !opts.refresh {
+		e.accesses.record(key, apl, format, opts)
+		if entry, ok := e.cache.Lookup(key); ok && e.fresh(ctx, key, apl, opts) {
+			meta := e.cachedMeta(key, apl, format, entry)
+			return ResultData{
+				Bytes:   entry.Bytes,
+				Size:    int64(len(entry.Bytes)),
+				ModTime: e.versions.since(key, meta.Version, entry.StoredAt),
+				Meta:    meta,
+			}, nil
+		}
+		if entry, ok := e.staleEntry(ctx, key, apl, format, opts); ok {
+			meta := e.cachedMeta(key, apl, format, entry)
+			meta.Stale = true
+			return ResultData{
+				Bytes:   entry.Bytes,
+				Size:    int64(len(entry.Bytes)),
+				ModTime: e.versions.since(key, meta.Version, entry.StoredAt),
+				Meta:    meta,
+			}, nil
 		}
 	}
 
-	value, err, _ := e.sf.Do(key, func() (any, error) {
-		result, err := e.client.QueryAPL(ctx, apl)
+	if err := e.quota.Allow(principal(ctx, opts)); err != nil {
+		return ResultData{}, err
+	}
+
+	return e.results.do(key, func() (ResultData, error) {
+		ctx, running := e.running.begin(ctx, key, apl, format, opts)
+		defer e.running.end(running)
+		writer, err := newSpillWriter(e.maxInMemoryBytes, e.tempDir, e.sealer)
 		if err != nil {
-			return nil, err
+			return ResultData{}, err
 		}
-		writer, err := newSpillWriter(e.maxInMemoryBytes, e.tempDir)
-		if err != nil {
-			return nil, err
+		hash := sha256.New()
+		out := newCappedWriter(io.MultiWriter(writer, hash), format, e.maxResultBytes, e.noMarkers)
+		var meta ResultMeta
+		if chain := e.postFor(format, opts); len(chain) > 0 {
+			var dst io.Writer = out
+			ender := newLineEnder(out)
+			if endsLines(format) {
+				dst = ender
+			}
+			stream := chain.Start(ctx, opts.Headers.Get(LabelHeader), format, dst)
+			meta, err = e.encodeQuery(ctx, apl, format, opts, stream)
+			if err = stream.Close(err); err == nil && dst == ender {
+				err = ender.finish()
+			}
+		} else {
+			meta, err = e.encodeQuery(ctx, apl, format, opts, out)
+		}
+		if err == nil && format == "md" {
+			_, err = io.WriteString(out, markdownFooter(apl))
+		}
+		if footer := e.truncatedFooter(format, meta.Truncated); err == nil && footer != "" {
+			_, err = io.WriteString(out, footer)
 		}
-		if err := encodeResultToWriter(result, format, writer); err != nil {
+		if err == nil || errors.Is(err, errResultCapped) {
+			meta.Capped, err = out.finish()
+		}
+		if err != nil {
 			writer.cleanup()
-			return nil, err
+			return ResultData{}, err
+		}
+		size := int64(writer.size + writer.buffer.Len())
+		e.quota.Record(principal(ctx, opts), meta.Rows, size)
+		meta.Bytes, meta.SHA256 = size, hex.EncodeToString(hash.Sum(nil))
+		meta.Restarted = running.Restarted
+		since := e.trackVersion(ctx, key, apl, opts, &meta)
+		if opts.UseCache && e.cache != nil {
+			e.storeMeta(key, meta)
 		}
 		if writer.file == nil {
 			data := writer.buffer.Bytes()
 			if opts.UseCache && e.cache != nil && e.shouldCache(len(data)) {
 				e.cache.Set(key, data)
 			}
-			return ResultData{Bytes: data, Size: int64(len(data))}, nil
+			return ResultData{Bytes: data, Size: size, ModTime: since, Meta: meta}, nil
 		}
-		size, _ := writer.file.Seek(0, io.SeekEnd)
-		_, _ = writer.file.Seek(0, io.SeekStart)
-		return ResultData{File: writer.file, Size: size}, nil
+		file, err := writer.finish()
+		if err != nil {
+			writer.cleanup()
+			return ResultData{}, err
+		}
+		return ResultData{File: file, Size: size, ModTime: since, Meta: meta}, nil
 	})
-	if err != nil {
-		return ResultData{}, err
+}
+
+// staleEntry returns the expired cache entry of a result that may be served
+// stale per opts.MaxStale, and starts its refresh.
+func (e *Executor) staleEntry(ctx context.Context, key, apl, format string, opts ExecOptions) (cache.Entry, bool) {
+	if opts.MaxStale <= 0 || opts.CacheOnly {
+		return cache.Entry{}, false
+	}
+	entry, ok := e.cache.LookupStale(key, opts.MaxStale)
+	if !ok || !time.Now().After(entry.ExpiresAt) {
+		return cache.Entry{}, false
+	}
+	e.refreshStale(ctx, key, apl, format, opts)
+	return entry, true
+}
+
+// refreshStale re-executes a result served stale in the background, once
+// per result at a time, replacing its cache entry. It outlives ctx but
+// keeps its values, so it is queued and charged like the read that
+// triggered it.
+func (e *Executor) refreshStale(ctx context.Context, key, apl, format string, opts ExecOptions) {
+	if _, running := e.staleRefreshes.LoadOrStore(key, struct{}{}); running {
+		return
 	}
-	return value.(ResultData), nil
+	go func() {
+		defer e.staleRefreshes.Delete(key)
+		opts.refresh = true
+		result, err := e.executeResult(context.WithoutCancel(ctx), apl, format, opts)
+		if err != nil {
+			slog.Debug("stale refresh failed", "key", key, "error", err)
+			return
+		}
+		result.Release()
+	}()
 }
 
 func encodeResult(result *axiomclient.QueryResult, format string) ([]byte, error) {
@@ -154,8 +546,24 @@ func encodeResult(result *axiomclient.QueryResult, format string) ([]byte, error
 		switch format {
 		case "json":
 			return []byte("[]\n"), nil
+		case "xlsx":
+			var buf bytes.Buffer
+			if err := encodeXLSXToWriter(axiomclient.QueryTable{}, &buf); err != nil {
+				return nil, err
+			}
+			return buf.Bytes(), nil
 		case "csv":
 			return []byte{}, nil
+		case "md":
+			var buf bytes.Buffer
+			if err := encodeMarkdownToWriter(axiomclient.QueryTable{}, &buf); err != nil {
+				return nil, err
+			}
+			return buf.Bytes(), nil
+		case "vl.json":
+			return chart.VegaLite(axiomclient.QueryTable{})
+		case "svg":
+			return chart.SVG(axiomclient.QueryTable{})
 		default:
 			return []byte{}, nil
 		}
@@ -164,11 +572,26 @@ func encodeResult(result *axiomclient.QueryResult, format string) ([]byte, error
 	table := result.Tables[0]
 	switch format {
 	case "ndjson":
+		if len(result.Tables) > 1 {
+			var buf bytes.Buffer
+			err := encodeNDJSONTablesToWriter(result, &buf)
+			return buf.Bytes(), err
+		}
 		return encodeNDJSON(table)
 	case "json":
 		return encodeJSON(table)
 	case "csv":
 		return encodeCSV(table)
+	case "tsv", "xlsx", "md":
+		var buf bytes.Buffer
+		if err := encodeResultToWriter(result, format, &buf); err != nil {
+			return nil, err
+		}
+		return buf.Bytes(), nil
+	case "vl.json":
+		return chart.VegaLite(table)
+	case "svg":
+		return chart.SVG(table)
 	default:
 		return nil, fmt.Errorf("unsupported format: %s", format)
 	}
@@ -180,6 +603,12 @@ func encodeResultToWriter(result *axiomclient.QueryResult, format string, w io.W
 		case "json":
 			_, err := io.WriteString(w, "[]\n")
 			return err
+		case "xlsx":
+			return encodeXLSXToWriter(axiomclient.QueryTable{}, w)
+		case "md":
+			return encodeMarkdownToWriter(axiomclient.QueryTable{}, w)
+		case "vl.json", "svg":
+			return encodeChartToWriter(axiomclient.QueryTable{}, format, w)
 		default:
 			return nil
 		}
@@ -188,16 +617,40 @@ func encodeResultToWriter(result *axiomclient.QueryResult, format string, w io.W
 	table := result.Tables[0]
 	switch format {
 	case "ndjson":
+		if len(result.Tables) > 1 {
+			return encodeNDJSONTablesToWriter(result, w)
+		}
 		return encodeNDJSONToWriter(table, w)
 	case "json":
 		return encodeJSONToWriter(table, w)
 	case "csv":
 		return encodeCSVToWriter(table, w)
+	case "tsv":
+		return encodeDelimitedToWriter(table, w, '\t')
+	case "xlsx":
+		return encodeXLSXToWriter(table, w)
+	case "md":
+		return encodeMarkdownToWriter(table, w)
+	case "vl.json", "svg":
+		return encodeChartToWriter(table, format, w)
 	default:
 		return fmt.Errorf("unsupported format: %s", format)
 	}
 }
 
+func encodeChartToWriter(table axiomclient.QueryTable, format string, w io.Writer) error {
+	render := chart.SVG
+	if format == "vl.json" {
+		render = chart.VegaLite
+	}
+	data, err := render(table)
+	if err != nil {
+		return err
+	}
+	_, err = w.Write(data)
+	return err
+}
+
 func encodeNDJSON(table axiomclient.QueryTable) ([]byte, error) {
 	var buf bytes.Buffer
 	enc := json.NewEncoder(&buf)
@@ -294,7 +747,12 @@ func encodeCSV(table axiomclient.QueryTable) ([]byte, error) {
 }
 
 func encodeCSVToWriter(table axiomclient.QueryTable, w io.Writer) error {
+	return encodeDelimitedToWriter(table, w, ',')
+}
+
+func encodeDelimitedToWriter(table axiomclient.QueryTable, w io.Writer, comma rune) error {
 	writer := csv.NewWriter(w)
+	writer.Comma = comma
 	header := make([]string, 0, len(table.Fields))
 	for _, field := range table.Fields {
 		header = append(header, field.Name)
@@ -334,17 +792,58 @@ func tableRows(table axiomclient.QueryTable) [][]any {
 	return rows
 }
 
+// resultRows counts rows across all tables of result.
+func resultRows(result *axiomclient.QueryResult) int64 {
+	var rows int64
+	for _, table := range result.Tables {
+		if len(table.Columns) > 0 {
+			rows += int64(len(table.Columns[0]))
+		}
+	}
+	return rows
+}
+
 func stringify(value any) string {
 	switch v := value.(type) {
 	case string:
 		return v
 	case []byte:
 		return string(v)
+	case map[string]any, []any:
+		// Nested values read as JSON rather than Go's map[...] syntax.
+		data, err := json.Marshal(v)
+		if err != nil {
+			return fmt.Sprint(v)
+		}
+		return string(data)
 	default:
 		return fmt.Sprint(v)
 	}
 }
 
+// principal returns who a query is charged to: opts.Principal, or else the
+// client that asked for it.
+func principal(ctx context.Context, opts ExecOptions) string {
+	if opts.Principal != "" {
+		return opts.Principal
+	}
+	return ClientFrom(ctx)
+}
+
+func (e *Executor) rangeFor(opts ExecOptions) string {
+	if opts.DefaultRange != "" {
+		return opts.DefaultRange
+	}
+	return e.defaultRange
+}
+
+func (e *Executor) limitFor(opts ExecOptions) int {
+	if opts.DefaultLimit > 0 {
+		return opts.DefaultLimit
+	}
+	return e.defaultLimit
+}
+
 func ensureTimeRange(apl, defaultRange string) string {
 	if strings.Contains(apl, "_time between") {
 		return apl
@@ -391,8 +890,113 @@ func insertPipeline(apl, clause string) string {
 	return head + "\n| " + clause + "\n| " + rest
 }
 
-func cacheKey(apl, format string) string {
-	return apl + "|" + format
+// CacheStats reports the cache counters and segments; zero without a cache.
+func (e *Executor) CacheStats() cache.Stats {
+	if e.cache == nil {
+		return cache.Stats{Segments: []cache.SegmentStats{}}
+	}
+	return e.cache.Stats()
+}
+
+// DiskUsage reports the footprint of the cache directory; zero without a
+// cache.
+func (e *Executor) DiskUsage() cache.DiskUsage {
+	if e.cache == nil {
+		return cache.DiskUsage{Segments: []cache.SegmentUsage{}}
+	}
+	return e.cache.DiskUsage()
+}
+
+// PurgeCache drops every cached result and its metadata, so the next read
+// of any result file queries Axiom again. It returns the number of entries
+// removed.
+func (e *Executor) PurgeCache() int {
+	if e.cache == nil {
+		return 0
+	}
+	return e.cache.Purge()
+}
+
+// InvalidateAPL drops the cached results of apl in every format, with
+// their metadata, counts and estimates, so the next read runs it again. It
+// returns the number of entries removed.
+func (e *Executor) InvalidateAPL(apl string) int {
+	if e.cache == nil {
+		return 0
+	}
+	apl, _ = e.pin(apl, ExecOptions{})
+	keys := []string{countKey(apl)}
+	for _, format := range estimateFormats {
+		key := cacheKey(apl, format)
+		keys = append(keys, key, metaKey(key), estimateKey(key))
+	}
+	prefixes := []string{cacheKey(apl, ""), metaKey(cacheKey(apl, "")), estimateKey(cacheKey(apl, ""))}
+	return e.cache.Remove(func(key string) bool {
+		for _, prefix := range prefixes {
+			if strings.HasPrefix(key, prefix) {
+				return true
+			}
+		}
+		return false
+	}, keys...)
+}
+
+// RetainDataset keeps the cached results of queries reading dataset until
+// at least until, past the cache TTL, and returns how many it kept.
+func (e *Executor) RetainDataset(dataset string, until time.Time) int {
+	if e.cache == nil {
+		return 0
+	}
+	return e.cache.Retain(readsDataset(dataset), until)
+}
+
+// InvalidateDataset drops the cached results of queries reading dataset
+// and returns how many it removed.
+func (e *Executor) InvalidateDataset(dataset string) int {
+	if e.cache == nil {
+		return 0
+	}
+	return e.cache.Remove(readsDataset(dataset))
+}
+
+// readsDataset matches the cache keys of queries naming dataset.
+func readsDataset(dataset string) func(key string) bool {
+	ref := "['" + dataset + "']"
+	return func(key string) bool { return strings.Contains(key, ref) }
+}
+
+// cacheKey keys results by the canonical form of their APL, so spellings
+// differing only in whitespace, comments or the order of where terms share
+// a cache entry and a single in-flight query.
+func cacheKey(src, format string) string {
+	return apl.Canonical(src) + "|" + format
+}
+
+// postFor returns the post-processors of the path opts label.
+func (e *Executor) postFor(format string, opts ExecOptions) postprocess.Chain {
+	return e.post.Match(opts.Headers.Get(LabelHeader), format)
+}
+
+// resultKey extends cacheKey with the projected columns, natural sort and
+// post-processors, if any.
+func (e *Executor) resultKey(apl, format string, opts ExecOptions) string {
+	key := cacheKey(apl, format)
+	if len(opts.Columns) > 0 {
+		key += "|cols=" + strings.Join(opts.Columns, ",")
+	}
+	if opts.NaturalSort != nil {
+		key += "|sort=" + opts.NaturalSort.String()
+	}
+	if opts.Table != "" {
+		key += "|table=" + opts.Table
+	}
+	if flattens(format, opts) {
+		key += "|flatten=" + opts.Flatten
+	}
+	if post := e.postFor(format, opts).Key(); post != "" {
+		key += "|post=" + post
+	}
+	return key
 }
 
 func BuildErrorAPL(apl string, err error) []byte {
@@ -423,48 +1027,81 @@ func (e *Executor) shouldCache(size int) bool {
 	return true
 }
 
+// spillWriter buffers a result in memory up to limit, then moves it to a
+// temp file, sealed when a sealer is set.
 type spillWriter struct {
 	limit   int
 	buffer  *bytes.Buffer
 	file    *os.File
+	out     io.Writer
+	sealer  *atrest.Sealer
 	size    int
 	tempDir string
 }
 
-func newSpillWriter(limit int, tempDir string) (*spillWriter, error) {
+func newSpillWriter(limit int, tempDir string, sealer *atrest.Sealer) (*spillWriter, error) {
 	return &spillWriter{
 		limit:   limit,
 		buffer:  &bytes.Buffer{},
+		sealer:  sealer,
 		tempDir: tempDir,
 	}, nil
 }
 
 func (w *spillWriter) Write(p []byte) (int, error) {
 	if w.file != nil {
-		n, err := w.file.Write(p)
+		n, err := w.out.Write(p)
 		w.size += n
 		return n, err
 	}
 	if w.limit > 0 && w.buffer.Len()+len(p) > w.limit {
-		file, err := os.CreateTemp(w.tempDir, "axiom-fs-*")
-		if err != nil {
+		if err := w.spill(); err != nil {
 			return 0, err
 		}
-		if _, err := file.Write(w.buffer.Bytes()); err != nil {
+		n, err := w.out.Write(p)
+		w.size += n
+		return n, err
+	}
+	return w.buffer.Write(p)
+}
+
+// spill moves the buffered bytes to a new temp file.
+func (w *spillWriter) spill() error {
+	file, err := os.CreateTemp(w.tempDir, "axiom-fs-*")
+	if err != nil {
+		return err
+	}
+	var out io.Writer = file
+	if w.sealer.Enabled() {
+		if out, err = w.sealer.NewWriter(file); err != nil {
 			_ = file.Close()
 			_ = os.Remove(file.Name())
-			return 0, err
+			return err
 		}
-		w.size = w.buffer.Len()
-		w.buffer.Reset()
-		w.file = file
 	}
-	if w.file != nil {
-		n, err := w.file.Write(p)
-		w.size += n
-		return n, err
+	if _, err := out.Write(w.buffer.Bytes()); err != nil {
+		_ = file.Close()
+		_ = os.Remove(file.Name())
+		return err
 	}
-	return w.buffer.Write(p)
+	w.size = w.buffer.Len()
+	w.buffer.Reset()
+	w.file, w.out = file, out
+	return nil
+}
+
+// finish returns the spilled result for reading from the start.
+func (w *spillWriter) finish() (SpillFile, error) {
+	if sealed, ok := w.out.(*atrest.Writer); ok {
+		if err := sealed.Close(); err != nil {
+			return nil, err
+		}
+		return w.sealer.OpenFile(w.file, int64(w.size))
+	}
+	if _, err := w.file.Seek(0, io.SeekStart); err != nil {
+		return nil, err
+	}
+	return w.file, nil
 }
 
 func (w *spillWriter) cleanup() {
@@ -2,11 +2,39 @@ package query
 
 import (
 	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
 	"encoding/json"
+	"errors"
+	"fmt"
+	"hash/maphash"
+	"io"
+	"io/fs"
+	"net/http"
+	"os"
+	"path/filepath"
+	"reflect"
+	"slices"
+	"strconv"
 	"strings"
+	"sync"
+	"syscall"
 	"testing"
+	"time"
 
+	"github.com/xuri/excelize/v2"
+
+	"github.com/axiomhq/axiom-fs/internal/atrest"
 	"github.com/axiomhq/axiom-fs/internal/axiomclient"
+	"github.com/axiomhq/axiom-fs/internal/cache"
+	"github.com/axiomhq/axiom-fs/internal/compiler"
+	"github.com/axiomhq/axiom-fs/internal/drain"
+	"github.com/axiomhq/axiom-fs/internal/events"
+	"github.com/axiomhq/axiom-fs/internal/latency"
+	"github.com/axiomhq/axiom-fs/internal/postprocess"
+	"github.com/axiomhq/axiom-fs/internal/quota"
+	"github.com/axiomhq/axiom-fs/internal/redact"
 )
 
 func TestEnsureTimeRange(t *testing.T) {
@@ -144,6 +172,7 @@ func TestCacheKey(t *testing.T) {
 		{"['logs']", "json", "['logs']|json"},
 		{"['logs'] | take 10", "csv", "['logs'] | take 10|csv"},
 		{"", "ndjson", "|ndjson"},
+		{"['logs']\n| where b == 2 and a == 1 // errors\n| take 10", "csv", "['logs'] | where a == 1 and b == 2 | take 10|csv"},
 	}
 
 	for _, tt := range tests {
@@ -433,3 +462,1931 @@ type testError struct{}
 func (testError) Error() string { return "test error" }
 
 var errTest = testError{}
+
+type fakeClient struct {
+	calls  int
+	apls   []string
+	result *axiomclient.QueryResult
+	err    error
+	// resultFn, when set, overrides result per query.
+	resultFn func(apl string) *axiomclient.QueryResult
+}
+
+func (f *fakeClient) CurrentUser(ctx context.Context) (*axiomclient.User, error) {
+	return &axiomclient.User{}, nil
+}
+
+func (f *fakeClient) ListDatasets(ctx context.Context) ([]axiomclient.Dataset, error) {
+	return nil, nil
+}
+
+func (f *fakeClient) ListFields(ctx context.Context, datasetID string) ([]axiomclient.Field, error) {
+	return nil, nil
+}
+
+func (f *fakeClient) DatasetStats(ctx context.Context) ([]axiomclient.DatasetStats, error) {
+	return nil, nil
+}
+
+func (f *fakeClient) QueryAPL(ctx context.Context, apl string) (*axiomclient.QueryResult, error) {
+	f.calls++
+	f.apls = append(f.apls, apl)
+	if f.resultFn != nil {
+		return f.resultFn(apl), f.err
+	}
+	if f.result == nil {
+		return &axiomclient.QueryResult{}, f.err
+	}
+	return f.result, f.err
+}
+
+func TestExecutorQuota(t *testing.T) {
+	client := &fakeClient{result: &axiomclient.QueryResult{
+		Tables: []axiomclient.QueryTable{makeTestTable([]string{"a"}, [][]any{{1}, {2}, {3}})},
+	}}
+	tracker := quota.New(quota.Limits{RowsPerHour: 3})
+	exec := NewExecutor(client, nil, "1h", 100, 0, 0, "", WithQuota(tracker))
+	ctx := context.Background()
+
+	if _, err := exec.ExecuteAPL(ctx, "['logs']", "ndjson", ExecOptions{}); err != nil {
+		t.Fatalf("first query: %v", err)
+	}
+	_, err := exec.ExecuteAPLResult(ctx, "['logs'] | take 1", "csv", ExecOptions{})
+	if !errors.Is(err, syscall.EDQUOT) {
+		t.Fatalf("expected EDQUOT, got %v", err)
+	}
+	if client.calls != 1 {
+		t.Errorf("client called %d times, want 1", client.calls)
+	}
+
+	if _, err := exec.ExecuteAPL(ctx, "['logs']", "ndjson", ExecOptions{Principal: "other"}); err != nil {
+		t.Errorf("other principal should have its own budget: %v", err)
+	}
+
+	if _, err := exec.ExecuteAPL(WithClient(ctx, "10.0.0.7"), "['logs'] | take 2", "ndjson", ExecOptions{}); err != nil {
+		t.Errorf("a client should be charged separately: %v", err)
+	}
+	snap := tracker.Snapshot()
+	charged := map[string]int64{}
+	for _, p := range snap.Principals {
+		charged[p.Principal] = p.Rows
+	}
+	if charged[LocalClient] != 3 || charged["other"] != 3 || charged["10.0.0.7"] != 3 {
+		t.Errorf("charged = %v", charged)
+	}
+}
+
+func TestExecutorAutoRange(t *testing.T) {
+	client := &fakeClient{resultFn: func(apl string) *axiomclient.QueryResult {
+		if !strings.Contains(apl, "ago(1d)") {
+			return &axiomclient.QueryResult{}
+		}
+		return &axiomclient.QueryResult{
+			Tables: []axiomclient.QueryTable{makeTestTable([]string{"a"}, [][]any{{1}})},
+		}
+	}}
+	exec := NewExecutor(client, nil, "1h", 100, 0, 0, "", WithMaxRange(48*time.Hour))
+	ctx := context.Background()
+	apl := "['logs']\n| where _time between (ago(1h) .. now())"
+
+	result, err := exec.ExecuteAPLResult(ctx, apl, "ndjson", ExecOptions{AutoRange: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if result.Range != "1d" {
+		t.Errorf("Range = %q, want 1d", result.Range)
+	}
+	if result.Size == 0 {
+		t.Error("expected rows from widened range")
+	}
+	if got := strings.Join(client.apls, "\n---\n"); !strings.Contains(got, "ago(6h)") {
+		t.Errorf("expected 6h attempt before 1d:\n%s", got)
+	}
+
+	client.apls = nil
+	client.resultFn = func(string) *axiomclient.QueryResult { return &axiomclient.QueryResult{} }
+	result, err = exec.ExecuteAPLResult(ctx, apl, "csv", ExecOptions{AutoRange: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if result.Range != "2d" {
+		t.Errorf("Range = %q, want max range 2d", result.Range)
+	}
+	if len(client.apls) != 4 {
+		t.Errorf("attempts = %d, want 4 (1h, 6h, 1d, 2d)", len(client.apls))
+	}
+
+	client.apls = nil
+	if _, err := exec.ExecuteAPL(ctx, apl, "ndjson", ExecOptions{}); err != nil {
+		t.Fatal(err)
+	}
+	if len(client.apls) != 1 {
+		t.Errorf("without AutoRange attempts = %d, want 1", len(client.apls))
+	}
+}
+
+func TestExecutorDefaultOverrides(t *testing.T) {
+	client := &fakeClient{resultFn: func(apl string) *axiomclient.QueryResult {
+		if !strings.Contains(apl, "ago(6h)") {
+			return &axiomclient.QueryResult{}
+		}
+		return &axiomclient.QueryResult{
+			Tables: []axiomclient.QueryTable{makeTestTable([]string{"a"}, [][]any{{1}})},
+		}
+	}}
+	exec := NewExecutor(client, nil, "1h", 100, 0, 0, "", WithMaxRange(48*time.Hour))
+	ctx := context.Background()
+
+	if _, err := exec.ExecuteAPL(ctx, "['logs']", "ndjson", ExecOptions{EnsureTimeRange: true, EnsureLimit: true, DefaultRange: "5m", DefaultLimit: 7}); err != nil {
+		t.Fatal(err)
+	}
+	if apl := client.apls[0]; !strings.Contains(apl, "ago(5m)") || !strings.Contains(apl, "take 7") {
+		t.Errorf("overrides not applied: %s", apl)
+	}
+
+	// Auto-range widens from the overridden default window.
+	client.apls = nil
+	result, err := exec.ExecuteAPLResult(ctx, "['logs']\n| where _time between (ago(5m) .. now())", "ndjson", ExecOptions{AutoRange: true, DefaultRange: "5m"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if result.Range != "6h" || len(client.apls) != 2 {
+		t.Errorf("Range = %q after %d attempts, want 6h after 2", result.Range, len(client.apls))
+	}
+}
+
+func TestFormatTimespan(t *testing.T) {
+	cases := map[time.Duration]string{
+		6 * time.Hour:    "6h",
+		72 * time.Hour:   "3d",
+		90 * time.Minute: "90m",
+	}
+	for d, want := range cases {
+		if got := formatTimespan(d); got != want {
+			t.Errorf("formatTimespan(%v) = %q, want %q", d, got, want)
+		}
+	}
+}
+
+func TestExecutorResultMeta(t *testing.T) {
+	client := &fakeClient{result: &axiomclient.QueryResult{
+		Tables:  []axiomclient.QueryTable{makeTestTable([]string{"a"}, [][]any{{1}, {2}})},
+		QueryID: "q-1",
+	}}
+	c := cache.New(time.Minute, 10, 1<<20, "")
+	exec := NewExecutor(client, c, "1h", 100, 1<<20, 1<<20, "")
+	ctx := context.Background()
+	opts := ExecOptions{UseCache: true}
+
+	result, err := exec.ExecuteAPLResult(ctx, "['logs']", "ndjson", opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	sum := sha256.Sum256(result.Bytes)
+	if result.Meta.SHA256 != hex.EncodeToString(sum[:]) {
+		t.Errorf("sha256 = %s, want %x", result.Meta.SHA256, sum)
+	}
+	if result.Meta.Rows != 2 || result.Meta.QueryID != "q-1" || result.Meta.Bytes != result.Size {
+		t.Errorf("unexpected meta: %+v", result.Meta)
+	}
+
+	meta, err := exec.ResultMeta(ctx, "['logs']", "ndjson", opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(meta, result.Meta) {
+		t.Errorf("ResultMeta = %+v, want %+v", meta, result.Meta)
+	}
+	cached, err := exec.ExecuteAPLResult(ctx, "['logs']", "ndjson", opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(cached.Meta, result.Meta) {
+		t.Errorf("cached meta = %+v, want %+v", cached.Meta, result.Meta)
+	}
+	if client.calls != 1 {
+		t.Errorf("client called %d times, want 1", client.calls)
+	}
+}
+
+func TestExecutorRevalidate(t *testing.T) {
+	matched, latest := int64(10), "2024-01-01T00:00:00Z"
+	client := &fakeClient{}
+	client.resultFn = func(apl string) *axiomclient.QueryResult {
+		table := makeTestTable([]string{"a"}, [][]any{{1}})
+		if strings.HasSuffix(apl, "max(_sysTime)") {
+			// A saturated take: the count stays put as new events arrive.
+			table = makeTestTable([]string{"count_", "max__sysTime"}, [][]any{{100, latest}})
+		}
+		return &axiomclient.QueryResult{
+			Tables: []axiomclient.QueryTable{table},
+			Status: axiomclient.QueryStatus{RowsMatched: matched},
+		}
+	}
+	c := cache.New(time.Minute, 10, 1<<20, "")
+	tracker := quota.New(quota.Limits{RowsPerHour: 1000})
+	exec := NewExecutor(client, c, "1h", 100, 1<<20, 1<<20, "", WithRevalidate(true), WithQuota(tracker))
+	ctx := context.Background()
+	opts := ExecOptions{UseCache: true}
+
+	first, err := exec.ExecuteAPLResult(ctx, "['logs']", "ndjson", opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if first.Meta.Version == "" {
+		t.Fatal("expected a content version")
+	}
+	client.apls = nil
+	if _, err := exec.ExecuteAPLResult(ctx, "['logs']", "ndjson", opts); err != nil {
+		t.Fatal(err)
+	}
+	if len(client.apls) != 1 || !strings.HasSuffix(client.apls[0], "| summarize count(), max(_sysTime)") {
+		t.Errorf("unchanged data should only probe, got %q", client.apls)
+	}
+	// The query and both probes are charged.
+	if snap := tracker.Snapshot(); len(snap.Principals) != 1 || snap.Principals[0].Queries != 3 {
+		t.Errorf("quota = %+v, want the query and 2 probes", snap.Principals)
+	}
+
+	latest = "2024-01-01T00:00:05Z"
+	client.apls = nil
+	if _, err := exec.ExecuteAPLResult(ctx, "['logs']", "ndjson", opts); err != nil {
+		t.Fatal(err)
+	}
+	if len(client.apls) < 2 {
+		t.Errorf("a newer _sysTime should re-execute, got %q", client.apls)
+	}
+
+	matched = 11
+	client.apls = nil
+	second, err := exec.ExecuteAPLResult(ctx, "['logs']", "ndjson", opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(client.apls) < 2 {
+		t.Errorf("changed probe should re-execute, got %q", client.apls)
+	}
+	if second.Meta.Version == first.Meta.Version {
+		t.Error("version should change with rows matched")
+	}
+}
+
+func TestExecutorStableModTime(t *testing.T) {
+	client := &fakeClient{result: &axiomclient.QueryResult{
+		Tables: []axiomclient.QueryTable{makeTestTable([]string{"a"}, [][]any{{1}})},
+	}}
+	exec := NewExecutor(client, nil, "1h", 100, 0, 0, "")
+	ctx := context.Background()
+
+	first, err := exec.ExecuteAPLResult(ctx, "['logs']", "csv", ExecOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	time.Sleep(2 * time.Millisecond)
+	second, err := exec.ExecuteAPLResult(ctx, "['logs']", "csv", ExecOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if client.calls != 2 {
+		t.Fatalf("expected re-execution without cache, got %d calls", client.calls)
+	}
+	if !second.ModTime.Equal(first.ModTime) {
+		t.Errorf("unchanged result mtime moved: %v -> %v", first.ModTime, second.ModTime)
+	}
+}
+
+func TestEncodeTSV(t *testing.T) {
+	table := makeTestTable([]string{"service", "count"}, [][]any{{"api", float64(3)}, {"a\tb", float64(1)}})
+	data, err := encodeResult(&axiomclient.QueryResult{Tables: []axiomclient.QueryTable{table}}, "tsv")
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := "service\tcount\napi\t3\n\"a\tb\"\t1\n"
+	if string(data) != want {
+		t.Errorf("tsv = %q, want %q", data, want)
+	}
+}
+
+func TestEncodeMarkdown(t *testing.T) {
+	table := makeTestTable([]string{"service", "count"}, [][]any{{"api|v2", float64(3)}, {"a\nb", nil}})
+	client := &fakeClient{result: &axiomclient.QueryResult{Tables: []axiomclient.QueryTable{table}}}
+	exec := NewExecutor(client, nil, "1h", 100, 0, 1<<20, "")
+	apl := "['logs']\n| where _time between (ago(1h) .. now())\n| summarize count() by service\n| order by count_ desc"
+
+	data, err := exec.ExecuteAPL(context.Background(), apl, "md", ExecOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := "| service | count |\n| --- | --- |\n| api\\|v2 | 3 |\n| a<br>b |  |\n" +
+		"\n```kusto\n" + apl + "\n```\n\nRange: `ago(1h) .. now()`\n"
+	if string(data) != want {
+		t.Errorf("md = %q, want %q", data, want)
+	}
+	result, err := exec.ExecuteAPLResult(context.Background(), apl, "md", ExecOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(result.Bytes) != want {
+		t.Errorf("streamed md = %q, want %q", result.Bytes, want)
+	}
+	if isEmptyResult(data, "md") {
+		t.Error("md with rows reported empty")
+	}
+
+	rows := make([][]any, markdownMaxRows+5)
+	for i := range rows {
+		rows[i] = []any{"api", float64(i)}
+	}
+	long, err := encodeResult(&axiomclient.QueryResult{Tables: []axiomclient.QueryTable{makeTestTable([]string{"service", "n"}, rows)}}, "md")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n := strings.Count(string(long), "\n| api |"); n != markdownMaxRows {
+		t.Errorf("md shows %d rows, want %d", n, markdownMaxRows)
+	}
+	if !strings.Contains(string(long), "first 100 of 105 rows") {
+		t.Errorf("truncated md has no note: %s", long[len(long)-60:])
+	}
+	empty, err := encodeResult(&axiomclient.QueryResult{Tables: []axiomclient.QueryTable{makeTestTable([]string{"service"}, nil)}}, "md")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !isEmptyResult(append(empty, markdownFooter(apl)...), "md") {
+		t.Errorf("md without rows not empty: %q", empty)
+	}
+}
+
+func TestEncodeXLSX(t *testing.T) {
+	table := makeTestTable([]string{"_time", "service", "count", "ok"}, [][]any{
+		{"2024-01-15T10:00:00Z", "api", float64(42), true},
+	})
+	table.Fields[0].Type = "datetime"
+	var buf bytes.Buffer
+	err := encodeResultToWriter(&axiomclient.QueryResult{Tables: []axiomclient.QueryTable{table}}, "xlsx", &buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	f, err := excelize.OpenReader(&buf)
+	if err != nil {
+		t.Fatalf("workbook does not open: %v", err)
+	}
+	defer f.Close()
+	rows, err := f.GetRows(xlsxSheet)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(rows) != 2 || rows[0][1] != "service" || rows[1][1] != "api" || rows[1][2] != "42" {
+		t.Errorf("unexpected rows: %v", rows)
+	}
+	if typ, _ := f.GetCellType(xlsxSheet, "C2"); typ == excelize.CellTypeSharedString || typ == excelize.CellTypeInlineString {
+		t.Errorf("count should be numeric, got cell type %v", typ)
+	}
+	panes, err := f.GetPanes(xlsxSheet)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !panes.Freeze || panes.YSplit != 1 {
+		t.Errorf("header row should be frozen: %+v", panes)
+	}
+}
+
+func TestEncodeChart(t *testing.T) {
+	table := makeTestTable([]string{"_time", "count_"}, [][]any{
+		{"2024-01-15T10:00:00Z", float64(3)},
+		{"2024-01-15T10:05:00Z", float64(5)},
+	})
+	table.Fields[0].Type = "datetime"
+	result := &axiomclient.QueryResult{Tables: []axiomclient.QueryTable{table}}
+
+	spec, err := encodeResult(result, "vl.json")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(string(spec), `"$schema": "https://vega.github.io/schema/vega-lite/v5.json"`) {
+		t.Errorf("vl.json = %s", spec)
+	}
+	var buf bytes.Buffer
+	if err := encodeResultToWriter(result, "svg", &buf); err != nil {
+		t.Fatal(err)
+	}
+	if !strings.HasPrefix(buf.String(), "<svg ") {
+		t.Errorf("svg = %s", buf.String())
+	}
+
+	flat := makeTestTable([]string{"service", "count_"}, [][]any{{"api", float64(3)}})
+	if _, err := encodeResult(&axiomclient.QueryResult{Tables: []axiomclient.QueryTable{flat}}, "svg"); !errors.Is(err, fs.ErrInvalid) {
+		t.Errorf("chart without time bucket: err = %v", err)
+	}
+}
+
+func TestExecutorColumns(t *testing.T) {
+	client := &fakeClient{resultFn: func(string) *axiomclient.QueryResult {
+		return &axiomclient.QueryResult{
+			Tables: []axiomclient.QueryTable{makeTestTable([]string{"a", "b", "c"}, [][]any{{1, 2, 3}})},
+		}
+	}}
+	exec := NewExecutor(client, nil, "1h", 100, 0, 0, "")
+	ctx := context.Background()
+
+	data, err := exec.ExecuteAPL(ctx, "['logs']", "csv", ExecOptions{UseCache: true, Columns: []string{"c", "a"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := string(data); got != "c,a\n3,1\n" {
+		t.Errorf("projected csv = %q", got)
+	}
+
+	data, err = exec.ExecuteAPL(ctx, "['logs']", "csv", ExecOptions{UseCache: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := string(data); got != "a,b,c\n1,2,3\n" {
+		t.Errorf("unprojected csv shares cache entry: %q", got)
+	}
+
+	_, err = exec.ExecuteAPL(ctx, "['logs']", "csv", ExecOptions{Columns: []string{"missing"}})
+	if err == nil || !strings.Contains(err.Error(), "available: a, b, c") {
+		t.Errorf("expected unknown column error, got %v", err)
+	}
+}
+
+func TestExecutorFlatten(t *testing.T) {
+	result := &axiomclient.QueryResult{Tables: []axiomclient.QueryTable{makeTestTable([]string{"id", "attrs"}, [][]any{
+		{1.0, map[string]any{"http": map[string]any{"status": 200.0, "tls": map[string]any{"v": "1.3"}}, "tags": []any{"a"}}},
+		{2.0, map[string]any{"user": "bob"}},
+		{3.0, "plain"},
+	})}}
+	ctx := context.Background()
+
+	// Unflattened, nested values read as JSON.
+	exec := NewExecutor(&fakeClient{result: result}, nil, "1h", 100, 0, 0, "")
+	data, err := exec.ExecuteAPL(ctx, "['logs']", "csv", ExecOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(string(data), `"{""http"":{""status"":200`) {
+		t.Errorf("csv without flatten = %q", data)
+	}
+
+	for _, client := range []axiomclient.API{&fakeClient{result: result}, &rowClient{fakeClient: fakeClient{result: result}}} {
+		exec := NewExecutor(client, nil, "1h", 100, 0, 0, "", WithFlattenDepth(2))
+		data, err := exec.ExecuteAPL(ctx, "['logs']", "csv", ExecOptions{Flatten: compiler.FlattenDot})
+		if err != nil {
+			t.Fatal(err)
+		}
+		want := "id,attrs,attrs.http.status,attrs.http.tls,attrs.tags,attrs.user\n" +
+			"1,<nil>,200,\"{\"\"v\"\":\"\"1.3\"\"}\",\"[\"\"a\"\"]\",<nil>\n" +
+			"2,<nil>,<nil>,<nil>,<nil>,bob\n" +
+			"3,plain,<nil>,<nil>,<nil>,<nil>\n"
+		if got := string(data); got != want {
+			t.Errorf("%T: flattened csv = %q, want %q", client, got, want)
+		}
+
+		data, err = exec.ExecuteAPL(ctx, "['logs']", "ndjson", ExecOptions{Flatten: compiler.FlattenDot})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !strings.Contains(string(data), `"attrs":{"http":{"status":200`) {
+			t.Errorf("%T: ndjson should keep objects: %s", client, data)
+		}
+	}
+}
+
+func TestExecutorHead(t *testing.T) {
+	rows := [][]any{{1.0}, {2.0}, {3.0}}
+	client := &fakeClient{resultFn: func(apl string) *axiomclient.QueryResult {
+		n := len(rows)
+		if strings.HasSuffix(apl, "| take 2") {
+			n = 2
+		}
+		return &axiomclient.QueryResult{Tables: []axiomclient.QueryTable{makeTestTable([]string{"n"}, rows[:n])}}
+	}}
+	exec := NewExecutor(client, cache.New(time.Minute, 10, 1<<20, ""), "1h", 100, 0, 1<<20, "")
+	ctx := context.Background()
+	opts := ExecOptions{UseCache: true}
+
+	head, complete, err := exec.ExecuteAPLHead(ctx, "['logs']", "csv", 2, opts)
+	if err != nil || complete || string(head.Bytes) != "n\n1\n2\n" {
+		t.Fatalf("probe = %q, complete %v, err %v", head.Bytes, complete, err)
+	}
+	head, complete, err = exec.ExecuteAPLHead(ctx, "['logs']", "csv", 5, opts)
+	if err != nil || !complete || string(head.Bytes) != "n\n1\n2\n3\n" {
+		t.Fatalf("short probe = %q, complete %v, err %v", head.Bytes, complete, err)
+	}
+
+	// Once the full result is cached, it is served instead of a probe.
+	if _, err := exec.ExecuteAPLResult(ctx, "['logs']", "csv", opts); err != nil {
+		t.Fatal(err)
+	}
+	calls := client.calls
+	head, complete, err = exec.ExecuteAPLHead(ctx, "['logs']", "csv", 1, opts)
+	if err != nil || !complete || string(head.Bytes) != "n\n1\n2\n3\n" || client.calls != calls {
+		t.Errorf("cached = %q, complete %v, err %v, %d more queries", head.Bytes, complete, err, client.calls-calls)
+	}
+}
+
+func TestExecutorNumbers(t *testing.T) {
+	result := &axiomclient.QueryResult{Tables: []axiomclient.QueryTable{makeTestTable([]string{"id", "ratio"}, [][]any{
+		{json.Number("1700000000123456789"), json.Number("0.5")},
+		{json.Number("1700000000123456788"), json.Number("1e3")},
+	})}}
+	exec := NewExecutor(&fakeClient{result: result}, nil, "1h", 100, 0, 0, "")
+	ctx := context.Background()
+
+	data, err := exec.ExecuteAPL(ctx, "['logs']", "ndjson", ExecOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := `{"id":1700000000123456789,"ratio":0.5}`; !strings.HasPrefix(string(data), want) {
+		t.Errorf("ndjson = %s, want prefix %s", data, want)
+	}
+
+	// Integers that differ past float64 precision still sort apart.
+	sort := compiler.Sort{Field: "id", Natural: true}
+	data, err = exec.ExecuteAPL(ctx, "['logs']", "csv", ExecOptions{NaturalSort: &sort})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := "id,ratio\n1700000000123456788,1e3\n1700000000123456789,0.5\n"; string(data) != want {
+		t.Errorf("sorted csv = %q, want %q", data, want)
+	}
+
+	if got := xlsxValue("integer", json.Number("1700000000123456789"), 0); got != "1700000000123456789" {
+		t.Errorf("xlsx big integer = %#v", got)
+	}
+	if got := xlsxValue("float", json.Number("0.5"), 0); got != 0.5 {
+		t.Errorf("xlsx float = %#v", got)
+	}
+}
+
+func TestExecutorNaturalSort(t *testing.T) {
+	client := &fakeClient{resultFn: func(string) *axiomclient.QueryResult {
+		return &axiomclient.QueryResult{
+			Tables: []axiomclient.QueryTable{makeTestTable([]string{"host", "n"}, [][]any{
+				{"web10", float64(1)},
+				{nil, float64(2)},
+				{"Web2", float64(3)},
+				{"web2", float64(4)},
+				{"web1", float64(5)},
+			})},
+		}
+	}}
+	exec := NewExecutor(client, nil, "1h", 100, 0, 0, "")
+	ctx := context.Background()
+	// order returns the n column of the sorted rows.
+	order := func(segment string) string {
+		t.Helper()
+		sort, err := compiler.ParseSort(segment)
+		if err != nil {
+			t.Fatal(err)
+		}
+		data, err := exec.ExecuteAPL(ctx, "['logs']", "ndjson", ExecOptions{NaturalSort: &sort})
+		if err != nil {
+			t.Fatal(err)
+		}
+		var ns []string
+		for _, line := range strings.Split(strings.TrimSpace(string(data)), "\n") {
+			var row struct{ N float64 }
+			if err := json.Unmarshal([]byte(line), &row); err != nil {
+				t.Fatal(err)
+			}
+			ns = append(ns, strconv.Itoa(int(row.N)))
+		}
+		return strings.Join(ns, " ")
+	}
+
+	// Digit runs compare as numbers, case is ignored and ties keep the
+	// order Axiom returned.
+	for segment, want := range map[string]string{
+		"host:asc:natural":            "2 5 3 4 1",
+		"host:desc:natural":           "1 3 4 5 2",
+		"host:asc:natural:nulls-last": "5 3 4 1 2",
+	} {
+		if got := order(segment); got != want {
+			t.Errorf("sort/%s: n = %s, want %s", segment, got, want)
+		}
+	}
+
+	sort := compiler.Sort{Field: "missing", Natural: true}
+	if _, err := exec.ExecuteAPL(ctx, "['logs']", "csv", ExecOptions{NaturalSort: &sort}); err == nil || !strings.Contains(err.Error(), "available: host, n") {
+		t.Errorf("unknown sort column: err = %v", err)
+	}
+}
+
+func TestExecutorEstimateResult(t *testing.T) {
+	client := &fakeClient{resultFn: func(apl string) *axiomclient.QueryResult {
+		if strings.HasSuffix(apl, "| count") {
+			return &axiomclient.QueryResult{
+				Tables: []axiomclient.QueryTable{makeTestTable([]string{"count_"}, [][]any{{float64(100)}})},
+			}
+		}
+		return &axiomclient.QueryResult{
+			Tables: []axiomclient.QueryTable{makeTestTable([]string{"a"}, [][]any{{1}, {2}})},
+		}
+	}}
+	c := cache.New(time.Minute, 10, 1<<20, "")
+	exec := NewExecutor(client, c, "1h", 100, 0, 0, "")
+	ctx := context.Background()
+	apl := "['logs']"
+
+	est, err := exec.EstimateResult(ctx, apl, "ndjson", ExecOptions{UseCache: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	// Each sampled row encodes as {"a":N}\n (8 bytes).
+	if est.Exact || est.Size != 800 {
+		t.Errorf("estimate = %+v, want approximate 800", est)
+	}
+	if len(client.apls) != 2 {
+		t.Errorf("estimate queries = %v, want count probe and sample", client.apls)
+	}
+
+	client.apls = nil
+	if _, err := exec.EstimateResult(ctx, apl, "ndjson", ExecOptions{UseCache: true}); err != nil {
+		t.Fatal(err)
+	}
+	if len(client.apls) != 0 {
+		t.Errorf("repeated estimate should be cached, ran %v", client.apls)
+	}
+
+	data, err := exec.ExecuteAPL(ctx, apl, "ndjson", ExecOptions{UseCache: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	est, err = exec.EstimateResult(ctx, apl, "ndjson", ExecOptions{UseCache: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !est.Exact || est.Size != int64(len(data)) {
+		t.Errorf("estimate after execution = %+v, want exact %d", est, len(data))
+	}
+
+	// Row counts from another format's execution skip the count probe.
+	client.apls = nil
+	est, err = exec.EstimateResult(ctx, apl, "csv", ExecOptions{UseCache: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(client.apls) != 1 || strings.HasSuffix(client.apls[0], "| count") {
+		t.Errorf("csv estimate queries = %v, want sample only", client.apls)
+	}
+	if est.Size != int64(len("a\n1\n2\n")) {
+		t.Errorf("csv estimate = %d", est.Size)
+	}
+}
+
+func TestExecutorDrain(t *testing.T) {
+	started := make(chan struct{})
+	release := make(chan struct{})
+	client := &fakeClient{resultFn: func(string) *axiomclient.QueryResult {
+		close(started)
+		<-release
+		return &axiomclient.QueryResult{}
+	}}
+	exec := NewExecutor(client, nil, "1h", 100, 0, 0, "")
+
+	done := make(chan error, 1)
+	go func() {
+		_, err := exec.ExecuteAPL(context.Background(), "['logs']", "ndjson", ExecOptions{})
+		done <- err
+	}()
+	<-started
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	if err := exec.Drain(ctx); !errors.Is(err, context.DeadlineExceeded) {
+		t.Fatalf("Drain with running query = %v, want deadline exceeded", err)
+	}
+	if _, err := exec.ExecuteAPL(context.Background(), "['other']", "ndjson", ExecOptions{}); !errors.Is(err, drain.ErrDraining) {
+		t.Errorf("new query while draining = %v, want ErrDraining", err)
+	}
+
+	close(release)
+	if err := <-done; err != nil {
+		t.Errorf("in-flight query failed: %v", err)
+	}
+	if err := exec.Drain(context.Background()); err != nil {
+		t.Errorf("Drain after completion: %v", err)
+	}
+}
+
+func TestExecutorResultCount(t *testing.T) {
+	client := &fakeClient{resultFn: func(apl string) *axiomclient.QueryResult {
+		if strings.HasSuffix(apl, "| count") {
+			return &axiomclient.QueryResult{
+				Tables: []axiomclient.QueryTable{makeTestTable([]string{"count_"}, [][]any{{float64(7)}})},
+			}
+		}
+		return &axiomclient.QueryResult{
+			Tables: []axiomclient.QueryTable{makeTestTable([]string{"a"}, [][]any{{1}, {2}, {3}})},
+		}
+	}}
+	c := cache.New(time.Minute, 10, 1<<20, "")
+	exec := NewExecutor(client, c, "1h", 100, 0, 0, "")
+	ctx := context.Background()
+
+	rows, err := exec.ResultCount(ctx, "['logs']", ExecOptions{UseCache: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if rows != 7 || len(client.apls) != 1 {
+		t.Errorf("rows = %d after %v, want 7 from one probe", rows, client.apls)
+	}
+	if _, err := exec.ResultCount(ctx, "['logs']", ExecOptions{UseCache: true}); err != nil || len(client.apls) != 1 {
+		t.Errorf("second count should be cached, ran %v", client.apls)
+	}
+
+	// An executed result's row count wins over a probe.
+	if _, err := exec.ExecuteAPL(ctx, "['other']", "csv", ExecOptions{UseCache: true}); err != nil {
+		t.Fatal(err)
+	}
+	client.apls = nil
+	rows, err = exec.ResultCount(ctx, "['other']", ExecOptions{UseCache: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if rows != 3 || len(client.apls) != 0 {
+		t.Errorf("rows = %d after %v, want 3 from metadata", rows, client.apls)
+	}
+}
+
+func TestExecutorPinnedRange(t *testing.T) {
+	client := &fakeClient{}
+	from := time.Date(2025, 6, 1, 12, 0, 0, 0, time.UTC)
+	to := from.Add(2 * time.Hour)
+	c := cache.New(0, 16, 1<<20, "")
+	exec := NewExecutor(client, c, "7200s", 100, 1<<20, 1<<20, "", WithPinnedRange(from, to))
+	ctx := context.Background()
+
+	apl := "['logs']\n| where _time between (ago(1h) .. now())\n| where ts < now()"
+	if _, err := exec.ExecuteAPLResult(ctx, apl, "csv", ExecOptions{UseCache: true, AutoRange: true}); err != nil {
+		t.Fatal(err)
+	}
+	if len(client.apls) != 1 {
+		t.Fatalf("attempts = %d, want 1 (no auto-range on a pinned mount)", len(client.apls))
+	}
+	got := client.apls[0]
+	for _, want := range []string{
+		`| where _time between (datetime("2025-06-01T12:00:00Z") .. datetime("2025-06-01T14:00:00Z"))`,
+		`| where _time between ((datetime("2025-06-01T14:00:00Z") - 1h) .. datetime("2025-06-01T14:00:00Z"))`,
+		`| where ts < datetime("2025-06-01T14:00:00Z")`,
+	} {
+		if !strings.Contains(got, want) {
+			t.Errorf("APL missing %q:\n%s", want, got)
+		}
+	}
+	if strings.Contains(got, "ago(") || strings.Contains(got, "now()") {
+		t.Errorf("APL still relative to the clock:\n%s", got)
+	}
+
+	// Entry points that call each other pin once; the result is served from
+	// the cache.
+	if _, err := exec.ResultMeta(ctx, apl, "csv", ExecOptions{UseCache: true, EnsureTimeRange: true}); err != nil {
+		t.Fatal(err)
+	}
+	if len(client.apls) != 1 {
+		t.Errorf("ResultMeta re-ran the query:\n%s", strings.Join(client.apls, "\n---\n"))
+	}
+
+	if _, err := exec.ExecuteAPL(ctx, "['logs'] | take 5", "ndjson", ExecOptions{EnsureTimeRange: true}); err != nil {
+		t.Fatal(err)
+	}
+	if got := client.apls[len(client.apls)-1]; strings.Count(got, "_time between") != 2 || strings.Contains(got, "ago(") {
+		t.Errorf("default range not pinned:\n%s", got)
+	}
+}
+
+func TestExecutorEncryptedSpill(t *testing.T) {
+	rows := make([][]any, 5000)
+	for i := range rows {
+		rows[i] = []any{fmt.Sprintf("secret-%d", i)}
+	}
+	client := &fakeClient{result: &axiomclient.QueryResult{
+		Tables: []axiomclient.QueryTable{makeTestTable([]string{"msg"}, rows)},
+	}}
+	sealer, err := atrest.New(bytes.Repeat([]byte{1}, atrest.KeySize))
+	if err != nil {
+		t.Fatal(err)
+	}
+	dir := t.TempDir()
+	exec := NewExecutor(client, nil, "1h", 100, 0, 1024, dir, WithEncryption(sealer))
+	result, err := exec.ExecuteAPLResult(context.Background(), "['logs']", "csv", ExecOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if result.File == nil {
+		t.Fatal("result was not spilled")
+	}
+	defer result.Release()
+	raw, err := os.ReadFile(result.File.Name())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if bytes.Contains(raw, []byte("secret-")) {
+		t.Error("spill file holds the plaintext")
+	}
+	got, err := io.ReadAll(result.File)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if int64(len(got)) != result.Size || !bytes.Contains(got, []byte("secret-4999")) {
+		t.Errorf("decrypted %d of %d bytes", len(got), result.Size)
+	}
+	sum := sha256.Sum256(got)
+	if hex.EncodeToString(sum[:]) != result.Meta.SHA256 {
+		t.Error("decrypted result does not match its SHA-256")
+	}
+}
+
+func TestExecutorSharedSpill(t *testing.T) {
+	rows := make([][]any, 5000)
+	for i := range rows {
+		rows[i] = []any{fmt.Sprintf("row-%d", i)}
+	}
+	gate := &gateClient{started: make(chan string), release: make(chan struct{})}
+	gate.result = &axiomclient.QueryResult{Tables: []axiomclient.QueryTable{makeTestTable([]string{"msg"}, rows)}}
+	exec := NewExecutor(gate, nil, "1h", 100, 0, 1024, t.TempDir())
+	ctx := context.Background()
+
+	results := make(chan ResultData, 2)
+	run := func() {
+		result, err := exec.ExecuteAPLResult(ctx, "['logs']", "csv", ExecOptions{})
+		if err != nil {
+			t.Error(err)
+		}
+		results <- result
+	}
+	go run()
+	<-gate.started
+	go run()
+	for deadline := time.Now().Add(5 * time.Second); ; time.Sleep(time.Millisecond) {
+		exec.results.mu.Lock()
+		joined := false
+		for _, c := range exec.results.calls {
+			joined = c.callers == 2
+		}
+		exec.results.mu.Unlock()
+		if joined {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatal("second read never joined the running query")
+		}
+	}
+	gate.release <- struct{}{}
+	first, second := <-results, <-results
+	if first.File == nil || second.File == nil || first.File == second.File {
+		t.Fatalf("callers share a handle: %v, %v", first.File, second.File)
+	}
+
+	// The first reader is done before the second starts; the second still
+	// reads the whole result, and the file goes with the last handle.
+	want, err := io.ReadAll(first.File)
+	if err != nil {
+		t.Fatal(err)
+	}
+	first.Release()
+	got, err := io.ReadAll(second.File)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(got, want) || int64(len(got)) != second.Size {
+		t.Errorf("second reader got %d bytes, want %d", len(got), len(want))
+	}
+	second.Release()
+	second.Release()
+	if _, err := os.Stat(second.File.Name()); !errors.Is(err, fs.ErrNotExist) {
+		t.Errorf("spill file left behind: %v", err)
+	}
+}
+
+func TestExecutorInvalidateAPL(t *testing.T) {
+	client := &fakeClient{result: &axiomclient.QueryResult{
+		Tables: []axiomclient.QueryTable{makeTestTable([]string{"a"}, [][]any{{1}})},
+	}}
+	c := cache.New(time.Minute, 16, 1<<20, "")
+	exec := NewExecutor(client, c, "1h", 100, 1<<20, 1<<20, "")
+	ctx := context.Background()
+	apl := "['logs'] | count"
+	opts := ExecOptions{UseCache: true}
+
+	for _, format := range []string{"csv", "ndjson"} {
+		if _, err := exec.ExecuteAPLResult(ctx, apl, format, opts); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if _, err := exec.ExecuteAPL(ctx, "['logs'] | take 1", "csv", opts); err != nil {
+		t.Fatal(err)
+	}
+	if n := exec.InvalidateAPL(apl); n == 0 {
+		t.Error("InvalidateAPL removed nothing")
+	}
+	calls := client.calls
+	if _, err := exec.ExecuteAPLResult(ctx, apl, "ndjson", opts); err != nil || client.calls != calls+1 {
+		t.Errorf("invalidated result served from cache: calls = %d, err = %v", client.calls, err)
+	}
+	if _, err := exec.ExecuteAPL(ctx, "['logs'] | take 1", "csv", opts); err != nil || client.calls != calls+1 {
+		t.Errorf("other query's result invalidated: calls = %d, err = %v", client.calls, err)
+	}
+}
+
+func TestExecutorCacheOnly(t *testing.T) {
+	client := &fakeClient{result: &axiomclient.QueryResult{
+		Tables: []axiomclient.QueryTable{makeTestTable([]string{"a"}, [][]any{{1}})},
+	}}
+	c := cache.New(time.Minute, 16, 1<<20, "")
+	exec := NewExecutor(client, c, "1h", 100, 1<<20, 1<<20, "")
+	ctx := context.Background()
+	cached := "['gone'] | count"
+	if _, err := exec.ExecuteAPLResult(ctx, cached, "csv", ExecOptions{UseCache: true}); err != nil {
+		t.Fatal(err)
+	}
+
+	opts := ExecOptions{UseCache: true, CacheOnly: true}
+	calls := client.calls
+	if _, err := exec.ExecuteAPLResult(ctx, cached, "csv", opts); err != nil {
+		t.Errorf("cached result: %v", err)
+	}
+	if _, err := exec.ExecuteAPLResult(ctx, "['gone'] | take 1", "csv", opts); !errors.Is(err, ErrNotCached) || !errors.Is(err, fs.ErrNotExist) {
+		t.Errorf("uncached result: err = %v, want ErrNotCached", err)
+	}
+	if _, err := exec.ResultCount(ctx, "['gone'] | take 1", opts); !errors.Is(err, ErrNotCached) {
+		t.Errorf("uncached count: err = %v, want ErrNotCached", err)
+	}
+	if client.calls != calls {
+		t.Errorf("cache-only calls queried Axiom %d times", client.calls-calls)
+	}
+
+	if n := exec.RetainDataset("gone", time.Now().Add(time.Hour)); n == 0 {
+		t.Error("RetainDataset kept nothing")
+	}
+	if n := exec.InvalidateDataset("gone"); n == 0 {
+		t.Error("InvalidateDataset removed nothing")
+	}
+	if _, err := exec.ExecuteAPLResult(ctx, cached, "csv", opts); !errors.Is(err, ErrNotCached) {
+		t.Errorf("invalidated result: err = %v, want ErrNotCached", err)
+	}
+}
+
+func TestExecutorCanonicalKey(t *testing.T) {
+	client := &fakeClient{result: &axiomclient.QueryResult{
+		Tables: []axiomclient.QueryTable{makeTestTable([]string{"a"}, [][]any{{1}})},
+	}}
+	c := cache.New(time.Minute, 16, 1<<20, "")
+	exec := NewExecutor(client, c, "1h", 100, 1<<20, 1<<20, "")
+	ctx := context.Background()
+	spellings := []string{
+		"['logs'] | where a == 1 and b == 2 | count",
+		"['logs']\n| where b==2\n| where a==1 // both\n| count",
+	}
+	for _, apl := range spellings {
+		if _, err := exec.ExecuteAPLResult(ctx, apl, "csv", ExecOptions{UseCache: true}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if client.calls != 1 {
+		t.Errorf("two spellings of one query ran %d times, want 1", client.calls)
+	}
+	if n := exec.InvalidateAPL(spellings[1]); n == 0 {
+		t.Error("InvalidateAPL of another spelling removed nothing")
+	}
+}
+
+func TestExecutorWarmCache(t *testing.T) {
+	client := &fakeClient{result: &axiomclient.QueryResult{
+		Tables: []axiomclient.QueryTable{makeTestTable([]string{"a"}, [][]any{{1}})},
+	}}
+	c := cache.New(time.Minute, 16, 1<<20, "")
+	log := events.New(0)
+	exec := NewExecutor(client, c, "1h", 100, 1<<20, 1<<20, "", WithEvents(log))
+	ctx := context.Background()
+	opts := WarmOptions{Interval: 20 * time.Second, Concurrency: 2}
+
+	popular := "['logs'] | count"
+	for range 2 {
+		if _, err := exec.ExecuteAPLResult(ctx, popular, "csv", ExecOptions{UseCache: true}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if _, err := exec.ExecuteAPL(ctx, "['logs'] | take 1", "ndjson", ExecOptions{UseCache: true}); err != nil {
+		t.Fatal(err)
+	}
+	if client.calls != 2 {
+		t.Fatalf("calls = %d, want 2", client.calls)
+	}
+
+	// Nothing expires within two intervals yet.
+	if n := exec.warmOnce(ctx, opts); n != 0 {
+		t.Errorf("refreshed %d results long before expiry", n)
+	}
+
+	// With a longer interval the popular result is due; the one read once is
+	// left to expire.
+	opts.Interval = time.Minute
+	if n := exec.warmOnce(ctx, opts); n != 1 {
+		t.Fatalf("refreshed %d results, want 1", n)
+	}
+	if client.calls != 3 || client.apls[2] != popular {
+		t.Errorf("warming ran %v", client.apls)
+	}
+	if _, err := exec.ExecuteAPLResult(ctx, popular, "csv", ExecOptions{UseCache: true}); err != nil || client.calls != 3 {
+		t.Errorf("refreshed result not served from cache: calls = %d, err = %v", client.calls, err)
+	}
+
+	status := exec.WarmStatus()
+	if status.Tracked != 2 || status.Refreshed != 1 || status.LastRun == nil {
+		t.Errorf("status = %+v", status)
+	}
+	buf := make([]byte, log.Size())
+	n, _ := log.ReadAt(ctx, buf, 0)
+	var event events.Event
+	if err := json.Unmarshal(buf[:n], &event); err != nil || event.Type != events.CacheRefreshed || event.APL != popular || event.Format != "csv" {
+		t.Errorf("event = %s (%v)", buf[:n], err)
+	}
+}
+
+func TestExecutorMaxStale(t *testing.T) {
+	client := &fakeClient{result: &axiomclient.QueryResult{
+		Tables: []axiomclient.QueryTable{makeTestTable([]string{"a"}, [][]any{{1}})},
+	}}
+	c := cache.New(50*time.Millisecond, 16, 1<<20, "", cache.WithMaxStale(time.Hour))
+	exec := NewExecutor(client, c, "1h", 100, 1<<20, 1<<20, "")
+	ctx := context.Background()
+	apl := "['logs'] | count"
+	waitRefresh := func() {
+		t.Helper()
+		deadline := time.Now().Add(5 * time.Second)
+		for {
+			running := false
+			exec.staleRefreshes.Range(func(key, value any) bool {
+				running = true
+				return false
+			})
+			if !running {
+				return
+			}
+			if time.Now().After(deadline) {
+				t.Fatal("stale refresh did not finish")
+			}
+			time.Sleep(5 * time.Millisecond)
+		}
+	}
+
+	if _, err := exec.ExecuteAPLResult(ctx, apl, "csv", ExecOptions{UseCache: true}); err != nil {
+		t.Fatal(err)
+	}
+	time.Sleep(100 * time.Millisecond)
+
+	// An expired result is served at once and refreshed in the background.
+	client.result = &axiomclient.QueryResult{
+		Tables: []axiomclient.QueryTable{makeTestTable([]string{"a"}, [][]any{{2}})},
+	}
+	result, err := exec.ExecuteAPLResult(ctx, apl, "csv", ExecOptions{UseCache: true, MaxStale: time.Minute})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(result.Bytes) != "a\n1\n" || !result.Meta.Stale {
+		t.Errorf("stale read = %q, stale %v", result.Bytes, result.Meta.Stale)
+	}
+	waitRefresh()
+	if client.calls != 2 {
+		t.Fatalf("calls = %d, want a background refresh", client.calls)
+	}
+	result, err = exec.ExecuteAPLResult(ctx, apl, "csv", ExecOptions{UseCache: true, MaxStale: time.Minute})
+	if err != nil || string(result.Bytes) != "a\n2\n" || result.Meta.Stale || client.calls != 2 {
+		t.Errorf("after refresh = %q, stale %v, calls %d, err %v", result.Bytes, result.Meta.Stale, client.calls, err)
+	}
+
+	// Without MaxStale an expired result waits for a fresh one.
+	time.Sleep(100 * time.Millisecond)
+	data, err := exec.ExecuteAPL(ctx, apl, "csv", ExecOptions{UseCache: true})
+	if err != nil || client.calls != 3 || string(data) != "a\n2\n" {
+		t.Errorf("fresh read = %q, calls %d, err %v", data, client.calls, err)
+	}
+}
+
+// headerClient is a fakeClient that can send extra query headers.
+type headerClient struct {
+	fakeClient
+	headers []http.Header
+}
+
+func (h *headerClient) QueryAPLWithHeaders(ctx context.Context, apl string, header http.Header) (*axiomclient.QueryResult, error) {
+	h.headers = append(h.headers, header)
+	return h.QueryAPL(ctx, apl)
+}
+
+func TestExecutorHeaders(t *testing.T) {
+	client := &headerClient{}
+	exec := NewExecutor(client, nil, "1h", 100, 0, 0, "")
+	ctx := context.Background()
+	opts := ExecOptions{Headers: http.Header{LabelHeader: {"/logs/q/result.csv"}}}
+
+	for range 2 {
+		if _, err := exec.ExecuteAPL(ctx, "['logs']", "csv", opts); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if _, err := exec.ResultCount(ctx, "['logs']", opts); err != nil {
+		t.Fatal(err)
+	}
+	if len(client.headers) != 3 {
+		t.Fatalf("sent %d queries with headers, want 3", len(client.headers))
+	}
+	ids := map[string]bool{}
+	for _, header := range client.headers {
+		if got := header.Get(LabelHeader); got != "/logs/q/result.csv" {
+			t.Errorf("label = %q", got)
+		}
+		ids[header.Get(RequestIDHeader)] = true
+		if tp := header.Get(TraceparentHeader); len(tp) != 55 || !strings.HasPrefix(tp, "00-") || !strings.HasSuffix(tp, "-01") {
+			t.Errorf("traceparent = %q", tp)
+		}
+	}
+	if len(ids) != 3 {
+		t.Errorf("request IDs not unique: %v", ids)
+	}
+	if len(opts.Headers) != 1 {
+		t.Errorf("caller's headers modified: %v", opts.Headers)
+	}
+
+	// A caller's own trace context is passed through.
+	parent := "00-0af7651916cd43dd8448eb211c80319c-b7ad6b7169203331-01"
+	opts.Headers.Set(TraceparentHeader, parent)
+	if _, err := exec.QueryAPL(ctx, "['logs'] | take 1", opts); err != nil {
+		t.Fatal(err)
+	}
+	if got := client.headers[3].Get(TraceparentHeader); got != parent {
+		t.Errorf("traceparent = %q, want %q", got, parent)
+	}
+}
+
+func TestResultStats(t *testing.T) {
+	client := &fakeClient{result: &axiomclient.QueryResult{
+		Tables: []axiomclient.QueryTable{{
+			Fields: []axiomclient.QueryField{{Name: "status", Type: "integer"}, {Name: "host", Type: "string"}},
+			Columns: [][]any{
+				{float64(200), float64(500), nil, float64(200)},
+				{"web-2", "web-1", "web-2", nil},
+			},
+		}},
+	}}
+	c := cache.New(time.Minute, 10, 1<<20, "")
+	exec := NewExecutor(client, c, "1h", 100, 1<<20, 1<<20, "")
+	ctx := context.Background()
+
+	data, err := exec.ResultStats(ctx, "['logs']", "csv", ExecOptions{UseCache: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := "column,type,count,nulls,distinct,min,max,avg\n" +
+		"status,integer,3,1,2,200,500,300\n" +
+		"host,string,3,1,2,web-1,web-2,\n"
+	if string(data) != want {
+		t.Errorf("stats =\n%s\nwant\n%s", data, want)
+	}
+	// The stats come with the result, so reading both runs one query.
+	if _, err := exec.ExecuteAPL(ctx, "['logs']", "csv", ExecOptions{UseCache: true}); err != nil {
+		t.Fatal(err)
+	}
+	if client.calls != 1 {
+		t.Errorf("calls = %d, want 1", client.calls)
+	}
+}
+
+func TestDistinctEstimate(t *testing.T) {
+	var sketch kmvSketch
+	seed := maphash.MakeSeed()
+	for i := range 100_000 {
+		sketch.add(maphash.String(seed, strconv.Itoa(i%50_000)))
+	}
+	if got := sketch.estimate(); got < 45_000 || got > 55_000 {
+		t.Errorf("estimate = %d, want about 50000", got)
+	}
+}
+
+func TestExecutorCosts(t *testing.T) {
+	client := &fakeClient{result: &axiomclient.QueryResult{
+		Tables: []axiomclient.QueryTable{makeTestTable([]string{"a"}, [][]any{{1}, {2}})},
+		Status: axiomclient.QueryStatus{ElapsedTime: 40, BlocksExamined: 2, RowsExamined: 1000, RowsMatched: 2},
+	}}
+	c := cache.New(time.Minute, 100, 0, "")
+	exec := NewExecutor(client, c, "1h", 100, 0, 0, "")
+	ctx := context.Background()
+
+	opts := ExecOptions{UseCache: true, Label: "team-a"}
+	for range 2 {
+		if _, err := exec.ExecuteAPL(ctx, "['logs']", "csv", opts); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if _, err := exec.ExecuteAPL(ctx, "// label: team-b\n['logs']", "csv", ExecOptions{}); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := exec.QueryAPL(ctx, "['logs'] | take 1", ExecOptions{}); err != nil {
+		t.Fatal(err)
+	}
+	client.err = errors.New("boom")
+	_, _ = exec.QueryAPL(ctx, "['logs'] | take 2", ExecOptions{Label: "team-a"})
+
+	costs := exec.Costs()
+	if len(costs) != 3 {
+		t.Fatalf("costs = %+v, want team-a, team-b and unlabeled", costs)
+	}
+	today := time.Now().UTC().Format(time.DateOnly)
+	a := costs[0]
+	if a.Label != "team-a" || a.Day != today || a.Queries != 2 || a.Errors != 1 || a.RowsExamined != 1000 || a.RowsReturned != 2 || a.AxiomElapsed != 40 {
+		t.Errorf("team-a = %+v, want the cache hit and the failure excluded from status sums", a)
+	}
+	if costs[1].Label != "team-b" || costs[2].Label != Unlabeled {
+		t.Errorf("labels = %s, %s", costs[1].Label, costs[2].Label)
+	}
+}
+
+func TestCostRetention(t *testing.T) {
+	var c costTracker
+	now := time.Now()
+	c.record("a", now.AddDate(0, 0, -costRetention), time.Second, nil, 0, nil)
+	c.record("a", now, time.Second, nil, 0, nil)
+	if costs := c.snapshot(); len(costs) != 1 || costs[0].Day != now.UTC().Format(time.DateOnly) {
+		t.Errorf("costs = %+v, want only today", costs)
+	}
+}
+
+func TestExecutorTables(t *testing.T) {
+	errs := makeTestTable([]string{"status"}, [][]any{{500}})
+	errs.Name = "errors"
+	slow := makeTestTable([]string{"ms"}, [][]any{{900}, {1200}})
+	slow.Name = "slow"
+	unnamed := makeTestTable([]string{"n"}, [][]any{{1}})
+	client := &fakeClient{result: &axiomclient.QueryResult{Tables: []axiomclient.QueryTable{errs, slow, unnamed}}}
+	c := cache.New(time.Minute, 100, 0, "")
+	exec := NewExecutor(client, c, "1h", 100, 0, 0, "")
+	ctx := context.Background()
+
+	data, err := exec.ExecuteAPL(ctx, "['logs']", "ndjson", ExecOptions{UseCache: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := `{"_table":"errors","status":500}
+{"_table":"slow","ms":900}
+{"_table":"slow","ms":1200}
+{"_table":"2","n":1}
+`
+	if string(data) != want {
+		t.Errorf("ndjson =\n%s\nwant\n%s", data, want)
+	}
+
+	meta, err := exec.ResultMeta(ctx, "['logs']", "ndjson", ExecOptions{UseCache: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if strings.Join(meta.Tables, ",") != "errors,slow,2" {
+		t.Errorf("meta tables = %v", meta.Tables)
+	}
+
+	data, err = exec.ExecuteAPL(ctx, "['logs']", "csv", ExecOptions{UseCache: true, Table: "slow"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := string(data); got != "ms\n900\n1200\n" {
+		t.Errorf("slow csv = %q", got)
+	}
+	data, err = exec.ExecuteAPL(ctx, "['logs']", "csv", ExecOptions{UseCache: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := string(data); got != "status\n500\n" {
+		t.Errorf("csv shares the table cache entry: %q", got)
+	}
+
+	_, err = exec.ExecuteAPL(ctx, "['logs']", "csv", ExecOptions{Table: "missing"})
+	if !errors.Is(err, fs.ErrNotExist) || !strings.Contains(err.Error(), "errors, slow, 2") {
+		t.Errorf("unknown table error = %v", err)
+	}
+}
+
+// rowClient is a fakeClient that also hands results over row by row.
+type rowClient struct {
+	fakeClient
+	rowCalls int
+}
+
+func (r *rowClient) QueryAPLRowsWithHeaders(ctx context.Context, apl string, header http.Header, fn axiomclient.RowFunc) (*axiomclient.QueryResult, error) {
+	r.rowCalls++
+	result, err := r.QueryAPL(ctx, apl)
+	if err != nil {
+		return nil, err
+	}
+	described := *result
+	described.Tables = make([]axiomclient.QueryTable, len(result.Tables))
+	for i, table := range result.Tables {
+		described.Tables[i] = axiomclient.QueryTable{Name: table.Name, Fields: table.Fields}
+	}
+	for i, table := range result.Tables {
+		for _, row := range tableRows(table) {
+			if err := fn(&described, i, row); err != nil {
+				return nil, err
+			}
+		}
+	}
+	return &described, nil
+}
+
+func TestExecutorStreamsRows(t *testing.T) {
+	first := makeTestTable([]string{"service", "ms"}, [][]any{{"api", 12.5}, {"web", nil}, {"api", 30.0}})
+	first.Name = "latency"
+	second := makeTestTable([]string{"n"}, [][]any{{1.0}})
+	second.Name = "total"
+	single := &axiomclient.QueryResult{Tables: []axiomclient.QueryTable{first}, QueryID: "q-1"}
+	multi := &axiomclient.QueryResult{Tables: []axiomclient.QueryTable{first, second}}
+	ctx := context.Background()
+
+	for _, tc := range []struct {
+		name   string
+		result *axiomclient.QueryResult
+		format string
+		opts   ExecOptions
+	}{
+		{"csv", single, "csv", ExecOptions{}},
+		{"tsv columns", single, "tsv", ExecOptions{Columns: []string{"ms", "service"}}},
+		{"ndjson", single, "ndjson", ExecOptions{}},
+		{"ndjson tables", multi, "ndjson", ExecOptions{Columns: []string{"ms"}}},
+		{"csv table", multi, "csv", ExecOptions{Table: "total"}},
+		{"empty", &axiomclient.QueryResult{}, "csv", ExecOptions{}},
+		{"no rows", &axiomclient.QueryResult{Tables: []axiomclient.QueryTable{{Fields: first.Fields}}}, "csv", ExecOptions{}},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			want, err := NewExecutor(&fakeClient{result: tc.result}, nil, "1h", 100, 0, 0, "").ExecuteAPLResult(ctx, "['logs']", tc.format, tc.opts)
+			if err != nil {
+				t.Fatal(err)
+			}
+			client := &rowClient{fakeClient: fakeClient{result: tc.result}}
+			got, err := NewExecutor(client, nil, "1h", 100, 0, 0, "").ExecuteAPLResult(ctx, "['logs']", tc.format, tc.opts)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if client.rowCalls != 1 {
+				t.Fatalf("row calls = %d, want the streaming path", client.rowCalls)
+			}
+			if string(got.Bytes) != string(want.Bytes) {
+				t.Errorf("streamed =\n%s\nwant\n%s", got.Bytes, want.Bytes)
+			}
+			got.Meta.ExecutedAt, want.Meta.ExecutedAt = time.Time{}, time.Time{}
+			if !reflect.DeepEqual(got.Meta, want.Meta) {
+				t.Errorf("streamed meta = %+v\nwant %+v", got.Meta, want.Meta)
+			}
+		})
+	}
+
+	client := &rowClient{fakeClient: fakeClient{result: single}}
+	exec := NewExecutor(client, nil, "1h", 100, 0, 0, "")
+	if _, err := exec.ExecuteAPLResult(ctx, "['logs']", "csv", ExecOptions{Columns: []string{"missing"}}); err == nil || !strings.Contains(err.Error(), "available: service, ms") {
+		t.Errorf("unknown column error = %v", err)
+	}
+	if _, err := exec.ExecuteAPLResult(ctx, "['logs']", "xlsx", ExecOptions{}); err != nil {
+		t.Fatal(err)
+	}
+	if client.rowCalls != 1 {
+		t.Errorf("xlsx streamed; it needs the whole result")
+	}
+}
+
+func TestExecutorMaxResultBytes(t *testing.T) {
+	var rows [][]any
+	for i := range 100 {
+		rows = append(rows, []any{fmt.Sprintf("row-%03d", i)})
+	}
+	result := &axiomclient.QueryResult{Tables: []axiomclient.QueryTable{makeTestTable([]string{"name"}, rows)}}
+	ctx := context.Background()
+	marker := cappedMarker("csv", 200)
+
+	for name, client := range map[string]axiomclient.API{
+		"buffered": &fakeClient{result: result},
+		"streamed": &rowClient{fakeClient: fakeClient{result: result}},
+	} {
+		t.Run(name, func(t *testing.T) {
+			exec := NewExecutor(client, nil, "1h", 1000, 0, 0, "", WithMaxResultBytes(200))
+			got, err := exec.ExecuteAPLResult(ctx, "['logs']", "csv", ExecOptions{})
+			if err != nil {
+				t.Fatal(err)
+			}
+			data, ok := strings.CutSuffix(string(got.Bytes), marker)
+			if !ok || len(data) > 200 || !got.Meta.Capped || got.Size != int64(len(got.Bytes)) {
+				t.Fatalf("capped result (%d bytes, capped %v) =\n%s", got.Size, got.Meta.Capped, got.Bytes)
+			}
+			for _, line := range strings.Split(strings.TrimSuffix(data, "\n"), "\n")[1:] {
+				if len(line) != len("row-000") {
+					t.Errorf("cut line %q", line)
+				}
+			}
+
+			if _, err := exec.ExecuteAPLResult(ctx, "['logs']", "json", ExecOptions{}); !errors.Is(err, ErrResultTooLarge) || !errors.Is(err, syscall.EFBIG) {
+				t.Errorf("json over the cap: err = %v, want ErrResultTooLarge", err)
+			}
+		})
+	}
+
+	exec := NewExecutor(&fakeClient{result: result}, nil, "1h", 1000, 0, 0, "", WithMaxResultBytes(200))
+	data, err := exec.ExecuteAPL(ctx, "['logs']", "ndjson", ExecOptions{})
+	if err != nil || !strings.HasSuffix(string(data), cappedMarker("ndjson", 200)) || len(data) > 200+len(marker) {
+		t.Errorf("ExecuteAPL = %q, %v", data, err)
+	}
+	exec = NewExecutor(&fakeClient{result: result}, nil, "1h", 1000, 0, 0, "", WithMaxResultBytes(200), WithInlineMarkers(false))
+	quiet, err := exec.ExecuteAPLResult(ctx, "['logs']", "csv", ExecOptions{})
+	if err != nil || strings.Contains(string(quiet.Bytes), "TRUNCATED") || len(quiet.Bytes) > 200 || !quiet.Meta.Capped {
+		t.Errorf("capped without markers = %q, capped %v, %v", quiet.Bytes, quiet.Meta.Capped, err)
+	}
+	exec = NewExecutor(&fakeClient{result: result}, nil, "1h", 1000, 0, 0, "", WithMaxResultBytes(1<<20))
+	small, err := exec.ExecuteAPLResult(ctx, "['logs']", "csv", ExecOptions{})
+	if err != nil || small.Meta.Capped || strings.Contains(string(small.Bytes), "TRUNCATED") {
+		t.Errorf("result under the cap = %q, capped %v, %v", small.Bytes, small.Meta.Capped, err)
+	}
+}
+
+// gateClient is a fakeClient whose queries report their APL on started and
+// then wait for a value on release.
+type gateClient struct {
+	fakeClient
+	started chan string
+	release chan struct{}
+}
+
+func (g *gateClient) QueryAPL(ctx context.Context, apl string) (*axiomclient.QueryResult, error) {
+	g.started <- apl
+	<-g.release
+	if g.result != nil {
+		return g.result, nil
+	}
+	return &axiomclient.QueryResult{}, nil
+}
+
+func TestExecutorFairQueue(t *testing.T) {
+	gate := &gateClient{started: make(chan string), release: make(chan struct{})}
+	exec := NewExecutor(gate, nil, "1h", 100, 0, 0, "", WithFairQueue(1, map[string]int{"b": 2}))
+	waiting := func(client string, n int) {
+		t.Helper()
+		for deadline := time.Now().Add(5 * time.Second); time.Now().Before(deadline); time.Sleep(time.Millisecond) {
+			for _, c := range exec.Clients().Clients {
+				if c.Client == client && c.Waiting == n {
+					return
+				}
+			}
+		}
+		t.Fatalf("client %s never had %d queries waiting: %+v", client, n, exec.Clients())
+	}
+	var wg sync.WaitGroup
+	run := func(client, apl string) {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if _, err := exec.ExecuteAPL(WithClient(context.Background(), client), apl, "csv", ExecOptions{}); err != nil {
+				t.Error(err)
+			}
+		}()
+	}
+
+	run("a", "['a1']")
+	order := []string{<-gate.started}
+	for i, apl := range []string{"['a2']", "['a3']", "['a4']"} {
+		run("a", apl)
+		waiting("a", i+1)
+	}
+	for i, apl := range []string{"['b1']", "['b2']", "['b3']"} {
+		run("b", apl)
+		waiting("b", i+1)
+	}
+	status := exec.Clients()
+	if status.Slots != 1 || status.Running != 1 || len(status.Clients) != 2 || status.Clients[1].Weight != 2 {
+		t.Fatalf("Clients() = %+v", status)
+	}
+	for len(order) < 7 {
+		gate.release <- struct{}{}
+		order = append(order, <-gate.started)
+	}
+	gate.release <- struct{}{}
+	wg.Wait()
+
+	// a's burst queued first, yet b, weighted 2, takes two slots per turn.
+	want := []string{"['a1']", "['a2']", "['b1']", "['b2']", "['a3']", "['b3']", "['a4']"}
+	if !reflect.DeepEqual(order, want) {
+		t.Errorf("queries ran in order %v, want %v", order, want)
+	}
+	for _, c := range exec.Clients().Clients {
+		if c.Running != 0 || c.Waiting != 0 || c.Queries != map[string]int64{"a": 4, "b": 3}[c.Client] || c.MaxWaitSeconds <= 0 {
+			t.Errorf("client usage = %+v", c)
+		}
+	}
+
+	if got := ClientFrom(context.Background()); got != LocalClient {
+		t.Errorf("ClientFrom(background) = %q, want %q", got, LocalClient)
+	}
+}
+
+// blockingClient is a fakeClient whose queries wait for their context.
+type blockingClient struct {
+	fakeClient
+	started chan struct{}
+}
+
+func (b *blockingClient) QueryAPL(ctx context.Context, apl string) (*axiomclient.QueryResult, error) {
+	b.started <- struct{}{}
+	<-ctx.Done()
+	return nil, ctx.Err()
+}
+
+func TestExecutorInflightJournal(t *testing.T) {
+	journal := filepath.Join(t.TempDir(), "inflight.json")
+	ctx := context.Background()
+	blocking := &blockingClient{started: make(chan struct{})}
+	first := NewExecutor(blocking, nil, "1h", 100, 0, 0, "", WithJournal(journal))
+	errc := make(chan error, 1)
+	go func() {
+		_, err := first.ExecuteAPL(ctx, "// label: nightly\n['logs']", "csv", ExecOptions{})
+		errc <- err
+	}()
+	<-blocking.started
+
+	running := first.Inflight().Running
+	if len(running) != 1 || running[0].Label != "nightly" || running[0].Format != "csv" {
+		t.Fatalf("running = %+v", running)
+	}
+
+	// A second executor on the same journal is the server after a restart.
+	client := &fakeClient{result: &axiomclient.QueryResult{Tables: []axiomclient.QueryTable{makeTestTable([]string{"a"}, [][]any{{1}})}}}
+	second := NewExecutor(client, nil, "1h", 100, 0, 0, "", WithJournal(journal))
+	interrupted := second.Inflight().Interrupted
+	if len(interrupted) != 1 || interrupted[0].ID != running[0].ID {
+		t.Fatalf("interrupted = %+v, want %s", interrupted, running[0].ID)
+	}
+	result, err := second.ExecuteAPLResult(ctx, "// label: nightly\n['logs']", "csv", ExecOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !result.Meta.Restarted {
+		t.Error("rerun of an interrupted query is not flagged")
+	}
+	if status := second.Inflight(); len(status.Interrupted) != 0 || len(status.Running) != 0 {
+		t.Errorf("after rerun = %+v", status)
+	}
+
+	if err := first.CancelQuery("missing"); !errors.Is(err, fs.ErrNotExist) {
+		t.Errorf("CancelQuery(missing) = %v", err)
+	}
+	if err := first.CancelQuery(running[0].ID); err != nil {
+		t.Fatal(err)
+	}
+	if err := <-errc; !errors.Is(err, context.Canceled) {
+		t.Errorf("canceled query error = %v", err)
+	}
+	if len(first.Inflight().Running) != 0 {
+		t.Error("canceled query still listed")
+	}
+}
+
+// slowClient is a fakeClient whose queries take delay.
+type slowClient struct {
+	fakeClient
+	delay time.Duration
+}
+
+func (s *slowClient) QueryAPL(ctx context.Context, apl string) (*axiomclient.QueryResult, error) {
+	time.Sleep(s.delay)
+	return s.fakeClient.QueryAPL(ctx, apl)
+}
+
+func TestExecutorLatency(t *testing.T) {
+	ctx := context.Background()
+	timings := latency.New(10 * time.Millisecond)
+	client := &slowClient{delay: 20 * time.Millisecond, fakeClient: fakeClient{result: &axiomclient.QueryResult{
+		Tables: []axiomclient.QueryTable{makeTestTable([]string{"n"}, [][]any{{1.0}})},
+	}}}
+	exec := NewExecutor(client, nil, "1h", 100, 0, 0, "", WithLatency(timings))
+	opts := ExecOptions{Headers: http.Header{LabelHeader: {"/logs/q/result.csv"}}}
+	if _, err := exec.ExecuteAPLResult(ctx, "['logs']", "csv", opts); err != nil {
+		t.Fatal(err)
+	}
+	client.err = testError{}
+	if _, err := exec.ExecuteAPL(ctx, "['other']", "csv", ExecOptions{}); err == nil {
+		t.Fatal("want the query error")
+	}
+
+	slow := timings.Slow()
+	if len(slow) != 2 {
+		t.Fatalf("slow = %+v", slow)
+	}
+	ok := slow[0]
+	if ok.Op != latency.OpQuery || ok.Path != "/logs/q/result.csv" || ok.APL != "['logs']" || ok.Error != "" {
+		t.Errorf("entry = %+v", ok)
+	}
+	if ok.AxiomSeconds < 0.02 || ok.EncodeSeconds < 0 || ok.AxiomSeconds+ok.EncodeSeconds > ok.Seconds+1e-6 {
+		t.Errorf("split = %v axiom + %v encode of %v", ok.AxiomSeconds, ok.EncodeSeconds, ok.Seconds)
+	}
+	if failed := slow[1]; failed.Error != "test error" || failed.APL != "['other']" {
+		t.Errorf("failed entry = %+v", failed)
+	}
+	if stats := timings.Stats(); len(stats.Ops) != 1 || stats.Ops[0].Count != 2 {
+		t.Errorf("stats = %+v", stats)
+	}
+}
+
+func TestExecutorRedaction(t *testing.T) {
+	result := &axiomclient.QueryResult{Tables: []axiomclient.QueryTable{
+		makeTestTable([]string{"user.email", "token", "status"}, [][]any{{"a@example.com", "t1", 200.0}, {"b@example.com", "t2", 500.0}}),
+	}}
+	redactor, err := redact.New([]redact.Rule{{Field: "user.*", Action: redact.Mask}, {Field: "token", Action: redact.Drop}}, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ctx := context.Background()
+	want := "user.email,status\n[redacted],200\n[redacted],500\n"
+	for _, client := range []axiomclient.API{&fakeClient{result: result}, &rowClient{fakeClient: fakeClient{result: result}}} {
+		exec := NewExecutor(client, nil, "1h", 100, 0, 0, "", WithRedaction(redactor))
+		data, err := exec.ExecuteAPL(ctx, "['logs']", "csv", ExecOptions{})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got := string(data); got != want {
+			t.Errorf("%T: csv = %q, want %q", client, got, want)
+		}
+		data, err = exec.ExecuteAPL(ctx, "['logs']", "ndjson", ExecOptions{})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if strings.Contains(string(data), "example.com") || strings.Contains(string(data), "t1") {
+			t.Errorf("%T: ndjson leaks redacted values: %s", client, data)
+		}
+	}
+}
+
+// pagedClient is a rowClient that also continues partial results from a
+// cursor, one page per cursor.
+type pagedClient struct {
+	rowClient
+	pages   map[string]*axiomclient.QueryResult
+	cursors []string
+}
+
+func (p *pagedClient) QueryAPLFrom(ctx context.Context, apl, cursor string, header http.Header) (*axiomclient.QueryResult, error) {
+	p.cursors = append(p.cursors, cursor)
+	page := *p.pages[cursor]
+	page.Tables = []axiomclient.QueryTable{makeTestTable([]string{"n"}, tableRows(page.Tables[0]))}
+	return &page, nil
+}
+
+func TestExecutorTruncation(t *testing.T) {
+	page := func(cursor string, rows ...any) *axiomclient.QueryResult {
+		table := make([][]any, len(rows))
+		for i, v := range rows {
+			table[i] = []any{v}
+		}
+		status := axiomclient.QueryStatus{RowsExamined: 10}
+		if cursor != "" {
+			status.IsPartial, status.MinCursor = true, cursor
+			status.Messages = []axiomclient.QueryMessage{{Code: "partial", Msg: "query stopped early"}}
+		}
+		return &axiomclient.QueryResult{Tables: []axiomclient.QueryTable{makeTestTable([]string{"n"}, table)}, Status: status}
+	}
+	pages := map[string]*axiomclient.QueryResult{"": page("c1", 1.0), "c1": page("c2", 2.0), "c2": page("", 3.0)}
+	ctx := context.Background()
+
+	// Without following, the first page is served and marked, streamed or not.
+	for _, client := range []axiomclient.API{&fakeClient{result: pages[""]}, &pagedClient{rowClient: rowClient{fakeClient: fakeClient{result: pages[""]}}}} {
+		result, err := NewExecutor(client, nil, "1h", 100, 0, 1<<20, "").ExecuteAPLResult(ctx, "['logs']", "csv", ExecOptions{})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got := string(result.Bytes); got != "n\n1\n"+TruncatedMarker {
+			t.Errorf("%T: csv = %q", client, got)
+		}
+		if !result.Meta.Truncated || !slices.Equal(result.Meta.Warnings, []string{"query stopped early"}) {
+			t.Errorf("%T: meta = %+v", client, result.Meta)
+		}
+	}
+
+	// Without inline markers, only the metadata says so.
+	for _, client := range []axiomclient.API{&fakeClient{result: pages[""]}, &rowClient{fakeClient: fakeClient{result: pages[""]}}} {
+		exec := NewExecutor(client, nil, "1h", 100, 0, 1<<20, "", WithInlineMarkers(false))
+		result, err := exec.ExecuteAPLResult(ctx, "['logs']", "csv", ExecOptions{})
+		if err != nil || string(result.Bytes) != "n\n1\n" || !result.Meta.Truncated {
+			t.Errorf("%T without markers: csv = %q, meta = %+v, %v", client, result.Bytes, result.Meta, err)
+		}
+	}
+
+	for _, tc := range []struct {
+		pages   int
+		want    string
+		cursors []string
+	}{
+		{1, "n\n1\n2\n" + TruncatedMarker, []string{"", "c1"}},
+		{5, "n\n1\n2\n3\n", []string{"", "c1", "c2"}},
+	} {
+		client := &pagedClient{rowClient: rowClient{fakeClient: fakeClient{result: pages[""]}}, pages: pages}
+		exec := NewExecutor(client, nil, "1h", 100, 0, 1<<20, "", WithCursorFollow(tc.pages))
+		result, err := exec.ExecuteAPLResult(ctx, "['logs']", "csv", ExecOptions{})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got := string(result.Bytes); got != tc.want {
+			t.Errorf("%d pages: csv = %q, want %q", tc.pages, got, tc.want)
+		}
+		if !slices.Equal(client.cursors, tc.cursors) || client.rowCalls != 0 {
+			t.Errorf("%d pages: cursors = %q, row calls = %d", tc.pages, client.cursors, client.rowCalls)
+		}
+		if n := len(client.cursors); result.Meta.Truncated != (tc.pages < 2) || n == 3 && result.Meta.Warnings != nil {
+			t.Errorf("%d pages: meta = %+v", tc.pages, result.Meta)
+		}
+	}
+}
+
+// TestEncodeConformance checks what line tools rely on, in every text
+// format: output is empty or ends with exactly one newline, csv and tsv
+// have a header whenever the columns are known, and fresh, streamed and
+// cached reads are byte for byte the same.
+func TestEncodeConformance(t *testing.T) {
+	rows := makeTestTable([]string{"_time", "service", "n"}, [][]any{
+		{"2024-01-01T00:00:00Z", "web", 2.0},
+		{"2024-01-01T00:01:00Z", "api", nil},
+	})
+	ctx := context.Background()
+	for _, tc := range []struct {
+		name   string
+		result *axiomclient.QueryResult
+		opts   ExecOptions
+		header string
+	}{
+		{"rows", &axiomclient.QueryResult{Tables: []axiomclient.QueryTable{rows}}, ExecOptions{}, "_time,service,n"},
+		{"no rows", &axiomclient.QueryResult{Tables: []axiomclient.QueryTable{{Fields: rows.Fields}}}, ExecOptions{}, "_time,service,n"},
+		{"no tables", &axiomclient.QueryResult{}, ExecOptions{}, ""},
+		{"no tables with columns", &axiomclient.QueryResult{}, ExecOptions{Columns: []string{"service", "n"}}, "service,n"},
+	} {
+		for _, format := range []string{"ndjson", "csv", "tsv", "json", "md", "vl.json", "svg"} {
+			t.Run(tc.name+"/"+format, func(t *testing.T) {
+				opts := tc.opts
+				opts.UseCache = true
+				fresh, err := NewExecutor(&fakeClient{result: tc.result}, nil, "1h", 100, 0, 1<<20, "").ExecuteAPL(ctx, "['logs']", format, opts)
+				if err != nil {
+					if format == "vl.json" || format == "svg" {
+						t.Skipf("not chartable: %v", err)
+					}
+					t.Fatal(err)
+				}
+				exec := NewExecutor(&rowClient{fakeClient: fakeClient{result: tc.result}}, cache.New(time.Minute, 10, 1<<20, ""), "1h", 100, 0, 1<<20, "")
+				streamed, err := exec.ExecuteAPLResult(ctx, "['logs']", format, opts)
+				if err != nil {
+					t.Fatal(err)
+				}
+				cached, err := exec.ExecuteAPLResult(ctx, "['logs']", format, opts)
+				if err != nil {
+					t.Fatal(err)
+				}
+				if string(streamed.Bytes) != string(fresh) || string(cached.Bytes) != string(fresh) {
+					t.Errorf("fresh, streamed and cached differ:\n%q\n%q\n%q", fresh, streamed.Bytes, cached.Bytes)
+				}
+				if len(fresh) > 0 && (!bytes.HasSuffix(fresh, []byte("\n")) || bytes.HasSuffix(fresh, []byte("\n\n"))) {
+					t.Errorf("output does not end with exactly one newline: %q", fresh)
+				}
+				if format == "csv" || format == "tsv" {
+					header := tc.header
+					if format == "tsv" {
+						header = strings.ReplaceAll(header, ",", "\t")
+					}
+					if first, _, _ := strings.Cut(string(fresh), "\n"); first != header {
+						t.Errorf("header = %q, want %q", first, header)
+					}
+				}
+			})
+		}
+	}
+}
+
+func TestExecutorGroupOrder(t *testing.T) {
+	runs := 0
+	client := &rowClient{fakeClient: fakeClient{resultFn: func(apl string) *axiomclient.QueryResult {
+		runs++
+		groups := [][]any{{"web", 2.0}, {"api", 5.0}, {nil, 1.0}}
+		if runs%2 == 0 {
+			slices.Reverse(groups)
+		}
+		return &axiomclient.QueryResult{Tables: []axiomclient.QueryTable{makeTestTable([]string{"service", "count_"}, groups)}}
+	}}}
+	exec := NewExecutor(client, nil, "1h", 100, 0, 1<<20, "")
+	ctx := context.Background()
+	summarize := "['logs'] | summarize count() by service"
+	first, err := exec.ExecuteAPL(ctx, summarize, "ndjson", ExecOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	second, err := exec.ExecuteAPLResult(ctx, summarize, "ndjson", ExecOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := "{\"count_\":1,\"service\":null}\n{\"count_\":5,\"service\":\"api\"}\n{\"count_\":2,\"service\":\"web\"}\n"
+	if string(first) != want || string(second.Bytes) != want {
+		t.Errorf("summarize runs =\n%s\n%s\nwant\n%s", first, second.Bytes, want)
+	}
+
+	ordered, err := exec.ExecuteAPL(ctx, summarize+" | order by count_ desc", "csv", ExecOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.HasPrefix(string(ordered), "service,count_\nweb,2\n") {
+		t.Errorf("ordered summarize was reordered:\n%s", ordered)
+	}
+}
+
+func TestExecutorPostProcessors(t *testing.T) {
+	postprocess.Register("test-upper", postprocess.ProcessorFunc(func(ctx context.Context, format string, in io.Reader, out io.Writer) error {
+		data, err := io.ReadAll(in)
+		if err != nil {
+			return err
+		}
+		_, err = out.Write(bytes.ToUpper(data))
+		return err
+	}))
+	post, err := postprocess.New([]postprocess.Rule{{Name: "upper", Path: "*/q", Formats: []string{"csv"}, Plugin: "test-upper"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	result := &axiomclient.QueryResult{Tables: []axiomclient.QueryTable{
+		makeTestTable([]string{"msg"}, [][]any{{"hello"}}),
+	}}
+	ctx := context.Background()
+	label := func(name string) http.Header { return http.Header{LabelHeader: {name}} }
+	for _, client := range []axiomclient.API{&fakeClient{result: result}, &rowClient{fakeClient: fakeClient{result: result}}} {
+		exec := NewExecutor(client, cache.New(time.Minute, 10, 1<<20, ""), "1h", 100, 0, 1<<20, "", WithPostProcessors(post))
+		for _, tc := range []struct {
+			name, format, want string
+		}{
+			{"/logs/q/result.csv", "csv", "MSG\nHELLO\n"},
+			{"/_queries/hello/result.csv", "csv", "msg\nhello\n"},
+			{"/logs/q/result.ndjson", "ndjson", "{\"msg\":\"hello\"}\n"},
+			// Cached apart: the processed result is not served unprocessed.
+			{"/_queries/hello/result.csv", "csv", "msg\nhello\n"},
+			{"/logs/q/result.csv", "csv", "MSG\nHELLO\n"},
+		} {
+			opts := ExecOptions{UseCache: true, Headers: label(tc.name)}
+			res, err := exec.ExecuteAPLResult(ctx, "['logs']", tc.format, opts)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got := string(res.Bytes); got != tc.want {
+				t.Errorf("%T %s: result = %q, want %q", client, tc.name, got, tc.want)
+			}
+			data, err := exec.ExecuteAPL(ctx, "['logs'] | take 1", tc.format, opts)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got := string(data); got != tc.want {
+				t.Errorf("%T %s: bytes = %q, want %q", client, tc.name, got, tc.want)
+			}
+		}
+	}
+
+	// Post-processor output ends with exactly one newline, however the
+	// processor ends it.
+	postprocess.Register("test-ragged", postprocess.ProcessorFunc(func(ctx context.Context, format string, in io.Reader, out io.Writer) error {
+		data, err := io.ReadAll(in)
+		if err != nil {
+			return err
+		}
+		if format == "csv" {
+			data = bytes.TrimRight(data, "\n")
+		} else {
+			data = append(data, "\n\n"...)
+		}
+		_, err = out.Write(data)
+		return err
+	}))
+	ragged, err := postprocess.New([]postprocess.Rule{{Name: "ragged", Path: "logs", Plugin: "test-ragged"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, client := range []axiomclient.API{&fakeClient{result: result}, &rowClient{fakeClient: fakeClient{result: result}}} {
+		exec := NewExecutor(client, nil, "1h", 100, 0, 1<<20, "", WithPostProcessors(ragged))
+		for format, want := range map[string]string{"csv": "msg\nhello\n", "ndjson": "{\"msg\":\"hello\"}\n"} {
+			opts := ExecOptions{Headers: label("/logs/q/result." + format)}
+			res, err := exec.ExecuteAPLResult(ctx, "['logs']", format, opts)
+			if err != nil {
+				t.Fatal(err)
+			}
+			data, err := exec.ExecuteAPL(ctx, "['logs']", format, opts)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if string(res.Bytes) != want || string(data) != want {
+				t.Errorf("%T %s: ragged post-processor output = %q, %q, want %q", client, format, res.Bytes, data, want)
+			}
+		}
+	}
+
+	failing, err := postprocess.New([]postprocess.Rule{{Name: "fail", Path: "logs", Command: []string{"false"}}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	exec := NewExecutor(&rowClient{fakeClient: fakeClient{result: result}}, nil, "1h", 100, 0, 1<<20, "", WithPostProcessors(failing))
+	if _, err := exec.ExecuteAPLResult(ctx, "['logs']", "csv", ExecOptions{Headers: label("/logs/q/result.csv")}); err == nil || !strings.Contains(err.Error(), "post-processor fail") {
+		t.Errorf("failing post-processor: err = %v", err)
+	}
+}
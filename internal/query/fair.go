@@ -0,0 +1,231 @@
+package query
+
+import (
+	"context"
+	"sort"
+	"sync"
+	"time"
+)
+
+// LocalClient is the client of queries that no NFS client asked for, such
+// as cache warming and admin files.
+const LocalClient = "local"
+
+type clientKey struct{}
+
+// WithClient returns ctx carrying the NFS client, by address, on whose
+// behalf queries run.
+func WithClient(ctx context.Context, client string) context.Context {
+	return context.WithValue(ctx, clientKey{}, client)
+}
+
+// ClientFrom returns the client WithClient stored in ctx, or LocalClient.
+func ClientFrom(ctx context.Context) string {
+	if client, ok := ctx.Value(clientKey{}).(string); ok && client != "" {
+		return client
+	}
+	return LocalClient
+}
+
+// WithFairQueue runs at most slots queries against Axiom at once. Queries
+// past that wait in one queue per client, and freed slots go round the
+// clients with waiting queries, each taking up to its weight (1 unless set
+// in weights) in a row, so one client's burst cannot starve the others.
+// Zero slots, the default, runs every query at once.
+func WithFairQueue(slots int, weights map[string]int) Option {
+	return func(e *Executor) {
+		e.fair = &fairQueue{slots: slots, weights: weights, clients: map[string]*clientQueue{}}
+	}
+}
+
+// ClientUsage is one client's line in /_status/clients.json.
+type ClientUsage struct {
+	Client  string `json:"client"`
+	Weight  int    `json:"weight"`
+	Running int    `json:"running"`
+	Waiting int    `json:"waiting"`
+	Queries int64  `json:"queries"`
+	// WaitSeconds is the total time the client's queries queued for a
+	// slot; MaxWaitSeconds the longest single wait.
+	WaitSeconds    float64 `json:"wait_seconds"`
+	MaxWaitSeconds float64 `json:"max_wait_seconds"`
+}
+
+// ClientStatus is /_status/clients.json.
+type ClientStatus struct {
+	Slots   int           `json:"slots"`
+	Running int           `json:"running"`
+	Clients []ClientUsage `json:"clients"`
+}
+
+// Clients reports the fair queue's slots and per-client usage. It is
+// empty when queries are not queued.
+func (e *Executor) Clients() ClientStatus {
+	if e.fair == nil {
+		return ClientStatus{Clients: []ClientUsage{}}
+	}
+	return e.fair.status()
+}
+
+type fairQueue struct {
+	slots   int
+	weights map[string]int
+
+	mu      sync.Mutex
+	running int
+	clients map[string]*clientQueue
+	// ring holds the clients with waiting queries, in turn order; turn
+	// is the position of the client being served and credit what it
+	// may still take this turn.
+	ring   []string
+	turn   int
+	credit int
+}
+
+type clientQueue struct {
+	waiting []chan struct{}
+	usage   ClientUsage
+}
+
+func (q *fairQueue) weight(client string) int {
+	if w := q.weights[client]; w > 0 {
+		return w
+	}
+	return 1
+}
+
+func (q *fairQueue) client(name string) *clientQueue {
+	c, ok := q.clients[name]
+	if !ok {
+		c = &clientQueue{usage: ClientUsage{Client: name, Weight: q.weight(name)}}
+		q.clients[name] = c
+	}
+	return c
+}
+
+// acquire waits for a slot for client until ctx ends.
+func (q *fairQueue) acquire(ctx context.Context, client string) error {
+	if q == nil || q.slots <= 0 {
+		return nil
+	}
+	start := time.Now()
+	q.mu.Lock()
+	c := q.client(client)
+	c.usage.Queries++
+	if q.running < q.slots && len(q.ring) == 0 {
+		q.running++
+		c.usage.Running++
+		q.mu.Unlock()
+		return nil
+	}
+	ready := make(chan struct{})
+	c.waiting = append(c.waiting, ready)
+	if len(c.waiting) == 1 {
+		q.ring = append(q.ring, client)
+	}
+	q.mu.Unlock()
+
+	select {
+	case <-ready:
+		q.waited(c, time.Since(start))
+		return nil
+	case <-ctx.Done():
+	}
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	select {
+	case <-ready:
+		// Granted as ctx ended; hand the slot on.
+		c.usage.Running--
+		q.running--
+		q.grantLocked()
+	default:
+		q.dequeueLocked(client, c, ready)
+	}
+	return ctx.Err()
+}
+
+func (q *fairQueue) waited(c *clientQueue, d time.Duration) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	c.usage.WaitSeconds += d.Seconds()
+	c.usage.MaxWaitSeconds = max(c.usage.MaxWaitSeconds, d.Seconds())
+}
+
+// release frees client's slot for the next waiting query.
+func (q *fairQueue) release(client string) {
+	if q == nil || q.slots <= 0 {
+		return
+	}
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	q.client(client).usage.Running--
+	q.running--
+	q.grantLocked()
+}
+
+// grantLocked hands free slots to waiting queries, the client whose turn
+// it is first.
+func (q *fairQueue) grantLocked() {
+	for q.running < q.slots && len(q.ring) > 0 {
+		if q.turn >= len(q.ring) {
+			q.turn = 0
+		}
+		name := q.ring[q.turn]
+		c := q.clients[name]
+		if q.credit <= 0 {
+			q.credit = q.weight(name)
+		}
+		ready := c.waiting[0]
+		c.waiting = c.waiting[1:]
+		c.usage.Running++
+		q.running++
+		q.credit--
+		close(ready)
+		if len(c.waiting) == 0 {
+			q.ring = append(q.ring[:q.turn], q.ring[q.turn+1:]...)
+			q.credit = 0
+		} else if q.credit == 0 {
+			q.turn++
+		}
+	}
+}
+
+// dequeueLocked drops a query that stopped waiting.
+func (q *fairQueue) dequeueLocked(name string, c *clientQueue, ready chan struct{}) {
+	for i, w := range c.waiting {
+		if w == ready {
+			c.waiting = append(c.waiting[:i], c.waiting[i+1:]...)
+			break
+		}
+	}
+	if len(c.waiting) > 0 {
+		return
+	}
+	for i, n := range q.ring {
+		if n != name {
+			continue
+		}
+		q.ring = append(q.ring[:i], q.ring[i+1:]...)
+		switch {
+		case i < q.turn:
+			q.turn--
+		case i == q.turn:
+			q.credit = 0
+		}
+		break
+	}
+}
+
+func (q *fairQueue) status() ClientStatus {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	status := ClientStatus{Slots: q.slots, Running: q.running, Clients: make([]ClientUsage, 0, len(q.clients))}
+	for _, c := range q.clients {
+		usage := c.usage
+		usage.Waiting = len(c.waiting)
+		status.Clients = append(status.Clients, usage)
+	}
+	sort.Slice(status.Clients, func(i, j int) bool { return status.Clients[i].Client < status.Clients[j].Client })
+	return status
+}
@@ -0,0 +1,127 @@
+package query
+
+import (
+	"slices"
+	"strings"
+
+	"github.com/axiomhq/axiom-fs/internal/axiomclient"
+	"github.com/axiomhq/axiom-fs/internal/compiler"
+)
+
+// WithFlattenDepth caps how many levels of nested objects flatten/dot
+// spreads over columns; deeper objects stay whole, as JSON. Zero means no
+// cap.
+func WithFlattenDepth(depth int) Option {
+	return func(e *Executor) { e.flattenDepth = depth }
+}
+
+// flattens reports whether format spreads nested objects over columns
+// when flattening is asked for. ndjson keeps them as JSON objects.
+func flattens(format string, opts ExecOptions) bool {
+	switch format {
+	case "csv", "tsv":
+		return opts.Flatten != ""
+	default:
+		return false
+	}
+}
+
+// flattenResult returns result with the first table's object columns
+// spread over one column per key path, name.key.sub, up to depth levels.
+// Rows whose value is not an object keep it in the original column, which
+// is dropped when no row needs it.
+func flattenResult(result *axiomclient.QueryResult, mode string, depth int) *axiomclient.QueryResult {
+	if mode != compiler.FlattenDot || len(result.Tables) == 0 {
+		return result
+	}
+	table := result.Tables[0]
+	flat := axiomclient.QueryTable{Name: table.Name}
+	changed := false
+	for i, field := range table.Fields {
+		var column []any
+		if i < len(table.Columns) {
+			column = table.Columns[i]
+		}
+		paths, scalar := objectPaths(column, depth)
+		if len(paths) == 0 {
+			flat.Fields = append(flat.Fields, field)
+			flat.Columns = append(flat.Columns, column)
+			continue
+		}
+		changed = true
+		if scalar {
+			kept := make([]any, len(column))
+			for j, v := range column {
+				if _, ok := v.(map[string]any); !ok {
+					kept[j] = v
+				}
+			}
+			flat.Fields = append(flat.Fields, field)
+			flat.Columns = append(flat.Columns, kept)
+		}
+		for _, p := range paths {
+			values := make([]any, len(column))
+			for j, v := range column {
+				values[j] = lookupPath(v, p)
+			}
+			flat.Fields = append(flat.Fields, axiomclient.QueryField{Name: field.Name + "." + strings.Join(p, ".")})
+			flat.Columns = append(flat.Columns, values)
+		}
+	}
+	if !changed {
+		return result
+	}
+	out := *result
+	out.Tables = append([]axiomclient.QueryTable{flat}, result.Tables[1:]...)
+	return &out
+}
+
+// objectPaths returns the key paths of the objects in column, in the
+// order first seen with each object's keys sorted, and whether any row
+// holds a non-null value that is not an object.
+func objectPaths(column []any, depth int) ([][]string, bool) {
+	var paths [][]string
+	seen := map[string]bool{}
+	scalar := false
+	var walk func(obj map[string]any, prefix []string)
+	walk = func(obj map[string]any, prefix []string) {
+		keys := make([]string, 0, len(obj))
+		for k := range obj {
+			keys = append(keys, k)
+		}
+		slices.Sort(keys)
+		for _, k := range keys {
+			p := append(slices.Clone(prefix), k)
+			if child, ok := obj[k].(map[string]any); ok && len(child) > 0 && (depth <= 0 || len(p) < depth) {
+				walk(child, p)
+				continue
+			}
+			if key := strings.Join(p, "\x00"); !seen[key] {
+				seen[key] = true
+				paths = append(paths, p)
+			}
+		}
+	}
+	for _, v := range column {
+		switch v := v.(type) {
+		case nil:
+		case map[string]any:
+			walk(v, nil)
+		default:
+			scalar = true
+		}
+	}
+	return paths, scalar
+}
+
+// lookupPath returns the value at path in v, nil when v has none.
+func lookupPath(v any, path []string) any {
+	for _, k := range path {
+		obj, ok := v.(map[string]any)
+		if !ok {
+			return nil
+		}
+		v = obj[k]
+	}
+	return v
+}
@@ -0,0 +1,38 @@
+package query
+
+import (
+	"context"
+)
+
+// ExecuteAPLHead serves the start of apl's result without running the full
+// query when it can: the full result if it is cached, otherwise the first
+// rows rows, cached on their own. complete reports whether the data is the
+// whole result, cached or shorter than rows rows.
+func (e *Executor) ExecuteAPLHead(ctx context.Context, apl, format string, rows int, opts ExecOptions) (ResultData, bool, error) {
+	if e.cachedResult(apl, format, opts) {
+		result, err := e.ExecuteAPLResult(ctx, apl, format, opts)
+		return result, true, err
+	}
+	result, err := e.ExecuteAPLResult(ctx, apl+"\n| take "+itoa(rows), format, opts)
+	if err != nil {
+		return ResultData{}, false, err
+	}
+	return result, result.Meta.Rows < int64(rows), nil
+}
+
+// cachedResult reports whether ExecuteAPLResult would serve apl from the
+// cache.
+func (e *Executor) cachedResult(apl, format string, opts ExecOptions) bool {
+	if !opts.UseCache || e.cache == nil {
+		return false
+	}
+	if opts.EnsureTimeRange {
+		apl = ensureTimeRange(apl, e.rangeFor(opts))
+	}
+	if opts.EnsureLimit {
+		apl = ensureLimit(apl, e.limitFor(opts))
+	}
+	apl, opts = e.pin(apl, opts)
+	_, ok := e.cache.Lookup(e.resultKey(apl, format, opts))
+	return ok
+}
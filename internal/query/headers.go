@@ -0,0 +1,48 @@
+package query
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"net/http"
+
+	"github.com/axiomhq/axiom-fs/internal/axiomclient"
+)
+
+// Headers sent with queries so Axiom's query logs can be traced back to the
+// mount.
+const (
+	// LabelHeader names what the query was run for, e.g. the path read.
+	LabelHeader     = "X-Axiom-Query-Label"
+	RequestIDHeader = "X-Request-ID"
+	// TraceparentHeader is the W3C trace context of the query.
+	TraceparentHeader = "Traceparent"
+)
+
+// headerQuerier is implemented by clients that can send extra headers with
+// a query.
+type headerQuerier interface {
+	QueryAPLWithHeaders(ctx context.Context, apl string, header http.Header) (*axiomclient.QueryResult, error)
+}
+
+// queryHeaders returns header plus a request ID and a sampled traceparent,
+// each kept when header already has one.
+func queryHeaders(header http.Header) http.Header {
+	out := header.Clone()
+	if out == nil {
+		out = http.Header{}
+	}
+	if out.Get(RequestIDHeader) == "" {
+		out.Set(RequestIDHeader, randomHex(16))
+	}
+	if out.Get(TraceparentHeader) == "" {
+		out.Set(TraceparentHeader, "00-"+randomHex(16)+"-"+randomHex(8)+"-01")
+	}
+	return out
+}
+
+func randomHex(n int) string {
+	b := make([]byte, n)
+	_, _ = rand.Read(b)
+	return hex.EncodeToString(b)
+}
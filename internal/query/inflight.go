@@ -0,0 +1,194 @@
+package query
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"sync"
+	"time"
+)
+
+// interruptedRetention is how long a query cut off by a restart is
+// remembered when it is not run again.
+const interruptedRetention = 24 * time.Hour
+
+// InflightQuery is a result query running against Axiom, or one that was
+// running when the server last stopped.
+type InflightQuery struct {
+	ID        string    `json:"id"`
+	APL       string    `json:"apl"`
+	Format    string    `json:"format"`
+	Label     string    `json:"label"`
+	StartedAt time.Time `json:"started_at"`
+	// ElapsedSeconds is how long a running query has taken so far.
+	ElapsedSeconds float64 `json:"elapsed_seconds,omitempty"`
+	// Restarted is set when an earlier run of the same query was cut off
+	// by a restart; this run started over.
+	Restarted bool `json:"restarted,omitempty"`
+}
+
+// InflightStatus is /_status/inflight.json.
+type InflightStatus struct {
+	Running []InflightQuery `json:"running"`
+	// Interrupted lists queries that were running when the server stopped
+	// and have not been run since.
+	Interrupted []InflightQuery `json:"interrupted"`
+}
+
+// journalEntry is a query as saved in the journal.
+type journalEntry struct {
+	Key string `json:"key"`
+	InflightQuery
+}
+
+type runningQuery struct {
+	InflightQuery
+	key    string
+	cancel context.CancelFunc
+}
+
+// inflightTracker tracks the result queries running against Axiom. With a
+// journal path, it saves them on every change, so queries cut off by a
+// restart are known after it.
+type inflightTracker struct {
+	mu          sync.Mutex
+	journal     string
+	running     map[string]*runningQuery
+	interrupted map[string]InflightQuery
+}
+
+// WithJournal keeps a journal of running result queries at path. Queries
+// found in it at startup were cut off by a restart: they are listed in
+// Inflight, and their next run is flagged as restarted in its metadata.
+func WithJournal(path string) Option {
+	return func(e *Executor) {
+		e.running.journal = path
+		e.running.load(time.Now())
+	}
+}
+
+func (t *inflightTracker) load(now time.Time) {
+	data, err := os.ReadFile(t.journal)
+	if err != nil {
+		return
+	}
+	var entries []journalEntry
+	if err := json.Unmarshal(data, &entries); err != nil {
+		return
+	}
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.interrupted = map[string]InflightQuery{}
+	for _, entry := range entries {
+		if now.Sub(entry.StartedAt) > interruptedRetention {
+			continue
+		}
+		entry.ElapsedSeconds = 0
+		t.interrupted[entry.Key] = entry.InflightQuery
+	}
+	t.saveLocked()
+}
+
+// begin registers a query about to run and returns a context Cancel can
+// cancel. end must be called once it finishes.
+func (t *inflightTracker) begin(ctx context.Context, key, apl, format string, opts ExecOptions) (context.Context, *runningQuery) {
+	ctx, cancel := context.WithCancel(ctx)
+	q := &runningQuery{
+		InflightQuery: InflightQuery{
+			ID:        randomHex(8),
+			APL:       apl,
+			Format:    format,
+			Label:     costLabel(apl, opts),
+			StartedAt: time.Now().UTC(),
+		},
+		key:    key,
+		cancel: cancel,
+	}
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if _, ok := t.interrupted[key]; ok {
+		q.Restarted = true
+		delete(t.interrupted, key)
+	}
+	if t.running == nil {
+		t.running = map[string]*runningQuery{}
+	}
+	t.running[q.ID] = q
+	t.saveLocked()
+	return ctx, q
+}
+
+func (t *inflightTracker) end(q *runningQuery) {
+	q.cancel()
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	delete(t.running, q.ID)
+	t.saveLocked()
+}
+
+// saveLocked rewrites the journal. Errors are ignored: the journal only
+// improves what is reported after a restart.
+func (t *inflightTracker) saveLocked() {
+	if t.journal == "" {
+		return
+	}
+	entries := make([]journalEntry, 0, len(t.running)+len(t.interrupted))
+	for _, q := range t.running {
+		entries = append(entries, journalEntry{Key: q.key, InflightQuery: q.InflightQuery})
+	}
+	for key, q := range t.interrupted {
+		entries = append(entries, journalEntry{Key: key, InflightQuery: q})
+	}
+	data, err := json.Marshal(entries)
+	if err != nil {
+		return
+	}
+	if err := os.MkdirAll(filepath.Dir(t.journal), 0o755); err != nil {
+		return
+	}
+	tmp := t.journal + ".tmp"
+	if err := os.WriteFile(tmp, data, 0o600); err != nil {
+		return
+	}
+	_ = os.Rename(tmp, t.journal)
+}
+
+func (t *inflightTracker) status(now time.Time) InflightStatus {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	status := InflightStatus{Running: []InflightQuery{}, Interrupted: []InflightQuery{}}
+	for _, q := range t.running {
+		info := q.InflightQuery
+		info.ElapsedSeconds = now.Sub(q.StartedAt).Seconds()
+		status.Running = append(status.Running, info)
+	}
+	for _, q := range t.interrupted {
+		status.Interrupted = append(status.Interrupted, q)
+	}
+	for _, list := range [][]InflightQuery{status.Running, status.Interrupted} {
+		sort.Slice(list, func(i, j int) bool { return list[i].StartedAt.Before(list[j].StartedAt) })
+	}
+	return status
+}
+
+// Inflight reports the result queries running against Axiom, oldest
+// first, and those a restart cut off.
+func (e *Executor) Inflight() InflightStatus {
+	return e.running.status(time.Now())
+}
+
+// CancelQuery cancels the running query with the given ID, as listed by
+// Inflight. Everyone waiting on its result gets an error.
+func (e *Executor) CancelQuery(id string) error {
+	e.running.mu.Lock()
+	q, ok := e.running.running[id]
+	e.running.mu.Unlock()
+	if !ok {
+		return fmt.Errorf("%w: no running query %q", os.ErrNotExist, id)
+	}
+	q.cancel()
+	return nil
+}
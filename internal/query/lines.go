@@ -0,0 +1,66 @@
+package query
+
+import (
+	"bytes"
+	"io"
+)
+
+// Encoded results in every format but xlsx end with exactly one newline,
+// or are empty, so line tools such as diff, join and wc see whole lines.
+// The encoders do so on their own; post-processors may not, so their
+// output is passed through endLine or a lineEnder.
+
+// endsLines reports whether results in format are text ending with a
+// newline.
+func endsLines(format string) bool {
+	return format != "xlsx"
+}
+
+// endLine returns data ending with exactly one newline, or empty.
+func endLine(data []byte) []byte {
+	trimmed := bytes.TrimRight(data, "\n")
+	if len(trimmed) == 0 {
+		return trimmed
+	}
+	return append(trimmed, '\n')
+}
+
+// lineEnder is endLine for a stream: it holds back newlines until more
+// text follows them, and finish ends the output with one.
+type lineEnder struct {
+	w     io.Writer
+	held  int
+	wrote bool
+}
+
+func newLineEnder(w io.Writer) *lineEnder {
+	return &lineEnder{w: w}
+}
+
+func (l *lineEnder) Write(p []byte) (int, error) {
+	trimmed := bytes.TrimRight(p, "\n")
+	if len(trimmed) == 0 {
+		l.held += len(p)
+		return len(p), nil
+	}
+	if l.held > 0 {
+		if _, err := l.w.Write(bytes.Repeat([]byte{'\n'}, l.held)); err != nil {
+			return 0, err
+		}
+	}
+	if _, err := l.w.Write(trimmed); err != nil {
+		return 0, err
+	}
+	l.held = len(p) - len(trimmed)
+	l.wrote = true
+	return len(p), nil
+}
+
+// finish writes the final newline, if anything was written.
+func (l *lineEnder) finish() error {
+	if !l.wrote {
+		return nil
+	}
+	_, err := l.w.Write([]byte{'\n'})
+	return err
+}
@@ -0,0 +1,100 @@
+package query
+
+import (
+	"fmt"
+	"io"
+	"strings"
+
+	"github.com/axiomhq/axiom-fs/internal/axiomclient"
+)
+
+// markdownMaxRows is how many rows result.md shows. It is meant for pasting
+// into incident docs and PRs, so longer results end with a note instead.
+const markdownMaxRows = 100
+
+var markdownCellEscaper = strings.NewReplacer(
+	`\`, `\\`,
+	"|", `\|`,
+	"\r\n", "<br>",
+	"\n", "<br>",
+	"\r", "<br>",
+)
+
+// encodeMarkdownToWriter writes table as a GitHub-flavored Markdown table of
+// at most markdownMaxRows rows.
+func encodeMarkdownToWriter(table axiomclient.QueryTable, w io.Writer) error {
+	if len(table.Fields) == 0 {
+		_, err := io.WriteString(w, "_No results._\n")
+		return err
+	}
+	var b strings.Builder
+	cells := make([]string, len(table.Fields))
+	for i, field := range table.Fields {
+		cells[i] = markdownCellEscaper.Replace(field.Name)
+	}
+	writeMarkdownRow(&b, cells)
+	for i := range cells {
+		cells[i] = "---"
+	}
+	writeMarkdownRow(&b, cells)
+	rows := tableRows(table)
+	for _, row := range rows[:min(len(rows), markdownMaxRows)] {
+		for i := range cells {
+			cells[i] = ""
+			if i < len(row) && row[i] != nil {
+				cells[i] = markdownCellEscaper.Replace(stringify(row[i]))
+			}
+		}
+		writeMarkdownRow(&b, cells)
+	}
+	if len(rows) > markdownMaxRows {
+		fmt.Fprintf(&b, "\n_Showing the first %d of %d rows._\n", markdownMaxRows, len(rows))
+	}
+	_, err := io.WriteString(w, b.String())
+	return err
+}
+
+func writeMarkdownRow(b *strings.Builder, cells []string) {
+	b.WriteString("|")
+	for _, cell := range cells {
+		b.WriteString(" " + cell + " |")
+	}
+	b.WriteString("\n")
+}
+
+// markdownFooter ends result.md with the APL that produced it and its time
+// range, so a pasted table says where it came from.
+func markdownFooter(apl string) string {
+	fence := "```"
+	for strings.Contains(apl, fence) {
+		fence += "`"
+	}
+	footer := "\n" + fence + "kusto\n" + strings.TrimSpace(apl) + "\n" + fence + "\n"
+	if rng := timeRange(apl); rng != "" {
+		footer += "\nRange: `" + rng + "`\n"
+	}
+	return footer
+}
+
+// timeRange returns the bounds of apl's first `_time between (…)` filter,
+// e.g. "ago(1h) .. now()", or "" when it has none.
+func timeRange(apl string) string {
+	const marker = "_time between ("
+	start := strings.Index(apl, marker)
+	if start < 0 {
+		return ""
+	}
+	start += len(marker)
+	depth := 1
+	for i := start; i < len(apl); i++ {
+		switch apl[i] {
+		case '(':
+			depth++
+		case ')':
+			if depth--; depth == 0 {
+				return strings.TrimSpace(apl[start:i])
+			}
+		}
+	}
+	return ""
+}
@@ -0,0 +1,141 @@
+package query
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"time"
+
+	"github.com/axiomhq/axiom-fs/internal/axiomclient"
+	"github.com/axiomhq/axiom-fs/internal/cache"
+)
+
+// ResultMeta describes one execution of a query: what ran, when, and a
+// checksum of the encoded output so copied exports can be verified.
+type ResultMeta struct {
+	APL        string    `json:"apl"`
+	Format     string    `json:"format"`
+	ExecutedAt time.Time `json:"executed_at"`
+	// Rows is -1 when the result predates metadata and the count is unknown.
+	Rows    int64  `json:"rows"`
+	Bytes   int64  `json:"bytes"`
+	SHA256  string `json:"sha256"`
+	QueryID string `json:"query_id,omitempty"`
+	// RowsMatched is Axiom's rows-matched count for the execution.
+	RowsMatched int64 `json:"rows_matched"`
+	// Version identifies the content: equal versions mean equal bytes.
+	Version string `json:"version"`
+	// Columns summarizes each result column; nil when the result predates
+	// metadata.
+	Columns []ColumnStats `json:"columns"`
+	// Tables names the result's tables as tables/<name>.csv does; nil when
+	// the result predates metadata.
+	Tables []string `json:"tables"`
+	// Restarted is set when the execution reran a query a restart had cut
+	// off; see WithJournal.
+	Restarted bool `json:"restarted,omitempty"`
+	// Truncated is set when Axiom reported the result as missing matching
+	// rows; Warnings are the messages it attached.
+	Truncated bool     `json:"truncated,omitempty"`
+	Warnings  []string `json:"warnings,omitempty"`
+	// Capped is set when the encoded result was cut at -max-result-bytes;
+	// see WithMaxResultBytes.
+	Capped bool `json:"capped,omitempty"`
+	// Stale is set when the result was served past its cache expiry while
+	// a refresh runs; see ExecOptions.MaxStale.
+	Stale bool `json:"stale,omitempty"`
+}
+
+func newResultMeta(apl, format string, result *axiomclient.QueryResult, size int64, sum []byte) ResultMeta {
+	return ResultMeta{
+		APL:         apl,
+		Format:      format,
+		ExecutedAt:  time.Now().UTC(),
+		Rows:        resultRows(result),
+		Bytes:       size,
+		SHA256:      hex.EncodeToString(sum),
+		QueryID:     result.QueryID,
+		RowsMatched: result.Status.RowsMatched,
+		Columns:     columnStats(result),
+		Tables:      TableNames(result),
+		Truncated:   result.Status.Truncated(),
+		Warnings:    result.Status.Warnings(),
+	}
+}
+
+// metaKey is the cache key holding the ResultMeta for a result key. Meta is
+// stored in the cache's metadata segment with the same TTL as the result.
+func metaKey(key string) string {
+	return "meta|" + key
+}
+
+func (e *Executor) storeMeta(key string, meta ResultMeta) {
+	data, err := json.Marshal(meta)
+	if err != nil {
+		return
+	}
+	e.cache.SetMeta(metaKey(key), data)
+}
+
+func (e *Executor) lookupMeta(key string) (ResultMeta, bool) {
+	if e.cache == nil {
+		return ResultMeta{}, false
+	}
+	data, ok := e.cache.Get(metaKey(key))
+	if !ok {
+		return ResultMeta{}, false
+	}
+	var meta ResultMeta
+	if err := json.Unmarshal(data, &meta); err != nil {
+		return ResultMeta{}, false
+	}
+	return meta, true
+}
+
+// cachedMeta returns the stored meta for a cached result, or derives what it
+// can from the cached bytes when none was stored.
+func (e *Executor) cachedMeta(key, apl, format string, entry cache.Entry) ResultMeta {
+	sum := sha256.Sum256(entry.Bytes)
+	if meta, ok := e.lookupMeta(key); ok && meta.SHA256 == hex.EncodeToString(sum[:]) {
+		return meta
+	}
+	return ResultMeta{
+		APL:        apl,
+		Format:     format,
+		ExecutedAt: entry.StoredAt.UTC(),
+		Rows:       -1,
+		Bytes:      int64(len(entry.Bytes)),
+		SHA256:     hex.EncodeToString(sum[:]),
+		Version:    contentVersion(apl, format, hex.EncodeToString(sum[:]), 0),
+	}
+}
+
+// ResultMeta returns the metadata of the execution backing a result file.
+// Stored metadata is reused so the checksum matches what readers received,
+// including results that spilled to disk and were not cached.
+func (e *Executor) ResultMeta(ctx context.Context, apl, format string, opts ExecOptions) (ResultMeta, error) {
+	if opts.EnsureTimeRange {
+		apl = ensureTimeRange(apl, e.rangeFor(opts))
+	}
+	if opts.EnsureLimit {
+		apl = ensureLimit(apl, e.limitFor(opts))
+	}
+	apl, opts = e.pin(apl, opts)
+	if opts.UseCache && !opts.AutoRange && !opts.refresh {
+		meta, ok := e.lookupMeta(e.resultKey(apl, format, opts))
+		if ok && meta.Tables != nil {
+			return meta, nil
+		}
+		// Metadata stored before table names were recorded is replaced.
+		opts.refresh = ok
+	}
+	opts.EnsureTimeRange = false
+	opts.EnsureLimit = false
+	result, err := e.ExecuteAPLResult(ctx, apl, format, opts)
+	if err != nil {
+		return ResultMeta{}, err
+	}
+	result.Release()
+	return result.Meta, nil
+}
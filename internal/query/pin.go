@@ -0,0 +1,53 @@
+package query
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+	"time"
+)
+
+// pinnedRange fixes every query to one time window, for snapshot mounts
+// whose results must not change with the clock.
+type pinnedRange struct {
+	now    string
+	clause string
+}
+
+// WithPinnedRange constrains every query to [from, to]: a where clause on
+// _time is added, and now() and ago() are evaluated as of to, so relative
+// ranges select the same rows on every run. AutoRange is disabled.
+func WithPinnedRange(from, to time.Time) Option {
+	return func(e *Executor) {
+		now := fmt.Sprintf("datetime(%q)", to.UTC().Format(time.RFC3339Nano))
+		e.pinned = &pinnedRange{
+			now:    now,
+			clause: fmt.Sprintf("where _time between (datetime(%q) .. %s)", from.UTC().Format(time.RFC3339Nano), now),
+		}
+	}
+}
+
+var (
+	agoRe = regexp.MustCompile(`\bago\(([^()]*)\)`)
+	nowRe = regexp.MustCompile(`\bnow\(\s*\)`)
+)
+
+// apply rewrites apl to the pinned window. It is idempotent, so APL passed
+// between the executor's entry points is not pinned twice.
+func (p *pinnedRange) apply(apl string) string {
+	apl = agoRe.ReplaceAllString(apl, "("+p.now+" - $1)")
+	apl = nowRe.ReplaceAllString(apl, p.now)
+	if strings.Contains(apl, p.clause) {
+		return apl
+	}
+	return insertPipeline(apl, p.clause)
+}
+
+// pin applies the pinned range, if any, to apl and opts.
+func (e *Executor) pin(apl string, opts ExecOptions) (string, ExecOptions) {
+	if e.pinned == nil {
+		return apl, opts
+	}
+	opts.AutoRange = false
+	return e.pinned.apply(apl), opts
+}
@@ -0,0 +1,15 @@
+package query
+
+import (
+	"context"
+	"time"
+
+	"github.com/axiomhq/axiom-fs/internal/axiomclient"
+)
+
+// Poll fetches up to limit events ingested into source after the ingest
+// time after, for live tails. It runs like QueryAPL, so polls wait in the
+// fair queue, count against the quota and come back redacted.
+func (e *Executor) Poll(ctx context.Context, source string, after time.Time, limit int) (*axiomclient.QueryResult, error) {
+	return e.QueryAPL(ctx, axiomclient.PollAPL(source, after, limit), ExecOptions{})
+}
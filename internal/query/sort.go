@@ -0,0 +1,193 @@
+package query
+
+import (
+	"cmp"
+	"encoding/json"
+	"fmt"
+	"slices"
+	"strings"
+
+	"golang.org/x/text/collate"
+
+	"github.com/axiomhq/axiom-fs/internal/apl"
+	"github.com/axiomhq/axiom-fs/internal/axiomclient"
+	"github.com/axiomhq/axiom-fs/internal/compiler"
+)
+
+// shapeResult applies the post-execution steps of opts for format to the
+// result of src: group ordering, column projection, flattening, then
+// natural sorting.
+func (e *Executor) shapeResult(result *axiomclient.QueryResult, src, format string, opts ExecOptions) (*axiomclient.QueryResult, error) {
+	result, err := selectTable(result, opts.Table)
+	if err != nil {
+		return nil, err
+	}
+	if unordered(src) {
+		result = groupOrder(result)
+	}
+	result, err = projectColumns(result, opts.Columns)
+	if err == nil && flattens(format, opts) {
+		result = flattenResult(result, opts.Flatten, e.flattenDepth)
+	}
+	if err != nil || opts.NaturalSort == nil {
+		return result, err
+	}
+	return naturalSort(result, *opts.NaturalSort, collate.New(e.collation, collate.Numeric, collate.IgnoreCase))
+}
+
+// unordered reports whether the rows of src come in no defined order: it
+// aggregates with summarize and no later stage orders the groups, so Axiom
+// may return them in a different order on every run.
+func unordered(src string) bool {
+	summarized := false
+	for i, stage := range apl.Stages(apl.Tokenize(src)) {
+		code := apl.Code(stage)
+		if i == 0 || len(code) == 0 {
+			continue
+		}
+		switch code[0].Text {
+		case "summarize":
+			summarized = true
+		case "order", "sort", "top":
+			summarized = false
+		}
+	}
+	return summarized
+}
+
+// groupOrder returns result with the first table's rows sorted by every
+// column, left to right, so diffs of two runs of an aggregation only show
+// changed groups. Nulls go first, numbers compare numerically and
+// anything else by its bytes.
+func groupOrder(result *axiomclient.QueryResult) *axiomclient.QueryResult {
+	if len(result.Tables) == 0 || len(result.Tables[0].Columns) == 0 {
+		return result
+	}
+	table := result.Tables[0]
+	rows := make([]int, len(table.Columns[0]))
+	for i := range rows {
+		rows[i] = i
+	}
+	cell := func(column []any, row int) any {
+		if row < len(column) {
+			return column[row]
+		}
+		return nil
+	}
+	slices.SortStableFunc(rows, func(a, b int) int {
+		for _, column := range table.Columns {
+			va, vb := cell(column, a), cell(column, b)
+			var c int
+			switch {
+			case va == nil && vb == nil:
+			case va == nil:
+				c = -1
+			case vb == nil:
+				c = 1
+			default:
+				c = compareValues(va, vb, nil)
+			}
+			if c != 0 {
+				return c
+			}
+		}
+		return 0
+	})
+	return permuteRows(result, rows)
+}
+
+// naturalSort returns result with the first table's rows stably sorted by
+// s.Field. Strings compare with the collator, numbers numerically. Nulls go
+// first when ascending and last when descending, unless s.NullsLast.
+func naturalSort(result *axiomclient.QueryResult, s compiler.Sort, collator *collate.Collator) (*axiomclient.QueryResult, error) {
+	if len(result.Tables) == 0 {
+		return result, nil
+	}
+	table := result.Tables[0]
+	col := slices.IndexFunc(table.Fields, func(f axiomclient.QueryField) bool { return f.Name == s.Field })
+	if col < 0 {
+		available := make([]string, len(table.Fields))
+		for i, f := range table.Fields {
+			available[i] = f.Name
+		}
+		return nil, fmt.Errorf("unknown sort column %q (available: %s)", s.Field, strings.Join(available, ", "))
+	}
+	if col >= len(table.Columns) {
+		return result, nil
+	}
+	values := table.Columns[col]
+	nullsLast := s.NullsLast || s.Desc
+	rows := make([]int, len(values))
+	for i := range rows {
+		rows[i] = i
+	}
+	slices.SortStableFunc(rows, func(a, b int) int {
+		va, vb := values[a], values[b]
+		switch {
+		case va == nil && vb == nil:
+			return 0
+		case va == nil:
+			return nullOrder(nullsLast)
+		case vb == nil:
+			return -nullOrder(nullsLast)
+		}
+		c := compareValues(va, vb, collator)
+		if s.Desc {
+			return -c
+		}
+		return c
+	})
+
+	return permuteRows(result, rows), nil
+}
+
+// permuteRows returns result with row rows[i] of the first table moved to
+// i. Columns of another length are left as they are.
+func permuteRows(result *axiomclient.QueryResult, rows []int) *axiomclient.QueryResult {
+	table := result.Tables[0]
+	sorted := axiomclient.QueryTable{Name: table.Name, Fields: table.Fields, Columns: make([][]any, len(table.Columns))}
+	for i, column := range table.Columns {
+		if len(column) != len(rows) {
+			sorted.Columns[i] = column
+			continue
+		}
+		sorted.Columns[i] = make([]any, len(column))
+		for to, from := range rows {
+			sorted.Columns[i][to] = column[from]
+		}
+	}
+	out := *result
+	out.Tables = append([]axiomclient.QueryTable{sorted}, result.Tables[1:]...)
+	return &out
+}
+
+func nullOrder(last bool) int {
+	if last {
+		return 1
+	}
+	return -1
+}
+
+// compareValues orders two non-null cells: numbers numerically, integers
+// exactly, anything else by its text under the collator, or byte by byte
+// without one.
+func compareValues(a, b any, collator *collate.Collator) int {
+	if x, ok := a.(json.Number); ok {
+		if y, ok := b.(json.Number); ok {
+			if i, err := x.Int64(); err == nil {
+				if j, err := y.Int64(); err == nil {
+					return cmp.Compare(i, j)
+				}
+			}
+		}
+	}
+	if x, ok := axiomclient.Float64(a); ok {
+		if y, ok := axiomclient.Float64(b); ok {
+			return cmp.Compare(x, y)
+		}
+	}
+	if collator == nil {
+		return strings.Compare(stringify(a), stringify(b))
+	}
+	return collator.CompareString(stringify(a), stringify(b))
+}
@@ -0,0 +1,106 @@
+package query
+
+import (
+	"io"
+	"os"
+	"sync"
+	"sync/atomic"
+)
+
+// resultFlight runs one execution of a result per key at a time, like
+// singleflight, and counts the callers each execution serves so every one
+// of them gets its own handle on a spilled result.
+type resultFlight struct {
+	mu    sync.Mutex
+	calls map[string]*resultCall
+}
+
+type resultCall struct {
+	done    sync.WaitGroup
+	callers int
+	result  ResultData
+	spill   *spill
+	err     error
+}
+
+// do runs fn for key unless a run is already in flight, in which case it
+// waits for that run's result. A spilled result is shared: each caller's
+// File is its own handle, and closing the last one removes the file.
+func (g *resultFlight) do(key string, fn func() (ResultData, error)) (ResultData, error) {
+	g.mu.Lock()
+	if g.calls == nil {
+		g.calls = make(map[string]*resultCall)
+	}
+	if c, ok := g.calls[key]; ok {
+		c.callers++
+		g.mu.Unlock()
+		c.done.Wait()
+		return c.take()
+	}
+	c := &resultCall{callers: 1}
+	c.done.Add(1)
+	g.calls[key] = c
+	g.mu.Unlock()
+
+	defer func() {
+		g.mu.Lock()
+		delete(g.calls, key)
+		// Callers arriving from here on start a run of their own, so the
+		// count is final.
+		if c.spill != nil {
+			c.spill.refs.Store(int64(c.callers))
+		}
+		g.mu.Unlock()
+		c.done.Done()
+	}()
+	c.result, c.err = fn()
+	if c.err == nil && c.result.File != nil {
+		c.spill = &spill{file: c.result.File, size: c.result.Size}
+	}
+	return c.take()
+}
+
+// take returns the result with a handle of the caller's own.
+func (c *resultCall) take() (ResultData, error) {
+	result := c.result
+	if c.spill != nil {
+		result.File = c.spill.handle()
+	}
+	return result, c.err
+}
+
+// spill is a spilled result shared by the callers of one execution. The
+// file is closed and removed when the last of their handles is closed.
+type spill struct {
+	file SpillFile
+	size int64
+	refs atomic.Int64
+}
+
+func (s *spill) handle() SpillFile {
+	return &spillHandle{SectionReader: io.NewSectionReader(s.file, 0, s.size), spill: s}
+}
+
+func (s *spill) release() {
+	if s.refs.Add(-1) == 0 {
+		name := s.file.Name()
+		_ = s.file.Close()
+		_ = os.Remove(name)
+	}
+}
+
+// spillHandle reads a shared spill file from its own offset.
+type spillHandle struct {
+	*io.SectionReader
+	spill  *spill
+	closed atomic.Bool
+}
+
+func (h *spillHandle) Name() string { return h.spill.file.Name() }
+
+func (h *spillHandle) Close() error {
+	if h.closed.CompareAndSwap(false, true) {
+		h.spill.release()
+	}
+	return nil
+}
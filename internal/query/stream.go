@@ -0,0 +1,275 @@
+package query
+
+import (
+	"context"
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"io"
+	"net/http"
+	"time"
+
+	"github.com/axiomhq/axiom-fs/internal/axiomclient"
+	"github.com/axiomhq/axiom-fs/internal/drain"
+	"github.com/axiomhq/axiom-fs/internal/latency"
+)
+
+// rowQuerier is implemented by clients that can hand over a result row by
+// row instead of in one piece.
+type rowQuerier interface {
+	QueryAPLRowsWithHeaders(ctx context.Context, apl string, header http.Header, fn axiomclient.RowFunc) (*axiomclient.QueryResult, error)
+}
+
+// streamable reports whether the result of src can be written in format
+// as rows arrive. Natural sorting, group ordering and flattening need every
+// row first.
+func streamable(src, format string, opts ExecOptions) bool {
+	switch format {
+	case "ndjson", "csv", "tsv":
+		return opts.NaturalSort == nil && !flattens(format, opts) && !unordered(src)
+	default:
+		return false
+	}
+}
+
+// runQueryRows is runQuery for row-by-row results.
+func (e *Executor) runQueryRows(ctx context.Context, client rowQuerier, apl string, opts ExecOptions, fn axiomclient.RowFunc) (*axiomclient.QueryResult, error) {
+	if opts.CacheOnly {
+		return nil, ErrNotCached
+	}
+	if !e.inflight.Acquire() {
+		return nil, drain.ErrDraining
+	}
+	defer e.inflight.Release()
+	peer := ClientFrom(ctx)
+	if err := e.fair.acquire(ctx, peer); err != nil {
+		return nil, err
+	}
+	defer e.fair.release(peer)
+	start := time.Now()
+	var rows int64
+	redactor := e.redactor.Scope(apl)
+	redacted := redactor.Stream()
+	result, err := client.QueryAPLRowsWithHeaders(ctx, apl, queryHeaders(opts.Headers), func(result *axiomclient.QueryResult, table int, row []any) error {
+		rows++
+		result, row = redacted.Row(result, table, row)
+		return fn(result, table, row)
+	})
+	e.costs.record(costLabel(apl, opts), start, time.Since(start), result, rows, err)
+	return redactor.Result(result), err
+}
+
+// encodeQuery runs apl and writes its result to w in format. When the
+// client and format allow, rows go straight from the response to w, so
+// memory does not grow with the result. The returned meta lacks Bytes and
+// SHA256.
+func (e *Executor) encodeQuery(ctx context.Context, apl, format string, opts ExecOptions, w io.Writer) (ResultMeta, error) {
+	start := time.Now()
+	if client, ok := e.client.(rowQuerier); ok && streamable(apl, format, opts) && !e.follows() {
+		enc := &rowEncoder{format: format, opts: opts, w: w}
+		// Rows are encoded as they arrive; the time spent in the encoder
+		// is what streaming did not spend waiting on Axiom.
+		var encoding time.Duration
+		result, err := e.runQueryRows(ctx, client, apl, opts, func(result *axiomclient.QueryResult, table int, row []any) error {
+			rowStart := time.Now()
+			err := enc.row(result, table, row)
+			encoding += time.Since(rowStart)
+			return err
+		})
+		axiom := time.Since(start) - encoding
+		if err == nil {
+			err = enc.finish(result)
+		}
+		if errors.Is(err, errResultCapped) {
+			// The rest of the result is not wanted; w ends it.
+			err = nil
+		}
+		e.observeQuery(apl, opts, start, axiom, err)
+		if err != nil {
+			return ResultMeta{}, err
+		}
+		meta := newResultMeta(apl, format, enc.result, 0, nil)
+		meta.Rows, meta.Columns = enc.rows, enc.stats.stats()
+		return meta, nil
+	}
+	result, err := e.runQuery(ctx, apl, opts)
+	axiom := time.Since(start)
+	if err == nil {
+		if result, err = e.shapeResult(result, apl, format, opts); err == nil {
+			err = encodeResultToWriter(result, format, w)
+		}
+		if errors.Is(err, errResultCapped) {
+			err = nil
+		}
+	}
+	e.observeQuery(apl, opts, start, axiom, err)
+	if err != nil {
+		return ResultMeta{}, err
+	}
+	return newResultMeta(apl, format, result, 0, nil), nil
+}
+
+// observeQuery times a query that started at start and waited axiom on
+// Axiom; the rest of the time went to encoding.
+func (e *Executor) observeQuery(apl string, opts ExecOptions, start time.Time, axiom time.Duration, err error) {
+	if e.latency == nil {
+		return
+	}
+	elapsed := time.Since(start)
+	entry := latency.Entry{
+		Time:          start.UTC(),
+		Op:            latency.OpQuery,
+		Path:          opts.Headers.Get(LabelHeader),
+		APL:           apl,
+		AxiomSeconds:  axiom.Seconds(),
+		EncodeSeconds: (elapsed - axiom).Seconds(),
+	}
+	if err != nil {
+		entry.Error = err.Error()
+	}
+	e.latency.Observe(entry, elapsed)
+}
+
+// rowEncoder writes ndjson, csv or tsv a row at a time, shaping rows the
+// way shapeResult shapes a whole result and encoding them the way
+// encodeResultToWriter does.
+type rowEncoder struct {
+	format string
+	opts   ExecOptions
+	w      io.Writer
+
+	started bool
+	// result is the shaped result: its tables' names and fields only.
+	result *axiomclient.QueryResult
+	// selected is the response index of the table opts.Table names, or -1.
+	selected int
+	// columns projects the first table's rows; nil keeps every column.
+	columns   []int
+	projected []any
+	// names tag ndjson rows when the result has more than one table.
+	names []string
+	stats *columnStatsAcc
+	rows  int64
+	enc   *json.Encoder
+	csv   *csv.Writer
+}
+
+func (r *rowEncoder) start(result *axiomclient.QueryResult) error {
+	r.started = true
+	r.selected = -1
+	shaped := result
+	if r.opts.Table != "" {
+		i, err := tableIndex(result, r.opts.Table)
+		if err != nil {
+			return err
+		}
+		r.selected = i
+		shaped = &axiomclient.QueryResult{Tables: result.Tables[i : i+1], Status: result.Status, QueryID: result.QueryID}
+	}
+	if len(r.opts.Columns) > 0 {
+		if len(shaped.Tables) > 0 {
+			columns, err := columnIndexes(shaped.Tables[0], r.opts.Columns)
+			if err != nil {
+				return err
+			}
+			r.columns = columns
+		}
+		var err error
+		if shaped, err = projectColumns(shaped, r.opts.Columns); err != nil {
+			return err
+		}
+	}
+	r.result = shaped
+	if len(shaped.Tables) == 0 {
+		r.stats = newColumnStatsAcc(nil)
+		return nil
+	}
+	fields := shaped.Tables[0].Fields
+	r.stats = newColumnStatsAcc(fields)
+	switch r.format {
+	case "ndjson":
+		r.enc = json.NewEncoder(r.w)
+		if len(shaped.Tables) > 1 {
+			r.names = TableNames(shaped)
+		}
+		return nil
+	default:
+		r.csv = csv.NewWriter(r.w)
+		if r.format == "tsv" {
+			r.csv.Comma = '\t'
+		}
+		header := make([]string, 0, len(fields))
+		for _, field := range fields {
+			header = append(header, field.Name)
+		}
+		return r.csv.Write(header)
+	}
+}
+
+func (r *rowEncoder) row(result *axiomclient.QueryResult, table int, row []any) error {
+	if !r.started {
+		if err := r.start(result); err != nil {
+			return err
+		}
+	}
+	if r.selected >= 0 {
+		if table != r.selected {
+			return nil
+		}
+		table = 0
+	}
+	if table == 0 && r.columns != nil {
+		r.projected = r.projected[:0]
+		for _, i := range r.columns {
+			if i < len(row) {
+				r.projected = append(r.projected, row[i])
+			} else {
+				r.projected = append(r.projected, nil)
+			}
+		}
+		row = r.projected
+	}
+	r.rows++
+	if table == 0 {
+		r.stats.addRow(row)
+	}
+	fields := r.result.Tables[table].Fields
+	if r.enc != nil {
+		entry := make(map[string]any, len(fields)+1)
+		if r.names != nil {
+			entry[TableField] = r.names[table]
+		}
+		for i, field := range fields {
+			if i < len(row) {
+				entry[field.Name] = row[i]
+			}
+		}
+		return r.enc.Encode(entry)
+	}
+	if table > 0 {
+		// Delimited formats hold the first table only.
+		return nil
+	}
+	record := make([]string, len(fields))
+	for i := range fields {
+		if i < len(row) {
+			record[i] = stringify(row[i])
+		}
+	}
+	return r.csv.Write(record)
+}
+
+// finish completes the encoding; result is what the client returned, for
+// results without rows.
+func (r *rowEncoder) finish(result *axiomclient.QueryResult) error {
+	if !r.started {
+		if err := r.start(result); err != nil {
+			return err
+		}
+	}
+	if r.csv == nil {
+		return nil
+	}
+	r.csv.Flush()
+	return r.csv.Error()
+}
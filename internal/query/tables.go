@@ -0,0 +1,82 @@
+package query
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"strconv"
+	"strings"
+
+	"github.com/axiomhq/axiom-fs/internal/axiomclient"
+)
+
+// TableField is the field result.ndjson adds to every row of a result with
+// more than one table, naming the row's table.
+const TableField = "_table"
+
+// TableNames names each table of result: its own name when that is set,
+// unique and usable as a file name, its index otherwise.
+func TableNames(result *axiomclient.QueryResult) []string {
+	names := make([]string, len(result.Tables))
+	seen := make(map[string]int, len(result.Tables))
+	for _, table := range result.Tables {
+		seen[table.Name]++
+	}
+	for i, table := range result.Tables {
+		name := table.Name
+		if name == "" || name == "." || name == ".." || strings.Contains(name, "/") || seen[name] > 1 {
+			name = strconv.Itoa(i)
+		}
+		names[i] = name
+	}
+	return names
+}
+
+// selectTable returns result with only the table named name, or result
+// itself when name is empty.
+func selectTable(result *axiomclient.QueryResult, name string) (*axiomclient.QueryResult, error) {
+	if name == "" {
+		return result, nil
+	}
+	i, err := tableIndex(result, name)
+	if err != nil {
+		return nil, err
+	}
+	selected := *result
+	selected.Tables = result.Tables[i : i+1]
+	return &selected, nil
+}
+
+// tableIndex returns the index of the table named name.
+func tableIndex(result *axiomclient.QueryResult, name string) (int, error) {
+	names := TableNames(result)
+	for i, n := range names {
+		if n == name {
+			return i, nil
+		}
+	}
+	return 0, fmt.Errorf("%w: no result table %q (tables: %s)", os.ErrNotExist, name, strings.Join(names, ", "))
+}
+
+// encodeNDJSONTablesToWriter writes the rows of every table, in order, each
+// with a TableField naming its table.
+func encodeNDJSONTablesToWriter(result *axiomclient.QueryResult, w io.Writer) error {
+	enc := json.NewEncoder(w)
+	for i, name := range TableNames(result) {
+		table := result.Tables[i]
+		for _, row := range tableRows(table) {
+			entry := make(map[string]any, len(table.Fields)+1)
+			entry[TableField] = name
+			for j, field := range table.Fields {
+				if j < len(row) {
+					entry[field.Name] = row[j]
+				}
+			}
+			if err := enc.Encode(entry); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
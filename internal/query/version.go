@@ -0,0 +1,131 @@
+package query
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"log/slog"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// maxTrackedVersions bounds the version table; it is reset when full.
+const maxTrackedVersions = 4096
+
+// contentVersion is the ETag-like version of a result: a hash of the APL,
+// format, Axiom's rows-matched count and the encoded bytes.
+func contentVersion(apl, format, sha string, rowsMatched int64) string {
+	h := sha256.New()
+	for _, part := range []string{apl, format, strconv.FormatInt(rowsMatched, 10), sha} {
+		h.Write([]byte(part))
+		h.Write([]byte{0})
+	}
+	return hex.EncodeToString(h.Sum(nil))[:16]
+}
+
+type versionInfo struct {
+	version string
+	since   time.Time
+	// probe is the count-probe fingerprint taken right after execution.
+	probe string
+}
+
+// versionTable remembers when each result's current version first appeared.
+// It outlives cache entries so a re-executed, unchanged result keeps its
+// mtime and tools like rsync skip it.
+type versionTable struct {
+	mu      sync.Mutex
+	entries map[string]versionInfo
+}
+
+func (t *versionTable) get(key string) (versionInfo, bool) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	info, ok := t.entries[key]
+	return info, ok
+}
+
+func (t *versionTable) set(key string, info versionInfo) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if t.entries == nil || len(t.entries) >= maxTrackedVersions {
+		t.entries = make(map[string]versionInfo)
+	}
+	t.entries[key] = info
+}
+
+// since returns when version was first seen for key, or fallback.
+func (t *versionTable) since(key, version string, fallback time.Time) time.Time {
+	if info, ok := t.get(key); ok && info.version == version {
+		return info.since
+	}
+	return fallback
+}
+
+// trackVersion stamps meta with its content version and returns the time
+// that version was first produced.
+func (e *Executor) trackVersion(ctx context.Context, key, apl string, opts ExecOptions, meta *ResultMeta) time.Time {
+	meta.Version = contentVersion(meta.APL, meta.Format, meta.SHA256, meta.RowsMatched)
+	info, ok := e.versions.get(key)
+	if !ok || info.version != meta.Version {
+		info = versionInfo{version: meta.Version, since: meta.ExecutedAt}
+	}
+	if e.revalidate {
+		info.probe, _ = e.probe(ctx, apl, opts)
+	}
+	e.versions.set(key, info)
+	return info.since
+}
+
+// fresh reports whether a cached entry for key may be served. Without
+// revalidation every cached entry is fresh; otherwise a probe must match
+// the fingerprint recorded when the entry was produced.
+func (e *Executor) fresh(ctx context.Context, key, apl string, opts ExecOptions) bool {
+	if !e.revalidate {
+		return true
+	}
+	info, ok := e.versions.get(key)
+	if !ok || info.probe == "" {
+		return true
+	}
+	current, err := e.probe(ctx, apl, opts)
+	if err != nil {
+		// Serve the cached copy rather than failing on a probe error.
+		slog.Debug("revalidation probe failed", "error", err)
+		return true
+	}
+	return current == info.probe
+}
+
+// probe fingerprints apl's current result with the rows-matched figure
+// Axiom reports, the row count and the latest _sysTime. A count alone
+// misses changes once a take is saturated; the latest ingest time does
+// not. Results without _sysTime, such as aggregations, are probed with a
+// count. Probes are charged to the quota like any query.
+func (e *Executor) probe(ctx context.Context, apl string, opts ExecOptions) (string, error) {
+	who := principal(ctx, opts)
+	if err := e.quota.Allow(who); err != nil {
+		return "", err
+	}
+	probeOpts := ExecOptions{Principal: opts.Principal, Headers: opts.Headers}
+	result, err := e.runQuery(ctx, apl+"\n| summarize count(), max(_sysTime)", probeOpts)
+	if err != nil && ctx.Err() == nil {
+		result, err = e.runQuery(ctx, apl+"\n| count", probeOpts)
+	}
+	if err != nil {
+		return "", err
+	}
+	e.quota.Record(who, resultRows(result), 0)
+	fingerprint := strconv.FormatInt(result.Status.RowsMatched, 10)
+	if len(result.Tables) > 0 {
+		for _, column := range result.Tables[0].Columns {
+			value := ""
+			if len(column) > 0 {
+				value = stringify(column[0])
+			}
+			fingerprint += "/" + value
+		}
+	}
+	return fingerprint, nil
+}
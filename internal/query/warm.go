@@ -0,0 +1,163 @@
+package query
+
+import (
+	"context"
+	"log/slog"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"golang.org/x/sync/errgroup"
+
+	"github.com/axiomhq/axiom-fs/internal/events"
+)
+
+// warmMinReads is how often a result must be read before WarmCache keeps it
+// fresh.
+const warmMinReads = 2
+
+// maxTrackedAccesses bounds the access log; it is reset when full.
+const maxTrackedAccesses = 4096
+
+// WarmOptions configure WarmCache.
+type WarmOptions struct {
+	// Interval is how often cached results are checked. Results expiring
+	// within two intervals are refreshed. Zero disables warming.
+	Interval time.Duration
+	// Concurrency bounds how many refreshes run at once.
+	Concurrency int
+}
+
+// WarmStatus is a snapshot of the cache warmer.
+type WarmStatus struct {
+	Tracked   int        `json:"tracked"`
+	Refreshed int64      `json:"refreshed"`
+	Failed    int64      `json:"failed"`
+	LastRun   *time.Time `json:"last_run,omitempty"`
+}
+
+// access is a cached result that has been read, with what it takes to
+// re-execute it.
+type access struct {
+	apl    string
+	format string
+	opts   ExecOptions
+	reads  int
+	last   time.Time
+}
+
+// accessLog records reads of cacheable results so that WarmCache can
+// refresh the ones read repeatedly before they expire.
+type accessLog struct {
+	mu        sync.Mutex
+	entries   map[string]*access
+	lastRun   time.Time
+	refreshed atomic.Int64
+	failed    atomic.Int64
+}
+
+func (l *accessLog) record(key, apl, format string, opts ExecOptions) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	a, ok := l.entries[key]
+	if !ok {
+		if l.entries == nil || len(l.entries) >= maxTrackedAccesses {
+			l.entries = make(map[string]*access)
+		}
+		a = &access{apl: apl, format: format, opts: opts}
+		l.entries[key] = a
+	}
+	a.reads++
+	a.last = time.Now()
+}
+
+// warmDue returns the results read repeatedly within ttl whose cache entries
+// expire within lead, and forgets results nobody read for a whole ttl.
+func (e *Executor) warmDue(ttl, lead time.Duration) map[string]access {
+	now := time.Now()
+	e.accesses.mu.Lock()
+	candidates := map[string]access{}
+	for key, a := range e.accesses.entries {
+		if now.Sub(a.last) > ttl {
+			delete(e.accesses.entries, key)
+			continue
+		}
+		if a.reads >= warmMinReads {
+			candidates[key] = *a
+		}
+	}
+	e.accesses.lastRun = now
+	e.accesses.mu.Unlock()
+
+	due := map[string]access{}
+	for key, a := range candidates {
+		entry, ok := e.cache.Lookup(key)
+		if ok && entry.ExpiresAt.Sub(now) <= lead {
+			due[key] = a
+		}
+	}
+	return due
+}
+
+// WarmCache re-executes cached results that are read repeatedly shortly
+// before they expire, so dashboards and polling scripts keep hitting the
+// cache. It runs until ctx is done; it returns at once when warming is
+// disabled or results never expire.
+func (e *Executor) WarmCache(ctx context.Context, opts WarmOptions) {
+	if opts.Interval <= 0 || e.cache == nil || e.cache.TTL() <= 0 {
+		return
+	}
+	ticker := time.NewTicker(opts.Interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			e.warmOnce(ctx, opts)
+		}
+	}
+}
+
+// warmOnce refreshes the results that are due and returns how many it
+// refreshed.
+func (e *Executor) warmOnce(ctx context.Context, opts WarmOptions) int {
+	due := e.warmDue(e.cache.TTL(), 2*opts.Interval)
+	g, gctx := errgroup.WithContext(ctx)
+	g.SetLimit(max(opts.Concurrency, 1))
+	var refreshed atomic.Int64
+	for key, a := range due {
+		g.Go(func() error {
+			a.opts.refresh = true
+			result, err := e.executeResult(gctx, a.apl, a.format, a.opts)
+			if err != nil {
+				e.accesses.failed.Add(1)
+				slog.Debug("cache warming failed", "key", key, "error", err)
+				return nil
+			}
+			result.Release()
+			refreshed.Add(1)
+			e.accesses.refreshed.Add(1)
+			e.events.Publish(events.Event{Type: events.CacheRefreshed, APL: a.apl, Format: a.format})
+			return nil
+		})
+	}
+	_ = g.Wait()
+	return int(refreshed.Load())
+}
+
+// WarmStatus reports what the cache warmer tracks and has done.
+func (e *Executor) WarmStatus() WarmStatus {
+	e.accesses.mu.Lock()
+	defer e.accesses.mu.Unlock()
+	status := WarmStatus{
+		Tracked:   len(e.accesses.entries),
+		Refreshed: e.accesses.refreshed.Load(),
+		Failed:    e.accesses.failed.Load(),
+	}
+	if !e.accesses.lastRun.IsZero() {
+		last := e.accesses.lastRun
+		status.LastRun = &last
+	}
+	return status
+}
@@ -0,0 +1,106 @@
+package query
+
+import (
+	"encoding/json"
+	"io"
+	"math"
+	"strings"
+	"time"
+
+	"github.com/xuri/excelize/v2"
+
+	"github.com/axiomhq/axiom-fs/internal/axiomclient"
+)
+
+const xlsxSheet = "Sheet1"
+
+// encodeXLSXToWriter writes table as a single-sheet workbook with a bold,
+// frozen header row. Rows go through excelize's stream writer so cell
+// values keep their types: numbers, booleans and datetimes stay native.
+func encodeXLSXToWriter(table axiomclient.QueryTable, w io.Writer) error {
+	f := excelize.NewFile()
+	defer f.Close()
+
+	sw, err := f.NewStreamWriter(xlsxSheet)
+	if err != nil {
+		return err
+	}
+	if err := sw.SetPanes(&excelize.Panes{
+		Freeze:      true,
+		YSplit:      1,
+		TopLeftCell: "A2",
+		ActivePane:  "bottomLeft",
+	}); err != nil {
+		return err
+	}
+	headerStyle, err := f.NewStyle(&excelize.Style{Font: &excelize.Font{Bold: true}})
+	if err != nil {
+		return err
+	}
+	dateStyle, err := f.NewStyle(&excelize.Style{NumFmt: 22}) // m/d/yy h:mm
+	if err != nil {
+		return err
+	}
+
+	header := make([]any, len(table.Fields))
+	for i, field := range table.Fields {
+		header[i] = excelize.Cell{StyleID: headerStyle, Value: field.Name}
+	}
+	if err := sw.SetRow("A1", header); err != nil {
+		return err
+	}
+
+	for r, row := range tableRows(table) {
+		cells := make([]any, len(table.Fields))
+		for i, field := range table.Fields {
+			if i >= len(row) {
+				continue
+			}
+			cells[i] = xlsxValue(field.Type, row[i], dateStyle)
+		}
+		cell, err := excelize.CoordinatesToCellName(1, r+2)
+		if err != nil {
+			return err
+		}
+		if err := sw.SetRow(cell, cells); err != nil {
+			return err
+		}
+	}
+	if err := sw.Flush(); err != nil {
+		return err
+	}
+	_, err = f.WriteTo(w)
+	return err
+}
+
+// xlsxValue converts a decoded JSON value into a typed cell value.
+func xlsxValue(fieldType string, value any, dateStyle int) any {
+	switch v := value.(type) {
+	case nil:
+		return nil
+	case float64, bool:
+		return v
+	case json.Number:
+		// Excel cells hold doubles; integers past 2^53, such as IDs and
+		// nanosecond timestamps, stay text so no digit is lost.
+		if f, err := v.Float64(); err == nil && (strings.ContainsAny(string(v), ".eE") || math.Abs(f) <= 1<<53) {
+			return f
+		}
+		return string(v)
+	case string:
+		if strings.Contains(fieldType, "datetime") {
+			if t, err := time.Parse(time.RFC3339Nano, v); err == nil {
+				return excelize.Cell{StyleID: dateStyle, Value: t.UTC()}
+			}
+		}
+		return v
+	case map[string]any, []any:
+		data, err := json.Marshal(v)
+		if err != nil {
+			return stringify(v)
+		}
+		return string(data)
+	default:
+		return stringify(v)
+	}
+}
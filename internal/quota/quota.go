@@ -0,0 +1,153 @@
+// Package quota tracks rows and bytes fetched from Axiom per principal and
+// rejects queries once an hourly budget is exhausted.
+package quota
+
+import (
+	"fmt"
+	"sort"
+	"sync"
+	"syscall"
+	"time"
+)
+
+// GlobalPrincipal is used when a query carries no principal. With NFS
+// AUTH_NULL every client shares it.
+const GlobalPrincipal = "global"
+
+// ExceededError is returned by Allow once a principal's budget is spent.
+// It unwraps to syscall.EDQUOT so the NFS layer can surface it as such.
+type ExceededError struct {
+	Principal string
+	Resource  string
+	Used      int64
+	Limit     int64
+}
+
+func (e *ExceededError) Error() string {
+	return fmt.Sprintf("quota exceeded for %s: %s %d >= %d per hour", e.Principal, e.Resource, e.Used, e.Limit)
+}
+
+func (e *ExceededError) Unwrap() error { return syscall.EDQUOT }
+
+// Limits configures the hourly budget applied to each principal. Zero
+// disables the corresponding check.
+type Limits struct {
+	RowsPerHour  int64 `json:"rows_per_hour"`
+	BytesPerHour int64 `json:"bytes_per_hour"`
+}
+
+// Usage is a principal's consumption in the current window.
+type Usage struct {
+	Principal string `json:"principal"`
+	Queries   int64  `json:"queries"`
+	Rows      int64  `json:"rows"`
+	Bytes     int64  `json:"bytes"`
+	Exceeded  bool   `json:"exceeded"`
+}
+
+// Snapshot is the tracker state exposed in /_status/quota.json.
+type Snapshot struct {
+	WindowStart time.Time `json:"window_start"`
+	WindowEnd   time.Time `json:"window_end"`
+	Limits      Limits    `json:"limits"`
+	Principals  []Usage   `json:"principals"`
+}
+
+// Tracker accounts usage in fixed hourly windows.
+type Tracker struct {
+	mu          sync.Mutex
+	limits      Limits
+	windowStart time.Time
+	usage       map[string]*Usage
+	now         func() time.Time
+}
+
+// New returns a tracker applying limits to each principal independently.
+func New(limits Limits) *Tracker {
+	return &Tracker{
+		limits: limits,
+		usage:  make(map[string]*Usage),
+		now:    time.Now,
+	}
+}
+
+// Enabled reports whether any limit is configured.
+func (t *Tracker) Enabled() bool {
+	return t != nil && (t.limits.RowsPerHour > 0 || t.limits.BytesPerHour > 0)
+}
+
+// Allow returns an *ExceededError when principal has spent its budget.
+func (t *Tracker) Allow(principal string) error {
+	if !t.Enabled() {
+		return nil
+	}
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.rollLocked()
+	u := t.usageLocked(principal)
+	if t.limits.RowsPerHour > 0 && u.Rows >= t.limits.RowsPerHour {
+		return &ExceededError{Principal: u.Principal, Resource: "rows", Used: u.Rows, Limit: t.limits.RowsPerHour}
+	}
+	if t.limits.BytesPerHour > 0 && u.Bytes >= t.limits.BytesPerHour {
+		return &ExceededError{Principal: u.Principal, Resource: "bytes", Used: u.Bytes, Limit: t.limits.BytesPerHour}
+	}
+	return nil
+}
+
+// Record adds a completed query's rows and bytes to principal's usage.
+func (t *Tracker) Record(principal string, rows, bytes int64) {
+	if t == nil {
+		return
+	}
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.rollLocked()
+	u := t.usageLocked(principal)
+	u.Queries++
+	u.Rows += rows
+	u.Bytes += bytes
+}
+
+// Snapshot returns the current window's usage sorted by principal.
+func (t *Tracker) Snapshot() Snapshot {
+	if t == nil {
+		return Snapshot{Principals: []Usage{}}
+	}
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.rollLocked()
+	snap := Snapshot{
+		WindowStart: t.windowStart,
+		WindowEnd:   t.windowStart.Add(time.Hour),
+		Limits:      t.limits,
+		Principals:  make([]Usage, 0, len(t.usage)),
+	}
+	for _, u := range t.usage {
+		entry := *u
+		entry.Exceeded = (t.limits.RowsPerHour > 0 && u.Rows >= t.limits.RowsPerHour) ||
+			(t.limits.BytesPerHour > 0 && u.Bytes >= t.limits.BytesPerHour)
+		snap.Principals = append(snap.Principals, entry)
+	}
+	sort.Slice(snap.Principals, func(i, j int) bool { return snap.Principals[i].Principal < snap.Principals[j].Principal })
+	return snap
+}
+
+func (t *Tracker) rollLocked() {
+	window := t.now().Truncate(time.Hour)
+	if !window.Equal(t.windowStart) {
+		t.windowStart = window
+		t.usage = make(map[string]*Usage)
+	}
+}
+
+func (t *Tracker) usageLocked(principal string) *Usage {
+	if principal == "" {
+		principal = GlobalPrincipal
+	}
+	u, ok := t.usage[principal]
+	if !ok {
+		u = &Usage{Principal: principal}
+		t.usage[principal] = u
+	}
+	return u
+}
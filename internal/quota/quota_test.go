@@ -0,0 +1,81 @@
+package quota
+
+import (
+	"errors"
+	"syscall"
+	"testing"
+	"time"
+)
+
+func TestTrackerLimits(t *testing.T) {
+	tr := New(Limits{RowsPerHour: 10, BytesPerHour: 100})
+
+	if err := tr.Allow(""); err != nil {
+		t.Fatalf("fresh principal rejected: %v", err)
+	}
+	tr.Record("", 10, 5)
+
+	err := tr.Allow("")
+	var exceeded *ExceededError
+	if !errors.As(err, &exceeded) || exceeded.Resource != "rows" {
+		t.Fatalf("expected rows exceeded, got %v", err)
+	}
+	if !errors.Is(err, syscall.EDQUOT) {
+		t.Errorf("error should unwrap to EDQUOT")
+	}
+
+	tr.Record("bob", 1, 100)
+	if err := tr.Allow("bob"); err == nil || !errors.As(err, &exceeded) || exceeded.Resource != "bytes" {
+		t.Errorf("expected bytes exceeded for bob, got %v", err)
+	}
+}
+
+func TestTrackerDisabled(t *testing.T) {
+	tr := New(Limits{})
+	tr.Record("", 1<<40, 1<<40)
+	if err := tr.Allow(""); err != nil {
+		t.Errorf("disabled tracker rejected: %v", err)
+	}
+
+	var nilTracker *Tracker
+	nilTracker.Record("", 1, 1)
+	if err := nilTracker.Allow(""); err != nil {
+		t.Errorf("nil tracker rejected: %v", err)
+	}
+	if snap := nilTracker.Snapshot(); len(snap.Principals) != 0 {
+		t.Errorf("nil tracker snapshot = %+v", snap)
+	}
+}
+
+func TestTrackerWindowRollover(t *testing.T) {
+	now := time.Date(2024, 1, 1, 10, 59, 0, 0, time.UTC)
+	tr := New(Limits{RowsPerHour: 1})
+	tr.now = func() time.Time { return now }
+
+	tr.Record("", 5, 0)
+	if err := tr.Allow(""); err == nil {
+		t.Fatal("expected exceeded")
+	}
+
+	now = now.Add(2 * time.Minute)
+	if err := tr.Allow(""); err != nil {
+		t.Errorf("new window should reset usage: %v", err)
+	}
+}
+
+func TestTrackerSnapshot(t *testing.T) {
+	tr := New(Limits{RowsPerHour: 5})
+	tr.Record("b", 6, 10)
+	tr.Record("a", 1, 10)
+
+	snap := tr.Snapshot()
+	if len(snap.Principals) != 2 || snap.Principals[0].Principal != "a" {
+		t.Fatalf("unexpected principals: %+v", snap.Principals)
+	}
+	if snap.Principals[0].Exceeded || !snap.Principals[1].Exceeded {
+		t.Errorf("unexpected exceeded flags: %+v", snap.Principals)
+	}
+	if snap.WindowEnd.Sub(snap.WindowStart) != time.Hour {
+		t.Errorf("window = %v..%v", snap.WindowStart, snap.WindowEnd)
+	}
+}
@@ -0,0 +1,453 @@
+// Package redact masks sensitive fields in query results before they are
+// encoded. Rules match result column names, and the keys of object values
+// as column.key, with path.Match globs and hash, mask or drop them. They go
+// by name only: APL that renames or derives a column escapes them.
+// Column rules drop the columns of some datasets outright; they apply to a
+// Redactor scoped to a query or dataset with Scope or ForDataset.
+//
+// A nil *Redactor leaves results untouched.
+package redact
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"maps"
+	"path"
+	"slices"
+
+	"github.com/axiomhq/axiom-fs/internal/apl"
+	"github.com/axiomhq/axiom-fs/internal/axiomclient"
+)
+
+// Action is what a rule does to a matching column.
+type Action string
+
+const (
+	// Hash replaces values with a short SHA-256 digest, so equal values
+	// still group and join.
+	Hash Action = "hash"
+	// Mask replaces values with Masked.
+	Mask Action = "mask"
+	// Drop removes the column.
+	Drop Action = "drop"
+)
+
+// Masked replaces the values of masked columns.
+const Masked = "[redacted]"
+
+// Rule applies Action to the columns whose name matches Field.
+type Rule struct {
+	Field  string `json:"field"`
+	Action Action `json:"action"`
+}
+
+// ColumnRule limits the columns of the datasets matching Dataset: with
+// Allow set, only matching columns are kept, computed ones such as count_
+// included, and columns matching Deny are always dropped.
+type ColumnRule struct {
+	Dataset string   `json:"dataset"`
+	Allow   []string `json:"allow,omitempty"`
+	Deny    []string `json:"deny,omitempty"`
+}
+
+// permits reports whether the rule keeps the column named field.
+func (c ColumnRule) permits(field string) bool {
+	for _, pattern := range c.Deny {
+		if ok, _ := path.Match(pattern, field); ok {
+			return false
+		}
+	}
+	if len(c.Allow) == 0 {
+		return true
+	}
+	for _, pattern := range c.Allow {
+		if ok, _ := path.Match(pattern, field); ok {
+			return true
+		}
+	}
+	return false
+}
+
+// Redactor applies rules in order; the first matching rule wins. Columns
+// a scoped column rule does not permit are dropped before any rule.
+type Redactor struct {
+	rules   []Rule
+	columns []ColumnRule
+	// scoped are the column rules applying to the query or dataset the
+	// Redactor was scoped to.
+	scoped []ColumnRule
+}
+
+// New validates rules and column rules. It returns nil, redacting
+// nothing, when there are none.
+func New(rules []Rule, columns []ColumnRule) (*Redactor, error) {
+	if len(rules) == 0 && len(columns) == 0 {
+		return nil, nil
+	}
+	for _, column := range columns {
+		patterns := append(append([]string{column.Dataset}, column.Allow...), column.Deny...)
+		for _, pattern := range patterns {
+			if _, err := path.Match(pattern, ""); err != nil || pattern == "" {
+				return nil, fmt.Errorf("column rule %q: invalid pattern %q", column.Dataset, pattern)
+			}
+		}
+	}
+	for _, rule := range rules {
+		if _, err := path.Match(rule.Field, ""); err != nil || rule.Field == "" {
+			return nil, fmt.Errorf("redaction rule: invalid field pattern %q", rule.Field)
+		}
+		switch rule.Action {
+		case Hash, Mask, Drop:
+		default:
+			return nil, fmt.Errorf("redaction rule %q: unknown action %q (want hash, mask or drop)", rule.Field, rule.Action)
+		}
+	}
+	return &Redactor{rules: rules, columns: columns}, nil
+}
+
+// Scope returns the Redactor for the results of src, applying the column
+// rules of every dataset it may read, or all of them when that cannot be
+// told.
+func (r *Redactor) Scope(src string) *Redactor {
+	if r == nil || len(r.columns) == 0 {
+		return r
+	}
+	datasets := apl.Datasets(src)
+	if len(datasets) == 0 {
+		scoped := *r
+		scoped.scoped = r.columns
+		return &scoped
+	}
+	return r.forDatasets(datasets)
+}
+
+// ForDataset returns the Redactor for the fields of dataset, applying its
+// column rules.
+func (r *Redactor) ForDataset(dataset string) *Redactor {
+	if r == nil || len(r.columns) == 0 {
+		return r
+	}
+	return r.forDatasets([]string{dataset})
+}
+
+func (r *Redactor) forDatasets(datasets []string) *Redactor {
+	scoped := *r
+	scoped.scoped = nil
+	for _, column := range r.columns {
+		for _, dataset := range datasets {
+			if ok, _ := path.Match(column.Dataset, dataset); ok {
+				scoped.scoped = append(scoped.scoped, column)
+				break
+			}
+		}
+	}
+	return &scoped
+}
+
+// Denied reports whether a scoped column rule drops the column named
+// field, whatever the redaction rules say.
+func (r *Redactor) Denied(field string) bool {
+	if r == nil {
+		return false
+	}
+	for _, column := range r.scoped {
+		if !column.permits(field) {
+			return true
+		}
+	}
+	return false
+}
+
+// Fingerprint identifies the rules, empty without any, so results
+// redacted under other rules can be kept apart.
+func (r *Redactor) Fingerprint() string {
+	if r == nil {
+		return ""
+	}
+	var data []byte
+	if len(r.columns) == 0 {
+		// Fingerprints of rules alone stay as they were before column rules.
+		data, _ = json.Marshal(r.rules)
+	} else {
+		data, _ = json.Marshal(struct {
+			Rules   []Rule       `json:"rules"`
+			Columns []ColumnRule `json:"columns"`
+		}{r.rules, r.columns})
+	}
+	sum := sha256.Sum256(data)
+	return hex.EncodeToString(sum[:8])
+}
+
+// Rules returns the active rules.
+func (r *Redactor) Rules() []Rule {
+	if r == nil || r.rules == nil {
+		return []Rule{}
+	}
+	return r.rules
+}
+
+// Columns returns the column rules.
+func (r *Redactor) Columns() []ColumnRule {
+	if r == nil || r.columns == nil {
+		return []ColumnRule{}
+	}
+	return r.columns
+}
+
+// Action returns what happens to the column named field, if anything.
+func (r *Redactor) Action(field string) (Action, bool) {
+	if r == nil {
+		return "", false
+	}
+	if r.Denied(field) {
+		return Drop, true
+	}
+	return r.ruleAction(field)
+}
+
+// ruleAction returns what the redaction rules alone do to field. Keys
+// nested in a column are matched this way, as column rules list columns.
+func (r *Redactor) ruleAction(field string) (Action, bool) {
+	for _, rule := range r.rules {
+		if ok, _ := path.Match(rule.Field, field); ok {
+			return rule.Action, true
+		}
+	}
+	return "", false
+}
+
+// Dropped reports whether the column named field is removed.
+func (r *Redactor) Dropped(field string) bool {
+	action, ok := r.Action(field)
+	return ok && action == Drop
+}
+
+// value redacts one value; nulls stay null.
+func value(action Action, v any) any {
+	if v == nil {
+		return nil
+	}
+	if action == Mask {
+		return Masked
+	}
+	s, ok := v.(string)
+	if !ok {
+		data, _ := json.Marshal(v)
+		s = string(data)
+	}
+	sum := sha256.Sum256([]byte(s))
+	return hex.EncodeToString(sum[:8])
+}
+
+// nested redacts the keys of v, a value of the column or key named name,
+// if it is an object, and of the objects nested in it. v is not modified;
+// changed reports whether the result differs.
+func (r *Redactor) nested(name string, v any) (out any, changed bool) {
+	m, ok := v.(map[string]any)
+	if !ok {
+		return v, false
+	}
+	var redacted map[string]any
+	for k, v := range m {
+		key := name + "." + k
+		action, ok := r.ruleAction(key)
+		if !ok {
+			if v, changed = r.nested(key, v); !changed {
+				continue
+			}
+		}
+		if redacted == nil {
+			redacted = maps.Clone(m)
+		}
+		switch {
+		case !ok:
+			redacted[k] = v
+		case action == Drop:
+			delete(redacted, k)
+		default:
+			redacted[k] = value(action, v)
+		}
+	}
+	if redacted == nil {
+		return m, false
+	}
+	return redacted, true
+}
+
+// nestedColumn redacts the object values of column, named name, in a copy
+// unless nothing changes.
+func (r *Redactor) nestedColumn(name string, column []any) ([]any, bool) {
+	var out []any
+	for j, v := range column {
+		if v, changed := r.nested(name, v); changed {
+			if out == nil {
+				out = slices.Clone(column)
+			}
+			out[j] = v
+		}
+	}
+	if out == nil {
+		return column, false
+	}
+	return out, true
+}
+
+// Stream redacts a result handed over row by row.
+type Stream struct {
+	r      *Redactor
+	src    *axiomclient.QueryResult
+	result *axiomclient.QueryResult
+	plans  []*Plan
+}
+
+// Stream starts redacting a streamed result.
+func (r *Redactor) Stream() *Stream {
+	return &Stream{r: r}
+}
+
+// Row returns the redacted result, its tables' fields only, and row of
+// table redacted.
+func (s *Stream) Row(result *axiomclient.QueryResult, table int, row []any) (*axiomclient.QueryResult, []any) {
+	if s.r == nil {
+		return result, row
+	}
+	if result != s.src {
+		s.src = result
+		s.result = s.r.Result(result)
+		s.plans = make([]*Plan, len(result.Tables))
+		for i, t := range result.Tables {
+			s.plans[i] = s.r.Plan(t.Fields)
+		}
+	}
+	if table >= len(s.plans) {
+		return s.result, row
+	}
+	return s.result, s.plans[table].Row(row)
+}
+
+// Plan redacts the rows of one table.
+type Plan struct {
+	r      *Redactor
+	fields []axiomclient.QueryField
+	// source is the input index of each kept column and actions what is
+	// done to it, empty for nothing.
+	source  []int
+	actions []Action
+	noop    bool
+	// nested holds the output index of each column left as is, whose
+	// object values may have keys a rule matches.
+	nested []int
+}
+
+// Plan returns how rows with fields are redacted.
+func (r *Redactor) Plan(fields []axiomclient.QueryField) *Plan {
+	p := &Plan{r: r, noop: true}
+	for i, field := range fields {
+		action, ok := r.Action(field.Name)
+		if ok && action == Drop {
+			p.noop = false
+			continue
+		}
+		if ok {
+			p.noop = false
+			field.Type = "string"
+		} else {
+			action = ""
+			if r != nil && len(r.rules) > 0 {
+				p.nested = append(p.nested, len(p.fields))
+			}
+		}
+		p.fields = append(p.fields, field)
+		p.source = append(p.source, i)
+		p.actions = append(p.actions, action)
+	}
+	if p.noop {
+		p.fields = fields
+	}
+	return p
+}
+
+// Fields are the table's fields after redaction.
+func (p *Plan) Fields() []axiomclient.QueryField { return p.fields }
+
+// Row returns row redacted, in a new slice unless nothing changes.
+func (p *Plan) Row(row []any) []any {
+	if p.noop {
+		var out []any
+		for _, i := range p.nested {
+			if i >= len(row) {
+				continue
+			}
+			if v, changed := p.r.nested(p.fields[i].Name, row[i]); changed {
+				if out == nil {
+					out = slices.Clone(row)
+				}
+				out[i] = v
+			}
+		}
+		if out == nil {
+			return row
+		}
+		return out
+	}
+	out := make([]any, len(p.source))
+	for i, src := range p.source {
+		if src >= len(row) {
+			continue
+		}
+		out[i] = row[src]
+		if p.actions[i] != "" {
+			out[i] = value(p.actions[i], out[i])
+		}
+	}
+	for _, i := range p.nested {
+		out[i], _ = p.r.nested(p.fields[i].Name, out[i])
+	}
+	return out
+}
+
+// Result returns result with its tables redacted. result is not modified;
+// tables without matching columns are shared.
+func (r *Redactor) Result(result *axiomclient.QueryResult) *axiomclient.QueryResult {
+	if r == nil || result == nil {
+		return result
+	}
+	out := *result
+	out.Tables = make([]axiomclient.QueryTable, len(result.Tables))
+	for t, table := range result.Tables {
+		p := r.Plan(table.Fields)
+		if p.noop && len(p.nested) == 0 {
+			out.Tables[t] = table
+			continue
+		}
+		redacted := axiomclient.QueryTable{Name: table.Name, Fields: p.fields}
+		changed := !p.noop
+		for i, src := range p.source {
+			// Tables streamed row by row come without columns.
+			if src >= len(table.Columns) {
+				continue
+			}
+			column := table.Columns[src]
+			if p.actions[i] != "" {
+				redactedColumn := make([]any, len(column))
+				for j, v := range column {
+					redactedColumn[j] = value(p.actions[i], v)
+				}
+				column = redactedColumn
+			} else if slices.Contains(p.nested, i) {
+				var nestedChanged bool
+				column, nestedChanged = r.nestedColumn(p.fields[i].Name, column)
+				changed = changed || nestedChanged
+			}
+			redacted.Columns = append(redacted.Columns, column)
+		}
+		if !changed {
+			out.Tables[t] = table
+			continue
+		}
+		out.Tables[t] = redacted
+	}
+	return &out
+}
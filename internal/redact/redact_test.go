@@ -0,0 +1,153 @@
+package redact
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/axiomhq/axiom-fs/internal/axiomclient"
+)
+
+func TestNew(t *testing.T) {
+	r, err := New(nil, nil)
+	if err != nil || r != nil {
+		t.Fatalf("New(nil, nil) = %v, %v; want nil", r, err)
+	}
+	if _, err := New([]Rule{{Field: "[", Action: Hash}}, nil); err == nil {
+		t.Error("expected error for malformed pattern")
+	}
+	if _, err := New([]Rule{{Field: "email", Action: "encrypt"}}, nil); err == nil {
+		t.Error("expected error for unknown action")
+	}
+	if _, err := New(nil, []ColumnRule{{Dataset: "logs", Deny: []string{"["}}}); err == nil {
+		t.Error("expected error for malformed column pattern")
+	}
+}
+
+func TestColumns(t *testing.T) {
+	r, err := New([]Rule{{Field: "email", Action: Hash}}, []ColumnRule{
+		{Dataset: "billing-*", Allow: []string{"_time", "plan", "email"}},
+		{Dataset: "logs", Deny: []string{"user.*"}},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if r.Denied("count_") || r.Denied("user.id") {
+		t.Error("unscoped Redactor denies columns")
+	}
+	billing := r.ForDataset("billing-eu")
+	for field, want := range map[string]bool{"_time": false, "plan": false, "card": true, "count_": true} {
+		if got := billing.Denied(field); got != want {
+			t.Errorf("billing Denied(%q) = %v, want %v", field, got, want)
+		}
+	}
+	if action, _ := billing.Action("email"); action != Hash {
+		t.Errorf("billing Action(email) = %q, want hash", action)
+	}
+	if !r.Scope("['logs'] | where x > 1").Dropped("user.id") || r.Scope("['other']").Dropped("user.id") {
+		t.Error("Scope does not follow the query's dataset")
+	}
+	if !r.Scope("['other'] | join (['logs']) on id").Denied("card") {
+		t.Error("Scope of an unknown source skips column rules")
+	}
+	rulesOnly, _ := New([]Rule{{Field: "email", Action: Hash}}, nil)
+	if r.Fingerprint() == rulesOnly.Fingerprint() {
+		t.Error("column rules do not change the fingerprint")
+	}
+}
+
+func TestAction(t *testing.T) {
+	r, err := New([]Rule{{Field: "user.email", Action: Hash}, {Field: "user.*", Action: Drop}}, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for field, want := range map[string]Action{"user.email": Hash, "user.name": Drop, "status": ""} {
+		if got, _ := r.Action(field); got != want {
+			t.Errorf("Action(%q) = %q, want %q", field, got, want)
+		}
+	}
+	if !r.Dropped("user.name") || r.Dropped("user.email") {
+		t.Error("Dropped disagrees with Action")
+	}
+	var none *Redactor
+	if _, ok := none.Action("user.email"); ok || none.Fingerprint() != "" {
+		t.Error("nil Redactor redacts")
+	}
+	if r.Fingerprint() == "" {
+		t.Error("empty fingerprint")
+	}
+}
+
+func TestResult(t *testing.T) {
+	r, err := New([]Rule{{Field: "email", Action: Hash}, {Field: "token", Action: Drop}, {Field: "ip", Action: Mask}}, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	in := &axiomclient.QueryResult{Tables: []axiomclient.QueryTable{{
+		Fields:  []axiomclient.QueryField{{Name: "email"}, {Name: "token"}, {Name: "ip"}, {Name: "n", Type: "integer"}},
+		Columns: [][]any{{"a@x", "a@x", nil}, {"t1", "t2", "t3"}, {"1.2.3.4", nil, "5.6.7.8"}, {1.0, 2.0, 3.0}},
+	}}}
+	out := r.Result(in)
+	table := out.Tables[0]
+	var names []string
+	for _, f := range table.Fields {
+		names = append(names, f.Name)
+	}
+	if !reflect.DeepEqual(names, []string{"email", "ip", "n"}) {
+		t.Fatalf("fields = %v", names)
+	}
+	email := table.Columns[0]
+	if email[0] == "a@x" || email[0] != email[1] || email[2] != nil {
+		t.Errorf("hashed email = %v", email)
+	}
+	if !reflect.DeepEqual(table.Columns[1], []any{Masked, nil, Masked}) {
+		t.Errorf("masked ip = %v", table.Columns[1])
+	}
+	if !reflect.DeepEqual(table.Columns[2], []any{1.0, 2.0, 3.0}) {
+		t.Errorf("kept n = %v", table.Columns[2])
+	}
+	if in.Tables[0].Columns[0][0] != "a@x" || len(in.Tables[0].Fields) != 4 {
+		t.Error("Result modified its input")
+	}
+
+	plan := r.Plan(in.Tables[0].Fields)
+	row := plan.Row([]any{"a@x", "t1", "1.2.3.4", 1.0})
+	if !reflect.DeepEqual(row, []any{email[0], Masked, 1.0}) {
+		t.Errorf("Plan.Row = %v", row)
+	}
+}
+
+func TestNested(t *testing.T) {
+	r, err := New([]Rule{{Field: "user.email", Action: Mask}, {Field: "*.token", Action: Drop}}, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	user := map[string]any{"email": "a@x", "name": "a", "auth": map[string]any{"token": "t1", "kind": "oauth"}}
+	in := &axiomclient.QueryResult{Tables: []axiomclient.QueryTable{{
+		Fields:  []axiomclient.QueryField{{Name: "user"}, {Name: "n"}},
+		Columns: [][]any{{user, "plain"}, {1.0, 2.0}},
+	}}}
+	want := map[string]any{"email": Masked, "name": "a", "auth": map[string]any{"kind": "oauth"}}
+	out := r.Result(in)
+	if got := out.Tables[0].Columns[0]; !reflect.DeepEqual(got, []any{want, "plain"}) {
+		t.Errorf("user = %v", got)
+	}
+	if user["email"] != "a@x" || len(user["auth"].(map[string]any)) != 2 {
+		t.Error("Result modified its input")
+	}
+
+	plan := r.Plan(in.Tables[0].Fields)
+	if row := plan.Row([]any{user, 1.0}); !reflect.DeepEqual(row, []any{want, 1.0}) {
+		t.Errorf("Plan.Row = %v", row)
+	}
+	row := []any{"plain", 1.0}
+	if got := plan.Row(row); &got[0] != &row[0] {
+		t.Error("Plan.Row copied a row without objects")
+	}
+	plain := &axiomclient.QueryResult{Tables: []axiomclient.QueryTable{{
+		Fields:  []axiomclient.QueryField{{Name: "user"}},
+		Columns: [][]any{{"plain"}},
+	}}}
+	if got := r.Result(plain); &got.Tables[0].Columns[0][0] != &plain.Tables[0].Columns[0][0] {
+		t.Error("Result copied a table without objects")
+	}
+}
@@ -0,0 +1,173 @@
+// Package selfcheck verifies that axiom-fs can reach Axiom and use its local
+// directories before anything is mounted.
+package selfcheck
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"os"
+	"sort"
+
+	"github.com/axiomhq/axiom-fs/internal/axiomclient"
+	"github.com/axiomhq/axiom-fs/internal/config"
+	"github.com/axiomhq/axiom-fs/internal/policy"
+)
+
+// Step is the outcome of one check.
+type Step struct {
+	Name string
+	// Skipped steps could not run because an earlier step failed.
+	Skipped bool
+	Err     error
+	// Detail describes a successful step.
+	Detail string
+	// Hint suggests how to fix a failed step.
+	Hint string
+}
+
+// OK reports whether the step passed.
+func (s Step) OK() bool {
+	return !s.Skipped && s.Err == nil
+}
+
+// Report is the result of Run.
+type Report struct {
+	Steps []Step
+}
+
+// OK reports whether every step passed.
+func (r Report) OK() bool {
+	for _, s := range r.Steps {
+		if !s.OK() {
+			return false
+		}
+	}
+	return true
+}
+
+// Print writes a human-readable readiness report to w.
+func (r Report) Print(w io.Writer) {
+	fmt.Fprintln(w, "axiom-fs readiness check")
+	for _, s := range r.Steps {
+		switch {
+		case s.Skipped:
+			fmt.Fprintf(w, "  skip  %-9s %s\n", s.Name, s.Detail)
+		case s.Err != nil:
+			fmt.Fprintf(w, "  FAIL  %-9s %v\n", s.Name, s.Err)
+			if s.Hint != "" {
+				fmt.Fprintf(w, "        %-9s fix: %s\n", "", s.Hint)
+			}
+		default:
+			fmt.Fprintf(w, "  ok    %-9s %s\n", s.Name, s.Detail)
+		}
+	}
+	if r.OK() {
+		fmt.Fprintln(w, "ready to mount")
+	} else {
+		fmt.Fprintln(w, "not ready: fix the failures above")
+	}
+}
+
+// Run validates the token, lists datasets, runs a tiny query against the
+// first dataset the policy shows, and checks that the cache directory is
+// writable. A nil policy shows every dataset.
+func Run(ctx context.Context, client axiomclient.API, cfg config.Config, pol *policy.Policy) Report {
+	var report Report
+	add := func(s Step) { report.Steps = append(report.Steps, s) }
+
+	token := Step{Name: "token"}
+	if user, err := client.CurrentUser(ctx); err != nil {
+		token.Err = err
+		token.Hint = "check AXIOM_URL is reachable and AXIOM_TOKEN (or --axiom-token) is valid; personal tokens also need AXIOM_ORG_ID"
+	} else {
+		token.Detail = fmt.Sprintf("connected as %s (%s)", user.Name, user.Email)
+	}
+	add(token)
+
+	datasets := Step{Name: "datasets"}
+	var first string
+	switch {
+	case !token.OK():
+		datasets.Skipped = true
+		datasets.Detail = "token check failed"
+	default:
+		names, err := visibleDatasets(ctx, client, pol)
+		switch {
+		case err != nil:
+			datasets.Err = err
+			datasets.Hint = "check the token has permission to read datasets"
+		case len(names) == 0:
+			datasets.Err = fmt.Errorf("no datasets visible")
+			datasets.Hint = "grant the token dataset access, or relax datasets.allow/deny in --policy-file"
+		default:
+			first = names[0]
+			datasets.Detail = fmt.Sprintf("%d visible", len(names))
+		}
+	}
+	add(datasets)
+
+	query := Step{Name: "query"}
+	if first == "" {
+		query.Skipped = true
+		query.Detail = "no dataset to query"
+	} else {
+		apl := "['" + first + "']\n| where _time between (ago(" + cfg.DefaultRange + ") .. now())\n| take 1"
+		if _, err := client.QueryAPL(ctx, apl); err != nil {
+			query.Err = fmt.Errorf("query %s: %w", first, err)
+			query.Hint = "check the token has query permission and --default-range is a valid ago() duration"
+		} else {
+			query.Detail = "ran a 1-row query against " + first
+		}
+	}
+	add(query)
+
+	cacheDir := Step{Name: "cache-dir"}
+	if err := checkWritable(cfg.CacheDir); err != nil {
+		cacheDir.Err = err
+		cacheDir.Hint = "choose a writable directory with --cache-dir"
+	} else if cfg.CacheDir == "" {
+		cacheDir.Detail = "disabled (memory only)"
+	} else {
+		cacheDir.Detail = cfg.CacheDir + " is writable"
+	}
+	add(cacheDir)
+
+	return report
+}
+
+func visibleDatasets(ctx context.Context, client axiomclient.API, pol *policy.Policy) ([]string, error) {
+	datasets, err := client.ListDatasets(ctx)
+	if err != nil {
+		return nil, err
+	}
+	var names []string
+	for _, d := range datasets {
+		if d.Name != "" && pol.DatasetVisible(d.Name) {
+			names = append(names, d.Name)
+		}
+	}
+	sort.Strings(names)
+	return names, nil
+}
+
+// checkWritable creates dir if needed and writes a probe file into it.
+func checkWritable(dir string) error {
+	if dir == "" {
+		return nil
+	}
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return err
+	}
+	f, err := os.CreateTemp(dir, ".axiom-fs-check-*")
+	if err != nil {
+		return err
+	}
+	name := f.Name()
+	_, err = f.Write([]byte("ok"))
+	if cerr := f.Close(); err == nil {
+		err = cerr
+	}
+	_ = os.Remove(name)
+	return err
+}
@@ -0,0 +1,105 @@
+package selfcheck
+
+import (
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/axiomhq/axiom-fs/internal/axiomclient"
+	"github.com/axiomhq/axiom-fs/internal/config"
+	"github.com/axiomhq/axiom-fs/internal/policy"
+)
+
+type fakeClient struct {
+	userErr  error
+	datasets []axiomclient.Dataset
+	queryErr error
+	apls     []string
+}
+
+func (f *fakeClient) CurrentUser(ctx context.Context) (*axiomclient.User, error) {
+	if f.userErr != nil {
+		return nil, f.userErr
+	}
+	return &axiomclient.User{Name: "Ada", Email: "ada@example.com"}, nil
+}
+
+func (f *fakeClient) ListDatasets(ctx context.Context) ([]axiomclient.Dataset, error) {
+	return f.datasets, nil
+}
+
+func (f *fakeClient) ListFields(ctx context.Context, datasetID string) ([]axiomclient.Field, error) {
+	return nil, nil
+}
+
+func (f *fakeClient) DatasetStats(ctx context.Context) ([]axiomclient.DatasetStats, error) {
+	return nil, nil
+}
+
+func (f *fakeClient) QueryAPL(ctx context.Context, apl string) (*axiomclient.QueryResult, error) {
+	f.apls = append(f.apls, apl)
+	return &axiomclient.QueryResult{}, f.queryErr
+}
+
+func TestRunReady(t *testing.T) {
+	cfg := config.Default()
+	cfg.CacheDir = filepath.Join(t.TempDir(), "cache")
+	client := &fakeClient{datasets: []axiomclient.Dataset{{Name: "web"}, {Name: "secret"}, {Name: "api"}}}
+	pol := &policy.Policy{Datasets: policy.Datasets{Deny: []string{"api"}}}
+
+	report := Run(context.Background(), client, cfg, pol)
+	if !report.OK() {
+		var buf strings.Builder
+		report.Print(&buf)
+		t.Fatalf("expected ready:\n%s", buf.String())
+	}
+	if len(client.apls) != 1 || !strings.HasPrefix(client.apls[0], "['secret']") {
+		t.Errorf("query should target the first visible dataset: %v", client.apls)
+	}
+	var buf strings.Builder
+	report.Print(&buf)
+	if !strings.Contains(buf.String(), "connected as Ada") || !strings.Contains(buf.String(), "ready to mount") {
+		t.Errorf("report:\n%s", buf.String())
+	}
+}
+
+func TestRunFailures(t *testing.T) {
+	cfg := config.Default()
+	blocker := filepath.Join(t.TempDir(), "file")
+	if err := os.WriteFile(blocker, nil, 0o644); err != nil {
+		t.Fatal(err)
+	}
+	cfg.CacheDir = filepath.Join(blocker, "cache")
+
+	report := Run(context.Background(), &fakeClient{userErr: errors.New("401 unauthorized")}, cfg, nil)
+	if report.OK() {
+		t.Fatal("expected failure")
+	}
+	byName := map[string]Step{}
+	for _, s := range report.Steps {
+		byName[s.Name] = s
+	}
+	if byName["token"].Err == nil || byName["token"].Hint == "" {
+		t.Errorf("token step = %+v", byName["token"])
+	}
+	if !byName["datasets"].Skipped || !byName["query"].Skipped {
+		t.Errorf("dataset and query steps should be skipped: %+v", report.Steps)
+	}
+	if byName["cache-dir"].Err == nil {
+		t.Error("cache-dir under a regular file should fail")
+	}
+
+	var buf strings.Builder
+	report.Print(&buf)
+	if !strings.Contains(buf.String(), "fix: check AXIOM_URL") || !strings.Contains(buf.String(), "not ready") {
+		t.Errorf("report:\n%s", buf.String())
+	}
+
+	report = Run(context.Background(), &fakeClient{}, config.Config{}, nil)
+	if report.OK() || report.Steps[1].Err == nil {
+		t.Errorf("no datasets should fail the datasets step: %+v", report.Steps)
+	}
+}
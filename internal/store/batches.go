@@ -0,0 +1,186 @@
+package store
+
+import (
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"sync"
+	"time"
+)
+
+// Batch files, under dir/<batch name>/.
+const (
+	batchQueries = "queries.json"
+	batchSummary = "summary.csv"
+	batchResults = "results"
+)
+
+// BatchFile is one result of a batch run.
+type BatchFile struct {
+	Name    string
+	Size    int64
+	ModTime time.Time
+}
+
+// BatchStore keeps query packs under dir/<batch name>/: the queries.json
+// written, results/<index>.<format> of the last run and its summary.csv.
+type BatchStore struct {
+	mu  sync.Mutex
+	dir string
+}
+
+func NewBatchStore(dir string) *BatchStore {
+	if dir == "" {
+		dir = filepath.Join(os.TempDir(), "axiom-fs-batches")
+	}
+	_ = os.MkdirAll(dir, 0o755)
+	return &BatchStore{dir: dir}
+}
+
+// Names lists the batches with a queries.json, sorted.
+func (s *BatchStore) Names() []string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	entries, err := os.ReadDir(s.dir)
+	if err != nil {
+		return nil
+	}
+	var names []string
+	for _, entry := range entries {
+		if !entry.IsDir() || !isValidName(entry.Name()) {
+			continue
+		}
+		if _, err := os.Stat(filepath.Join(s.dir, entry.Name(), batchQueries)); err == nil {
+			names = append(names, entry.Name())
+		}
+	}
+	sort.Strings(names)
+	return names
+}
+
+// Queries returns name's queries.json, nil if none was written.
+func (s *BatchStore) Queries(name string) []byte {
+	if !isValidName(name) {
+		return nil
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	data, _ := os.ReadFile(filepath.Join(s.dir, name, batchQueries))
+	return data
+}
+
+// SetQueries stores name's queries.json and drops the results and summary
+// of the previous run.
+func (s *BatchStore) SetQueries(name string, data []byte) error {
+	if !isValidName(name) {
+		return os.ErrInvalid
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	dir := filepath.Join(s.dir, name)
+	if err := os.RemoveAll(filepath.Join(dir, batchResults)); err != nil {
+		return err
+	}
+	_ = os.Remove(filepath.Join(dir, batchSummary))
+	if err := os.MkdirAll(filepath.Join(dir, batchResults), 0o755); err != nil {
+		return err
+	}
+	return writeFileAtomic(filepath.Join(dir, batchQueries), data)
+}
+
+// SaveResult stores r as name's result file, replacing it whole.
+func (s *BatchStore) SaveResult(name, file string, r io.Reader) (int64, error) {
+	if !isValidName(name) || !isValidName(file) {
+		return 0, os.ErrInvalid
+	}
+	dir := filepath.Join(s.dir, name, batchResults)
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return 0, err
+	}
+	tmp, err := os.CreateTemp(dir, ".result-*")
+	if err != nil {
+		return 0, err
+	}
+	size, err := io.Copy(tmp, r)
+	if cerr := tmp.Close(); err == nil {
+		err = cerr
+	}
+	if err == nil {
+		err = os.Rename(tmp.Name(), filepath.Join(dir, file))
+	}
+	if err != nil {
+		_ = os.Remove(tmp.Name())
+		return 0, err
+	}
+	return size, nil
+}
+
+// Results lists name's result files, sorted by name.
+func (s *BatchStore) Results(name string) []BatchFile {
+	if !isValidName(name) {
+		return nil
+	}
+	entries, err := os.ReadDir(filepath.Join(s.dir, name, batchResults))
+	if err != nil {
+		return nil
+	}
+	files := make([]BatchFile, 0, len(entries))
+	for _, entry := range entries {
+		if entry.IsDir() || !isValidName(entry.Name()) || entry.Name()[0] == '.' {
+			continue
+		}
+		info, err := entry.Info()
+		if err != nil {
+			continue
+		}
+		files = append(files, BatchFile{Name: entry.Name(), Size: info.Size(), ModTime: info.ModTime()})
+	}
+	return files
+}
+
+// ReadResult returns the contents of name's result file.
+func (s *BatchStore) ReadResult(name, file string) ([]byte, error) {
+	if !isValidName(name) || !isValidName(file) || file[0] == '.' {
+		return nil, os.ErrNotExist
+	}
+	return os.ReadFile(filepath.Join(s.dir, name, batchResults, file))
+}
+
+// Summary returns name's summary.csv of its last finished run.
+func (s *BatchStore) Summary(name string) ([]byte, error) {
+	if !isValidName(name) {
+		return nil, os.ErrNotExist
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return os.ReadFile(filepath.Join(s.dir, name, batchSummary))
+}
+
+// SetSummary stores name's summary.csv.
+func (s *BatchStore) SetSummary(name string, data []byte) error {
+	if !isValidName(name) {
+		return os.ErrInvalid
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return writeFileAtomic(filepath.Join(s.dir, name, batchSummary), data)
+}
+
+func writeFileAtomic(path string, data []byte) error {
+	tmp, err := os.CreateTemp(filepath.Dir(path), ".tmp-*")
+	if err != nil {
+		return err
+	}
+	_, err = tmp.Write(data)
+	if cerr := tmp.Close(); err == nil {
+		err = cerr
+	}
+	if err == nil {
+		err = os.Rename(tmp.Name(), path)
+	}
+	if err != nil {
+		_ = os.Remove(tmp.Name())
+	}
+	return err
+}
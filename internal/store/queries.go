@@ -8,17 +8,34 @@ import (
 	"sync"
 )
 
+// QueryStore keeps named text files, <name><ext>, in one directory.
 type QueryStore struct {
 	mu  sync.Mutex
 	dir string
+	ext string
+	// revs counts writes per query since the store was opened.
+	revs map[string]uint64
+	// onChange is called after every Set and Truncate.
+	onChange func(name string, rev uint64)
 }
 
+// NewQueryStore stores APL as <name>.apl.
 func NewQueryStore(dir string) *QueryStore {
+	return newStore(dir, ".apl")
+}
+
+// NewVarsStore stores the template variables of saved queries as
+// <name>.vars, next to their APL when dir is the query directory.
+func NewVarsStore(dir string) *QueryStore {
+	return newStore(dir, ".vars")
+}
+
+func newStore(dir, ext string) *QueryStore {
 	if dir == "" {
 		dir = filepath.Join(os.TempDir(), "axiom-fs-queries")
 	}
 	_ = os.MkdirAll(dir, 0o755)
-	return &QueryStore{dir: dir}
+	return &QueryStore{dir: dir, ext: ext, revs: map[string]uint64{}}
 }
 
 func (s *QueryStore) Get(name string) []byte {
@@ -27,10 +44,46 @@ func (s *QueryStore) Get(name string) []byte {
 	}
 	s.mu.Lock()
 	defer s.mu.Unlock()
-	data, _ := os.ReadFile(filepath.Join(s.dir, name+".apl"))
+	data, _ := os.ReadFile(filepath.Join(s.dir, name+s.ext))
 	return data
 }
 
+// GetRevision returns the query and its revision, read together so the
+// text belongs to that revision.
+func (s *QueryStore) GetRevision(name string) ([]byte, uint64) {
+	if !isValidName(name) {
+		return nil, 0
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	data, _ := os.ReadFile(filepath.Join(s.dir, name+s.ext))
+	return data, s.revs[name]
+}
+
+// Revision is bumped by every Set and Truncate of name.
+func (s *QueryStore) Revision(name string) uint64 {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.revs[name]
+}
+
+// OnChange calls fn with the name and new revision of every query written
+// or truncated from now on.
+func (s *QueryStore) OnChange(fn func(name string, rev uint64)) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.onChange = fn
+}
+
+// changed bumps name's revision and tells the OnChange hook; s.mu must be
+// held.
+func (s *QueryStore) changed(name string) {
+	s.revs[name]++
+	if s.onChange != nil {
+		s.onChange(name, s.revs[name])
+	}
+}
+
 func (s *QueryStore) Set(name string, data []byte) {
 	if !isValidName(name) {
 		return
@@ -38,7 +91,7 @@ func (s *QueryStore) Set(name string, data []byte) {
 	s.mu.Lock()
 	defer s.mu.Unlock()
 
-	path := filepath.Join(s.dir, name+".apl")
+	path := filepath.Join(s.dir, name+s.ext)
 	tmp, err := os.CreateTemp(s.dir, "apl-*")
 	if err != nil {
 		return
@@ -46,6 +99,7 @@ func (s *QueryStore) Set(name string, data []byte) {
 	_, _ = tmp.Write(data)
 	_ = tmp.Close()
 	_ = os.Rename(tmp.Name(), path)
+	s.changed(name)
 }
 
 func (s *QueryStore) Truncate(name string) {
@@ -54,8 +108,9 @@ func (s *QueryStore) Truncate(name string) {
 	}
 	s.mu.Lock()
 	defer s.mu.Unlock()
-	path := filepath.Join(s.dir, name+".apl")
+	path := filepath.Join(s.dir, name+s.ext)
 	_ = os.WriteFile(path, nil, 0o644)
+	s.changed(name)
 }
 
 func (s *QueryStore) Names() []string {
@@ -70,8 +125,8 @@ func (s *QueryStore) Names() []string {
 		if entry.IsDir() {
 			continue
 		}
-		name := strings.TrimSuffix(entry.Name(), ".apl")
-		if isValidName(name) {
+		name, ok := strings.CutSuffix(entry.Name(), s.ext)
+		if ok && isValidName(name) {
 			names = append(names, name)
 		}
 	}
@@ -0,0 +1,134 @@
+package store
+
+import (
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// snapshotExt is the format snapshots are stored in.
+const snapshotExt = ".ndjson"
+
+// Snapshot is one immutable copy of a saved query's result.
+type Snapshot struct {
+	// ID is the UTC RFC 3339 time the snapshot was taken, e.g.
+	// "2025-06-01T12:00:00Z"; the file is ID + ".ndjson".
+	ID   string
+	At   time.Time
+	Size int64
+}
+
+// Name is the snapshot's file name.
+func (s Snapshot) Name() string {
+	return s.ID + snapshotExt
+}
+
+// SnapshotStore keeps snapshots under dir/<query name>/.
+type SnapshotStore struct {
+	mu  sync.Mutex
+	dir string
+}
+
+func NewSnapshotStore(dir string) *SnapshotStore {
+	if dir == "" {
+		dir = filepath.Join(os.TempDir(), "axiom-fs-snapshots")
+	}
+	_ = os.MkdirAll(dir, 0o755)
+	return &SnapshotStore{dir: dir}
+}
+
+// Save stores r as a new snapshot of name taken at at. Snapshots are never
+// overwritten: a second snapshot within the same second is moved to the
+// next free second.
+func (s *SnapshotStore) Save(name string, at time.Time, r io.Reader) (Snapshot, error) {
+	if !isValidName(name) {
+		return Snapshot{}, os.ErrInvalid
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	dir := filepath.Join(s.dir, name)
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return Snapshot{}, err
+	}
+	tmp, err := os.CreateTemp(dir, "snapshot-*")
+	if err != nil {
+		return Snapshot{}, err
+	}
+	size, err := io.Copy(tmp, r)
+	if cerr := tmp.Close(); err == nil {
+		err = cerr
+	}
+	if err != nil {
+		_ = os.Remove(tmp.Name())
+		return Snapshot{}, err
+	}
+
+	at = at.UTC().Truncate(time.Second)
+	for {
+		snap := Snapshot{ID: at.Format(time.RFC3339), At: at, Size: size}
+		path := filepath.Join(dir, snap.Name())
+		if _, err := os.Lstat(path); os.IsNotExist(err) {
+			if err := os.Rename(tmp.Name(), path); err != nil {
+				_ = os.Remove(tmp.Name())
+				return Snapshot{}, err
+			}
+			_ = os.Chtimes(path, at, at)
+			return snap, nil
+		}
+		at = at.Add(time.Second)
+	}
+}
+
+// List returns name's snapshots, oldest first.
+func (s *SnapshotStore) List(name string) []Snapshot {
+	if !isValidName(name) {
+		return nil
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	entries, err := os.ReadDir(filepath.Join(s.dir, name))
+	if err != nil {
+		return nil
+	}
+	snapshots := make([]Snapshot, 0, len(entries))
+	for _, entry := range entries {
+		snap, ok := parseSnapshot(entry)
+		if ok {
+			snapshots = append(snapshots, snap)
+		}
+	}
+	sort.Slice(snapshots, func(i, j int) bool { return snapshots[i].At.Before(snapshots[j].At) })
+	return snapshots
+}
+
+// Read returns the contents of the snapshot stored as file.
+func (s *SnapshotStore) Read(name, file string) (Snapshot, []byte, error) {
+	for _, snap := range s.List(name) {
+		if snap.Name() == file {
+			data, err := os.ReadFile(filepath.Join(s.dir, name, file))
+			return snap, data, err
+		}
+	}
+	return Snapshot{}, nil, os.ErrNotExist
+}
+
+func parseSnapshot(entry os.DirEntry) (Snapshot, bool) {
+	id, ok := strings.CutSuffix(entry.Name(), snapshotExt)
+	if !ok || entry.IsDir() {
+		return Snapshot{}, false
+	}
+	at, err := time.Parse(time.RFC3339, id)
+	if err != nil {
+		return Snapshot{}, false
+	}
+	info, err := entry.Info()
+	if err != nil {
+		return Snapshot{}, false
+	}
+	return Snapshot{ID: id, At: at, Size: info.Size()}, true
+}
@@ -0,0 +1,79 @@
+// Package systemd implements the two systemd protocols a service needs:
+// socket activation, taking the listeners systemd opened and passed in
+// LISTEN_FDS, and sd_notify readiness, sending state to NOTIFY_SOCKET.
+// Neither needs libsystemd.
+package systemd
+
+import (
+	"fmt"
+	"net"
+	"os"
+	"strconv"
+	"strings"
+)
+
+// listenFDsStart is the first file descriptor systemd passes. Overridden
+// in tests.
+var listenFDsStart = 3
+
+// Listeners returns the sockets systemd passed to the process, in the
+// order of the socket unit's Listen lines, or none when it was not socket
+// activated. The LISTEN_* variables are removed so children do not take
+// the sockets too.
+func Listeners() ([]net.Listener, error) {
+	pid, err := strconv.Atoi(os.Getenv("LISTEN_PID"))
+	if err != nil || pid != os.Getpid() {
+		return nil, nil
+	}
+	n, err := strconv.Atoi(os.Getenv("LISTEN_FDS"))
+	if err != nil || n < 1 {
+		return nil, nil
+	}
+	names := strings.Split(os.Getenv("LISTEN_FDNAMES"), ":")
+	for _, key := range []string{"LISTEN_PID", "LISTEN_FDS", "LISTEN_FDNAMES"} {
+		_ = os.Unsetenv(key)
+	}
+
+	listeners := make([]net.Listener, 0, n)
+	for i := range n {
+		name := "LISTEN_FD_" + strconv.Itoa(listenFDsStart+i)
+		if i < len(names) && names[i] != "" {
+			name = names[i]
+		}
+		f := os.NewFile(uintptr(listenFDsStart+i), name)
+		// FileListener duplicates the descriptor; the original is closed.
+		l, err := net.FileListener(f)
+		_ = f.Close()
+		if err != nil {
+			for _, l := range listeners {
+				_ = l.Close()
+			}
+			return nil, fmt.Errorf("systemd: socket %s is not a stream listener: %w", name, err)
+		}
+		listeners = append(listeners, l)
+	}
+	return listeners, nil
+}
+
+// Notify sends state, such as "READY=1" or "STOPPING=1", to the service
+// manager. It reports false, and does nothing, when the process was not
+// started with NOTIFY_SOCKET.
+func Notify(state string) (bool, error) {
+	socket := os.Getenv("NOTIFY_SOCKET")
+	if socket == "" {
+		return false, nil
+	}
+	// A leading @ names a socket in the abstract namespace.
+	if strings.HasPrefix(socket, "@") {
+		socket = "\x00" + socket[1:]
+	}
+	conn, err := net.DialUnix("unixgram", nil, &net.UnixAddr{Name: socket, Net: "unixgram"})
+	if err != nil {
+		return false, fmt.Errorf("systemd: notify: %w", err)
+	}
+	defer conn.Close()
+	if _, err := conn.Write([]byte(state)); err != nil {
+		return false, fmt.Errorf("systemd: notify: %w", err)
+	}
+	return true, nil
+}
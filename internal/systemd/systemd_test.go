@@ -0,0 +1,74 @@
+package systemd
+
+import (
+	"net"
+	"os"
+	"path/filepath"
+	"strconv"
+	"syscall"
+	"testing"
+)
+
+func TestListeners(t *testing.T) {
+	t.Setenv("LISTEN_PID", "")
+	if ls, err := Listeners(); err != nil || ls != nil {
+		t.Fatalf("Listeners without activation = %v, %v", ls, err)
+	}
+
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer l.Close()
+	f, err := l.(*net.TCPListener).File()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	// Listeners closes the descriptor it is passed, so it gets its own.
+	fd, err := syscall.Dup(int(f.Fd()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	prev := listenFDsStart
+	t.Cleanup(func() { listenFDsStart = prev })
+	listenFDsStart = fd
+	t.Setenv("LISTEN_PID", strconv.Itoa(os.Getpid()))
+	t.Setenv("LISTEN_FDS", "1")
+	t.Setenv("LISTEN_FDNAMES", "nfs")
+
+	ls, err := Listeners()
+	if err != nil || len(ls) != 1 {
+		t.Fatalf("Listeners = %v, %v; want one", ls, err)
+	}
+	defer ls[0].Close()
+	if ls[0].Addr().String() != l.Addr().String() {
+		t.Errorf("listener on %s, want %s", ls[0].Addr(), l.Addr())
+	}
+	if _, ok := os.LookupEnv("LISTEN_FDS"); ok {
+		t.Error("LISTEN_FDS left set")
+	}
+}
+
+func TestNotify(t *testing.T) {
+	t.Setenv("NOTIFY_SOCKET", "")
+	if sent, err := Notify("READY=1"); sent || err != nil {
+		t.Fatalf("Notify without NOTIFY_SOCKET = %v, %v", sent, err)
+	}
+
+	socket := filepath.Join(t.TempDir(), "notify")
+	conn, err := net.ListenUnixgram("unixgram", &net.UnixAddr{Name: socket, Net: "unixgram"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	t.Setenv("NOTIFY_SOCKET", socket)
+	if sent, err := Notify("READY=1"); !sent || err != nil {
+		t.Fatalf("Notify = %v, %v", sent, err)
+	}
+	buf := make([]byte, 64)
+	n, err := conn.Read(buf)
+	if err != nil || string(buf[:n]) != "READY=1" {
+		t.Errorf("received %q, %v; want READY=1", buf[:n], err)
+	}
+}
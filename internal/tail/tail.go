@@ -0,0 +1,374 @@
+// Package tail keeps live, append-only ndjson streams of newly ingested
+// events, one per dataset, for /<dataset>/tail.ndjson.
+//
+// A stream starts on first use and polls Axiom for events ingested since
+// the newest one it has seen. The stream only grows, so `tail -f` picks up
+// new events by watching the size. While no events arrive, a heartbeat line
+// is appended every heartbeat interval so readers see the stream is alive.
+// Only the newest MaxBytes are kept; older offsets read back as newlines,
+// which ndjson readers skip. A stream nobody has touched for IdleTimeout
+// stops polling and is dropped.
+package tail
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"io"
+	"log/slog"
+	"sync"
+	"time"
+
+	"github.com/axiomhq/axiom-fs/internal/axiomclient"
+)
+
+// IdleTimeout is how long a stream keeps polling without readers.
+const IdleTimeout = 2 * time.Minute
+
+// pollLimit caps the events fetched per poll.
+const pollLimit = 1000
+
+// ErrClosed is returned for new streams once the Manager is closed.
+var ErrClosed = errors.New("tail stream closed")
+
+// Poller fetches events ingested after a given ingest time; see
+// axiomclient.Client.Poll.
+type Poller interface {
+	Poll(ctx context.Context, source string, after time.Time, limit int) (*axiomclient.QueryResult, error)
+}
+
+// Options tune every stream of a Manager.
+type Options struct {
+	// Interval is the delay between polls.
+	Interval time.Duration
+	// Heartbeat is how long a stream may go without output before a
+	// heartbeat line is appended. Zero disables heartbeats.
+	Heartbeat time.Duration
+	// QuietHeartbeats leaves heartbeats out of the stream, which then holds
+	// only events; Status still reports the last one.
+	QuietHeartbeats bool
+	// MaxBytes is how much of the stream is retained.
+	MaxBytes int
+}
+
+// Manager owns the live streams, keyed by dataset.
+type Manager struct {
+	poller Poller
+	opts   Options
+	now    func() time.Time
+
+	mu      sync.Mutex
+	streams map[string]*Stream
+	closed  bool
+}
+
+func NewManager(poller Poller, opts Options) *Manager {
+	if opts.Interval <= 0 {
+		opts.Interval = 2 * time.Second
+	}
+	if opts.MaxBytes <= 0 {
+		opts.MaxBytes = 8 << 20
+	}
+	return &Manager{poller: poller, opts: opts, now: time.Now, streams: map[string]*Stream{}}
+}
+
+// Stream returns the running stream of dataset, starting one that tails
+// source from now on when there is none. A new stream polls with the values
+// of ctx, such as the client it is charged to, but outlives it.
+func (m *Manager) Stream(ctx context.Context, dataset, source string) (*Stream, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if m.closed {
+		return nil, ErrClosed
+	}
+	if s, ok := m.streams[dataset]; ok {
+		s.touch()
+		return s, nil
+	}
+	ctx, cancel := context.WithCancel(context.WithoutCancel(ctx))
+	now := m.now()
+	s := &Stream{
+		source:   source,
+		opts:     m.opts,
+		now:      m.now,
+		cancel:   cancel,
+		cursor:   now,
+		lastRead: now,
+		lastData: now,
+		modTime:  now,
+		changed:  make(chan struct{}),
+		done:     make(chan struct{}),
+	}
+	m.streams[dataset] = s
+	go func() {
+		s.run(ctx, m.poller)
+		m.mu.Lock()
+		if m.streams[dataset] == s {
+			delete(m.streams, dataset)
+		}
+		m.mu.Unlock()
+	}()
+	return s, nil
+}
+
+// Get returns the running stream of dataset without starting one.
+func (m *Manager) Get(dataset string) (*Stream, bool) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	s, ok := m.streams[dataset]
+	if ok {
+		s.touch()
+	}
+	return s, ok
+}
+
+// Status reports the stream of dataset without keeping it alive; Running
+// is false when there is none.
+func (m *Manager) Status(dataset string) Status {
+	m.mu.Lock()
+	s, ok := m.streams[dataset]
+	m.mu.Unlock()
+	if !ok {
+		return Status{}
+	}
+	return s.Status()
+}
+
+// Close stops every stream and wakes blocked readers. A nil Manager is
+// already closed.
+func (m *Manager) Close() {
+	if m == nil {
+		return
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.closed = true
+	for _, s := range m.streams {
+		s.cancel()
+	}
+}
+
+// Stream is one live tail.
+type Stream struct {
+	source string
+	opts   Options
+	now    func() time.Time
+	cancel context.CancelFunc
+	done   chan struct{}
+
+	mu       sync.Mutex
+	buf      []byte
+	base     int64     // stream offset of buf[0]
+	cursor   time.Time // newest _sysTime seen
+	lastRead time.Time
+	lastData time.Time
+	modTime  time.Time
+	polled   time.Time
+	beat     time.Time
+	err      error
+	// changed is closed and replaced whenever the stream grows.
+	changed chan struct{}
+}
+
+// Size is the stream length so far.
+func (s *Stream) Size() int64 {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.base + int64(len(s.buf))
+}
+
+// ModTime is when the stream last grew.
+func (s *Stream) ModTime() time.Time {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.modTime
+}
+
+// Err is the last poll error, if the latest poll failed.
+func (s *Stream) Err() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.err
+}
+
+// Status describes a stream, for tail.status.json.
+type Status struct {
+	Running     bool      `json:"running"`
+	Bytes       int64     `json:"bytes"`
+	ModTime     time.Time `json:"mod_time"`
+	PolledAt    time.Time `json:"polled_at,omitzero"`
+	HeartbeatAt time.Time `json:"heartbeat_at,omitzero"`
+	Error       string    `json:"error,omitempty"`
+}
+
+// Status reports the stream's size, last poll and last heartbeat.
+func (s *Stream) Status() Status {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	status := Status{
+		Running:     true,
+		Bytes:       s.base + int64(len(s.buf)),
+		ModTime:     s.modTime,
+		PolledAt:    s.polled,
+		HeartbeatAt: s.beat,
+	}
+	if s.err != nil {
+		status.Error = s.err.Error()
+	}
+	return status
+}
+
+func (s *Stream) touch() {
+	s.mu.Lock()
+	s.lastRead = s.now()
+	s.mu.Unlock()
+}
+
+// ReadAt reads the stream at off. At the end of the stream it blocks until
+// new output arrives, ctx ends or the stream stops; reads into an empty p
+// never block.
+func (s *Stream) ReadAt(ctx context.Context, p []byte, off int64) (int, error) {
+	for {
+		s.mu.Lock()
+		s.lastRead = s.now()
+		size := s.base + int64(len(s.buf))
+		if len(p) == 0 || off < size {
+			n := s.copyLocked(p, off)
+			s.mu.Unlock()
+			if off+int64(n) >= size {
+				return n, io.EOF
+			}
+			return n, nil
+		}
+		changed := s.changed
+		s.mu.Unlock()
+
+		select {
+		case <-changed:
+		case <-s.done:
+			return 0, io.EOF
+		case <-ctx.Done():
+			return 0, io.EOF
+		}
+	}
+}
+
+// copyLocked copies the stream from off into p. Offsets that fell out of
+// the retained window read as newlines.
+func (s *Stream) copyLocked(p []byte, off int64) int {
+	n := 0
+	for off < s.base && n < len(p) {
+		p[n] = '\n'
+		n++
+		off++
+	}
+	if n < len(p) && off >= s.base && off < s.base+int64(len(s.buf)) {
+		n += copy(p[n:], s.buf[off-s.base:])
+	}
+	return n
+}
+
+func (s *Stream) run(ctx context.Context, poller Poller) {
+	defer close(s.done)
+	defer s.cancel()
+	ticker := time.NewTicker(s.opts.Interval)
+	defer ticker.Stop()
+	for {
+		s.poll(ctx, poller)
+		s.mu.Lock()
+		idle := s.now().Sub(s.lastRead) > IdleTimeout
+		s.mu.Unlock()
+		if idle {
+			return
+		}
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+func (s *Stream) poll(ctx context.Context, poller Poller) {
+	s.mu.Lock()
+	cursor := s.cursor
+	s.mu.Unlock()
+
+	result, err := poller.Poll(ctx, s.source, cursor, pollLimit)
+	if ctx.Err() != nil {
+		return
+	}
+	var lines []byte
+	newest := cursor
+	if err == nil {
+		lines, newest = encodeEvents(result, cursor)
+	} else {
+		slog.Debug("tail poll failed", "source", s.source, "error", err)
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.err = err
+	s.cursor = newest
+	now := s.now()
+	if err == nil {
+		s.polled = now
+	}
+	if len(lines) == 0 && s.opts.Heartbeat > 0 && now.Sub(s.lastData) >= s.opts.Heartbeat {
+		s.beat = now
+		if s.opts.QuietHeartbeats {
+			s.lastData = now
+			return
+		}
+		lines = heartbeat(now)
+	}
+	if len(lines) == 0 {
+		return
+	}
+	s.buf = append(s.buf, lines...)
+	if over := len(s.buf) - s.opts.MaxBytes; over > 0 {
+		s.buf = append([]byte(nil), s.buf[over:]...)
+		s.base += int64(over)
+	}
+	s.lastData, s.modTime = now, now
+	close(s.changed)
+	s.changed = make(chan struct{})
+}
+
+// encodeEvents renders result's rows as ndjson and returns the newest
+// _sysTime among them.
+func encodeEvents(result *axiomclient.QueryResult, cursor time.Time) ([]byte, time.Time) {
+	if result == nil || len(result.Tables) == 0 {
+		return nil, cursor
+	}
+	table := result.Tables[0]
+	if len(table.Columns) == 0 {
+		return nil, cursor
+	}
+	var out []byte
+	for row := range table.Columns[0] {
+		event := make(map[string]any, len(table.Fields))
+		for i, f := range table.Fields {
+			if i < len(table.Columns) && row < len(table.Columns[i]) {
+				event[f.Name] = table.Columns[i][row]
+			}
+		}
+		if v, ok := event["_sysTime"].(string); ok {
+			if t, err := time.Parse(time.RFC3339Nano, v); err == nil && t.After(cursor) {
+				cursor = t
+			}
+		}
+		line, err := json.Marshal(event)
+		if err != nil {
+			continue
+		}
+		out = append(out, line...)
+		out = append(out, '\n')
+	}
+	return out, cursor
+}
+
+func heartbeat(now time.Time) []byte {
+	line, _ := json.Marshal(map[string]string{"_heartbeat": now.UTC().Format(time.RFC3339)})
+	return append(line, '\n')
+}
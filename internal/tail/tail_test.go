@@ -0,0 +1,162 @@
+package tail
+
+import (
+	"context"
+	"errors"
+	"io"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/axiomhq/axiom-fs/internal/axiomclient"
+)
+
+type fakePoller struct {
+	mu      sync.Mutex
+	batches []*axiomclient.QueryResult
+	afters  []time.Time
+	sources []string
+}
+
+func (f *fakePoller) Poll(ctx context.Context, source string, after time.Time, limit int) (*axiomclient.QueryResult, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.afters = append(f.afters, after)
+	f.sources = append(f.sources, source)
+	if len(f.batches) == 0 {
+		return &axiomclient.QueryResult{}, nil
+	}
+	batch := f.batches[0]
+	f.batches = f.batches[1:]
+	return batch, nil
+}
+
+func (f *fakePoller) push(sysTime, msg string) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.batches = append(f.batches, &axiomclient.QueryResult{Tables: []axiomclient.QueryTable{{
+		Fields:  []axiomclient.QueryField{{Name: "_sysTime"}, {Name: "msg"}},
+		Columns: [][]any{{sysTime}, {msg}},
+	}}})
+}
+
+func readAll(t *testing.T, s *Stream, off int64) string {
+	t.Helper()
+	p := make([]byte, 4096)
+	ctx, cancel := context.WithTimeout(context.Background(), 2*time.Second)
+	defer cancel()
+	n, err := s.ReadAt(ctx, p, off)
+	if err != nil && !errors.Is(err, io.EOF) {
+		t.Fatal(err)
+	}
+	return string(p[:n])
+}
+
+func TestStream(t *testing.T) {
+	poller := &fakePoller{}
+	m := NewManager(poller, Options{Interval: 5 * time.Millisecond})
+	defer m.Close()
+
+	if _, ok := m.Get("logs"); ok {
+		t.Fatal("Get should not start a stream")
+	}
+	s, err := m.Stream(context.Background(), "logs", "['logs']")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if s.Size() != 0 {
+		t.Fatalf("new stream size = %d", s.Size())
+	}
+
+	// A read at the end blocks until events arrive.
+	first := time.Now().UTC().Add(time.Second).Truncate(time.Second)
+	poller.push(first.Format(time.RFC3339), "first")
+	got := readAll(t, s, 0)
+	if got != `{"_sysTime":"`+first.Format(time.RFC3339)+`","msg":"first"}`+"\n" {
+		t.Fatalf("first read = %q", got)
+	}
+	size := s.Size()
+	second := first.Add(time.Second)
+	poller.push(second.Format(time.RFC3339), "second")
+	if got := readAll(t, s, size); !strings.Contains(got, `"msg":"second"`) {
+		t.Fatalf("second read = %q", got)
+	}
+
+	// The next poll continues after the newest ingest time seen.
+	var last time.Time
+	var source string
+	for deadline := time.Now().Add(2 * time.Second); time.Now().Before(deadline); time.Sleep(time.Millisecond) {
+		poller.mu.Lock()
+		last, source = poller.afters[len(poller.afters)-1], poller.sources[0]
+		poller.mu.Unlock()
+		if last.Equal(second) {
+			break
+		}
+	}
+	if !last.Equal(second) || source != "['logs']" {
+		t.Errorf("poll cursor = %v, source = %q", last, source)
+	}
+
+	if again, _ := m.Stream(context.Background(), "logs", "['logs']"); again != s {
+		t.Error("second Stream call should share the running stream")
+	}
+}
+
+func TestStreamHeartbeatAndRetention(t *testing.T) {
+	poller := &fakePoller{}
+	m := NewManager(poller, Options{Interval: 5 * time.Millisecond, Heartbeat: time.Millisecond, MaxBytes: 64})
+	s, _ := m.Stream(context.Background(), "logs", "['logs']")
+
+	if got := readAll(t, s, 0); !strings.HasPrefix(got, `{"_heartbeat":`) {
+		t.Fatalf("expected heartbeat, got %q", got)
+	}
+	deadline := time.Now().Add(2 * time.Second)
+	for s.Size() <= 64 && time.Now().Before(deadline) {
+		time.Sleep(5 * time.Millisecond)
+	}
+	if s.Size() <= 64 {
+		t.Fatal("stream did not grow past MaxBytes")
+	}
+	// Trimmed offsets read as newlines.
+	if got := readAll(t, s, 0); got == "" || strings.Trim(got[:1], "\n") != "" {
+		t.Errorf("trimmed prefix = %q", got)
+	}
+
+	m.Close()
+	select {
+	case <-s.done:
+	case <-time.After(2 * time.Second):
+		t.Fatal("Close did not stop the stream")
+	}
+	if _, err := m.Stream(context.Background(), "web", "['web']"); !errors.Is(err, ErrClosed) {
+		t.Errorf("Stream after Close: err = %v", err)
+	}
+	var nilManager *Manager
+	nilManager.Close()
+}
+
+func TestStreamQuietHeartbeats(t *testing.T) {
+	poller := &fakePoller{}
+	m := NewManager(poller, Options{Interval: 5 * time.Millisecond, Heartbeat: time.Millisecond, QuietHeartbeats: true})
+	defer m.Close()
+	if status := m.Status("logs"); status.Running {
+		t.Fatalf("Status before Stream = %+v", status)
+	}
+	s, _ := m.Stream(context.Background(), "logs", "['logs']")
+
+	deadline := time.Now().Add(2 * time.Second)
+	for m.Status("logs").HeartbeatAt.IsZero() && time.Now().Before(deadline) {
+		time.Sleep(time.Millisecond)
+	}
+	status := m.Status("logs")
+	if !status.Running || status.HeartbeatAt.IsZero() || status.PolledAt.IsZero() || status.Bytes != 0 || s.Size() != 0 {
+		t.Fatalf("quiet heartbeat status = %+v, size %d", status, s.Size())
+	}
+
+	now := time.Now().UTC().Add(time.Second).Truncate(time.Second)
+	poller.push(now.Format(time.RFC3339), "event")
+	if got := readAll(t, s, 0); strings.Contains(got, "_heartbeat") || !strings.Contains(got, `"msg":"event"`) {
+		t.Errorf("stream = %q, want only the event", got)
+	}
+}
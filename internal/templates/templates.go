@@ -0,0 +1,197 @@
+// Package templates holds fill-in-the-blank APL for users new to APL.
+//
+// A template is an ordinary saved query with ${NAME} placeholders. Copied
+// into _queries/<name>/apl, it runs once a vars file next to it sets every
+// placeholder:
+//
+//	DATASET=http-logs
+//	FIELD=service
+package templates
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"io/fs"
+	"regexp"
+	"sort"
+	"strings"
+)
+
+// Template is one APL template, served as /_templates/<Category>/<Name>.apl.
+type Template struct {
+	Category    string
+	Name        string
+	Description string
+	// Vars documents each placeholder, in order of first use.
+	Vars   []Var
+	Source string
+}
+
+// Var documents a placeholder.
+type Var struct {
+	Name    string
+	Help    string
+	Example string
+}
+
+// ErrMissingVars is returned by Render when placeholders have no value.
+var ErrMissingVars = fmt.Errorf("template variables not set: %w", fs.ErrInvalid)
+
+var varDataset = Var{Name: "DATASET", Help: "dataset to query", Example: "http-logs"}
+
+var catalog = []Template{
+	{
+		Category:    "errors",
+		Name:        "errors-by-service",
+		Description: "HTTP 5xx responses per service in the last hour",
+		Vars:        []Var{varDataset, {Name: "FIELD", Help: "field naming the service", Example: "service"}},
+		Source:      "['${DATASET}']\n| where _time > ago(1h)\n| where status >= 500\n| summarize errors = count() by ${FIELD}\n| order by errors desc",
+	},
+	{
+		Category:    "errors",
+		Name:        "top-error-messages",
+		Description: "Most frequent error messages in the last hour",
+		Vars:        []Var{varDataset, {Name: "FIELD", Help: "field holding the message", Example: "message"}},
+		Source:      "['${DATASET}']\n| where _time > ago(1h)\n| where level == \"error\"\n| summarize count() by ${FIELD}\n| order by count_ desc\n| take 20",
+	},
+	{
+		Category:    "latency",
+		Name:        "p99-latency",
+		Description: "p50/p95/p99 of a duration field over the last hour",
+		Vars:        []Var{varDataset, {Name: "FIELD", Help: "numeric duration field", Example: "duration"}},
+		Source:      "['${DATASET}']\n| where _time > ago(1h)\n| summarize p50 = percentile(${FIELD}, 50), p95 = percentile(${FIELD}, 95), p99 = percentile(${FIELD}, 99) by bin_auto(_time)",
+	},
+	{
+		Category:    "product",
+		Name:        "funnel",
+		Description: "Distinct users reaching each of three steps in the last week",
+		Vars: []Var{
+			varDataset,
+			{Name: "USER", Help: "field identifying the user", Example: "user_id"},
+			{Name: "FIELD", Help: "field holding the event name", Example: "event"},
+			{Name: "STEP1", Help: "first event name", Example: "signup"},
+			{Name: "STEP2", Help: "second event name", Example: "activate"},
+			{Name: "STEP3", Help: "third event name", Example: "purchase"},
+		},
+		Source: "['${DATASET}']\n| where _time > ago(7d)\n| summarize step1 = dcountif(${USER}, ${FIELD} == \"${STEP1}\"), step2 = dcountif(${USER}, ${FIELD} == \"${STEP2}\"), step3 = dcountif(${USER}, ${FIELD} == \"${STEP3}\")",
+	},
+	{
+		Category:    "traffic",
+		Name:        "top-values",
+		Description: "Most common values of a field in the last hour",
+		Vars:        []Var{varDataset, {Name: "FIELD", Help: "field to count", Example: "endpoint"}},
+		Source:      "['${DATASET}']\n| where _time > ago(1h)\n| summarize count() by ${FIELD}\n| order by count_ desc\n| take 50",
+	},
+}
+
+// All returns every template, sorted by category and name.
+func All() []Template {
+	all := append([]Template(nil), catalog...)
+	sort.Slice(all, func(i, j int) bool {
+		if all[i].Category != all[j].Category {
+			return all[i].Category < all[j].Category
+		}
+		return all[i].Name < all[j].Name
+	})
+	return all
+}
+
+// Categories returns the template categories, sorted.
+func Categories() []string {
+	var categories []string
+	for _, t := range All() {
+		if len(categories) == 0 || categories[len(categories)-1] != t.Category {
+			categories = append(categories, t.Category)
+		}
+	}
+	return categories
+}
+
+// Lookup finds a template by category and name.
+func Lookup(category, name string) (Template, bool) {
+	for _, t := range catalog {
+		if t.Category == category && t.Name == name {
+			return t, true
+		}
+	}
+	return Template{}, false
+}
+
+// File renders the template as served: a comment header explaining the
+// placeholders and an example vars file, then the APL.
+func (t Template) File() []byte {
+	var b bytes.Buffer
+	fmt.Fprintf(&b, "// %s\n//\n", t.Description)
+	b.WriteString("// Copy this file to _queries/<name>/apl, then write a vars file next to\n")
+	b.WriteString("// it that sets each placeholder, e.g.:\n//\n")
+	for _, v := range t.Vars {
+		fmt.Fprintf(&b, "//   %s=%s\n", v.Name, v.Example)
+	}
+	b.WriteString("//\n")
+	for _, v := range t.Vars {
+		fmt.Fprintf(&b, "// %-8s %s\n", v.Name, v.Help)
+	}
+	b.WriteString(t.Source)
+	b.WriteByte('\n')
+	return b.Bytes()
+}
+
+var (
+	placeholderRe = regexp.MustCompile(`\$\{([A-Z][A-Z0-9_]*)\}`)
+	nameRe        = regexp.MustCompile(`^[A-Z][A-Z0-9_]*$`)
+)
+
+// Placeholders returns the distinct ${NAME} placeholders in src, in order
+// of first use.
+func Placeholders(src string) []string {
+	var names []string
+	seen := map[string]bool{}
+	for _, m := range placeholderRe.FindAllStringSubmatch(src, -1) {
+		if !seen[m[1]] {
+			seen[m[1]] = true
+			names = append(names, m[1])
+		}
+	}
+	return names
+}
+
+// Render replaces each ${NAME} in src with vars[NAME]. Placeholders without
+// a value are reported together in an error wrapping ErrMissingVars.
+func Render(src string, vars map[string]string) (string, error) {
+	var missing []string
+	for _, name := range Placeholders(src) {
+		if _, ok := vars[name]; !ok {
+			missing = append(missing, name)
+		}
+	}
+	if len(missing) > 0 {
+		return "", fmt.Errorf("%w: %s (write NAME=value lines to vars)", ErrMissingVars, strings.Join(missing, ", "))
+	}
+	return placeholderRe.ReplaceAllStringFunc(src, func(m string) string {
+		return vars[m[2:len(m)-1]]
+	}), nil
+}
+
+// ParseVars parses a vars file of NAME=value lines. Blank lines and lines
+// starting with # are skipped; values are trimmed of surrounding spaces.
+func ParseVars(data []byte) (map[string]string, error) {
+	vars := map[string]string{}
+	scanner := bufio.NewScanner(bytes.NewReader(data))
+	for n := 1; scanner.Scan(); n++ {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		name, value, ok := strings.Cut(line, "=")
+		name = strings.TrimSpace(name)
+		if !ok || !nameRe.MatchString(name) {
+			return nil, fmt.Errorf("vars line %d: want NAME=value, got %q: %w", n, line, fs.ErrInvalid)
+		}
+		vars[name] = strings.TrimSpace(value)
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+	return vars, nil
+}
@@ -0,0 +1,61 @@
+package templates
+
+import (
+	"errors"
+	"io/fs"
+	"slices"
+	"strings"
+	"testing"
+)
+
+func TestCatalog(t *testing.T) {
+	for _, want := range [][2]string{{"errors", "errors-by-service"}, {"latency", "p99-latency"}, {"product", "funnel"}} {
+		if _, ok := Lookup(want[0], want[1]); !ok {
+			t.Errorf("missing template %s/%s", want[0], want[1])
+		}
+	}
+	if got := Categories(); !slices.IsSorted(got) || len(got) < 3 {
+		t.Errorf("Categories() = %v", got)
+	}
+	for _, tmpl := range All() {
+		var documented []string
+		for _, v := range tmpl.Vars {
+			documented = append(documented, v.Name)
+		}
+		if got := Placeholders(tmpl.Source); !slices.Equal(got, documented) {
+			t.Errorf("%s/%s: placeholders %v, documented %v", tmpl.Category, tmpl.Name, got, documented)
+		}
+	}
+}
+
+func TestFile(t *testing.T) {
+	tmpl, _ := Lookup("errors", "errors-by-service")
+	file := string(tmpl.File())
+	for _, want := range []string{"// HTTP 5xx", "//   DATASET=http-logs", "// FIELD    field naming the service", "['${DATASET}']"} {
+		if !strings.Contains(file, want) {
+			t.Errorf("file missing %q:\n%s", want, file)
+		}
+	}
+}
+
+func TestRender(t *testing.T) {
+	vars, err := ParseVars([]byte("# fill in\nDATASET = logs\n\nFIELD=service\n"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	got, err := Render("['${DATASET}'] | summarize count() by ${FIELD}, ${FIELD}", vars)
+	if err != nil || got != "['logs'] | summarize count() by service, service" {
+		t.Errorf("Render = %q, %v", got, err)
+	}
+
+	_, err = Render("['${DATASET}'] | where ${A} == ${B}", vars)
+	if !errors.Is(err, ErrMissingVars) || !errors.Is(err, fs.ErrInvalid) || !strings.Contains(err.Error(), "A, B") {
+		t.Errorf("missing vars: err = %v", err)
+	}
+
+	for _, bad := range []string{"DATASET", "lower=x", "=x"} {
+		if _, err := ParseVars([]byte(bad)); !errors.Is(err, fs.ErrInvalid) {
+			t.Errorf("ParseVars(%q): err = %v", bad, err)
+		}
+	}
+}
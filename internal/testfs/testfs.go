@@ -0,0 +1,215 @@
+// Package testfs renders the virtual tree into text for golden file tests:
+// every path a client walking the mount would see, with its mode and size,
+// and the contents of chosen files. The tree is served from a fixed set of
+// datasets by a mocked client and executor, so the rendering only changes
+// when the tree does.
+//
+// Run `go test ./internal/testfs -update` to rewrite the golden files after
+// an intended change, and review the diff.
+package testfs
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/axiomhq/axiom-fs/internal/axiomclient"
+	"github.com/axiomhq/axiom-fs/internal/config"
+	"github.com/axiomhq/axiom-fs/internal/query"
+	"github.com/axiomhq/axiom-fs/internal/vfs"
+)
+
+var update = flag.Bool("update", false, "rewrite golden files instead of comparing against them")
+
+// Datasets are the datasets NewRoot serves.
+var Datasets = []axiomclient.Dataset{
+	{ID: "logs", Name: "logs", Description: "application logs"},
+	{ID: "traces", Name: "traces", Description: "request spans"},
+}
+
+// Fields are the fields of every dataset NewRoot serves.
+var Fields = []axiomclient.Field{
+	{Name: "_time", Type: "datetime"},
+	{Name: "duration", Type: "float"},
+	{Name: "message", Type: "string"},
+	{Name: "service.name", Type: "string"},
+	{Name: "status", Type: "integer"},
+}
+
+// NewRoot returns a tree over Datasets, with its caches and stores in
+// temporary directories and its queries answered by Executor.
+func NewRoot(t testing.TB, opts ...vfs.Option) *vfs.Root {
+	t.Helper()
+	cfg := config.Default()
+	cfg.CacheDir = t.TempDir()
+	cfg.QueryDir = t.TempDir()
+	cfg.SnippetDir = t.TempDir()
+	return vfs.NewRoot(cfg, Client{}, Executor{}, opts...)
+}
+
+// Options choose what Render includes.
+type Options struct {
+	// Skip are path.Match patterns of directories whose entries are not
+	// rendered, such as "/*/q".
+	Skip []string
+	// Contents are path.Match patterns of files whose contents are
+	// rendered below them.
+	Contents []string
+}
+
+// Render walks the tree from start and renders one line per node: its
+// path, mode and size. Sizes of generated files, only known once they are
+// read, are rendered as "-". Failures are rendered in place of the node
+// rather than stopping the walk.
+func Render(ctx context.Context, start vfs.Node, opts Options) (string, error) {
+	var b strings.Builder
+	err := vfs.Walk(ctx, start, func(name string, node vfs.Node, info os.FileInfo, err error) error {
+		if err != nil {
+			fmt.Fprintf(&b, "%s error: %v\n", name, err)
+			return nil
+		}
+		size := fmt.Sprint(info.Size())
+		if info.IsDir() || vfs.IsDynamic(info) {
+			size = "-"
+		}
+		fmt.Fprintf(&b, "%s %v %s\n", name, info.Mode(), size)
+		if info.IsDir() && matchAny(opts.Skip, name) {
+			return fs.SkipDir
+		}
+		if file, ok := node.(vfs.File); ok && matchAny(opts.Contents, name) {
+			data, err := read(ctx, file)
+			if err != nil {
+				fmt.Fprintf(&b, "  error: %v\n", err)
+				return nil
+			}
+			for _, line := range strings.SplitAfter(strings.TrimSuffix(string(data), "\n"), "\n") {
+				fmt.Fprintf(&b, "  | %s\n", strings.TrimSuffix(line, "\n"))
+			}
+		}
+		return nil
+	})
+	return b.String(), err
+}
+
+func matchAny(patterns []string, name string) bool {
+	for _, pattern := range patterns {
+		if ok, _ := path.Match(pattern, name); ok {
+			return true
+		}
+	}
+	return false
+}
+
+func read(ctx context.Context, file vfs.File) ([]byte, error) {
+	f, err := file.Open(ctx, os.O_RDONLY)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	return io.ReadAll(f)
+}
+
+// Golden compares got with the golden file name in testdata, or rewrites
+// it when the tests run with -update.
+func Golden(t testing.TB, name, got string) {
+	t.Helper()
+	file := filepath.Join("testdata", name)
+	if *update {
+		if err := os.MkdirAll("testdata", 0o755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(file, []byte(got), 0o644); err != nil {
+			t.Fatal(err)
+		}
+		return
+	}
+	want, err := os.ReadFile(file)
+	if err != nil {
+		t.Fatalf("%v (run go test -update to create it)", err)
+	}
+	if got == string(want) {
+		return
+	}
+	gotLines, wantLines := strings.Split(got, "\n"), strings.Split(string(want), "\n")
+	for i := 0; i < len(gotLines) || i < len(wantLines); i++ {
+		var g, w string
+		if i < len(gotLines) {
+			g = gotLines[i]
+		}
+		if i < len(wantLines) {
+			w = wantLines[i]
+		}
+		if g != w {
+			t.Fatalf("%s differs from the rendered tree at line %d:\n got: %s\nwant: %s\n(run go test -update to accept the change)", file, i+1, g, w)
+			return
+		}
+	}
+}
+
+// Client is the Axiom client NewRoot uses: Datasets, each with Fields, and
+// queries that return no rows.
+type Client struct{}
+
+func (Client) CurrentUser(ctx context.Context) (*axiomclient.User, error) {
+	return &axiomclient.User{ID: "user-1", Name: "Test User", Email: "test@example.com"}, nil
+}
+
+func (Client) ListDatasets(ctx context.Context) ([]axiomclient.Dataset, error) {
+	return Datasets, nil
+}
+
+func (Client) ListFields(ctx context.Context, datasetID string) ([]axiomclient.Field, error) {
+	return Fields, nil
+}
+
+func (Client) DatasetStats(ctx context.Context) ([]axiomclient.DatasetStats, error) {
+	return nil, nil
+}
+
+func (Client) QueryAPL(ctx context.Context, apl string) (*axiomclient.QueryResult, error) {
+	return &axiomclient.QueryResult{}, nil
+}
+
+// Executor is the executor NewRoot uses. Results echo the APL and format
+// they were asked for, so a rendering shows what each file queries.
+type Executor struct{}
+
+func result(apl, format string) []byte {
+	return []byte(fmt.Sprintf("%s: %s\n", format, apl))
+}
+
+func (Executor) ExecuteAPL(ctx context.Context, apl, format string, opts query.ExecOptions) ([]byte, error) {
+	return result(apl, format), nil
+}
+
+func (Executor) ExecuteAPLResult(ctx context.Context, apl, format string, opts query.ExecOptions) (query.ResultData, error) {
+	data := result(apl, format)
+	return query.ResultData{Bytes: data, Size: int64(len(data))}, nil
+}
+
+func (Executor) QueryAPL(ctx context.Context, apl string, opts query.ExecOptions) (*axiomclient.QueryResult, error) {
+	return &axiomclient.QueryResult{}, nil
+}
+
+func (Executor) ResultMeta(ctx context.Context, apl, format string, opts query.ExecOptions) (query.ResultMeta, error) {
+	return query.ResultMeta{APL: apl, Format: format, Rows: 0, Bytes: int64(len(result(apl, format)))}, nil
+}
+
+func (Executor) EstimateResult(ctx context.Context, apl, format string, opts query.ExecOptions) (query.ResultEstimate, error) {
+	return query.ResultEstimate{Size: int64(len(result(apl, format)))}, nil
+}
+
+func (Executor) ResultCount(ctx context.Context, apl string, opts query.ExecOptions) (int64, error) {
+	return 0, nil
+}
+
+func (Executor) ResultStats(ctx context.Context, apl, format string, opts query.ExecOptions) ([]byte, error) {
+	return []byte("column,type,count,nulls,distinct,min,max,avg\n"), nil
+}
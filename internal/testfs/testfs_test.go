@@ -0,0 +1,94 @@
+package testfs
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"testing"
+
+	"github.com/axiomhq/axiom-fs/internal/vfs"
+)
+
+// lookup resolves p, a path on the mount, from root.
+func lookup(t *testing.T, root vfs.Node, p string) vfs.Node {
+	t.Helper()
+	node := root
+	for _, name := range strings.Split(strings.Trim(p, "/"), "/") {
+		dir, ok := node.(vfs.Dir)
+		if !ok {
+			t.Fatalf("lookup %s: %s is not a directory", p, name)
+		}
+		next, err := dir.Lookup(context.Background(), name)
+		if err != nil {
+			t.Fatalf("lookup %s: %v", p, err)
+		}
+		node = next
+	}
+	return node
+}
+
+func TestTree(t *testing.T) {
+	got, err := Render(context.Background(), NewRoot(t), Options{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	Golden(t, "tree.golden", got)
+}
+
+func TestContents(t *testing.T) {
+	got, err := Render(context.Background(), NewRoot(t), Options{
+		Skip: []string{"/datasets", "/traces", "/_templates", "/_meta", "/_status", "/_events", "/logs/fields"},
+		Contents: []string{
+			"/README.txt",
+			"/_aliases.json",
+			"/_presets/errors.json",
+			"/examples/quickstart.txt",
+			"/logs/README.md",
+			"/logs/duckdb.sql",
+			"/logs/presets/errors.csv",
+			"/logs/schema.csv",
+		},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	Golden(t, "contents.golden", got)
+}
+
+func TestQueryPath(t *testing.T) {
+	// Query directories list nothing, so each file is looked up by name.
+	const dir = "/logs/q/range/ago/1h/where/status>=500/summarize/count()/by/service.name"
+	root := NewRoot(t)
+	var b strings.Builder
+	for _, name := range []string{"apl.txt", "result.csv", "result.ndjson", "result.count"} {
+		got, err := Render(context.Background(), lookup(t, root, dir+"/"+name), Options{Contents: []string{"/"}})
+		if err != nil {
+			t.Fatal(err)
+		}
+		b.WriteString(strings.Replace(got, "/", dir+"/"+name, 1))
+	}
+	Golden(t, "querypath.golden", b.String())
+}
+
+func TestGolden(t *testing.T) {
+	if *update {
+		t.Skip("golden files are being rewritten")
+	}
+	ft := &fakeT{TB: t}
+	Golden(ft, "tree.golden", "/ dr-xr-xr-x -\n/changed\n")
+	if !strings.Contains(ft.msg, "line 2") {
+		t.Errorf("Golden reported %q, want the first differing line", ft.msg)
+	}
+}
+
+// fakeT records the failure Golden reports instead of failing the test.
+type fakeT struct {
+	testing.TB
+	msg string
+}
+
+func (f *fakeT) Helper() {}
+
+func (f *fakeT) Fatalf(format string, args ...any) {
+	f.msg = fmt.Sprintf(format, args...)
+}
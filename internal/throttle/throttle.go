@@ -0,0 +1,137 @@
+// Package throttle limits how fast files are read, with a token bucket per
+// handle, so one client's large export cannot monopolize the server.
+package throttle
+
+import (
+	"context"
+	"sync"
+	"time"
+)
+
+// idleBucket is how long an unused bucket is kept. NFS has no open or
+// close, so a handle's bucket outlives any single read.
+const idleBucket = time.Minute
+
+// Stats is the limiter state exposed in /_status/throttle.json.
+type Stats struct {
+	// BytesPerSecond is the per-handle limit; zero means unlimited.
+	BytesPerSecond int64 `json:"bytes_per_second"`
+	// Handles counts the handles read within the last minute.
+	Handles int `json:"handles"`
+	// ThrottledReads and ThrottledBytes count the reads that had to wait
+	// and the bytes they returned; WaitSeconds is the total time waited.
+	ThrottledReads int64   `json:"throttled_reads"`
+	ThrottledBytes int64   `json:"throttled_bytes"`
+	WaitSeconds    float64 `json:"wait_seconds"`
+}
+
+// Limiter paces reads per handle. A nil Limiter, or one with a zero rate,
+// never waits.
+type Limiter struct {
+	rate int64
+
+	mu      sync.Mutex
+	buckets map[string]*bucket
+	swept   time.Time
+	reads   int64
+	bytes   int64
+	waited  time.Duration
+}
+
+// bucket holds up to one second of reads. Tokens go negative when a read
+// is larger than what is left, and the reader waits the debt off.
+type bucket struct {
+	tokens float64
+	last   time.Time
+}
+
+// New returns a limiter allowing bytesPerSecond on each handle.
+func New(bytesPerSecond int64) *Limiter {
+	return &Limiter{rate: bytesPerSecond, buckets: map[string]*bucket{}}
+}
+
+// Enabled reports whether l limits reads at all.
+func (l *Limiter) Enabled() bool {
+	return l != nil && l.rate > 0
+}
+
+// Wait accounts n bytes read from handle and blocks until the handle is
+// back within its rate, or ctx is done.
+func (l *Limiter) Wait(ctx context.Context, handle string, n int) error {
+	if !l.Enabled() || n <= 0 {
+		return nil
+	}
+	delay := l.reserve(handle, n, time.Now())
+	if delay <= 0 {
+		return nil
+	}
+	timer := time.NewTimer(delay)
+	defer timer.Stop()
+	select {
+	case <-timer.C:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// reserve takes n tokens from handle's bucket and returns how long the
+// reader must wait for them.
+func (l *Limiter) reserve(handle string, n int, now time.Time) time.Duration {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.sweep(now)
+	rate := float64(l.rate)
+	b, ok := l.buckets[handle]
+	if !ok {
+		b = &bucket{tokens: rate, last: now}
+		l.buckets[handle] = b
+	}
+	b.tokens = min(rate, b.tokens+now.Sub(b.last).Seconds()*rate)
+	b.last = now
+	b.tokens -= float64(n)
+	if b.tokens >= 0 {
+		return 0
+	}
+	delay := time.Duration(-b.tokens / rate * float64(time.Second))
+	l.reads++
+	l.bytes += int64(n)
+	l.waited += delay
+	return delay
+}
+
+// sweep drops buckets idle for idleBucket, at most once per idleBucket.
+func (l *Limiter) sweep(now time.Time) {
+	if now.Sub(l.swept) < idleBucket {
+		return
+	}
+	l.swept = now
+	for handle, b := range l.buckets {
+		if now.Sub(b.last) > idleBucket {
+			delete(l.buckets, handle)
+		}
+	}
+}
+
+// Stats reports the limit and what has been throttled so far.
+func (l *Limiter) Stats() Stats {
+	if l == nil {
+		return Stats{}
+	}
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	handles := 0
+	now := time.Now()
+	for _, b := range l.buckets {
+		if now.Sub(b.last) <= idleBucket {
+			handles++
+		}
+	}
+	return Stats{
+		BytesPerSecond: l.rate,
+		Handles:        handles,
+		ThrottledReads: l.reads,
+		ThrottledBytes: l.bytes,
+		WaitSeconds:    l.waited.Seconds(),
+	}
+}
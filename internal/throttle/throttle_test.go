@@ -0,0 +1,60 @@
+package throttle
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+)
+
+func TestLimiterReserve(t *testing.T) {
+	l := New(1000)
+	now := time.Now()
+
+	if d := l.reserve("a", 1000, now); d != 0 {
+		t.Errorf("first second of reads waited %v", d)
+	}
+	if d := l.reserve("a", 500, now); d != 500*time.Millisecond {
+		t.Errorf("over budget wait = %v, want 500ms", d)
+	}
+	if d := l.reserve("b", 1000, now); d != 0 {
+		t.Errorf("another handle waited %v, want its own bucket", d)
+	}
+	// After the debt is paid off and another second passes, a full second of
+	// reads is allowed again.
+	if d := l.reserve("a", 1000, now.Add(1500*time.Millisecond)); d != 0 {
+		t.Errorf("refilled bucket waited %v", d)
+	}
+
+	stats := l.Stats()
+	if stats.ThrottledReads != 1 || stats.ThrottledBytes != 500 || stats.WaitSeconds != 0.5 {
+		t.Errorf("stats = %+v", stats)
+	}
+}
+
+func TestLimiterIdleBuckets(t *testing.T) {
+	l := New(1000)
+	now := time.Now()
+	l.reserve("a", 10, now)
+	l.reserve("b", 10, now.Add(2*idleBucket))
+	if len(l.buckets) != 1 {
+		t.Errorf("buckets = %d, want the idle one dropped", len(l.buckets))
+	}
+}
+
+func TestLimiterWait(t *testing.T) {
+	var nilLimiter *Limiter
+	if err := nilLimiter.Wait(context.Background(), "a", 1<<30); err != nil {
+		t.Errorf("nil limiter: %v", err)
+	}
+	if err := New(0).Wait(context.Background(), "a", 1<<30); err != nil {
+		t.Errorf("unlimited: %v", err)
+	}
+
+	l := New(1)
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	if err := l.Wait(ctx, "a", 100); !errors.Is(err, context.Canceled) {
+		t.Errorf("Wait on canceled ctx = %v", err)
+	}
+}
@@ -0,0 +1,85 @@
+// Package urlbuilder builds Axiom web UI links that open a query in the
+// query editor, e.g.
+//
+//	https://app.axiom.co/acme/query?initForm={"apl":"['logs'] | ...","queryOptions":{"quickRange":"1h"}}
+//
+// The time range is taken from the query's `_time between` filter so the
+// UI's time picker covers the same window as the file.
+package urlbuilder
+
+import (
+	"encoding/json"
+	"net/url"
+	"regexp"
+	"strings"
+)
+
+const defaultAppURL = "https://app.axiom.co"
+
+var (
+	agoRange      = regexp.MustCompile(`_time between \(ago\(([^)]+)\) \.\. now\(\)\)`)
+	absoluteRange = regexp.MustCompile(`_time between \(datetime\("?([^")]+)"?\) \.\. datetime\("?([^")]+)"?\)\)`)
+)
+
+// Builder turns APL into web UI links for one Axiom deployment and org.
+type Builder struct {
+	appURL string
+	orgID  string
+}
+
+// New returns a Builder for the UI at appURL. When appURL is empty it is
+// derived from the API base URL: api.<domain> becomes app.<domain>, and any
+// other host (self-hosted Axiom) is assumed to serve the UI itself.
+func New(apiURL, appURL, orgID string) *Builder {
+	if appURL == "" {
+		appURL = deriveAppURL(apiURL)
+	}
+	return &Builder{appURL: strings.TrimRight(appURL, "/"), orgID: orgID}
+}
+
+func deriveAppURL(apiURL string) string {
+	if apiURL == "" {
+		return defaultAppURL
+	}
+	u, err := url.Parse(apiURL)
+	if err != nil || u.Host == "" {
+		return defaultAppURL
+	}
+	if rest, ok := strings.CutPrefix(u.Host, "api."); ok {
+		u.Host = "app." + rest
+	}
+	return u.Scheme + "://" + u.Host
+}
+
+type initForm struct {
+	APL          string       `json:"apl"`
+	QueryOptions queryOptions `json:"queryOptions,omitzero"`
+}
+
+type queryOptions struct {
+	QuickRange string `json:"quickRange,omitempty"`
+	StartTime  string `json:"startTime,omitempty"`
+	EndTime    string `json:"endTime,omitempty"`
+}
+
+// Query returns a link opening apl in the query editor.
+func (b *Builder) Query(apl string) string {
+	form := initForm{APL: apl}
+	if m := agoRange.FindStringSubmatch(apl); m != nil {
+		form.QueryOptions.QuickRange = m[1]
+	} else if m := absoluteRange.FindStringSubmatch(apl); m != nil {
+		form.QueryOptions.StartTime, form.QueryOptions.EndTime = m[1], m[2]
+	}
+	// Keep <, > and & readable in the APL; url.Values escapes them anyway.
+	var buf strings.Builder
+	enc := json.NewEncoder(&buf)
+	enc.SetEscapeHTML(false)
+	_ = enc.Encode(form)
+	data := strings.TrimSuffix(buf.String(), "\n")
+
+	path := "/query"
+	if b.orgID != "" {
+		path = "/" + url.PathEscape(b.orgID) + path
+	}
+	return b.appURL + path + "?" + url.Values{"initForm": {data}}.Encode()
+}
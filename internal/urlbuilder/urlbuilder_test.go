@@ -0,0 +1,67 @@
+package urlbuilder
+
+import (
+	"encoding/json"
+	"net/url"
+	"strings"
+	"testing"
+)
+
+func decodeForm(t *testing.T, link string) (*url.URL, initForm) {
+	t.Helper()
+	u, err := url.Parse(link)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var form initForm
+	if err := json.Unmarshal([]byte(u.Query().Get("initForm")), &form); err != nil {
+		t.Fatalf("initForm: %v (%s)", err, link)
+	}
+	return u, form
+}
+
+func TestAppURL(t *testing.T) {
+	tests := []struct {
+		apiURL, appURL, want string
+	}{
+		{"", "", "https://app.axiom.co"},
+		{"https://api.axiom.co", "", "https://app.axiom.co"},
+		{"https://api.eu.axiom.co/", "", "https://app.eu.axiom.co"},
+		{"https://axiom.internal:8443/api", "", "https://axiom.internal:8443"},
+		{"https://api.axiom.co", "https://ui.example.com/", "https://ui.example.com"},
+	}
+	for _, tt := range tests {
+		link := New(tt.apiURL, tt.appURL, "acme").Query("['logs']")
+		if !strings.HasPrefix(link, tt.want+"/acme/query?initForm=") {
+			t.Errorf("New(%q, %q): link %s, want prefix %s", tt.apiURL, tt.appURL, link, tt.want)
+		}
+	}
+}
+
+func TestQuery(t *testing.T) {
+	b := New("", "", "acme")
+
+	apl := "['logs'] | where _time between (ago(6h) .. now()) | where status >= 500 | take 100"
+	u, form := decodeForm(t, b.Query(apl))
+	if u.Path != "/acme/query" {
+		t.Errorf("path = %s", u.Path)
+	}
+	if form.APL != apl || form.QueryOptions.QuickRange != "6h" {
+		t.Errorf("form = %+v", form)
+	}
+
+	apl = `['logs'] | where _time between (datetime("2025-01-01T00:00:00Z") .. datetime("2025-01-02T00:00:00Z"))`
+	_, form = decodeForm(t, b.Query(apl))
+	if form.QueryOptions.StartTime != "2025-01-01T00:00:00Z" || form.QueryOptions.EndTime != "2025-01-02T00:00:00Z" {
+		t.Errorf("absolute range = %+v", form.QueryOptions)
+	}
+
+	link := New("", "", "").Query("['logs'] | count")
+	u, form = decodeForm(t, link)
+	if u.Path != "/query" || form.QueryOptions != (queryOptions{}) {
+		t.Errorf("link without org or range = %s", link)
+	}
+	if strings.Contains(link, "queryOptions") {
+		t.Errorf("empty queryOptions should be omitted: %s", link)
+	}
+}
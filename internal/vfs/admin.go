@@ -0,0 +1,255 @@
+package vfs
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"crypto/subtle"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"sync"
+
+	"github.com/go-git/go-billy/v5"
+)
+
+// AdminDir is the mount-relative directory of the admin control files. It
+// only exists with -enable-admin-files.
+const AdminDir = "_admin"
+
+// cachePurger is implemented by executors that can drop their cache.
+type cachePurger interface {
+	PurgeCache() int
+}
+
+// queryCanceler is implemented by executors that can cancel a running
+// query by the ID /_status/inflight.json lists.
+type queryCanceler interface {
+	CancelQuery(id string) error
+}
+
+// adminAction is a destructive control file under /_admin. Writing the
+// token from its paired <name>.confirm file runs it; anything else is
+// refused, so a stray echo or rm cannot trigger it. Actions taking an
+// argument read it after the token.
+type adminAction struct {
+	name string
+	help string
+	// arg names the argument the action takes; empty takes none.
+	arg string
+	run func(ctx context.Context, arg string) error
+}
+
+// adminActions returns the control files this mount supports.
+func (r *Root) adminActions() []adminAction {
+	var actions []adminAction
+	if purger, ok := r.Executor().(cachePurger); ok {
+		actions = append(actions, adminAction{
+			name: "cache.purge",
+			help: "drops every cached result and its metadata from memory and disk",
+			run: func(ctx context.Context, arg string) error {
+				_ = purger.PurgeCache()
+				return nil
+			},
+		})
+	}
+	if canceler, ok := r.Executor().(queryCanceler); ok {
+		actions = append(actions, adminAction{
+			name: "query.cancel",
+			help: "cancels a running query by its id in /_status/inflight.json",
+			arg:  "<id>",
+			run: func(ctx context.Context, id string) error {
+				return canceler.CancelQuery(id)
+			},
+		})
+	}
+	return actions
+}
+
+func (r *Root) adminAction(name string) (adminAction, bool) {
+	for _, action := range r.adminActions() {
+		if action.name == name {
+			return action, true
+		}
+	}
+	return adminAction{}, false
+}
+
+// confirmTokens holds the current confirmation token of each admin action.
+// A token is replaced once it has been used.
+type confirmTokens struct {
+	mu     sync.Mutex
+	tokens map[string]string
+}
+
+func (c *confirmTokens) get(name string) string {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.tokens == nil {
+		c.tokens = map[string]string{}
+	}
+	if _, ok := c.tokens[name]; !ok {
+		c.tokens[name] = newConfirmToken()
+	}
+	return c.tokens[name]
+}
+
+// consume reports whether given is name's current token and, if so,
+// replaces it.
+func (c *confirmTokens) consume(name, given string) bool {
+	want := c.get(name)
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if subtle.ConstantTimeCompare([]byte(strings.TrimSpace(given)), []byte(want)) != 1 {
+		return false
+	}
+	c.tokens[name] = newConfirmToken()
+	return true
+}
+
+func newConfirmToken() string {
+	b := make([]byte, 8)
+	_, _ = rand.Read(b)
+	return hex.EncodeToString(b)
+}
+
+// AdminFilesDir is /_admin: each action as a writable file next to its
+// read-only <name>.confirm token.
+type AdminFilesDir struct {
+	root *Root
+}
+
+func (a *AdminFilesDir) Stat(ctx context.Context) (os.FileInfo, error) {
+	return DirInfo(AdminDir), nil
+}
+
+func (a *AdminFilesDir) ReadDir(ctx context.Context) ([]os.FileInfo, error) {
+	actions := a.root.adminActions()
+	entries := make([]os.FileInfo, 0, 2*len(actions))
+	for _, action := range actions {
+		entries = append(entries, WritableFileInfo(action.name, 0), FileInfo(action.name+".confirm", 0))
+	}
+	return entries, nil
+}
+
+func (a *AdminFilesDir) Lookup(ctx context.Context, name string) (Node, error) {
+	if base, ok := strings.CutSuffix(name, ".confirm"); ok {
+		if _, ok := a.root.adminAction(base); !ok {
+			return nil, os.ErrNotExist
+		}
+		return &ConfirmFile{root: a.root, name: base}, nil
+	}
+	action, ok := a.root.adminAction(name)
+	if !ok {
+		return nil, os.ErrNotExist
+	}
+	return &AdminFile{root: a.root, action: action}, nil
+}
+
+// ConfirmFile shows the token that must be written to its admin file.
+type ConfirmFile struct {
+	root *Root
+	name string
+}
+
+func (c *ConfirmFile) Stat(ctx context.Context) (os.FileInfo, error) {
+	return DynamicFileInfo(c.name + ".confirm"), nil
+}
+
+func (c *ConfirmFile) Open(ctx context.Context, flags int) (billy.File, error) {
+	return newBytesFile([]byte(c.root.fsys.confirm.get(c.name) + "\n")), nil
+}
+
+// AdminFile runs its action when the current confirmation token is written
+// to it. Reading it explains what it does.
+type AdminFile struct {
+	root   *Root
+	action adminAction
+}
+
+func (a *AdminFile) Stat(ctx context.Context) (os.FileInfo, error) {
+	return WritableFileInfo(a.action.name, 0), nil
+}
+
+func (a *AdminFile) Open(ctx context.Context, flags int) (billy.File, error) {
+	usage := fmt.Sprintf("cat %s.confirm > %s", a.action.name, a.action.name)
+	if a.action.arg != "" {
+		usage = fmt.Sprintf("echo \"$(cat %s.confirm) %s\" > %s", a.action.name, a.action.arg, a.action.name)
+	}
+	text := fmt.Sprintf("%s %s.\nTo run it: %s\n", a.action.name, a.action.help, usage)
+	return newBytesFile([]byte(text)), nil
+}
+
+func (a *AdminFile) Create(ctx context.Context) (billy.File, error) {
+	return &lineWriteFile{name: a.action.name, run: a.run}, nil
+}
+
+func (a *AdminFile) run(ctx context.Context, written []byte) error {
+	token, arg, _ := strings.Cut(strings.TrimSpace(string(written)), " ")
+	if !a.root.fsys.confirm.consume(a.action.name, token) {
+		return fmt.Errorf("%w: %s needs the token from %s.confirm", os.ErrPermission, a.action.name, a.action.name)
+	}
+	arg = strings.TrimSpace(arg)
+	if (arg == "") != (a.action.arg == "") {
+		if a.action.arg == "" {
+			return fmt.Errorf("%w: %s takes only the token", os.ErrInvalid, a.action.name)
+		}
+		return fmt.Errorf("%w: %s takes the token followed by %s", os.ErrInvalid, a.action.name, a.action.arg)
+	}
+	return a.action.run(ctx, arg)
+}
+
+// lineWriteFile collects a short write, such as a token or a URI, and hands
+// it to run on the first Close after a write.
+type lineWriteFile struct {
+	name string
+	run  func(ctx context.Context, written []byte) error
+	// limit, when set, lifts the 1024-byte cap for files taking a document
+	// rather than a line.
+	limit  int
+	buf    bytes.Buffer
+	closed bool
+}
+
+func (f *lineWriteFile) Name() string { return f.name }
+func (f *lineWriteFile) Size() int64  { return 0 }
+
+func (f *lineWriteFile) Read(p []byte) (int, error) {
+	return 0, io.EOF
+}
+
+func (f *lineWriteFile) ReadAt(p []byte, off int64) (int, error) {
+	return 0, io.EOF
+}
+
+func (f *lineWriteFile) Seek(offset int64, whence int) (int64, error) {
+	return 0, nil
+}
+
+func (f *lineWriteFile) Write(p []byte) (int, error) {
+	// What is written is short; refuse to buffer anything much longer.
+	if f.limit > 0 && f.buf.Len()+len(p) > f.limit {
+		return 0, fmt.Errorf("%w: %s takes at most %d bytes", os.ErrInvalid, f.name, f.limit)
+	}
+	if f.limit == 0 && f.buf.Len()+len(p) > 1024 {
+		return 0, fmt.Errorf("%w: %s takes a single short line", os.ErrInvalid, f.name)
+	}
+	return f.buf.Write(p)
+}
+
+func (f *lineWriteFile) Close() error {
+	if f.buf.Len() == 0 || f.closed {
+		return nil
+	}
+	f.closed = true
+	return f.run(context.Background(), f.buf.Bytes())
+}
+
+func (f *lineWriteFile) Lock() error   { return nil }
+func (f *lineWriteFile) Unlock() error { return nil }
+func (f *lineWriteFile) Truncate(size int64) error {
+	f.buf.Truncate(min(int(size), f.buf.Len()))
+	return nil
+}
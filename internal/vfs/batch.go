@@ -0,0 +1,370 @@
+package vfs
+
+import (
+	"bytes"
+	"context"
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"strconv"
+	"sync"
+	"syscall"
+	"time"
+
+	"github.com/go-git/go-billy/v5"
+
+	"github.com/axiomhq/axiom-fs/internal/compiler"
+	"github.com/axiomhq/axiom-fs/internal/query"
+)
+
+// maxBatchQueries caps the entries of one queries.json, and
+// maxBatchQueriesBytes its size.
+const (
+	maxBatchQueries      = 1000
+	maxBatchQueriesBytes = 1 << 20
+)
+
+// batchQuery is one entry of a batch's queries.json.
+type batchQuery struct {
+	APL string `json:"apl"`
+	// Format is a result format or alias, as in result.<format>; ndjson
+	// when empty.
+	Format string `json:"format,omitempty"`
+}
+
+// batchEntry is one query's line in summary.csv.
+type batchEntry struct {
+	Format  string
+	File    string
+	State   string
+	Rows    int64
+	Bytes   int64
+	Seconds float64
+	Error   string
+}
+
+// Batch entry states.
+const (
+	batchPending = "pending"
+	batchRunning = "running"
+	batchDone    = "done"
+	batchFailed  = "failed"
+)
+
+// batchRun is the latest run of a batch.
+type batchRun struct {
+	entries  []batchEntry
+	finished bool
+}
+
+// batchTracker holds the latest run of each batch.
+type batchTracker struct {
+	mu   sync.Mutex
+	runs map[string]*batchRun
+}
+
+// start records a new run of name, unless one is still running.
+func (t *batchTracker) start(name string, run *batchRun) error {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if prev, ok := t.runs[name]; ok && !prev.finished {
+		return fmt.Errorf("batch %s is still running: %w", name, syscall.EAGAIN)
+	}
+	if t.runs == nil {
+		t.runs = map[string]*batchRun{}
+	}
+	t.runs[name] = run
+	return nil
+}
+
+func (t *batchTracker) update(fn func()) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	fn()
+}
+
+// summary renders name's run as summary.csv, false when this process has
+// not run it.
+func (t *batchTracker) summary(name string) ([]byte, bool) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	run, ok := t.runs[name]
+	if !ok {
+		return nil, false
+	}
+	return batchSummary(run.entries), true
+}
+
+func batchSummary(entries []batchEntry) []byte {
+	var buf bytes.Buffer
+	w := csv.NewWriter(&buf)
+	_ = w.Write([]string{"index", "file", "format", "status", "rows", "bytes", "seconds", "error"})
+	for i, e := range entries {
+		_ = w.Write([]string{
+			strconv.Itoa(i),
+			e.File,
+			e.Format,
+			e.State,
+			strconv.FormatInt(e.Rows, 10),
+			strconv.FormatInt(e.Bytes, 10),
+			strconv.FormatFloat(e.Seconds, 'f', 3, 64),
+			e.Error,
+		})
+	}
+	w.Flush()
+	return buf.Bytes()
+}
+
+// parseBatchQueries reads a queries.json, resolving each entry's format.
+func parseBatchQueries(data []byte) ([]batchQuery, error) {
+	var queries []batchQuery
+	if err := json.Unmarshal(data, &queries); err != nil {
+		return nil, fmt.Errorf("%w: queries.json: %v", os.ErrInvalid, err)
+	}
+	if len(queries) == 0 {
+		return nil, fmt.Errorf("%w: queries.json lists no queries", os.ErrInvalid)
+	}
+	if len(queries) > maxBatchQueries {
+		return nil, fmt.Errorf("%w: queries.json lists more than %d queries", os.ErrInvalid, maxBatchQueries)
+	}
+	for i, q := range queries {
+		if err := query.ValidateAPL(q.APL); err != nil {
+			return nil, fmt.Errorf("%w: queries.json entry %d: %v", os.ErrInvalid, i, err)
+		}
+		if q.Format == "" {
+			queries[i].Format = "ndjson"
+		} else if _, ok := compiler.ResultFormat(q.Format); !ok {
+			return nil, fmt.Errorf("%w: queries.json entry %d: unknown format %q", os.ErrInvalid, i, q.Format)
+		}
+	}
+	return queries, nil
+}
+
+// startBatch stores data as name's queries.json and runs it in the
+// background, at most -batch-concurrency queries at once.
+func (r *Root) startBatch(name string, data []byte) error {
+	queries, err := parseBatchQueries(data)
+	if err != nil {
+		return err
+	}
+	for i, q := range queries {
+		if err := r.checkAccess(q.APL); err != nil {
+			return fmt.Errorf("queries.json entry %d: %w", i, err)
+		}
+	}
+	run := &batchRun{entries: make([]batchEntry, len(queries))}
+	for i, q := range queries {
+		run.entries[i] = batchEntry{Format: q.Format, File: strconv.Itoa(i) + "." + q.Format, State: batchPending}
+	}
+	if err := r.fsys.batches.start(name, run); err != nil {
+		return err
+	}
+	if err := r.Batches().SetQueries(name, data); err != nil {
+		r.fsys.batches.update(func() { run.finished = true })
+		return err
+	}
+	go r.runBatch(name, queries, run)
+	return nil
+}
+
+func (r *Root) runBatch(name string, queries []batchQuery, run *batchRun) {
+	sem := make(chan struct{}, max(r.fsys.Config.BatchConcurrency, 1))
+	var wg sync.WaitGroup
+	for i, q := range queries {
+		wg.Add(1)
+		sem <- struct{}{}
+		go func() {
+			defer func() {
+				<-sem
+				wg.Done()
+			}()
+			r.runBatchQuery(context.Background(), name, i, q, run)
+		}()
+	}
+	wg.Wait()
+	var summary []byte
+	r.fsys.batches.update(func() {
+		run.finished = true
+		summary = batchSummary(run.entries)
+	})
+	_ = r.Batches().SetSummary(name, summary)
+}
+
+func (r *Root) runBatchQuery(ctx context.Context, name string, i int, q batchQuery, run *batchRun) {
+	entry := &run.entries[i]
+	r.fsys.batches.update(func() { entry.State = batchRunning })
+	start := time.Now()
+	rows, size, err := r.batchResult(ctx, name, i, q, entry.File)
+	r.fsys.batches.update(func() {
+		entry.Seconds = time.Since(start).Seconds()
+		entry.State, entry.Rows, entry.Bytes = batchDone, rows, size
+		if err != nil {
+			entry.State, entry.Error = batchFailed, err.Error()
+		}
+	})
+}
+
+func (r *Root) batchResult(ctx context.Context, name string, i int, q batchQuery, file string) (int64, int64, error) {
+	format, _ := compiler.ResultFormat(q.Format)
+	result, err := r.Executor().ExecuteAPLResult(ctx, q.APL, format, query.ExecOptions{UseCache: true, Headers: queryLabel("_batch", name, strconv.Itoa(i))})
+	if err != nil {
+		return 0, 0, err
+	}
+	defer result.Release()
+	var body io.Reader = bytes.NewReader(result.Bytes)
+	if result.File != nil {
+		body = result.File
+	}
+	size, err := r.Batches().SaveResult(name, file, body)
+	return result.Meta.Rows, size, err
+}
+
+// BatchDir is /_batch: one directory per query pack.
+type BatchDir struct {
+	root *Root
+}
+
+func (b *BatchDir) Stat(ctx context.Context) (os.FileInfo, error) {
+	return DirInfo("_batch"), nil
+}
+
+func (b *BatchDir) ReadDir(ctx context.Context) ([]os.FileInfo, error) {
+	names := b.root.Batches().Names()
+	entries := make([]os.FileInfo, 0, len(names))
+	for _, name := range names {
+		entries = append(entries, DirInfo(name))
+	}
+	return entries, nil
+}
+
+func (b *BatchDir) Lookup(ctx context.Context, name string) (Node, error) {
+	if !isValidQueryName(name) {
+		return nil, os.ErrNotExist
+	}
+	return &BatchEntryDir{root: b.root, name: name}, nil
+}
+
+// BatchEntryDir is /_batch/<name>/. Writing queries.json, a JSON list of
+// {"apl", "format"} entries, runs every query and fills results/ and
+// summary.csv.
+type BatchEntryDir struct {
+	root *Root
+	name string
+}
+
+func (b *BatchEntryDir) Stat(ctx context.Context) (os.FileInfo, error) {
+	return DirInfo(b.name), nil
+}
+
+func (b *BatchEntryDir) ReadDir(ctx context.Context) ([]os.FileInfo, error) {
+	return []os.FileInfo{
+		WritableFileInfo("queries.json", int64(len(b.root.Batches().Queries(b.name)))),
+		DirInfo("results"),
+		DynamicFileInfo("summary.csv"),
+	}, nil
+}
+
+func (b *BatchEntryDir) Lookup(ctx context.Context, name string) (Node, error) {
+	switch name {
+	case "queries.json":
+		return &BatchQueriesFile{root: b.root, name: b.name}, nil
+	case "results":
+		return &BatchResultsDir{root: b.root, name: b.name}, nil
+	case "summary.csv":
+		return &BatchSummaryFile{root: b.root, name: b.name}, nil
+	}
+	return nil, os.ErrNotExist
+}
+
+// BatchQueriesFile is a batch's queries.json; writing it starts a run, and
+// fails with EAGAIN while the previous one is still going.
+type BatchQueriesFile struct {
+	root *Root
+	name string
+}
+
+func (b *BatchQueriesFile) Stat(ctx context.Context) (os.FileInfo, error) {
+	return WritableFileInfo("queries.json", int64(len(b.root.Batches().Queries(b.name)))), nil
+}
+
+func (b *BatchQueriesFile) Open(ctx context.Context, flags int) (billy.File, error) {
+	return newBytesFile(b.root.Batches().Queries(b.name)), nil
+}
+
+func (b *BatchQueriesFile) Create(ctx context.Context) (billy.File, error) {
+	return &lineWriteFile{name: "queries.json", limit: maxBatchQueriesBytes, run: func(ctx context.Context, written []byte) error {
+		return b.root.startBatch(b.name, written)
+	}}, nil
+}
+
+// BatchResultsDir lists a batch's results/<index>.<format> files.
+type BatchResultsDir struct {
+	root *Root
+	name string
+}
+
+func (b *BatchResultsDir) Stat(ctx context.Context) (os.FileInfo, error) {
+	return DirInfo("results"), nil
+}
+
+func (b *BatchResultsDir) ReadDir(ctx context.Context) ([]os.FileInfo, error) {
+	files := b.root.Batches().Results(b.name)
+	entries := make([]os.FileInfo, 0, len(files))
+	for _, f := range files {
+		entries = append(entries, FileInfoAt(f.Name, f.Size, f.ModTime))
+	}
+	return entries, nil
+}
+
+func (b *BatchResultsDir) Lookup(ctx context.Context, name string) (Node, error) {
+	for _, f := range b.root.Batches().Results(b.name) {
+		if f.Name == name {
+			return &BatchResultFile{root: b.root, name: b.name, file: f.Name, size: f.Size, modTime: f.ModTime}, nil
+		}
+	}
+	return nil, os.ErrNotExist
+}
+
+type BatchResultFile struct {
+	root    *Root
+	name    string
+	file    string
+	size    int64
+	modTime time.Time
+}
+
+func (b *BatchResultFile) Stat(ctx context.Context) (os.FileInfo, error) {
+	return FileInfoAt(b.file, b.size, b.modTime), nil
+}
+
+func (b *BatchResultFile) Open(ctx context.Context, flags int) (billy.File, error) {
+	data, err := b.root.Batches().ReadResult(b.name, b.file)
+	if err != nil {
+		return nil, err
+	}
+	return &bytesFile{data: data, reader: bytes.NewReader(data), modTime: b.modTime}, nil
+}
+
+// BatchSummaryFile is a batch's summary.csv: each query's file, status,
+// rows, bytes and time, as the run goes.
+type BatchSummaryFile struct {
+	root *Root
+	name string
+}
+
+func (b *BatchSummaryFile) Stat(ctx context.Context) (os.FileInfo, error) {
+	return DynamicFileInfo("summary.csv"), nil
+}
+
+func (b *BatchSummaryFile) Open(ctx context.Context, flags int) (billy.File, error) {
+	if data, ok := b.root.fsys.batches.summary(b.name); ok {
+		return newBytesFile(data), nil
+	}
+	data, err := b.root.Batches().Summary(b.name)
+	if err != nil {
+		return nil, fmt.Errorf("%w: batch %s has not run", os.ErrNotExist, b.name)
+	}
+	return newBytesFile(data), nil
+}
@@ -0,0 +1,35 @@
+package vfs
+
+import (
+	"context"
+	"os"
+
+	"github.com/axiomhq/axiom-fs/internal/cache"
+)
+
+// diskUsageReporter is implemented by executors backed by a disk cache.
+type diskUsageReporter interface {
+	DiskUsage() cache.DiskUsage
+}
+
+// CacheInfoDir is /_cache, the state of the persistent cache.
+type CacheInfoDir struct {
+	reporter diskUsageReporter
+}
+
+func (c *CacheInfoDir) Stat(ctx context.Context) (os.FileInfo, error) {
+	return DirInfo("_cache"), nil
+}
+
+func (c *CacheInfoDir) ReadDir(ctx context.Context) ([]os.FileInfo, error) {
+	return []os.FileInfo{FileInfo("usage.json", 0)}, nil
+}
+
+func (c *CacheInfoDir) Lookup(ctx context.Context, name string) (Node, error) {
+	if name != "usage.json" {
+		return nil, os.ErrNotExist
+	}
+	return &StatusFile{name: name, build: func(ctx context.Context) (any, error) {
+		return c.reporter.DiskUsage(), nil
+	}}, nil
+}
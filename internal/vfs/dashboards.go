@@ -0,0 +1,329 @@
+package vfs
+
+import (
+	"context"
+	"net/http"
+	"os"
+	"regexp"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/go-git/go-billy/v5"
+	"golang.org/x/sync/singleflight"
+
+	"github.com/axiomhq/axiom-fs/internal/axiomclient"
+	"github.com/axiomhq/axiom-fs/internal/config"
+	"github.com/axiomhq/axiom-fs/internal/query"
+)
+
+// DashboardClient is implemented by clients that can read Axiom dashboards.
+// /_dashboards is only served when the client implements it.
+type DashboardClient interface {
+	ListDashboards(ctx context.Context) ([]axiomclient.Dashboard, error)
+	GetDashboard(ctx context.Context, id string) (*axiomclient.Dashboard, error)
+}
+
+func (r *Root) dashboardClient() (DashboardClient, bool) {
+	client, ok := r.fsys.Client.(DashboardClient)
+	return client, ok
+}
+
+// dashboardCache holds the dashboard list and fetched dashboards for
+// MetadataTTL, so path lookups under /_dashboards don't hit the API.
+type dashboardCache struct {
+	mu      sync.Mutex
+	ttl     time.Duration
+	fetched time.Time
+	list    []axiomclient.Dashboard
+	byID    map[string]cachedDashboard
+	sf      singleflight.Group
+}
+
+type cachedDashboard struct {
+	dashboard *axiomclient.Dashboard
+	fetched   time.Time
+}
+
+func (c *dashboardCache) List(ctx context.Context, client DashboardClient) ([]axiomclient.Dashboard, error) {
+	c.mu.Lock()
+	if c.list != nil && time.Since(c.fetched) < c.ttl {
+		list := c.list
+		c.mu.Unlock()
+		return list, nil
+	}
+	c.mu.Unlock()
+
+	result, err, _ := c.sf.Do("list", func() (any, error) {
+		list, err := client.ListDashboards(ctx)
+		if err != nil {
+			return nil, err
+		}
+		if list == nil {
+			list = []axiomclient.Dashboard{}
+		}
+		c.mu.Lock()
+		c.list, c.fetched = list, time.Now()
+		c.mu.Unlock()
+		return list, nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return result.([]axiomclient.Dashboard), nil
+}
+
+func (c *dashboardCache) Get(ctx context.Context, client DashboardClient, id string) (*axiomclient.Dashboard, error) {
+	c.mu.Lock()
+	if cached, ok := c.byID[id]; ok && time.Since(cached.fetched) < c.ttl {
+		c.mu.Unlock()
+		return cached.dashboard, nil
+	}
+	c.mu.Unlock()
+
+	result, err, _ := c.sf.Do("dashboard:"+id, func() (any, error) {
+		dashboard, err := client.GetDashboard(ctx, id)
+		if err != nil {
+			return nil, err
+		}
+		c.mu.Lock()
+		if c.byID == nil {
+			c.byID = map[string]cachedDashboard{}
+		}
+		c.byID[id] = cachedDashboard{dashboard: dashboard, fetched: time.Now()}
+		c.mu.Unlock()
+		return dashboard, nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return result.(*axiomclient.Dashboard), nil
+}
+
+// entryNames maps each item to a unique directory name: its display name
+// with slashes replaced, or its ID when the name is empty or taken.
+func entryNames(n int, name, id func(i int) string) []string {
+	names := make([]string, n)
+	used := map[string]bool{}
+	for i := range names {
+		entry := strings.ReplaceAll(strings.TrimSpace(name(i)), "/", "_")
+		if entry == "" || entry == "." || entry == ".." || used[entry] {
+			entry = strings.TrimSpace(entry + " " + id(i))
+		}
+		used[entry] = true
+		names[i] = entry
+	}
+	return names
+}
+
+func dashboardNames(list []axiomclient.Dashboard) []string {
+	return entryNames(len(list), func(i int) string { return list[i].Name }, func(i int) string { return list[i].ID })
+}
+
+func chartNames(charts []axiomclient.DashboardChart) []string {
+	return entryNames(len(charts), func(i int) string { return charts[i].Name }, func(i int) string { return charts[i].ID })
+}
+
+// DashboardsDir is /_dashboards, one directory per Axiom dashboard.
+type DashboardsDir struct {
+	root   *Root
+	client DashboardClient
+}
+
+func (d *DashboardsDir) Stat(ctx context.Context) (os.FileInfo, error) {
+	return DirInfo("_dashboards"), nil
+}
+
+func (d *DashboardsDir) ReadDir(ctx context.Context) ([]os.FileInfo, error) {
+	list, err := d.root.fsys.dashboards.List(ctx, d.client)
+	if err != nil {
+		return nil, err
+	}
+	names := dashboardNames(list)
+	entries := make([]os.FileInfo, 0, len(names))
+	for _, name := range names {
+		entries = append(entries, DirInfo(name))
+	}
+	sort.Slice(entries, func(i, j int) bool { return entries[i].Name() < entries[j].Name() })
+	return entries, nil
+}
+
+func (d *DashboardsDir) Lookup(ctx context.Context, name string) (Node, error) {
+	list, err := d.root.fsys.dashboards.List(ctx, d.client)
+	if err != nil {
+		return nil, err
+	}
+	for i, entry := range dashboardNames(list) {
+		if entry == name {
+			return &DashboardDir{root: d.root, client: d.client, name: name, id: list[i].ID}, nil
+		}
+	}
+	return nil, os.ErrNotExist
+}
+
+// DashboardDir holds one directory per chart of a dashboard.
+type DashboardDir struct {
+	root   *Root
+	client DashboardClient
+	name   string
+	id     string
+}
+
+func (d *DashboardDir) dashboard(ctx context.Context) (*axiomclient.Dashboard, error) {
+	return d.root.fsys.dashboards.Get(ctx, d.client, d.id)
+}
+
+func (d *DashboardDir) Stat(ctx context.Context) (os.FileInfo, error) {
+	return DirInfo(d.name), nil
+}
+
+func (d *DashboardDir) ReadDir(ctx context.Context) ([]os.FileInfo, error) {
+	dashboard, err := d.dashboard(ctx)
+	if err != nil {
+		return nil, err
+	}
+	names := chartNames(dashboard.Charts)
+	entries := make([]os.FileInfo, 0, len(names))
+	for _, name := range names {
+		entries = append(entries, DirInfo(name))
+	}
+	sort.Slice(entries, func(i, j int) bool { return entries[i].Name() < entries[j].Name() })
+	return entries, nil
+}
+
+func (d *DashboardDir) Lookup(ctx context.Context, name string) (Node, error) {
+	dashboard, err := d.dashboard(ctx)
+	if err != nil {
+		return nil, err
+	}
+	for i, entry := range chartNames(dashboard.Charts) {
+		if entry == name {
+			apl := dashboardAPL(dashboard.Charts[i].Query.APL, dashboard, d.root.fsys.Config.DefaultRange)
+			return &ChartDir{root: d.root, dashboard: d.name, name: name, apl: apl}, nil
+		}
+	}
+	return nil, os.ErrNotExist
+}
+
+// ChartDir is one dashboard chart: its APL with the dashboard's time range
+// applied, and the result as CSV.
+type ChartDir struct {
+	root      *Root
+	dashboard string
+	name      string
+	apl       string
+}
+
+func (c *ChartDir) Stat(ctx context.Context) (os.FileInfo, error) {
+	return DirInfo(c.name), nil
+}
+
+func (c *ChartDir) ReadDir(ctx context.Context) ([]os.FileInfo, error) {
+	return []os.FileInfo{
+		FileInfo("apl", int64(len(c.apl)+1)),
+		FileInfo("result.csv", 0),
+	}, nil
+}
+
+func (c *ChartDir) Lookup(ctx context.Context, name string) (Node, error) {
+	switch name {
+	case "apl":
+		return &StaticFile{name: name, data: []byte(c.apl + "\n")}, nil
+	case "result.csv":
+		return &ChartResultFile{root: c.root, apl: c.apl, label: queryLabel("_dashboards", c.dashboard, c.name, name)}, nil
+	default:
+		if node, ok := lookupErrorFile(ctx, c, name); ok {
+			return node, nil
+		}
+		return nil, os.ErrNotExist
+	}
+}
+
+// ChartResultFile runs a chart's APL.
+type ChartResultFile struct {
+	root  *Root
+	apl   string
+	label http.Header
+}
+
+func (c *ChartResultFile) Stat(ctx context.Context) (os.FileInfo, error) {
+	return QueryFileInfo("result.csv"), nil
+}
+
+func (c *ChartResultFile) options() query.ExecOptions {
+	return query.ExecOptions{
+		UseCache:    true,
+		EnsureLimit: true,
+		Headers:     c.label,
+		MaxStale:    c.root.Config().MaxStale(config.StalePathDashboards),
+	}
+}
+
+func (c *ChartResultFile) Estimate(ctx context.Context) (query.ResultEstimate, error) {
+	if err := query.ValidateAPL(c.apl); err != nil {
+		return query.ResultEstimate{}, err
+	}
+	if err := c.root.checkAccess(c.apl); err != nil {
+		return query.ResultEstimate{}, err
+	}
+	return c.root.Executor().EstimateResult(ctx, c.apl, "csv", c.options())
+}
+
+func (c *ChartResultFile) Open(ctx context.Context, flags int) (billy.File, error) {
+	if err := query.ValidateAPL(c.apl); err != nil {
+		return nil, err
+	}
+	if err := c.root.checkAccess(c.apl); err != nil {
+		return nil, err
+	}
+	result, err := c.root.Executor().ExecuteAPLResult(ctx, c.apl, "csv", c.options())
+	if err != nil {
+		return nil, err
+	}
+	return openResult(result)
+}
+
+// dashboardAPL restricts a chart's APL to the dashboard's time window, unless
+// the APL already filters on _time. A dashboard without a start uses the
+// last defaultRange.
+func dashboardAPL(apl string, d *axiomclient.Dashboard, defaultRange string) string {
+	apl = strings.TrimSpace(apl)
+	if apl == "" || strings.Contains(apl, "_time between") {
+		return apl
+	}
+	start := timeWindowExpr(d.TimeWindowStart)
+	if start == "" {
+		start = "ago(" + defaultRange + ")"
+	}
+	end := timeWindowExpr(d.TimeWindowEnd)
+	if end == "" {
+		end = "now()"
+	}
+	clause := "| where _time between (" + start + " .. " + end + ")"
+	source, rest, ok := strings.Cut(apl, "|")
+	if !ok {
+		return apl + "\n" + clause
+	}
+	return strings.TrimRight(source, " \n") + "\n" + clause + "\n|" + rest
+}
+
+var relativeWindowRe = regexp.MustCompile(`^[0-9]+[a-z]+$`)
+
+// timeWindowExpr converts a dashboard time bound ("qr-now", "qr-now-1h" or
+// an RFC 3339 timestamp) to an APL expression, or "" when it is unknown.
+func timeWindowExpr(bound string) string {
+	switch {
+	case bound == "qr-now":
+		return "now()"
+	case strings.HasPrefix(bound, "qr-now-"):
+		if d := strings.TrimPrefix(bound, "qr-now-"); relativeWindowRe.MatchString(d) {
+			return "ago(" + d + ")"
+		}
+		return ""
+	}
+	if t, err := time.Parse(time.RFC3339Nano, bound); err == nil {
+		return "datetime(" + t.UTC().Format(time.RFC3339Nano) + ")"
+	}
+	return ""
+}
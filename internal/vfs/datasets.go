@@ -7,6 +7,7 @@ import (
 	"encoding/json"
 	"log/slog"
 	"os"
+	"slices"
 	"sort"
 	"strconv"
 	"time"
@@ -14,6 +15,8 @@ import (
 	"github.com/go-git/go-billy/v5"
 
 	"github.com/axiomhq/axiom-fs/internal/axiomclient"
+	"github.com/axiomhq/axiom-fs/internal/compiler"
+	"github.com/axiomhq/axiom-fs/internal/config"
 	"github.com/axiomhq/axiom-fs/internal/query"
 )
 
@@ -26,7 +29,7 @@ func (d *DatasetsDir) Stat(ctx context.Context) (os.FileInfo, error) {
 }
 
 func (d *DatasetsDir) ReadDir(ctx context.Context) ([]os.FileInfo, error) {
-	datasets, err := d.root.datasets().List(ctx, d.root.Client())
+	datasets, err := d.root.listDatasets(ctx)
 	if err != nil {
 		return nil, err
 	}
@@ -35,14 +38,23 @@ func (d *DatasetsDir) ReadDir(ctx context.Context) ([]os.FileInfo, error) {
 		if dataset.Name == "" {
 			continue
 		}
-		entries = append(entries, DirInfo(dataset.Name))
+		entries = append(entries, d.root.datasetDirInfo(ctx, dataset.Name))
+	}
+	if _, stale := d.root.staleFile(); stale {
+		entries = append(entries, DynamicFileInfo(".stale"))
 	}
 	sort.Slice(entries, func(i, j int) bool { return entries[i].Name() < entries[j].Name() })
 	return entries, nil
 }
 
 func (d *DatasetsDir) Lookup(ctx context.Context, name string) (Node, error) {
-	datasets, err := d.root.datasets().List(ctx, d.root.Client())
+	if name == ".stale" {
+		if node, stale := d.root.staleFile(); stale {
+			return node, nil
+		}
+		return nil, os.ErrNotExist
+	}
+	datasets, err := d.root.listDatasets(ctx)
 	if err != nil {
 		return nil, err
 	}
@@ -60,7 +72,7 @@ type DatasetDir struct {
 }
 
 func (d *DatasetDir) Stat(ctx context.Context) (os.FileInfo, error) {
-	return DirInfo(d.dataset.Name), nil
+	return d.root.datasetDirInfo(ctx, d.dataset.Name), nil
 }
 
 func (d *DatasetDir) ReadDir(ctx context.Context) ([]os.FileInfo, error) {
@@ -72,31 +84,72 @@ func (d *DatasetDir) ReadDir(ctx context.Context) ([]os.FileInfo, error) {
 			slog.Warn("failed to prefetch fields", "dataset", d.dataset.Name, "error", err)
 		}
 	}()
-	return []os.FileInfo{
+	entries := []os.FileInfo{
+		FileInfo("README.md", 0),
 		FileInfo("schema.json", 0),
 		FileInfo("schema.csv", 0),
+		FileInfo("schema.jsonschema", 0),
+		FileInfo("schema.diff.json", 0),
 		FileInfo("sample.ndjson", 0),
+		FileInfo("duckdb.sql", 0),
 		DirInfo("fields"),
 		DirInfo("presets"),
 		DirInfo("q"),
-	}, nil
+	}
+	if d.root.Tails() != nil {
+		entries = append(entries, FileInfo("tail.ndjson", 0), FileInfo("tail.status.json", 0))
+	}
+	if d.root.buried(d.dataset.Name) {
+		entries = append(entries, DynamicFileInfo(".deleted"))
+	}
+	return entries, nil
 }
 
 func (d *DatasetDir) Lookup(ctx context.Context, name string) (Node, error) {
 	switch name {
+	case "README.md":
+		return &DatasetReadmeFile{root: d.root, dataset: d.dataset}, nil
 	case "schema.json":
 		return &DatasetSchemaFile{root: d.root, dataset: d.dataset, format: "json"}, nil
 	case "schema.csv":
 		return &DatasetSchemaFile{root: d.root, dataset: d.dataset, format: "csv"}, nil
+	case "schema.jsonschema":
+		return &DatasetSchemaFile{root: d.root, dataset: d.dataset, format: "jsonschema"}, nil
+	case "schema.diff.json":
+		return &StatusFile{name: name, build: func(ctx context.Context) (any, error) {
+			return d.root.schemaDiff(ctx, d.dataset.Name)
+		}}, nil
 	case "sample.ndjson":
 		return &DatasetSampleFile{root: d.root, dataset: d.dataset}, nil
+	case "duckdb.sql":
+		return &DuckDBFile{root: d.root, dataset: d.dataset}, nil
 	case "fields":
 		return &FieldsDir{root: d.root, dataset: d.dataset}, nil
 	case "presets":
 		return &DatasetPresetsDir{root: d.root, dataset: d.dataset}, nil
 	case "q":
 		return &QueryPathDir{root: d.root, dataset: d.dataset.Name, segments: nil}, nil
+	case "tail.ndjson":
+		if d.root.Tails() == nil {
+			return nil, os.ErrNotExist
+		}
+		return &TailFile{root: d.root, dataset: d.dataset.Name}, nil
+	case "tail.status.json":
+		if d.root.Tails() == nil {
+			return nil, os.ErrNotExist
+		}
+		return &StatusFile{name: name, build: func(ctx context.Context) (any, error) {
+			return d.root.Tails().Status(d.dataset.Name), nil
+		}}, nil
+	case ".deleted":
+		if node, ok := d.root.deletedFile(d.dataset.Name); ok {
+			return node, nil
+		}
+		return nil, os.ErrNotExist
 	default:
+		if node, ok := lookupErrorFile(ctx, d, name); ok {
+			return node, nil
+		}
 		return nil, os.ErrNotExist
 	}
 }
@@ -115,18 +168,57 @@ func (f *FieldsDir) ReadDir(ctx context.Context) ([]os.FileInfo, error) {
 	if err != nil {
 		return nil, err
 	}
-	entries := make([]os.FileInfo, 0, len(fields))
-	for _, field := range fields {
-		if field.Hidden {
-			continue
+	names := f.listedFields(fields)
+	var entries []os.FileInfo
+	if !f.sharded(len(names)) {
+		entries = fieldEntries(names)
+	} else {
+		seen := map[string]bool{}
+		for _, name := range names {
+			if shard := fieldShard(name); !seen[shard] {
+				seen[shard] = true
+				entries = append(entries, DirInfo(shard))
+			}
 		}
-		entries = append(entries, DirInfo(field.Name))
+	}
+	if slices.ContainsFunc(fields, func(field axiomclient.Field) bool { return field.Hidden }) {
+		entries = append(entries, DirInfo(".hidden"))
+	}
+	if len(encodedFieldNames(fields)) > 0 {
+		entries = append(entries, FileInfo(fieldNamesFile, 0))
 	}
 	sort.Slice(entries, func(i, j int) bool { return entries[i].Name() < entries[j].Name() })
 	return entries, nil
 }
 
 func (f *FieldsDir) Lookup(ctx context.Context, name string) (Node, error) {
+	switch name {
+	case ".hidden":
+		return &HiddenFieldsDir{fields: f}, nil
+	case fieldNamesFile:
+		return &StatusFile{name: name, build: func(ctx context.Context) (any, error) {
+			fields, err := f.root.fields().List(ctx, f.root.Client(), f.dataset.Name)
+			if err != nil {
+				return nil, err
+			}
+			return encodedFieldNames(fields), nil
+		}}, nil
+	}
+	if isFieldShard(name) {
+		names, err := f.visibleFields(ctx)
+		if err == nil && f.sharded(len(names)) {
+			return &FieldShardDir{fields: f, shard: name}, nil
+		}
+	}
+	return f.lookupField(ctx, name)
+}
+
+// lookupField resolves a fields/ entry, decoding its name first.
+func (f *FieldsDir) lookupField(ctx context.Context, entry string) (Node, error) {
+	name, ok := decodeFieldName(entry)
+	if !ok || f.root.redactor(f.dataset.Name).Denied(name) {
+		return nil, os.ErrNotExist
+	}
 	field, found, err := f.root.fields().Lookup(ctx, f.root.Client(), f.dataset.Name, name)
 	if err != nil {
 		return &FieldDir{root: f.root, dataset: f.dataset, field: name, fieldType: ""}, nil
@@ -137,6 +229,127 @@ func (f *FieldsDir) Lookup(ctx context.Context, name string) (Node, error) {
 	return &FieldDir{root: f.root, dataset: f.dataset, field: field.Name, fieldType: field.Type}, nil
 }
 
+// visibleFields returns the names of the fields listed under fields/.
+func (f *FieldsDir) visibleFields(ctx context.Context) ([]string, error) {
+	fields, err := f.root.fields().List(ctx, f.root.Client(), f.dataset.Name)
+	if err != nil {
+		return nil, err
+	}
+	return f.listedFields(fields), nil
+}
+
+func (f *FieldsDir) listedFields(fields []axiomclient.Field) []string {
+	names := make([]string, 0, len(fields))
+	for _, field := range fields {
+		if f.root.fieldListed(f.dataset.Name, field) {
+			names = append(names, field.Name)
+		}
+	}
+	return names
+}
+
+// sharded reports whether a dataset with n fields lists fields/ by shard.
+func (f *FieldsDir) sharded(n int) bool {
+	threshold := f.root.fsys.Config.FieldShardThreshold
+	return threshold > 0 && n > threshold
+}
+
+func fieldEntries(names []string) []os.FileInfo {
+	entries := make([]os.FileInfo, 0, len(names))
+	for _, name := range names {
+		entries = append(entries, DirInfo(encodeFieldName(name)))
+	}
+	sort.Slice(entries, func(i, j int) bool { return entries[i].Name() < entries[j].Name() })
+	return entries
+}
+
+// fieldShard names the fields/ subdirectory a field is listed under on wide
+// datasets: its lowercased first letter or digit, or "_".
+func fieldShard(name string) string {
+	if name != "" {
+		c := name[0]
+		switch {
+		case c >= 'a' && c <= 'z', c >= '0' && c <= '9':
+			return string(c)
+		case c >= 'A' && c <= 'Z':
+			return string(c + 'a' - 'A')
+		}
+	}
+	return "_"
+}
+
+func isFieldShard(name string) bool {
+	return len(name) == 1 && fieldShard(name) == name
+}
+
+// FieldShardDir is fields/<shard>/ on a dataset with more fields than
+// FieldShardThreshold.
+type FieldShardDir struct {
+	fields *FieldsDir
+	shard  string
+}
+
+func (s *FieldShardDir) Stat(ctx context.Context) (os.FileInfo, error) {
+	return DirInfo(s.shard), nil
+}
+
+func (s *FieldShardDir) ReadDir(ctx context.Context) ([]os.FileInfo, error) {
+	names, err := s.fields.visibleFields(ctx)
+	if err != nil {
+		return nil, err
+	}
+	names = slices.DeleteFunc(names, func(name string) bool { return fieldShard(name) != s.shard })
+	return fieldEntries(names), nil
+}
+
+func (s *FieldShardDir) Lookup(ctx context.Context, entry string) (Node, error) {
+	if name, ok := decodeFieldName(entry); !ok || fieldShard(name) != s.shard {
+		return nil, os.ErrNotExist
+	}
+	return s.fields.lookupField(ctx, entry)
+}
+
+// HiddenFieldsDir is fields/.hidden/, the dataset's hidden fields. They
+// are served here whether or not IncludeHiddenFields lists them elsewhere.
+type HiddenFieldsDir struct {
+	fields *FieldsDir
+}
+
+func (h *HiddenFieldsDir) Stat(ctx context.Context) (os.FileInfo, error) {
+	return DirInfo(".hidden"), nil
+}
+
+func (h *HiddenFieldsDir) ReadDir(ctx context.Context) ([]os.FileInfo, error) {
+	fields, err := h.fields.root.fields().List(ctx, h.fields.root.Client(), h.fields.dataset.Name)
+	if err != nil {
+		return nil, err
+	}
+	var names []string
+	redactor := h.fields.root.redactor(h.fields.dataset.Name)
+	for _, field := range fields {
+		if field.Hidden && !redactor.Denied(field.Name) {
+			names = append(names, field.Name)
+		}
+	}
+	return fieldEntries(names), nil
+}
+
+func (h *HiddenFieldsDir) Lookup(ctx context.Context, entry string) (Node, error) {
+	name, ok := decodeFieldName(entry)
+	if !ok {
+		return nil, os.ErrNotExist
+	}
+	root := h.fields.root
+	field, found, err := root.fields().Lookup(ctx, root.Client(), h.fields.dataset.Name, name)
+	if err != nil {
+		return nil, err
+	}
+	if !found || !field.Hidden || root.redactor(h.fields.dataset.Name).Denied(field.Name) {
+		return nil, os.ErrNotExist
+	}
+	return &FieldDir{root: root, dataset: h.fields.dataset, field: field.Name, fieldType: field.Type}, nil
+}
+
 type FieldDir struct {
 	root      *Root
 	dataset   *axiomclient.Dataset
@@ -145,7 +358,7 @@ type FieldDir struct {
 }
 
 func (f *FieldDir) Stat(ctx context.Context) (os.FileInfo, error) {
-	return DirInfo(f.field), nil
+	return DirInfo(encodeFieldName(f.field)), nil
 }
 
 func (f *FieldDir) supportsHistogram() bool {
@@ -190,20 +403,34 @@ func (d *DatasetSchemaFile) buildSchema(ctx context.Context) ([]byte, error) {
 	if err != nil {
 		return nil, err
 	}
+	redactor := d.root.redactor(d.dataset.Name)
+	fields = slices.DeleteFunc(slices.Clone(fields), func(f axiomclient.Field) bool { return redactor.Denied(f.Name) })
+	listed := fields
+	if !d.root.fsys.Config.IncludeHiddenFields {
+		listed = slices.DeleteFunc(slices.Clone(fields), func(f axiomclient.Field) bool { return f.Hidden })
+	}
 	switch d.format {
 	case "json":
-		data, err := json.MarshalIndent(fields, "", "  ")
+		data, err := json.MarshalIndent(listed, "", "  ")
 		if err != nil {
 			return nil, err
 		}
 		return append(data, '\n'), nil
 	case "csv":
-		return fieldsToCSV(fields)
+		return fieldsToCSV(listed)
+	case "jsonschema":
+		data, err := json.MarshalIndent(fieldsToJSONSchema(d.dataset.Name, listed), "", "  ")
+		if err != nil {
+			return nil, err
+		}
+		return append(data, '\n'), nil
 	default:
 		return nil, os.ErrInvalid
 	}
 }
 
+// fieldsToCSV lists fields by their fields/ directory name, so every row is
+// one line; .names.json maps encoded names back.
 func fieldsToCSV(fields []axiomclient.Field) ([]byte, error) {
 	var buf bytes.Buffer
 	w := csv.NewWriter(&buf)
@@ -211,10 +438,7 @@ func fieldsToCSV(fields []axiomclient.Field) ([]byte, error) {
 		return nil, err
 	}
 	for _, f := range fields {
-		if f.Hidden {
-			continue
-		}
-		if err := w.Write([]string{f.Name, f.Type, f.Description, f.Unit}); err != nil {
+		if err := w.Write([]string{encodeFieldName(f.Name), f.Type, f.Description, f.Unit}); err != nil {
 			return nil, err
 		}
 	}
@@ -242,18 +466,38 @@ type DatasetSampleFile struct {
 	dataset *axiomclient.Dataset
 }
 
-func (d *DatasetSampleFile) buildSample(ctx context.Context) ([]byte, error) {
-	cfg := d.root.Config()
-	apl := "['" + d.dataset.Name + "']\n| take " + strconv.Itoa(cfg.SampleLimit)
-	return d.root.Executor().ExecuteAPL(ctx, apl, "ndjson", query.ExecOptions{
+// query returns the APL the sample runs and its options.
+func (d *DatasetSampleFile) query() (string, query.ExecOptions) {
+	cfg := d.root.datasetConfig(d.dataset.Name)
+	apl := d.root.source(d.dataset.Name)
+	if cfg.ExploreSample > 0 {
+		apl += "\n| " + compiler.SampleAPL(cfg.ExploreSample)
+	}
+	apl += "\n| take " + strconv.Itoa(cfg.SampleLimit)
+	return apl, query.ExecOptions{
 		UseCache:        true,
 		EnsureTimeRange: true,
 		EnsureLimit:     false,
-	})
+		AutoRange:       cfg.SampleAutoRange,
+		DefaultRange:    cfg.DefaultRange,
+		Headers:         queryLabel(d.dataset.Name, "sample.ndjson"),
+		CacheOnly:       d.root.buried(d.dataset.Name),
+		MaxStale:        cfg.MaxStale(config.StalePathSample),
+	}
+}
+
+func (d *DatasetSampleFile) buildSample(ctx context.Context) ([]byte, error) {
+	apl, opts := d.query()
+	return d.root.Executor().ExecuteAPL(ctx, apl, "ndjson", opts)
 }
 
 func (d *DatasetSampleFile) Stat(ctx context.Context) (os.FileInfo, error) {
-	return DynamicFileInfo("sample.ndjson"), nil
+	return QueryFileInfo("sample.ndjson"), nil
+}
+
+func (d *DatasetSampleFile) Estimate(ctx context.Context) (query.ResultEstimate, error) {
+	apl, opts := d.query()
+	return d.root.Executor().EstimateResult(ctx, apl, "ndjson", opts)
 }
 
 func (d *DatasetSampleFile) Open(ctx context.Context, flags int) (billy.File, error) {
@@ -271,26 +515,48 @@ type FieldQueryFile struct {
 	kind    string
 }
 
-func (f *FieldQueryFile) buildFieldQuery(ctx context.Context) ([]byte, error) {
+// query returns the APL the file runs and its options.
+func (f *FieldQueryFile) query() (string, query.ExecOptions, error) {
 	var expr string
 	switch f.kind {
 	case "top":
-		expr = "summarize count() by " + f.field + "\n| order by count_ desc\n| take 10"
+		expr = "summarize count() by " + compiler.FieldRef(f.field) + "\n| order by count_ desc\n| take 10"
 	case "histogram":
-		expr = "summarize histogram(" + f.field + ", 100)"
+		expr = "summarize histogram(" + compiler.FieldRef(f.field) + ", 100)"
 	default:
-		return nil, os.ErrInvalid
+		return "", query.ExecOptions{}, os.ErrInvalid
 	}
-	apl := "['" + f.dataset.Name + "']\n| " + expr
-	return f.root.Executor().ExecuteAPL(ctx, apl, "csv", query.ExecOptions{
+	apl := f.root.source(f.dataset.Name) + "\n| " + expr
+	cfg := f.root.datasetConfig(f.dataset.Name)
+	return apl, query.ExecOptions{
 		UseCache:        true,
 		EnsureTimeRange: true,
 		EnsureLimit:     false,
-	})
+		DefaultRange:    cfg.DefaultRange,
+		Headers:         queryLabel(f.dataset.Name, "fields", encodeFieldName(f.field), f.kind+".csv"),
+		CacheOnly:       f.root.buried(f.dataset.Name),
+		MaxStale:        cfg.MaxStale(config.StalePathFields),
+	}, nil
+}
+
+func (f *FieldQueryFile) buildFieldQuery(ctx context.Context) ([]byte, error) {
+	apl, opts, err := f.query()
+	if err != nil {
+		return nil, err
+	}
+	return f.root.Executor().ExecuteAPL(ctx, apl, "csv", opts)
 }
 
 func (f *FieldQueryFile) Stat(ctx context.Context) (os.FileInfo, error) {
-	return DynamicFileInfo(f.kind + ".csv"), nil
+	return QueryFileInfo(f.kind + ".csv"), nil
+}
+
+func (f *FieldQueryFile) Estimate(ctx context.Context) (query.ResultEstimate, error) {
+	apl, opts, err := f.query()
+	if err != nil {
+		return query.ResultEstimate{}, err
+	}
+	return f.root.Executor().EstimateResult(ctx, apl, "csv", opts)
 }
 
 func (f *FieldQueryFile) Open(ctx context.Context, flags int) (billy.File, error) {
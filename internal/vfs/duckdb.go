@@ -0,0 +1,152 @@
+package vfs
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"path"
+	"sort"
+	"strings"
+
+	"github.com/go-git/go-billy/v5"
+
+	"github.com/axiomhq/axiom-fs/internal/axiomclient"
+	"github.com/axiomhq/axiom-fs/internal/presets"
+	"github.com/axiomhq/axiom-fs/internal/query"
+)
+
+// DuckDBFile is <dataset>/duckdb.sql: DuckDB views over the dataset's
+// result and preset CSVs on the mount, for `duckdb -init`.
+type DuckDBFile struct {
+	root    *Root
+	dataset *axiomclient.Dataset
+}
+
+func (d *DuckDBFile) Stat(ctx context.Context) (os.FileInfo, error) {
+	return QueryFileInfo("duckdb.sql"), nil
+}
+
+func (d *DuckDBFile) Open(ctx context.Context, flags int) (billy.File, error) {
+	data, err := d.render(ctx)
+	if err != nil {
+		return nil, err
+	}
+	return newBytesFile(data), nil
+}
+
+// render writes a view named after the dataset over q/result.csv, typed
+// from the dataset's fields, and one view per preset named
+// <dataset>_<preset>, whose aggregated columns DuckDB detects itself.
+func (d *DuckDBFile) render(ctx context.Context) ([]byte, error) {
+	name := d.dataset.Name
+	dir := path.Join(d.root.fsys.Config.MountPoint, name)
+	types, err := d.columnTypes(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	var b strings.Builder
+	fmt.Fprintf(&b, "-- DuckDB views over the Axiom dataset %s, read from the mount at %s.\n", name, dir)
+	fmt.Fprintf(&b, "-- Load with: duckdb -init %s\n", path.Join(dir, "duckdb.sql"))
+	b.WriteString("-- Each view runs its file's query on read, or reuses the cached result.\n\n")
+	writeDuckDBView(&b, name, path.Join(dir, "q", "result.csv"), types)
+	for _, preset := range presets.PresetsForDataset(d.dataset) {
+		writeDuckDBView(&b, name+"_"+preset.Name, path.Join(dir, "presets", preset.Name+".csv"), nil)
+	}
+	return []byte(b.String()), nil
+}
+
+// columnTypes maps the columns of q/result.csv to DuckDB types. DuckDB
+// refuses types for columns missing from the CSV, so it runs the query the
+// view reads (the view then reuses the cached result) and types only the
+// columns it returned.
+func (d *DuckDBFile) columnTypes(ctx context.Context) (map[string]string, error) {
+	name := d.dataset.Name
+	segments := []string{"result.csv"}
+	cfg := d.root.datasetConfig(name)
+	compiled, err := compilePath(name, segments, cfg, d.root.distinctFields(ctx, name, segments))
+	if err != nil {
+		return nil, err
+	}
+	meta, err := d.root.Executor().ResultMeta(ctx, compiled.APL, compiled.Format, query.ExecOptions{
+		UseCache:     true,
+		DefaultRange: cfg.DefaultRange,
+		Headers:      queryPathLabel(name, segments),
+		Label:        compiled.Label,
+		CacheOnly:    d.root.buried(name),
+	})
+	if err != nil {
+		return nil, err
+	}
+	fields, err := d.root.fields().List(ctx, d.root.Client(), name)
+	if err != nil {
+		return nil, err
+	}
+	fieldTypes := make(map[string]string, len(fields))
+	for _, field := range fields {
+		fieldTypes[field.Name] = duckDBType(field.Type)
+	}
+	types := make(map[string]string, len(meta.Columns))
+	for _, column := range meta.Columns {
+		if t, ok := fieldTypes[column.Name]; ok {
+			types[column.Name] = t
+		}
+	}
+	return types, nil
+}
+
+// duckDBType maps an Axiom field type, such as "integer" or
+// "integer|float", to a DuckDB column type. Arrays, maps and mixed types
+// stay text.
+func duckDBType(fieldType string) string {
+	numeric, integer := true, true
+	for _, t := range strings.Split(fieldType, "|") {
+		switch t {
+		case "integer":
+		case "float":
+			integer = false
+		default:
+			numeric = false
+		}
+	}
+	switch {
+	case numeric && integer:
+		return "BIGINT"
+	case numeric:
+		return "DOUBLE"
+	case fieldType == "boolean":
+		return "BOOLEAN"
+	case fieldType == "datetime":
+		return "TIMESTAMPTZ"
+	default:
+		return "VARCHAR"
+	}
+}
+
+func writeDuckDBView(b *strings.Builder, view, file string, types map[string]string) {
+	fmt.Fprintf(b, "CREATE OR REPLACE VIEW %s AS\n  SELECT * FROM read_csv(%s, header = true", duckDBIdent(view), duckDBString(file))
+	if len(types) > 0 {
+		columns := make([]string, 0, len(types))
+		for column := range types {
+			columns = append(columns, column)
+		}
+		sort.Strings(columns)
+		b.WriteString(", types = {")
+		for i, column := range columns {
+			if i > 0 {
+				b.WriteString(", ")
+			}
+			fmt.Fprintf(b, "%s: %s", duckDBString(column), duckDBString(types[column]))
+		}
+		b.WriteString("}")
+	}
+	b.WriteString(");\n")
+}
+
+func duckDBIdent(s string) string {
+	return `"` + strings.ReplaceAll(s, `"`, `""`) + `"`
+}
+
+func duckDBString(s string) string {
+	return "'" + strings.ReplaceAll(s, "'", "''") + "'"
+}
@@ -0,0 +1,138 @@
+package vfs
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"strings"
+	"syscall"
+	"time"
+
+	"github.com/go-git/go-billy/v5"
+
+	"github.com/axiomhq/axiom-fs/internal/axiomclient"
+	"github.com/axiomhq/axiom-fs/internal/drain"
+)
+
+// errnoNames are the symbolic names reported in .error files.
+var errnoNames = map[syscall.Errno]string{
+	syscall.EACCES:    "EACCES",
+	syscall.ENOENT:    "ENOENT",
+	syscall.EAGAIN:    "EAGAIN",
+	syscall.EINVAL:    "EINVAL",
+	syscall.EDQUOT:    "EDQUOT",
+	syscall.ETIMEDOUT: "ETIMEDOUT",
+	syscall.EROFS:     "EROFS",
+	syscall.ENOTDIR:   "ENOTDIR",
+	syscall.EISDIR:    "EISDIR",
+	syscall.EIO:       "EIO",
+	syscall.ESTALE:    "ESTALE",
+	syscall.EFBIG:     "EFBIG",
+}
+
+// errQueryChanged reports that a saved query was rewritten while one of its
+// results was being produced or read.
+var errQueryChanged = fmt.Errorf("saved query changed since the result was opened: %w", syscall.ESTALE)
+
+// errProbeChanged reports that a result's full run does not start with the
+// rows already served from its -read-probe-rows probe.
+var errProbeChanged = fmt.Errorf("result changed since its first rows were read: %w", syscall.ESTALE)
+
+// Errno translates an error from the tree, the executor or the Axiom API
+// into the errno a file operation should fail with. Errors that already
+// carry an errno (Axiom API errors, quotas) keep it; unknown errors are EIO.
+func Errno(err error) syscall.Errno {
+	var errno syscall.Errno
+	switch {
+	case err == nil:
+		return 0
+	case errors.As(err, &errno):
+		return errno
+	case errors.Is(err, fs.ErrNotExist):
+		return syscall.ENOENT
+	case errors.Is(err, fs.ErrPermission):
+		return syscall.EACCES
+	case errors.Is(err, fs.ErrInvalid):
+		return syscall.EINVAL
+	case errors.Is(err, drain.ErrDraining):
+		return syscall.EAGAIN
+	case errors.Is(err, context.DeadlineExceeded):
+		return syscall.ETIMEDOUT
+	default:
+		return syscall.EIO
+	}
+}
+
+func errnoName(errno syscall.Errno) string {
+	if name, ok := errnoNames[errno]; ok {
+		return name
+	}
+	return errno.Error()
+}
+
+// NodeErrorFile is the `<file>.error` sibling of a result file. Reading it
+// opens the file and reports why that failed, with the errno a read of the
+// file returns, so shell users can tell auth failures from bad queries.
+type NodeErrorFile struct {
+	name   string
+	target string
+	file   File
+}
+
+type nodeErrorReport struct {
+	File  string `json:"file"`
+	OK    bool   `json:"ok"`
+	Errno string `json:"errno,omitempty"`
+	Error string `json:"error,omitempty"`
+	// Status is the Axiom API HTTP status, when the API rejected the query.
+	Status int    `json:"status,omitempty"`
+	At     string `json:"at"`
+}
+
+func (n *NodeErrorFile) build(ctx context.Context) []byte {
+	report := nodeErrorReport{File: n.target, OK: true, At: time.Now().UTC().Format(time.RFC3339Nano)}
+	f, err := n.file.Open(ctx, os.O_RDONLY)
+	if f != nil {
+		_ = f.Close()
+	}
+	if err != nil {
+		report.OK = false
+		report.Errno = errnoName(Errno(err))
+		report.Error = err.Error()
+		var apiErr *axiomclient.APIError
+		if errors.As(err, &apiErr) {
+			report.Status = apiErr.StatusCode
+		}
+	}
+	data, _ := json.MarshalIndent(report, "", "  ")
+	return append(data, '\n')
+}
+
+func (n *NodeErrorFile) Stat(ctx context.Context) (os.FileInfo, error) {
+	return DynamicFileInfo(n.name), nil
+}
+
+func (n *NodeErrorFile) Open(ctx context.Context, flags int) (billy.File, error) {
+	return newBytesFile(n.build(ctx)), nil
+}
+
+// lookupErrorFile resolves name as the `.error` sibling of a file in d. It
+// reports false when name is not of that form or the sibling is not a file.
+func lookupErrorFile(ctx context.Context, d Dir, name string) (Node, bool) {
+	target, ok := strings.CutSuffix(name, ".error")
+	if !ok || target == "" || strings.HasSuffix(target, ".error") {
+		return nil, false
+	}
+	node, err := d.Lookup(ctx, target)
+	if err != nil {
+		return nil, false
+	}
+	file, ok := node.(File)
+	if !ok {
+		return nil, false
+	}
+	return &NodeErrorFile{name: name, target: target, file: file}, true
+}
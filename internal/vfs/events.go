@@ -0,0 +1,87 @@
+package vfs
+
+import (
+	"context"
+	"io"
+	"os"
+
+	"github.com/go-git/go-billy/v5"
+
+	"github.com/axiomhq/axiom-fs/internal/events"
+)
+
+// EventsDir is /_events, the mount's change events.
+type EventsDir struct {
+	root *Root
+}
+
+func (e *EventsDir) Stat(ctx context.Context) (os.FileInfo, error) {
+	return DirInfo("_events"), nil
+}
+
+func (e *EventsDir) ReadDir(ctx context.Context) ([]os.FileInfo, error) {
+	return []os.FileInfo{FileInfoAt("stream.ndjson", e.root.Events().Size(), e.root.Events().ModTime())}, nil
+}
+
+func (e *EventsDir) Lookup(ctx context.Context, name string) (Node, error) {
+	if name != "stream.ndjson" {
+		return nil, os.ErrNotExist
+	}
+	return &EventsFile{log: e.root.Events()}, nil
+}
+
+// EventsFile is /_events/stream.ndjson. Like tail.ndjson, stat reports its
+// current size so `tail -f` follows it, and reads at its end wait for the
+// next event.
+type EventsFile struct {
+	log *events.Log
+}
+
+func (e *EventsFile) Stat(ctx context.Context) (os.FileInfo, error) {
+	return FileInfoAt("stream.ndjson", e.log.Size(), e.log.ModTime()), nil
+}
+
+func (e *EventsFile) Open(ctx context.Context, flags int) (billy.File, error) {
+	return &eventsHandle{log: e.log}, nil
+}
+
+type eventsHandle struct {
+	log    *events.Log
+	offset int64
+}
+
+func (h *eventsHandle) Name() string { return "stream.ndjson" }
+func (h *eventsHandle) Size() int64  { return h.log.Size() }
+
+func (h *eventsHandle) Read(p []byte) (int, error) {
+	n, err := h.ReadAt(p, h.offset)
+	h.offset += int64(n)
+	if err == io.EOF && n > 0 {
+		err = nil
+	}
+	return n, err
+}
+
+func (h *eventsHandle) ReadAt(p []byte, off int64) (int, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), tailReadTimeout)
+	defer cancel()
+	return h.log.ReadAt(ctx, p, off)
+}
+
+func (h *eventsHandle) Seek(offset int64, whence int) (int64, error) {
+	switch whence {
+	case io.SeekStart:
+		h.offset = offset
+	case io.SeekCurrent:
+		h.offset += offset
+	case io.SeekEnd:
+		h.offset = h.log.Size() + offset
+	}
+	return h.offset, nil
+}
+
+func (h *eventsHandle) Write(p []byte) (int, error) { return 0, os.ErrPermission }
+func (h *eventsHandle) Close() error                { return nil }
+func (h *eventsHandle) Lock() error                 { return nil }
+func (h *eventsHandle) Unlock() error               { return nil }
+func (h *eventsHandle) Truncate(size int64) error   { return os.ErrPermission }
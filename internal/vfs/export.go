@@ -0,0 +1,200 @@
+package vfs
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+
+	"github.com/go-git/go-billy/v5"
+
+	"github.com/axiomhq/axiom-fs/internal/export"
+	"github.com/axiomhq/axiom-fs/internal/query"
+)
+
+// WithExportSinks sets the object stores export.dest can upload to.
+func WithExportSinks(sinks export.Sinks) Option {
+	return func(fsys *FS) { fsys.Exports = sinks }
+}
+
+// exportFormats are the result formats an object key's extension selects,
+// longest extension first. Other keys get ndjson.
+var exportFormats = []string{"vl.json", "ndjson", "json", "csv", "tsv", "xlsx", "svg", "md"}
+
+func exportFormat(key string) string {
+	for _, format := range exportFormats {
+		if strings.HasSuffix(key, "."+format) {
+			return format
+		}
+	}
+	return "ndjson"
+}
+
+// exportState is a saved query's latest export, shown in export.status.
+type exportState struct {
+	Dest       string     `json:"dest"`
+	Format     string     `json:"format"`
+	State      string     `json:"state"`
+	Bytes      int64      `json:"bytes"`
+	Total      int64      `json:"total"`
+	StartedAt  time.Time  `json:"started_at"`
+	FinishedAt *time.Time `json:"finished_at,omitempty"`
+	Error      string     `json:"error,omitempty"`
+}
+
+// Export states.
+const (
+	exportRunning   = "running"
+	exportUploading = "uploading"
+	exportDone      = "done"
+	exportFailed    = "failed"
+)
+
+// exportTracker holds the latest export of each saved query.
+type exportTracker struct {
+	mu      sync.Mutex
+	exports map[string]*exportState
+}
+
+func (t *exportTracker) get(name string) (exportState, bool) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	state, ok := t.exports[name]
+	if !ok {
+		return exportState{}, false
+	}
+	return *state, true
+}
+
+// start records a new export of name, unless one is still running.
+func (t *exportTracker) start(name string, state *exportState) error {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if prev, ok := t.exports[name]; ok && prev.FinishedAt == nil {
+		return fmt.Errorf("export of %s to %s is still %s: %w", name, prev.Dest, prev.State, syscall.EAGAIN)
+	}
+	if t.exports == nil {
+		t.exports = map[string]*exportState{}
+	}
+	t.exports[name] = state
+	return nil
+}
+
+func (t *exportTracker) update(fn func()) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	fn()
+}
+
+// startExport runs the saved query name and uploads its result to dest in
+// the background. The format follows dest's extension.
+func (r *Root) startExport(name, dest string) error {
+	dest = strings.TrimSpace(dest)
+	u, err := r.fsys.Exports.Parse(dest)
+	if err != nil {
+		return err
+	}
+	apl, err := r.savedAPL(name)
+	if err != nil {
+		return err
+	}
+	state := &exportState{Dest: dest, Format: exportFormat(u.Path), State: exportRunning, StartedAt: time.Now().UTC()}
+	if err := r.fsys.exports.start(name, state); err != nil {
+		return err
+	}
+	go func() {
+		err := r.export(context.Background(), name, apl, state)
+		r.fsys.exports.update(func() {
+			finished := time.Now().UTC()
+			state.FinishedAt = &finished
+			state.State = exportDone
+			if err != nil {
+				state.State, state.Error = exportFailed, err.Error()
+			}
+		})
+	}()
+	return nil
+}
+
+func (r *Root) export(ctx context.Context, name, apl string, state *exportState) error {
+	result, err := r.Executor().ExecuteAPLResult(ctx, apl, state.Format, query.ExecOptions{UseCache: true, Headers: savedQueryLabel(name)})
+	if err != nil {
+		return err
+	}
+	defer result.Release()
+	var body io.Reader = bytes.NewReader(result.Bytes)
+	if result.File != nil {
+		body = result.File
+	}
+	r.fsys.exports.update(func() {
+		state.State, state.Total = exportUploading, result.Size
+	})
+	return r.fsys.Exports.Upload(ctx, state.Dest, &exportProgress{r: body, tracker: &r.fsys.exports, state: state}, result.Size)
+}
+
+// exportProgress counts the bytes read for upload into state.
+type exportProgress struct {
+	r       io.Reader
+	tracker *exportTracker
+	state   *exportState
+}
+
+func (p *exportProgress) Read(b []byte) (int, error) {
+	n, err := p.r.Read(b)
+	p.tracker.update(func() { p.state.Bytes += int64(n) })
+	return n, err
+}
+
+// ExportDestFile is a saved query's export.dest. Writing an object storage
+// URI uploads the result there; reading it shows the last one written.
+type ExportDestFile struct {
+	root *Root
+	name string
+}
+
+func (e *ExportDestFile) Stat(ctx context.Context) (os.FileInfo, error) {
+	return WritableFileInfo("export.dest", 0), nil
+}
+
+func (e *ExportDestFile) Open(ctx context.Context, flags int) (billy.File, error) {
+	state, ok := e.root.fsys.exports.get(e.name)
+	if !ok {
+		return newBytesFile(nil), nil
+	}
+	return newBytesFile([]byte(state.Dest + "\n")), nil
+}
+
+func (e *ExportDestFile) Create(ctx context.Context) (billy.File, error) {
+	return &lineWriteFile{name: "export.dest", run: func(ctx context.Context, written []byte) error {
+		return e.root.startExport(e.name, string(written))
+	}}, nil
+}
+
+// ExportStatusFile is a saved query's export.status: the state and
+// progress of its latest export.
+type ExportStatusFile struct {
+	root *Root
+	name string
+}
+
+func (e *ExportStatusFile) Stat(ctx context.Context) (os.FileInfo, error) {
+	return DynamicFileInfo("export.status"), nil
+}
+
+func (e *ExportStatusFile) Open(ctx context.Context, flags int) (billy.File, error) {
+	state, ok := e.root.fsys.exports.get(e.name)
+	if !ok {
+		return nil, fmt.Errorf("%w: %s has not been exported", os.ErrNotExist, e.name)
+	}
+	data, err := json.MarshalIndent(state, "", "  ")
+	if err != nil {
+		return nil, err
+	}
+	return newBytesFile(append(data, '\n')), nil
+}
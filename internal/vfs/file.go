@@ -2,8 +2,8 @@ package vfs
 
 import (
 	"bytes"
-	"io"
 	"os"
+	"time"
 
 	"github.com/go-git/go-billy/v5"
 
@@ -12,17 +12,19 @@ import (
 )
 
 type bytesFile struct {
-	name   string
-	data   []byte
-	reader *bytes.Reader
+	name    string
+	data    []byte
+	reader  *bytes.Reader
+	modTime time.Time
 }
 
 func newBytesFile(data []byte) billy.File {
 	return &bytesFile{data: data, reader: bytes.NewReader(data)}
 }
 
-func (f *bytesFile) Name() string { return f.name }
-func (f *bytesFile) Size() int64  { return int64(len(f.data)) }
+func (f *bytesFile) Name() string       { return f.name }
+func (f *bytesFile) Size() int64        { return int64(len(f.data)) }
+func (f *bytesFile) ModTime() time.Time { return f.modTime }
 
 func (f *bytesFile) Read(p []byte) (int, error) {
 	return f.reader.Read(p)
@@ -51,16 +53,14 @@ func (f *bytesFile) Truncate(size int64) error {
 }
 
 type tempFile struct {
-	file *os.File
-	size int64
-}
-
-func newTempFile(file *os.File, size int64) billy.File {
-	return &tempFile{file: file, size: size}
+	file    query.SpillFile
+	size    int64
+	modTime time.Time
 }
 
-func (f *tempFile) Name() string { return f.file.Name() }
-func (f *tempFile) Size() int64  { return f.size }
+func (f *tempFile) Name() string       { return f.file.Name() }
+func (f *tempFile) Size() int64        { return f.size }
+func (f *tempFile) ModTime() time.Time { return f.modTime }
 
 func (f *tempFile) Read(p []byte) (int, error) {
 	return f.file.Read(p)
@@ -79,10 +79,7 @@ func (f *tempFile) Write(p []byte) (int, error) {
 }
 
 func (f *tempFile) Close() error {
-	name := f.file.Name()
-	_ = f.file.Close()
-	_ = os.Remove(name)
-	return nil
+	return f.file.Close()
 }
 
 func (f *tempFile) Lock() error   { return nil }
@@ -143,8 +140,7 @@ func (f *aplFile) Truncate(size int64) error {
 
 func openResult(result query.ResultData) (billy.File, error) {
 	if result.File != nil {
-		_, _ = result.File.Seek(0, io.SeekStart)
-		return newTempFile(result.File, result.Size), nil
+		return &tempFile{file: result.File, size: result.Size, modTime: result.ModTime}, nil
 	}
-	return newBytesFile(result.Bytes), nil
+	return &bytesFile{data: result.Bytes, reader: bytes.NewReader(result.Bytes), modTime: result.ModTime}, nil
 }
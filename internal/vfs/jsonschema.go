@@ -0,0 +1,143 @@
+package vfs
+
+import (
+	"strings"
+
+	"github.com/axiomhq/axiom-fs/internal/axiomclient"
+)
+
+const jsonSchemaDraft = "https://json-schema.org/draft/2020-12/schema"
+
+// schemaNode is one level of the event object. Dotted field names such as
+// "attributes.http.status" become nested object properties.
+type schemaNode struct {
+	schema   map[string]any
+	children map[string]*schemaNode
+}
+
+func (n *schemaNode) child(name string) *schemaNode {
+	if n.children == nil {
+		n.children = map[string]*schemaNode{}
+	}
+	c, ok := n.children[name]
+	if !ok {
+		c = &schemaNode{schema: map[string]any{}}
+		n.children[name] = c
+	}
+	return c
+}
+
+func (n *schemaNode) render() map[string]any {
+	if len(n.children) == 0 {
+		return n.schema
+	}
+	out := make(map[string]any, len(n.schema)+2)
+	for k, v := range n.schema {
+		out[k] = v
+	}
+	if _, ok := out["type"]; !ok {
+		out["type"] = "object"
+	}
+	props := make(map[string]any, len(n.children))
+	for name, c := range n.children {
+		props[name] = c.render()
+	}
+	out["properties"] = props
+	return out
+}
+
+// fieldsToJSONSchema builds a draft 2020-12 JSON Schema describing events in
+// dataset. Field types map to JSON types; descriptions and units are kept.
+func fieldsToJSONSchema(dataset string, fields []axiomclient.Field) map[string]any {
+	root := &schemaNode{schema: map[string]any{}}
+	hasTime := false
+	for _, f := range fields {
+		if f.Name == "" {
+			continue
+		}
+		if f.Name == "_time" {
+			hasTime = true
+		}
+		node := root
+		for _, part := range strings.Split(f.Name, ".") {
+			node = node.child(part)
+		}
+		for k, v := range fieldSchema(f) {
+			node.schema[k] = v
+		}
+	}
+
+	doc := root.render()
+	if len(root.children) == 0 {
+		doc = map[string]any{"type": "object", "properties": map[string]any{}}
+	}
+	doc["$schema"] = jsonSchemaDraft
+	doc["title"] = dataset
+	if hasTime {
+		doc["required"] = []string{"_time"}
+	}
+	return doc
+}
+
+// fieldSchema maps an Axiom field to its JSON Schema. Union types such as
+// "integer|float" produce a type list.
+func fieldSchema(f axiomclient.Field) map[string]any {
+	schema := map[string]any{}
+	var types []string
+	seen := map[string]bool{}
+	for _, t := range strings.Split(f.Type, "|") {
+		jsonType, format := jsonSchemaType(strings.TrimSpace(t))
+		if jsonType == "" {
+			// Unknown types leave the field unconstrained.
+			types = nil
+			delete(schema, "format")
+			break
+		}
+		if format != "" {
+			schema["format"] = format
+		}
+		if !seen[jsonType] {
+			seen[jsonType] = true
+			types = append(types, jsonType)
+		}
+	}
+	switch len(types) {
+	case 0:
+	case 1:
+		schema["type"] = types[0]
+	default:
+		schema["type"] = types
+	}
+	if f.Description != "" {
+		schema["description"] = f.Description
+	}
+	if f.Unit != "" {
+		schema["x-unit"] = f.Unit
+	}
+	return schema
+}
+
+func jsonSchemaType(axiomType string) (string, string) {
+	switch axiomType {
+	case "string":
+		return "string", ""
+	case "integer":
+		return "integer", ""
+	case "float":
+		return "number", ""
+	case "boolean":
+		return "boolean", ""
+	case "datetime":
+		return "string", "date-time"
+	case "timespan":
+		return "string", "duration"
+	case "array":
+		return "array", ""
+	case "map", "object":
+		return "object", ""
+	case "null":
+		return "null", ""
+	default:
+		return "", ""
+	}
+}
@@ -0,0 +1,47 @@
+package vfs
+
+import (
+	"context"
+	"os"
+
+	"github.com/go-git/go-billy/v5"
+)
+
+// LinkFile serves a deep link to a query in the Axiom web UI, either as a
+// plain URL (link.txt) or as an internet shortcut (open.url) that Finder and
+// Explorer open in the browser.
+type LinkFile struct {
+	name string
+	link func() (string, error)
+}
+
+func isLinkName(name string) bool {
+	return name == "open.url" || name == "link.txt"
+}
+
+func (l *LinkFile) render() ([]byte, error) {
+	link, err := l.link()
+	if err != nil {
+		return nil, err
+	}
+	if l.name == "open.url" {
+		return []byte("[InternetShortcut]\r\nURL=" + link + "\r\n"), nil
+	}
+	return []byte(link + "\n"), nil
+}
+
+func (l *LinkFile) Stat(ctx context.Context) (os.FileInfo, error) {
+	data, err := l.render()
+	if err != nil {
+		return nil, err
+	}
+	return FileInfo(l.name, int64(len(data))), nil
+}
+
+func (l *LinkFile) Open(ctx context.Context, flags int) (billy.File, error) {
+	data, err := l.render()
+	if err != nil {
+		return nil, err
+	}
+	return newBytesFile(data), nil
+}
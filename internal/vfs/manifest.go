@@ -0,0 +1,94 @@
+package vfs
+
+import (
+	"context"
+	"encoding/json"
+	"os"
+	"strconv"
+	"strings"
+
+	"github.com/go-git/go-billy/v5"
+
+	"github.com/axiomhq/axiom-fs/internal/query"
+)
+
+// ResultMetaFile renders the execution metadata of a sibling result file,
+// either as a sha256sum line (a .sha256 file) or as a manifest.
+type ResultMetaFile struct {
+	name   string
+	result string
+	meta   func(ctx context.Context) (query.ResultMeta, error)
+}
+
+type resultManifest struct {
+	File string `json:"file"`
+	query.ResultMeta
+}
+
+func (r *ResultMetaFile) render(ctx context.Context) ([]byte, error) {
+	meta, err := r.meta(ctx)
+	if err != nil {
+		return nil, err
+	}
+	if strings.HasSuffix(r.name, ".sha256") {
+		// Same layout as sha256sum, so `sha256sum -c result.sha256` works.
+		return []byte(meta.SHA256 + "  " + r.result + "\n"), nil
+	}
+	data, err := json.MarshalIndent(resultManifest{File: r.result, ResultMeta: meta}, "", "  ")
+	if err != nil {
+		return nil, err
+	}
+	return append(data, '\n'), nil
+}
+
+func (r *ResultMetaFile) Stat(ctx context.Context) (os.FileInfo, error) {
+	return QueryFileInfo(r.name), nil
+}
+
+func (r *ResultMetaFile) Open(ctx context.Context, flags int) (billy.File, error) {
+	data, err := r.render(ctx)
+	if err != nil {
+		return nil, err
+	}
+	return newBytesFile(data), nil
+}
+
+// ResultCountFile serves result.count, the number of rows the sibling
+// result files contain, without fetching or encoding them.
+type ResultCountFile struct {
+	count func(ctx context.Context) (int64, error)
+}
+
+func (r *ResultCountFile) Stat(ctx context.Context) (os.FileInfo, error) {
+	return QueryFileInfo("result.count"), nil
+}
+
+func (r *ResultCountFile) Open(ctx context.Context, flags int) (billy.File, error) {
+	rows, err := r.count(ctx)
+	if err != nil {
+		return nil, err
+	}
+	return newBytesFile([]byte(strconv.FormatInt(rows, 10) + "\n")), nil
+}
+
+// ResultStatsFile serves result.stats.csv, a summary of each column of the
+// sibling result files.
+type ResultStatsFile struct {
+	stats func(ctx context.Context) ([]byte, error)
+}
+
+func (r *ResultStatsFile) Stat(ctx context.Context) (os.FileInfo, error) {
+	return QueryFileInfo("result.stats.csv"), nil
+}
+
+func (r *ResultStatsFile) Open(ctx context.Context, flags int) (billy.File, error) {
+	data, err := r.stats(ctx)
+	if err != nil {
+		return nil, err
+	}
+	return newBytesFile(data), nil
+}
+
+func isResultMetaName(name string) bool {
+	return name == "result.sha256" || name == "manifest.json"
+}
@@ -0,0 +1,148 @@
+package vfs
+
+import (
+	"context"
+	"os"
+
+	"github.com/axiomhq/axiom-fs/internal/apl"
+)
+
+// MetaDir is /_meta: machine-readable references for editor plugins that
+// complete .apl files on the mount.
+type MetaDir struct {
+	root *Root
+}
+
+func (m *MetaDir) Stat(ctx context.Context) (os.FileInfo, error) {
+	return DirInfo("_meta"), nil
+}
+
+func (m *MetaDir) ReadDir(ctx context.Context) ([]os.FileInfo, error) {
+	return []os.FileInfo{DirInfo("apl"), DirInfo("datasets")}, nil
+}
+
+func (m *MetaDir) Lookup(ctx context.Context, name string) (Node, error) {
+	switch name {
+	case "apl":
+		return &MetaAPLDir{}, nil
+	case "datasets":
+		return &MetaDatasetsDir{root: m.root}, nil
+	default:
+		return nil, os.ErrNotExist
+	}
+}
+
+// MetaAPLDir is /_meta/apl. functions.json is the bundled catalog of
+// functions and tabular operators.
+type MetaAPLDir struct{}
+
+func (m *MetaAPLDir) Stat(ctx context.Context) (os.FileInfo, error) {
+	return DirInfo("apl"), nil
+}
+
+func (m *MetaAPLDir) ReadDir(ctx context.Context) ([]os.FileInfo, error) {
+	return []os.FileInfo{FileInfo("functions.json", 0)}, nil
+}
+
+func (m *MetaAPLDir) Lookup(ctx context.Context, name string) (Node, error) {
+	if name != "functions.json" {
+		return nil, os.ErrNotExist
+	}
+	return &StatusFile{name: name, build: func(ctx context.Context) (any, error) {
+		return apl.BundledCatalog(), nil
+	}}, nil
+}
+
+// MetaDatasetsDir is /_meta/datasets: a directory per visible dataset or
+// alias holding its fields.json.
+type MetaDatasetsDir struct {
+	root *Root
+}
+
+func (m *MetaDatasetsDir) Stat(ctx context.Context) (os.FileInfo, error) {
+	return DirInfo("datasets"), nil
+}
+
+func (m *MetaDatasetsDir) ReadDir(ctx context.Context) ([]os.FileInfo, error) {
+	datasets, err := m.root.listDatasets(ctx)
+	if err != nil {
+		return nil, err
+	}
+	entries := make([]os.FileInfo, 0, len(datasets))
+	for _, dataset := range datasets {
+		if dataset.Name != "" {
+			entries = append(entries, DirInfo(dataset.Name))
+		}
+	}
+	return entries, nil
+}
+
+func (m *MetaDatasetsDir) Lookup(ctx context.Context, name string) (Node, error) {
+	dataset, err := m.root.lookupDataset(ctx, name)
+	if err != nil {
+		return nil, err
+	}
+	if dataset == nil {
+		return nil, os.ErrNotExist
+	}
+	return &MetaDatasetDir{root: m.root, name: dataset.Name}, nil
+}
+
+// MetaDatasetDir is /_meta/datasets/<name>.
+type MetaDatasetDir struct {
+	root *Root
+	name string
+}
+
+// metaField is one entry of fields.json.
+type metaField struct {
+	Name        string `json:"name"`
+	Type        string `json:"type"`
+	Description string `json:"description,omitempty"`
+	Unit        string `json:"unit,omitempty"`
+	Hidden      bool   `json:"hidden,omitempty"`
+}
+
+// metaFields is fields.json.
+type metaFields struct {
+	Dataset string      `json:"dataset"`
+	Fields  []metaField `json:"fields"`
+}
+
+func (m *MetaDatasetDir) Stat(ctx context.Context) (os.FileInfo, error) {
+	return DirInfo(m.name), nil
+}
+
+func (m *MetaDatasetDir) ReadDir(ctx context.Context) ([]os.FileInfo, error) {
+	return []os.FileInfo{FileInfo("fields.json", 0)}, nil
+}
+
+func (m *MetaDatasetDir) Lookup(ctx context.Context, name string) (Node, error) {
+	if name != "fields.json" {
+		return nil, os.ErrNotExist
+	}
+	return &StatusFile{name: name, build: m.fields}, nil
+}
+
+// fields lists the dataset's fields as fields/ does, hidden ones only with
+// -include-hidden-fields.
+func (m *MetaDatasetDir) fields(ctx context.Context) (any, error) {
+	fields, err := m.root.fields().List(ctx, m.root.Client(), m.name)
+	if err != nil {
+		return nil, err
+	}
+	out := metaFields{Dataset: m.name, Fields: make([]metaField, 0, len(fields))}
+	for _, field := range fields {
+		if !m.root.fieldListed(m.name, field) {
+			continue
+		}
+		out.Fields = append(out.Fields, metaField{
+			Name:        field.Name,
+			Type:        field.Type,
+			Description: field.Description,
+			Unit:        field.Unit,
+			Hidden:      field.Hidden,
+		})
+	}
+	return out, nil
+}
@@ -0,0 +1,231 @@
+package vfs
+
+import (
+	"context"
+	"log/slog"
+	"os"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/axiomhq/axiom-fs/internal/axiomclient"
+	"github.com/axiomhq/axiom-fs/internal/events"
+)
+
+// MetadataStatus is served at /_status/metadata.json.
+type MetadataStatus struct {
+	TTL          string     `json:"ttl"`
+	PollInterval string     `json:"poll_interval,omitempty"`
+	Datasets     int        `json:"datasets"`
+	LastRefresh  *time.Time `json:"last_refresh,omitempty"`
+	LastPoll     *time.Time `json:"last_poll,omitempty"`
+	LastChange   *time.Time `json:"last_change,omitempty"`
+	LastError    string     `json:"last_error,omitempty"`
+	Changes      int64      `json:"changes"`
+}
+
+// metadataWatch records the dataset poller's progress.
+type metadataWatch struct {
+	mu         sync.Mutex
+	interval   time.Duration
+	lastPoll   time.Time
+	lastChange time.Time
+	lastErr    string
+	changes    int64
+}
+
+// WatchMetadata polls the dataset list every interval until ctx ends. When
+// a dataset was created or deleted, the dataset, field and stats caches are
+// invalidated so listings change without waiting for MetadataTTL. A zero
+// interval returns at once.
+func (r *Root) WatchMetadata(ctx context.Context, interval time.Duration) {
+	if interval <= 0 {
+		return
+	}
+	w := &r.fsys.watch
+	w.mu.Lock()
+	w.interval = interval
+	w.mu.Unlock()
+
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+		if _, err := r.RefreshMetadata(ctx); err != nil && ctx.Err() == nil {
+			slog.Debug("metadata poll failed", "error", err)
+		}
+	}
+}
+
+// RefreshMetadata fetches the dataset list and, when it differs from the
+// cached one, replaces it and drops cached fields and stats of the datasets
+// that came or went. It reports whether anything changed.
+func (r *Root) RefreshMetadata(ctx context.Context) (bool, error) {
+	datasets, err := r.fsys.Client.ListDatasets(ctx)
+
+	w := &r.fsys.watch
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	w.lastPoll = time.Now()
+	if err != nil {
+		w.lastErr = err.Error()
+		return false, err
+	}
+	w.lastErr = ""
+
+	changed := r.fsys.datasets.replace(datasets)
+	if len(changed) == 0 {
+		return false, nil
+	}
+	current := make(map[string]bool, len(datasets))
+	for _, d := range datasets {
+		current[d.Name] = true
+	}
+	for _, name := range changed {
+		if !r.buried(name) {
+			r.fsys.fields.invalidate(name)
+		}
+		event := events.DatasetDeleted
+		if current[name] {
+			event = events.DatasetCreated
+		}
+		r.fsys.Events.Publish(events.Event{Type: event, Dataset: name})
+	}
+	r.fsys.stats.invalidate()
+	w.lastChange = w.lastPoll
+	w.changes++
+	slog.Info("datasets changed", "datasets", changed)
+	return true, nil
+}
+
+func (r *Root) metadataStatus() MetadataStatus {
+	w := &r.fsys.watch
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	status := MetadataStatus{
+		TTL:       r.fsys.Config.MetadataTTL.String(),
+		LastError: w.lastErr,
+		Changes:   w.changes,
+	}
+	if w.interval > 0 {
+		status.PollInterval = w.interval.String()
+	}
+	status.Datasets, status.LastRefresh = r.fsys.datasets.info()
+	status.LastPoll = timePtr(w.lastPoll)
+	status.LastChange = timePtr(w.lastChange)
+	return status
+}
+
+func timePtr(t time.Time) *time.Time {
+	if t.IsZero() {
+		return nil
+	}
+	return &t
+}
+
+// replace swaps in a freshly fetched dataset list and returns the names of
+// datasets that were added or removed. The first list it sees counts as no
+// change.
+func (c *datasetCache) replace(datasets []axiomclient.Dataset) []string {
+	c.mu.Lock()
+	old := c.datasets
+	c.datasets = datasets
+	c.fetched = time.Now()
+	c.refreshErr = ""
+	c.fromDisk = false
+	c.mu.Unlock()
+	if err := c.saveDisk(datasets); err != nil {
+		slog.Warn("failed to cache datasets", "error", err)
+	}
+	if old == nil {
+		return nil
+	}
+	if c.changed != nil {
+		c.changed(old, datasets)
+	}
+
+	before := make(map[string]bool, len(old))
+	for _, d := range old {
+		before[d.Name] = true
+	}
+	var changed []string
+	for _, d := range datasets {
+		if !before[d.Name] {
+			changed = append(changed, d.Name)
+		}
+		delete(before, d.Name)
+	}
+	for name := range before {
+		changed = append(changed, name)
+	}
+	sort.Strings(changed)
+	return changed
+}
+
+// StaleStatus is the .stale marker listed next to datasets while the
+// dataset list served is past -metadata-ttl.
+type StaleStatus struct {
+	FetchedAt  time.Time `json:"fetched_at"`
+	AgeSeconds float64   `json:"age_seconds"`
+	// MaxStale is how long past the TTL the list is served before listings
+	// wait for Axiom again.
+	MaxStale  string `json:"max_stale"`
+	LastError string `json:"last_error,omitempty"`
+}
+
+// staleness describes the cached list when it is past ttl.
+func (c *datasetCache) staleness() (StaleStatus, bool) {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	age := time.Since(c.fetched)
+	if len(c.datasets) == 0 || age < c.ttl {
+		return StaleStatus{}, false
+	}
+	return StaleStatus{
+		FetchedAt:  c.fetched.UTC(),
+		AgeSeconds: age.Seconds(),
+		MaxStale:   c.maxStale.String(),
+		LastError:  c.refreshErr,
+	}, true
+}
+
+// staleFile is the .stale marker of a dataset listing, if it is stale.
+func (r *Root) staleFile() (Node, bool) {
+	if _, stale := r.fsys.datasets.staleness(); !stale {
+		return nil, false
+	}
+	return &StatusFile{name: ".stale", build: func(ctx context.Context) (any, error) {
+		status, _ := r.fsys.datasets.staleness()
+		return status, nil
+	}}, true
+}
+
+// info returns the number of cached datasets and when they were fetched.
+func (c *datasetCache) info() (int, *time.Time) {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	return len(c.datasets), timePtr(c.fetched)
+}
+
+// invalidate drops dataset's cached fields from memory and disk.
+func (c *fieldCache) invalidate(dataset string) {
+	c.mu.Lock()
+	delete(c.fields, dataset)
+	delete(c.fetched, dataset)
+	delete(c.index, dataset)
+	c.mu.Unlock()
+	if path := c.diskPath(dataset); path != "" {
+		_ = os.Remove(path)
+	}
+}
+
+// invalidate makes the next lookup refetch dataset stats.
+func (c *statsCache) invalidate() {
+	c.mu.Lock()
+	c.stats = nil
+	c.mu.Unlock()
+}
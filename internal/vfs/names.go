@@ -0,0 +1,102 @@
+package vfs
+
+import (
+	"fmt"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+
+	"github.com/axiomhq/axiom-fs/internal/axiomclient"
+)
+
+// fieldNamesFile maps encoded field directory names back to field names.
+const fieldNamesFile = ".names.json"
+
+// encodeFieldName returns the fields/ directory name of a field. '%', '/',
+// control characters and invalid UTF-8 are percent-encoded, as is the
+// leading dot of names that would be special in a directory ("." and
+// "..") or taken by fields/ itself. Ordinary names are unchanged, and
+// decodeFieldName reverses the encoding.
+func encodeFieldName(name string) string {
+	if !needsEncoding(name) {
+		return name
+	}
+	var b strings.Builder
+	for i := 0; i < len(name); {
+		r, size := utf8.DecodeRuneInString(name[i:])
+		switch {
+		case r == utf8.RuneError && size <= 1, r == '%', r == '/', unicode.IsControl(r), i == 0 && r == '.' && reservedFieldName(name):
+			for _, c := range []byte(name[i : i+size]) {
+				fmt.Fprintf(&b, "%%%02X", c)
+			}
+		default:
+			b.WriteString(name[i : i+size])
+		}
+		i += size
+	}
+	return b.String()
+}
+
+func needsEncoding(name string) bool {
+	if reservedFieldName(name) || !utf8.ValidString(name) {
+		return true
+	}
+	return strings.ContainsFunc(name, func(r rune) bool {
+		return r == '%' || r == '/' || unicode.IsControl(r)
+	})
+}
+
+func reservedFieldName(name string) bool {
+	switch name {
+	case ".", "..", ".hidden", fieldNamesFile:
+		return true
+	default:
+		return false
+	}
+}
+
+// decodeFieldName returns the field a fields/ directory name stands for.
+// Only the canonical encoding is accepted, so each field has one name.
+func decodeFieldName(entry string) (string, bool) {
+	if !strings.Contains(entry, "%") {
+		return entry, !needsEncoding(entry)
+	}
+	var b strings.Builder
+	for i := 0; i < len(entry); i++ {
+		if entry[i] != '%' {
+			b.WriteByte(entry[i])
+			continue
+		}
+		if i+2 >= len(entry) || !isHex(entry[i+1]) || !isHex(entry[i+2]) {
+			return "", false
+		}
+		b.WriteByte(unhex(entry[i+1])<<4 | unhex(entry[i+2]))
+		i += 2
+	}
+	name := b.String()
+	return name, encodeFieldName(name) == entry
+}
+
+func isHex(c byte) bool {
+	return c >= '0' && c <= '9' || c >= 'A' && c <= 'F'
+}
+
+func unhex(c byte) byte {
+	if c <= '9' {
+		return c - '0'
+	}
+	return c - 'A' + 10
+}
+
+// encodedFieldNames is served as .names.json: each encoded directory name
+// mapped to its field name. Fields listed under their own name are left
+// out.
+func encodedFieldNames(fields []axiomclient.Field) map[string]string {
+	names := map[string]string{}
+	for _, field := range fields {
+		if entry := encodeFieldName(field.Name); entry != field.Name {
+			names[entry] = field.Name
+		}
+	}
+	return names
+}
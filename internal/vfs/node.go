@@ -6,6 +6,8 @@ import (
 	"time"
 
 	"github.com/go-git/go-billy/v5"
+
+	"github.com/axiomhq/axiom-fs/internal/query"
 )
 
 // stableModTime is used for virtual files/dirs to prevent NFS client revalidation storms.
@@ -32,12 +34,25 @@ type Writable interface {
 	Create(ctx context.Context) (billy.File, error)
 }
 
+// Touchable is implemented by files that act on `touch`: saved query
+// results drop their cached copy so the next read runs the query again.
+type Touchable interface {
+	File
+	Touch(ctx context.Context) error
+}
+
 type virtualFileInfo struct {
 	name    string
 	size    int64
 	mode    os.FileMode
 	modTime time.Time
 	isDir   bool
+	// dynamic marks a generated file whose size is unknown until it is
+	// opened; see DynamicFileInfo.
+	dynamic bool
+	// query marks a dynamic file whose content is an Axiom query's; see
+	// QueryFileInfo.
+	query bool
 }
 
 func (v *virtualFileInfo) Name() string       { return v.name }
@@ -56,6 +71,21 @@ func DirInfo(name string) os.FileInfo {
 	}
 }
 
+// DirInfoAt returns directory info with an explicit size and mtime, used for
+// dataset directories backed by ingest activity.
+func DirInfoAt(name string, size int64, modTime time.Time) os.FileInfo {
+	if modTime.IsZero() {
+		modTime = stableModTime
+	}
+	return &virtualFileInfo{
+		name:    name,
+		size:    size,
+		mode:    os.ModeDir | 0o555,
+		modTime: modTime,
+		isDir:   true,
+	}
+}
+
 func FileInfo(name string, size int64) os.FileInfo {
 	return &virtualFileInfo{
 		name:    name,
@@ -65,19 +95,67 @@ func FileInfo(name string, size int64) os.FileInfo {
 	}
 }
 
-// DynamicFileInfo returns a FileInfo with a reasonable placeholder size.
-// NFS requires a non-zero size to trigger reads for dynamically generated content.
-// We use a moderate size that's large enough for most results but won't cause
-// excessive read attempts.
+// FileInfoAt returns read-only file info with an explicit mtime, used for
+// result files whose mtime reflects when the query was executed.
+func FileInfoAt(name string, size int64, modTime time.Time) os.FileInfo {
+	if modTime.IsZero() {
+		modTime = stableModTime
+	}
+	return &virtualFileInfo{
+		name:    name,
+		size:    size,
+		mode:    0o444,
+		modTime: modTime,
+	}
+}
+
+// DynamicFileInfo returns info for a generated file whose size is only
+// known once it is opened. It reports 0 bytes and is marked so frontends
+// can size it on demand; see IsDynamic.
 func DynamicFileInfo(name string) os.FileInfo {
 	return &virtualFileInfo{
 		name:    name,
-		size:    64 * 1024 * 1024, // 64MB placeholder
 		mode:    0o444,
 		modTime: stableModTime,
+		dynamic: true,
 	}
 }
 
+// IsDynamic reports whether info came from DynamicFileInfo or
+// QueryFileInfo, so its size is not the size of the file's content.
+func IsDynamic(info os.FileInfo) bool {
+	v, ok := info.(*virtualFileInfo)
+	return ok && v.dynamic
+}
+
+// QueryFileInfo returns info for a generated file whose content comes from
+// running an Axiom query. Like DynamicFileInfo it reports 0 bytes, but
+// opening it to learn its size runs the query, so frontends size it with
+// an Estimator instead; see IsQueryBacked.
+func QueryFileInfo(name string) os.FileInfo {
+	return &virtualFileInfo{
+		name:    name,
+		mode:    0o444,
+		modTime: stableModTime,
+		dynamic: true,
+		query:   true,
+	}
+}
+
+// IsQueryBacked reports whether info came from QueryFileInfo.
+func IsQueryBacked(info os.FileInfo) bool {
+	v, ok := info.(*virtualFileInfo)
+	return ok && v.query
+}
+
+// Estimator is implemented by query-backed result files, which can be
+// sized without running their query: exactly once it has run, from a
+// count probe before; see query.Executor.EstimateResult.
+type Estimator interface {
+	File
+	Estimate(ctx context.Context) (query.ResultEstimate, error)
+}
+
 func WritableFileInfo(name string, size int64) os.FileInfo {
 	return &virtualFileInfo{
 		name:    name,
@@ -0,0 +1,91 @@
+package vfs
+
+import (
+	"context"
+	"os"
+
+	"github.com/axiomhq/axiom-fs/internal/axiomclient"
+)
+
+// OrgClient is implemented by clients that can describe their org and
+// tokens. org.json, limits.json and tokens.json are only served for them.
+type OrgClient interface {
+	Org(ctx context.Context) (*axiomclient.Org, error)
+	Limits(ctx context.Context) (*axiomclient.Limits, error)
+	ListTokens(ctx context.Context) ([]axiomclient.Token, error)
+	TokenInfo() axiomclient.TokenInfo
+}
+
+// OrgDir is /_org, the identity the mount runs as.
+type OrgDir struct {
+	root *Root
+}
+
+// tokensReport is served as tokens.json. Tokens the mount's token may not
+// list are reported in Error rather than failing the read.
+type tokensReport struct {
+	Current axiomclient.TokenInfo `json:"current"`
+	Tokens  []axiomclient.Token   `json:"tokens"`
+	Error   string                `json:"error,omitempty"`
+}
+
+func (o *OrgDir) client() (OrgClient, bool) {
+	client, ok := o.root.Client().(OrgClient)
+	return client, ok
+}
+
+func (o *OrgDir) Stat(ctx context.Context) (os.FileInfo, error) {
+	return DirInfo("_org"), nil
+}
+
+func (o *OrgDir) ReadDir(ctx context.Context) ([]os.FileInfo, error) {
+	entries := []os.FileInfo{FileInfo("user.json", 0)}
+	if _, ok := o.client(); ok {
+		entries = append(entries,
+			FileInfo("org.json", 0),
+			FileInfo("limits.json", 0),
+			FileInfo("tokens.json", 0),
+		)
+	}
+	return entries, nil
+}
+
+func (o *OrgDir) Lookup(ctx context.Context, name string) (Node, error) {
+	switch name {
+	case "user.json":
+		return &StatusFile{name: name, build: func(ctx context.Context) (any, error) {
+			return o.root.Client().CurrentUser(ctx)
+		}}, nil
+	case "org.json", "limits.json", "tokens.json":
+		client, ok := o.client()
+		if !ok {
+			return nil, os.ErrNotExist
+		}
+		return &StatusFile{name: name, build: func(ctx context.Context) (any, error) {
+			return buildOrgFile(ctx, client, name)
+		}}, nil
+	default:
+		if node, ok := lookupErrorFile(ctx, o, name); ok {
+			return node, nil
+		}
+		return nil, os.ErrNotExist
+	}
+}
+
+func buildOrgFile(ctx context.Context, client OrgClient, name string) (any, error) {
+	switch name {
+	case "org.json":
+		return client.Org(ctx)
+	case "limits.json":
+		return client.Limits(ctx)
+	default:
+		report := tokensReport{Current: client.TokenInfo(), Tokens: []axiomclient.Token{}}
+		tokens, err := client.ListTokens(ctx)
+		if err != nil {
+			report.Error = err.Error()
+		} else if tokens != nil {
+			report.Tokens = tokens
+		}
+		return report, nil
+	}
+}
@@ -0,0 +1,146 @@
+package vfs
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/go-git/go-billy/v5"
+
+	"github.com/axiomhq/axiom-fs/internal/apl"
+	"github.com/axiomhq/axiom-fs/internal/compiler"
+)
+
+// queryPlan is served as plan.json, and as plan.txt for people: the APL a
+// query directory runs, stage by stage, with why each stage is there.
+type queryPlan struct {
+	Dataset string           `json:"dataset,omitempty"`
+	Format  string           `json:"format,omitempty"`
+	APL     string           `json:"apl"`
+	Stages  []compiler.Stage `json:"stages"`
+	// Notes apply to the whole query.
+	Notes []string `json:"notes,omitempty"`
+}
+
+func isPlanName(name string) bool {
+	return name == "plan.txt" || name == "plan.json"
+}
+
+// PlanFile renders a query plan as plan.txt or plan.json.
+type PlanFile struct {
+	name string
+	plan func(ctx context.Context) (queryPlan, error)
+}
+
+func (p *PlanFile) Stat(ctx context.Context) (os.FileInfo, error) {
+	return DynamicFileInfo(p.name), nil
+}
+
+func (p *PlanFile) Open(ctx context.Context, flags int) (billy.File, error) {
+	if p.name == "plan.json" {
+		return (&StatusFile{name: p.name, build: func(ctx context.Context) (any, error) {
+			return p.plan(ctx)
+		}}).Open(ctx, flags)
+	}
+	plan, err := p.plan(ctx)
+	if err != nil {
+		return nil, err
+	}
+	return newBytesFile([]byte(plan.text())), nil
+}
+
+// text lays the plan out as numbered APL stages, each followed by the path
+// segment that added it and its note; stages applied after execution come
+// last.
+func (p queryPlan) text() string {
+	var b strings.Builder
+	n := 0
+	for _, stage := range p.Stages {
+		if stage.APL == "" {
+			continue
+		}
+		n++
+		step := stage.APL
+		if n > 1 {
+			step = "| " + step
+		}
+		fmt.Fprintf(&b, "%d. %s\n", n, step)
+		writePlanDetail(&b, stage)
+	}
+	for _, stage := range p.Stages {
+		if stage.APL != "" {
+			continue
+		}
+		b.WriteString("after execution:\n")
+		writePlanDetail(&b, stage)
+	}
+	if p.Format != "" {
+		b.WriteString("format: " + p.Format + "\n")
+	}
+	for _, note := range p.Notes {
+		b.WriteString("note: " + note + "\n")
+	}
+	return b.String()
+}
+
+func writePlanDetail(b *strings.Builder, stage compiler.Stage) {
+	if stage.Segment != "" {
+		b.WriteString("   from " + stage.Segment + "/\n")
+	}
+	if stage.Note != "" {
+		b.WriteString("   " + stage.Note + "\n")
+	}
+}
+
+// queryPathPlan is the plan of a q/ directory.
+func (r *Root) queryPathPlan(ctx context.Context, dataset string, segments []string) (queryPlan, error) {
+	compiled, err := compilePath(dataset, segments, r.datasetConfig(dataset), r.distinctFields(ctx, dataset, segments))
+	if err != nil {
+		return queryPlan{}, fmt.Errorf("%w: %w", os.ErrInvalid, err)
+	}
+	return queryPlan{
+		Dataset: dataset,
+		Format:  compiled.Format,
+		APL:     compiled.APL,
+		Stages:  compiled.Stages,
+		Notes:   r.snapshotNotes(),
+	}, nil
+}
+
+// savedQueryPlan is the plan of a saved query: its APL split at top-level
+// pipes, with #include directives expanded.
+func (r *Root) savedQueryPlan(name string) (queryPlan, error) {
+	src, err := r.savedAPL(name)
+	if err != nil {
+		return queryPlan{}, err
+	}
+	plan := queryPlan{
+		Dataset: apl.Dataset(src),
+		APL:     src,
+		Stages:  []compiler.Stage{},
+		Notes:   append([]string{"saved APL runs as written: no default range or limit is added"}, r.snapshotNotes()...),
+	}
+	for i, stage := range apl.Stages(apl.Tokenize(src)) {
+		code := apl.Join(stage)
+		if code == "" {
+			continue
+		}
+		note := ""
+		if i == 0 {
+			note = "source"
+		}
+		plan.Stages = append(plan.Stages, compiler.Stage{APL: code, Note: note})
+	}
+	return plan, nil
+}
+
+func (r *Root) snapshotNotes() []string {
+	cfg := r.Config()
+	if !cfg.Snapshot() {
+		return nil
+	}
+	return []string{"snapshot mount: every query is pinned to " +
+		cfg.SnapshotFrom.UTC().Format(time.RFC3339) + " .. " + cfg.SnapshotTo.UTC().Format(time.RFC3339)}
+}
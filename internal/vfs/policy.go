@@ -0,0 +1,37 @@
+package vfs
+
+import (
+	"context"
+	"os"
+
+	"github.com/axiomhq/axiom-fs/internal/redact"
+)
+
+// PolicyDir is /_policy: read-only views of the mount policy in effect.
+type PolicyDir struct {
+	root *Root
+}
+
+// redactionStatus is /_policy/redaction.json.
+type redactionStatus struct {
+	Rules   []redact.Rule       `json:"rules"`
+	Columns []redact.ColumnRule `json:"columns"`
+}
+
+func (p *PolicyDir) Stat(ctx context.Context) (os.FileInfo, error) {
+	return DirInfo("_policy"), nil
+}
+
+func (p *PolicyDir) ReadDir(ctx context.Context) ([]os.FileInfo, error) {
+	return []os.FileInfo{FileInfo("redaction.json", 0)}, nil
+}
+
+func (p *PolicyDir) Lookup(ctx context.Context, name string) (Node, error) {
+	if name != "redaction.json" {
+		return nil, os.ErrNotExist
+	}
+	return &StatusFile{name: name, build: func(ctx context.Context) (any, error) {
+		redactor := p.root.fsys.Redactor
+		return redactionStatus{Rules: redactor.Rules(), Columns: redactor.Columns()}, nil
+	}}, nil
+}
@@ -10,6 +10,7 @@ import (
 	"github.com/go-git/go-billy/v5"
 
 	"github.com/axiomhq/axiom-fs/internal/axiomclient"
+	"github.com/axiomhq/axiom-fs/internal/config"
 	"github.com/axiomhq/axiom-fs/internal/presets"
 	"github.com/axiomhq/axiom-fs/internal/query"
 )
@@ -60,35 +61,75 @@ func (p *DatasetPresetsDir) ReadDir(ctx context.Context) ([]os.FileInfo, error)
 }
 
 func (p *DatasetPresetsDir) Lookup(ctx context.Context, name string) (Node, error) {
+	if node, ok := lookupErrorFile(ctx, p, name); ok {
+		return node, nil
+	}
 	base := strings.TrimSuffix(name, path.Ext(name))
 	ext := strings.TrimPrefix(path.Ext(name), ".")
 	for _, preset := range presets.PresetsForDataset(p.dataset) {
-		if preset.Name == base && preset.Format == ext {
-			return &PresetResultFile{root: p.root, dataset: p.dataset, preset: preset}, nil
+		if preset.Name == base && (preset.Format == ext || isExportFormat(ext)) {
+			return &PresetResultFile{root: p.root, dataset: p.dataset, preset: preset, format: ext}, nil
 		}
 	}
 	return nil, os.ErrNotExist
 }
 
+// isExportFormat reports whether a preset may also be read in format, e.g.
+// errors.xlsx next to the listed errors.csv.
+func isExportFormat(format string) bool {
+	switch format {
+	case "csv", "tsv", "json", "ndjson", "xlsx", "md":
+		return true
+	default:
+		return false
+	}
+}
+
 type PresetResultFile struct {
 	root    *Root
 	dataset *axiomclient.Dataset
 	preset  presets.Preset
+	format  string
 }
 
 func (p *PresetResultFile) Stat(ctx context.Context) (os.FileInfo, error) {
-	return FileInfo(p.preset.Name+"."+p.preset.Format, 0), nil
+	name := p.preset.Name + "." + p.format
+	if known, ok := p.root.fsys.presetSizes.get(p.sizeKey()); ok {
+		return FileInfoAt(name, known.size, known.modTime), nil
+	}
+	return FileInfo(name, 0), nil
 }
 
 func (p *PresetResultFile) Open(ctx context.Context, flags int) (billy.File, error) {
-	apl := presets.Render(p.preset, p.dataset.Name, p.root.Config().DefaultRange)
-	result, err := p.root.Executor().ExecuteAPLResult(ctx, apl, p.preset.Format, query.ExecOptions{
+	result, err := p.execute(ctx)
+	if err != nil {
+		return nil, err
+	}
+	return openResult(result)
+}
+
+// execute runs the preset, or reuses its cached result, and records the
+// result's size for Stat.
+func (p *PresetResultFile) execute(ctx context.Context) (query.ResultData, error) {
+	cfg := p.root.datasetConfig(p.dataset.Name)
+	apl := presets.RenderSource(p.preset, p.root.source(p.dataset.Name), cfg.DefaultRange)
+	result, err := p.root.Executor().ExecuteAPLResult(ctx, apl, p.format, query.ExecOptions{
 		UseCache:        true,
 		EnsureTimeRange: true,
 		EnsureLimit:     true,
+		DefaultRange:    cfg.DefaultRange,
+		DefaultLimit:    cfg.DefaultLimit,
+		Headers:         queryLabel(p.dataset.Name, "presets", p.preset.Name+"."+p.format),
+		CacheOnly:       p.root.buried(p.dataset.Name),
+		MaxStale:        cfg.MaxStale(config.StalePathPresets),
 	})
 	if err != nil {
-		return nil, err
+		return query.ResultData{}, err
 	}
-	return openResult(result)
+	p.root.fsys.presetSizes.set(p.sizeKey(), result.Size, result.ModTime)
+	return result, nil
+}
+
+func (p *PresetResultFile) sizeKey() string {
+	return p.dataset.Name + "/" + p.preset.Name + "." + p.format
 }
@@ -0,0 +1,91 @@
+package vfs
+
+import (
+	"context"
+	"log/slog"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/axiomhq/axiom-fs/internal/presets"
+)
+
+// presetSizes are the sizes of preset results seen so far, from SizePresets
+// or from reading them, so Stat reports them instead of 0: some tools skip
+// empty files.
+type presetSizes struct {
+	mu    sync.Mutex
+	sizes map[string]presetSize
+}
+
+type presetSize struct {
+	size    int64
+	modTime time.Time
+}
+
+func (s *presetSizes) get(key string) (presetSize, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	size, ok := s.sizes[key]
+	return size, ok
+}
+
+func (s *presetSizes) set(key string, size int64, modTime time.Time) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.sizes == nil {
+		s.sizes = make(map[string]presetSize)
+	}
+	s.sizes[key] = presetSize{size: size, modTime: modTime}
+}
+
+// SizePresets runs the cheap presets of every dataset once, one query per
+// interval, so their files have a size before anyone reads them. Presets
+// already read are skipped. A zero interval returns at once.
+func (r *Root) SizePresets(ctx context.Context, interval time.Duration) {
+	if interval <= 0 {
+		return
+	}
+	datasets, err := r.listDatasets(ctx)
+	if err != nil {
+		slog.Debug("preset sizing skipped", "error", err)
+		return
+	}
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for i := range datasets {
+		if datasets[i].Name == "" {
+			continue
+		}
+		for _, preset := range cheapPresets(presets.PresetsForDataset(&datasets[i])) {
+			file := &PresetResultFile{root: r, dataset: &datasets[i], preset: preset, format: preset.Format}
+			if _, ok := r.fsys.presetSizes.get(file.sizeKey()); ok {
+				continue
+			}
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+			}
+			result, err := file.execute(ctx)
+			if err != nil {
+				slog.Debug("preset sizing failed", "dataset", datasets[i].Name, "preset", preset.Name, "error", err)
+				continue
+			}
+			result.Release()
+		}
+	}
+}
+
+// cheapPresets are the presets that aggregate, whose results are a few
+// rows however busy the dataset; presets listing raw events are left to
+// their first read.
+func cheapPresets(list []presets.Preset) []presets.Preset {
+	var cheap []presets.Preset
+	for _, preset := range list {
+		if strings.Contains(preset.Template, "| summarize ") {
+			cheap = append(cheap, preset)
+		}
+	}
+	return cheap
+}
@@ -0,0 +1,168 @@
+package vfs
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"io"
+	"os"
+	"sync"
+
+	"github.com/go-git/go-billy/v5"
+
+	"github.com/axiomhq/axiom-fs/internal/compiler"
+	"github.com/axiomhq/axiom-fs/internal/query"
+)
+
+// headRunner is implemented by executors that can serve the start of a
+// result from a take-limited probe query.
+type headRunner interface {
+	ExecuteAPLHead(ctx context.Context, apl, format string, rows int, opts query.ExecOptions) (query.ResultData, bool, error)
+}
+
+// probeable reports whether reads of compiled's result may start from a
+// -read-probe-rows probe: its first rows must encode to a prefix of the
+// full result, which natural sorting, flattening and auto-range break.
+func probeable(compiled compiler.Query) bool {
+	switch compiled.Format {
+	case "ndjson", "csv", "tsv":
+		return compiled.NaturalSort == nil && compiled.Flatten == "" && !compiled.AutoRange
+	default:
+		return false
+	}
+}
+
+// probedFile is a result file whose reads are served from a probe of its
+// first rows until they go past the probe's end, when the full query runs.
+// go-nfs opens a file for every READ call, so `file` or a preview reading
+// the first few KB never runs the full query.
+//
+// It does not know its size, so the file keeps the size Stat reported. A
+// full result that does not start with the probe fails reads with ESTALE.
+type probedFile struct {
+	head func(ctx context.Context) (query.ResultData, bool, error)
+	full func(ctx context.Context) (billy.File, error)
+
+	mu     sync.Mutex
+	offset int64
+	// probe is the probe's result, or the full one once complete.
+	probe    billy.File
+	size     int64
+	complete bool
+	opened   billy.File
+}
+
+func (f *probedFile) Name() string { return "" }
+
+// file returns what serves a read of n bytes at off.
+func (f *probedFile) file(off int64, n int) (billy.File, error) {
+	if f.opened != nil {
+		return f.opened, nil
+	}
+	ctx := context.Background()
+	if f.probe == nil {
+		result, complete, err := f.head(ctx)
+		if err != nil {
+			return nil, err
+		}
+		if f.probe, err = openResult(result); err != nil {
+			return nil, err
+		}
+		f.size, f.complete = result.Size, complete
+	}
+	if f.complete || off+int64(n) <= f.size {
+		return f.probe, nil
+	}
+	opened, err := f.full(ctx)
+	if err != nil {
+		return nil, err
+	}
+	if err := samePrefix(f.probe, opened, f.size); err != nil {
+		_ = opened.Close()
+		return nil, err
+	}
+	f.opened = opened
+	return opened, nil
+}
+
+// samePrefix fails with ESTALE unless full starts with the n bytes of
+// probe. The full query runs after the probe, so new events or a result
+// without a stable order would otherwise splice two results into one
+// read.
+func samePrefix(probe, full io.ReaderAt, n int64) error {
+	want, err := io.ReadAll(io.NewSectionReader(probe, 0, n))
+	if err != nil {
+		return err
+	}
+	got := make([]byte, len(want))
+	if m, err := full.ReadAt(got, 0); m < len(got) || !bytes.Equal(got, want) {
+		if err != nil && !errors.Is(err, io.EOF) {
+			return err
+		}
+		return errProbeChanged
+	}
+	return nil
+}
+
+func (f *probedFile) ReadAt(p []byte, off int64) (int, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	file, err := f.file(off, len(p))
+	if err != nil {
+		return 0, err
+	}
+	return file.ReadAt(p, off)
+}
+
+func (f *probedFile) Read(p []byte) (int, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	file, err := f.file(f.offset, len(p))
+	if err != nil {
+		return 0, err
+	}
+	n, err := file.ReadAt(p, f.offset)
+	f.offset += int64(n)
+	if errors.Is(err, io.EOF) && n > 0 {
+		err = nil
+	}
+	return n, err
+}
+
+func (f *probedFile) Seek(offset int64, whence int) (int64, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	switch whence {
+	case io.SeekStart:
+	case io.SeekCurrent:
+		offset += f.offset
+	default:
+		return 0, os.ErrInvalid
+	}
+	if offset < 0 {
+		return 0, os.ErrInvalid
+	}
+	f.offset = offset
+	return offset, nil
+}
+
+func (f *probedFile) Write(p []byte) (int, error) {
+	return 0, os.ErrPermission
+}
+
+func (f *probedFile) Close() error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	for _, file := range []billy.File{f.probe, f.opened} {
+		if file != nil {
+			_ = file.Close()
+		}
+	}
+	return nil
+}
+
+func (f *probedFile) Lock() error   { return nil }
+func (f *probedFile) Unlock() error { return nil }
+func (f *probedFile) Truncate(size int64) error {
+	return os.ErrPermission
+}
@@ -5,16 +5,53 @@ import (
 	"encoding/json"
 	"os"
 	"sort"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
 
 	"github.com/go-git/go-billy/v5"
 
+	"github.com/axiomhq/axiom-fs/internal/apl"
+	"github.com/axiomhq/axiom-fs/internal/compiler"
+	"github.com/axiomhq/axiom-fs/internal/config"
 	"github.com/axiomhq/axiom-fs/internal/query"
 )
 
+// cacheInvalidator is implemented by executors that can drop the cached
+// results of one query.
+type cacheInvalidator interface {
+	InvalidateAPL(apl string) int
+}
+
 type QueriesDir struct {
 	root *Root
 }
 
+// QuerySource is implemented by nodes whose content comes from APL compiled
+// from their path: q/ directories and their result files.
+type QuerySource interface {
+	QueryAPL(ctx context.Context) (string, error)
+}
+
+// SaveQuery stores the APL behind src as the saved query name. It is how
+// `mv <dataset>/q/.../result.csv /_queries/<name>/` keeps a path query.
+func (r *Root) SaveQuery(ctx context.Context, name string, src Node) error {
+	if !isValidQueryName(name) {
+		return os.ErrInvalid
+	}
+	source, ok := src.(QuerySource)
+	if !ok {
+		return syscall.EROFS
+	}
+	apl, err := source.QueryAPL(ctx)
+	if err != nil {
+		return err
+	}
+	r.Store().Set(name, []byte(apl+"\n"))
+	return nil
+}
+
 func (q *QueriesDir) Stat(ctx context.Context) (os.FileInfo, error) {
 	return DirInfo("_queries"), nil
 }
@@ -33,9 +70,45 @@ func (q *QueriesDir) Lookup(ctx context.Context, name string) (Node, error) {
 	if !isValidQueryName(name) {
 		return nil, os.ErrNotExist
 	}
+	if stem, ok := importedQueryName(name); ok {
+		return &APLImportFile{root: q.root, name: stem, file: name}, nil
+	}
 	return &QueryEntryDir{root: q.root, name: name}, nil
 }
 
+// importedQueryName returns the query a file copied into /_queries saves:
+// name without its .apl or .kql extension.
+func importedQueryName(name string) (string, bool) {
+	for _, ext := range []string{".apl", ".kql"} {
+		if stem, ok := strings.CutSuffix(name, ext); ok && isValidQueryName(stem) {
+			return stem, true
+		}
+	}
+	return "", false
+}
+
+// APLImportFile is /_queries/<name>.apl (or .kql): `cp errors.apl
+// /mnt/axiom/_queries/` saves the file as the query errors, as if written to
+// /_queries/errors/apl. Reading it returns that query's APL. It is not
+// listed; the query's directory is.
+type APLImportFile struct {
+	root *Root
+	name string
+	file string
+}
+
+func (a *APLImportFile) Stat(ctx context.Context) (os.FileInfo, error) {
+	return WritableFileInfo(a.file, int64(len(a.root.Store().Get(a.name)))), nil
+}
+
+func (a *APLImportFile) Open(ctx context.Context, flags int) (billy.File, error) {
+	return newBytesFile(a.root.Store().Get(a.name)), nil
+}
+
+func (a *APLImportFile) Create(ctx context.Context) (billy.File, error) {
+	return newAPLFile(a.root.Store(), a.name), nil
+}
+
 type QueryEntryDir struct {
 	root *Root
 	name string
@@ -52,12 +125,36 @@ func (q *QueryEntryDir) ReadDir(ctx context.Context) ([]os.FileInfo, error) {
 	aplData := q.root.Store().Get(q.name)
 	return []os.FileInfo{
 		WritableFileInfo("apl", int64(len(aplData))),
+		WritableFileInfo("vars", int64(len(q.root.Vars().Get(q.name)))),
+		FileInfo("apl.fmt", 0),
+		FileInfo("lint.json", 0),
+		FileInfo("result", 0),
 		FileInfo("result.ndjson", 0),
+		FileInfo("result.jsonl", 0),
 		FileInfo("result.csv", 0),
 		FileInfo("result.json", 0),
+		FileInfo("result.tsv", 0),
+		FileInfo("result.xlsx", 0),
+		FileInfo("result.vl.json", 0),
+		FileInfo("result.svg", 0),
+		FileInfo("result.md", 0),
 		FileInfo("result.error", 0),
+		FileInfo("result.count", 0),
+		FileInfo("result.stats.csv", 0),
+		FileInfo("result.sha256", 0),
+		FileInfo("manifest.json", 0),
 		FileInfo("schema.csv", 0),
 		FileInfo("stats.json", 0),
+		FileInfo("plan.txt", 0),
+		FileInfo("plan.json", 0),
+		FileInfo("open.url", 0),
+		FileInfo("link.txt", 0),
+		DirInfo("cols"),
+		DirInfo("tables"),
+		DirInfo("snapshot"),
+		WritableFileInfo("snapshot.trigger", 0),
+		WritableFileInfo("export.dest", 0),
+		FileInfo("export.status", 0),
 	}, nil
 }
 
@@ -68,23 +165,151 @@ func (q *QueryEntryDir) Lookup(ctx context.Context, name string) (Node, error) {
 	switch name {
 	case "apl":
 		return &APLFile{root: q.root, name: q.name}, nil
+	case "vars":
+		return &VarsFile{root: q.root, name: q.name}, nil
+	case "apl.fmt":
+		return &APLFormatFile{root: q.root, name: q.name}, nil
+	case "lint.json":
+		return &APLLintFile{root: q.root, name: q.name}, nil
+	case "result":
+		return &QueryResultFile{root: q.root, name: q.name, format: q.root.Config().DefaultFormat, bare: true}, nil
 	case "result.ndjson":
 		return &QueryResultFile{root: q.root, name: q.name, format: "ndjson"}, nil
+	case "result.jsonl":
+		return &QueryResultFile{root: q.root, name: q.name, format: "ndjson", ext: "jsonl"}, nil
 	case "result.csv":
 		return &QueryResultFile{root: q.root, name: q.name, format: "csv"}, nil
 	case "result.json":
 		return &QueryResultFile{root: q.root, name: q.name, format: "json"}, nil
+	case "result.tsv":
+		return &QueryResultFile{root: q.root, name: q.name, format: "tsv"}, nil
+	case "result.xlsx":
+		return &QueryResultFile{root: q.root, name: q.name, format: "xlsx"}, nil
+	case "result.vl.json":
+		return &QueryResultFile{root: q.root, name: q.name, format: "vl.json"}, nil
+	case "result.svg":
+		return &QueryResultFile{root: q.root, name: q.name, format: "svg"}, nil
+	case "result.md":
+		return &QueryResultFile{root: q.root, name: q.name, format: "md"}, nil
 	case "result.error":
 		return &QueryErrorFile{root: q.root, name: q.name}, nil
 	case "schema.csv":
 		return &QuerySchemaFile{root: q.root, name: q.name}, nil
 	case "stats.json":
 		return &QueryStatsFile{root: q.root, name: q.name}, nil
+	case "plan.txt", "plan.json":
+		return &PlanFile{name: name, plan: func(ctx context.Context) (queryPlan, error) {
+			return q.root.savedQueryPlan(q.name)
+		}}, nil
+	case "result.count":
+		return &ResultCountFile{count: q.resultCount}, nil
+	case "result.stats.csv":
+		return &ResultStatsFile{stats: q.resultStats}, nil
+	case "open.url", "link.txt":
+		return &LinkFile{name: name, link: q.link}, nil
+	case "result.sha256", "manifest.json":
+		// Both describe result.ndjson, the default export format; see
+		// resultMetaFile for the other formats.
+		return &ResultMetaFile{name: name, result: "result.ndjson", meta: q.resultMeta}, nil
+	case "cols":
+		return &QueryColsDir{root: q.root, name: q.name}, nil
+	case "tables":
+		return &ResultTablesDir{names: q.tableNames, result: q.tableResult, estimate: q.tableEstimate}, nil
+	case "snapshot":
+		return &SnapshotDir{root: q.root, name: q.name}, nil
+	case "snapshot.trigger":
+		return &SnapshotTriggerFile{root: q.root, name: q.name}, nil
+	case "export.dest":
+		return &ExportDestFile{root: q.root, name: q.name}, nil
+	case "export.status":
+		return &ExportStatusFile{root: q.root, name: q.name}, nil
 	default:
+		if node, ok := q.resultMetaFile(ctx, name); ok {
+			return node, nil
+		}
+		if node, ok := lookupErrorFile(ctx, q, name); ok {
+			return node, nil
+		}
 		return nil, os.ErrNotExist
 	}
 }
 
+// resultMetaFile serves result.<ext>.sha256 and result.<ext>.manifest.json,
+// which describe result.<ext> as result.sha256 and manifest.json describe
+// result.ndjson. They are not listed.
+func (q *QueryEntryDir) resultMetaFile(ctx context.Context, name string) (Node, bool) {
+	for _, suffix := range []string{".sha256", ".manifest.json"} {
+		file, ok := strings.CutSuffix(name, suffix)
+		if !ok || !strings.HasPrefix(file, "result.") {
+			continue
+		}
+		node, err := q.Lookup(ctx, file)
+		result, isResult := node.(*QueryResultFile)
+		if err != nil || !isResult {
+			return nil, false
+		}
+		return &ResultMetaFile{name: name, result: file, meta: result.meta}, true
+	}
+	return nil, false
+}
+
+func (q *QueryEntryDir) resultMeta(ctx context.Context) (query.ResultMeta, error) {
+	apl, err := q.root.savedAPL(q.name)
+	if err != nil {
+		return query.ResultMeta{}, err
+	}
+	return q.root.Executor().ResultMeta(ctx, apl, "ndjson", query.ExecOptions{UseCache: true, Headers: savedQueryLabel(q.name)})
+}
+
+func (q *QueryEntryDir) tableNames(ctx context.Context) ([]string, error) {
+	meta, err := q.resultMeta(ctx)
+	return meta.Tables, err
+}
+
+func (q *QueryEntryDir) tableResult(ctx context.Context, table string) (query.ResultData, error) {
+	apl, err := q.root.savedAPL(q.name)
+	if err != nil {
+		return query.ResultData{}, err
+	}
+	return q.root.Executor().ExecuteAPLResult(ctx, apl, "csv", q.tableOptions(table))
+}
+
+func (q *QueryEntryDir) tableEstimate(ctx context.Context, table string) (query.ResultEstimate, error) {
+	apl, err := q.root.savedAPL(q.name)
+	if err != nil {
+		return query.ResultEstimate{}, err
+	}
+	return q.root.Executor().EstimateResult(ctx, apl, "csv", q.tableOptions(table))
+}
+
+func (q *QueryEntryDir) tableOptions(table string) query.ExecOptions {
+	return query.ExecOptions{UseCache: true, Headers: savedQueryLabel(q.name), Table: table}
+}
+
+func (q *QueryEntryDir) resultStats(ctx context.Context) ([]byte, error) {
+	apl, err := q.root.savedAPL(q.name)
+	if err != nil {
+		return nil, err
+	}
+	return q.root.Executor().ResultStats(ctx, apl, "ndjson", query.ExecOptions{UseCache: true, Headers: savedQueryLabel(q.name)})
+}
+
+func (q *QueryEntryDir) link() (string, error) {
+	apl, err := q.root.savedAPL(q.name)
+	if err != nil {
+		return "", err
+	}
+	return q.root.Links().Query(apl), nil
+}
+
+func (q *QueryEntryDir) resultCount(ctx context.Context) (int64, error) {
+	apl, err := q.root.savedAPL(q.name)
+	if err != nil {
+		return 0, err
+	}
+	return q.root.Executor().ResultCount(ctx, apl, query.ExecOptions{UseCache: true, Headers: savedQueryLabel(q.name)})
+}
+
 type APLFile struct {
 	root *Root
 	name string
@@ -104,34 +329,299 @@ func (a *APLFile) Create(ctx context.Context) (billy.File, error) {
 	return newAPLFile(a.root.Store(), a.name), nil
 }
 
+// APLFormatFile serves the saved APL in canonical form.
+type APLFormatFile struct {
+	root *Root
+	name string
+}
+
+func (a *APLFormatFile) Stat(ctx context.Context) (os.FileInfo, error) {
+	data := apl.Format(string(a.root.Store().Get(a.name)))
+	return FileInfo("apl.fmt", int64(len(data))), nil
+}
+
+func (a *APLFormatFile) Open(ctx context.Context, flags int) (billy.File, error) {
+	data := apl.Format(string(a.root.Store().Get(a.name)))
+	return newBytesFile([]byte(data)), nil
+}
+
+// APLLintFile reports common issues in the saved APL. Field checks use the
+// cached field list of the query's source dataset.
+type APLLintFile struct {
+	root *Root
+	name string
+}
+
+func (a *APLLintFile) buildLint(ctx context.Context) ([]byte, error) {
+	src, err := a.root.expandedAPL(a.name)
+	if err != nil {
+		src = string(a.root.Store().Get(a.name))
+	}
+	opts := apl.LintOptions{}
+	if dataset := apl.Dataset(src); dataset != "" && a.root.fsys.Policy.DatasetVisible(dataset) {
+		if fields, err := a.root.fields().List(ctx, a.root.Client(), dataset); err == nil {
+			opts.Fields = make([]string, 0, len(fields))
+			for _, f := range fields {
+				opts.Fields = append(opts.Fields, f.Name)
+			}
+		}
+	}
+	payload := map[string]any{
+		"apl":    src,
+		"issues": apl.Lint(src, opts),
+	}
+	data, err := json.MarshalIndent(payload, "", "  ")
+	if err != nil {
+		return nil, err
+	}
+	return append(data, '\n'), nil
+}
+
+func (a *APLLintFile) Stat(ctx context.Context) (os.FileInfo, error) {
+	return DynamicFileInfo("lint.json"), nil
+}
+
+func (a *APLLintFile) Open(ctx context.Context, flags int) (billy.File, error) {
+	data, err := a.buildLint(ctx)
+	if err != nil {
+		return nil, err
+	}
+	return newBytesFile(data), nil
+}
+
+// QueryColsDir holds cols/<a,b>/ projections of a saved query's results.
+type QueryColsDir struct {
+	root *Root
+	name string
+}
+
+func (q *QueryColsDir) Stat(ctx context.Context) (os.FileInfo, error) {
+	return DirInfo("cols"), nil
+}
+
+func (q *QueryColsDir) ReadDir(ctx context.Context) ([]os.FileInfo, error) {
+	return []os.FileInfo{}, nil
+}
+
+func (q *QueryColsDir) Lookup(ctx context.Context, name string) (Node, error) {
+	columns, err := compiler.ParseColumns(name)
+	if err != nil {
+		return nil, os.ErrNotExist
+	}
+	return &QueryColumnsDir{root: q.root, name: q.name, spec: name, columns: columns}, nil
+}
+
+// QueryColumnsDir serves the saved query's results keeping only columns.
+type QueryColumnsDir struct {
+	root    *Root
+	name    string
+	spec    string
+	columns []string
+}
+
+func (q *QueryColumnsDir) Stat(ctx context.Context) (os.FileInfo, error) {
+	return DirInfo(q.spec), nil
+}
+
+func (q *QueryColumnsDir) ReadDir(ctx context.Context) ([]os.FileInfo, error) {
+	return []os.FileInfo{
+		FileInfo("result.ndjson", 0),
+		FileInfo("result.csv", 0),
+		FileInfo("result.json", 0),
+		FileInfo("result.tsv", 0),
+		FileInfo("result.xlsx", 0),
+		FileInfo("result.vl.json", 0),
+		FileInfo("result.svg", 0),
+		FileInfo("result.md", 0),
+	}, nil
+}
+
+func (q *QueryColumnsDir) Lookup(ctx context.Context, name string) (Node, error) {
+	switch name {
+	case "result.ndjson", "result.csv", "result.json", "result.tsv", "result.xlsx", "result.vl.json", "result.svg", "result.md":
+		format := strings.TrimPrefix(name, "result.")
+		return &QueryResultFile{root: q.root, name: q.name, format: format, columns: q.columns}, nil
+	default:
+		if node, ok := lookupErrorFile(ctx, q, name); ok {
+			return node, nil
+		}
+		return nil, os.ErrNotExist
+	}
+}
+
 type QueryResultFile struct {
-	root   *Root
-	name   string
-	format string
+	root    *Root
+	name    string
+	format  string
+	columns []string
+	// bare is set for result, encoded in -default-format.
+	bare bool
+	// ext is the extension when it is an alias of format, such as jsonl.
+	ext string
 }
 
-func (q *QueryResultFile) execute(ctx context.Context) (query.ResultData, error) {
-	apl := string(q.root.Store().Get(q.name))
-	if err := query.ValidateAPL(apl); err != nil {
-		return query.ResultData{}, err
+func (q *QueryResultFile) execute(ctx context.Context) (query.ResultData, uint64, error) {
+	apl, rev, err := q.root.savedAPLRevision(q.name)
+	if err != nil {
+		return query.ResultData{}, rev, err
 	}
-	return q.root.Executor().ExecuteAPLResult(ctx, apl, q.format, query.ExecOptions{
+	result, err := q.root.Executor().ExecuteAPLResult(ctx, apl, q.format, q.options())
+	return result, rev, err
+}
+
+func (q *QueryResultFile) options() query.ExecOptions {
+	return query.ExecOptions{
 		UseCache:        true,
 		EnsureTimeRange: false, // Raw APL queries run as-is
 		EnsureLimit:     false,
-	})
+		Columns:         q.columns,
+		Headers:         savedQueryLabel(q.name),
+		MaxStale:        q.root.Config().MaxStale(config.StalePathQueries),
+	}
+}
+
+// meta returns the execution metadata of the file's result, from the same
+// cached execution reads are served from.
+func (q *QueryResultFile) meta(ctx context.Context) (query.ResultMeta, error) {
+	apl, err := q.root.savedAPL(q.name)
+	if err != nil {
+		return query.ResultMeta{}, err
+	}
+	return q.root.Executor().ResultMeta(ctx, apl, q.format, q.options())
+}
+
+// fileName is the name the file was looked up as: result, result.<format>
+// or an alias such as result.jsonl.
+func (q *QueryResultFile) fileName() string {
+	if q.bare {
+		return "result"
+	}
+	if q.ext != "" {
+		return "result." + q.ext
+	}
+	return "result." + q.format
 }
 
 func (q *QueryResultFile) Stat(ctx context.Context) (os.FileInfo, error) {
-	return DynamicFileInfo("result." + q.format), nil
+	return QueryFileInfo(q.fileName()), nil
+}
+
+func (q *QueryResultFile) Estimate(ctx context.Context) (query.ResultEstimate, error) {
+	apl, err := q.root.savedAPL(q.name)
+	if err != nil {
+		return query.ResultEstimate{}, err
+	}
+	return q.root.Executor().EstimateResult(ctx, apl, q.format, q.options())
+}
+
+// Touch drops the saved query's cached results, in every format, so the
+// next read runs it again.
+func (q *QueryResultFile) Touch(ctx context.Context) error {
+	apl, err := q.root.savedAPL(q.name)
+	if err != nil {
+		return err
+	}
+	if invalidator, ok := q.root.Executor().(cacheInvalidator); ok {
+		invalidator.InvalidateAPL(apl)
+	}
+	return nil
 }
 
+// Open captures the saved query's revision. If the APL is rewritten while
+// the query runs, Open fails with ESTALE, and so do reads from the returned
+// handle after a rewrite, so a reader never mixes results of two queries.
 func (q *QueryResultFile) Open(ctx context.Context, flags int) (billy.File, error) {
-	result, err := q.execute(ctx)
+	result, rev, err := q.execute(ctx)
+	if err != nil {
+		return nil, err
+	}
+	f, err := openResult(result)
 	if err != nil {
 		return nil, err
 	}
-	return openResult(result)
+	if q.root.revision(q.name) != rev {
+		_ = f.Close()
+		return nil, errQueryChanged
+	}
+	return &revisionFile{
+		File:     f,
+		root:     q.root,
+		name:     q.name,
+		revision: rev,
+		pin:      query.ClientFrom(ctx) + "\x00" + q.name + "/" + q.fileName(),
+	}, nil
+}
+
+// revisionFile is a result handle of a saved query opened at revision.
+//
+// go-nfs opens a file again for every READ, so a handle lasts one RPC. A
+// read at offset 0 pins the revision it was served at for the client and
+// file, and later reads of a different revision fail, until the client
+// reads from the start again.
+type revisionFile struct {
+	billy.File
+	root     *Root
+	name     string
+	revision uint64
+	// pin keys the file's read pin: the client and the file's path.
+	pin string
+}
+
+func (f *revisionFile) check() error {
+	if f.root.revision(f.name) != f.revision {
+		return errQueryChanged
+	}
+	return nil
+}
+
+// readPins holds the revision each client's read of a saved query result
+// started at.
+type readPins struct {
+	mu   sync.Mutex
+	pins map[string]uint64
+}
+
+// check pins revision for key when start is set, or when nothing is
+// pinned, and otherwise fails if key is pinned at another revision.
+func (p *readPins) check(key string, revision uint64, start bool) error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	pinned, ok := p.pins[key]
+	if ok && !start && pinned != revision {
+		return errQueryChanged
+	}
+	if p.pins == nil {
+		p.pins = make(map[string]uint64)
+	}
+	p.pins[key] = revision
+	return nil
+}
+
+func (f *revisionFile) Read(p []byte) (int, error) {
+	if err := f.check(); err != nil {
+		return 0, err
+	}
+	return f.File.Read(p)
+}
+
+func (f *revisionFile) ReadAt(p []byte, off int64) (int, error) {
+	if err := f.check(); err != nil {
+		return 0, err
+	}
+	if err := f.root.fsys.readPins.check(f.pin, f.revision, off == 0); err != nil {
+		return 0, err
+	}
+	return f.File.ReadAt(p, off)
+}
+
+// Size and ModTime are forwarded for the NFS layer, which looks for them on
+// opened files.
+func (f *revisionFile) Size() int64 {
+	return f.File.(interface{ Size() int64 }).Size()
+}
+
+func (f *revisionFile) ModTime() time.Time {
+	return f.File.(interface{ ModTime() time.Time }).ModTime()
 }
 
 type QueryErrorFile struct {
@@ -140,20 +630,21 @@ type QueryErrorFile struct {
 }
 
 func (q *QueryErrorFile) buildError(ctx context.Context) []byte {
-	apl := string(q.root.Store().Get(q.name))
-	if err := query.ValidateAPL(apl); err != nil {
-		return query.BuildErrorAPL(apl, err)
+	apl, err := q.root.savedAPL(q.name)
+	if err != nil {
+		return query.BuildErrorAPL(string(q.root.Store().Get(q.name)), err)
 	}
-	_, err := q.root.Executor().ExecuteAPL(ctx, apl, "ndjson", query.ExecOptions{
+	_, err = q.root.Executor().ExecuteAPL(ctx, apl, "ndjson", query.ExecOptions{
 		UseCache:        true,
 		EnsureTimeRange: false,
 		EnsureLimit:     false,
+		Headers:         savedQueryLabel(q.name),
 	})
 	return query.BuildErrorAPL(apl, err)
 }
 
 func (q *QueryErrorFile) Stat(ctx context.Context) (os.FileInfo, error) {
-	return DynamicFileInfo("result.error"), nil
+	return QueryFileInfo("result.error"), nil
 }
 
 func (q *QueryErrorFile) Open(ctx context.Context, flags int) (billy.File, error) {
@@ -167,14 +658,15 @@ type QuerySchemaFile struct {
 }
 
 func (q *QuerySchemaFile) buildSchema(ctx context.Context) ([]byte, error) {
-	apl := string(q.root.Store().Get(q.name))
-	if err := query.ValidateAPL(apl); err != nil {
+	apl, err := q.root.savedAPL(q.name)
+	if err != nil {
 		return nil, err
 	}
 	result, err := q.root.Executor().QueryAPL(ctx, apl, query.ExecOptions{
 		UseCache:        true,
 		EnsureTimeRange: false,
 		EnsureLimit:     false,
+		Headers:         savedQueryLabel(q.name),
 	})
 	if err != nil {
 		return nil, err
@@ -183,7 +675,7 @@ func (q *QuerySchemaFile) buildSchema(ctx context.Context) ([]byte, error) {
 }
 
 func (q *QuerySchemaFile) Stat(ctx context.Context) (os.FileInfo, error) {
-	return DynamicFileInfo("schema.csv"), nil
+	return QueryFileInfo("schema.csv"), nil
 }
 
 func (q *QuerySchemaFile) Open(ctx context.Context, flags int) (billy.File, error) {
@@ -200,21 +692,27 @@ type QueryStatsFile struct {
 }
 
 func (q *QueryStatsFile) buildStats(ctx context.Context) ([]byte, error) {
-	apl := string(q.root.Store().Get(q.name))
-	if err := query.ValidateAPL(apl); err != nil {
+	src, rev, err := q.root.savedAPLRevision(q.name)
+	if err != nil {
 		return nil, err
 	}
-	result, err := q.root.Executor().QueryAPL(ctx, apl, query.ExecOptions{
+	result, err := q.root.Executor().QueryAPL(ctx, src, query.ExecOptions{
 		UseCache:        true,
 		EnsureTimeRange: false,
 		EnsureLimit:     false,
+		Headers:         savedQueryLabel(q.name),
 	})
 	if err != nil {
 		return nil, err
 	}
 	payload := map[string]any{
-		"apl":    apl,
-		"status": result.Status,
+		"apl":       src,
+		"canonical": apl.Canonical(src),
+		"revision":  rev,
+		"status":    result.Status,
+	}
+	if result.Status.Truncated() {
+		payload["truncated"] = true
 	}
 	data, err := json.MarshalIndent(payload, "", "  ")
 	if err != nil {
@@ -224,7 +722,7 @@ func (q *QueryStatsFile) buildStats(ctx context.Context) ([]byte, error) {
 }
 
 func (q *QueryStatsFile) Stat(ctx context.Context) (os.FileInfo, error) {
-	return DynamicFileInfo("stats.json"), nil
+	return QueryFileInfo("stats.json"), nil
 }
 
 func (q *QueryStatsFile) Open(ctx context.Context, flags int) (billy.File, error) {
@@ -2,11 +2,17 @@ package vfs
 
 import (
 	"context"
+	"encoding/json"
+	"fmt"
 	"os"
+	"slices"
 	"strings"
 
 	"github.com/go-git/go-billy/v5"
 
+	"github.com/axiomhq/axiom-fs/internal/apl"
+	"github.com/axiomhq/axiom-fs/internal/compiler"
+	"github.com/axiomhq/axiom-fs/internal/config"
 	"github.com/axiomhq/axiom-fs/internal/query"
 )
 
@@ -25,6 +31,48 @@ func (q *QueryPathDir) ReadDir(ctx context.Context) ([]os.FileInfo, error) {
 }
 
 func (q *QueryPathDir) Lookup(ctx context.Context, name string) (Node, error) {
+	if name != "result.error" {
+		if node, ok := lookupErrorFile(ctx, q, name); ok {
+			return node, nil
+		}
+	}
+	if name == "stats.json" {
+		return &QueryPathStatsFile{root: q.root, dataset: q.dataset, segments: append(q.segments, name)}, nil
+	}
+	if isResultMetaName(name) {
+		return q.resultMetaFile(ctx, name)
+	}
+	if name == "result.count" {
+		return q.resultCountFile(ctx)
+	}
+	if name == "result.stats.csv" {
+		return q.resultStatsFile(ctx)
+	}
+	if name == "tables" {
+		// A segment argument named tables, such as where/tables, leaves the
+		// path incomplete; only a complete query has a tables/ directory.
+		if node, err := q.tablesDir(ctx); err == nil {
+			return node, nil
+		}
+	}
+	if isPlanName(name) {
+		return &PlanFile{name: name, plan: func(ctx context.Context) (queryPlan, error) {
+			return q.root.queryPathPlan(ctx, q.dataset, q.segments)
+		}}, nil
+	}
+	if isLinkName(name) {
+		return q.linkFile(ctx, name)
+	}
+	if name == "apl.txt" {
+		return q.aplFile(ctx)
+	}
+	if name == "result" {
+		// As with tables, an argument named result, such as
+		// project/result, leaves the path incomplete.
+		if _, err := compilePath(q.dataset, q.segments, q.root.datasetConfig(q.dataset), nil); err == nil {
+			return &QueryPathResultFile{root: q.root, dataset: q.dataset, segments: append(slices.Clip(q.segments), name)}, nil
+		}
+	}
 	if strings.HasPrefix(name, "result.") {
 		ext := strings.TrimPrefix(name, "result.")
 		if ext == "error" {
@@ -35,43 +83,261 @@ func (q *QueryPathDir) Lookup(ctx context.Context, name string) (Node, error) {
 	return &QueryPathDir{root: q.root, dataset: q.dataset, segments: append(q.segments, name)}, nil
 }
 
+// QueryAPL returns the APL this directory's segments compile to.
+func (q *QueryPathDir) QueryAPL(ctx context.Context) (string, error) {
+	return compileQueryAPL(ctx, q.root, q.dataset, q.segments)
+}
+
+// resultMetaFile describes the result this directory's format/ segment
+// selects (-default-format by default).
+func (q *QueryPathDir) resultMetaFile(ctx context.Context, name string) (Node, error) {
+	cfg := q.root.datasetConfig(q.dataset)
+	compiled, err := compilePath(q.dataset, q.segments, cfg, q.root.distinctFields(ctx, q.dataset, q.segments))
+	if err != nil {
+		return nil, os.ErrNotExist
+	}
+	return &ResultMetaFile{
+		name:   name,
+		result: "result." + compiled.Format,
+		meta: func(ctx context.Context) (query.ResultMeta, error) {
+			return q.root.Executor().ResultMeta(ctx, compiled.APL, compiled.Format, query.ExecOptions{
+				UseCache:     true,
+				AutoRange:    compiled.AutoRange,
+				DefaultRange: cfg.DefaultRange,
+				Columns:      compiled.Columns,
+				NaturalSort:  compiled.NaturalSort,
+				Flatten:      compiled.Flatten,
+				Headers:      queryPathLabel(q.dataset, q.segments),
+				CacheOnly:    q.root.buried(q.dataset),
+				Label:        compiled.Label,
+			})
+		},
+	}, nil
+}
+
+func (q *QueryPathDir) resultCountFile(ctx context.Context) (Node, error) {
+	cfg := q.root.datasetConfig(q.dataset)
+	compiled, err := compilePath(q.dataset, q.segments, cfg, q.root.distinctFields(ctx, q.dataset, q.segments))
+	if err != nil {
+		return nil, os.ErrNotExist
+	}
+	return &ResultCountFile{count: func(ctx context.Context) (int64, error) {
+		return q.root.Executor().ResultCount(ctx, compiled.APL, query.ExecOptions{
+			UseCache:     true,
+			AutoRange:    compiled.AutoRange,
+			DefaultRange: cfg.DefaultRange,
+			Columns:      compiled.Columns,
+			NaturalSort:  compiled.NaturalSort,
+			Flatten:      compiled.Flatten,
+			Headers:      queryPathLabel(q.dataset, q.segments),
+			CacheOnly:    q.root.buried(q.dataset),
+			Label:        compiled.Label,
+		})
+	}}, nil
+}
+
+func (q *QueryPathDir) resultStatsFile(ctx context.Context) (Node, error) {
+	cfg := q.root.datasetConfig(q.dataset)
+	compiled, err := compilePath(q.dataset, q.segments, cfg, q.root.distinctFields(ctx, q.dataset, q.segments))
+	if err != nil {
+		return nil, os.ErrNotExist
+	}
+	return &ResultStatsFile{stats: func(ctx context.Context) ([]byte, error) {
+		return q.root.Executor().ResultStats(ctx, compiled.APL, compiled.Format, query.ExecOptions{
+			UseCache:     true,
+			AutoRange:    compiled.AutoRange,
+			DefaultRange: cfg.DefaultRange,
+			Columns:      compiled.Columns,
+			NaturalSort:  compiled.NaturalSort,
+			Flatten:      compiled.Flatten,
+			Headers:      queryPathLabel(q.dataset, q.segments),
+			CacheOnly:    q.root.buried(q.dataset),
+			Label:        compiled.Label,
+		})
+	}}, nil
+}
+
+// tablesDir lists the result tables of this directory's query.
+func (q *QueryPathDir) tablesDir(ctx context.Context) (Node, error) {
+	cfg := q.root.datasetConfig(q.dataset)
+	compiled, err := compilePath(q.dataset, q.segments, cfg, q.root.distinctFields(ctx, q.dataset, q.segments))
+	if err != nil {
+		return nil, os.ErrNotExist
+	}
+	opts := query.ExecOptions{
+		UseCache:     true,
+		AutoRange:    compiled.AutoRange,
+		DefaultRange: cfg.DefaultRange,
+		Columns:      compiled.Columns,
+		NaturalSort:  compiled.NaturalSort,
+		Flatten:      compiled.Flatten,
+		Headers:      queryPathLabel(q.dataset, q.segments),
+		CacheOnly:    q.root.buried(q.dataset),
+		Label:        compiled.Label,
+	}
+	return &ResultTablesDir{
+		names: func(ctx context.Context) ([]string, error) {
+			meta, err := q.root.Executor().ResultMeta(ctx, compiled.APL, compiled.Format, opts)
+			return meta.Tables, err
+		},
+		result: func(ctx context.Context, table string) (query.ResultData, error) {
+			opts := opts
+			opts.Table = table
+			return q.root.Executor().ExecuteAPLResult(ctx, compiled.APL, "csv", opts)
+		},
+		estimate: func(ctx context.Context, table string) (query.ResultEstimate, error) {
+			opts := opts
+			opts.Table = table
+			return q.root.Executor().EstimateResult(ctx, compiled.APL, "csv", opts)
+		},
+	}, nil
+}
+
+func (q *QueryPathDir) linkFile(ctx context.Context, name string) (Node, error) {
+	cfg := q.root.datasetConfig(q.dataset)
+	compiled, err := compilePath(q.dataset, q.segments, cfg, q.root.distinctFields(ctx, q.dataset, q.segments))
+	if err != nil {
+		return nil, os.ErrNotExist
+	}
+	return &LinkFile{name: name, link: func() (string, error) {
+		return q.root.Links().Query(compiled.APL), nil
+	}}, nil
+}
+
+// aplFile is apl.txt: the APL the path compiles to, as result files would
+// run it, without running it.
+func (q *QueryPathDir) aplFile(ctx context.Context) (Node, error) {
+	src, err := compileQueryAPL(ctx, q.root, q.dataset, q.segments)
+	if err != nil {
+		return nil, os.ErrNotExist
+	}
+	return &StaticFile{name: "apl.txt", data: []byte(src + "\n")}, nil
+}
+
 type QueryPathResultFile struct {
 	root     *Root
 	dataset  string
 	segments []string
 }
 
-func (q *QueryPathResultFile) execute(ctx context.Context) (query.ResultData, error) {
-	compiled, err := compilePath(q.dataset, q.segments, q.root.Config())
+func (q *QueryPathResultFile) compile(ctx context.Context) (compiler.Query, query.ExecOptions, error) {
+	cfg := q.root.datasetConfig(q.dataset)
+	compiled, err := compilePath(q.dataset, q.segments, cfg, q.root.distinctFields(ctx, q.dataset, q.segments))
 	if err != nil {
-		return query.ResultData{}, err
+		return compiler.Query{}, query.ExecOptions{}, err
 	}
-	return q.root.Executor().ExecuteAPLResult(ctx, compiled.APL, compiled.Format, query.ExecOptions{
+	return compiled, query.ExecOptions{
 		UseCache:        true,
 		EnsureTimeRange: false,
 		EnsureLimit:     false,
-	})
+		AutoRange:       compiled.AutoRange,
+		DefaultRange:    cfg.DefaultRange,
+		Columns:         compiled.Columns,
+		NaturalSort:     compiled.NaturalSort,
+		Flatten:         compiled.Flatten,
+		Headers:         queryPathLabel(q.dataset, q.segments),
+		CacheOnly:       q.root.buried(q.dataset),
+		Label:           compiled.Label,
+		MaxStale:        cfg.MaxStale(config.StalePathQuery),
+	}, nil
+}
+
+func (q *QueryPathResultFile) execute(ctx context.Context) (query.ResultData, error) {
+	compiled, opts, err := q.compile(ctx)
+	if err != nil {
+		return query.ResultData{}, err
+	}
+	return q.run(ctx, compiled, opts)
+}
+
+// run executes the result. When Axiom no longer knows the dataset, it is
+// marked deleted and the result is served from the cache, if it is there.
+func (q *QueryPathResultFile) run(ctx context.Context, compiled compiler.Query, opts query.ExecOptions) (query.ResultData, error) {
+	result, err := q.root.Executor().ExecuteAPLResult(ctx, compiled.APL, compiled.Format, opts)
+	if err != nil && !opts.CacheOnly && q.root.datasetGone(ctx, q.dataset, err) {
+		opts.CacheOnly = true
+		return q.root.Executor().ExecuteAPLResult(ctx, compiled.APL, compiled.Format, opts)
+	}
+	return result, err
+}
+
+// QueryAPL returns the APL the result file runs.
+func (q *QueryPathResultFile) QueryAPL(ctx context.Context) (string, error) {
+	return compileQueryAPL(ctx, q.root, q.dataset, q.segments)
+}
+
+func compileQueryAPL(ctx context.Context, root *Root, dataset string, segments []string) (string, error) {
+	cfg := root.datasetConfig(dataset)
+	compiled, err := compilePath(dataset, segments, cfg, root.distinctFields(ctx, dataset, segments))
+	if err != nil {
+		return "", fmt.Errorf("%w: %w", os.ErrInvalid, err)
+	}
+	return compiled.APL, nil
 }
 
 func (q *QueryPathResultFile) Stat(ctx context.Context) (os.FileInfo, error) {
-	result, err := q.execute(ctx)
+	cfg := q.root.datasetConfig(q.dataset)
+	compiled, err := compilePath(q.dataset, q.segments, cfg, q.root.distinctFields(ctx, q.dataset, q.segments))
 	if err != nil {
 		return nil, err
 	}
-	compiled, _ := compilePath(q.dataset, q.segments, q.root.Config())
-	name := "result.ndjson"
-	if compiled.Format != "" {
-		name = "result." + compiled.Format
+	// The file is named as looked up: result, result.<format> or an
+	// alias such as result.jsonl.
+	name := q.segments[len(q.segments)-1]
+	if q.root.Config().StatMode == config.StatModeEstimate {
+		estimate, err := q.root.Executor().EstimateResult(ctx, compiled.APL, compiled.Format, query.ExecOptions{
+			UseCache:     true,
+			AutoRange:    compiled.AutoRange,
+			DefaultRange: cfg.DefaultRange,
+			Columns:      compiled.Columns,
+			NaturalSort:  compiled.NaturalSort,
+			Flatten:      compiled.Flatten,
+			Headers:      queryPathLabel(q.dataset, q.segments),
+			CacheOnly:    q.root.buried(q.dataset),
+			Label:        compiled.Label,
+		})
+		if err != nil {
+			return nil, err
+		}
+		return FileInfoAt(name, estimate.Size, estimate.ModTime), nil
+	}
+	result, err := q.execute(ctx)
+	if err != nil {
+		return nil, err
 	}
-	return FileInfo(name, result.Size), nil
+	result.Release()
+	return FileInfoAt(name, result.Size, result.ModTime), nil
 }
 
 func (q *QueryPathResultFile) Open(ctx context.Context, flags int) (billy.File, error) {
-	result, err := q.execute(ctx)
+	compiled, opts, err := q.compile(ctx)
 	if err != nil {
 		return nil, err
 	}
-	return openResult(result)
+	full := func(ctx context.Context) (billy.File, error) {
+		result, err := q.run(ctx, compiled, opts)
+		if err != nil {
+			return nil, err
+		}
+		return openResult(result)
+	}
+	runner, ok := q.root.Executor().(headRunner)
+	if rows := q.root.Config().ReadProbeRows; ok && rows > 0 && probeable(compiled) {
+		return &probedFile{
+			head: func(ctx context.Context) (query.ResultData, bool, error) {
+				result, complete, err := runner.ExecuteAPLHead(ctx, compiled.APL, compiled.Format, rows, opts)
+				if err != nil && !opts.CacheOnly && q.root.datasetGone(ctx, q.dataset, err) {
+					opts := opts
+					opts.CacheOnly = true
+					result, err = q.root.Executor().ExecuteAPLResult(ctx, compiled.APL, compiled.Format, opts)
+					return result, true, err
+				}
+				return result, complete, err
+			},
+			full: full,
+		}, nil
+	}
+	return full(ctx)
 }
 
 type QueryPathErrorFile struct {
@@ -81,7 +347,8 @@ type QueryPathErrorFile struct {
 }
 
 func (q *QueryPathErrorFile) buildError(ctx context.Context) []byte {
-	compiled, err := compilePath(q.dataset, q.segments, q.root.Config())
+	cfg := q.root.datasetConfig(q.dataset)
+	compiled, err := compilePath(q.dataset, q.segments, cfg, q.root.distinctFields(ctx, q.dataset, q.segments))
 	if err != nil {
 		return query.BuildErrorAPL("", err)
 	}
@@ -89,15 +356,96 @@ func (q *QueryPathErrorFile) buildError(ctx context.Context) []byte {
 		UseCache:        true,
 		EnsureTimeRange: false,
 		EnsureLimit:     false,
+		AutoRange:       compiled.AutoRange,
+		DefaultRange:    cfg.DefaultRange,
+		Columns:         compiled.Columns,
+		NaturalSort:     compiled.NaturalSort,
+		Flatten:         compiled.Flatten,
+		Headers:         queryPathLabel(q.dataset, q.segments),
+		CacheOnly:       q.root.buried(q.dataset),
+		Label:           compiled.Label,
 	})
 	return query.BuildErrorAPL(compiled.APL, err)
 }
 
 func (q *QueryPathErrorFile) Stat(ctx context.Context) (os.FileInfo, error) {
-	return DynamicFileInfo("result.error"), nil
+	return QueryFileInfo("result.error"), nil
 }
 
 func (q *QueryPathErrorFile) Open(ctx context.Context, flags int) (billy.File, error) {
 	data := q.buildError(ctx)
 	return newBytesFile(data), nil
 }
+
+// QueryPathStatsFile describes how a q/ path executes, including the range
+// actually used when auto-range widened the default window.
+type QueryPathStatsFile struct {
+	root     *Root
+	dataset  string
+	segments []string
+}
+
+func (q *QueryPathStatsFile) buildStats(ctx context.Context) ([]byte, error) {
+	cfg := q.root.datasetConfig(q.dataset)
+	compiled, err := compilePath(q.dataset, q.segments, cfg, q.root.distinctFields(ctx, q.dataset, q.segments))
+	if err != nil {
+		return nil, err
+	}
+	result, err := q.root.Executor().ExecuteAPLResult(ctx, compiled.APL, compiled.Format, query.ExecOptions{
+		UseCache:        true,
+		EnsureTimeRange: false,
+		EnsureLimit:     false,
+		AutoRange:       compiled.AutoRange,
+		DefaultRange:    cfg.DefaultRange,
+		Columns:         compiled.Columns,
+		NaturalSort:     compiled.NaturalSort,
+		Flatten:         compiled.Flatten,
+		Headers:         queryPathLabel(q.dataset, q.segments),
+		CacheOnly:       q.root.buried(q.dataset),
+		Label:           compiled.Label,
+		MaxStale:        cfg.MaxStale(config.StalePathQuery),
+	})
+	if err != nil {
+		return nil, err
+	}
+	result.Release()
+	payload := map[string]any{
+		"apl":        compiled.APL,
+		"canonical":  apl.Canonical(compiled.APL),
+		"format":     compiled.Format,
+		"auto_range": compiled.AutoRange,
+		"bytes":      result.Size,
+	}
+	if result.Range != "" {
+		payload["range"] = result.Range
+	}
+	if result.Meta.Truncated {
+		payload["truncated"] = true
+	}
+	if len(result.Meta.Warnings) > 0 {
+		payload["warnings"] = result.Meta.Warnings
+	}
+	if result.Meta.Capped {
+		payload["capped"] = true
+	}
+	if result.Meta.Stale {
+		payload["stale"] = true
+	}
+	data, err := json.MarshalIndent(payload, "", "  ")
+	if err != nil {
+		return nil, err
+	}
+	return append(data, '\n'), nil
+}
+
+func (q *QueryPathStatsFile) Stat(ctx context.Context) (os.FileInfo, error) {
+	return QueryFileInfo("stats.json"), nil
+}
+
+func (q *QueryPathStatsFile) Open(ctx context.Context, flags int) (billy.File, error) {
+	data, err := q.buildStats(ctx)
+	if err != nil {
+		return nil, err
+	}
+	return newBytesFile(data), nil
+}
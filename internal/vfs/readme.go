@@ -0,0 +1,207 @@
+package vfs
+
+import (
+	"bytes"
+	"context"
+	"os"
+	"strconv"
+	"strings"
+	"text/template"
+
+	"github.com/go-git/go-billy/v5"
+
+	"github.com/axiomhq/axiom-fs/internal/axiomclient"
+	"github.com/axiomhq/axiom-fs/internal/compiler"
+	"github.com/axiomhq/axiom-fs/internal/presets"
+	"github.com/axiomhq/axiom-fs/internal/query"
+)
+
+// readmeFieldRows caps the fields table of a dataset README; schema.csv
+// lists the rest.
+const readmeFieldRows = 20
+
+var datasetReadmeTemplate = template.Must(template.New("README.md").Parse(`# {{.Name}}
+{{if .Description}}
+{{.Description}}
+{{end}}
+- fields: {{.FieldCount}}{{if .HiddenCount}} ({{.HiddenCount}} hidden, under fields/.hidden/){{end}}
+- events in the last {{.Range}}: {{.Events}}
+{{if .Kind}}- kind: {{.Kind}}
+{{end}}
+## Fields
+
+| field | type |
+|---|---|
+{{range .Fields}}| [{{.Name}}](<fields/{{.Dir}}/top.csv>) | {{.Type}} |
+{{end}}{{if .MoreFields}}
+…and {{.MoreFields}} more in [schema.csv](schema.csv).
+{{end}}
+## Presets
+{{range .Presets}}
+- [{{.Name}}](presets/{{.Name}}.{{.Format}}): {{.Description}}{{end}}
+
+## Example queries
+{{range .Examples}}
+- [q/{{.Path}}](q/{{.Path}}): {{.Description}}{{end}}
+
+See also sample.ndjson for recent events and duckdb.sql to query the dataset
+from DuckDB.
+`))
+
+type readmeData struct {
+	Name        string
+	Description string
+	Kind        string
+	Range       string
+	Events      string
+	FieldCount  int
+	HiddenCount int
+	Fields      []readmeField
+	MoreFields  int
+	Presets     []presets.Preset
+	Examples    []readmeExample
+}
+
+type readmeField struct {
+	Name string
+	Dir  string
+	Type string
+}
+
+type readmeExample struct {
+	Path        string
+	Description string
+}
+
+// DatasetReadmeFile is <dataset>/README.md: an introduction to the dataset
+// rendered on read from its live field list and event count in the default
+// range, with its presets and q/ paths built from its own fields.
+type DatasetReadmeFile struct {
+	root    *Root
+	dataset *axiomclient.Dataset
+}
+
+func (d *DatasetReadmeFile) Stat(ctx context.Context) (os.FileInfo, error) {
+	return QueryFileInfo("README.md"), nil
+}
+
+func (d *DatasetReadmeFile) Open(ctx context.Context, flags int) (billy.File, error) {
+	data, err := d.render(ctx)
+	if err != nil {
+		return nil, err
+	}
+	return newBytesFile(data), nil
+}
+
+func (d *DatasetReadmeFile) render(ctx context.Context) ([]byte, error) {
+	name := d.dataset.Name
+	fields, err := d.root.fields().List(ctx, d.root.Client(), name)
+	if err != nil {
+		return nil, err
+	}
+	cfg := d.root.datasetConfig(name)
+	data := readmeData{
+		Name:        name,
+		Description: d.dataset.Description,
+		Kind:        d.dataset.Kind,
+		Range:       cfg.DefaultRange,
+		Events:      d.events(ctx),
+		Presets:     presets.PresetsForDataset(d.dataset),
+	}
+	var listed []axiomclient.Field
+	for _, field := range fields {
+		if field.Hidden {
+			data.HiddenCount++
+		}
+		if d.root.fieldListed(name, field) {
+			listed = append(listed, field)
+		}
+	}
+	data.FieldCount = len(listed)
+	for i, field := range listed {
+		if i == readmeFieldRows {
+			data.MoreFields = len(listed) - i
+			break
+		}
+		data.Fields = append(data.Fields, readmeField{Name: markdownCell(field.Name), Dir: encodeFieldName(field.Name), Type: field.Type})
+	}
+	data.Examples = readmeExamples(listed, cfg.DefaultRange)
+
+	var buf bytes.Buffer
+	if err := datasetReadmeTemplate.Execute(&buf, data); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+// events counts the dataset's events in the default range, or says why it
+// could not: the README is still worth reading without it.
+func (d *DatasetReadmeFile) events(ctx context.Context) string {
+	name := d.dataset.Name
+	cfg := d.root.datasetConfig(name)
+	n, err := d.root.Executor().ResultCount(ctx, d.root.source(name), query.ExecOptions{
+		UseCache:        true,
+		EnsureTimeRange: true,
+		DefaultRange:    cfg.DefaultRange,
+		Headers:         queryLabel(name, "README.md"),
+		CacheOnly:       d.root.buried(name),
+	})
+	if err != nil {
+		return "unknown (" + err.Error() + ")"
+	}
+	return strconv.FormatInt(n, 10)
+}
+
+// readmeExamples builds q/ paths from the dataset's own fields: grouping by
+// a string field and aggregating a numeric one. Fields whose names need
+// quoting in APL are skipped so every path stays readable.
+func readmeExamples(fields []axiomclient.Field, defaultRange string) []readmeExample {
+	examples := []readmeExample{
+		{Path: "range/ago/" + defaultRange + "/limit/10/result.ndjson", Description: "10 events from the default range"},
+		{Path: "summarize/count()/by/bin_auto(_time)/result.csv", Description: "event volume over time"},
+	}
+	var group, number string
+	for _, field := range fields {
+		if strings.HasPrefix(field.Name, "_") || compiler.FieldRef(field.Name) != field.Name {
+			continue
+		}
+		switch field.Type {
+		case "string":
+			if group == "" {
+				group = field.Name
+			}
+		case "integer", "float", "integer|float", "float|integer":
+			if number == "" {
+				number = field.Name
+			}
+		}
+	}
+	if group != "" {
+		examples = append(examples, readmeExample{
+			Path:        "summarize/count()/by/" + group + "/order/count_:desc/limit/10/result.csv",
+			Description: "the 10 most common values of " + group,
+		})
+	}
+	if number != "" {
+		examples = append(examples, readmeExample{
+			Path:        "summarize/avg(" + number + ")/by/bin_auto(_time)/result.csv",
+			Description: "average " + number + " over time",
+		})
+		examples = append(examples, readmeExample{
+			Path:        "top/10/by/" + number + ":desc/result.ndjson",
+			Description: "the 10 events with the highest " + number,
+		})
+	}
+	if group != "" && number != "" {
+		examples = append(examples, readmeExample{
+			Path:        "summarize/percentile(" + number + ",95)/by/" + group + "/result.csv",
+			Description: "p95 " + number + " per " + group,
+		})
+	}
+	return examples
+}
+
+// markdownCell escapes the characters that would end a markdown table cell.
+func markdownCell(s string) string {
+	return strings.NewReplacer("|", `\|`, "\n", " ").Replace(s)
+}
@@ -5,17 +5,32 @@ import (
 	"encoding/json"
 	"log/slog"
 	"os"
+	"path"
 	"path/filepath"
+	"slices"
 	"sort"
+	"strings"
 	"sync"
+	"sync/atomic"
 	"time"
 
 	"golang.org/x/sync/singleflight"
 
 	"github.com/axiomhq/axiom-fs/internal/axiomclient"
+	"github.com/axiomhq/axiom-fs/internal/compiler"
 	"github.com/axiomhq/axiom-fs/internal/config"
+	"github.com/axiomhq/axiom-fs/internal/events"
+	"github.com/axiomhq/axiom-fs/internal/export"
+	"github.com/axiomhq/axiom-fs/internal/finder"
+	"github.com/axiomhq/axiom-fs/internal/latency"
+	"github.com/axiomhq/axiom-fs/internal/policy"
 	"github.com/axiomhq/axiom-fs/internal/query"
+	"github.com/axiomhq/axiom-fs/internal/quota"
+	"github.com/axiomhq/axiom-fs/internal/redact"
 	"github.com/axiomhq/axiom-fs/internal/store"
+	"github.com/axiomhq/axiom-fs/internal/tail"
+	"github.com/axiomhq/axiom-fs/internal/throttle"
+	"github.com/axiomhq/axiom-fs/internal/urlbuilder"
 )
 
 type FS struct {
@@ -23,75 +38,328 @@ type FS struct {
 	Client   axiomclient.API
 	Executor query.Runner
 	Store    *store.QueryStore
+	Snippets *store.QueryStore
+	// Vars holds the template variables of saved queries.
+	Vars *store.QueryStore
+	// Snapshots holds saved-query result snapshots under CacheDir.
+	Snapshots *store.SnapshotStore
+	// Batches holds the query packs and results of /_batch under CacheDir.
+	Batches *store.BatchStore
+	Quota   *quota.Tracker
+	Policy  *policy.Policy
+	// Transfer reports Axiom response bytes for /_status/transfer.json.
+	Transfer func() axiomclient.TransferStats
+	// Links builds the web UI links served as open.url and link.txt.
+	Links *urlbuilder.Builder
+	// Tails runs the streams behind <dataset>/tail.ndjson. It is nil, and
+	// tail.ndjson absent, when the executor cannot poll or the mount is a
+	// snapshot.
+	Tails *tail.Manager
+	// Reads paces reads of each file handle to -max-read-throughput.
+	Reads *throttle.Limiter
+	// Latency times file operations and queries for /_status/latency.json
+	// and /_status/slow.ndjson.
+	Latency *latency.Recorder
+	// Finder recognizes the macOS metadata names the NFS layer answers
+	// itself, and counts them for /_status/finder.json.
+	Finder *finder.Filter
+	// Exports are the object stores export.dest uploads to.
+	Exports export.Sinks
+	// Events is the change log served at /_events/stream.ndjson.
+	Events *events.Log
+	// Redactor hides the columns the executor redacts from field listings
+	// and is shown at /_policy/redaction.json.
+	Redactor *redact.Redactor
 
 	datasets datasetCache
 	fields   fieldCache
+	stats    statsCache
+	watch    metadataWatch
+
+	dashboards dashboardCache
+
+	// confirm holds the tokens that unlock the /_admin control files.
+	confirm confirmTokens
+	exports exportTracker
+	batches batchTracker
+	// owner is the mount-wide ownership before the policy's owners rules.
+	owner policy.Ownership
+	// tenants, when set, are served instead of the mount's own tree.
+	tenants []Tenant
+	// tombstones are the deleted datasets still served from the cache.
+	tombstones tombstones
+	// presetSizes are the preset result sizes Stat reports.
+	presetSizes presetSizes
+	// readPins carry the revision a saved query result is read at across
+	// the opens of one read; see revisionFile.
+	readPins readPins
+}
+
+// Option configures optional subsystems of the virtual filesystem.
+type Option func(*FS)
+
+// WithQuota exposes t's usage at /_status/quota.json.
+func WithQuota(t *quota.Tracker) Option {
+	return func(fsys *FS) { fsys.Quota = t }
+}
+
+// WithPolicy hides datasets and marks writable subtrees according to p.
+func WithPolicy(p *policy.Policy) Option {
+	return func(fsys *FS) { fsys.Policy = p }
+}
+
+// WithLatency shares r, e.g. with the executor, instead of a recorder of
+// the file operations only.
+func WithLatency(r *latency.Recorder) Option {
+	return func(fsys *FS) { fsys.Latency = r }
+}
+
+// WithFinder shares f, e.g. between tenant views, so /_status/finder.json
+// counts what the NFS layer suppressed.
+func WithFinder(f *finder.Filter) Option {
+	return func(fsys *FS) { fsys.Finder = f }
+}
+
+// WithRedaction shares the executor's redactor, so fields it drops are not
+// listed either.
+func WithRedaction(r *redact.Redactor) Option {
+	return func(fsys *FS) { fsys.Redactor = r }
+}
+
+// WithEvents shares l, e.g. with the executor, instead of a log of the
+// file system's own events.
+func WithEvents(l *events.Log) Option {
+	return func(fsys *FS) { fsys.Events = l }
+}
+
+// WithTransferStats exposes fn's counters at /_status/transfer.json.
+func WithTransferStats(fn func() axiomclient.TransferStats) Option {
+	return func(fsys *FS) { fsys.Transfer = fn }
+}
+
+// WithLinks sets the builder for open.url and link.txt, e.g. with the API
+// URL and org the client resolved from the environment.
+func WithLinks(b *urlbuilder.Builder) Option {
+	return func(fsys *FS) { fsys.Links = b }
 }
 
-func NewRoot(cfg config.Config, client axiomclient.API, executor query.Runner) *Root {
+func NewRoot(cfg config.Config, client axiomclient.API, executor query.Runner, opts ...Option) *Root {
 	cacheDir := cfg.CacheDir
 	if cacheDir != "" {
 		_ = os.MkdirAll(filepath.Join(cacheDir, "fields"), 0o755)
 	}
-	fsys := &FS{
-		Config:   cfg,
-		Client:   client,
-		Executor: executor,
-		Store:    store.NewQueryStore(cfg.QueryDir),
-		datasets: datasetCache{ttl: cfg.MetadataTTL, dir: cacheDir},
-		fields:   fieldCache{ttl: cfg.MetadataTTL, dir: cacheDir},
+	snapshotDir, batchDir := "", ""
+	if cacheDir != "" {
+		snapshotDir = filepath.Join(cacheDir, "snapshots")
+		batchDir = filepath.Join(cacheDir, "batches")
 	}
-	return &Root{fsys: fsys}
+	fsys := &FS{
+		Config:     cfg,
+		Client:     client,
+		Executor:   executor,
+		Store:      store.NewQueryStore(cfg.QueryDir),
+		Snippets:   store.NewQueryStore(cfg.SnippetDir),
+		Vars:       store.NewVarsStore(cfg.QueryDir),
+		Snapshots:  store.NewSnapshotStore(snapshotDir),
+		Batches:    store.NewBatchStore(batchDir),
+		datasets:   datasetCache{ttl: cfg.MetadataTTL, maxStale: cfg.MetadataMaxStale, dir: cacheDir, warmStart: cfg.MetadataWarmStart},
+		fields:     fieldCache{ttl: cfg.MetadataTTL, dir: cacheDir, warmStart: cfg.MetadataWarmStart},
+		stats:      statsCache{ttl: cfg.MetadataTTL, maxStale: cfg.MetadataMaxStale},
+		dashboards: dashboardCache{ttl: cfg.MetadataTTL},
+		Links:      urlbuilder.New(cfg.AxiomURL, cfg.AppURL, cfg.AxiomOrgID),
+		Reads:      throttle.New(cfg.MaxReadThroughput),
+		Latency:    latency.New(cfg.SlowOpThreshold),
+		Finder:     finder.New(cfg.SuppressFinderJunk, cfg.FakeDSStore),
+		Events:     events.New(0),
+		owner:      baseOwnership(cfg),
+	}
+	if poller, ok := executor.(tail.Poller); ok && !cfg.Snapshot() {
+		fsys.Tails = tail.NewManager(poller, tail.Options{
+			Interval:        cfg.TailInterval,
+			Heartbeat:       cfg.TailHeartbeat,
+			QuietHeartbeats: !cfg.InlineMarkers,
+			MaxBytes:        cfg.TailMaxBytes,
+		})
+	}
+	fsys.fields.aliases = fsys.aliases
+	for _, opt := range opts {
+		opt(fsys)
+	}
+	fsys.Store.OnChange(func(name string, rev uint64) {
+		fsys.Events.Publish(events.Event{Type: events.QueryChanged, Query: name, Revision: rev})
+	})
+	root := &Root{fsys: fsys}
+	fsys.datasets.changed = root.datasetsChanged
+	return root
 }
 
+// backgroundRefresh bounds a refresh of a stale listing nobody waits for.
+const backgroundRefresh = 30 * time.Second
+
+// datasetCache holds the dataset list. Past ttl, and for up to maxStale
+// more, the last list is served while it is refreshed in the background,
+// so a slow Axiom does not hang every listing of the root.
 type datasetCache struct {
 	mu       sync.RWMutex
 	fetched  time.Time
 	datasets []axiomclient.Dataset
 	ttl      time.Duration
-	dir      string
-	sf       singleflight.Group
+	maxStale time.Duration
+	// refreshErr is why the last refresh failed; empty once one succeeds.
+	refreshErr string
+	refreshing atomic.Bool
+	dir        string
+	sf         singleflight.Group
+	// changed, when set, is called with the previous and the new list
+	// whenever a fetched list replaces one.
+	changed func(old, current []axiomclient.Dataset)
+	// warmStart serves the list saved in dir at startup however old it
+	// is, refreshing it in the background; fromDisk is set while that
+	// list has not been refreshed yet.
+	warmStart bool
+	fromDisk  bool
 }
 
 type fieldCache struct {
 	mu      sync.RWMutex
 	fetched map[string]time.Time
 	fields  map[string][]axiomclient.Field
+	// index maps each cached dataset's field names to their position in
+	// fields, so lookups stay cheap on datasets with many fields.
+	index map[string]map[string]int
+	// drift holds the field changes found between fetches, per dataset;
+	// see schema.diff.json.
+	drift   map[string][]schemaChange
 	ttl     time.Duration
 	dir     string
 	sf      singleflight.Group
+	aliases func() map[string][]string
+	// warmStart serves field lists saved in dir however old they are the
+	// first time they are needed, refreshing them in the background;
+	// revalidating holds the datasets being refreshed that way.
+	warmStart    bool
+	revalidating map[string]bool
 }
 
-func (c *datasetCache) List(ctx context.Context, client axiomclient.API) ([]axiomclient.Dataset, error) {
+// statsCache holds per-dataset ingest activity. Lookups never fail: when the
+// stats endpoint is unavailable, callers fall back to the stable mtime. Like
+// datasetCache, it serves expired stats for up to maxStale while refreshing
+// them in the background.
+type statsCache struct {
+	mu         sync.RWMutex
+	fetched    time.Time
+	stats      map[string]axiomclient.DatasetStats
+	ttl        time.Duration
+	maxStale   time.Duration
+	refreshing atomic.Bool
+	sf         singleflight.Group
+}
+
+func (c *statsCache) Get(ctx context.Context, client axiomclient.API, dataset string) (axiomclient.DatasetStats, bool) {
 	c.mu.RLock()
-	if time.Since(c.fetched) < c.ttl && len(c.datasets) > 0 {
-		datasets := c.datasets
-		c.mu.RUnlock()
-		return datasets, nil
+	if c.stats != nil {
+		age := time.Since(c.fetched)
+		if age < c.ttl || len(c.stats) > 0 && age < c.ttl+c.maxStale {
+			stats, ok := c.stats[dataset]
+			c.mu.RUnlock()
+			if age >= c.ttl && c.refreshing.CompareAndSwap(false, true) {
+				go func() {
+					defer c.refreshing.Store(false)
+					ctx, cancel := context.WithTimeout(context.Background(), backgroundRefresh)
+					defer cancel()
+					c.refresh(ctx, client)
+				}()
+			}
+			return stats, ok
+		}
 	}
 	c.mu.RUnlock()
 
-	// Try loading from disk if memory cache is empty
-	if datasets, ok := c.loadDisk(); ok {
+	c.refresh(ctx, client)
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	stats, ok := c.stats[dataset]
+	return stats, ok
+}
+
+// refresh fetches the stats of every dataset. A failure leaves the last
+// stats in place, or none.
+func (c *statsCache) refresh(ctx context.Context, client axiomclient.API) {
+	_, err, _ := c.sf.Do("stats", func() (any, error) {
+		list, err := client.DatasetStats(ctx)
+		if err != nil {
+			return nil, err
+		}
+		stats := make(map[string]axiomclient.DatasetStats, len(list))
+		for _, s := range list {
+			stats[s.Name] = s
+		}
 		c.mu.Lock()
-		c.datasets = datasets
+		c.stats = stats
+		c.fetched = time.Now()
+		c.mu.Unlock()
+		return nil, nil
+	})
+	if err != nil {
+		slog.Debug("failed to fetch dataset stats", "error", err)
+		// Avoid hammering a failing endpoint on every listing.
+		c.mu.Lock()
+		if c.stats == nil {
+			c.stats = map[string]axiomclient.DatasetStats{}
+		}
 		c.fetched = time.Now()
 		c.mu.Unlock()
-		return datasets, nil
 	}
+}
 
+func (c *datasetCache) List(ctx context.Context, client axiomclient.API) ([]axiomclient.Dataset, error) {
+	c.mu.RLock()
+	datasets, fetched, fromDisk := c.datasets, c.fetched, c.fromDisk
+	c.mu.RUnlock()
+
+	// Try loading from disk if memory cache is empty
+	if len(datasets) == 0 {
+		if disk, modTime, ok := c.loadDisk(); ok {
+			c.mu.Lock()
+			c.datasets, c.fetched, c.fromDisk = disk, modTime, c.warmStart
+			c.mu.Unlock()
+			datasets, fetched, fromDisk = disk, modTime, c.warmStart
+		}
+	}
+
+	if len(datasets) > 0 {
+		age := time.Since(fetched)
+		if age < c.ttl {
+			return datasets, nil
+		}
+		if age < c.ttl+c.maxStale || fromDisk {
+			if c.refreshing.CompareAndSwap(false, true) {
+				go func() {
+					defer c.refreshing.Store(false)
+					ctx, cancel := context.WithTimeout(context.Background(), backgroundRefresh)
+					defer cancel()
+					if _, err := c.refresh(ctx, client); err != nil {
+						slog.Warn("serving stale datasets, refresh failed", "fetched", fetched, "error", err)
+					}
+				}()
+			}
+			return datasets, nil
+		}
+	}
+	return c.refresh(ctx, client)
+}
+
+// refresh fetches the dataset list, replacing the cached one.
+func (c *datasetCache) refresh(ctx context.Context, client axiomclient.API) ([]axiomclient.Dataset, error) {
 	result, err, _ := c.sf.Do("datasets", func() (any, error) {
 		datasets, err := client.ListDatasets(ctx)
 		if err != nil {
+			c.mu.Lock()
+			c.refreshErr = err.Error()
+			c.mu.Unlock()
 			return nil, err
 		}
-		c.mu.Lock()
-		c.datasets = datasets
-		c.fetched = time.Now()
-		c.mu.Unlock()
-		if err := c.saveDisk(datasets); err != nil {
-			slog.Warn("failed to cache datasets", "error", err)
-		}
+		c.replace(datasets)
 		return datasets, nil
 	})
 	if err != nil {
@@ -100,6 +368,13 @@ func (c *datasetCache) List(ctx context.Context, client axiomclient.API) ([]axio
 	return result.([]axiomclient.Dataset), nil
 }
 
+// cached returns the datasets last listed without fetching.
+func (c *datasetCache) cached() []axiomclient.Dataset {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	return c.datasets
+}
+
 func (c *datasetCache) diskPath() string {
 	if c.dir == "" {
 		return ""
@@ -107,24 +382,26 @@ func (c *datasetCache) diskPath() string {
 	return filepath.Join(c.dir, "datasets.json")
 }
 
-func (c *datasetCache) loadDisk() ([]axiomclient.Dataset, bool) {
+// loadDisk reads the list saved by the last fetch, with the time it was
+// saved, unless it is too stale to serve and warmStart is off.
+func (c *datasetCache) loadDisk() ([]axiomclient.Dataset, time.Time, bool) {
 	path := c.diskPath()
 	if path == "" {
-		return nil, false
+		return nil, time.Time{}, false
 	}
 	info, err := os.Stat(path)
-	if err != nil || time.Since(info.ModTime()) > c.ttl {
-		return nil, false
+	if err != nil || !c.warmStart && time.Since(info.ModTime()) > c.ttl+c.maxStale {
+		return nil, time.Time{}, false
 	}
 	data, err := os.ReadFile(path)
 	if err != nil {
-		return nil, false
+		return nil, time.Time{}, false
 	}
 	var datasets []axiomclient.Dataset
 	if json.Unmarshal(data, &datasets) != nil {
-		return nil, false
+		return nil, time.Time{}, false
 	}
-	return datasets, true
+	return datasets, info.ModTime(), true
 }
 
 func (c *datasetCache) saveDisk(datasets []axiomclient.Dataset) error {
@@ -140,9 +417,18 @@ func (c *datasetCache) saveDisk(datasets []axiomclient.Dataset) error {
 }
 
 func (c *fieldCache) List(ctx context.Context, client axiomclient.API, dataset string) ([]axiomclient.Field, error) {
+	if members, ok := c.aliases()[dataset]; ok {
+		return c.listAlias(ctx, client, members)
+	}
+	return c.listDataset(ctx, client, dataset)
+}
+
+// listDataset lists the fields of dataset, which is not an alias.
+func (c *fieldCache) listDataset(ctx context.Context, client axiomclient.API, dataset string) ([]axiomclient.Field, error) {
 	c.mu.RLock()
+	_, cached := c.fetched[dataset]
 	if c.fields != nil {
-		if ts, ok := c.fetched[dataset]; ok && time.Since(ts) < c.ttl {
+		if ts, ok := c.fetched[dataset]; ok && (time.Since(ts) < c.ttl || c.revalidating[dataset]) {
 			fields := c.fields[dataset]
 			c.mu.RUnlock()
 			return fields, nil
@@ -151,31 +437,25 @@ func (c *fieldCache) List(ctx context.Context, client axiomclient.API, dataset s
 	c.mu.RUnlock()
 
 	// Try loading from disk
-	if fields, ok := c.loadDisk(dataset); ok {
-		c.mu.Lock()
-		if c.fields == nil {
-			c.fields = make(map[string][]axiomclient.Field)
-			c.fetched = make(map[string]time.Time)
+	if fields, modTime, ok := c.loadDisk(dataset, c.warmStart && !cached); ok {
+		c.set(dataset, fields, modTime)
+		if time.Since(modTime) >= c.ttl {
+			c.revalidate(client, dataset)
 		}
-		c.fields[dataset] = fields
-		c.fetched[dataset] = time.Now()
-		c.mu.Unlock()
 		return fields, nil
 	}
+	return c.fetch(ctx, client, dataset)
+}
 
+// fetch lists dataset's fields from Axiom and caches them.
+func (c *fieldCache) fetch(ctx context.Context, client axiomclient.API, dataset string) ([]axiomclient.Field, error) {
 	result, err, _ := c.sf.Do("fields:"+dataset, func() (any, error) {
 		fields, err := client.ListFields(ctx, dataset)
 		if err != nil {
 			return nil, err
 		}
-		c.mu.Lock()
-		if c.fields == nil {
-			c.fields = make(map[string][]axiomclient.Field)
-			c.fetched = make(map[string]time.Time)
-		}
-		c.fields[dataset] = fields
-		c.fetched[dataset] = time.Now()
-		c.mu.Unlock()
+		c.recordChanges(dataset, fields)
+		c.set(dataset, fields, time.Now())
 		if err := c.saveDisk(dataset, fields); err != nil {
 			slog.Warn("failed to cache fields", "dataset", dataset, "error", err)
 		}
@@ -187,6 +467,77 @@ func (c *fieldCache) List(ctx context.Context, client axiomclient.API, dataset s
 	return result.([]axiomclient.Field), nil
 }
 
+// revalidate refreshes dataset's fields in the background; until it is
+// done, the cached ones are served however old.
+func (c *fieldCache) revalidate(client axiomclient.API, dataset string) {
+	c.mu.Lock()
+	if c.revalidating[dataset] {
+		c.mu.Unlock()
+		return
+	}
+	if c.revalidating == nil {
+		c.revalidating = make(map[string]bool)
+	}
+	c.revalidating[dataset] = true
+	c.mu.Unlock()
+	go func() {
+		defer func() {
+			c.mu.Lock()
+			delete(c.revalidating, dataset)
+			c.mu.Unlock()
+		}()
+		ctx, cancel := context.WithTimeout(context.Background(), backgroundRefresh)
+		defer cancel()
+		if _, err := c.fetch(ctx, client, dataset); err != nil {
+			slog.Warn("serving stale fields, refresh failed", "dataset", dataset, "error", err)
+		}
+	}()
+}
+
+// set caches a dataset's fields, fetched at fetched, and indexes them by
+// name.
+func (c *fieldCache) set(dataset string, fields []axiomclient.Field, fetched time.Time) {
+	index := make(map[string]int, len(fields))
+	for i, f := range fields {
+		if _, ok := index[f.Name]; !ok {
+			index[f.Name] = i
+		}
+	}
+	c.mu.Lock()
+	if c.fields == nil {
+		c.fields = make(map[string][]axiomclient.Field)
+		c.fetched = make(map[string]time.Time)
+		c.index = make(map[string]map[string]int)
+	}
+	c.fields[dataset] = fields
+	c.index[dataset] = index
+	c.fetched[dataset] = fetched
+	c.mu.Unlock()
+}
+
+// listAlias merges the fields of an alias's member datasets. When members
+// disagree on a field's type, the first member's definition wins. Members
+// are listed as datasets even where an alias has the same name, so a
+// self-referencing alias cannot recurse.
+func (c *fieldCache) listAlias(ctx context.Context, client axiomclient.API, members []string) ([]axiomclient.Field, error) {
+	seen := map[string]bool{}
+	merged := []axiomclient.Field{}
+	for _, member := range members {
+		fields, err := c.listDataset(ctx, client, member)
+		if err != nil {
+			return nil, err
+		}
+		for _, f := range fields {
+			if seen[f.Name] {
+				continue
+			}
+			seen[f.Name] = true
+			merged = append(merged, f)
+		}
+	}
+	return merged, nil
+}
+
 func (c *fieldCache) diskPath(dataset string) string {
 	if c.dir == "" {
 		return ""
@@ -194,13 +545,24 @@ func (c *fieldCache) diskPath(dataset string) string {
 	return filepath.Join(c.dir, "fields", dataset+".json")
 }
 
-func (c *fieldCache) loadDisk(dataset string) ([]axiomclient.Field, bool) {
+// loadDisk reads dataset's saved fields with the time they were saved,
+// unless they are past ttl and stale is false.
+func (c *fieldCache) loadDisk(dataset string, stale bool) ([]axiomclient.Field, time.Time, bool) {
 	path := c.diskPath(dataset)
 	if path == "" {
-		return nil, false
+		return nil, time.Time{}, false
 	}
 	info, err := os.Stat(path)
-	if err != nil || time.Since(info.ModTime()) > c.ttl {
+	if err != nil || !stale && time.Since(info.ModTime()) > c.ttl {
+		return nil, time.Time{}, false
+	}
+	fields, ok := c.readDisk(path)
+	return fields, info.ModTime(), ok
+}
+
+// readDisk reads a field list saved by saveDisk, however old.
+func (c *fieldCache) readDisk(path string) ([]axiomclient.Field, bool) {
+	if path == "" {
 		return nil, false
 	}
 	data, err := os.ReadFile(path)
@@ -231,6 +593,18 @@ func (c *fieldCache) Lookup(ctx context.Context, client axiomclient.API, dataset
 	if err != nil {
 		return axiomclient.Field{}, false, err
 	}
+	c.mu.RLock()
+	index := c.index[dataset]
+	c.mu.RUnlock()
+	if index != nil {
+		// The index may belong to a newer listing than fields; check the
+		// position still names the field.
+		if i, ok := index[fieldName]; ok && i < len(fields) && fields[i].Name == fieldName {
+			return fields[i], true, nil
+		} else if !ok {
+			return axiomclient.Field{}, false, nil
+		}
+	}
 	for _, f := range fields {
 		if f.Name == fieldName {
 			return f, true, nil
@@ -243,28 +617,289 @@ type Root struct {
 	fsys *FS
 }
 
-func (r *Root) Config() config.Config    { return r.fsys.Config }
-func (r *Root) Client() axiomclient.API  { return r.fsys.Client }
-func (r *Root) Executor() query.Runner   { return r.fsys.Executor }
-func (r *Root) Store() *store.QueryStore { return r.fsys.Store }
+func (r *Root) Config() config.Config           { return r.fsys.Config }
+func (r *Root) Client() axiomclient.API         { return r.fsys.Client }
+func (r *Root) Executor() query.Runner          { return r.fsys.Executor }
+func (r *Root) Store() *store.QueryStore        { return r.fsys.Store }
+func (r *Root) Snippets() *store.QueryStore     { return r.fsys.Snippets }
+func (r *Root) Vars() *store.QueryStore         { return r.fsys.Vars }
+func (r *Root) Snapshots() *store.SnapshotStore { return r.fsys.Snapshots }
+func (r *Root) Batches() *store.BatchStore      { return r.fsys.Batches }
+func (r *Root) Policy() *policy.Policy          { return r.fsys.Policy }
+func (r *Root) Links() *urlbuilder.Builder      { return r.fsys.Links }
+func (r *Root) Tails() *tail.Manager            { return r.fsys.Tails }
+func (r *Root) Reads() *throttle.Limiter        { return r.fsys.Reads }
+func (r *Root) Latency() *latency.Recorder      { return r.fsys.Latency }
+func (r *Root) Finder() *finder.Filter          { return r.fsys.Finder }
+func (r *Root) Events() *events.Log             { return r.fsys.Events }
+
+// Ownership returns the owner and permission bits of name, a slash path
+// relative to the mount root: -uid, -gid, -file-mode and -dir-mode, as
+// overridden by the policy's owners rules.
+func (r *Root) Ownership(name string) policy.Ownership {
+	return r.Policy().Ownership(name, r.fsys.owner)
+}
+
+// baseOwnership resolves the mount-wide ownership flags, negative IDs
+// meaning the process's own.
+func baseOwnership(cfg config.Config) policy.Ownership {
+	uid, gid := cfg.UID, cfg.GID
+	if uid < 0 {
+		uid = os.Getuid()
+	}
+	if gid < 0 {
+		gid = os.Getgid()
+	}
+	return policy.Ownership{
+		UID:      uint32(uid),
+		GID:      uint32(gid),
+		FileMode: os.FileMode(cfg.FileMode) & os.ModePerm,
+		DirMode:  os.FileMode(cfg.DirMode) & os.ModePerm,
+	}
+}
 
 func (r *Root) datasets() *datasetCache { return &r.fsys.datasets }
 func (r *Root) fields() *fieldCache     { return &r.fsys.fields }
 
+// distinctFields returns the dataset's field names when segments use
+// distinct/ or select/, so the compiler can reject unknown fields before
+// querying. It returns nil, skipping the check, otherwise or when fields
+// can't be listed.
+func (r *Root) distinctFields(ctx context.Context, dataset string, segments []string) []string {
+	if !slices.Contains(segments, "distinct") && !slices.Contains(segments, "select") {
+		return nil
+	}
+	fields, err := r.fields().List(ctx, r.Client(), dataset)
+	if err != nil {
+		return nil
+	}
+	names := make([]string, 0, len(fields))
+	for _, f := range fields {
+		names = append(names, f.Name)
+	}
+	return names
+}
+
+// fieldListed reports whether field of dataset appears in fields/
+// listings, schema.csv, schema.jsonschema and field search. Fields the
+// redaction or column rules drop never do.
+func (r *Root) fieldListed(dataset string, field axiomclient.Field) bool {
+	if r.redactor(dataset).Dropped(field.Name) {
+		return false
+	}
+	return !field.Hidden || r.fsys.Config.IncludeHiddenFields
+}
+
+// redactor is the Redactor for the results of dataset, with the column
+// rules of the dataset, or of an alias's members, applied.
+func (r *Root) redactor(dataset string) *redact.Redactor {
+	return r.fsys.Redactor.Scope(r.source(dataset))
+}
+
+// datasetDirInfo returns directory info for a dataset whose size is the
+// dataset's ingested bytes and whose mtime is its latest event time.
+func (r *Root) datasetDirInfo(ctx context.Context, name string) os.FileInfo {
+	members, isAlias := r.aliases()[name]
+	if !isAlias {
+		members = []string{name}
+	}
+	var (
+		size   int64
+		latest time.Time
+		found  bool
+	)
+	for _, member := range members {
+		stats, ok := r.fsys.stats.Get(ctx, r.fsys.Client, member)
+		if !ok {
+			continue
+		}
+		found = true
+		size += stats.InputBytes
+		if stats.MaxTime.After(latest) {
+			latest = stats.MaxTime
+		}
+	}
+	if !found {
+		return DirInfo(name)
+	}
+	return DirInfoAt(name, size, latest)
+}
+
+// datasetConfig is the configuration with dataset's default overrides
+// applied.
+func (r *Root) datasetConfig(dataset string) config.Config {
+	cfg := r.fsys.Config.ForDataset(dataset)
+	cfg.Aliases = r.aliases()
+	return cfg
+}
+
+func (r *Root) source(dataset string) string {
+	return compiler.Source(dataset, r.aliases())
+}
+
+func (r *Root) aliases() map[string][]string {
+	return r.fsys.aliases()
+}
+
+// aliases returns the configured aliases with glob members, such as
+// "logs-*", expanded to the visible datasets they match in the last dataset
+// listing. An alias whose members match no dataset is left out, and so is
+// one named like a dataset in that listing: the dataset wins everywhere,
+// in queries and field lists as well as in the listing.
+func (fsys *FS) aliases() map[string][]string {
+	configured := fsys.Config.Aliases
+	if len(configured) == 0 {
+		return configured
+	}
+	datasets := fsys.datasets.cached()
+	resolved := make(map[string][]string, len(configured))
+	for name, members := range configured {
+		if slices.ContainsFunc(datasets, func(d axiomclient.Dataset) bool { return d.Name == name }) {
+			continue
+		}
+		var expanded []string
+		for _, member := range members {
+			if !config.IsDatasetGlob(member) {
+				if !slices.Contains(expanded, member) {
+					expanded = append(expanded, member)
+				}
+				continue
+			}
+			var matches []string
+			for _, d := range datasets {
+				if ok, _ := path.Match(member, d.Name); ok && fsys.Policy.DatasetVisible(d.Name) && !slices.Contains(expanded, d.Name) {
+					matches = append(matches, d.Name)
+				}
+			}
+			sort.Strings(matches)
+			expanded = append(expanded, matches...)
+		}
+		if len(expanded) > 0 {
+			resolved[name] = expanded
+		}
+	}
+	return resolved
+}
+
+// listDatasets returns the real datasets followed by configured aliases.
+// An alias that shadows a real dataset is ignored; see FS.aliases.
+func (r *Root) listDatasets(ctx context.Context) ([]axiomclient.Dataset, error) {
+	datasets, err := r.visibleDatasets(ctx)
+	if err != nil {
+		return nil, err
+	}
+	aliases := r.visibleAliases()
+	if len(aliases) == 0 {
+		return datasets, nil
+	}
+	real := make(map[string]bool, len(datasets))
+	for _, d := range datasets {
+		real[d.Name] = true
+	}
+	all := append([]axiomclient.Dataset{}, datasets...)
+	names := make([]string, 0, len(aliases))
+	for name := range aliases {
+		if !real[name] {
+			names = append(names, name)
+		}
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		all = append(all, axiomclient.Dataset{
+			ID:          name,
+			Name:        name,
+			Kind:        "alias",
+			Description: "union of " + strings.Join(aliases[name], ", "),
+		})
+	}
+	return all, nil
+}
+
+// visibleDatasets returns the real datasets the policy allows, including
+// deleted ones still in their grace period.
+func (r *Root) visibleDatasets(ctx context.Context) ([]axiomclient.Dataset, error) {
+	datasets, err := r.fsys.datasets.List(ctx, r.fsys.Client)
+	if err != nil {
+		return nil, err
+	}
+	pol := r.fsys.Policy
+	visible := make([]axiomclient.Dataset, 0, len(datasets))
+	listed := make(map[string]bool, len(datasets))
+	for _, d := range datasets {
+		listed[d.Name] = true
+		if pol.DatasetVisible(d.Name) {
+			visible = append(visible, d)
+		}
+	}
+	for _, d := range r.buriedDatasets() {
+		if !listed[d.Name] && pol.DatasetVisible(d.Name) {
+			visible = append(visible, d)
+		}
+	}
+	return visible, nil
+}
+
+// visibleAliases returns the aliases whose name and members are all visible,
+// so an alias cannot expose a hidden dataset.
+func (r *Root) visibleAliases() map[string][]string {
+	pol := r.fsys.Policy
+	configured := r.aliases()
+	aliases := make(map[string][]string, len(configured))
+	for name, members := range configured {
+		if !pol.DatasetVisible(name) {
+			continue
+		}
+		ok := true
+		for _, m := range members {
+			if !pol.DatasetVisible(m) {
+				ok = false
+				break
+			}
+		}
+		if ok {
+			aliases[name] = members
+		}
+	}
+	return aliases
+}
+
 func (r *Root) Stat(ctx context.Context) (os.FileInfo, error) {
 	return DirInfo(""), nil
 }
 
 func (r *Root) ReadDir(ctx context.Context) ([]os.FileInfo, error) {
+	if len(r.fsys.tenants) > 0 {
+		return (&TenantDir{root: r}).ReadDir(ctx)
+	}
 	entries := []os.FileInfo{
 		DirInfo("datasets"),
 		FileInfo("README.txt", 0),
 		DirInfo("examples"),
 		DirInfo("_presets"),
 		DirInfo("_queries"),
+		DirInfo("_status"),
+		DirInfo("_events"),
+		DirInfo("_search"),
+		DirInfo("_snippets"),
+		DirInfo("_batch"),
+		DirInfo("_templates"),
+		DirInfo("_org"),
+		DirInfo("_meta"),
+		DirInfo("_policy"),
+		FileInfo("_aliases.json", 0),
+		FileInfo("_manifest.json", 0),
+	}
+	if _, ok := r.dashboardClient(); ok {
+		entries = append(entries, DirInfo("_dashboards"))
+	}
+	if _, ok := r.Executor().(diskUsageReporter); ok {
+		entries = append(entries, DirInfo("_cache"))
+	}
+	if r.fsys.Config.EnableAdminFiles {
+		entries = append(entries, DirInfo(AdminDir))
 	}
 
-	datasets, err := r.fsys.datasets.List(ctx, r.fsys.Client)
+	datasets, err := r.listDatasets(ctx)
 	if err != nil {
 		return nil, err
 	}
@@ -275,13 +910,19 @@ func (r *Root) ReadDir(ctx context.Context) ([]os.FileInfo, error) {
 		if isReservedRoot(dataset.Name) {
 			continue
 		}
-		entries = append(entries, DirInfo(dataset.Name))
+		entries = append(entries, r.datasetDirInfo(ctx, dataset.Name))
+	}
+	if _, stale := r.staleFile(); stale {
+		entries = append(entries, DynamicFileInfo(".stale"))
 	}
 	sort.Slice(entries, func(i, j int) bool { return entries[i].Name() < entries[j].Name() })
 	return entries, nil
 }
 
 func (r *Root) Lookup(ctx context.Context, name string) (Node, error) {
+	if len(r.fsys.tenants) > 0 {
+		return (&TenantDir{root: r}).Lookup(ctx, name)
+	}
 	switch name {
 	case "README.txt":
 		return &StaticFile{name: name, data: readmeText}, nil
@@ -293,6 +934,50 @@ func (r *Root) Lookup(ctx context.Context, name string) (Node, error) {
 		return &PresetsDir{}, nil
 	case "_queries":
 		return &QueriesDir{root: r}, nil
+	case "_status":
+		return &StatusDir{root: r}, nil
+	case "_events":
+		return &EventsDir{root: r}, nil
+	case "_search":
+		return &SearchDir{root: r}, nil
+	case "_snippets":
+		return &SnippetsDir{root: r}, nil
+	case "_batch":
+		return &BatchDir{root: r}, nil
+	case "_templates":
+		return &TemplatesDir{}, nil
+	case "_org":
+		return &OrgDir{root: r}, nil
+	case "_meta":
+		return &MetaDir{root: r}, nil
+	case "_policy":
+		return &PolicyDir{root: r}, nil
+	case "_dashboards":
+		client, ok := r.dashboardClient()
+		if !ok {
+			return nil, os.ErrNotExist
+		}
+		return &DashboardsDir{root: r, client: client}, nil
+	case "_aliases.json":
+		return &StaticFile{name: name, data: aliasesJSON(r.visibleAliases())}, nil
+	case "_manifest.json":
+		return &StatusFile{name: name, build: r.manifest}, nil
+	case "_cache":
+		reporter, ok := r.Executor().(diskUsageReporter)
+		if !ok {
+			return nil, os.ErrNotExist
+		}
+		return &CacheInfoDir{reporter: reporter}, nil
+	case AdminDir:
+		if !r.fsys.Config.EnableAdminFiles {
+			return nil, os.ErrNotExist
+		}
+		return &AdminFilesDir{root: r}, nil
+	case ".stale":
+		if node, stale := r.staleFile(); stale {
+			return node, nil
+		}
+		return nil, os.ErrNotExist
 	}
 
 	dataset, err := r.lookupDataset(ctx, name)
@@ -306,7 +991,7 @@ func (r *Root) Lookup(ctx context.Context, name string) (Node, error) {
 }
 
 func (r *Root) lookupDataset(ctx context.Context, name string) (*axiomclient.Dataset, error) {
-	datasets, err := r.fsys.datasets.List(ctx, r.fsys.Client)
+	datasets, err := r.listDatasets(ctx)
 	if err != nil {
 		return nil, err
 	}
@@ -320,9 +1005,17 @@ func (r *Root) lookupDataset(ctx context.Context, name string) (*axiomclient.Dat
 
 func isReservedRoot(name string) bool {
 	switch name {
-	case "datasets", "README.txt", "examples", "_presets", "_queries":
+	case "datasets", "README.txt", "examples", "_presets", "_queries", "_status", "_events", "_search", "_snippets", "_batch", "_templates", "_dashboards", "_org", "_meta", "_policy", "_aliases.json", "_manifest.json", "_cache", ".stale", AdminDir:
 		return true
 	default:
 		return false
 	}
 }
+
+func aliasesJSON(aliases map[string][]string) []byte {
+	if aliases == nil {
+		aliases = map[string][]string{}
+	}
+	data, _ := json.MarshalIndent(aliases, "", "  ")
+	return append(data, '\n')
+}
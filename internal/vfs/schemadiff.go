@@ -0,0 +1,163 @@
+package vfs
+
+import (
+	"context"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/axiomhq/axiom-fs/internal/axiomclient"
+)
+
+// maxSchemaChanges is how many field changes are kept per dataset; older
+// ones are dropped.
+const maxSchemaChanges = 1000
+
+// Field changes recorded in schema.diff.json.
+const (
+	schemaAdded   = "added"
+	schemaRemoved = "removed"
+	schemaRetyped = "retyped"
+)
+
+// schemaChange is one field added, removed or retyped between two fetches
+// of a dataset's field list.
+type schemaChange struct {
+	Field   string `json:"field"`
+	Change  string `json:"change"`
+	Type    string `json:"type,omitempty"`
+	OldType string `json:"old_type,omitempty"`
+	// At is when the fetch that found the change ran.
+	At time.Time `json:"at"`
+	// Dataset is the member dataset that changed, for aliases.
+	Dataset string `json:"dataset,omitempty"`
+}
+
+// schemaDiff is <dataset>/schema.diff.json.
+type schemaDiff struct {
+	Dataset string `json:"dataset"`
+	// CheckedAt is when the field list was last fetched from Axiom.
+	CheckedAt *time.Time     `json:"checked_at,omitempty"`
+	Changes   []schemaChange `json:"changes"`
+}
+
+// diffFields lists how current differs from old: added and retyped fields
+// in current's order, then removed ones in old's.
+func diffFields(old, current []axiomclient.Field, at time.Time) []schemaChange {
+	oldTypes := make(map[string]string, len(old))
+	for _, f := range old {
+		oldTypes[f.Name] = f.Type
+	}
+	seen := make(map[string]bool, len(current))
+	var changes []schemaChange
+	for _, f := range current {
+		seen[f.Name] = true
+		oldType, ok := oldTypes[f.Name]
+		switch {
+		case !ok:
+			changes = append(changes, schemaChange{Field: f.Name, Change: schemaAdded, Type: f.Type, At: at})
+		case oldType != f.Type:
+			changes = append(changes, schemaChange{Field: f.Name, Change: schemaRetyped, Type: f.Type, OldType: oldType, At: at})
+		}
+	}
+	for _, f := range old {
+		if !seen[f.Name] {
+			changes = append(changes, schemaChange{Field: f.Name, Change: schemaRemoved, OldType: f.Type, At: at})
+		}
+	}
+	return changes
+}
+
+// recordChanges compares fields, just fetched from Axiom, with the last
+// list seen for dataset, in memory or on disk, and keeps what changed.
+func (c *fieldCache) recordChanges(dataset string, fields []axiomclient.Field) {
+	c.mu.RLock()
+	previous, ok := c.fields[dataset]
+	c.mu.RUnlock()
+	if !ok {
+		if previous, ok = c.readDisk(c.diskPath(dataset)); !ok {
+			return
+		}
+	}
+	changes := diffFields(previous, fields, time.Now().UTC())
+	if len(changes) == 0 {
+		return
+	}
+	all := append(c.changes(dataset), changes...)
+	if len(all) > maxSchemaChanges {
+		all = all[len(all)-maxSchemaChanges:]
+	}
+	c.mu.Lock()
+	if c.drift == nil {
+		c.drift = make(map[string][]schemaChange)
+	}
+	c.drift[dataset] = all
+	c.mu.Unlock()
+	if path := c.changesPath(dataset); path != "" {
+		if data, err := json.Marshal(all); err == nil {
+			_ = os.WriteFile(path, data, 0o644)
+		}
+	}
+}
+
+// changes returns the field changes recorded for dataset, oldest first.
+func (c *fieldCache) changes(dataset string) []schemaChange {
+	c.mu.RLock()
+	changes, ok := c.drift[dataset]
+	c.mu.RUnlock()
+	if ok {
+		return changes
+	}
+	path := c.changesPath(dataset)
+	if path == "" {
+		return nil
+	}
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil
+	}
+	_ = json.Unmarshal(data, &changes)
+	return changes
+}
+
+func (c *fieldCache) changesPath(dataset string) string {
+	if c.dir == "" {
+		return ""
+	}
+	return filepath.Join(c.dir, "fields", dataset+".changes.json")
+}
+
+// schemaDiff refreshes the dataset's fields when they are older than the
+// metadata TTL, recording any drift, and lists the changes seen so far. An
+// alias lists the changes of its members.
+func (r *Root) schemaDiff(ctx context.Context, dataset string) (any, error) {
+	if _, err := r.fields().List(ctx, r.Client(), dataset); err != nil {
+		return nil, err
+	}
+	members, ok := r.aliases()[dataset]
+	if !ok {
+		members = []string{dataset}
+	}
+	out := schemaDiff{Dataset: dataset, Changes: []schemaChange{}}
+	c := r.fields()
+	redactor := r.redactor(dataset)
+	for _, member := range members {
+		c.mu.RLock()
+		fetched, ok := c.fetched[member]
+		c.mu.RUnlock()
+		if ok && (out.CheckedAt == nil || fetched.Before(*out.CheckedAt)) {
+			out.CheckedAt = timePtr(fetched)
+		}
+		for _, change := range c.changes(member) {
+			if redactor.Dropped(change.Field) {
+				continue
+			}
+			if member != dataset {
+				change.Dataset = member
+			}
+			out.Changes = append(out.Changes, change)
+		}
+	}
+	return out, nil
+}
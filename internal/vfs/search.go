@@ -0,0 +1,171 @@
+package vfs
+
+import (
+	"bytes"
+	"context"
+	"encoding/csv"
+	"log/slog"
+	"os"
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/go-git/go-billy/v5"
+	"golang.org/x/sync/errgroup"
+)
+
+// fieldSearchConcurrency bounds concurrent ListFields calls during a search.
+const fieldSearchConcurrency = 8
+
+// SearchDir exposes org-wide searches under /_search.
+type SearchDir struct {
+	root *Root
+}
+
+func (s *SearchDir) Stat(ctx context.Context) (os.FileInfo, error) {
+	return DirInfo("_search"), nil
+}
+
+func (s *SearchDir) ReadDir(ctx context.Context) ([]os.FileInfo, error) {
+	return []os.FileInfo{DirInfo("fields")}, nil
+}
+
+func (s *SearchDir) Lookup(ctx context.Context, name string) (Node, error) {
+	if name == "fields" {
+		return &FieldSearchDir{root: s.root}, nil
+	}
+	return nil, os.ErrNotExist
+}
+
+// FieldSearchDir accepts any substring as a child directory name.
+type FieldSearchDir struct {
+	root *Root
+}
+
+func (f *FieldSearchDir) Stat(ctx context.Context) (os.FileInfo, error) {
+	return DirInfo("fields"), nil
+}
+
+func (f *FieldSearchDir) ReadDir(ctx context.Context) ([]os.FileInfo, error) {
+	return []os.FileInfo{}, nil
+}
+
+func (f *FieldSearchDir) Lookup(ctx context.Context, name string) (Node, error) {
+	if name == "" {
+		return nil, os.ErrNotExist
+	}
+	return &FieldSearchTermDir{root: f.root, term: name}, nil
+}
+
+type FieldSearchTermDir struct {
+	root *Root
+	term string
+}
+
+func (f *FieldSearchTermDir) Stat(ctx context.Context) (os.FileInfo, error) {
+	return DirInfo(f.term), nil
+}
+
+func (f *FieldSearchTermDir) ReadDir(ctx context.Context) ([]os.FileInfo, error) {
+	return []os.FileInfo{FileInfo("results.csv", 0)}, nil
+}
+
+func (f *FieldSearchTermDir) Lookup(ctx context.Context, name string) (Node, error) {
+	if name == "results.csv" {
+		return &FieldSearchResultsFile{root: f.root, term: f.term}, nil
+	}
+	return nil, os.ErrNotExist
+}
+
+// FieldSearchResultsFile lists dataset,field,type rows whose field name
+// contains the search term, case-insensitively.
+type FieldSearchResultsFile struct {
+	root *Root
+	term string
+}
+
+type fieldMatch struct {
+	dataset, field, fieldType string
+}
+
+func (f *FieldSearchResultsFile) search(ctx context.Context) ([]fieldMatch, error) {
+	datasets, err := f.root.visibleDatasets(ctx)
+	if err != nil {
+		return nil, err
+	}
+	term := strings.ToLower(f.term)
+
+	var (
+		mu      sync.Mutex
+		matches []fieldMatch
+	)
+	g, gctx := errgroup.WithContext(ctx)
+	g.SetLimit(fieldSearchConcurrency)
+	for _, dataset := range datasets {
+		if dataset.Name == "" {
+			continue
+		}
+		name := dataset.Name
+		g.Go(func() error {
+			fields, err := f.root.fields().List(gctx, f.root.Client(), name)
+			if err != nil {
+				// One unreadable dataset should not fail the whole search.
+				slog.Warn("field search: failed to list fields", "dataset", name, "error", err)
+				return nil
+			}
+			for _, field := range fields {
+				if !f.root.fieldListed(name, field) || !strings.Contains(strings.ToLower(field.Name), term) {
+					continue
+				}
+				mu.Lock()
+				matches = append(matches, fieldMatch{dataset: name, field: field.Name, fieldType: field.Type})
+				mu.Unlock()
+			}
+			return nil
+		})
+	}
+	if err := g.Wait(); err != nil {
+		return nil, err
+	}
+	sort.Slice(matches, func(i, j int) bool {
+		if matches[i].dataset != matches[j].dataset {
+			return matches[i].dataset < matches[j].dataset
+		}
+		return matches[i].field < matches[j].field
+	})
+	return matches, nil
+}
+
+func (f *FieldSearchResultsFile) build(ctx context.Context) ([]byte, error) {
+	matches, err := f.search(ctx)
+	if err != nil {
+		return nil, err
+	}
+	var buf bytes.Buffer
+	w := csv.NewWriter(&buf)
+	if err := w.Write([]string{"dataset", "field", "type"}); err != nil {
+		return nil, err
+	}
+	for _, m := range matches {
+		if err := w.Write([]string{m.dataset, m.field, m.fieldType}); err != nil {
+			return nil, err
+		}
+	}
+	w.Flush()
+	if err := w.Error(); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+func (f *FieldSearchResultsFile) Stat(ctx context.Context) (os.FileInfo, error) {
+	return DynamicFileInfo("results.csv"), nil
+}
+
+func (f *FieldSearchResultsFile) Open(ctx context.Context, flags int) (billy.File, error) {
+	data, err := f.build(ctx)
+	if err != nil {
+		return nil, err
+	}
+	return newBytesFile(data), nil
+}
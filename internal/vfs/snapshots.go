@@ -0,0 +1,142 @@
+package vfs
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"os"
+	"time"
+
+	"github.com/go-git/go-billy/v5"
+
+	"github.com/axiomhq/axiom-fs/internal/query"
+	"github.com/axiomhq/axiom-fs/internal/store"
+)
+
+// SnapshotDir lists the immutable result copies of a saved query, one
+// <RFC3339 time>.ndjson file per snapshot.
+type SnapshotDir struct {
+	root *Root
+	name string
+}
+
+func (s *SnapshotDir) Stat(ctx context.Context) (os.FileInfo, error) {
+	return DirInfo("snapshot"), nil
+}
+
+func (s *SnapshotDir) ReadDir(ctx context.Context) ([]os.FileInfo, error) {
+	snapshots := s.root.Snapshots().List(s.name)
+	entries := make([]os.FileInfo, 0, len(snapshots))
+	for _, snap := range snapshots {
+		entries = append(entries, FileInfoAt(snap.Name(), snap.Size, snap.At))
+	}
+	return entries, nil
+}
+
+func (s *SnapshotDir) Lookup(ctx context.Context, name string) (Node, error) {
+	for _, snap := range s.root.Snapshots().List(s.name) {
+		if snap.Name() == name {
+			return &SnapshotFile{root: s.root, name: s.name, snapshot: snap}, nil
+		}
+	}
+	return nil, os.ErrNotExist
+}
+
+type SnapshotFile struct {
+	root     *Root
+	name     string
+	snapshot store.Snapshot
+}
+
+func (s *SnapshotFile) Stat(ctx context.Context) (os.FileInfo, error) {
+	return FileInfoAt(s.snapshot.Name(), s.snapshot.Size, s.snapshot.At), nil
+}
+
+func (s *SnapshotFile) Open(ctx context.Context, flags int) (billy.File, error) {
+	snap, data, err := s.root.Snapshots().Read(s.name, s.snapshot.Name())
+	if err != nil {
+		return nil, err
+	}
+	return &bytesFile{data: data, reader: bytes.NewReader(data), modTime: snap.At}, nil
+}
+
+// SnapshotTriggerFile takes a snapshot of the saved query's current result
+// whenever something is written to it.
+type SnapshotTriggerFile struct {
+	root *Root
+	name string
+}
+
+func (s *SnapshotTriggerFile) Stat(ctx context.Context) (os.FileInfo, error) {
+	return WritableFileInfo("snapshot.trigger", 0), nil
+}
+
+func (s *SnapshotTriggerFile) Open(ctx context.Context, flags int) (billy.File, error) {
+	return newBytesFile(nil), nil
+}
+
+func (s *SnapshotTriggerFile) Create(ctx context.Context) (billy.File, error) {
+	return &triggerFile{take: s.take}, nil
+}
+
+// take executes the saved query bypassing the cache and stores the result.
+func (s *SnapshotTriggerFile) take(ctx context.Context) error {
+	apl, err := s.root.savedAPL(s.name)
+	if err != nil {
+		return err
+	}
+	at := time.Now()
+	result, err := s.root.Executor().ExecuteAPLResult(ctx, apl, "ndjson", query.ExecOptions{Headers: savedQueryLabel(s.name)})
+	if err != nil {
+		return err
+	}
+	defer result.Release()
+	var r io.Reader = bytes.NewReader(result.Bytes)
+	if result.File != nil {
+		r = result.File
+	}
+	_, err = s.root.Snapshots().Save(s.name, at, r)
+	return err
+}
+
+// triggerFile discards what is written and runs take on the first Close
+// after a write.
+type triggerFile struct {
+	take    func(ctx context.Context) error
+	written bool
+	closed  bool
+}
+
+func (f *triggerFile) Name() string { return "snapshot.trigger" }
+func (f *triggerFile) Size() int64  { return 0 }
+
+func (f *triggerFile) Read(p []byte) (int, error) {
+	return 0, io.EOF
+}
+
+func (f *triggerFile) ReadAt(p []byte, off int64) (int, error) {
+	return 0, io.EOF
+}
+
+func (f *triggerFile) Seek(offset int64, whence int) (int64, error) {
+	return 0, nil
+}
+
+func (f *triggerFile) Write(p []byte) (int, error) {
+	f.written = true
+	return len(p), nil
+}
+
+func (f *triggerFile) Close() error {
+	if !f.written || f.closed {
+		return nil
+	}
+	f.closed = true
+	return f.take(context.Background())
+}
+
+func (f *triggerFile) Lock() error   { return nil }
+func (f *triggerFile) Unlock() error { return nil }
+func (f *triggerFile) Truncate(size int64) error {
+	return nil
+}
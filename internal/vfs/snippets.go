@@ -0,0 +1,162 @@
+package vfs
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"sort"
+
+	"github.com/go-git/go-billy/v5"
+
+	"github.com/axiomhq/axiom-fs/internal/apl"
+	"github.com/axiomhq/axiom-fs/internal/query"
+)
+
+// SnippetsDir holds reusable APL fragments for `#include` in saved queries.
+type SnippetsDir struct {
+	root *Root
+}
+
+func (s *SnippetsDir) Stat(ctx context.Context) (os.FileInfo, error) {
+	return DirInfo("_snippets"), nil
+}
+
+func (s *SnippetsDir) ReadDir(ctx context.Context) ([]os.FileInfo, error) {
+	names := s.root.Snippets().Names()
+	entries := make([]os.FileInfo, 0, len(names))
+	for _, name := range names {
+		entries = append(entries, WritableFileInfo(name, int64(len(s.root.Snippets().Get(name)))))
+	}
+	sort.Slice(entries, func(i, j int) bool { return entries[i].Name() < entries[j].Name() })
+	return entries, nil
+}
+
+func (s *SnippetsDir) Lookup(ctx context.Context, name string) (Node, error) {
+	if !isValidQueryName(name) {
+		return nil, os.ErrNotExist
+	}
+	return &SnippetFile{root: s.root, name: name}, nil
+}
+
+type SnippetFile struct {
+	root *Root
+	name string
+}
+
+func (s *SnippetFile) Stat(ctx context.Context) (os.FileInfo, error) {
+	return WritableFileInfo(s.name, int64(len(s.root.Snippets().Get(s.name)))), nil
+}
+
+func (s *SnippetFile) Open(ctx context.Context, flags int) (billy.File, error) {
+	return newBytesFile(s.root.Snippets().Get(s.name)), nil
+}
+
+func (s *SnippetFile) Create(ctx context.Context) (billy.File, error) {
+	return newAPLFile(s.root.Snippets(), s.name), nil
+}
+
+// resolveInclude finds an include target, preferring snippets over saved
+// queries of the same name.
+func (r *Root) resolveInclude(name string) (string, bool) {
+	if data := r.Snippets().Get(name); len(data) > 0 {
+		return string(data), true
+	}
+	if data := r.Store().Get(name); len(data) > 0 {
+		return string(data), true
+	}
+	return "", false
+}
+
+// expandedAPL returns a saved query with its `#include` directives spliced in
+// and its template placeholders filled in from vars.
+func (r *Root) expandedAPL(name string) (string, error) {
+	src, _, err := r.expandedAPLRevision(name)
+	return src, err
+}
+
+// expandedAPLRevision is expandedAPL plus the revision of the query, which
+// changes whenever its APL or vars are rewritten.
+func (r *Root) expandedAPLRevision(name string) (string, uint64, error) {
+	data, rev := r.Store().GetRevision(name)
+	vars, varsRev := r.Vars().GetRevision(name)
+	rev += varsRev
+	src, err := apl.ExpandIncludes(name, string(data), r.resolveInclude)
+	if err != nil {
+		return "", rev, err
+	}
+	src, err = renderVars(src, vars)
+	return src, rev, err
+}
+
+// revision is the saved query's revision; see expandedAPLRevision.
+func (r *Root) revision(name string) uint64 {
+	return r.Store().Revision(name) + r.Vars().Revision(name)
+}
+
+// savedAPL returns the expanded, validated APL of a saved query.
+func (r *Root) savedAPL(name string) (string, error) {
+	src, _, err := r.savedAPLRevision(name)
+	return src, err
+}
+
+// savedAPLRevision is savedAPL plus the store revision the APL was read at.
+func (r *Root) savedAPLRevision(name string) (string, uint64, error) {
+	src, rev, err := r.expandedAPLRevision(name)
+	if err != nil {
+		return "", rev, err
+	}
+	if err := query.ValidateAPL(src); err != nil {
+		return "", rev, err
+	}
+	if err := r.checkAccess(src); err != nil {
+		return "", rev, err
+	}
+	return src, rev, nil
+}
+
+// checkAccess fails with a permission error when src reads a dataset or a
+// column the mount policy hides; see checkDatasets and checkColumns.
+func (r *Root) checkAccess(src string) error {
+	if err := r.checkDatasets(src); err != nil {
+		return err
+	}
+	return r.checkColumns(src)
+}
+
+// checkDatasets fails with a permission error when src may read a dataset
+// the mount policy hides, directly or through an alias, or, under a policy
+// that hides any, when it cannot tell which datasets src reads. Bracket-
+// quoted field names are checked like datasets.
+func (r *Root) checkDatasets(src string) error {
+	pol := r.fsys.Policy
+	if !pol.RestrictsDatasets() {
+		return nil
+	}
+	names := apl.Datasets(src)
+	if names == nil {
+		return fmt.Errorf("%w: cannot tell which datasets the query reads under a dataset policy", os.ErrPermission)
+	}
+	aliases := r.aliases()
+	for _, name := range names {
+		for _, dataset := range append([]string{name}, aliases[name]...) {
+			if !pol.DatasetVisible(dataset) {
+				return fmt.Errorf("%w: dataset %q is hidden by the mount policy", os.ErrPermission, dataset)
+			}
+		}
+	}
+	return nil
+}
+
+// checkColumns fails with a permission error when src reads a column the
+// column rules deny, in any stage, rather than serving the result without
+// it: dropping result columns by name would miss one renamed or
+// aggregated on the way.
+func (r *Root) checkColumns(src string) error {
+	redactor := r.fsys.Redactor.Scope(src)
+	for _, column := range apl.References(src) {
+		if redactor.Denied(column) {
+			return fmt.Errorf("%w: column %q is denied by the mount policy", os.ErrPermission, column)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,207 @@
+package vfs
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"os"
+
+	"github.com/go-git/go-billy/v5"
+
+	"github.com/axiomhq/axiom-fs/internal/axiomclient"
+	"github.com/axiomhq/axiom-fs/internal/cache"
+	"github.com/axiomhq/axiom-fs/internal/latency"
+	"github.com/axiomhq/axiom-fs/internal/query"
+)
+
+// StatusDir exposes live server state under /_status.
+type StatusDir struct {
+	root *Root
+}
+
+func (s *StatusDir) Stat(ctx context.Context) (os.FileInfo, error) {
+	return DirInfo("_status"), nil
+}
+
+// warmReporter is implemented by executors that warm the cache.
+type warmReporter interface {
+	WarmStatus() query.WarmStatus
+}
+
+// cacheReporter is implemented by executors that report cache segments.
+type cacheReporter interface {
+	CacheStats() cache.Stats
+}
+
+// costReporter is implemented by executors that attribute query costs to
+// labels.
+type costReporter interface {
+	Costs() []query.CostUsage
+}
+
+// inflightReporter is implemented by executors that track the queries
+// they are running.
+type inflightReporter interface {
+	Inflight() query.InflightStatus
+}
+
+// clientReporter is implemented by executors that queue queries per NFS
+// client.
+type clientReporter interface {
+	Clients() query.ClientStatus
+}
+
+func (s *StatusDir) ReadDir(ctx context.Context) ([]os.FileInfo, error) {
+	entries := []os.FileInfo{
+		FileInfo("metadata.json", 0),
+		FileInfo("quota.json", 0),
+		FileInfo("transfer.json", 0),
+		FileInfo("throttle.json", 0),
+		FileInfo("latency.json", 0),
+		FileInfo("slow.ndjson", 0),
+		FileInfo("finder.json", 0),
+	}
+	if _, ok := s.root.Executor().(warmReporter); ok {
+		entries = append(entries, FileInfo("warm.json", 0))
+	}
+	if _, ok := s.root.Executor().(cacheReporter); ok {
+		entries = append(entries, FileInfo("cache.json", 0))
+	}
+	if _, ok := s.root.Executor().(costReporter); ok {
+		entries = append(entries, FileInfo("costs.json", 0))
+	}
+	if _, ok := s.root.Executor().(inflightReporter); ok {
+		entries = append(entries, FileInfo("inflight.json", 0))
+	}
+	if _, ok := s.root.Executor().(clientReporter); ok {
+		entries = append(entries, FileInfo("clients.json", 0))
+	}
+	return entries, nil
+}
+
+func (s *StatusDir) Lookup(ctx context.Context, name string) (Node, error) {
+	switch name {
+	case "metadata.json":
+		return &StatusFile{name: name, build: func(ctx context.Context) (any, error) {
+			return s.root.metadataStatus(), nil
+		}}, nil
+	case "quota.json":
+		return &StatusFile{name: name, build: func(ctx context.Context) (any, error) {
+			return s.root.fsys.Quota.Snapshot(), nil
+		}}, nil
+	case "transfer.json":
+		return &StatusFile{name: name, build: func(ctx context.Context) (any, error) {
+			if s.root.fsys.Transfer == nil {
+				return axiomclient.TransferStats{}, nil
+			}
+			return s.root.fsys.Transfer(), nil
+		}}, nil
+	case "throttle.json":
+		return &StatusFile{name: name, build: func(ctx context.Context) (any, error) {
+			return s.root.fsys.Reads.Stats(), nil
+		}}, nil
+	case "latency.json":
+		return &StatusFile{name: name, build: func(ctx context.Context) (any, error) {
+			return s.root.Latency().Stats(), nil
+		}}, nil
+	case "slow.ndjson":
+		return &SlowLogFile{latency: s.root.Latency()}, nil
+	case "finder.json":
+		return &StatusFile{name: name, build: func(ctx context.Context) (any, error) {
+			return s.root.Finder().Stats(), nil
+		}}, nil
+	case "warm.json":
+		warm, ok := s.root.Executor().(warmReporter)
+		if !ok {
+			return nil, os.ErrNotExist
+		}
+		return &StatusFile{name: name, build: func(ctx context.Context) (any, error) {
+			return warm.WarmStatus(), nil
+		}}, nil
+	case "cache.json":
+		reporter, ok := s.root.Executor().(cacheReporter)
+		if !ok {
+			return nil, os.ErrNotExist
+		}
+		return &StatusFile{name: name, build: func(ctx context.Context) (any, error) {
+			return reporter.CacheStats(), nil
+		}}, nil
+	case "costs.json":
+		reporter, ok := s.root.Executor().(costReporter)
+		if !ok {
+			return nil, os.ErrNotExist
+		}
+		return &StatusFile{name: name, build: func(ctx context.Context) (any, error) {
+			return reporter.Costs(), nil
+		}}, nil
+	case "inflight.json":
+		reporter, ok := s.root.Executor().(inflightReporter)
+		if !ok {
+			return nil, os.ErrNotExist
+		}
+		return &StatusFile{name: name, build: func(ctx context.Context) (any, error) {
+			return reporter.Inflight(), nil
+		}}, nil
+	case "clients.json":
+		reporter, ok := s.root.Executor().(clientReporter)
+		if !ok {
+			return nil, os.ErrNotExist
+		}
+		return &StatusFile{name: name, build: func(ctx context.Context) (any, error) {
+			return reporter.Clients(), nil
+		}}, nil
+	default:
+		return nil, os.ErrNotExist
+	}
+}
+
+// StatusFile renders a JSON snapshot on every open.
+type StatusFile struct {
+	name  string
+	build func(ctx context.Context) (any, error)
+}
+
+func (s *StatusFile) render(ctx context.Context) ([]byte, error) {
+	payload, err := s.build(ctx)
+	if err != nil {
+		return nil, err
+	}
+	data, err := json.MarshalIndent(payload, "", "  ")
+	if err != nil {
+		return nil, err
+	}
+	return append(data, '\n'), nil
+}
+
+func (s *StatusFile) Stat(ctx context.Context) (os.FileInfo, error) {
+	return DynamicFileInfo(s.name), nil
+}
+
+func (s *StatusFile) Open(ctx context.Context, flags int) (billy.File, error) {
+	data, err := s.render(ctx)
+	if err != nil {
+		return nil, err
+	}
+	return newBytesFile(data), nil
+}
+
+// SlowLogFile is /_status/slow.ndjson: the recent operations slower than
+// -slow-op-threshold, oldest first.
+type SlowLogFile struct {
+	latency *latency.Recorder
+}
+
+func (s *SlowLogFile) Stat(ctx context.Context) (os.FileInfo, error) {
+	return DynamicFileInfo("slow.ndjson"), nil
+}
+
+func (s *SlowLogFile) Open(ctx context.Context, flags int) (billy.File, error) {
+	var buf bytes.Buffer
+	enc := json.NewEncoder(&buf)
+	for _, entry := range s.latency.Slow() {
+		if err := enc.Encode(entry); err != nil {
+			return nil, err
+		}
+	}
+	return newBytesFile(buf.Bytes()), nil
+}
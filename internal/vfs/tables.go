@@ -0,0 +1,71 @@
+package vfs
+
+import (
+	"context"
+	"os"
+	"strings"
+
+	"github.com/go-git/go-billy/v5"
+
+	"github.com/axiomhq/axiom-fs/internal/query"
+)
+
+// ResultTablesDir is a query's tables/ directory: one <name>.csv per result
+// table, for queries whose result has more than one, such as those using
+// fork.
+type ResultTablesDir struct {
+	// names lists the result tables, as query.TableNames does.
+	names func(ctx context.Context) ([]string, error)
+	// result runs the query encoding only the named table as CSV.
+	result func(ctx context.Context, table string) (query.ResultData, error)
+	// estimate sizes the named table's CSV without running the query.
+	estimate func(ctx context.Context, table string) (query.ResultEstimate, error)
+}
+
+func (t *ResultTablesDir) Stat(ctx context.Context) (os.FileInfo, error) {
+	return DirInfo("tables"), nil
+}
+
+func (t *ResultTablesDir) ReadDir(ctx context.Context) ([]os.FileInfo, error) {
+	names, err := t.names(ctx)
+	if err != nil {
+		return nil, err
+	}
+	entries := make([]os.FileInfo, 0, len(names))
+	for _, name := range names {
+		entries = append(entries, QueryFileInfo(name+".csv"))
+	}
+	return entries, nil
+}
+
+func (t *ResultTablesDir) Lookup(ctx context.Context, name string) (Node, error) {
+	table, ok := strings.CutSuffix(name, ".csv")
+	if !ok || table == "" {
+		return nil, os.ErrNotExist
+	}
+	return &ResultTableFile{name: name, table: table, result: t.result, estimate: t.estimate}, nil
+}
+
+// ResultTableFile is tables/<name>.csv.
+type ResultTableFile struct {
+	name     string
+	table    string
+	result   func(ctx context.Context, table string) (query.ResultData, error)
+	estimate func(ctx context.Context, table string) (query.ResultEstimate, error)
+}
+
+func (t *ResultTableFile) Stat(ctx context.Context) (os.FileInfo, error) {
+	return QueryFileInfo(t.name), nil
+}
+
+func (t *ResultTableFile) Estimate(ctx context.Context) (query.ResultEstimate, error) {
+	return t.estimate(ctx, t.table)
+}
+
+func (t *ResultTableFile) Open(ctx context.Context, flags int) (billy.File, error) {
+	result, err := t.result(ctx, t.table)
+	if err != nil {
+		return nil, err
+	}
+	return openResult(result)
+}
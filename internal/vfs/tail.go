@@ -0,0 +1,82 @@
+package vfs
+
+import (
+	"context"
+	"io"
+	"os"
+	"time"
+
+	"github.com/go-git/go-billy/v5"
+
+	"github.com/axiomhq/axiom-fs/internal/tail"
+)
+
+// tailReadTimeout bounds how long a read at the end of tail.ndjson waits
+// for new events, well inside NFS client RPC timeouts.
+const tailReadTimeout = 15 * time.Second
+
+// TailFile is /<dataset>/tail.ndjson, a live stream of newly ingested
+// events. Opening it starts the stream; stat reports its current size so
+// `tail -f` follows it.
+type TailFile struct {
+	root    *Root
+	dataset string
+}
+
+func (t *TailFile) Stat(ctx context.Context) (os.FileInfo, error) {
+	if s, ok := t.root.Tails().Get(t.dataset); ok {
+		return FileInfoAt("tail.ndjson", s.Size(), s.ModTime()), nil
+	}
+	return FileInfoAt("tail.ndjson", 0, time.Now()), nil
+}
+
+func (t *TailFile) Open(ctx context.Context, flags int) (billy.File, error) {
+	s, err := t.root.Tails().Stream(ctx, t.dataset, t.root.source(t.dataset))
+	if err != nil {
+		return nil, err
+	}
+	return &tailHandle{stream: s}, nil
+}
+
+// tailHandle reads a tail stream. Reads at the end of the stream block
+// until new events or a heartbeat arrive.
+type tailHandle struct {
+	stream *tail.Stream
+	offset int64
+}
+
+func (h *tailHandle) Name() string { return "tail.ndjson" }
+func (h *tailHandle) Size() int64  { return h.stream.Size() }
+
+func (h *tailHandle) Read(p []byte) (int, error) {
+	n, err := h.ReadAt(p, h.offset)
+	h.offset += int64(n)
+	if err == io.EOF && n > 0 {
+		err = nil
+	}
+	return n, err
+}
+
+func (h *tailHandle) ReadAt(p []byte, off int64) (int, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), tailReadTimeout)
+	defer cancel()
+	return h.stream.ReadAt(ctx, p, off)
+}
+
+func (h *tailHandle) Seek(offset int64, whence int) (int64, error) {
+	switch whence {
+	case io.SeekStart:
+		h.offset = offset
+	case io.SeekCurrent:
+		h.offset += offset
+	case io.SeekEnd:
+		h.offset = h.stream.Size() + offset
+	}
+	return h.offset, nil
+}
+
+func (h *tailHandle) Write(p []byte) (int, error) { return 0, os.ErrPermission }
+func (h *tailHandle) Close() error                { return nil }
+func (h *tailHandle) Lock() error                 { return nil }
+func (h *tailHandle) Unlock() error               { return nil }
+func (h *tailHandle) Truncate(size int64) error   { return os.ErrPermission }
@@ -0,0 +1,98 @@
+package vfs
+
+import (
+	"context"
+	"os"
+	"slices"
+	"strings"
+
+	"github.com/go-git/go-billy/v5"
+
+	"github.com/axiomhq/axiom-fs/internal/templates"
+)
+
+// TemplatesDir is /_templates, APL templates grouped by category.
+type TemplatesDir struct{}
+
+func (t *TemplatesDir) Stat(ctx context.Context) (os.FileInfo, error) {
+	return DirInfo("_templates"), nil
+}
+
+func (t *TemplatesDir) ReadDir(ctx context.Context) ([]os.FileInfo, error) {
+	categories := templates.Categories()
+	entries := make([]os.FileInfo, 0, len(categories))
+	for _, category := range categories {
+		entries = append(entries, DirInfo(category))
+	}
+	return entries, nil
+}
+
+func (t *TemplatesDir) Lookup(ctx context.Context, name string) (Node, error) {
+	if !slices.Contains(templates.Categories(), name) {
+		return nil, os.ErrNotExist
+	}
+	return &TemplateCategoryDir{category: name}, nil
+}
+
+// TemplateCategoryDir holds the <name>.apl templates of one category.
+type TemplateCategoryDir struct {
+	category string
+}
+
+func (t *TemplateCategoryDir) Stat(ctx context.Context) (os.FileInfo, error) {
+	return DirInfo(t.category), nil
+}
+
+func (t *TemplateCategoryDir) ReadDir(ctx context.Context) ([]os.FileInfo, error) {
+	var entries []os.FileInfo
+	for _, tmpl := range templates.All() {
+		if tmpl.Category == t.category {
+			entries = append(entries, FileInfo(tmpl.Name+".apl", int64(len(tmpl.File()))))
+		}
+	}
+	return entries, nil
+}
+
+func (t *TemplateCategoryDir) Lookup(ctx context.Context, name string) (Node, error) {
+	base, ok := strings.CutSuffix(name, ".apl")
+	if !ok {
+		return nil, os.ErrNotExist
+	}
+	tmpl, ok := templates.Lookup(t.category, base)
+	if !ok {
+		return nil, os.ErrNotExist
+	}
+	return &StaticFile{name: name, data: tmpl.File()}, nil
+}
+
+// VarsFile is _queries/<name>/vars, the NAME=value lines that fill in the
+// ${NAME} placeholders of the saved APL.
+type VarsFile struct {
+	root *Root
+	name string
+}
+
+func (v *VarsFile) Stat(ctx context.Context) (os.FileInfo, error) {
+	return WritableFileInfo("vars", int64(len(v.root.Vars().Get(v.name)))), nil
+}
+
+func (v *VarsFile) Open(ctx context.Context, flags int) (billy.File, error) {
+	return newBytesFile(v.root.Vars().Get(v.name)), nil
+}
+
+func (v *VarsFile) Create(ctx context.Context) (billy.File, error) {
+	return newAPLFile(v.root.Vars(), v.name), nil
+}
+
+// renderVars fills in src's placeholders from a vars file. APL without
+// placeholders is returned unchanged.
+func renderVars(src string, vars []byte) (string, error) {
+	if len(templates.Placeholders(src)) == 0 {
+		return src, nil
+	}
+	values, err := templates.ParseVars(vars)
+	if err != nil {
+		return "", err
+	}
+	return templates.Render(src, values)
+}
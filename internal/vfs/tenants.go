@@ -0,0 +1,94 @@
+package vfs
+
+import (
+	"context"
+	"os"
+	"path"
+	"sort"
+	"strings"
+)
+
+// Tenant is a restricted view of Axiom, with its own client, executor and
+// policy, served at Path.
+type Tenant struct {
+	// Path is where the view is mounted, a slash path relative to the
+	// mount root such as teams/payments.
+	Path string
+	Root *Root
+}
+
+// WithTenants serves only the tenants' views, each at its path, instead of
+// the mount's own tree.
+func WithTenants(tenants []Tenant) Option {
+	return func(fsys *FS) {
+		fsys.tenants = make([]Tenant, len(tenants))
+		for i, t := range tenants {
+			fsys.tenants[i] = Tenant{Path: strings.Trim(path.Clean("/"+t.Path), "/"), Root: t.Root}
+		}
+	}
+}
+
+// Tenants lists the views the mount serves instead of its own tree.
+func (r *Root) Tenants() []Tenant { return r.fsys.tenants }
+
+// Tenant returns the root serving name, a slash path relative to the mount
+// root, and name relative to that root: a tenant's view for paths inside
+// it, r itself otherwise.
+func (r *Root) Tenant(name string) (*Root, string) {
+	name = strings.Trim(path.Clean("/"+name), "/")
+	for _, t := range r.fsys.tenants {
+		if name == t.Path {
+			return t.Root, ""
+		}
+		if rest, ok := strings.CutPrefix(name, t.Path+"/"); ok {
+			return t.Root, rest
+		}
+	}
+	return r, name
+}
+
+// TenantDir is a directory on the way to tenant views, such as /teams.
+type TenantDir struct {
+	root   *Root
+	prefix string
+}
+
+func (d *TenantDir) Stat(ctx context.Context) (os.FileInfo, error) {
+	return DirInfo(path.Base("/" + d.prefix)), nil
+}
+
+func (d *TenantDir) ReadDir(ctx context.Context) ([]os.FileInfo, error) {
+	seen := map[string]bool{}
+	var entries []os.FileInfo
+	for _, t := range d.root.fsys.tenants {
+		rest := t.Path
+		if d.prefix != "" {
+			var ok bool
+			if rest, ok = strings.CutPrefix(t.Path, d.prefix+"/"); !ok {
+				continue
+			}
+		}
+		name, _, _ := strings.Cut(rest, "/")
+		if !seen[name] {
+			seen[name] = true
+			entries = append(entries, DirInfo(name))
+		}
+	}
+	sort.Slice(entries, func(i, j int) bool { return entries[i].Name() < entries[j].Name() })
+	return entries, nil
+}
+
+func (d *TenantDir) Lookup(ctx context.Context, name string) (Node, error) {
+	p := path.Join(d.prefix, name)
+	for _, t := range d.root.fsys.tenants {
+		if t.Path == p {
+			return t.Root, nil
+		}
+	}
+	for _, t := range d.root.fsys.tenants {
+		if strings.HasPrefix(t.Path, p+"/") {
+			return &TenantDir{root: d.root, prefix: p}, nil
+		}
+	}
+	return nil, os.ErrNotExist
+}
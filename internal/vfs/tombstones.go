@@ -0,0 +1,189 @@
+package vfs
+
+import (
+	"context"
+	"errors"
+	"log/slog"
+	"net/http"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/axiomhq/axiom-fs/internal/axiomclient"
+)
+
+// datasetCacheKeeper is implemented by executors that can keep or drop the
+// cached results of one dataset.
+type datasetCacheKeeper interface {
+	RetainDataset(dataset string, until time.Time) int
+	InvalidateDataset(dataset string) int
+}
+
+// tombstones are the datasets that disappeared from Axiom. For
+// DeletedDatasetGrace they stay listed, marked by a .deleted file, and
+// their q/ results are served from the cache only; after that their
+// caches are dropped.
+type tombstones struct {
+	mu      sync.Mutex
+	deleted map[string]tombstone
+}
+
+type tombstone struct {
+	dataset axiomclient.Dataset
+	at      time.Time
+}
+
+// deletedMarker is <dataset>/.deleted.
+type deletedMarker struct {
+	Dataset   string    `json:"dataset"`
+	DeletedAt time.Time `json:"deleted_at"`
+	ExpiresAt time.Time `json:"expires_at"`
+}
+
+// datasetsChanged buries the datasets missing from current and revives
+// those back in it.
+func (r *Root) datasetsChanged(old, current []axiomclient.Dataset) {
+	present := make(map[string]bool, len(current))
+	for _, d := range current {
+		present[d.Name] = true
+	}
+	t := &r.fsys.tombstones
+	t.mu.Lock()
+	for name := range t.deleted {
+		if present[name] {
+			delete(t.deleted, name)
+		}
+	}
+	t.mu.Unlock()
+	for _, d := range old {
+		if !present[d.Name] {
+			r.bury(d)
+		}
+	}
+	r.sweepTombstones()
+}
+
+// bury marks d deleted and keeps its cached results for the grace period.
+// A zero grace drops them at once.
+func (r *Root) bury(d axiomclient.Dataset) {
+	grace := r.fsys.Config.DeletedDatasetGrace
+	if grace <= 0 {
+		r.dropDataset(d.Name)
+		return
+	}
+	t := &r.fsys.tombstones
+	t.mu.Lock()
+	if _, ok := t.deleted[d.Name]; ok {
+		t.mu.Unlock()
+		return
+	}
+	if t.deleted == nil {
+		t.deleted = make(map[string]tombstone)
+	}
+	now := time.Now().UTC()
+	t.deleted[d.Name] = tombstone{dataset: d, at: now}
+	t.mu.Unlock()
+
+	kept := 0
+	if keeper, ok := r.fsys.Executor.(datasetCacheKeeper); ok {
+		kept = keeper.RetainDataset(d.Name, now.Add(grace))
+	}
+	slog.Info("dataset deleted, serving cached results", "dataset", d.Name, "results", kept, "grace", grace)
+}
+
+// buried reports whether dataset was deleted and is within its grace
+// period.
+func (r *Root) buried(dataset string) bool {
+	_, ok := r.tombstone(dataset)
+	return ok
+}
+
+func (r *Root) tombstone(dataset string) (tombstone, bool) {
+	t := &r.fsys.tombstones
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	stone, ok := t.deleted[dataset]
+	if !ok || time.Since(stone.at) >= r.fsys.Config.DeletedDatasetGrace {
+		return tombstone{}, false
+	}
+	return stone, true
+}
+
+// buriedDatasets lists the datasets within their grace period, by name.
+func (r *Root) buriedDatasets() []axiomclient.Dataset {
+	r.sweepTombstones()
+	t := &r.fsys.tombstones
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	datasets := make([]axiomclient.Dataset, 0, len(t.deleted))
+	for _, stone := range t.deleted {
+		datasets = append(datasets, stone.dataset)
+	}
+	sort.Slice(datasets, func(i, j int) bool { return datasets[i].Name < datasets[j].Name })
+	return datasets
+}
+
+// sweepTombstones drops the datasets whose grace period is over.
+func (r *Root) sweepTombstones() {
+	t := &r.fsys.tombstones
+	t.mu.Lock()
+	var expired []string
+	for name, stone := range t.deleted {
+		if time.Since(stone.at) >= r.fsys.Config.DeletedDatasetGrace {
+			expired = append(expired, name)
+			delete(t.deleted, name)
+		}
+	}
+	t.mu.Unlock()
+	for _, name := range expired {
+		r.dropDataset(name)
+	}
+}
+
+// dropDataset removes what is cached for a deleted dataset.
+func (r *Root) dropDataset(dataset string) {
+	r.fsys.fields.invalidate(dataset)
+	dropped := 0
+	if keeper, ok := r.fsys.Executor.(datasetCacheKeeper); ok {
+		dropped = keeper.InvalidateDataset(dataset)
+	}
+	slog.Info("dropped deleted dataset caches", "dataset", dataset, "results", dropped)
+}
+
+// datasetGone buries dataset when err is Axiom reporting a dataset this
+// mount knows as not found, and reports whether it did.
+func (r *Root) datasetGone(ctx context.Context, dataset string, err error) bool {
+	var apiErr *axiomclient.APIError
+	if !errors.As(err, &apiErr) || apiErr.StatusCode != http.StatusNotFound {
+		return false
+	}
+	if r.buried(dataset) {
+		return true
+	}
+	datasets, listErr := r.fsys.datasets.List(ctx, r.fsys.Client)
+	if listErr != nil {
+		return false
+	}
+	for _, d := range datasets {
+		if d.Name == dataset {
+			r.bury(d)
+			return r.buried(dataset)
+		}
+	}
+	return false
+}
+
+// deletedFile is <dataset>/.deleted, present while the dataset is buried.
+func (r *Root) deletedFile(dataset string) (Node, bool) {
+	stone, ok := r.tombstone(dataset)
+	if !ok {
+		return nil, false
+	}
+	return &StatusFile{name: ".deleted", build: func(ctx context.Context) (any, error) {
+		return deletedMarker{
+			Dataset:   dataset,
+			DeletedAt: stone.at,
+			ExpiresAt: stone.at.Add(r.fsys.Config.DeletedDatasetGrace),
+		}, nil
+	}}, true
+}
@@ -0,0 +1,181 @@
+package vfs
+
+import (
+	"context"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/axiomhq/axiom-fs/internal/compiler"
+	"github.com/axiomhq/axiom-fs/internal/policy"
+)
+
+// treeManifest is /_manifest.json: the grammar of the tree, for agents and
+// tools that build paths instead of reading README.txt.
+type treeManifest struct {
+	Version    int            `json:"version"`
+	MountPoint string         `json:"mount_point,omitempty"`
+	Paths      []manifestPath `json:"paths"`
+	Query      queryGrammar   `json:"query"`
+	Limits     manifestLimits `json:"limits"`
+	// Writable lists the subtrees, relative to the mount root, whose
+	// contents accept writes.
+	Writable []string `json:"writable"`
+}
+
+// manifestPath is one file or directory, by a path pattern relative to the
+// mount root such as <dataset>/sample.ndjson.
+type manifestPath struct {
+	Path        string `json:"path"`
+	Kind        string `json:"kind"`
+	Description string `json:"description,omitempty"`
+}
+
+type queryGrammar struct {
+	// Path is the shape of a query path; Segments may repeat and combine
+	// in any order, force/ first.
+	Path          string                 `json:"path"`
+	Segments      []compiler.SegmentRule `json:"segments"`
+	Placeholders  map[string]string      `json:"placeholders"`
+	Files         []manifestPath         `json:"files"`
+	Formats       []string               `json:"formats"`
+	FormatAliases map[string]string      `json:"format_aliases"`
+	DefaultFormat string                 `json:"default_format"`
+}
+
+type manifestLimits struct {
+	DefaultRange  string `json:"default_range"`
+	DefaultLimit  int    `json:"default_limit"`
+	MaxRange      string `json:"max_range,omitempty"`
+	MaxLimit      int    `json:"max_limit,omitempty"`
+	AllowForce    bool   `json:"allow_force"`
+	ForceMaxRange string `json:"force_max_range,omitempty"`
+	ForceMaxLimit int    `json:"force_max_limit,omitempty"`
+	SampleLimit   int    `json:"sample_limit"`
+}
+
+// rootDescriptions describe the mount root's own entries.
+var rootDescriptions = map[string]string{
+	"datasets":       "every visible dataset, also listed at the root",
+	"README.txt":     "short guide",
+	"examples":       "example query paths",
+	"_presets":       "preset queries available under <dataset>/presets",
+	"_queries":       "saved raw APL queries: write <name>/apl, read <name>/result.<format>",
+	"_status":        "mount health, cache, quota and cost reports as JSON",
+	"_events":        "stream.ndjson of query, cache and dataset changes",
+	"_search":        "fields/<substr>/results.csv finds fields across datasets",
+	"_snippets":      "APL snippets for #include in saved queries",
+	"_batch":         "query packs: write <name>/queries.json, read <name>/results/ and summary.csv",
+	"_templates":     "APL templates by category, with vars to fill in",
+	"_org":           "the organization, user, limits and tokens",
+	"_meta":          "APL functions and per-dataset field metadata",
+	"_policy":        "the mount policy in effect",
+	"_dashboards":    "Axiom dashboards, one directory per chart",
+	"_aliases.json":  "virtual datasets and the datasets they union",
+	"_cache":         "disk cache usage",
+	AdminDir:         "writable files that drop caches and change settings",
+	".stale":         "present while the dataset list is served stale",
+	"_manifest.json": "this file",
+}
+
+// datasetPaths are the entries of every dataset directory.
+var datasetPaths = []manifestPath{
+	{Path: "<dataset>", Kind: "dir", Description: "one dataset or alias"},
+	{Path: "<dataset>/README.md", Kind: "file", Description: "field count, event volume, presets and example q/ paths"},
+	{Path: "<dataset>/schema.json", Kind: "file", Description: "fields and types"},
+	{Path: "<dataset>/schema.csv", Kind: "file", Description: "fields and types"},
+	{Path: "<dataset>/schema.jsonschema", Kind: "file", Description: "JSON Schema of an event"},
+	{Path: "<dataset>/schema.diff.json", Kind: "file", Description: "fields added, removed or retyped since first seen"},
+	{Path: "<dataset>/sample.ndjson", Kind: "file", Description: "a few recent events"},
+	{Path: "<dataset>/duckdb.sql", Kind: "file", Description: "DuckDB views over the dataset's result and preset CSVs"},
+	{Path: "<dataset>/fields/<field>", Kind: "dir", Description: "top values and histogram of one field"},
+	{Path: "<dataset>/presets/<preset>.csv", Kind: "file", Description: "preset query results"},
+	{Path: "<dataset>/tail.ndjson", Kind: "file", Description: "live stream of newly ingested events"},
+	{Path: "<dataset>/tail.status.json", Kind: "file", Description: "state of the tail.ndjson stream"},
+	{Path: "<dataset>/q", Kind: "dir", Description: "query paths; see query"},
+}
+
+// queryFiles are the files at the end of a query path.
+var queryFiles = []manifestPath{
+	{Path: "result.<format>", Kind: "file", Description: "runs the query and reads its result"},
+	{Path: "result", Kind: "file", Description: "the result in the format/ segment's format or the default format"},
+	{Path: "result.error", Kind: "file", Description: "why the query or path failed, as JSON"},
+	{Path: "result.count", Kind: "file", Description: "row count of the result"},
+	{Path: "result.stats.csv", Kind: "file", Description: "per-column count, nulls, distinct, min, max and avg"},
+	{Path: "result.sha256", Kind: "file", Description: "sha256sum line of the result"},
+	{Path: "manifest.json", Kind: "file", Description: "APL, execution time, rows, bytes and checksum of the result"},
+	{Path: "stats.json", Kind: "file", Description: "APL, format and range used, and truncation"},
+	{Path: "apl.txt", Kind: "file", Description: "the compiled APL, without running it"},
+	{Path: "plan.txt", Kind: "file", Description: "the APL stage by stage, without running it"},
+	{Path: "plan.json", Kind: "file", Description: "plan.txt as JSON"},
+	{Path: "open.url", Kind: "file", Description: "the query in the Axiom web UI"},
+	{Path: "tables/<name>.csv", Kind: "file", Description: "one table of a result with several"},
+}
+
+// manifest describes r's tree as it is mounted.
+func (r *Root) manifest(ctx context.Context) (any, error) {
+	cfg := r.fsys.Config
+	entries, err := r.ReadDir(ctx)
+	if err != nil {
+		return nil, err
+	}
+	var paths []manifestPath
+	for _, entry := range entries {
+		if !isReservedRoot(entry.Name()) {
+			continue
+		}
+		paths = append(paths, manifestPath{Path: entry.Name(), Kind: manifestKind(entry), Description: rootDescriptions[entry.Name()]})
+	}
+	for _, p := range datasetPaths {
+		if strings.HasPrefix(p.Path, "<dataset>/tail.") && r.Tails() == nil {
+			continue
+		}
+		paths = append(paths, p)
+	}
+
+	pol := r.Policy()
+	if pol == nil {
+		pol = policy.Default()
+	}
+	writable := append([]string{}, pol.WritablePaths...)
+	return treeManifest{
+		Version:    1,
+		MountPoint: cfg.MountPoint,
+		Paths:      paths,
+		Query: queryGrammar{
+			Path:          "<dataset>/q/<segment>/.../<file>",
+			Segments:      compiler.Segments(),
+			Placeholders:  compiler.Placeholders(),
+			Files:         queryFiles,
+			Formats:       compiler.Formats(),
+			FormatAliases: compiler.FormatAliases(),
+			DefaultFormat: cfg.DefaultFormat,
+		},
+		Limits: manifestLimits{
+			DefaultRange:  cfg.DefaultRange,
+			DefaultLimit:  cfg.DefaultLimit,
+			MaxRange:      manifestDuration(cfg.MaxRange),
+			MaxLimit:      cfg.MaxLimit,
+			AllowForce:    cfg.AllowForce,
+			ForceMaxRange: manifestDuration(cfg.ForceMaxRange),
+			ForceMaxLimit: cfg.ForceMaxLimit,
+			SampleLimit:   cfg.SampleLimit,
+		},
+		Writable: writable,
+	}, nil
+}
+
+// manifestDuration renders a limit, empty when it is off.
+func manifestDuration(d time.Duration) string {
+	if d <= 0 {
+		return ""
+	}
+	return d.String()
+}
+
+func manifestKind(info os.FileInfo) string {
+	if info.IsDir() {
+		return "dir"
+	}
+	return "file"
+}
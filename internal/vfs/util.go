@@ -2,25 +2,63 @@ package vfs
 
 import (
 	"encoding/csv"
+	"encoding/json"
+	"net/http"
 	"os"
+	"path"
 	"strings"
+	"unicode"
 
 	"github.com/axiomhq/axiom-fs/internal/axiomclient"
 	"github.com/axiomhq/axiom-fs/internal/compiler"
 	"github.com/axiomhq/axiom-fs/internal/config"
 	"github.com/axiomhq/axiom-fs/internal/presets"
+	"github.com/axiomhq/axiom-fs/internal/query"
 )
 
-func compilePath(dataset string, segments []string, cfg config.Config) (compiler.Query, error) {
-	if len(segments) > 0 && segments[len(segments)-1] == "result.error" {
+// queryLabel labels the queries run for a file with the file's path in the
+// mount, so Axiom's query logs show what was read. Control characters,
+// which headers can't carry, become '?'.
+func queryLabel(elem ...string) http.Header {
+	label := strings.Map(func(r rune) rune {
+		if unicode.IsControl(r) {
+			return '?'
+		}
+		return r
+	}, "/"+path.Join(elem...))
+	return http.Header{query.LabelHeader: {label}}
+}
+
+func queryPathLabel(dataset string, segments []string) http.Header {
+	return queryLabel(append([]string{dataset, "q"}, segments...)...)
+}
+
+func savedQueryLabel(name string) http.Header {
+	return queryLabel("_queries", name)
+}
+
+// compilePath compiles a q/ path. fields, when non-nil, are the dataset's
+// known fields to check distinct/ and select/ against.
+func compilePath(dataset string, segments []string, cfg config.Config, fields []string) (compiler.Query, error) {
+	if len(segments) > 0 && (segments[len(segments)-1] == "result.error" || segments[len(segments)-1] == "stats.json") {
 		segments = append([]string{}, segments[:len(segments)-1]...)
-		segments = append(segments, "result.ndjson")
+		segments = append(segments, "result")
 	}
 	opts := compiler.Options{
-		DefaultRange: cfg.DefaultRange,
-		DefaultLimit: cfg.DefaultLimit,
-		MaxRange:     cfg.MaxRange,
-		MaxLimit:     cfg.MaxLimit,
+		DefaultRange:  cfg.DefaultRange,
+		DefaultLimit:  cfg.DefaultLimit,
+		DefaultFormat: cfg.DefaultFormat,
+		MaxRange:      cfg.MaxRange,
+		MaxLimit:      cfg.MaxLimit,
+		AllowForce:    cfg.AllowForce,
+		ForceMaxRange: cfg.ForceMaxRange,
+		ForceMaxLimit: cfg.ForceMaxLimit,
+		MountPoint:    cfg.MountPoint,
+		Aliases:       cfg.Aliases,
+		Fields:        fields,
+		Location:      cfg.Location,
+		// Snapshot mounts count days as of -snapshot-to.
+		Now: cfg.SnapshotTo,
 	}
 	return compiler.CompileSegments(dataset, segments, opts)
 }
@@ -35,6 +73,12 @@ Advanced:
 
 Raw APL:
   /_queries/<name>/apl
+
+New to APL? Copy a template and fill in its vars:
+  /_templates/<category>/<name>.apl
+
+Building paths from a program:
+  /_manifest.json
 `)
 
 var exampleText = []byte(`Example query:
@@ -91,6 +135,9 @@ func stringify(v any) string {
 	switch x := v.(type) {
 	case string:
 		return x
+	case json.Number:
+		// Results decoded with UseNumber keep numeric args as written.
+		return x.String()
 	case float64:
 		if x == float64(int64(x)) {
 			return itoa(int(x))
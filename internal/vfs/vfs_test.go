@@ -1,21 +1,44 @@
 package vfs
 
 import (
+	"bytes"
 	"context"
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"fmt"
 	"io"
+	"net/http"
+	"net/url"
 	"os"
+	"path/filepath"
+	"reflect"
+	"slices"
 	"sort"
 	"strings"
+	"sync"
+	"sync/atomic"
+	"syscall"
 	"testing"
+	"time"
 
+	"github.com/axiomhq/axiom-fs/internal/apl"
 	"github.com/axiomhq/axiom-fs/internal/axiomclient"
+	"github.com/axiomhq/axiom-fs/internal/cache"
 	"github.com/axiomhq/axiom-fs/internal/config"
+	"github.com/axiomhq/axiom-fs/internal/events"
+	"github.com/axiomhq/axiom-fs/internal/export"
+	"github.com/axiomhq/axiom-fs/internal/latency"
+	"github.com/axiomhq/axiom-fs/internal/policy"
 	"github.com/axiomhq/axiom-fs/internal/query"
+	"github.com/axiomhq/axiom-fs/internal/quota"
+	"github.com/axiomhq/axiom-fs/internal/redact"
 )
 
 type mockClient struct {
 	datasets []axiomclient.Dataset
 	fields   map[string][]axiomclient.Field
+	stats    []axiomclient.DatasetStats
 	queryFn  func(apl string) (*axiomclient.QueryResult, error)
 }
 
@@ -37,6 +60,10 @@ func (m *mockClient) ListFields(ctx context.Context, datasetID string) ([]axiomc
 	}, nil
 }
 
+func (m *mockClient) DatasetStats(ctx context.Context) ([]axiomclient.DatasetStats, error) {
+	return m.stats, nil
+}
+
 func (m *mockClient) QueryAPL(ctx context.Context, apl string) (*axiomclient.QueryResult, error) {
 	if m.queryFn != nil {
 		return m.queryFn(apl)
@@ -44,12 +71,103 @@ func (m *mockClient) QueryAPL(ctx context.Context, apl string) (*axiomclient.Que
 	return &axiomclient.QueryResult{}, nil
 }
 
+// pollingClient is a mockClient answering tail polls with the messages
+// sent on events.
+func pollingClient(datasets []axiomclient.Dataset, events chan string) *mockClient {
+	return &mockClient{datasets: datasets, queryFn: func(apl string) (*axiomclient.QueryResult, error) {
+		select {
+		case msg := <-events:
+			source, _, _ := strings.Cut(apl, " | ")
+			return &axiomclient.QueryResult{Tables: []axiomclient.QueryTable{{
+				Fields:  []axiomclient.QueryField{{Name: "_sysTime"}, {Name: "source"}, {Name: "msg"}},
+				Columns: [][]any{{time.Now().UTC().Format(time.RFC3339Nano)}, {source}, {msg}},
+			}}}, nil
+		default:
+			return &axiomclient.QueryResult{}, nil
+		}
+	}}
+}
+
+// readTail opens tail.ndjson of dataset with ctx, sends msg and returns
+// the first line read.
+func readTail(t *testing.T, ctx context.Context, root *Root, dataset string, events chan string, msg string) string {
+	t.Helper()
+	dir, err := root.Lookup(ctx, dataset)
+	if err != nil {
+		t.Fatal(err)
+	}
+	node, err := dir.(Dir).Lookup(ctx, "tail.ndjson")
+	if err != nil {
+		t.Fatal(err)
+	}
+	f, err := node.(File).Open(ctx, os.O_RDONLY)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	events <- msg
+	buf := make([]byte, 1024)
+	n, _ := f.Read(buf)
+	return string(buf[:n])
+}
+
+// dashboardClient is a mockClient that serves dashboards.
+type dashboardClient struct {
+	mockClient
+	dashboards []axiomclient.Dashboard
+	gets       int
+}
+
+func (d *dashboardClient) ListDashboards(ctx context.Context) ([]axiomclient.Dashboard, error) {
+	list := make([]axiomclient.Dashboard, len(d.dashboards))
+	for i, dash := range d.dashboards {
+		list[i] = axiomclient.Dashboard{ID: dash.ID, Name: dash.Name}
+	}
+	return list, nil
+}
+
+func (d *dashboardClient) GetDashboard(ctx context.Context, id string) (*axiomclient.Dashboard, error) {
+	d.gets++
+	for i := range d.dashboards {
+		if d.dashboards[i].ID == id {
+			return &d.dashboards[i], nil
+		}
+	}
+	return nil, &axiomclient.APIError{StatusCode: 404, Message: "not found"}
+}
+
+// orgClient is a mockClient that describes its org.
+type orgClient struct {
+	mockClient
+}
+
+func (o *orgClient) Org(ctx context.Context) (*axiomclient.Org, error) {
+	return &axiomclient.Org{ID: "org-1", Name: "Acme"}, nil
+}
+
+func (o *orgClient) Limits(ctx context.Context) (*axiomclient.Limits, error) {
+	return &axiomclient.Limits{Query: &axiomclient.RateLimit{Limit: 10, Remaining: 9}}, nil
+}
+
+func (o *orgClient) ListTokens(ctx context.Context) ([]axiomclient.Token, error) {
+	return nil, &axiomclient.APIError{StatusCode: 403, Message: "forbidden"}
+}
+
+func (o *orgClient) TokenInfo() axiomclient.TokenInfo {
+	return axiomclient.TokenInfo{Kind: "api", Redacted: "xaat-…abcd"}
+}
+
 type mockExecutor struct {
 	aplLog    []string
 	formatLog []string
 	data      []byte
-	result    *axiomclient.QueryResult
-	err       error
+	optsLog   []query.ExecOptions
+	// estimateLog records APL sized by EstimateResult.
+	estimateLog []string
+	result      *axiomclient.QueryResult
+	err         error
+	// onExecute, when set, runs inside ExecuteAPLResult.
+	onExecute func()
 }
 
 func (m *mockExecutor) ExecuteAPL(ctx context.Context, apl, format string, opts query.ExecOptions) ([]byte, error) {
@@ -61,6 +179,10 @@ func (m *mockExecutor) ExecuteAPL(ctx context.Context, apl, format string, opts
 func (m *mockExecutor) ExecuteAPLResult(ctx context.Context, apl, format string, opts query.ExecOptions) (query.ResultData, error) {
 	m.aplLog = append(m.aplLog, apl)
 	m.formatLog = append(m.formatLog, format)
+	m.optsLog = append(m.optsLog, opts)
+	if m.onExecute != nil {
+		m.onExecute()
+	}
 	return query.ResultData{Bytes: m.data, Size: int64(len(m.data))}, m.err
 }
 
@@ -72,6 +194,38 @@ func (m *mockExecutor) QueryAPL(ctx context.Context, apl string, opts query.Exec
 	return &axiomclient.QueryResult{}, m.err
 }
 
+func (m *mockExecutor) ResultMeta(ctx context.Context, apl, format string, opts query.ExecOptions) (query.ResultMeta, error) {
+	m.aplLog = append(m.aplLog, apl)
+	m.formatLog = append(m.formatLog, format)
+	meta := query.ResultMeta{APL: apl, Format: format, Rows: 2, Bytes: int64(len(m.data)), SHA256: "abc123"}
+	if m.result != nil {
+		meta.Tables = query.TableNames(m.result)
+		for _, table := range m.result.Tables {
+			for _, field := range table.Fields {
+				meta.Columns = append(meta.Columns, query.ColumnStats{Name: field.Name, Type: field.Type})
+			}
+		}
+	}
+	return meta, m.err
+}
+
+func (m *mockExecutor) ResultCount(ctx context.Context, apl string, opts query.ExecOptions) (int64, error) {
+	m.aplLog = append(m.aplLog, apl)
+	m.optsLog = append(m.optsLog, opts)
+	return 42, m.err
+}
+
+func (m *mockExecutor) ResultStats(ctx context.Context, apl, format string, opts query.ExecOptions) ([]byte, error) {
+	m.aplLog = append(m.aplLog, apl)
+	m.formatLog = append(m.formatLog, format)
+	return []byte("column,type,count,nulls,distinct,min,max,avg\n"), m.err
+}
+
+func (m *mockExecutor) EstimateResult(ctx context.Context, apl, format string, opts query.ExecOptions) (query.ResultEstimate, error) {
+	m.estimateLog = append(m.estimateLog, apl)
+	return query.ResultEstimate{Size: 1234}, m.err
+}
+
 func (m *mockExecutor) lastAPL() string {
 	if len(m.aplLog) == 0 {
 		return ""
@@ -139,7 +293,7 @@ func TestRootStructure(t *testing.T) {
 
 	t.Run("ReadDir", func(t *testing.T) {
 		names := dirNames(t, root)
-		want := []string{"README.txt", "_presets", "_queries", "datasets", "examples", "logs", "metrics"}
+		want := []string{"README.txt", "_aliases.json", "_batch", "_events", "_manifest.json", "_meta", "_org", "_policy", "_presets", "_queries", "_search", "_snippets", "_status", "_templates", "datasets", "examples", "logs", "metrics"}
 		if len(names) != len(want) {
 			t.Fatalf("got %v, want %v", names, want)
 		}
@@ -160,6 +314,12 @@ func TestRootStructure(t *testing.T) {
 			{"datasets", true},
 			{"_presets", true},
 			{"_queries", true},
+			{"_status", true},
+			{"_search", true},
+			{"_snippets", true},
+			{"_batch", true},
+			{"_aliases.json", false},
+			{"_manifest.json", false},
 			{"logs", true},
 			{"metrics", true},
 		}
@@ -208,462 +368,3012 @@ func TestRootStructure(t *testing.T) {
 	})
 }
 
-func TestDatasetDir(t *testing.T) {
-	root, exec := newTestRoot(t, []axiomclient.Dataset{{Name: "logs"}}, []byte(`{"test":true}`))
+func TestTreeManifest(t *testing.T) {
+	root, _ := newTestRoot(t, []axiomclient.Dataset{{Name: "logs"}}, []byte("{}\n"))
+	root.fsys.Config.AllowForce = true
 	ctx := context.Background()
 
-	dataset, _ := root.Lookup(ctx, "logs")
-	dir := dataset.(Dir)
-
-	t.Run("ReadDir", func(t *testing.T) {
-		names := dirNames(t, dir)
-		want := []string{"fields", "presets", "q", "sample.ndjson", "schema.csv", "schema.json"}
-		if len(names) != len(want) {
-			t.Fatalf("got %v, want %v", names, want)
-		}
-	})
+	node, err := root.Lookup(ctx, "_manifest.json")
+	if err != nil {
+		t.Fatal(err)
+	}
+	var manifest treeManifest
+	if err := json.Unmarshal(readFile(t, node.(File)), &manifest); err != nil {
+		t.Fatal(err)
+	}
+	if manifest.Limits.DefaultLimit != root.Config().DefaultLimit || !slices.Contains(manifest.Writable, "_queries") {
+		t.Errorf("limits = %+v, writable = %v", manifest.Limits, manifest.Writable)
+	}
+	if !slices.ContainsFunc(manifest.Paths, func(p manifestPath) bool { return p.Path == "_queries" && p.Kind == "dir" }) {
+		t.Errorf("paths = %+v", manifest.Paths)
+	}
+	if manifest.Query.FormatAliases["jsonl"] != "ndjson" || !slices.Contains(manifest.Query.Formats, "csv") {
+		t.Errorf("formats = %v, aliases = %v", manifest.Query.Formats, manifest.Query.FormatAliases)
+	}
 
-	t.Run("schema.json uses fields API", func(t *testing.T) {
-		node, _ := dir.Lookup(ctx, "schema.json")
-		data := readFile(t, node.(File))
-		// schema.json now uses /fields API, not getschema query
-		if !strings.Contains(string(data), "_time") {
-			t.Errorf("schema should contain _time field: %s", data)
+	// Every segment's example is a path the tree serves.
+	for _, rule := range manifest.Query.Segments {
+		var node Node = root
+		for _, name := range append([]string{"logs", "q"}, strings.Split(rule.Example, "/")...) {
+			dir, ok := node.(Dir)
+			if !ok {
+				t.Fatalf("%s: %s is not a directory", rule.Example, name)
+			}
+			if node, err = dir.Lookup(ctx, name); err != nil {
+				t.Fatalf("%s: Lookup(%s): %v", rule.Example, name, err)
+			}
 		}
-	})
-
-	t.Run("sample.ndjson applies limit", func(t *testing.T) {
-		node, _ := dir.Lookup(ctx, "sample.ndjson")
-		_ = readFile(t, node.(File))
-		if !strings.Contains(exec.lastAPL(), "take") {
-			t.Errorf("APL should contain take: %s", exec.lastAPL())
+		if _, err := node.(File).Stat(ctx); err != nil {
+			t.Errorf("%s: Stat: %v", rule.Example, err)
 		}
-	})
+	}
 }
 
-func TestQueryPath(t *testing.T) {
-	root, exec := newTestRoot(t, []axiomclient.Dataset{{Name: "logs"}}, []byte("row1\nrow2"))
+func TestDatasetDirActivity(t *testing.T) {
 	ctx := context.Background()
-
-	dataset, _ := root.Lookup(ctx, "logs")
-	qDir, _ := dataset.(Dir).Lookup(ctx, "q")
-
-	cases := []struct {
-		segments []string
-		wantAPL  []string
-		format   string
-	}{
-		{
-			segments: []string{"range", "ago", "1h", "result.csv"},
-			wantAPL:  []string{"ago(1h)"},
-			format:   "csv",
-		},
-		{
-			segments: []string{"where", "status>=500", "result.ndjson"},
-			wantAPL:  []string{"where status>=500"},
-			format:   "ndjson",
-		},
-		{
-			segments: []string{"summarize", "count()", "by", "service", "result.json"},
-			wantAPL:  []string{"summarize count() by service"},
-			format:   "json",
-		},
-		{
-			segments: []string{"limit", "100", "result.csv"},
-			wantAPL:  []string{"take 100"},
-			format:   "csv",
-		},
+	latest := time.Date(2024, 3, 1, 12, 0, 0, 0, time.UTC)
+	cfg := config.Default()
+	cfg.CacheDir = t.TempDir()
+	client := &mockClient{
+		datasets: []axiomclient.Dataset{{Name: "logs"}, {Name: "quiet"}},
+		stats:    []axiomclient.DatasetStats{{Name: "logs", InputBytes: 4096, MaxTime: latest}},
 	}
+	root := NewRoot(cfg, client, &mockExecutor{})
 
-	for _, tc := range cases {
-		t.Run(strings.Join(tc.segments, "/"), func(t *testing.T) {
-			var node Node = qDir
-			for _, seg := range tc.segments {
-				next, err := node.(Dir).Lookup(ctx, seg)
-				if err != nil {
-					t.Fatalf("Lookup(%q): %v", seg, err)
-				}
-				node = next
+	entries, err := root.ReadDir(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, e := range entries {
+		switch e.Name() {
+		case "logs":
+			if !e.ModTime().Equal(latest) {
+				t.Errorf("logs mtime = %v, want %v", e.ModTime(), latest)
 			}
-
-			_ = readFile(t, node.(File))
-
-			for _, want := range tc.wantAPL {
-				if !strings.Contains(exec.lastAPL(), want) {
-					t.Errorf("APL missing %q: %s", want, exec.lastAPL())
-				}
+			if e.Size() != 4096 {
+				t.Errorf("logs size = %d, want 4096", e.Size())
 			}
-			if exec.lastFormat() != tc.format {
-				t.Errorf("format = %q, want %q", exec.lastFormat(), tc.format)
+		case "quiet":
+			if !e.ModTime().Equal(stableModTime) {
+				t.Errorf("quiet mtime = %v, want stable mtime", e.ModTime())
 			}
-		})
+		}
+	}
+
+	node, _ := root.Lookup(ctx, "logs")
+	info, _ := node.Stat(ctx)
+	if !info.ModTime().Equal(latest) {
+		t.Errorf("Stat mtime = %v, want %v", info.ModTime(), latest)
 	}
 }
 
-func TestRawQueries(t *testing.T) {
-	root, exec := newTestRoot(t, nil, []byte("results"))
+func TestDatasetAliases(t *testing.T) {
 	ctx := context.Background()
+	cfg := config.Default()
+	cfg.CacheDir = t.TempDir()
+	cfg.Aliases = map[string][]string{"prod": {"logs-eu", "logs-us"}}
+	client := &mockClient{
+		datasets: []axiomclient.Dataset{{Name: "logs-eu"}, {Name: "logs-us"}},
+		fields: map[string][]axiomclient.Field{
+			"logs-eu": {{Name: "_time", Type: "datetime"}, {Name: "region", Type: "string"}},
+			"logs-us": {{Name: "_time", Type: "datetime"}, {Name: "zone", Type: "string"}},
+		},
+	}
+	exec := &mockExecutor{data: []byte("ok")}
+	root := NewRoot(cfg, client, exec)
 
-	queries, _ := root.Lookup(ctx, "_queries")
-	qDir := queries.(Dir)
+	names := dirNames(t, root)
+	if !containsString(names, "prod") {
+		t.Fatalf("alias missing from root: %v", names)
+	}
 
-	t.Run("create and execute query", func(t *testing.T) {
-		entry, _ := qDir.Lookup(ctx, "myquery")
-		aplNode, _ := entry.(Dir).Lookup(ctx, "apl")
+	node, err := root.Lookup(ctx, "prod")
+	if err != nil {
+		t.Fatal(err)
+	}
+	fields, _ := node.(Dir).Lookup(ctx, "fields")
+	if got, want := dirNames(t, fields.(Dir)), []string{"_time", "region", "zone"}; strings.Join(got, ",") != strings.Join(want, ",") {
+		t.Errorf("alias fields = %v, want %v", got, want)
+	}
 
-		// Write APL
-		wf, err := aplNode.(Writable).Create(ctx)
-		if err != nil {
-			t.Fatal(err)
-		}
-		wf.Write([]byte("['logs'] | where error == true | take 50"))
-		wf.Close()
+	sample, _ := node.(Dir).Lookup(ctx, "sample.ndjson")
+	_ = readFile(t, sample.(File))
+	if !strings.HasPrefix(exec.lastAPL(), "union ['logs-eu'], ['logs-us']") {
+		t.Errorf("sample APL = %q, want union source", exec.lastAPL())
+	}
 
-		// Read result
-		resultNode, _ := entry.(Dir).Lookup(ctx, "result.csv")
-		data := readFile(t, resultNode.(File))
-		if string(data) != "results" {
-			t.Errorf("got %q, want results", data)
+	aliases, _ := root.Lookup(ctx, "_aliases.json")
+	if data := string(readFile(t, aliases.(File))); !strings.Contains(data, `"logs-us"`) {
+		t.Errorf("_aliases.json = %s", data)
+	}
+}
+
+func TestSelfReferencingAlias(t *testing.T) {
+	file := filepath.Join(t.TempDir(), "aliases.json")
+	for _, aliases := range []string{
+		`{"logs": ["logs", "logs-archive"]}`,
+		`{"a": ["b"], "b": ["a"]}`,
+	} {
+		if err := os.WriteFile(file, []byte(aliases), 0o644); err != nil {
+			t.Fatal(err)
 		}
-		if !strings.Contains(exec.lastAPL(), "where error == true") {
-			t.Errorf("APL missing filter: %s", exec.lastAPL())
+		if _, err := config.LoadAliases(file); err == nil {
+			t.Errorf("LoadAliases accepted %s", aliases)
 		}
-	})
+	}
+	if err := os.WriteFile(file, []byte(`{"logs-*": ["logs-*"]}`), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := config.LoadAliases(file); err != nil {
+		t.Errorf("LoadAliases rejected a glob alias: %v", err)
+	}
 
-	t.Run("invalid query name rejected", func(t *testing.T) {
-		_, err := qDir.Lookup(ctx, "../escape")
-		if !os.IsNotExist(err) {
-			t.Errorf("expected ErrNotExist for path traversal, got %v", err)
-		}
-	})
+	// An alias named like a dataset is not expanded anywhere once the
+	// dataset is listed: queries and fields are the dataset's own.
+	ctx := context.Background()
+	cfg := config.Default()
+	cfg.CacheDir = t.TempDir()
+	cfg.Aliases = map[string][]string{"logs": {"logs-eu", "logs-archive"}}
+	client := &mockClient{
+		datasets: []axiomclient.Dataset{{Name: "logs"}, {Name: "logs-eu"}, {Name: "logs-archive"}},
+		fields: map[string][]axiomclient.Field{
+			"logs":         {{Name: "_time", Type: "datetime"}, {Name: "status", Type: "integer"}},
+			"logs-eu":      {{Name: "_time", Type: "datetime"}, {Name: "region", Type: "string"}},
+			"logs-archive": {{Name: "_time", Type: "datetime"}, {Name: "archived", Type: "boolean"}},
+		},
+	}
+	root := NewRoot(cfg, client, &mockExecutor{})
+	if _, err := root.ReadDir(ctx); err != nil {
+		t.Fatal(err)
+	}
+	if src := root.source("logs"); src != "['logs']" {
+		t.Errorf("source of a shadowing alias = %s", src)
+	}
+	fields, err := root.fsys.fields.List(ctx, client, "logs")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(fields) != 2 {
+		t.Errorf("fields of a shadowing alias = %v", fields)
+	}
 }
 
-func TestFieldsDir(t *testing.T) {
+func TestDatasetGlobAliases(t *testing.T) {
+	ctx := context.Background()
 	cfg := config.Default()
 	cfg.CacheDir = t.TempDir()
+	cfg.Aliases = map[string][]string{"logs-*": {"logs-*"}, "traces-*": {"traces-*"}}
 	client := &mockClient{
-		datasets: []axiomclient.Dataset{{Name: "logs"}},
+		datasets: []axiomclient.Dataset{{Name: "logs-us"}, {Name: "metrics"}, {Name: "logs-eu"}},
 		fields: map[string][]axiomclient.Field{
-			"logs": {
-				{Name: "duration", Type: "integer"},
-				{Name: "service", Type: "string"},
-				{Name: "status", Type: "integer"},
-			},
+			"logs-eu": {{Name: "_time", Type: "datetime"}, {Name: "region", Type: "string"}},
+			"logs-us": {{Name: "_time", Type: "datetime"}, {Name: "zone", Type: "string"}},
 		},
 	}
-	exec := &mockExecutor{
-		data: []byte("field_data"),
-	}
+	exec := &mockExecutor{data: []byte("ok")}
 	root := NewRoot(cfg, client, exec)
-	ctx := context.Background()
 
-	dataset, _ := root.Lookup(ctx, "logs")
-	fields, _ := dataset.(Dir).Lookup(ctx, "fields")
+	names := dirNames(t, root)
+	if !containsString(names, "logs-*") || containsString(names, "traces-*") {
+		t.Fatalf("root = %v, want logs-* and no unmatched traces-*", names)
+	}
 
-	t.Run("lists fields from API", func(t *testing.T) {
-		names := dirNames(t, fields.(Dir))
-		want := []string{"duration", "service", "status"}
-		if len(names) != len(want) {
-			t.Fatalf("got %v, want %v", names, want)
+	node, err := root.Lookup(ctx, "logs-*")
+	if err != nil {
+		t.Fatal(err)
+	}
+	fields, _ := node.(Dir).Lookup(ctx, "fields")
+	if got, want := dirNames(t, fields.(Dir)), []string{"_time", "region", "zone"}; strings.Join(got, ",") != strings.Join(want, ",") {
+		t.Errorf("glob fields = %v, want %v", got, want)
+	}
+
+	sample, _ := node.(Dir).Lookup(ctx, "sample.ndjson")
+	_ = readFile(t, sample.(File))
+	if !strings.HasPrefix(exec.lastAPL(), "union ['logs-eu'], ['logs-us']") {
+		t.Errorf("sample APL = %q, want union of matching datasets", exec.lastAPL())
+	}
+	if _, err := root.Lookup(ctx, "traces-*"); !errors.Is(err, os.ErrNotExist) {
+		t.Errorf("Lookup(traces-*) error = %v, want not exist", err)
+	}
+}
+
+func containsString(list []string, s string) bool {
+	for _, v := range list {
+		if v == s {
+			return true
 		}
-	})
+	}
+	return false
+}
 
-	t.Run("field/top.csv", func(t *testing.T) {
-		fieldDir, err := fields.(Dir).Lookup(ctx, "status")
+func TestFieldSearch(t *testing.T) {
+	cfg := config.Default()
+	cfg.CacheDir = t.TempDir()
+	client := &mockClient{
+		datasets: []axiomclient.Dataset{{Name: "billing"}, {Name: "logs"}, {Name: "metrics"}},
+		fields: map[string][]axiomclient.Field{
+			"billing": {{Name: "customer_id", Type: "string"}, {Name: "amount", Type: "float"}},
+			"logs":    {{Name: "attributes.Customer_ID", Type: "string"}, {Name: "customer_secret", Type: "string", Hidden: true}},
+			"metrics": {{Name: "value", Type: "float"}},
+		},
+	}
+	root := NewRoot(cfg, client, &mockExecutor{})
+	ctx := context.Background()
+
+	var node Node = root
+	for _, seg := range []string{"_search", "fields", "customer_id", "results.csv"} {
+		next, err := node.(Dir).Lookup(ctx, seg)
 		if err != nil {
-			t.Fatalf("Lookup status: %v", err)
+			t.Fatalf("Lookup(%q): %v", seg, err)
 		}
-		topFile, _ := fieldDir.(Dir).Lookup(ctx, "top.csv")
+		node = next
+	}
+	got := string(readFile(t, node.(File)))
+	want := "dataset,field,type\nbilling,customer_id,string\nlogs,attributes.Customer_ID,string\n"
+	if got != want {
+		t.Errorf("results.csv =\n%s\nwant\n%s", got, want)
+	}
+}
+
+func TestStatusQuota(t *testing.T) {
+	ctx := context.Background()
+	cfg := config.Default()
+	cfg.CacheDir = t.TempDir()
+	tracker := quota.New(quota.Limits{RowsPerHour: 100})
+	tracker.Record("", 42, 1024)
+	root := NewRoot(cfg, &mockClient{}, &mockExecutor{}, WithQuota(tracker))
+
+	status, err := root.Lookup(ctx, "_status")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if names := dirNames(t, status.(Dir)); !slices.Contains(names, "quota.json") || !slices.Contains(names, "metadata.json") {
+		t.Fatalf("unexpected status entries: %v", names)
+	}
+	node, err := status.(Dir).Lookup(ctx, "quota.json")
+	if err != nil {
+		t.Fatal(err)
+	}
+	data := string(readFile(t, node.(File)))
+	for _, want := range []string{`"rows_per_hour": 100`, `"principal": "global"`, `"rows": 42`} {
+		if !strings.Contains(data, want) {
+			t.Errorf("quota.json missing %s: %s", want, data)
+		}
+	}
+}
+
+func TestStatusTransfer(t *testing.T) {
+	ctx := context.Background()
+	cfg := config.Default()
+	cfg.CacheDir = t.TempDir()
+	root := NewRoot(cfg, &mockClient{}, &mockExecutor{}, WithTransferStats(func() axiomclient.TransferStats {
+		return axiomclient.TransferStats{Compression: true, Responses: 2, WireBytes: 100, DecodedBytes: 900}
+	}))
+
+	status, _ := root.Lookup(ctx, "_status")
+	node, err := status.(Dir).Lookup(ctx, "transfer.json")
+	if err != nil {
+		t.Fatal(err)
+	}
+	data := string(readFile(t, node.(File)))
+	for _, want := range []string{`"compression": true`, `"wire_bytes": 100`, `"decoded_bytes": 900`} {
+		if !strings.Contains(data, want) {
+			t.Errorf("transfer.json missing %s: %s", want, data)
+		}
+	}
+}
+
+func TestStatusLatency(t *testing.T) {
+	ctx := context.Background()
+	cfg := config.Default()
+	cfg.CacheDir = t.TempDir()
+	timings := latency.New(time.Second)
+	timings.Observe(latency.Entry{Op: latency.OpQuery, Path: "/logs/q/result.csv", AxiomSeconds: 2.5}, 3*time.Second)
+	timings.Observe(latency.Entry{Op: latency.OpStat, Path: "/logs"}, time.Millisecond)
+	root := NewRoot(cfg, &mockClient{}, &mockExecutor{}, WithLatency(timings))
+
+	status, _ := root.Lookup(ctx, "_status")
+	read := func(name string) string {
+		t.Helper()
+		node, err := status.(Dir).Lookup(ctx, name)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return string(readFile(t, node.(File)))
+	}
+	stats := read("latency.json")
+	for _, want := range []string{`"slow_threshold_seconds": 1`, `"op": "stat"`, `"op": "query"`} {
+		if !strings.Contains(stats, want) {
+			t.Errorf("latency.json missing %s: %s", want, stats)
+		}
+	}
+	slow := strings.Split(strings.TrimSpace(read("slow.ndjson")), "\n")
+	if len(slow) != 1 || !strings.Contains(slow[0], `"path":"/logs/q/result.csv"`) || !strings.Contains(slow[0], `"axiom_seconds":2.5`) {
+		t.Errorf("slow.ndjson = %q", slow)
+	}
+}
+
+// diskCacheExecutor reports a disk cache, which serves /_cache.
+type diskCacheExecutor struct {
+	mockExecutor
+	cache *cache.Cache
+}
+
+func (e *diskCacheExecutor) DiskUsage() cache.DiskUsage { return e.cache.DiskUsage() }
+
+func TestCacheUsage(t *testing.T) {
+	ctx := context.Background()
+	cfg := config.Default()
+	cfg.CacheDir = t.TempDir()
+	if names := dirNames(t, NewRoot(cfg, &mockClient{}, &mockExecutor{})); slices.Contains(names, "_cache") {
+		t.Errorf("_cache listed without a disk cache: %v", names)
+	}
+
+	c := cache.New(time.Hour, 0, 0, t.TempDir())
+	c.Set("result", []byte("abc"))
+	root := NewRoot(cfg, &mockClient{}, &diskCacheExecutor{cache: c})
+	if names := dirNames(t, root); !slices.Contains(names, "_cache") {
+		t.Fatalf("_cache not listed: %v", names)
+	}
+	dir, err := root.Lookup(ctx, "_cache")
+	if err != nil {
+		t.Fatal(err)
+	}
+	node, err := dir.(Dir).Lookup(ctx, "usage.json")
+	if err != nil {
+		t.Fatal(err)
+	}
+	data := string(readFile(t, node.(File)))
+	for _, want := range []string{`"bytes": 3`, `"segment": "small"`, `"entries": 1`} {
+		if !strings.Contains(data, want) {
+			t.Errorf("usage.json missing %s: %s", want, data)
+		}
+	}
+}
+
+func TestRefreshMetadata(t *testing.T) {
+	ctx := context.Background()
+	cfg := config.Default()
+	cfg.CacheDir = t.TempDir()
+	client := &mockClient{
+		datasets: []axiomclient.Dataset{{Name: "logs"}},
+		fields:   map[string][]axiomclient.Field{"web": {{Name: "old", Type: "string"}}},
+	}
+	root := NewRoot(cfg, client, &mockExecutor{})
+
+	if names := dirNames(t, root); slices.Contains(names, "web") {
+		t.Fatalf("unexpected web dataset: %v", names)
+	}
+	if _, err := root.fields().List(ctx, client, "web"); err != nil {
+		t.Fatal(err)
+	}
+
+	client.datasets = []axiomclient.Dataset{{Name: "logs"}, {Name: "web"}}
+	client.fields = map[string][]axiomclient.Field{"web": {{Name: "new", Type: "string"}}}
+	changed, err := root.RefreshMetadata(ctx)
+	if err != nil || !changed {
+		t.Fatalf("RefreshMetadata = %v, %v", changed, err)
+	}
+	if names := dirNames(t, root); !slices.Contains(names, "web") {
+		t.Errorf("created dataset not listed: %v", names)
+	}
+	fields, _ := root.fields().List(ctx, client, "web")
+	if len(fields) != 1 || fields[0].Name != "new" {
+		t.Errorf("fields of created dataset were not refetched: %v", fields)
+	}
+	if changed, _ := root.RefreshMetadata(ctx); changed {
+		t.Error("unchanged dataset list reported as changed")
+	}
+
+	status, _ := root.Lookup(ctx, "_status")
+	node, err := status.(Dir).Lookup(ctx, "metadata.json")
+	if err != nil {
+		t.Fatal(err)
+	}
+	data := string(readFile(t, node.(File)))
+	for _, want := range []string{`"datasets": 2`, `"changes": 1`, `"last_refresh"`, `"last_change"`, `"ttl": "10m0s"`} {
+		if !strings.Contains(data, want) {
+			t.Errorf("metadata.json missing %s: %s", want, data)
+		}
+	}
+}
+
+func TestEventStream(t *testing.T) {
+	ctx := context.Background()
+	cfg := config.Default()
+	cfg.CacheDir = t.TempDir()
+	client := &mockClient{datasets: []axiomclient.Dataset{{Name: "logs"}}}
+	root := NewRoot(cfg, client, &mockExecutor{})
+	dirNames(t, root)
+
+	client.datasets = []axiomclient.Dataset{{Name: "web"}}
+	if _, err := root.RefreshMetadata(ctx); err != nil {
+		t.Fatal(err)
+	}
+	root.Store().Set("errors", []byte("['web']\n"))
+
+	dir, err := root.Lookup(ctx, "_events")
+	if err != nil {
+		t.Fatal(err)
+	}
+	node, err := dir.(Dir).Lookup(ctx, "stream.ndjson")
+	if err != nil {
+		t.Fatal(err)
+	}
+	info, _ := node.(File).Stat(ctx)
+	f, err := node.(File).Open(ctx, os.O_RDONLY)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	buf := make([]byte, info.Size())
+	n, _ := f.ReadAt(buf, 0)
+
+	var got []string
+	for _, line := range strings.Split(strings.TrimSpace(string(buf[:n])), "\n") {
+		var e events.Event
+		if err := json.Unmarshal([]byte(line), &e); err != nil {
+			t.Fatalf("line %q: %v", line, err)
+		}
+		got = append(got, e.Type+":"+e.Dataset+e.Query)
+	}
+	want := []string{"dataset.deleted:logs", "dataset.created:web", "query.changed:errors"}
+	if !slices.Equal(got, want) {
+		t.Errorf("events = %v, want %v", got, want)
+	}
+}
+
+func TestDeletedDataset(t *testing.T) {
+	ctx := context.Background()
+	cfg := config.Default()
+	cfg.CacheDir = t.TempDir()
+	client := &mockClient{datasets: []axiomclient.Dataset{{Name: "logs"}, {Name: "web"}}}
+	exec := &mockExecutor{err: &axiomclient.APIError{StatusCode: http.StatusNotFound}}
+	root := NewRoot(cfg, client, exec)
+
+	open := func(path ...string) (Node, error) {
+		var node Node = root
+		for _, seg := range path {
+			next, err := node.(Dir).Lookup(ctx, seg)
+			if err != nil {
+				return nil, err
+			}
+			node = next
+		}
+		return node, nil
+	}
+
+	// A 404 for a known dataset marks it deleted and retries from the cache.
+	node, err := open("web", "q", "result.ndjson")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := node.(File).Open(ctx, os.O_RDONLY); err == nil {
+		t.Fatal("Open of an uncached result succeeded")
+	}
+	if opts := exec.optsLog[len(exec.optsLog)-1]; !opts.CacheOnly || len(exec.optsLog) != 2 {
+		t.Errorf("retry opts = %+v after %d runs", opts, len(exec.optsLog))
+	}
+	if _, err := open("web", ".deleted"); err != nil {
+		t.Fatalf(".deleted after 404: %v", err)
+	}
+
+	// Once the poller finds it gone, it stays listed and served from the cache.
+	exec.err = nil
+	client.datasets = []axiomclient.Dataset{{Name: "logs"}}
+	if _, err := root.RefreshMetadata(ctx); err != nil {
+		t.Fatal(err)
+	}
+	datasets, _ := open("datasets")
+	if names := dirNames(t, datasets.(Dir)); !slices.Contains(names, "web") {
+		t.Errorf("deleted dataset not listed in its grace period: %v", names)
+	}
+	web, _ := open("web")
+	if names := dirNames(t, web.(Dir)); !slices.Contains(names, ".deleted") {
+		t.Errorf("deleted dataset has no marker: %v", names)
+	}
+	marker, _ := open("web", ".deleted")
+	if data := string(readFile(t, marker.(File))); !strings.Contains(data, `"dataset": "web"`) || !strings.Contains(data, `"expires_at"`) {
+		t.Errorf(".deleted = %s", data)
+	}
+	node, _ = open("web", "q", "result.ndjson")
+	readFile(t, node.(File))
+	if opts := exec.optsLog[len(exec.optsLog)-1]; !opts.CacheOnly {
+		t.Error("deleted dataset read without CacheOnly")
+	}
+	if _, err := open("logs", ".deleted"); err == nil {
+		t.Error("live dataset has a .deleted marker")
+	}
+
+	// After the grace period it is gone.
+	root.fsys.tombstones.mu.Lock()
+	stone := root.fsys.tombstones.deleted["web"]
+	stone.at = stone.at.Add(-cfg.DeletedDatasetGrace)
+	root.fsys.tombstones.deleted["web"] = stone
+	root.fsys.tombstones.mu.Unlock()
+	if names := dirNames(t, datasets.(Dir)); slices.Contains(names, "web") {
+		t.Errorf("deleted dataset listed after its grace period: %v", names)
+	}
+
+	// A dataset that comes back is live again.
+	client.datasets = []axiomclient.Dataset{{Name: "logs"}, {Name: "web"}}
+	if _, err := root.RefreshMetadata(ctx); err != nil {
+		t.Fatal(err)
+	}
+	client.datasets = []axiomclient.Dataset{{Name: "web"}}
+	if _, err := root.RefreshMetadata(ctx); err != nil {
+		t.Fatal(err)
+	}
+	client.datasets = []axiomclient.Dataset{{Name: "logs"}, {Name: "web"}}
+	if _, err := root.RefreshMetadata(ctx); err != nil {
+		t.Fatal(err)
+	}
+	if root.buried("logs") {
+		t.Error("recreated dataset still marked deleted")
+	}
+}
+
+func TestDatasetDefaults(t *testing.T) {
+	ctx := context.Background()
+	cfg := config.Default()
+	cfg.CacheDir = t.TempDir()
+	cfg.DatasetDefaults = map[string]config.DatasetDefaults{
+		"busy": {DefaultRange: "5m", DefaultLimit: 50, SampleLimit: 3},
+	}
+	exec := &mockExecutor{}
+	root := NewRoot(cfg, &mockClient{datasets: []axiomclient.Dataset{{Name: "busy"}, {Name: "quiet"}}}, exec)
+
+	read := func(path ...string) {
+		t.Helper()
+		var node Node = root
+		for _, seg := range path {
+			next, err := node.(Dir).Lookup(ctx, seg)
+			if err != nil {
+				t.Fatalf("Lookup(%q): %v", seg, err)
+			}
+			node = next
+		}
+		readFile(t, node.(File))
+	}
+
+	read("busy", "q", "result.ndjson")
+	if apl := exec.lastAPL(); !strings.Contains(apl, "ago(5m)") || !strings.Contains(apl, "take 50") {
+		t.Errorf("busy q/ APL = %s", apl)
+	}
+	if opts := exec.optsLog[len(exec.optsLog)-1]; opts.DefaultRange != "5m" {
+		t.Errorf("busy q/ DefaultRange = %q", opts.DefaultRange)
+	}
+	read("busy", "sample.ndjson")
+	if apl := exec.lastAPL(); !strings.Contains(apl, "take 3") {
+		t.Errorf("busy sample APL = %s", apl)
+	}
+	read("quiet", "q", "result.ndjson")
+	if apl := exec.lastAPL(); !strings.Contains(apl, "ago(1h)") || !strings.Contains(apl, "take 10000") {
+		t.Errorf("quiet q/ APL = %s", apl)
+	}
+}
+
+func TestQueryLabels(t *testing.T) {
+	ctx := context.Background()
+	cfg := config.Default()
+	cfg.CacheDir = t.TempDir()
+	exec := &mockExecutor{}
+	root := NewRoot(cfg, &mockClient{datasets: []axiomclient.Dataset{{Name: "logs"}}}, exec)
+
+	var node Node = root
+	for _, seg := range []string{"logs", "q", "range", "ago", "5m", "result.csv"} {
+		next, err := node.(Dir).Lookup(ctx, seg)
+		if err != nil {
+			t.Fatalf("Lookup(%q): %v", seg, err)
+		}
+		node = next
+	}
+	readFile(t, node.(File))
+	opts := exec.optsLog[len(exec.optsLog)-1]
+	if got := opts.Headers.Get(query.LabelHeader); got != "/logs/q/range/ago/5m/result.csv" {
+		t.Errorf("label = %q", got)
+	}
+
+	if got := queryLabel("logs", "fields", "a\nb", "top.csv").Get(query.LabelHeader); got != "/logs/fields/a?b/top.csv" {
+		t.Errorf("label with control character = %q", got)
+	}
+}
+
+func TestTailFile(t *testing.T) {
+	ctx := context.Background()
+	cfg := config.Default()
+	cfg.CacheDir = t.TempDir()
+	cfg.TailInterval = 5 * time.Millisecond
+	events := make(chan string, 1)
+	client := pollingClient([]axiomclient.Dataset{{Name: "logs"}}, events)
+	root := NewRoot(cfg, client, query.NewExecutor(client, nil, "1h", 100, 0, 0, ""))
+	defer root.Tails().Close()
+
+	dataset, _ := root.Lookup(ctx, "logs")
+	if names := dirNames(t, dataset.(Dir)); !slices.Contains(names, "tail.ndjson") {
+		t.Fatalf("dataset listing = %v", names)
+	}
+	node, err := dataset.(Dir).Lookup(ctx, "tail.ndjson")
+	if err != nil {
+		t.Fatal(err)
+	}
+	f, err := node.(File).Open(ctx, os.O_RDONLY)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+
+	events <- "hello"
+	buf := make([]byte, 1024)
+	n, _ := f.Read(buf)
+	line := string(buf[:n])
+	if !strings.Contains(line, `"msg":"hello"`) || !strings.Contains(line, `"source":"['logs']"`) {
+		t.Fatalf("tail line = %q", line)
+	}
+	info, err := node.(File).Stat(ctx)
+	if err != nil || info.Size() != int64(n) {
+		t.Errorf("tail.ndjson size = %d, want %d (%v)", info.Size(), n, err)
+	}
+
+	// Without an executor that polls there is no tail.ndjson.
+	plain, _ := newTestRoot(t, []axiomclient.Dataset{{Name: "logs"}}, nil)
+	dataset, _ = plain.Lookup(ctx, "logs")
+	if _, err := dataset.(Dir).Lookup(ctx, "tail.ndjson"); !os.IsNotExist(err) {
+		t.Errorf("tail.ndjson without polling: err = %v", err)
+	}
+}
+
+func TestTailRedaction(t *testing.T) {
+	cfg := config.Default()
+	cfg.CacheDir = t.TempDir()
+	cfg.TailInterval = 5 * time.Millisecond
+	events := make(chan string, 1)
+	client := pollingClient([]axiomclient.Dataset{{Name: "logs"}}, events)
+	redactor, err := redact.New([]redact.Rule{{Field: "msg", Action: redact.Mask}}, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	exec := query.NewExecutor(client, nil, "1h", 100, 0, 0, "", query.WithRedaction(redactor))
+	root := NewRoot(cfg, client, exec, WithRedaction(redactor))
+	defer root.Tails().Close()
+
+	line := readTail(t, context.Background(), root, "logs", events, "secret")
+	if strings.Contains(line, "secret") || !strings.Contains(line, `"msg":"`+redact.Masked+`"`) {
+		t.Errorf("tail line = %q", line)
+	}
+}
+
+func TestTailQuota(t *testing.T) {
+	cfg := config.Default()
+	cfg.CacheDir = t.TempDir()
+	cfg.TailInterval = 5 * time.Millisecond
+	events := make(chan string, 1)
+	client := pollingClient([]axiomclient.Dataset{{Name: "logs"}}, events)
+	tracker := quota.New(quota.Limits{})
+	root := NewRoot(cfg, client, query.NewExecutor(client, nil, "1h", 100, 0, 0, "", query.WithQuota(tracker)))
+	defer root.Tails().Close()
+
+	readTail(t, query.WithClient(context.Background(), "10.0.0.7"), root, "logs", events, "hello")
+	snap := tracker.Snapshot()
+	if len(snap.Principals) != 1 || snap.Principals[0].Principal != "10.0.0.7" || snap.Principals[0].Rows == 0 {
+		t.Errorf("tail polls charged to %+v, want the opening client", snap.Principals)
+	}
+}
+
+func TestSchemaDiff(t *testing.T) {
+	cfg := config.Default()
+	cfg.CacheDir = t.TempDir()
+	cfg.MetadataTTL = 0
+	client := &mockClient{
+		datasets: []axiomclient.Dataset{{Name: "logs"}},
+		fields: map[string][]axiomclient.Field{"logs": {
+			{Name: "_time", Type: "datetime"},
+			{Name: "status", Type: "integer"},
+			{Name: "path", Type: "string"},
+		}},
+	}
+	ctx := context.Background()
+	read := func(root *Root) schemaDiff {
+		t.Helper()
+		dataset, err := root.Lookup(ctx, "logs")
+		if err != nil {
+			t.Fatal(err)
+		}
+		node, err := dataset.(Dir).Lookup(ctx, "schema.diff.json")
+		if err != nil {
+			t.Fatal(err)
+		}
+		var diff schemaDiff
+		if err := json.Unmarshal(readFile(t, node.(File)), &diff); err != nil {
+			t.Fatal(err)
+		}
+		return diff
+	}
+
+	root := NewRoot(cfg, client, &mockExecutor{})
+	if diff := read(root); len(diff.Changes) != 0 || diff.CheckedAt == nil {
+		t.Fatalf("first fetch = %+v, want no changes", diff)
+	}
+
+	client.fields["logs"] = []axiomclient.Field{
+		{Name: "_time", Type: "datetime"},
+		{Name: "status", Type: "string"},
+		{Name: "user", Type: "string"},
+	}
+	diff := read(root)
+	got := make([]string, len(diff.Changes))
+	for i, c := range diff.Changes {
+		got[i] = c.Change + ":" + c.Field + ":" + c.OldType + ">" + c.Type
+		if c.At.IsZero() {
+			t.Errorf("change %+v has no timestamp", c)
+		}
+	}
+	want := []string{"retyped:status:integer>string", "added:user:>string", "removed:path:string>"}
+	if !slices.Equal(got, want) {
+		t.Fatalf("changes = %v, want %v", got, want)
+	}
+
+	// A remount compares against the snapshot in the cache dir and keeps
+	// earlier changes.
+	client.fields["logs"] = client.fields["logs"][:2]
+	diff = read(NewRoot(cfg, client, &mockExecutor{}))
+	if len(diff.Changes) != 4 || diff.Changes[3].Change != schemaRemoved || diff.Changes[3].Field != "user" {
+		t.Fatalf("after remount = %+v", diff.Changes)
+	}
+}
+
+func TestDatasetDir(t *testing.T) {
+	root, exec := newTestRoot(t, []axiomclient.Dataset{{Name: "logs"}}, []byte(`{"test":true}`))
+	ctx := context.Background()
+
+	dataset, _ := root.Lookup(ctx, "logs")
+	dir := dataset.(Dir)
+
+	t.Run("ReadDir", func(t *testing.T) {
+		names := dirNames(t, dir)
+		want := []string{"README.md", "duckdb.sql", "fields", "presets", "q", "sample.ndjson", "schema.csv", "schema.diff.json", "schema.json", "schema.jsonschema"}
+		if len(names) != len(want) {
+			t.Fatalf("got %v, want %v", names, want)
+		}
+	})
+
+	t.Run("schema.json uses fields API", func(t *testing.T) {
+		node, _ := dir.Lookup(ctx, "schema.json")
+		data := readFile(t, node.(File))
+		// schema.json now uses /fields API, not getschema query
+		if !strings.Contains(string(data), "_time") {
+			t.Errorf("schema should contain _time field: %s", data)
+		}
+	})
+
+	t.Run("sample.ndjson applies limit", func(t *testing.T) {
+		node, _ := dir.Lookup(ctx, "sample.ndjson")
+		_ = readFile(t, node.(File))
+		if !strings.Contains(exec.lastAPL(), "take") {
+			t.Errorf("APL should contain take: %s", exec.lastAPL())
+		}
+	})
+
+	t.Run("sample.ndjson with -explore-sample", func(t *testing.T) {
+		root.fsys.Config.ExploreSample = 0.5
+		defer func() { root.fsys.Config.ExploreSample = 0 }()
+		node, _ := dir.Lookup(ctx, "sample.ndjson")
+		_ = readFile(t, node.(File))
+		if !strings.Contains(exec.lastAPL(), "| where rand() < 0.005\n| take ") {
+			t.Errorf("APL should sample before take: %s", exec.lastAPL())
+		}
+	})
+}
+
+func TestDuckDBFile(t *testing.T) {
+	ctx := context.Background()
+	cfg := config.Default()
+	cfg.CacheDir = t.TempDir()
+	client := &mockClient{
+		datasets: []axiomclient.Dataset{{Name: "o'logs"}},
+		fields: map[string][]axiomclient.Field{"o'logs": {
+			{Name: "_time", Type: "datetime"},
+			{Name: "status", Type: "integer"},
+			{Name: "duration", Type: "integer|float"},
+			{Name: "tags", Type: "array"},
+			{Name: "sparse", Type: "boolean"},
+		}},
+	}
+	exec := &mockExecutor{result: &axiomclient.QueryResult{Tables: []axiomclient.QueryTable{{
+		Fields: []axiomclient.QueryField{{Name: "_time"}, {Name: "status"}, {Name: "duration"}, {Name: "tags"}, {Name: "_sysTime"}},
+	}}}}
+	root := NewRoot(cfg, client, exec)
+
+	dataset, err := root.Lookup(ctx, "o'logs")
+	if err != nil {
+		t.Fatal(err)
+	}
+	node, err := dataset.(Dir).Lookup(ctx, "duckdb.sql")
+	if err != nil {
+		t.Fatal(err)
+	}
+	sql := string(readFile(t, node.(File)))
+	for _, want := range []string{
+		"duckdb -init /mnt/axiom/o'logs/duckdb.sql",
+		`CREATE OR REPLACE VIEW "o'logs" AS`,
+		`read_csv('/mnt/axiom/o''logs/q/result.csv', header = true, types = {'_time': 'TIMESTAMPTZ', 'duration': 'DOUBLE', 'status': 'BIGINT', 'tags': 'VARCHAR'});`,
+		`CREATE OR REPLACE VIEW "o'logs_errors" AS`,
+		`read_csv('/mnt/axiom/o''logs/presets/errors.csv', header = true);`,
+	} {
+		if !strings.Contains(sql, want) {
+			t.Errorf("duckdb.sql missing %s:\n%s", want, sql)
+		}
+	}
+	// Only returned columns are typed: DuckDB rejects types for the rest.
+	if strings.Contains(sql, "sparse") {
+		t.Errorf("duckdb.sql types a column the result lacks:\n%s", sql)
+	}
+	if exec.lastFormat() != "csv" {
+		t.Errorf("duckdb.sql typed the %s result, want q/result.csv", exec.lastFormat())
+	}
+}
+
+func TestDatasetReadme(t *testing.T) {
+	ctx := context.Background()
+	cfg := config.Default()
+	cfg.CacheDir = t.TempDir()
+	client := &mockClient{
+		datasets: []axiomclient.Dataset{{Name: "logs", Description: "edge proxy logs"}},
+		fields: map[string][]axiomclient.Field{"logs": {
+			{Name: "_time", Type: "datetime"},
+			{Name: "my field", Type: "string"},
+			{Name: "service", Type: "string"},
+			{Name: "duration", Type: "integer|float"},
+			{Name: "secret", Type: "string", Hidden: true},
+		}},
+	}
+	exec := &mockExecutor{}
+	root := NewRoot(cfg, client, exec)
+
+	dataset, err := root.Lookup(ctx, "logs")
+	if err != nil {
+		t.Fatal(err)
+	}
+	node, err := dataset.(Dir).Lookup(ctx, "README.md")
+	if err != nil {
+		t.Fatal(err)
+	}
+	readme := string(readFile(t, node.(File)))
+	for _, want := range []string{
+		"# logs\n\nedge proxy logs\n",
+		"- fields: 4 (1 hidden, under fields/.hidden/)",
+		"- events in the last 1h: 42",
+		"| [my field](<fields/my field/top.csv>) | string |",
+		"- [errors](presets/errors.csv): HTTP 500+ counts by service",
+		"(q/summarize/count()/by/service/order/count_:desc/limit/10/result.csv)",
+		"(q/summarize/percentile(duration,95)/by/service/result.csv)",
+	} {
+		if !strings.Contains(readme, want) {
+			t.Errorf("README.md missing %q:\n%s", want, readme)
+		}
+	}
+	if strings.Contains(readme, "secret") {
+		t.Errorf("README.md lists a hidden field:\n%s", readme)
+	}
+	if exec.lastAPL() != "['logs']" {
+		t.Errorf("README.md counted %q, want the dataset", exec.lastAPL())
+	}
+
+	// Every example must be a path q/ accepts.
+	for _, example := range readmeExamples(client.fields["logs"], cfg.DefaultRange) {
+		segments := strings.Split(example.Path, "/")
+		if _, err := compilePath("logs", segments, cfg, nil); err != nil {
+			t.Errorf("example %s: %v", example.Path, err)
+		}
+	}
+}
+func TestQueryPath(t *testing.T) {
+	root, exec := newTestRoot(t, []axiomclient.Dataset{{Name: "logs"}}, []byte("row1\nrow2"))
+	ctx := context.Background()
+
+	dataset, _ := root.Lookup(ctx, "logs")
+	qDir, _ := dataset.(Dir).Lookup(ctx, "q")
+
+	cases := []struct {
+		segments []string
+		wantAPL  []string
+		format   string
+	}{
+		{
+			segments: []string{"range", "ago", "1h", "result.csv"},
+			wantAPL:  []string{"ago(1h)"},
+			format:   "csv",
+		},
+		{
+			segments: []string{"where", "status>=500", "result.ndjson"},
+			wantAPL:  []string{"where status>=500"},
+			format:   "ndjson",
+		},
+		{
+			segments: []string{"summarize", "count()", "by", "service", "result.json"},
+			wantAPL:  []string{"summarize count() by service"},
+			format:   "json",
+		},
+		{
+			segments: []string{"limit", "100", "result.csv"},
+			wantAPL:  []string{"take 100"},
+			format:   "csv",
+		},
+		{
+			segments: []string{"distinct", "message", "result.csv"},
+			wantAPL:  []string{"| distinct message"},
+			format:   "csv",
+		},
+		{
+			segments: []string{"where", "status>=500", "result.jsonl"},
+			wantAPL:  []string{"where status>=500"},
+			format:   "ndjson",
+		},
+	}
+
+	for _, tc := range cases {
+		t.Run(strings.Join(tc.segments, "/"), func(t *testing.T) {
+			var node Node = qDir
+			for _, seg := range tc.segments {
+				next, err := node.(Dir).Lookup(ctx, seg)
+				if err != nil {
+					t.Fatalf("Lookup(%q): %v", seg, err)
+				}
+				node = next
+			}
+
+			_ = readFile(t, node.(File))
+
+			for _, want := range tc.wantAPL {
+				if !strings.Contains(exec.lastAPL(), want) {
+					t.Errorf("APL missing %q: %s", want, exec.lastAPL())
+				}
+			}
+			if exec.lastFormat() != tc.format {
+				t.Errorf("format = %q, want %q", exec.lastFormat(), tc.format)
+			}
+			if info, err := node.(File).Stat(ctx); err != nil || info.Name() != tc.segments[len(tc.segments)-1] {
+				t.Errorf("Stat = %v, %v", info, err)
+			}
+		})
+	}
+
+	t.Run("auto-range/stats.json", func(t *testing.T) {
+		autoDir, _ := qDir.(Dir).Lookup(ctx, "auto-range")
+		node, err := autoDir.(Dir).Lookup(ctx, "stats.json")
+		if err != nil {
+			t.Fatal(err)
+		}
+		data := string(readFile(t, node.(File)))
+		if !strings.Contains(data, `"auto_range": true`) || !strings.Contains(data, "ago(1h)") {
+			t.Errorf("unexpected stats.json: %s", data)
+		}
+		var stats struct{ APL, Canonical string }
+		if err := json.Unmarshal([]byte(data), &stats); err != nil || stats.Canonical != apl.Canonical(stats.APL) {
+			t.Errorf("stats.json canonical = %q, want the canonical form of %q", stats.Canonical, stats.APL)
+		}
+	})
+
+	t.Run("distinct unknown field", func(t *testing.T) {
+		var node Node = qDir
+		for _, seg := range []string{"distinct", "region", "result.csv"} {
+			node, _ = node.(Dir).Lookup(ctx, seg)
+		}
+		if _, err := node.(File).Open(ctx, os.O_RDONLY); Errno(err) != syscall.EINVAL {
+			t.Errorf("err = %v, want EINVAL", err)
+		}
+	})
+}
+
+func TestResultManifest(t *testing.T) {
+	root, _ := newTestRoot(t, []axiomclient.Dataset{{Name: "logs"}}, []byte("row1\nrow2\n"))
+	ctx := context.Background()
+
+	var node Node = root
+	for _, seg := range []string{"logs", "q", "format", "csv"} {
+		next, err := node.(Dir).Lookup(ctx, seg)
+		if err != nil {
+			t.Fatalf("Lookup(%q): %v", seg, err)
+		}
+		node = next
+	}
+	dir := node.(Dir)
+
+	sum, _ := dir.Lookup(ctx, "result.sha256")
+	if got := string(readFile(t, sum.(File))); got != "abc123  result.csv\n" {
+		t.Errorf("result.sha256 = %q", got)
+	}
+
+	manifest, _ := dir.Lookup(ctx, "manifest.json")
+	data := string(readFile(t, manifest.(File)))
+	for _, want := range []string{`"file": "result.csv"`, `"format": "csv"`, `"rows": 2`, `"sha256": "abc123"`} {
+		if !strings.Contains(data, want) {
+			t.Errorf("manifest.json missing %s: %s", want, data)
+		}
+	}
+
+	// Saved queries describe each result file next to it.
+	root.Store().Set("errors", []byte("['logs'] | where status >= 500"))
+	queries, _ := root.Lookup(ctx, "_queries")
+	entry, _ := queries.(Dir).Lookup(ctx, "errors")
+	sum, err := entry.(Dir).Lookup(ctx, "result.csv.sha256")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := string(readFile(t, sum.(File))); got != "abc123  result.csv\n" {
+		t.Errorf("result.csv.sha256 = %q", got)
+	}
+	manifest, err = entry.(Dir).Lookup(ctx, "result.jsonl.manifest.json")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if data := string(readFile(t, manifest.(File))); !strings.Contains(data, `"file": "result.jsonl"`) || !strings.Contains(data, `"format": "ndjson"`) {
+		t.Errorf("result.jsonl.manifest.json = %s", data)
+	}
+	for _, name := range []string{"result.error.sha256", "schema.csv.manifest.json", "result.sha256.sha256"} {
+		if _, err := entry.(Dir).Lookup(ctx, name); !errors.Is(err, os.ErrNotExist) {
+			t.Errorf("Lookup(%s) = %v, want ErrNotExist", name, err)
+		}
+	}
+}
+
+func TestNodeErrorFiles(t *testing.T) {
+	root, exec := newTestRoot(t, []axiomclient.Dataset{{Name: "logs"}}, []byte("ok"))
+	ctx := context.Background()
+	exec.err = &axiomclient.APIError{StatusCode: 401, Code: 401, Message: "invalid token"}
+
+	var node Node = root
+	for _, seg := range []string{"logs", "q", "result.csv.error"} {
+		next, err := node.(Dir).Lookup(ctx, seg)
+		if err != nil {
+			t.Fatalf("Lookup(%q): %v", seg, err)
+		}
+		node = next
+	}
+	data := string(readFile(t, node.(File)))
+	for _, want := range []string{`"file": "result.csv"`, `"ok": false`, `"errno": "EACCES"`, `"status": 401`, "invalid token"} {
+		if !strings.Contains(data, want) {
+			t.Errorf("result.csv.error missing %s:\n%s", want, data)
+		}
+	}
+
+	root.Store().Set("bad", []byte("['logs'] | where"))
+	exec.err = &axiomclient.APIError{StatusCode: 400, Code: 400, Message: "syntax error"}
+	queries, _ := root.Lookup(ctx, "_queries")
+	entry, _ := queries.(Dir).Lookup(ctx, "bad")
+	errFile, err := entry.(Dir).Lookup(ctx, "result.json.error")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if data := string(readFile(t, errFile.(File))); !strings.Contains(data, `"errno": "EINVAL"`) {
+		t.Errorf("syntax errors should map to EINVAL:\n%s", data)
+	}
+
+	exec.err = nil
+	if data := string(readFile(t, errFile.(File))); !strings.Contains(data, `"ok": true`) {
+		t.Errorf("successful read should report ok:\n%s", data)
+	}
+	if _, err := entry.(Dir).Lookup(ctx, "nope.error"); err == nil {
+		t.Error(".error of a missing file should not exist")
+	}
+}
+
+func TestErrno(t *testing.T) {
+	cases := map[error]syscall.Errno{
+		&axiomclient.APIError{StatusCode: 403}:                 syscall.EACCES,
+		&axiomclient.APIError{StatusCode: 404}:                 syscall.ENOENT,
+		fmt.Errorf("wrapped: %w", os.ErrNotExist):              syscall.ENOENT,
+		&quota.ExceededError{Principal: "p", Resource: "rows"}: syscall.EDQUOT,
+		context.DeadlineExceeded:                               syscall.ETIMEDOUT,
+		errors.New("boom"):                                     syscall.EIO,
+	}
+	for err, want := range cases {
+		if got := Errno(err); got != want {
+			t.Errorf("Errno(%v) = %v, want %v", err, got, want)
+		}
+	}
+}
+
+func TestResultCount(t *testing.T) {
+	root, exec := newTestRoot(t, []axiomclient.Dataset{{Name: "logs"}}, nil)
+	ctx := context.Background()
+
+	var node Node = root
+	for _, seg := range []string{"logs", "q", "where", "status>=500", "result.count"} {
+		next, err := node.(Dir).Lookup(ctx, seg)
+		if err != nil {
+			t.Fatalf("Lookup(%q): %v", seg, err)
+		}
+		node = next
+	}
+	if got := string(readFile(t, node.(File))); got != "42\n" {
+		t.Errorf("result.count = %q", got)
+	}
+	if !strings.Contains(exec.lastAPL(), "where status>=500") {
+		t.Errorf("counted APL = %q", exec.lastAPL())
+	}
+
+	root.Store().Set("errors", []byte("['logs'] | where status >= 500"))
+	queries, _ := root.Lookup(ctx, "_queries")
+	entry, _ := queries.(Dir).Lookup(ctx, "errors")
+	count, err := entry.(Dir).Lookup(ctx, "result.count")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := string(readFile(t, count.(File))); got != "42\n" {
+		t.Errorf("_queries result.count = %q", got)
+	}
+
+	stats, err := entry.(Dir).Lookup(ctx, "result.stats.csv")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := string(readFile(t, stats.(File))); !strings.HasPrefix(got, "column,type,count") {
+		t.Errorf("_queries result.stats.csv = %q", got)
+	}
+	node = root
+	for _, seg := range []string{"logs", "q", "where", "status>=500", "format", "csv", "result.stats.csv"} {
+		next, err := node.(Dir).Lookup(ctx, seg)
+		if err != nil {
+			t.Fatalf("Lookup(%q): %v", seg, err)
+		}
+		node = next
+	}
+	readFile(t, node.(File))
+	if format := exec.formatLog[len(exec.formatLog)-1]; format != "csv" {
+		t.Errorf("q/ result.stats.csv described %s", format)
+	}
+}
+
+func TestResultTables(t *testing.T) {
+	root, exec := newTestRoot(t, []axiomclient.Dataset{{Name: "logs"}}, nil)
+	exec.result = &axiomclient.QueryResult{Tables: []axiomclient.QueryTable{{Name: "errors"}, {Name: "slow"}}}
+	ctx := context.Background()
+
+	var node Node = root
+	for _, seg := range []string{"logs", "q", "where", "tables"} {
+		next, err := node.(Dir).Lookup(ctx, seg)
+		if err != nil {
+			t.Fatalf("Lookup(%q): %v", seg, err)
+		}
+		node = next
+	}
+	if _, ok := node.(*QueryPathDir); !ok {
+		t.Fatalf("where/tables = %T, want the where argument", node)
+	}
+
+	node = root
+	for _, seg := range []string{"logs", "q", "where", "status>=500", "tables"} {
+		next, err := node.(Dir).Lookup(ctx, seg)
+		if err != nil {
+			t.Fatalf("Lookup(%q): %v", seg, err)
+		}
+		node = next
+	}
+	entries, err := node.(Dir).ReadDir(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var names []string
+	for _, entry := range entries {
+		names = append(names, entry.Name())
+	}
+	if strings.Join(names, ",") != "errors.csv,slow.csv" {
+		t.Errorf("tables/ = %v", names)
+	}
+	table, err := node.(Dir).Lookup(ctx, "slow.csv")
+	if err != nil {
+		t.Fatal(err)
+	}
+	readFile(t, table.(File))
+	if opts := exec.optsLog[len(exec.optsLog)-1]; opts.Table != "slow" || exec.formatLog[len(exec.formatLog)-1] != "csv" {
+		t.Errorf("slow.csv ran table %q as %s", opts.Table, exec.formatLog[len(exec.formatLog)-1])
+	}
+	if _, err := node.(Dir).Lookup(ctx, "slow.json"); !errors.Is(err, os.ErrNotExist) {
+		t.Errorf("Lookup(slow.json) = %v", err)
+	}
+
+	root.Store().Set("errors", []byte("['logs'] | where status >= 500"))
+	queries, _ := root.Lookup(ctx, "_queries")
+	entry, _ := queries.(Dir).Lookup(ctx, "errors")
+	tables, err := entry.(Dir).Lookup(ctx, "tables")
+	if err != nil {
+		t.Fatal(err)
+	}
+	table, err = tables.(Dir).Lookup(ctx, "errors.csv")
+	if err != nil {
+		t.Fatal(err)
+	}
+	readFile(t, table.(File))
+	if opts := exec.optsLog[len(exec.optsLog)-1]; opts.Table != "errors" || opts.Headers == nil {
+		t.Errorf("_queries tables/errors.csv opts = %+v", opts)
+	}
+}
+
+func TestQueryPlan(t *testing.T) {
+	root, _ := newTestRoot(t, []axiomclient.Dataset{{Name: "logs"}}, nil)
+	ctx := context.Background()
+	lookup := func(path ...string) File {
+		t.Helper()
+		var node Node = root
+		for _, seg := range path {
+			next, err := node.(Dir).Lookup(ctx, seg)
+			if err != nil {
+				t.Fatalf("Lookup(%q): %v", seg, err)
+			}
+			node = next
+		}
+		return node.(File)
+	}
+
+	text := string(readFile(t, lookup("logs", "q", "where", "status>=500", "plan.txt")))
+	want := "1. ['logs']\n   dataset logs\n" +
+		"2. | where _time between (ago(1h) .. now())\n   default range: no range/ segment\n" +
+		"3. | where status>=500\n   from where/status>=500/\n" +
+		"4. | take 10000\n   default limit: no limit/ or top/ segment, so rows past 10000 are dropped\n" +
+		"format: ndjson\n"
+	if text != want {
+		t.Errorf("plan.txt =\n%s\nwant\n%s", text, want)
+	}
+	var plan queryPlan
+	if err := json.Unmarshal(readFile(t, lookup("logs", "q", "limit", "5", "plan.json")), &plan); err != nil {
+		t.Fatal(err)
+	}
+	if len(plan.Stages) != 3 || plan.Stages[2].Segment != "limit/5" || !strings.HasSuffix(plan.APL, "| take 5") {
+		t.Errorf("plan.json = %+v", plan)
+	}
+
+	aplFile := lookup("logs", "q", "limit", "5", "apl.txt")
+	if got := string(readFile(t, aplFile)); got != plan.APL+"\n" {
+		t.Errorf("apl.txt = %q, want %q", got, plan.APL+"\n")
+	}
+	if info, _ := aplFile.Stat(ctx); info.Size() != int64(len(plan.APL)+1) {
+		t.Errorf("apl.txt size = %d", info.Size())
+	}
+	logs, _ := root.Lookup(ctx, "logs")
+	q, _ := logs.(Dir).Lookup(ctx, "q")
+	incomplete, _ := q.(Dir).Lookup(ctx, "where")
+	if _, err := incomplete.(Dir).Lookup(ctx, "apl.txt"); !errors.Is(err, os.ErrNotExist) {
+		t.Errorf("apl.txt of an incomplete path: err = %v, want ErrNotExist", err)
+	}
+
+	root.Store().Set("errors", []byte("['logs'] | where status >= 500 | take 5"))
+	text = string(readFile(t, lookup("_queries", "errors", "plan.txt")))
+	if !strings.HasPrefix(text, "1. ['logs']\n   source\n2. | where status >= 500\n3. | take 5\n") || !strings.Contains(text, "no default range or limit") {
+		t.Errorf("saved plan.txt =\n%s", text)
+	}
+}
+
+func TestSavedQueryRevision(t *testing.T) {
+	root, exec := newTestRoot(t, nil, []byte("a,b\n"))
+	ctx := context.Background()
+	root.Store().Set("errors", []byte("['logs'] | where status >= 500"))
+	queries, _ := root.Lookup(ctx, "_queries")
+	entry, _ := queries.(Dir).Lookup(ctx, "errors")
+	result, _ := entry.(Dir).Lookup(ctx, "result.csv")
+
+	f, err := result.(File).Open(ctx, os.O_RDONLY)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	if f.(interface{ Size() int64 }).Size() != 4 {
+		t.Error("result handle should report its size")
+	}
+	root.Store().Set("errors", []byte("['logs'] | where status >= 400"))
+	if _, err := f.Read(make([]byte, 4)); Errno(err) != syscall.ESTALE {
+		t.Errorf("read after rewrite: err = %v, want ESTALE", err)
+	}
+
+	// A rewrite while the query runs fails the open.
+	exec.onExecute = func() { root.Store().Set("errors", []byte("['logs'] | take 1")) }
+	if _, err := result.(File).Open(ctx, os.O_RDONLY); Errno(err) != syscall.ESTALE {
+		t.Errorf("open racing a rewrite: err = %v, want ESTALE", err)
+	}
+	exec.onExecute = nil
+
+	// go-nfs opens the file for every READ: a read continuing past offset
+	// 0 after a rewrite fails, until the client reads from the start.
+	readAt := func(ctx context.Context, off int64) error {
+		f, err := result.(File).Open(ctx, os.O_RDONLY)
+		if err != nil {
+			return err
+		}
+		defer f.Close()
+		_, err = f.ReadAt(make([]byte, 2), off)
+		return err
+	}
+	if err := readAt(ctx, 0); err != nil {
+		t.Fatal(err)
+	}
+	root.Store().Set("errors", []byte("['logs'] | where status >= 500"))
+	if err := readAt(ctx, 2); Errno(err) != syscall.ESTALE {
+		t.Errorf("read continuing across a rewrite: err = %v, want ESTALE", err)
+	}
+	if err := readAt(query.WithClient(ctx, "10.0.0.2"), 2); err != nil {
+		t.Errorf("another client's read: %v", err)
+	}
+	if err := readAt(ctx, 0); err != nil {
+		t.Errorf("read from the start after a rewrite: %v", err)
+	}
+	if err := readAt(ctx, 2); err != nil {
+		t.Errorf("read continuing at the new revision: %v", err)
+	}
+
+	stats, _ := entry.(Dir).Lookup(ctx, "stats.json")
+	if data := string(readFile(t, stats.(File))); !strings.Contains(data, `"revision": 4`) {
+		t.Errorf("stats.json = %s", data)
+	}
+}
+
+func TestTemplates(t *testing.T) {
+	cfg := config.Default()
+	cfg.CacheDir = t.TempDir()
+	cfg.QueryDir = t.TempDir()
+	exec := &mockExecutor{data: []byte("ok\n")}
+	root := NewRoot(cfg, &mockClient{}, exec)
+	ctx := context.Background()
+
+	lookup := func(path ...string) Node {
+		t.Helper()
+		var node Node = root
+		for _, seg := range path {
+			next, err := node.(Dir).Lookup(ctx, seg)
+			if err != nil {
+				t.Fatalf("Lookup(%q): %v", seg, err)
+			}
+			node = next
+		}
+		return node
+	}
+	if names := dirNames(t, lookup("_templates").(Dir)); !slices.Contains(names, "errors") {
+		t.Fatalf("_templates = %v", names)
+	}
+	if names := dirNames(t, lookup("_templates", "errors").(Dir)); !slices.Contains(names, "errors-by-service.apl") {
+		t.Fatalf("_templates/errors = %v", names)
+	}
+	tmpl := readFile(t, lookup("_templates", "errors", "errors-by-service.apl").(File))
+
+	// cp errors-by-service.apl _queries/errs/apl
+	root.Store().Set("errs", tmpl)
+	result := lookup("_queries", "errs", "result.csv").(File)
+	if _, err := result.Open(ctx, os.O_RDONLY); Errno(err) != syscall.EINVAL || !strings.Contains(err.Error(), "DATASET, FIELD") {
+		t.Fatalf("result without vars: err = %v", err)
+	}
+
+	vars := lookup("_queries", "errs", "vars").(Writable)
+	f, err := vars.Create(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, _ = f.Write([]byte("DATASET=http-logs\nFIELD=service\n"))
+	_ = f.Close()
+	if got := string(readFile(t, result)); got != "ok\n" {
+		t.Errorf("result = %q", got)
+	}
+	if apl := exec.lastAPL(); !strings.Contains(apl, "['http-logs']") || !strings.Contains(apl, "by service") {
+		t.Errorf("executed APL = %q", apl)
+	}
+	if names := dirNames(t, lookup("_queries").(Dir)); !slices.Equal(names, []string{"errs"}) {
+		t.Errorf("vars should not show up as a saved query: %v", names)
+	}
+}
+
+func TestDashboards(t *testing.T) {
+	ctx := context.Background()
+	cfg := config.Default()
+	cfg.CacheDir = t.TempDir()
+	client := &dashboardClient{dashboards: []axiomclient.Dashboard{
+		{
+			ID:              "d1",
+			Name:            "API / overview",
+			TimeWindowStart: "qr-now-6h",
+			TimeWindowEnd:   "qr-now",
+			Charts: []axiomclient.DashboardChart{
+				{ID: "c1", Name: "Errors", Query: axiomclient.DashboardQuery{APL: "['logs'] | where status >= 500 | summarize count() by bin_auto(_time)"}},
+				{ID: "c2", Name: "Errors", Query: axiomclient.DashboardQuery{APL: "['logs'] | count"}},
+			},
+		},
+		{
+			ID:              "d2",
+			Name:            "Incident",
+			TimeWindowStart: "2025-01-01T00:00:00Z",
+			TimeWindowEnd:   "2025-01-01T06:00:00Z",
+			Charts:          []axiomclient.DashboardChart{{ID: "c3", Query: axiomclient.DashboardQuery{APL: "['logs']"}}},
+		},
+	}}
+	exec := &mockExecutor{data: []byte("a\n1\n")}
+	root := NewRoot(cfg, client, exec)
+
+	if names := dirNames(t, root); !slices.Contains(names, "_dashboards") {
+		t.Fatalf("root = %v", names)
+	}
+	dir, err := root.Lookup(ctx, "_dashboards")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if names := dirNames(t, dir.(Dir)); !slices.Equal(names, []string{"API _ overview", "Incident"}) {
+		t.Fatalf("_dashboards = %v", names)
+	}
+	overview, _ := dir.(Dir).Lookup(ctx, "API _ overview")
+	if names := dirNames(t, overview.(Dir)); !slices.Equal(names, []string{"Errors", "Errors c2"}) {
+		t.Fatalf("charts = %v", names)
+	}
+	chart, _ := overview.(Dir).Lookup(ctx, "Errors")
+	result, err := chart.(Dir).Lookup(ctx, "result.csv")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := string(readFile(t, result.(File))); got != "a\n1\n" {
+		t.Errorf("result.csv = %q", got)
+	}
+	if apl := exec.lastAPL(); apl != "['logs']\n| where _time between (ago(6h) .. now())\n| where status >= 500 | summarize count() by bin_auto(_time)" {
+		t.Errorf("chart APL = %q", apl)
+	}
+	if exec.lastFormat() != "csv" {
+		t.Errorf("format = %q", exec.lastFormat())
+	}
+
+	incident, _ := dir.(Dir).Lookup(ctx, "Incident")
+	chart, err = incident.(Dir).Lookup(ctx, "c3")
+	if err != nil {
+		t.Fatal(err)
+	}
+	aplFile, _ := chart.(Dir).Lookup(ctx, "apl")
+	if got := string(readFile(t, aplFile.(File))); got != "['logs']\n| where _time between (datetime(2025-01-01T00:00:00Z) .. datetime(2025-01-01T06:00:00Z))\n" {
+		t.Errorf("apl = %q", got)
+	}
+	if client.gets != 2 {
+		t.Errorf("GetDashboard calls = %d, want one per dashboard", client.gets)
+	}
+
+	plain := NewRoot(cfg, &mockClient{}, exec)
+	if _, err := plain.Lookup(ctx, "_dashboards"); !errors.Is(err, os.ErrNotExist) {
+		t.Errorf("_dashboards without dashboard support: err = %v", err)
+	}
+}
+
+func TestOrgDir(t *testing.T) {
+	ctx := context.Background()
+	cfg := config.Default()
+	cfg.CacheDir = t.TempDir()
+	root := NewRoot(cfg, &orgClient{}, &mockExecutor{})
+
+	dir, err := root.Lookup(ctx, "_org")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if names := dirNames(t, dir.(Dir)); !slices.Equal(names, []string{"limits.json", "org.json", "tokens.json", "user.json"}) {
+		t.Fatalf("_org = %v", names)
+	}
+	read := func(name string) string {
+		t.Helper()
+		node, err := dir.(Dir).Lookup(ctx, name)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return string(readFile(t, node.(File)))
+	}
+	for name, want := range map[string]string{
+		"user.json":   `"email": "test@example.com"`,
+		"org.json":    `"name": "Acme"`,
+		"limits.json": `"remaining": 9`,
+		"tokens.json": `"redacted": "xaat-…abcd"`,
+	} {
+		if got := read(name); !strings.Contains(got, want) {
+			t.Errorf("%s missing %s: %s", name, want, got)
+		}
+	}
+	if got := read("tokens.json"); !strings.Contains(got, `"tokens": []`) || !strings.Contains(got, "forbidden") {
+		t.Errorf("tokens.json should report the listing error: %s", got)
+	}
+
+	plain := NewRoot(cfg, &mockClient{}, &mockExecutor{})
+	dir, _ = plain.Lookup(ctx, "_org")
+	if names := dirNames(t, dir.(Dir)); !slices.Equal(names, []string{"user.json"}) {
+		t.Errorf("_org without org support = %v", names)
+	}
+}
+
+func TestMetaDir(t *testing.T) {
+	ctx := context.Background()
+	cfg := config.Default()
+	cfg.CacheDir = t.TempDir()
+	client := &mockClient{
+		datasets: []axiomclient.Dataset{{Name: "logs"}},
+		fields: map[string][]axiomclient.Field{"logs": {
+			{Name: "_time", Type: "datetime"},
+			{Name: "duration", Type: "float", Unit: "ms", Description: "request time"},
+			{Name: "secret", Type: "string", Hidden: true},
+		}},
+	}
+	root := NewRoot(cfg, client, &mockExecutor{})
+
+	read := func(path ...string) string {
+		t.Helper()
+		var node Node = root
+		for _, name := range path {
+			var err error
+			if node, err = node.(Dir).Lookup(ctx, name); err != nil {
+				t.Fatalf("%v: %v", path, err)
+			}
+		}
+		return string(readFile(t, node.(File)))
+	}
+
+	var catalog apl.Catalog
+	if err := json.Unmarshal([]byte(read("_meta", "apl", "functions.json")), &catalog); err != nil {
+		t.Fatal(err)
+	}
+	if !slices.ContainsFunc(catalog.Operators, func(e apl.CatalogEntry) bool { return e.Name == "summarize" }) ||
+		!slices.ContainsFunc(catalog.Functions, func(e apl.CatalogEntry) bool { return e.Name == "count" && e.Aggregation }) {
+		t.Errorf("functions.json = %+v", catalog)
+	}
+
+	datasets, _ := root.Lookup(ctx, "_meta")
+	datasets, _ = datasets.(Dir).Lookup(ctx, "datasets")
+	if names := dirNames(t, datasets.(Dir)); !slices.Equal(names, []string{"logs"}) {
+		t.Errorf("_meta/datasets = %v", names)
+	}
+	if _, err := datasets.(Dir).Lookup(ctx, "missing"); !errors.Is(err, os.ErrNotExist) {
+		t.Errorf("unknown dataset = %v", err)
+	}
+
+	fields := read("_meta", "datasets", "logs", "fields.json")
+	for _, want := range []string{`"dataset": "logs"`, `"name": "duration"`, `"unit": "ms"`, `"description": "request time"`} {
+		if !strings.Contains(fields, want) {
+			t.Errorf("fields.json missing %s: %s", want, fields)
+		}
+	}
+	if strings.Contains(fields, "secret") {
+		t.Errorf("fields.json lists a hidden field: %s", fields)
+	}
+}
+
+func TestPolicyRedaction(t *testing.T) {
+	ctx := context.Background()
+	cfg := config.Default()
+	cfg.CacheDir = t.TempDir()
+	client := &mockClient{
+		datasets: []axiomclient.Dataset{{Name: "logs"}},
+		fields: map[string][]axiomclient.Field{"logs": {
+			{Name: "status", Type: "integer"},
+			{Name: "user.email", Type: "string"},
+			{Name: "user.token", Type: "string"},
+		}},
+	}
+	redactor, err := redact.New([]redact.Rule{{Field: "user.email", Action: redact.Hash}, {Field: "user.*", Action: redact.Drop}}, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	root := NewRoot(cfg, client, &mockExecutor{}, WithRedaction(redactor))
+
+	dir, err := root.Lookup(ctx, "_policy")
+	if err != nil {
+		t.Fatal(err)
+	}
+	file, err := dir.(Dir).Lookup(ctx, "redaction.json")
+	if err != nil {
+		t.Fatal(err)
+	}
+	var status redactionStatus
+	if err := json.Unmarshal(readFile(t, file.(File)), &status); err != nil {
+		t.Fatal(err)
+	}
+	if !slices.Equal(status.Rules, redactor.Rules()) {
+		t.Errorf("redaction.json rules = %+v", status.Rules)
+	}
+
+	logs, err := root.Lookup(ctx, "logs")
+	if err != nil {
+		t.Fatal(err)
+	}
+	fields, err := logs.(Dir).Lookup(ctx, "fields")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if names := dirNames(t, fields.(Dir)); !slices.Equal(names, []string{"status", "user.email"}) {
+		t.Errorf("fields/ = %v, want the dropped field hidden", names)
+	}
+
+	// Without rules the file lists none.
+	root, _ = newTestRoot(t, nil, nil)
+	dir, _ = root.Lookup(ctx, "_policy")
+	file, _ = dir.(Dir).Lookup(ctx, "redaction.json")
+	if got := string(readFile(t, file.(File))); !strings.Contains(got, `"rules": []`) {
+		t.Errorf("redaction.json without rules = %s", got)
+	}
+}
+
+func TestPolicyColumns(t *testing.T) {
+	ctx := context.Background()
+	cfg := config.Default()
+	cfg.CacheDir = t.TempDir()
+	client := &mockClient{
+		datasets: []axiomclient.Dataset{{Name: "billing"}, {Name: "logs"}},
+		fields: map[string][]axiomclient.Field{
+			"billing": {{Name: "_time", Type: "datetime"}, {Name: "plan", Type: "string"}, {Name: "card", Type: "string"}},
+			"logs":    {{Name: "card", Type: "string"}},
+		},
+	}
+	redactor, err := redact.New(nil, []redact.ColumnRule{{Dataset: "billing", Allow: []string{"_time", "plan"}}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	root := NewRoot(cfg, client, &mockExecutor{}, WithRedaction(redactor))
+
+	billing, _ := root.Lookup(ctx, "billing")
+	fields, _ := billing.(Dir).Lookup(ctx, "fields")
+	if names := dirNames(t, fields.(Dir)); !slices.Equal(names, []string{"_time", "plan"}) {
+		t.Errorf("billing fields/ = %v, want the denied column hidden", names)
+	}
+	if _, err := fields.(Dir).Lookup(ctx, "card"); !errors.Is(err, os.ErrNotExist) {
+		t.Errorf("Lookup(card) = %v, want ErrNotExist", err)
+	}
+	schema, _ := billing.(Dir).Lookup(ctx, "schema.json")
+	if data := string(readFile(t, schema.(File))); strings.Contains(data, "card") {
+		t.Errorf("schema.json lists a denied column:\n%s", data)
+	}
+	logs, _ := root.Lookup(ctx, "logs")
+	fields, _ = logs.(Dir).Lookup(ctx, "fields")
+	if names := dirNames(t, fields.(Dir)); !slices.Equal(names, []string{"card"}) {
+		t.Errorf("logs fields/ = %v, want rules of billing not applied", names)
+	}
+
+	root.Store().Set("cards", []byte("['billing'] | project _time, card"))
+	root.Store().Set("plans", []byte("['billing'] | project _time, plan"))
+	queries, _ := root.Lookup(ctx, "_queries")
+	entry, _ := queries.(Dir).Lookup(ctx, "cards")
+	result, _ := entry.(Dir).Lookup(ctx, "result.csv")
+	if _, err := result.(File).Open(ctx, os.O_RDONLY); !errors.Is(err, os.ErrPermission) {
+		t.Errorf("saved query projecting a denied column: err = %v, want ErrPermission", err)
+	}
+	entry, _ = queries.(Dir).Lookup(ctx, "plans")
+	result, _ = entry.(Dir).Lookup(ctx, "result.csv")
+	readFile(t, result.(File))
+
+	dir, _ := root.Lookup(ctx, "_policy")
+	file, _ := dir.(Dir).Lookup(ctx, "redaction.json")
+	if got := string(readFile(t, file.(File))); !strings.Contains(got, `"dataset": "billing"`) {
+		t.Errorf("redaction.json without column rules:\n%s", got)
+	}
+}
+
+func TestPolicyColumnReferences(t *testing.T) {
+	ctx := context.Background()
+	cfg := config.Default()
+	cfg.CacheDir = t.TempDir()
+	cfg.TailInterval = 5 * time.Millisecond
+	events := make(chan string, 1)
+	client := pollingClient([]axiomclient.Dataset{{Name: "logs"}}, events)
+	redactor, err := redact.New(nil, []redact.ColumnRule{{Dataset: "logs", Deny: []string{"msg"}}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	exec := query.NewExecutor(client, nil, "1h", 100, 0, 0, "", query.WithRedaction(redactor))
+	root := NewRoot(cfg, client, exec, WithRedaction(redactor))
+	defer root.Tails().Close()
+
+	queries, _ := root.Lookup(ctx, "_queries")
+	for src, denied := range map[string]bool{
+		"['logs'] | extend x = msg | project x":       true,
+		"['logs'] | summarize make_set(msg)":          true,
+		"['logs'] | where msg contains \"a\" | count": true,
+		"['logs'] | project-away msg | take 1":        false,
+	} {
+		root.Store().Set("q", []byte(src))
+		entry, _ := queries.(Dir).Lookup(ctx, "q")
+		result, _ := entry.(Dir).Lookup(ctx, "result.csv")
+		f, err := result.(File).Open(ctx, os.O_RDONLY)
+		if got := errors.Is(err, os.ErrPermission); got != denied {
+			t.Errorf("%s: err = %v, want denied %v", src, err, denied)
+		}
+		if err == nil {
+			f.Close()
+		}
+	}
+
+	batches, _ := root.Lookup(ctx, "_batch")
+	entry, _ := batches.(Dir).Lookup(ctx, "nightly")
+	pack, _ := entry.(Dir).Lookup(ctx, "queries.json")
+	w, err := pack.(Writable).Create(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, _ = w.Write([]byte(`[{"apl": "['logs'] | extend x = msg | project x"}]`))
+	if err := w.Close(); !errors.Is(err, os.ErrPermission) {
+		t.Errorf("batch reading a denied column: err = %v, want ErrPermission", err)
+	}
+
+	if line := readTail(t, ctx, root, "logs", events, "secret"); strings.Contains(line, "secret") || !strings.Contains(line, `"_sysTime"`) {
+		t.Errorf("tail line with a denied column = %q", line)
+	}
+}
+
+func TestPolicyDatasetAPL(t *testing.T) {
+	ctx := context.Background()
+	cfg := config.Default()
+	cfg.CacheDir = t.TempDir()
+	cfg.Aliases = map[string][]string{"everything": {"logs", "secrets"}}
+	client := &mockClient{datasets: []axiomclient.Dataset{{Name: "logs"}, {Name: "secrets"}}}
+	pol := &policy.Policy{Datasets: policy.Datasets{Deny: []string{"secrets"}}}
+	root := NewRoot(cfg, client, &mockExecutor{data: []byte("{}\n")}, WithPolicy(pol))
+
+	root.Snippets().Set("hidden", []byte("['secrets']"))
+	queries, _ := root.Lookup(ctx, "_queries")
+	for src, denied := range map[string]bool{
+		"['logs'] | take 1":                      false,
+		"['secrets'] | take 1":                   true,
+		"union ['logs'], ['secrets']":            true,
+		"['logs'] | join (secrets) on id":        true,
+		"['everything'] | take 1":                true,
+		"#include hidden\n| take 1":              true,
+		"['logs'] | where ['geo.city'] == \"x\"": false,
+	} {
+		root.Store().Set("q", []byte(src))
+		entry, _ := queries.(Dir).Lookup(ctx, "q")
+		result, _ := entry.(Dir).Lookup(ctx, "result.ndjson")
+		f, err := result.(File).Open(ctx, os.O_RDONLY)
+		if got := errors.Is(err, os.ErrPermission); got != denied {
+			t.Errorf("%s: err = %v, want denied %v", src, err, denied)
+		}
+		if err == nil {
+			f.Close()
+		}
+	}
+
+	batches, _ := root.Lookup(ctx, "_batch")
+	entry, _ := batches.(Dir).Lookup(ctx, "nightly")
+	pack, _ := entry.(Dir).Lookup(ctx, "queries.json")
+	w, err := pack.(Writable).Create(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, _ = w.Write([]byte(`[{"apl": "['secrets'] | count"}]`))
+	if err := w.Close(); !errors.Is(err, os.ErrPermission) {
+		t.Errorf("batch reading a hidden dataset: err = %v, want ErrPermission", err)
+	}
+}
+
+func TestLinkFiles(t *testing.T) {
+	root, _ := newTestRoot(t, []axiomclient.Dataset{{Name: "logs"}}, nil)
+	ctx := context.Background()
+
+	var node Node = root
+	for _, seg := range []string{"logs", "q", "where", "status>=500", "open.url"} {
+		next, err := node.(Dir).Lookup(ctx, seg)
+		if err != nil {
+			t.Fatalf("Lookup(%q): %v", seg, err)
+		}
+		node = next
+	}
+	data := string(readFile(t, node.(File)))
+	if !strings.HasPrefix(data, "[InternetShortcut]\r\nURL=https://app.axiom.co/query?initForm=") {
+		t.Errorf("open.url = %q", data)
+	}
+	info, _ := node.(File).Stat(ctx)
+	if info.Size() != int64(len(data)) {
+		t.Errorf("open.url size = %d, want %d", info.Size(), len(data))
+	}
+
+	root.Store().Set("errors", []byte("['logs'] | where status >= 500"))
+	queries, _ := root.Lookup(ctx, "_queries")
+	entry, _ := queries.(Dir).Lookup(ctx, "errors")
+	if names := dirNames(t, entry.(Dir)); !slices.Contains(names, "link.txt") {
+		t.Errorf("_queries entry listing = %v", names)
+	}
+	link, err := entry.(Dir).Lookup(ctx, "link.txt")
+	if err != nil {
+		t.Fatal(err)
+	}
+	u, err := url.Parse(strings.TrimSpace(string(readFile(t, link.(File)))))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if form := u.Query().Get("initForm"); !strings.Contains(form, `"apl":"['logs'] | where status >= 500"`) {
+		t.Errorf("initForm = %s", form)
+	}
+}
+
+func TestRawQueries(t *testing.T) {
+	root, exec := newTestRoot(t, nil, []byte("results"))
+	ctx := context.Background()
+
+	queries, _ := root.Lookup(ctx, "_queries")
+	qDir := queries.(Dir)
+
+	t.Run("create and execute query", func(t *testing.T) {
+		entry, _ := qDir.Lookup(ctx, "myquery")
+		aplNode, _ := entry.(Dir).Lookup(ctx, "apl")
+
+		// Write APL
+		wf, err := aplNode.(Writable).Create(ctx)
+		if err != nil {
+			t.Fatal(err)
+		}
+		wf.Write([]byte("['logs'] | where error == true | take 50"))
+		wf.Close()
+
+		// Read result
+		resultNode, _ := entry.(Dir).Lookup(ctx, "result.csv")
+		data := readFile(t, resultNode.(File))
+		if string(data) != "results" {
+			t.Errorf("got %q, want results", data)
+		}
+		if !strings.Contains(exec.lastAPL(), "where error == true") {
+			t.Errorf("APL missing filter: %s", exec.lastAPL())
+		}
+	})
+
+	t.Run("import apl file", func(t *testing.T) {
+		for _, file := range []string{"imported.apl", "imported.kql"} {
+			node, err := qDir.Lookup(ctx, file)
+			if err != nil {
+				t.Fatal(err)
+			}
+			wf, err := node.(Writable).Create(ctx)
+			if err != nil {
+				t.Fatal(err)
+			}
+			wf.Write([]byte("['logs'] | where status == 503 // " + file))
+			wf.Close()
+
+			if got := string(readFile(t, node.(File))); !strings.HasSuffix(got, file) {
+				t.Errorf("%s reads %q", file, got)
+			}
+			entry, _ := qDir.Lookup(ctx, "imported")
+			resultNode, _ := entry.(Dir).Lookup(ctx, "result.csv")
+			readFile(t, resultNode.(File))
+			if !strings.Contains(exec.lastAPL(), "// "+file) {
+				t.Errorf("%s: ran %s", file, exec.lastAPL())
+			}
+		}
+		entries, err := qDir.ReadDir(ctx)
+		if err != nil {
+			t.Fatal(err)
+		}
+		for _, entry := range entries {
+			if entry.Name() == "imported" && !entry.IsDir() || strings.HasSuffix(entry.Name(), ".apl") {
+				t.Errorf("listing has %s", entry.Name())
+			}
+		}
+	})
+
+	t.Run("invalid query name rejected", func(t *testing.T) {
+		_, err := qDir.Lookup(ctx, "../escape")
+		if !os.IsNotExist(err) {
+			t.Errorf("expected ErrNotExist for path traversal, got %v", err)
+		}
+	})
+}
+
+func TestFieldsDir(t *testing.T) {
+	cfg := config.Default()
+	cfg.CacheDir = t.TempDir()
+	client := &mockClient{
+		datasets: []axiomclient.Dataset{{Name: "logs"}},
+		fields: map[string][]axiomclient.Field{
+			"logs": {
+				{Name: "duration", Type: "integer"},
+				{Name: "service", Type: "string"},
+				{Name: "status", Type: "integer"},
+			},
+		},
+	}
+	exec := &mockExecutor{
+		data: []byte("field_data"),
+	}
+	root := NewRoot(cfg, client, exec)
+	ctx := context.Background()
+
+	dataset, _ := root.Lookup(ctx, "logs")
+	fields, _ := dataset.(Dir).Lookup(ctx, "fields")
+
+	t.Run("lists fields from API", func(t *testing.T) {
+		names := dirNames(t, fields.(Dir))
+		want := []string{"duration", "service", "status"}
+		if len(names) != len(want) {
+			t.Fatalf("got %v, want %v", names, want)
+		}
+	})
+
+	t.Run("field/top.csv", func(t *testing.T) {
+		fieldDir, err := fields.(Dir).Lookup(ctx, "status")
+		if err != nil {
+			t.Fatalf("Lookup status: %v", err)
+		}
+		topFile, _ := fieldDir.(Dir).Lookup(ctx, "top.csv")
 		_ = readFile(t, topFile.(File))
 		if !strings.Contains(exec.lastAPL(), "count() by status") {
 			t.Errorf("APL missing count() by status: %s", exec.lastAPL())
 		}
 	})
 
-	t.Run("field/histogram.csv", func(t *testing.T) {
-		fieldDir, err := fields.(Dir).Lookup(ctx, "duration")
+	t.Run("field/histogram.csv", func(t *testing.T) {
+		fieldDir, err := fields.(Dir).Lookup(ctx, "duration")
+		if err != nil {
+			t.Fatalf("Lookup duration: %v", err)
+		}
+		histFile, _ := fieldDir.(Dir).Lookup(ctx, "histogram.csv")
+		_ = readFile(t, histFile.(File))
+		if !strings.Contains(exec.lastAPL(), "histogram(duration") {
+			t.Errorf("APL missing histogram: %s", exec.lastAPL())
+		}
+	})
+}
+
+func TestFieldsDirSharded(t *testing.T) {
+	cfg := config.Default()
+	cfg.CacheDir = t.TempDir()
+	cfg.FieldShardThreshold = 4
+	client := &mockClient{
+		datasets: []axiomclient.Dataset{{Name: "traces"}},
+		fields: map[string][]axiomclient.Field{
+			"traces": {
+				{Name: "_time", Type: "datetime"},
+				{Name: "attributes.http.method", Type: "string"},
+				{Name: "Duration", Type: "integer"},
+				{Name: "duration", Type: "integer"},
+				{Name: "resource.service.name", Type: "string"},
+				{Name: "2xx", Type: "integer"},
+				{Name: "secret", Type: "string", Hidden: true},
+			},
+		},
+	}
+	root := NewRoot(cfg, client, &mockExecutor{data: []byte("ok")})
+	ctx := context.Background()
+	dataset, _ := root.Lookup(ctx, "traces")
+	fields, _ := dataset.(Dir).Lookup(ctx, "fields")
+
+	if got, want := dirNames(t, fields.(Dir)), []string{".hidden", "2", "_", "a", "d", "r"}; !slices.Equal(got, want) {
+		t.Fatalf("shards = %v, want %v", got, want)
+	}
+	shard, err := fields.(Dir).Lookup(ctx, "d")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got, want := dirNames(t, shard.(Dir)), []string{"Duration", "duration"}; !slices.Equal(got, want) {
+		t.Errorf("fields/d = %v, want %v", got, want)
+	}
+	field, err := shard.(Dir).Lookup(ctx, "duration")
+	if err != nil || !slices.Contains(dirNames(t, field.(Dir)), "histogram.csv") {
+		t.Errorf("fields/d/duration: %v", err)
+	}
+	if _, err := shard.(Dir).Lookup(ctx, "_time"); !errors.Is(err, os.ErrNotExist) {
+		t.Errorf("_time in shard d: err = %v", err)
+	}
+	if _, err := fields.(Dir).Lookup(ctx, "resource.service.name"); err != nil {
+		t.Errorf("direct field lookup: %v", err)
+	}
+
+	cfg.FieldShardThreshold = 0
+	root = NewRoot(cfg, client, &mockExecutor{})
+	dataset, _ = root.Lookup(ctx, "traces")
+	fields, _ = dataset.(Dir).Lookup(ctx, "fields")
+	if got := dirNames(t, fields.(Dir)); len(got) != 7 || slices.Contains(got, "secret") {
+		t.Errorf("unsharded fields = %v", got)
+	}
+	if _, err := fields.(Dir).Lookup(ctx, "d"); !errors.Is(err, os.ErrNotExist) {
+		t.Errorf("shard served while unsharded: err = %v", err)
+	}
+}
+
+func TestFieldNameEncoding(t *testing.T) {
+	for name, want := range map[string]string{
+		"message":         "message",
+		"héllo wörld":     "héllo wörld",
+		"a/b":             "a%2Fb",
+		"line\nbreak":     "line%0Abreak",
+		"100%":            "100%25",
+		"..":              "%2E.",
+		".hidden":         "%2Ehidden",
+		".dot":            ".dot",
+		"bad\xffutf8":     "bad%FFutf8",
+		"tab\tand\x7fdel": "tab%09and%7Fdel",
+	} {
+		if got := encodeFieldName(name); got != want {
+			t.Errorf("encodeFieldName(%q) = %q, want %q", name, got, want)
+		}
+		if got, ok := decodeFieldName(want); !ok || got != name {
+			t.Errorf("decodeFieldName(%q) = %q, %v", want, got, ok)
+		}
+	}
+	for _, entry := range []string{"a%2fb", "a%2", "%41", "..", ".hidden", "a\nb"} {
+		if got, ok := decodeFieldName(entry); ok {
+			t.Errorf("decodeFieldName(%q) = %q, want rejected", entry, got)
+		}
+	}
+
+	cfg := config.Default()
+	cfg.CacheDir = t.TempDir()
+	client := &mockClient{
+		datasets: []axiomclient.Dataset{{Name: "logs"}},
+		fields: map[string][]axiomclient.Field{
+			"logs": {
+				{Name: "message", Type: "string"},
+				{Name: "http/status", Type: "integer"},
+				{Name: "multi\nline", Type: "string"},
+			},
+		},
+	}
+	exec := &mockExecutor{data: []byte("ok")}
+	root := NewRoot(cfg, client, exec)
+	ctx := context.Background()
+	dataset, _ := root.Lookup(ctx, "logs")
+	fields, _ := dataset.(Dir).Lookup(ctx, "fields")
+
+	want := []string{".names.json", "http%2Fstatus", "message", "multi%0Aline"}
+	if got := dirNames(t, fields.(Dir)); !slices.Equal(got, want) {
+		t.Errorf("fields/ = %v, want %v", got, want)
+	}
+	names, err := fields.(Dir).Lookup(ctx, ".names.json")
+	if err != nil {
+		t.Fatal(err)
+	}
+	var mapping map[string]string
+	if err := json.Unmarshal(readFile(t, names.(File)), &mapping); err != nil || len(mapping) != 2 || mapping["multi%0Aline"] != "multi\nline" {
+		t.Errorf(".names.json = %v, %v", mapping, err)
+	}
+
+	field, err := fields.(Dir).Lookup(ctx, "http%2Fstatus")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if info, _ := field.Stat(ctx); info.Name() != "http%2Fstatus" {
+		t.Errorf("field Stat name = %q", info.Name())
+	}
+	top, _ := field.(Dir).Lookup(ctx, "top.csv")
+	readFile(t, top.(File))
+	if got := exec.aplLog[len(exec.aplLog)-1]; !strings.Contains(got, "by ['http/status']") {
+		t.Errorf("top.csv APL = %q", got)
+	}
+	if _, err := fields.(Dir).Lookup(ctx, "http%2fstatus"); !errors.Is(err, os.ErrNotExist) {
+		t.Errorf("non-canonical name: err = %v", err)
+	}
+
+	schema, _ := dataset.(Dir).Lookup(ctx, "schema.csv")
+	if got := string(readFile(t, schema.(File))); !strings.Contains(got, "\nmulti%0Aline,string") {
+		t.Errorf("schema.csv =\n%s", got)
+	}
+}
+
+func TestHiddenFields(t *testing.T) {
+	cfg := config.Default()
+	cfg.CacheDir = t.TempDir()
+	client := &mockClient{
+		datasets: []axiomclient.Dataset{{Name: "logs"}},
+		fields: map[string][]axiomclient.Field{
+			"logs": {
+				{Name: "message", Type: "string"},
+				{Name: "_sysTime", Type: "datetime", Hidden: true},
+			},
+		},
+	}
+	ctx := context.Background()
+	open := func(cfg config.Config) (Dir, Dir) {
+		root := NewRoot(cfg, client, &mockExecutor{data: []byte("ok")})
+		dataset, _ := root.Lookup(ctx, "logs")
+		fields, _ := dataset.(Dir).Lookup(ctx, "fields")
+		return dataset.(Dir), fields.(Dir)
+	}
+	schema := func(dataset Dir, name string) string {
+		node, err := dataset.Lookup(ctx, name)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return string(readFile(t, node.(File)))
+	}
+
+	dataset, fields := open(cfg)
+	if got, want := dirNames(t, fields), []string{".hidden", "message"}; !slices.Equal(got, want) {
+		t.Errorf("fields/ = %v, want %v", got, want)
+	}
+	for _, name := range []string{"schema.csv", "schema.json"} {
+		if strings.Contains(schema(dataset, name), "_sysTime") {
+			t.Errorf("%s lists a hidden field", name)
+		}
+	}
+	hidden, err := fields.Lookup(ctx, ".hidden")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := dirNames(t, hidden.(Dir)); !slices.Equal(got, []string{"_sysTime"}) {
+		t.Errorf("fields/.hidden = %v", got)
+	}
+	field, err := hidden.(Dir).Lookup(ctx, "_sysTime")
+	if err != nil || !slices.Contains(dirNames(t, field.(Dir)), "histogram.csv") {
+		t.Errorf("fields/.hidden/_sysTime: %v", err)
+	}
+	if _, err := hidden.(Dir).Lookup(ctx, "message"); !errors.Is(err, os.ErrNotExist) {
+		t.Errorf("visible field under .hidden: err = %v", err)
+	}
+
+	cfg.IncludeHiddenFields = true
+	dataset, fields = open(cfg)
+	if got, want := dirNames(t, fields), []string{".hidden", "_sysTime", "message"}; !slices.Equal(got, want) {
+		t.Errorf("fields/ with hidden = %v, want %v", got, want)
+	}
+	if !strings.Contains(schema(dataset, "schema.csv"), "_sysTime,datetime") {
+		t.Error("schema.csv missing hidden field with IncludeHiddenFields")
+	}
+	if !strings.Contains(schema(dataset, "schema.json"), `"_sysTime"`) {
+		t.Error("schema.json missing hidden field with IncludeHiddenFields")
+	}
+}
+
+func TestFieldDir_HistogramVisibility(t *testing.T) {
+	tests := []struct {
+		fieldType     string
+		wantHistogram bool
+	}{
+		{"integer", true},
+		{"float", true},
+		{"datetime", true},
+		{"timespan", true},
+		{"string", false},
+		{"boolean", false},
+		{"array", false},
+		{"object", false},
+		{"", false}, // unknown type
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.fieldType, func(t *testing.T) {
+			cfg := config.Default()
+			cfg.CacheDir = t.TempDir()
+			client := &mockClient{
+				datasets: []axiomclient.Dataset{{Name: "logs"}},
+				fields: map[string][]axiomclient.Field{
+					"logs": {{Name: "testfield", Type: tt.fieldType}},
+				},
+			}
+			exec := &mockExecutor{data: []byte("data")}
+			root := NewRoot(cfg, client, exec)
+			ctx := t.Context()
+
+			dataset, _ := root.Lookup(ctx, "logs")
+			fields, _ := dataset.(Dir).Lookup(ctx, "fields")
+			fieldDir, err := fields.(Dir).Lookup(ctx, "testfield")
+			if err != nil {
+				t.Fatalf("Lookup testfield: %v", err)
+			}
+
+			// Check ReadDir
+			entries, _ := fieldDir.(Dir).ReadDir(ctx)
+			hasHistogram := false
+			for _, e := range entries {
+				if e.Name() == "histogram.csv" {
+					hasHistogram = true
+				}
+			}
+			if hasHistogram != tt.wantHistogram {
+				t.Errorf("ReadDir: hasHistogram=%v, want %v", hasHistogram, tt.wantHistogram)
+			}
+
+			// Check Lookup
+			_, lookupErr := fieldDir.(Dir).Lookup(ctx, "histogram.csv")
+			if tt.wantHistogram && lookupErr != nil {
+				t.Errorf("Lookup histogram.csv should succeed for %s, got: %v", tt.fieldType, lookupErr)
+			}
+			if !tt.wantHistogram && lookupErr == nil {
+				t.Errorf("Lookup histogram.csv should fail for %s", tt.fieldType)
+			}
+		})
+	}
+}
+
+func TestPresets(t *testing.T) {
+	root, exec := newTestRoot(t, []axiomclient.Dataset{{Name: "logs"}}, []byte("preset_data"))
+	ctx := context.Background()
+
+	t.Run("_presets lists all presets", func(t *testing.T) {
+		presets, _ := root.Lookup(ctx, "_presets")
+		names := dirNames(t, presets.(Dir))
+		if len(names) == 0 {
+			t.Error("expected presets")
+		}
+		for _, n := range names {
+			if !strings.HasSuffix(n, ".json") {
+				t.Errorf("preset %q should end with .json", n)
+			}
+		}
+	})
+
+	t.Run("dataset presets execute with dataset", func(t *testing.T) {
+		dataset, _ := root.Lookup(ctx, "logs")
+		presets, _ := dataset.(Dir).Lookup(ctx, "presets")
+		names := dirNames(t, presets.(Dir))
+		if len(names) == 0 {
+			t.Skip("no presets for this dataset")
+		}
+
+		first, _ := presets.(Dir).Lookup(ctx, names[0])
+		_ = readFile(t, first.(File))
+		if !strings.Contains(exec.lastAPL(), "['logs']") {
+			t.Errorf("APL missing dataset: %s", exec.lastAPL())
+		}
+	})
+}
+
+func TestStaticFiles(t *testing.T) {
+	root, _ := newTestRoot(t, nil, nil)
+	ctx := context.Background()
+
+	t.Run("README.txt", func(t *testing.T) {
+		node, _ := root.Lookup(ctx, "README.txt")
+		data := readFile(t, node.(File))
+		if len(data) == 0 {
+			t.Error("README should not be empty")
+		}
+		if !strings.Contains(string(data), "Axiom") {
+			t.Error("README should mention Axiom")
+		}
+	})
+
+	t.Run("examples/quickstart.txt", func(t *testing.T) {
+		examples, _ := root.Lookup(ctx, "examples")
+		qs, _ := examples.(Dir).Lookup(ctx, "quickstart.txt")
+		data := readFile(t, qs.(File))
+		if !strings.Contains(string(data), "result.csv") {
+			t.Error("quickstart should show example path")
+		}
+	})
+}
+
+func TestSchemaCSV(t *testing.T) {
+	cases := []struct {
+		name   string
+		result *axiomclient.QueryResult
+		want   []string
+	}{
+		{
+			name:   "empty",
+			result: &axiomclient.QueryResult{},
+			want:   nil,
+		},
+		{
+			name: "simple fields",
+			result: &axiomclient.QueryResult{
+				Tables: []axiomclient.QueryTable{{
+					Fields: []axiomclient.QueryField{
+						{Name: "service", Type: "string"},
+						{Name: "count_", Type: "int64"},
+					},
+				}},
+			},
+			want: []string{"service,string", "count_,int64"},
+		},
+		{
+			name: "with aggregation",
+			result: &axiomclient.QueryResult{
+				Tables: []axiomclient.QueryTable{{
+					Fields: []axiomclient.QueryField{
+						{
+							Name: "total",
+							Type: "int64",
+							Aggregation: &axiomclient.Aggregation{
+								Op:     "sum",
+								Fields: []string{"amount"},
+							},
+						},
+					},
+				}},
+			},
+			want: []string{"total,int64,sum(amount)"},
+		},
+		{
+			name: "with numeric arg",
+			result: &axiomclient.QueryResult{
+				Tables: []axiomclient.QueryTable{{
+					Fields: []axiomclient.QueryField{
+						{
+							Name: "p95",
+							Type: "float",
+							Aggregation: &axiomclient.Aggregation{
+								Op:     "percentile",
+								Fields: []string{"duration"},
+								Args:   []any{json.Number("95")},
+							},
+						},
+					},
+				}},
+			},
+			want: []string{"p95,float,\"percentile(duration, 95)\""},
+		},
+	}
+
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			data, err := schemaCSV(tc.result)
+			if err != nil {
+				t.Fatal(err)
+			}
+			for _, w := range tc.want {
+				if !strings.Contains(string(data), w) {
+					t.Errorf("missing %q in:\n%s", w, data)
+				}
+			}
+		})
+	}
+}
+
+func TestSchemaJSONSchema(t *testing.T) {
+	cfg := config.Default()
+	cfg.CacheDir = t.TempDir()
+	client := &mockClient{
+		datasets: []axiomclient.Dataset{{Name: "logs"}},
+		fields: map[string][]axiomclient.Field{
+			"logs": {
+				{Name: "_time", Type: "datetime"},
+				{Name: "duration", Type: "float", Unit: "ms", Description: "request duration"},
+				{Name: "attributes.http.status", Type: "integer|string"},
+				{Name: "secret", Type: "string", Hidden: true},
+			},
+		},
+	}
+	root := NewRoot(cfg, client, &mockExecutor{})
+	ctx := context.Background()
+
+	dataset, _ := root.Lookup(ctx, "logs")
+	node, err := dataset.(Dir).Lookup(ctx, "schema.jsonschema")
+	if err != nil {
+		t.Fatal(err)
+	}
+	var doc map[string]any
+	if err := json.Unmarshal(readFile(t, node.(File)), &doc); err != nil {
+		t.Fatalf("invalid JSON: %v", err)
+	}
+	if doc["$schema"] != "https://json-schema.org/draft/2020-12/schema" || doc["title"] != "logs" {
+		t.Errorf("unexpected header: %v", doc)
+	}
+	props := doc["properties"].(map[string]any)
+	if _, ok := props["secret"]; ok {
+		t.Error("hidden field should be omitted")
+	}
+	if got := props["_time"].(map[string]any)["format"]; got != "date-time" {
+		t.Errorf("_time format = %v", got)
+	}
+	duration := props["duration"].(map[string]any)
+	if duration["type"] != "number" || duration["x-unit"] != "ms" || duration["description"] != "request duration" {
+		t.Errorf("duration = %v", duration)
+	}
+	http := props["attributes"].(map[string]any)["properties"].(map[string]any)["http"].(map[string]any)
+	status := http["properties"].(map[string]any)["status"].(map[string]any)
+	if types, ok := status["type"].([]any); !ok || len(types) != 2 {
+		t.Errorf("status type = %v, want [integer string]", status["type"])
+	}
+}
+
+func TestBytesFile(t *testing.T) {
+	content := []byte("hello world")
+	f := newBytesFile(content)
+
+	t.Run("Read", func(t *testing.T) {
+		buf := make([]byte, 5)
+		n, _ := f.Read(buf)
+		if string(buf[:n]) != "hello" {
+			t.Errorf("got %q", buf[:n])
+		}
+	})
+
+	t.Run("ReadAt", func(t *testing.T) {
+		buf := make([]byte, 5)
+		n, _ := f.ReadAt(buf, 6)
+		if string(buf[:n]) != "world" {
+			t.Errorf("got %q", buf[:n])
+		}
+	})
+
+	t.Run("Seek", func(t *testing.T) {
+		pos, _ := f.Seek(0, io.SeekStart)
+		if pos != 0 {
+			t.Errorf("pos = %d", pos)
+		}
+	})
+
+	t.Run("Write denied", func(t *testing.T) {
+		_, err := f.Write([]byte("x"))
+		if !os.IsPermission(err) {
+			t.Errorf("expected permission error, got %v", err)
+		}
+	})
+
+	t.Run("Truncate denied", func(t *testing.T) {
+		err := f.Truncate(0)
+		if !os.IsPermission(err) {
+			t.Errorf("expected permission error, got %v", err)
+		}
+	})
+}
+
+func TestAPLFile(t *testing.T) {
+	root, _ := newTestRoot(t, nil, nil)
+	_ = context.Background()
+	store := root.Store()
+
+	t.Run("write and read", func(t *testing.T) {
+		f := newAPLFile(store, "test1")
+		f.Write([]byte("['ds'] | take 10"))
+		f.Close()
+
+		data := store.Get("test1")
+		if string(data) != "['ds'] | take 10" {
+			t.Errorf("got %q", data)
+		}
+	})
+
+	t.Run("truncate clears", func(t *testing.T) {
+		store.Set("test2", []byte("old content"))
+		f := newAPLFile(store, "test2")
+		f.Truncate(0)
+		f.Close()
+
+		data := store.Get("test2")
+		if len(data) != 0 {
+			t.Errorf("expected empty, got %q", data)
+		}
+	})
+}
+
+func TestIsValidQueryName(t *testing.T) {
+	cases := []struct {
+		name  string
+		valid bool
+	}{
+		{"simple", true},
+		{"with-dash", true},
+		{"with_underscore", true},
+		{"with.dot", true},
+		{"123numeric", true},
+		{"", false},
+		{"../escape", false},
+		{"path/sep", false},
+		{strings.Repeat("x", 100), true}, // long names OK in vfs, store may reject
+	}
+
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			got := isValidQueryName(tc.name)
+			if got != tc.valid {
+				t.Errorf("isValidQueryName(%q) = %v, want %v", tc.name, got, tc.valid)
+			}
+		})
+	}
+}
+
+func TestAPLFormatAndLint(t *testing.T) {
+	root, _ := newTestRoot(t, []axiomclient.Dataset{{Name: "logs"}}, nil)
+	ctx := context.Background()
+	root.Store().Set("fmt", []byte("['logs']|where status>=500|project message, nope"))
+	entry := &QueryEntryDir{root: root, name: "fmt"}
+
+	t.Run("apl.fmt", func(t *testing.T) {
+		node, err := entry.Lookup(ctx, "apl.fmt")
+		if err != nil {
+			t.Fatal(err)
+		}
+		got := string(readFile(t, node.(File)))
+		want := "['logs']\n| where status >= 500\n| project message, nope\n"
+		if got != want {
+			t.Errorf("got %q, want %q", got, want)
+		}
+	})
+
+	t.Run("lint.json", func(t *testing.T) {
+		node, err := entry.Lookup(ctx, "lint.json")
 		if err != nil {
-			t.Fatalf("Lookup duration: %v", err)
+			t.Fatal(err)
 		}
-		histFile, _ := fieldDir.(Dir).Lookup(ctx, "histogram.csv")
-		_ = readFile(t, histFile.(File))
-		if !strings.Contains(exec.lastAPL(), "histogram(duration") {
-			t.Errorf("APL missing histogram: %s", exec.lastAPL())
+		data := string(readFile(t, node.(File)))
+		for _, want := range []string{"missing-time-filter", "unbounded-take", `unknown field \"nope\"`} {
+			if !strings.Contains(data, want) {
+				t.Errorf("lint.json missing %s: %s", want, data)
+			}
+		}
+		if strings.Contains(data, `\"message\"`) {
+			t.Errorf("known field flagged: %s", data)
 		}
 	})
 }
 
-func TestFieldDir_HistogramVisibility(t *testing.T) {
-	tests := []struct {
-		fieldType     string
-		wantHistogram bool
-	}{
-		{"integer", true},
-		{"float", true},
-		{"datetime", true},
-		{"timespan", true},
-		{"string", false},
-		{"boolean", false},
-		{"array", false},
-		{"object", false},
-		{"", false}, // unknown type
+func TestQueryIncludes(t *testing.T) {
+	cfg := config.Default()
+	cfg.CacheDir = t.TempDir()
+	cfg.QueryDir = t.TempDir()
+	cfg.SnippetDir = t.TempDir()
+	exec := &mockExecutor{data: []byte("ok")}
+	root := NewRoot(cfg, &mockClient{}, exec)
+	ctx := context.Background()
+
+	snippets, _ := root.Lookup(ctx, "_snippets")
+	node, _ := snippets.(Dir).Lookup(ctx, "prod")
+	w, err := node.(Writable).Create(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, _ = w.Write([]byte("| where env == \"prod\"\n"))
+	_ = w.Close()
+	if names := dirNames(t, snippets.(Dir)); len(names) != 1 || names[0] != "prod" {
+		t.Fatalf("snippets = %v", names)
 	}
 
-	for _, tt := range tests {
-		t.Run(tt.fieldType, func(t *testing.T) {
-			cfg := config.Default()
-			cfg.CacheDir = t.TempDir()
-			client := &mockClient{
-				datasets: []axiomclient.Dataset{{Name: "logs"}},
-				fields: map[string][]axiomclient.Field{
-					"logs": {{Name: "testfield", Type: tt.fieldType}},
-				},
-			}
-			exec := &mockExecutor{data: []byte("data")}
-			root := NewRoot(cfg, client, exec)
-			ctx := t.Context()
+	root.Store().Set("base", []byte("['logs']\n#include <prod>"))
+	root.Store().Set("errors", []byte("#include base\n| where status >= 500"))
+	root.Store().Set("loop", []byte("#include loop"))
 
-			dataset, _ := root.Lookup(ctx, "logs")
-			fields, _ := dataset.(Dir).Lookup(ctx, "fields")
-			fieldDir, err := fields.(Dir).Lookup(ctx, "testfield")
-			if err != nil {
-				t.Fatalf("Lookup testfield: %v", err)
-			}
+	queries, _ := root.Lookup(ctx, "_queries")
+	entry, _ := queries.(Dir).Lookup(ctx, "errors")
+	result, _ := entry.(Dir).Lookup(ctx, "result.ndjson")
+	_ = readFile(t, result.(File))
+	want := "['logs']\n| where env == \"prod\"\n| where status >= 500"
+	if exec.lastAPL() != want {
+		t.Errorf("expanded APL = %q, want %q", exec.lastAPL(), want)
+	}
 
-			// Check ReadDir
-			entries, _ := fieldDir.(Dir).ReadDir(ctx)
-			hasHistogram := false
-			for _, e := range entries {
-				if e.Name() == "histogram.csv" {
-					hasHistogram = true
-				}
-			}
-			if hasHistogram != tt.wantHistogram {
-				t.Errorf("ReadDir: hasHistogram=%v, want %v", hasHistogram, tt.wantHistogram)
-			}
+	loop, _ := queries.(Dir).Lookup(ctx, "loop")
+	errFile, _ := loop.(Dir).Lookup(ctx, "result.error")
+	if data := string(readFile(t, errFile.(File))); !strings.Contains(data, "include cycle") {
+		t.Errorf("result.error should report the cycle: %s", data)
+	}
+}
 
-			// Check Lookup
-			_, lookupErr := fieldDir.(Dir).Lookup(ctx, "histogram.csv")
-			if tt.wantHistogram && lookupErr != nil {
-				t.Errorf("Lookup histogram.csv should succeed for %s, got: %v", tt.fieldType, lookupErr)
-			}
-			if !tt.wantHistogram && lookupErr == nil {
-				t.Errorf("Lookup histogram.csv should fail for %s", tt.fieldType)
-			}
-		})
+func TestQueryColumns(t *testing.T) {
+	root, exec := newTestRoot(t, []axiomclient.Dataset{{Name: "logs"}}, []byte("ok"))
+	ctx := context.Background()
+	root.Store().Set("errors", []byte("['logs'] | where status >= 500"))
+
+	queries, _ := root.Lookup(ctx, "_queries")
+	entry, _ := queries.(Dir).Lookup(ctx, "errors")
+	cols, err := entry.(Dir).Lookup(ctx, "cols")
+	if err != nil {
+		t.Fatal(err)
+	}
+	dir, err := cols.(Dir).Lookup(ctx, "service,status")
+	if err != nil {
+		t.Fatal(err)
+	}
+	result, err := dir.(Dir).Lookup(ctx, "result.csv")
+	if err != nil {
+		t.Fatal(err)
+	}
+	_ = readFile(t, result.(File))
+	if exec.lastAPL() != "['logs'] | where status >= 500" {
+		t.Errorf("cols should not change the APL: %q", exec.lastAPL())
+	}
+	opts := exec.optsLog[len(exec.optsLog)-1]
+	if strings.Join(opts.Columns, ",") != "service,status" || exec.formatLog[len(exec.formatLog)-1] != "csv" {
+		t.Errorf("opts = %+v", opts)
+	}
+
+	qdir, _ := root.Lookup(ctx, "datasets")
+	ds, _ := qdir.(Dir).Lookup(ctx, "logs")
+	node, _ := ds.(Dir).Lookup(ctx, "q")
+	for _, seg := range []string{"cols", "service", "result.ndjson"} {
+		node, err = node.(Dir).Lookup(ctx, seg)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	_ = readFile(t, node.(File))
+	if opts := exec.optsLog[len(exec.optsLog)-1]; len(opts.Columns) != 1 || opts.Columns[0] != "service" {
+		t.Errorf("q/ opts = %+v", opts)
 	}
 }
 
-func TestPresets(t *testing.T) {
-	root, exec := newTestRoot(t, []axiomclient.Dataset{{Name: "logs"}}, []byte("preset_data"))
+func TestQuerySnapshots(t *testing.T) {
+	root, exec := newTestRoot(t, nil, []byte("{\"a\":1}\n"))
 	ctx := context.Background()
+	root.Store().Set("errors", []byte("['logs'] | where status >= 500"))
 
-	t.Run("_presets lists all presets", func(t *testing.T) {
-		presets, _ := root.Lookup(ctx, "_presets")
-		names := dirNames(t, presets.(Dir))
-		if len(names) == 0 {
-			t.Error("expected presets")
+	queries, _ := root.Lookup(ctx, "_queries")
+	entry, _ := queries.(Dir).Lookup(ctx, "errors")
+	snapDir, err := entry.(Dir).Lookup(ctx, "snapshot")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if names := dirNames(t, snapDir.(Dir)); len(names) != 0 {
+		t.Fatalf("snapshots before trigger = %v", names)
+	}
+
+	trigger, err := entry.(Dir).Lookup(ctx, "snapshot.trigger")
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; i < 2; i++ {
+		w, err := trigger.(Writable).Create(ctx)
+		if err != nil {
+			t.Fatal(err)
 		}
-		for _, n := range names {
-			if !strings.HasSuffix(n, ".json") {
-				t.Errorf("preset %q should end with .json", n)
-			}
+		_, _ = w.Write([]byte("now\n"))
+		if err := w.Close(); err != nil {
+			t.Fatalf("trigger close: %v", err)
 		}
-	})
+	}
+	if opts := exec.optsLog[len(exec.optsLog)-1]; opts.UseCache {
+		t.Error("snapshots should bypass the cache")
+	}
 
-	t.Run("dataset presets execute with dataset", func(t *testing.T) {
-		dataset, _ := root.Lookup(ctx, "logs")
-		presets, _ := dataset.(Dir).Lookup(ctx, "presets")
-		names := dirNames(t, presets.(Dir))
-		if len(names) == 0 {
-			t.Skip("no presets for this dataset")
-		}
+	names := dirNames(t, snapDir.(Dir))
+	if len(names) != 2 || names[0] == names[1] || !strings.HasSuffix(names[0], "Z.ndjson") {
+		t.Fatalf("snapshots = %v, want two distinct timestamped files", names)
+	}
+	node, err := snapDir.(Dir).Lookup(ctx, names[0])
+	if err != nil {
+		t.Fatal(err)
+	}
+	if data := string(readFile(t, node.(File))); data != "{\"a\":1}\n" {
+		t.Errorf("snapshot = %q", data)
+	}
+	info, _ := node.Stat(ctx)
+	if info.ModTime().Format(time.RFC3339) != strings.TrimSuffix(names[0], ".ndjson") {
+		t.Errorf("mtime %v should match the snapshot name %s", info.ModTime(), names[0])
+	}
+}
 
-		first, _ := presets.(Dir).Lookup(ctx, names[0])
-		_ = readFile(t, first.(File))
-		if !strings.Contains(exec.lastAPL(), "['logs']") {
-			t.Errorf("APL missing dataset: %s", exec.lastAPL())
-		}
-	})
+type purgingExecutor struct {
+	*mockExecutor
+	purges int
 }
 
-func TestStaticFiles(t *testing.T) {
-	root, _ := newTestRoot(t, nil, nil)
+func (p *purgingExecutor) PurgeCache() int {
+	p.purges++
+	return 0
+}
+
+func TestAdminFiles(t *testing.T) {
 	ctx := context.Background()
+	exec := &purgingExecutor{mockExecutor: &mockExecutor{}}
+	cfg := config.Default()
+	cfg.CacheDir = t.TempDir()
+	if _, err := NewRoot(cfg, &mockClient{}, exec).Lookup(ctx, AdminDir); !errors.Is(err, os.ErrNotExist) {
+		t.Fatalf("Lookup(_admin) without -enable-admin-files error = %v", err)
+	}
 
-	t.Run("README.txt", func(t *testing.T) {
-		node, _ := root.Lookup(ctx, "README.txt")
-		data := readFile(t, node.(File))
-		if len(data) == 0 {
-			t.Error("README should not be empty")
-		}
-		if !strings.Contains(string(data), "Axiom") {
-			t.Error("README should mention Axiom")
+	cfg.EnableAdminFiles = true
+	root := NewRoot(cfg, &mockClient{}, exec)
+	admin, err := root.Lookup(ctx, AdminDir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got, want := dirNames(t, admin.(Dir)), []string{"cache.purge", "cache.purge.confirm"}; !slices.Equal(got, want) {
+		t.Fatalf("_admin = %v, want %v", got, want)
+	}
+	purge, _ := admin.(Dir).Lookup(ctx, "cache.purge")
+	confirm, _ := admin.(Dir).Lookup(ctx, "cache.purge.confirm")
+	write := func(data string) error {
+		w, err := purge.(Writable).Create(ctx)
+		if err != nil {
+			t.Fatal(err)
 		}
-	})
+		_, _ = w.Write([]byte(data))
+		return w.Close()
+	}
 
-	t.Run("examples/quickstart.txt", func(t *testing.T) {
-		examples, _ := root.Lookup(ctx, "examples")
-		qs, _ := examples.(Dir).Lookup(ctx, "quickstart.txt")
-		data := readFile(t, qs.(File))
-		if !strings.Contains(string(data), "result.csv") {
-			t.Error("quickstart should show example path")
+	if err := write("yes\n"); !errors.Is(err, os.ErrPermission) || exec.purges != 0 {
+		t.Fatalf("write without token: error = %v, purges = %d", err, exec.purges)
+	}
+	token := string(readFile(t, confirm.(File)))
+	if err := write(token); err != nil || exec.purges != 1 {
+		t.Fatalf("write with token: error = %v, purges = %d", err, exec.purges)
+	}
+	if err := write(token); !errors.Is(err, os.ErrPermission) || exec.purges != 1 {
+		t.Errorf("reused token: error = %v, purges = %d", err, exec.purges)
+	}
+	if next := string(readFile(t, confirm.(File))); next == token {
+		t.Error("token was not rotated after use")
+	}
+	token = string(readFile(t, confirm.(File)))
+	if err := write(token + " extra"); !errors.Is(err, os.ErrInvalid) || exec.purges != 1 {
+		t.Errorf("token with argument: error = %v, purges = %d", err, exec.purges)
+	}
+}
+
+// cancelingExecutor is a mockExecutor that records cancelled query IDs.
+type cancelingExecutor struct {
+	*mockExecutor
+	canceled []string
+}
+
+func (c *cancelingExecutor) CancelQuery(id string) error {
+	c.canceled = append(c.canceled, id)
+	return nil
+}
+
+func (c *cancelingExecutor) Inflight() query.InflightStatus {
+	return query.InflightStatus{Running: []query.InflightQuery{{ID: "abc", APL: "['logs']"}}}
+}
+
+func TestAdminCancelQuery(t *testing.T) {
+	ctx := context.Background()
+	exec := &cancelingExecutor{mockExecutor: &mockExecutor{}}
+	cfg := config.Default()
+	cfg.CacheDir = t.TempDir()
+	cfg.EnableAdminFiles = true
+	root := NewRoot(cfg, &mockClient{}, exec)
+
+	status, _ := root.Lookup(ctx, "_status")
+	inflight, err := status.(Dir).Lookup(ctx, "inflight.json")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := string(readFile(t, inflight.(File))); !strings.Contains(got, `"id": "abc"`) {
+		t.Errorf("inflight.json = %s", got)
+	}
+
+	admin, _ := root.Lookup(ctx, AdminDir)
+	cancel, err := admin.(Dir).Lookup(ctx, "query.cancel")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if help := string(readFile(t, cancel.(File))); !strings.Contains(help, "<id>") {
+		t.Errorf("help = %q", help)
+	}
+	confirm, _ := admin.(Dir).Lookup(ctx, "query.cancel.confirm")
+	write := func(data string) error {
+		w, err := cancel.(Writable).Create(ctx)
+		if err != nil {
+			t.Fatal(err)
 		}
-	})
+		_, _ = w.Write([]byte(data))
+		return w.Close()
+	}
+	token := strings.TrimSpace(string(readFile(t, confirm.(File))))
+	if err := write(token + "\n"); !errors.Is(err, os.ErrInvalid) {
+		t.Errorf("cancel without id: error = %v", err)
+	}
+	token = strings.TrimSpace(string(readFile(t, confirm.(File))))
+	if err := write(token + " abc\n"); err != nil || !slices.Equal(exec.canceled, []string{"abc"}) {
+		t.Errorf("cancel: error = %v, canceled = %v", err, exec.canceled)
+	}
 }
 
-func TestSchemaCSV(t *testing.T) {
-	cases := []struct {
-		name   string
-		result *axiomclient.QueryResult
-		want   []string
-	}{
-		{
-			name:   "empty",
-			result: &axiomclient.QueryResult{},
-			want:   nil,
-		},
-		{
-			name: "simple fields",
-			result: &axiomclient.QueryResult{
-				Tables: []axiomclient.QueryTable{{
-					Fields: []axiomclient.QueryField{
-						{Name: "service", Type: "string"},
-						{Name: "count_", Type: "int64"},
-					},
-				}},
-			},
-			want: []string{"service,string", "count_,int64"},
-		},
-		{
-			name: "with aggregation",
-			result: &axiomclient.QueryResult{
-				Tables: []axiomclient.QueryTable{{
-					Fields: []axiomclient.QueryField{
-						{
-							Name: "total",
-							Type: "int64",
-							Aggregation: &axiomclient.Aggregation{
-								Op:     "sum",
-								Fields: []string{"amount"},
-							},
-						},
-					},
-				}},
-			},
-			want: []string{"total,int64,sum(amount)"},
-		},
+// memorySink is an export sink keeping uploads in memory.
+type memorySink struct {
+	mu      sync.Mutex
+	uploads map[string]string
+}
+
+func (m *memorySink) Upload(ctx context.Context, dest *url.URL, r io.Reader, size int64) error {
+	data, err := io.ReadAll(r)
+	if err != nil {
+		return err
+	}
+	if int64(len(data)) != size {
+		return fmt.Errorf("read %d bytes, size %d", len(data), size)
 	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.uploads[dest.String()] = string(data)
+	return nil
+}
 
-	for _, tc := range cases {
-		t.Run(tc.name, func(t *testing.T) {
-			data, err := schemaCSV(tc.result)
-			if err != nil {
-				t.Fatal(err)
-			}
-			for _, w := range tc.want {
-				if !strings.Contains(string(data), w) {
-					t.Errorf("missing %q in:\n%s", w, data)
-				}
-			}
-		})
+func TestExportDest(t *testing.T) {
+	root, exec := newTestRoot(t, nil, []byte("a,b\n1,2\n"))
+	sink := &memorySink{uploads: map[string]string{}}
+	root.fsys.Exports = export.Sinks{"s3": sink}
+	ctx := context.Background()
+	root.Store().Set("errors", []byte("['logs'] | where status >= 500"))
+	queries, _ := root.Lookup(ctx, "_queries")
+	entry, _ := queries.(Dir).Lookup(ctx, "errors")
+	dest, _ := entry.(Dir).Lookup(ctx, "export.dest")
+	status, _ := entry.(Dir).Lookup(ctx, "export.status")
+	write := func(data string) error {
+		w, err := dest.(Writable).Create(ctx)
+		if err != nil {
+			t.Fatal(err)
+		}
+		_, _ = w.Write([]byte(data))
+		return w.Close()
+	}
+
+	if err := write("gs://bucket/errors.csv\n"); !errors.Is(err, os.ErrInvalid) {
+		t.Errorf("unconfigured scheme error = %v", err)
+	}
+	if _, err := status.(File).Open(ctx, os.O_RDONLY); !errors.Is(err, os.ErrNotExist) {
+		t.Errorf("export.status before any export = %v", err)
+	}
+	if err := write("s3://bucket/daily/errors.csv\n"); err != nil {
+		t.Fatal(err)
+	}
+	var state exportState
+	deadline := time.Now().Add(5 * time.Second)
+	for state.FinishedAt == nil && time.Now().Before(deadline) {
+		time.Sleep(5 * time.Millisecond)
+		_ = json.Unmarshal(readFile(t, status.(File)), &state)
+	}
+	if state.State != exportDone || state.Bytes != 8 || state.Total != 8 || state.Format != "csv" {
+		t.Fatalf("export.status = %+v", state)
+	}
+	if got := sink.uploads["s3://bucket/daily/errors.csv"]; got != "a,b\n1,2\n" {
+		t.Errorf("uploaded %q", got)
+	}
+	if format := exec.formatLog[len(exec.formatLog)-1]; format != "csv" {
+		t.Errorf("exported as %s", format)
+	}
+	if got := string(readFile(t, dest.(File))); got != "s3://bucket/daily/errors.csv\n" {
+		t.Errorf("export.dest = %q", got)
 	}
 }
 
-func TestBytesFile(t *testing.T) {
-	content := []byte("hello world")
-	f := newBytesFile(content)
+// batchExecutor fails queries mentioning "fail" and is safe for the
+// concurrent queries of a batch.
+type batchExecutor struct {
+	*mockExecutor
+	mu      sync.Mutex
+	formats map[string]string
+}
 
-	t.Run("Read", func(t *testing.T) {
-		buf := make([]byte, 5)
-		n, _ := f.Read(buf)
-		if string(buf[:n]) != "hello" {
-			t.Errorf("got %q", buf[:n])
-		}
-	})
+func (b *batchExecutor) ExecuteAPLResult(ctx context.Context, apl, format string, opts query.ExecOptions) (query.ResultData, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.formats[apl] = format
+	if strings.Contains(apl, "fail") {
+		return query.ResultData{}, errors.New("query failed")
+	}
+	data := []byte(apl + "\n")
+	return query.ResultData{Bytes: data, Size: int64(len(data)), Meta: query.ResultMeta{Rows: 1}}, nil
+}
 
-	t.Run("ReadAt", func(t *testing.T) {
-		buf := make([]byte, 5)
-		n, _ := f.ReadAt(buf, 6)
-		if string(buf[:n]) != "world" {
-			t.Errorf("got %q", buf[:n])
+func TestBatch(t *testing.T) {
+	cfg := config.Default()
+	cfg.CacheDir = t.TempDir()
+	exec := &batchExecutor{mockExecutor: &mockExecutor{}, formats: map[string]string{}}
+	root := NewRoot(cfg, &mockClient{}, exec)
+	ctx := context.Background()
+	batches, _ := root.Lookup(ctx, "_batch")
+	entry, err := batches.(Dir).Lookup(ctx, "nightly")
+	if err != nil {
+		t.Fatal(err)
+	}
+	queries, _ := entry.(Dir).Lookup(ctx, "queries.json")
+	write := func(data string) error {
+		w, err := queries.(Writable).Create(ctx)
+		if err != nil {
+			t.Fatal(err)
 		}
-	})
+		_, _ = w.Write([]byte(data))
+		return w.Close()
+	}
 
-	t.Run("Seek", func(t *testing.T) {
-		pos, _ := f.Seek(0, io.SeekStart)
-		if pos != 0 {
-			t.Errorf("pos = %d", pos)
+	for _, bad := range []string{"{}", "[]", `[{"apl": ""}]`, `[{"apl": "['logs']", "format": "pdf"}]`} {
+		if err := write(bad); !errors.Is(err, os.ErrInvalid) {
+			t.Errorf("queries.json %s: error = %v", bad, err)
 		}
-	})
+	}
+	if names := dirNames(t, batches.(Dir)); len(names) != 0 {
+		t.Fatalf("_batch before a valid write = %v", names)
+	}
 
-	t.Run("Write denied", func(t *testing.T) {
-		_, err := f.Write([]byte("x"))
-		if !os.IsPermission(err) {
-			t.Errorf("expected permission error, got %v", err)
+	pack := `[{"apl": "['logs'] | count", "format": "csv"}, {"apl": "['logs'] | fail"}, {"apl": "['logs'] | take 1", "format": "jsonl"}]`
+	if err := write(pack); err != nil {
+		t.Fatal(err)
+	}
+	summaryFile, _ := entry.(Dir).Lookup(ctx, "summary.csv")
+	// The summary is stored once every query finished.
+	deadline := time.Now().Add(5 * time.Second)
+	for _, err := root.Batches().Summary("nightly"); err != nil && time.Now().Before(deadline); _, err = root.Batches().Summary("nightly") {
+		time.Sleep(5 * time.Millisecond)
+	}
+	rows, err := csv.NewReader(bytes.NewReader(readFile(t, summaryFile.(File)))).ReadAll()
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := [][]string{
+		{"0", "0.csv", "csv", batchDone, "1"},
+		{"1", "1.ndjson", "ndjson", batchFailed, "0"},
+		{"2", "2.jsonl", "jsonl", batchDone, "1"},
+	}
+	if len(rows) != 4 || rows[0][0] != "index" {
+		t.Fatalf("summary.csv = %v", rows)
+	}
+	for i, w := range want {
+		if got := rows[i+1][:5]; !reflect.DeepEqual(got, w) {
+			t.Errorf("summary row %d = %v, want %v", i, got, w)
 		}
-	})
+	}
+	if rows[2][7] != "query failed" {
+		t.Errorf("failed entry error = %q", rows[2][7])
+	}
+	if exec.formats["['logs'] | take 1"] != "ndjson" {
+		t.Errorf("jsonl entry ran as %q", exec.formats["['logs'] | take 1"])
+	}
 
-	t.Run("Truncate denied", func(t *testing.T) {
-		err := f.Truncate(0)
-		if !os.IsPermission(err) {
-			t.Errorf("expected permission error, got %v", err)
-		}
-	})
-}
+	results, _ := entry.(Dir).Lookup(ctx, "results")
+	if names := dirNames(t, results.(Dir)); !reflect.DeepEqual(names, []string{"0.csv", "2.jsonl"}) {
+		t.Errorf("results/ = %v", names)
+	}
+	result, err := results.(Dir).Lookup(ctx, "0.csv")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := string(readFile(t, result.(File))); got != "['logs'] | count\n" {
+		t.Errorf("0.csv = %q", got)
+	}
+	if got := string(readFile(t, queries.(File))); got != pack {
+		t.Errorf("queries.json = %q", got)
+	}
+	if names := dirNames(t, batches.(Dir)); !reflect.DeepEqual(names, []string{"nightly"}) {
+		t.Errorf("_batch = %v", names)
+	}
 
-func TestAPLFile(t *testing.T) {
-	root, _ := newTestRoot(t, nil, nil)
-	_ = context.Background()
-	store := root.Store()
+	// A restart serves the stored summary.
+	restarted := NewRoot(cfg, &mockClient{}, exec)
+	if got, _ := restarted.Batches().Summary("nightly"); !bytes.Equal(got, readFile(t, summaryFile.(File))) {
+		t.Errorf("stored summary = %q", got)
+	}
+}
 
-	t.Run("write and read", func(t *testing.T) {
-		f := newAPLFile(store, "test1")
-		f.Write([]byte("['ds'] | take 10"))
-		f.Close()
+func TestBareResult(t *testing.T) {
+	root, exec := newTestRoot(t, []axiomclient.Dataset{{Name: "logs"}, {Name: "audit"}}, []byte("data"))
+	root.fsys.Config.DefaultFormat = "csv"
+	root.fsys.Config.DatasetDefaults = map[string]config.DatasetDefaults{"audit": {DefaultFormat: "json"}}
+	root.fsys.Store.Set("errors", []byte("['logs']"))
+	ctx := context.Background()
 
-		data := store.Get("test1")
-		if string(data) != "['ds'] | take 10" {
-			t.Errorf("got %q", data)
+	lookup := func(path ...string) Node {
+		t.Helper()
+		var node Node = root
+		for _, name := range path {
+			var err error
+			if node, err = node.(Dir).Lookup(ctx, name); err != nil {
+				t.Fatalf("%v: %v", path, err)
+			}
 		}
-	})
-
-	t.Run("truncate clears", func(t *testing.T) {
-		store.Set("test2", []byte("old content"))
-		f := newAPLFile(store, "test2")
-		f.Truncate(0)
-		f.Close()
-
-		data := store.Get("test2")
-		if len(data) != 0 {
-			t.Errorf("expected empty, got %q", data)
+		return node
+	}
+	for _, tc := range []struct {
+		path   []string
+		format string
+	}{
+		{[]string{"logs", "q", "result"}, "csv"},
+		{[]string{"logs", "q", "format", "tsv", "result"}, "tsv"},
+		{[]string{"audit", "q", "result"}, "json"},
+		{[]string{"_queries", "errors", "result"}, "csv"},
+	} {
+		node := lookup(tc.path...)
+		info, err := node.(File).Stat(ctx)
+		if err != nil {
+			t.Fatal(err)
 		}
-	})
+		if info.Name() != "result" {
+			t.Errorf("%v: Stat name = %q", tc.path, info.Name())
+		}
+		_ = readFile(t, node.(File))
+		if exec.lastFormat() != tc.format {
+			t.Errorf("%v: format = %q, want %q", tc.path, exec.lastFormat(), tc.format)
+		}
+	}
+
+	// An argument named result is still a directory.
+	if _, ok := lookup("logs", "q", "project", "result").(Dir); !ok {
+		t.Error("project/result is not a directory")
+	}
 }
 
-func TestIsValidQueryName(t *testing.T) {
-	cases := []struct {
-		name  string
-		valid bool
-	}{
-		{"simple", true},
-		{"with-dash", true},
-		{"with_underscore", true},
-		{"with.dot", true},
-		{"123numeric", true},
-		{"", false},
-		{"../escape", false},
-		{"path/sep", false},
-		{strings.Repeat("x", 100), true}, // long names OK in vfs, store may reject
+func TestQueryPathStatMode(t *testing.T) {
+	root, exec := newTestRoot(t, []axiomclient.Dataset{{Name: "logs"}}, []byte("exact"))
+	ctx := context.Background()
+	node := &QueryPathResultFile{root: root, dataset: "logs", segments: []string{"result.csv"}}
+
+	info, err := node.Stat(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if info.Size() != int64(len("exact")) || len(exec.estimateLog) != 0 {
+		t.Errorf("exact mode: size = %d, estimates = %v", info.Size(), exec.estimateLog)
 	}
 
-	for _, tc := range cases {
-		t.Run(tc.name, func(t *testing.T) {
-			got := isValidQueryName(tc.name)
-			if got != tc.valid {
-				t.Errorf("isValidQueryName(%q) = %v, want %v", tc.name, got, tc.valid)
-			}
-		})
+	root.fsys.Config.StatMode = config.StatModeEstimate
+	executed := len(exec.aplLog)
+	info, err = node.Stat(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if info.Size() != 1234 || info.Name() != "result.csv" {
+		t.Errorf("estimate mode: %s size = %d", info.Name(), info.Size())
+	}
+	if len(exec.aplLog) != executed || len(exec.estimateLog) != 1 {
+		t.Errorf("estimate mode should not execute: %v", exec.aplLog[executed:])
 	}
 }
 
@@ -883,7 +3593,7 @@ func TestQueryEntryDir(t *testing.T) {
 		for _, e := range entries {
 			names[e.Name()] = true
 		}
-		for _, want := range []string{"apl", "result.ndjson", "result.csv", "schema.csv", "stats.json"} {
+		for _, want := range []string{"apl", "apl.fmt", "lint.json", "result.ndjson", "result.csv", "schema.csv", "stats.json"} {
 			if !names[want] {
 				t.Errorf("missing %s", want)
 			}
@@ -927,6 +3637,51 @@ func TestDatasetPresetsDir(t *testing.T) {
 	})
 }
 
+func TestSizePresets(t *testing.T) {
+	root, exec := newTestRoot(t, []axiomclient.Dataset{{Name: "logs"}}, []byte("service,count_\napi,3\n"))
+	ctx := context.Background()
+	dataset, _ := root.Lookup(ctx, "logs")
+	presetsDir, _ := dataset.(Dir).Lookup(ctx, "presets")
+	size := func(name string) int64 {
+		t.Helper()
+		node, err := presetsDir.(Dir).Lookup(ctx, name)
+		if err != nil {
+			t.Fatal(err)
+		}
+		info, err := node.Stat(ctx)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return info.Size()
+	}
+
+	root.SizePresets(ctx, 0)
+	if len(exec.aplLog) != 0 || size("errors.csv") != 0 {
+		t.Fatalf("sizing off ran %d queries", len(exec.aplLog))
+	}
+
+	root.SizePresets(ctx, time.Millisecond)
+	if got := size("errors.csv"); got != int64(len(exec.data)) {
+		t.Errorf("errors.csv size = %d, want %d", got, len(exec.data))
+	}
+	// slow-requests lists raw events, which may be many: it waits for a read.
+	if got := size("slow-requests.csv"); got != 0 {
+		t.Errorf("slow-requests.csv size = %d, want 0", got)
+	}
+	for _, apl := range exec.aplLog {
+		if strings.Contains(apl, "duration > 1s") {
+			t.Errorf("sized slow-requests: %s", apl)
+		}
+	}
+
+	// Presets already sized are not run again.
+	ran := len(exec.aplLog)
+	root.SizePresets(ctx, time.Millisecond)
+	if len(exec.aplLog) != ran {
+		t.Errorf("second pass ran %d more queries", len(exec.aplLog)-ran)
+	}
+}
+
 func TestVirtualFileInfo(t *testing.T) {
 	info := DirInfo("test")
 	if info.Name() != "test" {
@@ -999,11 +3754,13 @@ func TestTempFile(t *testing.T) {
 		}
 	})
 
-	t.Run("Close removes file", func(t *testing.T) {
-		name := tf.Name()
+	// Removing the file is left to the executor, once every handle on
+	// it is closed.
+	t.Run("Close closes handle", func(t *testing.T) {
+		defer os.Remove(tf.Name())
 		tf.Close()
-		if _, err := os.Stat(name); !os.IsNotExist(err) {
-			t.Error("file should be removed")
+		if _, err := tf.ReadAt(make([]byte, 1), 0); err == nil {
+			t.Error("read after Close succeeded")
 		}
 	})
 }
@@ -1022,3 +3779,209 @@ func TestOpenResult(t *testing.T) {
 		}
 	})
 }
+
+// downClient fails ListDatasets once down is set.
+type downClient struct {
+	mockClient
+	down atomic.Bool
+}
+
+func (d *downClient) ListDatasets(ctx context.Context) ([]axiomclient.Dataset, error) {
+	if d.down.Load() {
+		return nil, errors.New("axiom unavailable")
+	}
+	return d.mockClient.ListDatasets(ctx)
+}
+
+func TestStaleDatasetList(t *testing.T) {
+	cfg := config.Default()
+	cfg.CacheDir = t.TempDir()
+	cfg.MetadataTTL = 10 * time.Millisecond
+	cfg.MetadataMaxStale = 200 * time.Millisecond
+	client := &downClient{mockClient: mockClient{datasets: []axiomclient.Dataset{{Name: "logs"}}}}
+	root := NewRoot(cfg, client, &mockExecutor{})
+	ctx := context.Background()
+
+	if names := dirNames(t, root); slices.Contains(names, ".stale") {
+		t.Fatalf("fresh listing has .stale: %v", names)
+	}
+	if _, err := root.Lookup(ctx, ".stale"); !errors.Is(err, os.ErrNotExist) {
+		t.Fatalf("Lookup .stale when fresh: %v", err)
+	}
+
+	client.down.Store(true)
+	time.Sleep(20 * time.Millisecond)
+	names := dirNames(t, root)
+	if !slices.Contains(names, "logs") || !slices.Contains(names, ".stale") {
+		t.Fatalf("stale listing = %v, want logs and .stale", names)
+	}
+
+	var status StaleStatus
+	deadline := time.Now().Add(time.Second)
+	for status.LastError == "" && time.Now().Before(deadline) {
+		node, err := root.Lookup(ctx, ".stale")
+		if err != nil {
+			t.Fatalf("Lookup .stale: %v", err)
+		}
+		if err := json.Unmarshal(readFile(t, node.(File)), &status); err != nil {
+			t.Fatal(err)
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+	if status.LastError != "axiom unavailable" || status.MaxStale != "200ms" || status.AgeSeconds <= 0 {
+		t.Errorf(".stale = %+v", status)
+	}
+
+	time.Sleep(250 * time.Millisecond)
+	if _, err := root.ReadDir(ctx); err == nil {
+		t.Error("ReadDir past max staleness should fail")
+	}
+
+	client.down.Store(false)
+	if names := dirNames(t, root); slices.Contains(names, ".stale") {
+		t.Errorf("listing after recovery has .stale: %v", names)
+	}
+}
+
+func TestMetadataWarmStart(t *testing.T) {
+	ctx := context.Background()
+	cfg := config.Default()
+	cfg.CacheDir = t.TempDir()
+	_ = os.MkdirAll(filepath.Join(cfg.CacheDir, "fields"), 0o755)
+	old := time.Now().Add(-48 * time.Hour)
+	for path, data := range map[string]string{
+		"datasets.json":    `[{"name":"logs"}]`,
+		"fields/logs.json": `[{"name":"saved","type":"string"}]`,
+	} {
+		path = filepath.Join(cfg.CacheDir, path)
+		if err := os.WriteFile(path, []byte(data), 0o644); err != nil {
+			t.Fatal(err)
+		}
+		_ = os.Chtimes(path, old, old)
+	}
+	client := &downClient{mockClient: mockClient{
+		datasets: []axiomclient.Dataset{{Name: "logs"}, {Name: "web"}},
+		fields:   map[string][]axiomclient.Field{"logs": {{Name: "live", Type: "string"}}},
+	}}
+	client.down.Store(true)
+
+	if _, err := NewRoot(cfg, client, &mockExecutor{}).ReadDir(ctx); err == nil {
+		t.Fatal("listing past max staleness without warm start should fail")
+	}
+
+	cfg.MetadataWarmStart = true
+	root := NewRoot(cfg, client, &mockExecutor{})
+	names := dirNames(t, root)
+	if !slices.Contains(names, "logs") || !slices.Contains(names, ".stale") {
+		t.Fatalf("warm listing = %v, want the saved list and .stale", names)
+	}
+	fields, err := root.fields().List(ctx, client, "logs")
+	if err != nil || len(fields) != 1 || fields[0].Name != "saved" {
+		t.Fatalf("warm fields = %v, %v", fields, err)
+	}
+
+	client.down.Store(false)
+	deadline := time.Now().Add(time.Second)
+	for {
+		names = dirNames(t, root)
+		fields, _ = root.fields().List(ctx, client, "logs")
+		if slices.Contains(names, "web") && len(fields) == 1 && fields[0].Name == "live" {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("not revalidated: datasets %v, fields %v", names, fields)
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+	if slices.Contains(names, ".stale") {
+		t.Errorf("revalidated listing has .stale: %v", names)
+	}
+}
+
+// headExecutor is a mockExecutor whose probes return head, complete or not.
+type headExecutor struct {
+	*mockExecutor
+	head     []byte
+	complete bool
+	probes   []int
+}
+
+func (h *headExecutor) ExecuteAPLHead(ctx context.Context, apl, format string, rows int, opts query.ExecOptions) (query.ResultData, bool, error) {
+	h.probes = append(h.probes, rows)
+	return query.ResultData{Bytes: h.head, Size: int64(len(h.head))}, h.complete, nil
+}
+
+func TestReadProbe(t *testing.T) {
+	ctx := context.Background()
+	full := []byte("n\n1\n2\n3\n4\n")
+	exec := &headExecutor{mockExecutor: &mockExecutor{data: full}, head: full[:6]}
+	cfg := config.Default()
+	cfg.CacheDir = t.TempDir()
+	cfg.ReadProbeRows = 2
+	root := NewRoot(cfg, &mockClient{datasets: []axiomclient.Dataset{{Name: "logs"}}}, exec)
+	open := func(segments ...string) interface {
+		io.ReadCloser
+		io.ReaderAt
+	} {
+		t.Helper()
+		var node Node = root
+		for _, name := range append([]string{"logs", "q"}, segments...) {
+			next, err := node.(Dir).Lookup(ctx, name)
+			if err != nil {
+				t.Fatalf("Lookup(%s): %v", name, err)
+			}
+			node = next
+		}
+		f, err := node.(File).Open(ctx, os.O_RDONLY)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return f
+	}
+
+	// Reads within the probe never run the full query.
+	f := open("result.csv")
+	buf := make([]byte, 4)
+	if n, err := f.ReadAt(buf, 2); err != nil || string(buf[:n]) != "1\n2\n" {
+		t.Fatalf("ReadAt within probe = %q, %v", buf[:n], err)
+	}
+	f.Close()
+	if len(exec.aplLog) != 0 || !slices.Equal(exec.probes, []int{2}) {
+		t.Fatalf("probe read ran %v, probes %v", exec.aplLog, exec.probes)
+	}
+
+	// Reading past it upgrades to the full query.
+	f = open("result.csv")
+	if data, err := io.ReadAll(f); err != nil || string(data) != string(full) {
+		t.Fatalf("ReadAll = %q, %v", data, err)
+	}
+	f.Close()
+	if len(exec.aplLog) != 1 {
+		t.Errorf("full query ran %d times", len(exec.aplLog))
+	}
+
+	// A complete probe is the whole result.
+	exec.aplLog, exec.complete = nil, true
+	f = open("result.csv")
+	if data, err := io.ReadAll(f); err != nil || string(data) != string(full[:6]) || len(exec.aplLog) != 0 {
+		t.Errorf("complete probe: %q, %v, ran %v", data, err, exec.aplLog)
+	}
+	f.Close()
+
+	// A full result that does not start with the probe fails with ESTALE.
+	exec.complete, exec.head = false, []byte("n\n9\n8\n")
+	f = open("result.csv")
+	if _, err := io.ReadAll(f); !errors.Is(err, syscall.ESTALE) {
+		t.Errorf("read past a probe the full result does not start with: %v, want ESTALE", err)
+	}
+	f.Close()
+
+	// Formats and segments whose head is not a prefix skip the probe.
+	exec.probes = nil
+	for _, segments := range [][]string{{"result.json"}, {"sort", "n:asc:natural", "result.csv"}} {
+		open(segments...).Close()
+	}
+	if len(exec.probes) != 0 {
+		t.Errorf("probed %v", exec.probes)
+	}
+}
@@ -0,0 +1,63 @@
+package vfs
+
+import (
+	"context"
+	"errors"
+	"io/fs"
+	"os"
+	"path"
+	"sort"
+)
+
+// WalkFunc is called by Walk for each node it visits, with the node's path
+// below the walk's start ("/" for the start itself). err is the failure to
+// look the node up, stat it or list it, in which case node or info may be
+// nil. Returning fs.SkipDir from a directory skips its entries, and
+// fs.SkipAll stops the walk; other errors stop it and are returned.
+type WalkFunc func(name string, node Node, info os.FileInfo, err error) error
+
+// Walk visits start and, depth first, every node its directories list,
+// in name order, the way a client walking the mount would. Nodes that are
+// only reached by Lookup, such as q/ query paths, are not visited.
+func Walk(ctx context.Context, start Node, fn WalkFunc) error {
+	err := walk(ctx, "/", start, fn)
+	if errors.Is(err, fs.SkipDir) || errors.Is(err, fs.SkipAll) {
+		return nil
+	}
+	return err
+}
+
+func walk(ctx context.Context, name string, node Node, fn WalkFunc) error {
+	info, err := node.Stat(ctx)
+	if err != nil {
+		return fn(name, node, nil, err)
+	}
+	dir, ok := node.(Dir)
+	if err := fn(name, node, info, nil); err != nil || !ok {
+		return err
+	}
+	entries, err := dir.ReadDir(ctx)
+	if err != nil {
+		if err := fn(name, node, info, err); !errors.Is(err, fs.SkipDir) {
+			return err
+		}
+		return nil
+	}
+	sort.Slice(entries, func(i, j int) bool { return entries[i].Name() < entries[j].Name() })
+	for _, entry := range entries {
+		child := path.Join(name, entry.Name())
+		next, err := dir.Lookup(ctx, entry.Name())
+		if err != nil {
+			err = fn(child, nil, nil, err)
+		} else {
+			err = walk(ctx, child, next, fn)
+		}
+		if errors.Is(err, fs.SkipDir) {
+			continue
+		}
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}